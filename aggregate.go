@@ -0,0 +1,454 @@
+package forwarder
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+)
+
+// dimensionWildcard is the dimension value that matches any value.
+// It is available only with Aggregate.
+const dimensionWildcard = "*"
+
+// the aggregations of the dimensions.
+const (
+	aggregateSum = "sum"
+	aggregateAvg = "avg"
+	aggregateMax = "max"
+	aggregateMin = "min"
+)
+
+// aggregateFunctions is the metric math functions of the aggregations.
+var aggregateFunctions = map[string]string{
+	aggregateSum: "SUM",
+	aggregateAvg: "AVG",
+	aggregateMax: "MAX",
+	aggregateMin: "MIN",
+}
+
+// recentlyActive is the period of the metrics that ListMetrics returns.
+// The metrics that have no data points in it are not aggregated.
+const recentlyActive = types.RecentlyActivePt3h
+
+type cloudwatchlistiface interface {
+	cloudwatch.ListMetricsAPIClient
+}
+
+// defaultRankBy is the default statistic for ranking the metrics of TopN.
+const defaultRankBy = "Average"
+
+// rankStatistics is the statistics available for ranking the metrics of TopN.
+var rankStatistics = map[string]bool{
+	"Average":     true,
+	"Maximum":     true,
+	"Minimum":     true,
+	"Sum":         true,
+	"SampleCount": true,
+}
+
+// otherMetricSuffix is the suffix of the metric that aggregates the metrics out of TopN.
+const otherMetricSuffix = "other"
+
+// hasWildcard reports whether the metric of the query has the wildcard dimension values.
+func (q *Query) hasWildcard() bool {
+	for j := 3; j < len(q.Metric); j += 2 {
+		if interfaceToString(q.Metric[j]) == dimensionWildcard {
+			return true
+		}
+	}
+	return false
+}
+
+// validateAggregate validates the aggregation of the query.
+func (q *Query) validateAggregate(dimensions []types.Dimension) error {
+	wildcard := hasWildcard(dimensions)
+	if q.TopN < 0 {
+		return fmt.Errorf("topN must be positive: %d", q.TopN)
+	}
+	if q.By != "" {
+		if q.TopN == 0 {
+			return errors.New("by is available only with topN")
+		}
+		if !rankStatistics[q.By] {
+			return fmt.Errorf("unknown statistic of by: %q", q.By)
+		}
+	}
+	if q.Aggregate == "" && q.TopN == 0 {
+		if wildcard && !hasNameTemplate(q.Name) {
+			return errors.New("the wildcard dimension value requires aggregate, topN, or the name template")
+		}
+		if !wildcard {
+			return nil
+		}
+	}
+	if _, ok := aggregateFunctions[q.Aggregate]; q.Aggregate != "" && !ok {
+		return fmt.Errorf("unknown aggregate: %q", q.Aggregate)
+	}
+	if q.Type != "" && q.Type != queryTypeMetric {
+		return fmt.Errorf("the wildcard expansion is available only for metric type queries, but the type is %q", q.Type)
+	}
+	if q.Expression != "" {
+		return errors.New("the wildcard expansion is not available with expressions")
+	}
+	if q.API == apiStatistics {
+		return errors.New("the wildcard expansion is not available with the statistics api")
+	}
+	if !wildcard {
+		return errors.New("aggregate and topN require at least one wildcard dimension value")
+	}
+	return nil
+}
+
+func hasWildcard(dimensions []types.Dimension) bool {
+	for _, d := range dimensions {
+		if aws.ToString(d.Value) == dimensionWildcard {
+			return true
+		}
+	}
+	return false
+}
+
+// expandAggregates expands the queries with the wildcard dimension values
+// into the metrics found by ListMetrics, and aggregates them by metric math
+// or forwards them individually with the names from the name template.
+// The queries that fail to expand are skipped with warnings.
+func (fctx *forwardContext) expandAggregates(ctx context.Context, queries []*metricQuery) []*metricQuery {
+	var cnt int
+	for _, q := range queries {
+		if q.usesGetMetricData() {
+			cnt++
+		}
+	}
+
+	ret := make([]*metricQuery, 0, len(queries))
+	for _, q := range queries {
+		if !hasWildcard(q.Dimensions) {
+			ret = append(ret, q)
+			continue
+		}
+		ctx := withQueryIndex(ctx, q.Index)
+		metrics, err := fctx.listMetrics(ctx, q)
+		if err != nil {
+			fctx.forwarder.logger().WarnContext(ctx, "failed to list the metrics to aggregate, skips",
+				"error", err.Error(),
+			)
+			continue
+		}
+		if len(metrics) == 0 {
+			fctx.forwarder.logger().WarnContext(ctx, "no metrics match the wildcard dimensions, skips")
+			continue
+		}
+		if cnt+len(metrics) > maxMetricDataQueries {
+			fctx.forwarder.logger().WarnContext(ctx, "too many metrics to aggregate, skips",
+				"count", len(metrics),
+				"limit", maxMetricDataQueries,
+			)
+			continue
+		}
+		cnt += len(metrics)
+		if q.Query.TopN == 0 {
+			if q.Query.Aggregate == "" {
+				ret = append(ret, q.each(metrics)...)
+			} else {
+				ret = append(ret, q.aggregate(metrics)...)
+			}
+			continue
+		}
+		if len(metrics) > q.Query.TopN {
+			metrics, err = fctx.rankMetrics(ctx, q, metrics)
+			if err != nil {
+				fctx.forwarder.logger().WarnContext(ctx, "failed to rank the metrics, skips",
+					"error", err.Error(),
+				)
+				continue
+			}
+		}
+		ret = append(ret, q.topN(metrics)...)
+	}
+	return ret
+}
+
+// listMetrics returns the metrics that match the dimensions of the query, including the wildcards.
+// The metrics are cached for the discovery cache ttl.
+func (fctx *forwardContext) listMetrics(ctx context.Context, q *metricQuery) ([]types.Metric, error) {
+	f := fctx.forwarder
+	key := q.Namespace + ":" + q.MetricName + ":" + dimensionsKey(q.Dimensions)
+	now := f.now()
+
+	f.muDiscovery.Lock()
+	defer f.muDiscovery.Unlock()
+	f.loadDiscoveryCache(ctx)
+	if c, ok := f.discoveryCache.Metrics[key]; ok && f.fresh(c.CachedAt, now) {
+		ret := make([]types.Metric, 0, len(c.Dimensions))
+		for _, dims := range c.Dimensions {
+			ret = append(ret, types.Metric{
+				Namespace:  aws.String(q.Namespace),
+				MetricName: aws.String(q.MetricName),
+				Dimensions: dimensionsFromMap(dims),
+			})
+		}
+		return ret, nil
+	}
+
+	metrics, err := fctx.listMetricsNoCache(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	c := &DiscoveredMetrics{
+		Dimensions: make([]map[string]string, 0, len(metrics)),
+		CachedAt:   now.Unix(),
+	}
+	for _, m := range metrics {
+		dims := make(map[string]string, len(m.Dimensions))
+		for _, d := range m.Dimensions {
+			dims[aws.ToString(d.Name)] = aws.ToString(d.Value)
+		}
+		c.Dimensions = append(c.Dimensions, dims)
+	}
+	if f.discoveryCache.Metrics == nil {
+		f.discoveryCache.Metrics = make(map[string]*DiscoveredMetrics)
+	}
+	f.discoveryCache.Metrics[key] = c
+	return metrics, nil
+}
+
+// dimensionsFromMap converts the map of the dimensions into the dimensions sorted by the names.
+func dimensionsFromMap(m map[string]string) []types.Dimension {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	ret := make([]types.Dimension, 0, len(names))
+	for _, name := range names {
+		ret = append(ret, types.Dimension{Name: aws.String(name), Value: aws.String(m[name])})
+	}
+	return ret
+}
+
+// listMetricsNoCache calls ListMetrics.
+// The metrics with extra dimensions are excluded, because they are different metrics on CloudWatch.
+func (fctx *forwardContext) listMetricsNoCache(ctx context.Context, q *metricQuery) ([]types.Metric, error) {
+	svc, ok := fctx.forwarder.cloudwatch().(cloudwatchlistiface)
+	if !ok {
+		return nil, errors.New("forwarder: the CloudWatch client doesn't support ListMetrics")
+	}
+
+	filters := make([]types.DimensionFilter, 0, len(q.Dimensions))
+	for _, d := range q.Dimensions {
+		filter := types.DimensionFilter{Name: d.Name}
+		if aws.ToString(d.Value) != dimensionWildcard {
+			filter.Value = d.Value
+		}
+		filters = append(filters, filter)
+	}
+	paginator := cloudwatch.NewListMetricsPaginator(svc, &cloudwatch.ListMetricsInput{
+		Namespace:      aws.String(q.Namespace),
+		MetricName:     aws.String(q.MetricName),
+		Dimensions:     filters,
+		RecentlyActive: recentlyActive,
+	})
+
+	var ret []types.Metric
+	for paginator.HasMorePages() {
+		page, err := withThrottle(ctx, &fctx.throttle, func() (*cloudwatch.ListMetricsOutput, error) {
+			return paginator.NextPage(ctx)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("forwarder: failed to list the metrics: %w", err)
+		}
+		for _, m := range page.Metrics {
+			if len(m.Dimensions) == len(q.Dimensions) {
+				ret = append(ret, m)
+			}
+		}
+	}
+
+	// sort the metrics so that the ids of the sub-queries are stable.
+	slices.SortFunc(ret, func(a, b types.Metric) int {
+		return strings.Compare(dimensionsKey(a.Dimensions), dimensionsKey(b.Dimensions))
+	})
+	return ret, nil
+}
+
+func dimensionsKey(dimensions []types.Dimension) string {
+	pairs := make([]string, 0, len(dimensions))
+	for _, d := range dimensions {
+		pairs = append(pairs, aws.ToString(d.Name)+"="+aws.ToString(d.Value))
+	}
+	slices.Sort(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// rankMetrics sorts the metrics in descending order of the statistic By in the time window of the query.
+// The metrics without data points are ranked last.
+func (fctx *forwardContext) rankMetrics(ctx context.Context, q *metricQuery, metrics []types.Metric) ([]types.Metric, error) {
+	by := cmp.Or(q.Query.By, defaultRankBy)
+	start, end := fctx.window(q)
+	period := int32(max(end.Sub(start).Truncate(time.Minute), time.Minute) / time.Second)
+
+	scores := make([]float64, len(metrics))
+	for i := range scores {
+		scores[i] = math.Inf(-1)
+	}
+	svc := fctx.forwarder.cloudwatch()
+	for offset := 0; offset < len(metrics); offset += maxMetricDataQueries {
+		chunk := metrics[offset:min(offset+maxMetricDataQueries, len(metrics))]
+		queries := make([]types.MetricDataQuery, 0, len(chunk))
+		for i := range chunk {
+			queries = append(queries, types.MetricDataQuery{
+				Id: aws.String(fmt.Sprintf("r%d", offset+i)),
+				MetricStat: &types.MetricStat{
+					Metric: &chunk[i],
+					Period: aws.Int32(period),
+					Stat:   aws.String(by),
+				},
+			})
+		}
+		paginator := cloudwatch.NewGetMetricDataPaginator(svc, &cloudwatch.GetMetricDataInput{
+			StartTime:         aws.Time(start),
+			EndTime:           aws.Time(end),
+			MetricDataQueries: queries,
+		})
+		for paginator.HasMorePages() {
+			page, err := withThrottle(ctx, &fctx.throttle, func() (*cloudwatch.GetMetricDataOutput, error) {
+				return paginator.NextPage(ctx)
+			})
+			if err != nil {
+				return nil, fmt.Errorf("forwarder: failed to get the metrics for ranking: %w", err)
+			}
+			for _, result := range page.MetricDataResults {
+				var i int
+				if _, err := fmt.Sscanf(aws.ToString(result.Id), "r%d", &i); err != nil || i < 0 || i >= len(scores) {
+					continue
+				}
+				for _, v := range result.Values {
+					scores[i] = max(scores[i], v)
+				}
+			}
+		}
+	}
+
+	idx := make([]int, len(metrics))
+	for i := range idx {
+		idx[i] = i
+	}
+	slices.SortStableFunc(idx, func(a, b int) int {
+		return cmp.Compare(scores[b], scores[a])
+	})
+	ret := make([]types.Metric, 0, len(metrics))
+	for _, i := range idx {
+		ret = append(ret, metrics[i])
+	}
+	return ret, nil
+}
+
+// topN returns the queries of the top N metrics, and the queries that aggregate the rest.
+// The metrics must be sorted in descending order of the ranking.
+func (q *metricQuery) topN(metrics []types.Metric) []*metricQuery {
+	n := min(q.Query.TopN, len(metrics))
+	ret := q.each(metrics[:n])
+	if n == len(metrics) {
+		return ret
+	}
+
+	other := *q
+	other.ID = q.ID + "_other"
+	other.Label.MetricName = q.Label.MetricName + "." + otherMetricSuffix
+	if hasNameTemplate(q.Label.MetricName) {
+		dimensions := make([]types.Dimension, 0, len(q.Dimensions))
+		for _, d := range q.Dimensions {
+			if aws.ToString(d.Value) == dimensionWildcard {
+				d.Value = aws.String(otherMetricSuffix)
+			}
+			dimensions = append(dimensions, d)
+		}
+		other.Label.MetricName, _ = renderNameTemplate(q.Label.MetricName, dimensions)
+	}
+	aggregate := *q.Query
+	aggregate.Aggregate = cmp.Or(q.Query.Aggregate, aggregateSum)
+	other.Query = &aggregate
+	return append(ret, other.aggregate(metrics[n:])...)
+}
+
+// each returns the queries that forward the metrics individually.
+func (q *metricQuery) each(metrics []types.Metric) []*metricQuery {
+	ret := make([]*metricQuery, 0, len(metrics))
+	for i, m := range metrics {
+		sub := *q.Query
+		sub.Aggregate = ""
+		sub.TopN = 0
+		sub.Label = ""
+		mq := *q
+		mq.Query = &sub
+		mq.ID = fmt.Sprintf("%s_%d", q.ID, i)
+		mq.Label.MetricName = q.seriesName(m.Dimensions)
+		mq.Dimensions = m.Dimensions
+		ret = append(ret, &mq)
+	}
+	return ret
+}
+
+// seriesName returns the metric name of the metric expanded from the wildcard dimension values.
+// It is rendered from the name template if the name has placeholders,
+// otherwise the values of the wildcard dimensions are appended to the name.
+func (q *metricQuery) seriesName(dimensions []types.Dimension) string {
+	if hasNameTemplate(q.Label.MetricName) {
+		// the template is validated in resolveQueries.
+		name, _ := renderNameTemplate(q.Label.MetricName, dimensions)
+		return name
+	}
+	return q.Label.MetricName + "." + q.wildcardSuffix(dimensions)
+}
+
+// wildcardSuffix returns the suffix of the metric name from the values of the wildcard dimensions.
+func (q *metricQuery) wildcardSuffix(dimensions []types.Dimension) string {
+	var values []string
+	for _, w := range q.Dimensions {
+		if aws.ToString(w.Value) != dimensionWildcard {
+			continue
+		}
+		for _, d := range dimensions {
+			if aws.ToString(d.Name) == aws.ToString(w.Name) {
+				values = append(values, strings.ReplaceAll(aws.ToString(d.Value), ".", "_"))
+			}
+		}
+	}
+	return sanitizeMetricName(strings.Join(values, "_"))
+}
+
+// aggregate returns the sub-queries of the metrics that are not forwarded,
+// and the query of the metric math expression that aggregates them.
+func (q *metricQuery) aggregate(metrics []types.Metric) []*metricQuery {
+	ret := make([]*metricQuery, 0, len(metrics)+1)
+	ids := make([]string, 0, len(metrics))
+	for i, m := range metrics {
+		sub := *q.Query
+		sub.Aggregate = ""
+		sub.TopN = 0
+		sub.Label = ""
+		sub.ReturnData = aws.Bool(false)
+		mq := *q
+		mq.Query = &sub
+		mq.ID = fmt.Sprintf("%s_%d", q.ID, i)
+		mq.Dimensions = m.Dimensions
+		ret = append(ret, &mq)
+		ids = append(ids, mq.ID)
+	}
+
+	expr := *q.Query
+	expr.Expression = aggregateFunctions[q.Query.Aggregate] + "([" + strings.Join(ids, ", ") + "])"
+	mq := *q
+	mq.Query = &expr
+	ret = append(ret, &mq)
+	return ret
+}
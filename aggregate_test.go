@@ -0,0 +1,285 @@
+package forwarder
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+	"github.com/google/go-cmp/cmp"
+)
+
+// listingCloudWatch lists the metrics that match the dimension filters,
+// and records the queries of GetMetricData.
+type listingCloudWatch struct {
+	fakeCloudWatch
+	metrics []types.Metric
+	queries []types.MetricDataQuery
+}
+
+func (s *listingCloudWatch) ListMetrics(ctx context.Context, params *cloudwatch.ListMetricsInput, optFns ...func(*cloudwatch.Options)) (*cloudwatch.ListMetricsOutput, error) {
+	var metrics []types.Metric
+	for _, m := range s.metrics {
+		if aws.ToString(m.Namespace) != aws.ToString(params.Namespace) || aws.ToString(m.MetricName) != aws.ToString(params.MetricName) {
+			continue
+		}
+		if matchDimensionFilters(m.Dimensions, params.Dimensions) {
+			metrics = append(metrics, m)
+		}
+	}
+	return &cloudwatch.ListMetricsOutput{Metrics: metrics}, nil
+}
+
+func matchDimensionFilters(dimensions []types.Dimension, filters []types.DimensionFilter) bool {
+	for _, f := range filters {
+		found := false
+		for _, d := range dimensions {
+			if aws.ToString(d.Name) == aws.ToString(f.Name) && (f.Value == nil || aws.ToString(d.Value) == aws.ToString(f.Value)) {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *listingCloudWatch) GetMetricData(ctx context.Context, params *cloudwatch.GetMetricDataInput, optFns ...func(*cloudwatch.Options)) (*cloudwatch.GetMetricDataOutput, error) {
+	s.queries = append(s.queries, params.MetricDataQueries...)
+	return s.fakeCloudWatch.GetMetricData(ctx, params, optFns...)
+}
+
+func kinesisShardMetric(stream, shard string) types.Metric {
+	dimensions := []types.Dimension{
+		{Name: aws.String("StreamName"), Value: aws.String(stream)},
+	}
+	if shard != "" {
+		dimensions = append(dimensions, types.Dimension{Name: aws.String("ShardId"), Value: aws.String(shard)})
+	}
+	return types.Metric{
+		Namespace:  aws.String("AWS/Kinesis"),
+		MetricName: aws.String("IncomingRecords"),
+		Dimensions: dimensions,
+	}
+}
+
+func TestGetMetricsData_Aggregate(t *testing.T) {
+	start := time.Unix(1234567860, 0)
+	svc := &listingCloudWatch{
+		fakeCloudWatch: fakeCloudWatch{
+			values: map[string][]float64{
+				"m1": {30},
+			},
+		},
+		metrics: []types.Metric{
+			kinesisShardMetric("stream", "shardId-000000000001"),
+			kinesisShardMetric("stream", "shardId-000000000000"),
+			kinesisShardMetric("other", "shardId-000000000000"),
+			kinesisShardMetric("stream", ""), // the stream level metric is not aggregated.
+		},
+	}
+	fctx := &forwardContext{
+		forwarder: &Forwarder{svccloudwatch: svc},
+		start:     start,
+		end:       start.Add(time.Minute),
+	}
+	query := []*Query{
+		{
+			Service:   "foo",
+			Name:      "kinesis.records",
+			Metric:    []interface{}{"AWS/Kinesis", "IncomingRecords", "StreamName", "stream", "ShardId", "*"},
+			Stat:      "Sum",
+			Aggregate: "sum",
+		},
+	}
+	if err := fctx.getMetricsData(context.Background(), query); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, q := range svc.queries {
+		if q.Expression != nil {
+			got = append(got, aws.ToString(q.Id)+" = "+aws.ToString(q.Expression))
+			continue
+		}
+		got = append(got, aws.ToString(q.Id)+" = "+dimensionsKey(q.MetricStat.Metric.Dimensions))
+	}
+	want := []string{
+		"m1_0 = ShardId=shardId-000000000000,StreamName=stream",
+		"m1_1 = ShardId=shardId-000000000001,StreamName=stream",
+		"m1 = SUM([m1_0, m1_1])",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("queries mismatch: (-want/+got):\n%s", diff)
+	}
+
+	wantMetrics := serviceMetricsType{
+		"foo": {{Name: "kinesis.records", Time: start.Unix(), Value: 30}},
+	}
+	if diff := cmp.Diff(wantMetrics, fctx.serviceMetrics); diff != "" {
+		t.Errorf("service metrics mismatch: (-want/+got):\n%s", diff)
+	}
+}
+
+func TestGetMetricsData_AggregateNoMetrics(t *testing.T) {
+	start := time.Unix(1234567860, 0)
+	svc := &listingCloudWatch{}
+	fctx := &forwardContext{
+		forwarder: &Forwarder{svccloudwatch: svc},
+		start:     start,
+		end:       start.Add(time.Minute),
+	}
+	query := []*Query{
+		{
+			Service:   "foo",
+			Name:      "kinesis.records",
+			Metric:    []interface{}{"AWS/Kinesis", "IncomingRecords", "StreamName", "stream", "ShardId", "*"},
+			Stat:      "Sum",
+			Aggregate: "sum",
+		},
+	}
+	if err := fctx.getMetricsData(context.Background(), query); err != nil {
+		t.Fatal(err)
+	}
+	if len(svc.queries) != 0 {
+		t.Errorf("want no queries, got %v", svc.queries)
+	}
+}
+
+func TestGetMetricsData_TopN(t *testing.T) {
+	start := time.Unix(1234567860, 0)
+	svc := &listingCloudWatch{
+		fakeCloudWatch: fakeCloudWatch{
+			values: map[string][]float64{
+				// the scores for ranking, in the order of the dimension values.
+				"r0": {10},
+				"r1": {30},
+				"r2": {20},
+
+				"m1_0":     {3},
+				"m1_1":     {2},
+				"m1_other": {1},
+			},
+		},
+		metrics: []types.Metric{
+			kinesisShardMetric("stream", "shardId-000000000000"),
+			kinesisShardMetric("stream", "shardId-000000000001"),
+			kinesisShardMetric("stream", "shardId-000000000002"),
+		},
+	}
+	fctx := &forwardContext{
+		forwarder: &Forwarder{svccloudwatch: svc},
+		start:     start,
+		end:       start.Add(time.Minute),
+	}
+	query := []*Query{
+		{
+			Service: "foo",
+			Name:    "kinesis.records",
+			Metric:  []interface{}{"AWS/Kinesis", "IncomingRecords", "StreamName", "stream", "ShardId", "*"},
+			Stat:    "Sum",
+			TopN:    2,
+			By:      "Maximum",
+		},
+	}
+	if err := fctx.getMetricsData(context.Background(), query); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, q := range svc.queries {
+		if q.Expression != nil {
+			got = append(got, aws.ToString(q.Id)+" = "+aws.ToString(q.Expression))
+			continue
+		}
+		got = append(got, aws.ToString(q.Id)+" = "+aws.ToString(q.MetricStat.Stat)+" "+dimensionsKey(q.MetricStat.Metric.Dimensions))
+	}
+	want := []string{
+		"r0 = Maximum ShardId=shardId-000000000000,StreamName=stream",
+		"r1 = Maximum ShardId=shardId-000000000001,StreamName=stream",
+		"r2 = Maximum ShardId=shardId-000000000002,StreamName=stream",
+		"m1_0 = Sum ShardId=shardId-000000000001,StreamName=stream",
+		"m1_1 = Sum ShardId=shardId-000000000002,StreamName=stream",
+		"m1_other_0 = Sum ShardId=shardId-000000000000,StreamName=stream",
+		"m1_other = SUM([m1_other_0])",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("queries mismatch: (-want/+got):\n%s", diff)
+	}
+
+	wantMetrics := serviceMetricsType{
+		"foo": {
+			{Name: "kinesis.records.shardId-000000000001", Time: start.Unix(), Value: 3},
+			{Name: "kinesis.records.shardId-000000000002", Time: start.Unix(), Value: 2},
+			{Name: "kinesis.records.other", Time: start.Unix(), Value: 1},
+		},
+	}
+	if diff := cmp.Diff(wantMetrics, fctx.serviceMetrics); diff != "" {
+		t.Errorf("service metrics mismatch: (-want/+got):\n%s", diff)
+	}
+}
+
+func TestValidateAggregate(t *testing.T) {
+	tests := []struct {
+		name  string
+		query *Query
+		err   string
+	}{
+		{
+			name:  "aggregate",
+			query: &Query{Service: "foo", Metric: []interface{}{"AWS/Kinesis", "IncomingRecords", "StreamName", "*"}, Stat: "Sum", Aggregate: "avg"},
+		},
+		{
+			name:  "wildcard without aggregate",
+			query: &Query{Service: "foo", Metric: []interface{}{"AWS/Kinesis", "IncomingRecords", "StreamName", "*"}, Stat: "Sum"},
+			err:   "requires aggregate, topN, or the name template",
+		},
+		{
+			name:  "aggregate without wildcard",
+			query: &Query{Service: "foo", Metric: []interface{}{"AWS/Kinesis", "IncomingRecords", "StreamName", "stream"}, Stat: "Sum", Aggregate: "sum"},
+			err:   "at least one wildcard",
+		},
+		{
+			name:  "top n",
+			query: &Query{Service: "foo", Metric: []interface{}{"AWS/Kinesis", "IncomingRecords", "StreamName", "*"}, Stat: "Sum", TopN: 5, By: "Sum"},
+		},
+		{
+			name:  "by without top n",
+			query: &Query{Service: "foo", Metric: []interface{}{"AWS/Kinesis", "IncomingRecords", "StreamName", "*"}, Stat: "Sum", Aggregate: "sum", By: "Sum"},
+			err:   "only with topN",
+		},
+		{
+			name:  "unknown by",
+			query: &Query{Service: "foo", Metric: []interface{}{"AWS/Kinesis", "IncomingRecords", "StreamName", "*"}, Stat: "Sum", TopN: 5, By: "p99"},
+			err:   "unknown statistic",
+		},
+		{
+			name:  "unknown aggregate",
+			query: &Query{Service: "foo", Metric: []interface{}{"AWS/Kinesis", "IncomingRecords", "StreamName", "*"}, Stat: "Sum", Aggregate: "median"},
+			err:   "unknown aggregate",
+		},
+		{
+			name:  "statistics api",
+			query: &Query{Service: "foo", Metric: []interface{}{"AWS/Kinesis", "IncomingRecords", "StreamName", "*"}, Stat: "Sum", Aggregate: "sum", API: "statistics"},
+			err:   "statistics api",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, errs := prepareQueries([]*Query{tt.query})
+			if tt.err == "" {
+				if len(errs) > 0 {
+					t.Errorf("want no errors, got %v", errs)
+				}
+				return
+			}
+			if len(errs) != 1 || !strings.Contains(errs[0].Err.Error(), tt.err) {
+				t.Errorf("want an error containing %q, got %v", tt.err, errs)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package forwarder
+
+import (
+	"context"
+	"slices"
+)
+
+// autoCreatedServiceMemo is the memo of the services created by the forwarder.
+const autoCreatedServiceMemo = "created by mackerel-cloudwatch-forwarder"
+
+func (f *Forwarder) autoCreateServices() bool {
+	if f.AutoCreateServices {
+		return true
+	}
+	return f.env().AutoCreateService
+}
+
+// ensureServices creates the services of the service metrics that don't exist on Mackerel,
+// because Mackerel rejects the service metrics of unknown services.
+// The existing services are cached across the invocations for each organization.
+// The failures are only warned, and the metrics are posted as is.
+func (fctx *forwardContext) ensureServices(ctx context.Context) {
+	if len(fctx.serviceMetrics) == 0 {
+		return
+	}
+	f := fctx.forwarder
+	cache := f.mackerelCache(fctx.mackerel)
+	cache.muServices.Lock()
+	defer cache.muServices.Unlock()
+
+	services := make([]string, 0, len(fctx.serviceMetrics))
+	for service := range fctx.serviceMetrics {
+		if !cache.services[service] {
+			services = append(services, service)
+		}
+	}
+	if len(services) == 0 {
+		return
+	}
+	slices.Sort(services)
+
+	// refresh the list, the services may be created by others.
+	list, err := fctx.mackerel.FindServices(ctx)
+	if err != nil {
+		f.logger().WarnContext(ctx, "failed to get the service list, skips creating services", "error", err.Error())
+		return
+	}
+	if cache.services == nil {
+		cache.services = make(map[string]bool, len(list))
+	}
+	for _, s := range list {
+		cache.services[s.Name] = true
+	}
+
+	for _, service := range services {
+		if cache.services[service] {
+			continue
+		}
+		err := fctx.mackerel.CreateService(ctx, &Service{Name: service, Memo: autoCreatedServiceMemo})
+		if err != nil {
+			f.logger().WarnContext(ctx, "failed to create the service", "service", service, "error", err.Error())
+			continue
+		}
+		f.logger().InfoContext(ctx, "the service is created", "service", service)
+		cache.services[service] = true
+	}
+}
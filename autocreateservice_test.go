@@ -0,0 +1,77 @@
+package forwarder
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestEnsureServices(t *testing.T) {
+	var mu sync.Mutex
+	var requests []string
+	client := newTestMackerelClient(t, http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method {
+		case http.MethodGet:
+			requests = append(requests, "GET")
+			rw.Header().Set("Content-Type", "application/json")
+			rw.Write([]byte(`{"services":[{"name":"existing","memo":"","roles":[]}]}`))
+		case http.MethodPost:
+			var service Service
+			if err := json.NewDecoder(r.Body).Decode(&service); err != nil {
+				t.Fatal(err)
+			}
+			requests = append(requests, "POST "+service.Name)
+			if service.Name == "broken" {
+				rw.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			rw.Header().Set("Content-Type", "application/json")
+			rw.Write([]byte(`{}`))
+		}
+	}))
+
+	f := &Forwarder{}
+	newContext := func() *forwardContext {
+		return &forwardContext{
+			forwarder: f,
+			mackerel:  client,
+			serviceMetrics: serviceMetricsType{
+				"existing": {{Name: "a", Time: 1234567860, Value: 1}},
+				"new":      {{Name: "a", Time: 1234567860, Value: 2}},
+				"broken":   {{Name: "a", Time: 1234567860, Value: 3}},
+			},
+		}
+	}
+
+	fctx := newContext()
+	fctx.ensureServices(context.Background())
+	if len(fctx.serviceMetrics) != 3 {
+		t.Errorf("the metrics should be posted as is: %v", fctx.serviceMetrics)
+	}
+
+	// the created services are cached, and the failed ones are retried.
+	newContext().ensureServices(context.Background())
+
+	want := []string{"GET", "POST broken", "POST new", "GET", "POST broken"}
+	if diff := cmp.Diff(want, requests); diff != "" {
+		t.Errorf("requests mismatch: (-want/+got):\n%s", diff)
+	}
+}
+
+func TestForwarder_AutoCreateServices(t *testing.T) {
+	if (&Forwarder{EnvConfig: &Config{}}).autoCreateServices() {
+		t.Error("want false, got true")
+	}
+	if !(&Forwarder{EnvConfig: &Config{AutoCreateService: true}}).autoCreateServices() {
+		t.Error("want true, got false")
+	}
+	if !(&Forwarder{AutoCreateServices: true, EnvConfig: &Config{}}).autoCreateServices() {
+		t.Error("want true, got false")
+	}
+}
@@ -0,0 +1,101 @@
+package forwarder
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+)
+
+func (f *Forwarder) autoRegisterHosts() bool {
+	if f.AutoRegisterHosts {
+		return true
+	}
+	return f.env().AutoRegisterHosts
+}
+
+// registerHosts fills the host ids of the queries that have neither service name nor host id.
+// The hosts are registered to Mackerel with the custom identifiers of the AWS resources.
+// The queries that don't return data, e.g. the inputs of the expressions, are left as they are,
+// and so are the queries that don't identify a single resource,
+// i.e. the expressions and the queries with the wildcard dimension values, aggregate, or topN.
+func (f *Forwarder) registerHosts(ctx context.Context, client *MackerelClient, query []*Query) []*Query {
+	ret := make([]*Query, 0, len(query))
+	for i, sh := range resolveShorthands(query) {
+		q := query[i]
+		if sh.host != "" || sh.service != "" || !q.returnData() {
+			// the queries that don't return data are not forwarded, so they need no hosts.
+			ret = append(ret, q)
+			continue
+		}
+		if sh.namespace == "" || q.Aggregate != "" || q.TopN > 0 || hasWildcard(sh.dimensions) {
+			// they are reported by the validation.
+			ret = append(ret, q)
+			continue
+		}
+
+		id, err := f.resolveResourceHost(ctx, client, sh.namespace, sh.dimensions)
+		if err != nil {
+			f.logger().WarnContext(withQueryIndex(ctx, i), "failed to register the host for the resource",
+				"namespace", sh.namespace,
+				"error", err.Error(),
+			)
+			ret = append(ret, q)
+			continue
+		}
+		qq := *q
+		qq.Host = id
+		ret = append(ret, &qq)
+	}
+	return ret
+}
+
+// resolveResourceHost returns the host id of the AWS resource.
+// If the host is not registered yet, it registers a new host.
+func (f *Forwarder) resolveResourceHost(ctx context.Context, client *MackerelClient, namespace string, dimensions []types.Dimension) (string, error) {
+	if len(dimensions) == 0 {
+		return "", errors.New("forwarder: dimensions are required to identify the resource")
+	}
+
+	names := make([]string, 0, len(dimensions))
+	pairs := make([]string, 0, len(dimensions))
+	for _, d := range dimensions {
+		names = append(names, aws.ToString(d.Value))
+		pairs = append(pairs, aws.ToString(d.Name)+"="+aws.ToString(d.Value))
+	}
+	customIdentifier := "cloudwatch:" + namespace + ":" + strings.Join(pairs, ",")
+
+	now := f.now()
+	cache := f.mackerelCache(client)
+	cache.muHosts.Lock()
+	defer cache.muHosts.Unlock()
+	if id, ok := cache.hostID(customIdentifier, now); ok {
+		return id, nil
+	}
+
+	var id string
+	hosts, err := client.FindHostsByCustomIdentifier(ctx, customIdentifier)
+	if err != nil {
+		return "", err
+	}
+	if len(hosts) > 0 {
+		id = hosts[0].ID
+	} else {
+		id, err = client.CreateHost(ctx, &Host{
+			Name:             strings.Join(names, "/"),
+			CustomIdentifier: customIdentifier,
+		})
+		if err != nil {
+			return "", err
+		}
+		f.logger().InfoContext(ctx, "register a new host",
+			"hostId", id,
+			"customIdentifier", customIdentifier,
+		)
+	}
+
+	cache.setHostID(customIdentifier, id, now.Add(f.hostListTTL(ctx)))
+	return id, nil
+}
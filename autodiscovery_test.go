@@ -0,0 +1,103 @@
+package forwarder
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRegisterHosts(t *testing.T) {
+	var created int32
+	client := newTestMackerelClient(t, http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			rw.Write([]byte(`{"hosts":[]}`))
+		case http.MethodPost:
+			atomic.AddInt32(&created, 1)
+			rw.Write([]byte(`{"id":"host-abc"}`))
+		}
+	}))
+
+	f := &Forwarder{}
+	hidden := false
+	query := []*Query{
+		{
+			Service: "foo-bar",
+			Name:    "metric.sum",
+			Metric:  []interface{}{"AWS/RDS", "CPUUtilization"},
+		},
+		{
+			Name:   "rds.cpu",
+			Metric: []interface{}{"AWS/RDS", "CPUUtilization", "DBInstanceIdentifier", "db"},
+		},
+		{
+			Name:   "rds.connections",
+			Metric: []interface{}{".", "DatabaseConnections", ".", "."},
+		},
+		{
+			ID:         "ec2",
+			Metric:     []interface{}{"AWS/EC2", "CPUUtilization", "InstanceId", "i-1234567890"},
+			ReturnData: &hidden,
+		},
+	}
+
+	for i := 0; i < 2; i++ {
+		got := f.registerHosts(context.Background(), client, query)
+		if got[0].Host != "" {
+			t.Errorf("unexpected host id: want %q, got %q", "", got[0].Host)
+		}
+		if got[1].Host != "host-abc" {
+			t.Errorf("unexpected host id: want %q, got %q", "host-abc", got[1].Host)
+		}
+		if got[2].Host != "host-abc" {
+			t.Errorf("unexpected host id: want %q, got %q", "host-abc", got[2].Host)
+		}
+		if got[3].Host != "" {
+			t.Errorf("unexpected host id: want %q, got %q", "", got[3].Host)
+		}
+	}
+	if want, got := int32(1), atomic.LoadInt32(&created); want != got {
+		t.Errorf("unexpected host creation count: want %d, got %d", want, got)
+	}
+}
+
+func TestRegisterHosts_NotSingleResource(t *testing.T) {
+	client := newTestMackerelClient(t, http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request: %s %s", r.Method, r.URL)
+		rw.WriteHeader(http.StatusInternalServerError)
+	}))
+
+	f := &Forwarder{}
+	query := []*Query{
+		{
+			Name:   "rds.cpu",
+			Metric: []interface{}{"AWS/RDS", "CPUUtilization", "DBInstanceIdentifier", "*"},
+		},
+		{
+			Name:      "rds.cpu.sum",
+			Metric:    []interface{}{"AWS/RDS", "CPUUtilization", "DBInstanceIdentifier", "db-*"},
+			Aggregate: "sum",
+		},
+		{
+			Name:   "rds.cpu.top",
+			Metric: []interface{}{"AWS/RDS", "CPUUtilization", "DBInstanceIdentifier", "db-*"},
+			TopN:   3,
+		},
+		{
+			Name:       "rds.cpu.double",
+			Expression: "m1 * 2",
+		},
+		{
+			// the shorthand refers to the metric with the wildcard.
+			Name:   "rds.connections",
+			Metric: []interface{}{".", "DatabaseConnections", ".", "*"},
+		},
+	}
+	got := f.registerHosts(context.Background(), client, query)
+	for i, q := range got {
+		if q.Host != "" {
+			t.Errorf("%d: unexpected host id: %q", i, q.Host)
+		}
+	}
+}
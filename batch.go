@@ -0,0 +1,79 @@
+package forwarder
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// QueryGroup is a group of the queries in BatchRequest.
+type QueryGroup struct {
+	// ID is the identifier of the group. The default is the index of the group.
+	ID string `json:"id,omitempty"`
+
+	// Queries is the queries of the group, in the same format as the input of ForwardMetrics.
+	Queries json.RawMessage `json:"queries"`
+}
+
+// BatchRequest is the input of ForwardMetricsBatch.
+type BatchRequest struct {
+	Groups []QueryGroup `json:"groups"`
+}
+
+// BatchResponse is the output of ForwardMetricsBatch.
+// It is similar to the partial batch response of SQS.
+type BatchResponse struct {
+	// BatchItemFailures is the groups that failed to forward.
+	BatchItemFailures []BatchItemFailure `json:"batchItemFailures"`
+}
+
+// BatchItemFailure is a group that failed to forward.
+type BatchItemFailure struct {
+	ItemIdentifier string `json:"itemIdentifier"`
+	Error          string `json:"error,omitempty"`
+}
+
+// ForwardMetricsBatch forwards metrics of the query groups.
+// The groups are forwarded independently, and the failed groups are reported in the response,
+// so that the callers (e.g. a Map state of AWS Step Functions) can retry only the failed groups.
+// A group fails if its queries are invalid, or some metrics fail to post.
+func (f *Forwarder) ForwardMetricsBatch(ctx context.Context, req *BatchRequest) (*BatchResponse, error) {
+	ctx, cancel := f.invocationContext(ctx)
+	defer cancel()
+
+	return &BatchResponse{
+		BatchItemFailures: f.forwardGroups(ctx, req.Groups),
+	}, nil
+}
+
+// forwardGroups forwards metrics of the query groups, and returns the failed groups.
+func (f *Forwarder) forwardGroups(ctx context.Context, groups []QueryGroup) []BatchItemFailure {
+	failures := []BatchItemFailure{}
+	for i, group := range groups {
+		id := group.ID
+		if id == "" {
+			id = strconv.Itoa(i)
+		}
+
+		// the failed groups are retried by the caller, so the failed metrics are not kept for the next invocation,
+		// and the pending metrics of the scheduled invocations are left as they are.
+		report := &InvocationReport{}
+		err := f.forwardMetrics(withIsolatedInvocation(ctx), group.Queries, 0, report)
+
+		if err == nil && report.Failed > 0 {
+			err = fmt.Errorf("forwarder: failed to post %d data points", report.Failed)
+		}
+		if err != nil {
+			f.logger().WarnContext(ctx, "failed to forward the metrics of the group",
+				"group", id,
+				"error", err.Error(),
+			)
+			failures = append(failures, BatchItemFailure{
+				ItemIdentifier: id,
+				Error:          err.Error(),
+			})
+		}
+	}
+	return failures
+}
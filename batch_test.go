@@ -0,0 +1,66 @@
+package forwarder
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestForwardMetricsBatch(t *testing.T) {
+	client := newTestMackerelClient(t, http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/services/bar/") {
+			rw.WriteHeader(http.StatusForbidden)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+	}))
+	pending := serviceMetricsType{"baz": {{Name: "ec2.cpu", Time: 1234567860, Value: 1}}}
+	f := &Forwarder{
+		svcmackerel: client,
+		svccloudwatch: &fakeCloudWatch{
+			values: map[string][]float64{
+				"m1": {1},
+			},
+		},
+		pendingServiceMetrics: pending,
+	}
+	req := &BatchRequest{
+		Groups: []QueryGroup{
+			{
+				ID:      "foo",
+				Queries: json.RawMessage(`[{"service":"foo","name":"ec2.cpu","metric":["AWS/EC2","CPUUtilization","InstanceId","i-1"],"stat":"Average"}]`),
+			},
+			{
+				ID:      "bar",
+				Queries: json.RawMessage(`[{"service":"bar","name":"ec2.cpu","metric":["AWS/EC2","CPUUtilization","InstanceId","i-2"],"stat":"Average"}]`),
+			},
+			{
+				Queries: json.RawMessage(`{"version": 100}`),
+			},
+		},
+	}
+
+	resp, err := f.ForwardMetricsBatch(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &BatchResponse{
+		BatchItemFailures: []BatchItemFailure{
+			{ItemIdentifier: "bar"},
+			{ItemIdentifier: "2"},
+		},
+	}
+	if diff := cmp.Diff(want, resp, cmpopts.IgnoreFields(BatchItemFailure{}, "Error")); diff != "" {
+		t.Errorf("response mismatch: (-want/+got):\n%s", diff)
+	}
+
+	// the pending metrics of the scheduled invocations are neither published nor cleared.
+	if diff := cmp.Diff(pending, f.pendingServiceMetrics); diff != "" {
+		t.Errorf("pending metrics mismatch: (-want/+got):\n%s", diff)
+	}
+}
@@ -0,0 +1,57 @@
+package forwarder
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// isBadRequest reports whether Mackerel rejects the request as a bad request.
+func isBadRequest(err error) bool {
+	var merr Error
+	return errors.As(err, &merr) && merr.StatusCode == http.StatusBadRequest
+}
+
+// maxBisectRequests is the maximum number of the requests of postBisect.
+// An invalid value is isolated in about 2*log2(n) requests,
+// so the bisection stops before many invalid values, e.g. the values of a retired host, send too many requests.
+const maxBisectRequests = 64
+
+// postBisect posts the values.
+// If Mackerel rejects them, i.e. reject(err) reports true, it splits them in halves and posts them again,
+// so that only the invalid values are isolated and the rest are posted.
+// It returns the values that Mackerel rejects, and the values that failed to post for other reasons with the last error.
+// After maxBisectRequests requests, the values in the rejected halves are rejected without bisecting them further.
+func postBisect[T any](ctx context.Context, values []T, reject func(error) bool, post func(ctx context.Context, values []T) error) (rejected, failed []T, err error) {
+	budget := maxBisectRequests
+	return postBisectBudget(ctx, values, reject, post, &budget)
+}
+
+func postBisectBudget[T any](ctx context.Context, values []T, reject func(error) bool, post func(ctx context.Context, values []T) error, budget *int) (rejected, failed []T, err error) {
+	if len(values) == 0 {
+		return nil, nil, nil
+	}
+	if *budget <= 0 {
+		// the values are in a half that Mackerel has rejected.
+		return values, nil, nil
+	}
+	*budget--
+	err = post(ctx, values)
+	if err == nil {
+		return nil, nil, nil
+	}
+	if !reject(err) || ctx.Err() != nil {
+		return nil, values, err
+	}
+	if len(values) == 1 {
+		return values, nil, nil
+	}
+
+	mid := len(values) / 2
+	r1, f1, err1 := postBisectBudget(ctx, values[:mid], reject, post, budget)
+	r2, f2, err2 := postBisectBudget(ctx, values[mid:], reject, post, budget)
+	if err2 == nil {
+		err2 = err1
+	}
+	return append(r1, r2...), append(f1, f2...), err2
+}
@@ -0,0 +1,113 @@
+package forwarder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPostBisect(t *testing.T) {
+	var calls int
+	post := func(ctx context.Context, values []int) error {
+		calls++
+		for _, v := range values {
+			if v < 0 {
+				return Error{StatusCode: http.StatusBadRequest}
+			}
+			if v == 0 {
+				return errors.New("connection reset")
+			}
+		}
+		return nil
+	}
+
+	rejected, failed, err := postBisect(context.Background(), []int{1, -2, 3, 4, 5, 6, 0, 8}, isBadRequest, post)
+	if diff := cmp.Diff([]int{-2}, rejected); diff != "" {
+		t.Errorf("rejected mismatch: (-want/+got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]int{5, 6, 0, 8}, failed); diff != "" {
+		t.Errorf("failed mismatch: (-want/+got):\n%s", diff)
+	}
+	if err == nil || err.Error() != "connection reset" {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// all values are posted in a request if they are valid.
+	calls = 0
+	rejected, failed, err = postBisect(context.Background(), []int{1, 2, 3}, isBadRequest, post)
+	if len(rejected) != 0 || len(failed) != 0 || err != nil {
+		t.Errorf("unexpected result: rejected %v, failed %v, error %v", rejected, failed, err)
+	}
+	if calls != 1 {
+		t.Errorf("unexpected number of the requests: want 1, got %d", calls)
+	}
+}
+
+func TestPostBisect_MaxRequests(t *testing.T) {
+	var calls int
+	post := func(ctx context.Context, values []int) error {
+		calls++
+		for _, v := range values {
+			if v < 0 {
+				return Error{StatusCode: http.StatusBadRequest}
+			}
+		}
+		return nil
+	}
+
+	// all the values are invalid.
+	values := make([]int, 1000)
+	for i := range values {
+		values[i] = -1
+	}
+	rejected, failed, err := postBisect(context.Background(), values, isBadRequest, post)
+	if len(rejected) != len(values) || len(failed) != 0 || err != nil {
+		t.Errorf("unexpected result: rejected %d, failed %v, error %v", len(rejected), failed, err)
+	}
+	if calls > maxBisectRequests {
+		t.Errorf("too many requests: %d", calls)
+	}
+}
+
+func TestPublishMetric_Bisect(t *testing.T) {
+	client := newTestMackerelClient(t, http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		var values []ServiceMetricValue
+		if err := json.NewDecoder(r.Body).Decode(&values); err != nil {
+			t.Error(err)
+		}
+		for _, v := range values {
+			if v.Value < 0 {
+				rw.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		}
+		rw.WriteHeader(http.StatusOK)
+	}))
+	now := time.Now().Truncate(time.Minute).Unix()
+	report := &InvocationReport{}
+	fctx := &forwardContext{
+		forwarder: &Forwarder{},
+		mackerel:  client,
+		serviceMetrics: serviceMetricsType{
+			"foo": {
+				{Name: "a", Time: now - 180, Value: 1},
+				{Name: "a", Time: now - 120, Value: -1},
+				{Name: "a", Time: now - 60, Value: 3},
+			},
+		},
+		report: report,
+	}
+	fctx.publishMetric(context.Background())
+
+	if len(fctx.failedServiceMetrics) != 0 {
+		t.Errorf("the rejected metrics must not be retried: %v", fctx.failedServiceMetrics)
+	}
+	if report.Posted != 2 || report.Dropped != 1 || report.Failed != 0 {
+		t.Errorf("unexpected report: posted %d, dropped %d, failed %d", report.Posted, report.Dropped, report.Failed)
+	}
+}
@@ -0,0 +1,73 @@
+package forwarder
+
+import (
+	"errors"
+	"fmt"
+)
+
+// CheckRule is a rule for check monitoring of the metric.
+type CheckRule struct {
+	// Name is the name of the check monitoring.
+	// If it is empty, the metric name is used.
+	Name string `json:"name,omitempty"`
+
+	// Warning is the threshold for the warning status.
+	Warning *float64 `json:"warning,omitempty"`
+
+	// Critical is the threshold for the critical status.
+	Critical *float64 `json:"critical,omitempty"`
+
+	// Operator is the comparison operator for the thresholds.
+	// It is one of ">", ">=", "<", and "<=". The default is ">".
+	Operator string `json:"operator,omitempty"`
+}
+
+// validate validates the rule, so that the mistakes are reported before posting the check reports.
+func (r *CheckRule) validate() error {
+	var err error
+	switch r.Operator {
+	case "", ">", ">=", "<", "<=":
+	default:
+		err = fmt.Errorf("unknown operator of the check: %q", r.Operator)
+	}
+	if r.Warning == nil && r.Critical == nil {
+		err = errors.Join(err, errors.New("the check requires warning or critical"))
+	}
+	return err
+}
+
+// Evaluate evaluates the value and returns the status and the message.
+func (r *CheckRule) Evaluate(v float64) (CheckStatus, string) {
+	op := r.Operator
+	if op == "" {
+		op = ">"
+	}
+	var cmp func(v, threshold float64) bool
+	switch op {
+	case ">":
+		cmp = func(v, threshold float64) bool { return v > threshold }
+	case ">=":
+		cmp = func(v, threshold float64) bool { return v >= threshold }
+	case "<":
+		cmp = func(v, threshold float64) bool { return v < threshold }
+	case "<=":
+		cmp = func(v, threshold float64) bool { return v <= threshold }
+	default:
+		return CheckStatusUnknown, fmt.Sprintf("unknown operator: %q", op)
+	}
+
+	if r.Critical != nil && cmp(v, *r.Critical) {
+		return CheckStatusCritical, fmt.Sprintf("%g %s %g", v, op, *r.Critical)
+	}
+	if r.Warning != nil && cmp(v, *r.Warning) {
+		return CheckStatusWarning, fmt.Sprintf("%g %s %g", v, op, *r.Warning)
+	}
+	return CheckStatusOK, fmt.Sprintf("%g", v)
+}
+
+func (r *CheckRule) name(label Label) string {
+	if r.Name != "" {
+		return r.Name
+	}
+	return label.MetricName
+}
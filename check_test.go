@@ -0,0 +1,76 @@
+package forwarder
+
+import (
+	"testing"
+)
+
+func TestCheckRule_Evaluate(t *testing.T) {
+	warning := 80.0
+	critical := 90.0
+	testcases := []struct {
+		rule   CheckRule
+		in     float64
+		status CheckStatus
+	}{
+		{
+			rule:   CheckRule{Warning: &warning, Critical: &critical},
+			in:     50,
+			status: CheckStatusOK,
+		},
+		{
+			rule:   CheckRule{Warning: &warning, Critical: &critical},
+			in:     85,
+			status: CheckStatusWarning,
+		},
+		{
+			rule:   CheckRule{Warning: &warning, Critical: &critical},
+			in:     95,
+			status: CheckStatusCritical,
+		},
+		{
+			rule:   CheckRule{Warning: &warning, Critical: &critical, Operator: ">="},
+			in:     90,
+			status: CheckStatusCritical,
+		},
+		{
+			rule:   CheckRule{Warning: &critical, Critical: &warning, Operator: "<"},
+			in:     85,
+			status: CheckStatusWarning,
+		},
+		{
+			rule:   CheckRule{Critical: &warning, Operator: "<="},
+			in:     80,
+			status: CheckStatusCritical,
+		},
+		{
+			rule:   CheckRule{Critical: &warning, Operator: "=="},
+			in:     80,
+			status: CheckStatusUnknown,
+		},
+	}
+
+	for i, tc := range testcases {
+		got, _ := tc.rule.Evaluate(tc.in)
+		if got != tc.status {
+			t.Errorf("no.%d: want %s, got %s", i, tc.status, got)
+		}
+	}
+}
+
+func TestResolveQueries_Check(t *testing.T) {
+	critical := 90.0
+	metric := []interface{}{"AWS/EC2", "CPUUtilization", "InstanceId", "i-1"}
+	query := []*Query{
+		{Host: "host-abc", Name: "ok", Metric: metric, Stat: "Average", Check: &CheckRule{Critical: &critical, Operator: ">="}},
+		{Host: "host-abc", Name: "operator", Metric: metric, Stat: "Average", Check: &CheckRule{Critical: &critical, Operator: "=>"}},
+		{Host: "host-abc", Name: "threshold", Metric: metric, Stat: "Average", Check: &CheckRule{Name: "no threshold"}},
+		{Service: "foo", Name: "service", Metric: metric, Stat: "Average", Check: &CheckRule{Critical: &critical}},
+	}
+	got, errs := resolveQueries(query)
+	if len(got) != 1 || got[0].Index != 0 {
+		t.Errorf("unexpected valid queries: %v", got)
+	}
+	if len(errs) != 3 || errs[0].Index != 1 || errs[1].Index != 2 || errs[2].Index != 3 {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+}
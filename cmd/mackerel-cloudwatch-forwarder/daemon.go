@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	forwarder "github.com/shogo82148/mackerel-cloudwatch-forwarder"
+)
+
+// runDaemon runs the "daemon" subcommand.
+// It forwards the metrics every minute as a long-running process, e.g. on ECS or EC2.
+// The query definition is reloaded on changes and on SIGHUP, so adding metrics doesn't require restarts.
+// The forwarder is configured by the same environment values as the Lambda function,
+// e.g. FORWARD_INTERVAL forwards the high-resolution metrics every interval within the minutes.
+func runDaemon(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("daemon", flag.ContinueOnError)
+	source := flags.String("f", "", "the query definition: a file path, s3://bucket/key, or ssm:/parameter/name")
+	interval := flags.Duration("reload-interval", time.Minute, "the interval for checking the changes of the query definition")
+	metricsAddr := flags.String("metrics-addr", "", "the address for serving /metrics, /healthz, and /readyz, e.g. :9100")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *source == "" {
+		return errors.New("usage: daemon -f query-definition [options]")
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return err
+	}
+	f := &forwarder.Forwarder{
+		Config: cfg,
+	}
+	w := forwarder.NewQueryWatcher(cfg, *source)
+	if _, err := w.Reload(ctx); err != nil {
+		return err
+	}
+	go w.Watch(ctx, *interval)
+
+	if *metricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("GET /metrics", f.MetricsHandler())
+		mux.Handle("GET /healthz", f.LivenessHandler())
+		mux.Handle("GET /readyz", f.ReadinessHandler())
+		srv := &http.Server{
+			Addr:              *metricsAddr,
+			Handler:           mux,
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		go func() {
+			if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				slog.Error("failed to serve the metrics and the health checks", "error", err.Error())
+			}
+		}()
+		defer srv.Close()
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	// align the invocations to the minutes, as the schedule of EventBridge does.
+	next := time.Now().Truncate(time.Minute).Add(time.Minute)
+	timer := time.NewTimer(time.Until(next))
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			// save the state, e.g. the pending metrics that failed to post.
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			return f.Shutdown(shutdownCtx)
+		case <-hup:
+			w.ReloadAndLog(ctx)
+		case <-timer.C:
+			// the errors are logged by the forwarder, and the metrics are retried in the next invocation.
+			if _, err := f.ForwardMetrics(ctx, w.Query()); err != nil {
+				slog.Debug("the invocation failed", "error", err.Error())
+			}
+			next = next.Add(time.Minute)
+			for !next.After(time.Now()) {
+				next = next.Add(time.Minute)
+			}
+			timer.Reset(time.Until(next))
+		}
+	}
+}
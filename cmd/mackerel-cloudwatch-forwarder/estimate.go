@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"os"
+
+	forwarder "github.com/shogo82148/mackerel-cloudwatch-forwarder"
+)
+
+// runEstimateCost runs the "estimate-cost" subcommand.
+// It prints the estimated monthly usage and cost of the CloudWatch API for the query definition.
+func runEstimateCost(args []string) error {
+	flags := flag.NewFlagSet("estimate-cost", flag.ContinueOnError)
+	schedule := flags.String("schedule", "rate(1 minute)", "the rate expression of the EventBridge rule")
+	lookback := flags.Duration("lookback", 0, "the length of the time window for fetching the metrics")
+	metricDataPrice := flags.Float64("metric-data-price", 0, "the price of GetMetricData in USD per 1,000 metrics requested")
+	apiRequestPrice := flags.Float64("api-request-price", 0, "the price of GetMetricStatistics in USD per 1,000 requests")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("usage: estimate-cost [options] query-file")
+	}
+
+	data, err := forwarder.LoadQueryFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	queries, err := forwarder.ParseQueries(data)
+	if err != nil {
+		return err
+	}
+	estimate, err := forwarder.EstimateCost(queries, &forwarder.CostEstimateOptions{
+		Schedule:        *schedule,
+		Lookback:        *lookback,
+		MetricDataPrice: *metricDataPrice,
+		APIRequestPrice: *apiRequestPrice,
+	})
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(estimate)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"os"
+
+	forwarder "github.com/shogo82148/mackerel-cloudwatch-forwarder"
+)
+
+// runExplain runs the "explain" subcommand.
+// It prints the effective queries of the query definition,
+// e.g. the namespaces, the metrics and the dimensions inherited by the "." shorthand.
+func runExplain(args []string) error {
+	flags := flag.NewFlagSet("explain", flag.ContinueOnError)
+	source := flags.String("f", "", "the query definition file")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *source == "" {
+		return errors.New("usage: explain -f query-file")
+	}
+
+	data, err := forwarder.LoadQueryFile(*source)
+	if err != nil {
+		return err
+	}
+	queries, err := forwarder.ParseQueries(data)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(forwarder.ExplainQueries(queries))
+}
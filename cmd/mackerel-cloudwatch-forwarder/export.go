@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	forwarder "github.com/shogo82148/mackerel-cloudwatch-forwarder"
+)
+
+// runExport runs the "export" subcommand.
+// It writes the fetched data points to the output instead of posting them to Mackerel.
+func runExport(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	file := flags.String("f", "", "the query file")
+	format := flags.String("format", forwarder.ExportFormatJSON, "the output format, json or csv")
+	output := flags.String("o", "-", "the output, a file path, an S3 URI (e.g. s3://bucket/metrics.json), or - for stdout")
+	from := flags.String("from", "", "the start of the time range in RFC 3339. the default is the current time window")
+	to := flags.String("to", "", "the end of the time range in RFC 3339")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *file == "" {
+		return errors.New("usage: export -f query-file [options]")
+	}
+	var opts forwarder.ExportOptions
+	if *from != "" || *to != "" {
+		var err error
+		if opts.Start, err = time.Parse(time.RFC3339, *from); err != nil {
+			return err
+		}
+		if opts.End, err = time.Parse(time.RFC3339, *to); err != nil {
+			return err
+		}
+	}
+
+	data, err := forwarder.LoadQueryFile(*file)
+	if err != nil {
+		return err
+	}
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return err
+	}
+	f := &forwarder.Forwarder{
+		Config: cfg,
+	}
+	points, err := f.Export(ctx, data, &opts)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := forwarder.EncodeDatapoints(&buf, *format, points); err != nil {
+		return err
+	}
+	if *output == "-" {
+		_, err := os.Stdout.Write(buf.Bytes())
+		return err
+	}
+	if u, err := url.Parse(*output); err == nil && u.Scheme == "s3" {
+		_, err := s3.NewFromConfig(cfg).PutObject(ctx, &s3.PutObjectInput{
+			Bucket: aws.String(u.Host),
+			Key:    aws.String(strings.TrimPrefix(u.Path, "/")),
+			Body:   bytes.NewReader(buf.Bytes()),
+		})
+		return err
+	}
+	return os.WriteFile(*output, buf.Bytes(), 0o644)
+}
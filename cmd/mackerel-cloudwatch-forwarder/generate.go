@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	forwarder "github.com/shogo82148/mackerel-cloudwatch-forwarder"
+	"sigs.k8s.io/yaml"
+)
+
+// runGenerateTemplate runs the "generate-template" subcommand.
+// It generates the AWS SAM template that forwards the metrics of the query definition.
+func runGenerateTemplate(args []string) error {
+	flags := flag.NewFlagSet("generate-template", flag.ContinueOnError)
+	parameterName := flags.String("parameter-name", "", "the name of the SSM parameter that stores the API key of Mackerel (required)")
+	kmsKeyARN := flags.String("kms-key-arn", "", "the ARN of the KMS key that encrypts the API key")
+	schedule := flags.String("schedule", "rate(1 minute)", "the schedule expression of the EventBridge rule")
+	codeURI := flags.String("code-uri", "dist.zip", "the zip file of the Lambda function")
+	architecture := flags.String("architecture", "arm64", "the architecture of the Lambda function: arm64 or x86_64, that the zip file is built for")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *parameterName == "" {
+		return errors.New("-parameter-name is required")
+	}
+	if *architecture != "arm64" && *architecture != "x86_64" {
+		return fmt.Errorf("unknown architecture: %s", *architecture)
+	}
+	if flags.NArg() != 1 {
+		return errors.New("usage: generate-template [options] query-file")
+	}
+
+	data, err := forwarder.LoadQueryFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	queries, err := forwarder.ParseQueries(data)
+	if err != nil {
+		return err
+	}
+	// normalize the input
+	input, err := json.Marshal(queries)
+	if err != nil {
+		return err
+	}
+
+	policy, err := forwarder.RequiredPolicyForInput(data, &forwarder.PolicyOptions{
+		ParameterName: *parameterName,
+		KMSKeyARN:     *kmsKeyARN,
+	})
+	if err != nil {
+		return err
+	}
+
+	template := map[string]any{
+		"AWSTemplateFormatVersion": "2010-09-09",
+		"Transform":                "AWS::Serverless-2016-10-31",
+		"Resources": map[string]any{
+			"Forwarder": map[string]any{
+				"Type": "AWS::Serverless::Function",
+				"Properties": map[string]any{
+					"Handler":       "bootstrap",
+					"Runtime":       "provided.al2023",
+					"Architectures": []string{*architecture},
+					"CodeUri":       *codeURI,
+					"Timeout":       60,
+					"Policies":      []any{cfnPolicy(policy)},
+					"Environment": map[string]any{
+						"Variables": map[string]string{
+							"MACKEREL_APIKEY_PARAMETER":    *parameterName,
+							"MACKEREL_APIKEY_WITH_DECRYPT": "1",
+						},
+					},
+					"Events": map[string]any{
+						"ForwardSchedule": map[string]any{
+							"Type": "Schedule",
+							"Properties": map[string]any{
+								"Schedule": *schedule,
+								"Input":    string(input),
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	return writeYAML(os.Stdout, template)
+}
+
+// cfnPolicy converts the policy into CloudFormation.
+// The strings with the pseudo parameters are wrapped with Fn::Sub.
+func cfnPolicy(policy *forwarder.PolicyDocument) map[string]any {
+	statements := make([]any, 0, len(policy.Statement))
+	for _, s := range policy.Statement {
+		resources := make([]any, 0, len(s.Resource))
+		for _, r := range s.Resource {
+			resources = append(resources, cfnSub(r))
+		}
+		statement := map[string]any{
+			"Effect":   s.Effect,
+			"Action":   s.Action,
+			"Resource": resources,
+		}
+		if len(s.Condition) > 0 {
+			condition := make(map[string]any, len(s.Condition))
+			for op, values := range s.Condition {
+				m := make(map[string]any, len(values))
+				for k, v := range values {
+					if str, ok := v.(string); ok {
+						m[k] = cfnSub(str)
+					} else {
+						m[k] = v
+					}
+				}
+				condition[op] = m
+			}
+			statement["Condition"] = condition
+		}
+		statements = append(statements, statement)
+	}
+	return map[string]any{
+		"Version":   policy.Version,
+		"Statement": statements,
+	}
+}
+
+func cfnSub(s string) any {
+	if strings.Contains(s, "${") {
+		return map[string]string{"Fn::Sub": s}
+	}
+	return s
+}
+
+func writeYAML(w io.Writer, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	out, err := yaml.JSONToYAML(data)
+	if err != nil {
+		return fmt.Errorf("failed to convert into yaml: %w", err)
+	}
+	_, err = w.Write(out)
+	return err
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	forwarder "github.com/shogo82148/mackerel-cloudwatch-forwarder"
+)
+
+// runGenerateDashboard runs the "generate-dashboard" subcommand.
+// It creates the custom dashboard on Mackerel with the graphs of the metrics in the query definition,
+// or replaces the existing one of the same url path, so that the new queries are visualized.
+// The API key is configured by the same environment values as the Lambda function.
+func runGenerateDashboard(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("generate-dashboard", flag.ContinueOnError)
+	source := flags.String("f", "", "the query definition file")
+	title := flags.String("title", "CloudWatch", "the title of the dashboard")
+	urlPath := flags.String("url-path", "cloudwatch", "the url path of the dashboard, which identifies the dashboard to update")
+	dryRun := flags.Bool("dry-run", false, "print the dashboard without creating it")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *source == "" {
+		return errors.New("usage: generate-dashboard -f query-file [options]")
+	}
+
+	data, err := forwarder.LoadQueryFile(*source)
+	if err != nil {
+		return err
+	}
+	queries, err := forwarder.ParseQueries(data)
+	if err != nil {
+		return err
+	}
+	dashboard := forwarder.GenerateDashboard(queries, *title, *urlPath)
+	if *dryRun {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(dashboard)
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return err
+	}
+	f := &forwarder.Forwarder{
+		APIURL: os.Getenv("MACKEREL_APIURL"),
+		Config: cfg,
+	}
+	ret, err := f.SyncDashboard(ctx, dashboard)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("the dashboard %s is generated: %d widgets\n", ret.ID, len(dashboard.Widgets))
+	return nil
+}
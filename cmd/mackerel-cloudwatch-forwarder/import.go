@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	forwarder "github.com/shogo82148/mackerel-cloudwatch-forwarder"
+)
+
+// runImport runs the "import" subcommand.
+// It posts the data points in the file, e.g. the output of the "export" subcommand, to Mackerel.
+// The API key is configured by the same environment values as the Lambda function.
+func runImport(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	file := flags.String("f", "", "the file of the data points")
+	format := flags.String("format", forwarder.ExportFormatJSON, "the input format, json or csv")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *file == "" {
+		return errors.New("usage: import -f metrics-file [options]")
+	}
+
+	r, err := os.Open(*file)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	points, err := forwarder.DecodeDatapoints(r, *format)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return err
+	}
+	f := &forwarder.Forwarder{
+		APIURL: os.Getenv("MACKEREL_APIURL"),
+		Config: cfg,
+	}
+	return f.Import(ctx, points)
+}
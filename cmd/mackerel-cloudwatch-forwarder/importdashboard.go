@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"os"
+
+	forwarder "github.com/shogo82148/mackerel-cloudwatch-forwarder"
+)
+
+// runImportDashboard runs the "import-dashboard" subcommand.
+// It converts a CloudWatch dashboard into the query file,
+// e.g. the output of `aws cloudwatch get-dashboard` or the source of the dashboard on the console.
+func runImportDashboard(args []string) error {
+	flags := flag.NewFlagSet("import-dashboard", flag.ContinueOnError)
+	file := flags.String("f", "", "the file of the dashboard")
+	service := flags.String("service", "", "the service name on Mackerel")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *file == "" || *service == "" {
+		return errors.New("usage: import-dashboard -f dashboard-file -service service-name")
+	}
+
+	data, err := os.ReadFile(*file)
+	if err != nil {
+		return err
+	}
+	doc, err := forwarder.ImportDashboard(data, *service)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
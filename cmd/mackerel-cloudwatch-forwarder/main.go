@@ -2,39 +2,168 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"log/slog"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go-v2/config"
 	forwarder "github.com/shogo82148/mackerel-cloudwatch-forwarder"
-	"github.com/sirupsen/logrus"
 )
 
 func init() {
-	logrus.SetFormatter(&logrus.JSONFormatter{})
+	level := new(slog.LevelVar)
+	logger := slog.New(forwarder.NewLambdaHandler(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{
+		Level: level,
+	})))
+	slog.SetDefault(logger)
 
 	s := os.Getenv("FORWARD_LOG_LEVEL")
 	if s != "" {
-		level, err := logrus.ParseLevel(s)
+		l, err := parseLogLevel(s)
 		if err != nil {
-			logrus.WithFields(logrus.Fields{
-				"input": level,
-				"error": err,
-			}).Error("fail to parse log level")
+			logger.Error("fail to parse log level",
+				"input", s,
+				"error", err.Error(),
+			)
 		} else {
-			logrus.SetLevel(level)
+			level.Set(l)
 		}
 	}
 }
 
+// parseLogLevel parses the log level.
+// It also accepts the level names of logrus for backward compatibility.
+func parseLogLevel(s string) (slog.Level, error) {
+	switch strings.ToLower(s) {
+	case "trace":
+		return slog.LevelDebug, nil
+	case "warning":
+		return slog.LevelWarn, nil
+	case "fatal", "panic":
+		return slog.LevelError, nil
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return 0, err
+	}
+	return level, nil
+}
+
+// unwrapErrors returns the errors joined by errors.Join.
+func unwrapErrors(err error) []error {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		return joined.Unwrap()
+	}
+	return []error{err}
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		var err error
+		switch os.Args[1] {
+		case "package":
+			err = runPackage(context.Background(), os.Args[2:])
+		case "generate-template":
+			err = runGenerateTemplate(os.Args[2:])
+		case "iam-policy":
+			err = runIAMPolicy(os.Args[2:])
+		case "estimate-cost":
+			err = runEstimateCost(os.Args[2:])
+		case "explain":
+			err = runExplain(os.Args[2:])
+		case "schema":
+			err = runSchema(os.Args[2:])
+		case "replay":
+			err = runReplay(context.Background(), os.Args[2:])
+		case "export":
+			err = runExport(context.Background(), os.Args[2:])
+		case "import":
+			err = runImport(context.Background(), os.Args[2:])
+		case "import-dashboard":
+			err = runImportDashboard(os.Args[2:])
+		case "generate-dashboard":
+			err = runGenerateDashboard(context.Background(), os.Args[2:])
+		case "sync-monitors":
+			err = runSyncMonitors(context.Background(), os.Args[2:])
+		case "daemon":
+			err = runDaemon(context.Background(), os.Args[2:])
+		case "run":
+			err = runOnce(context.Background(), os.Args[2:])
+		default:
+			slog.Error("unknown subcommand", "subcommand", os.Args[1])
+			os.Exit(exitUsage)
+		}
+		if err != nil {
+			slog.Error("failed to run the subcommand", "subcommand", os.Args[1], "error", err.Error())
+			os.Exit(exitCode(err))
+		}
+		return
+	}
+
 	cfg, err := config.LoadDefaultConfig(context.Background())
 	if err != nil {
-		logrus.WithError(err).Error("fail to load aws config")
+		slog.Error("fail to load aws config", "error", err.Error())
+	}
+	env, err := forwarder.LoadConfigFromEnv()
+	if err != nil {
+		// don't stop forwarding on the problems that the older versions accepted,
+		// e.g. the unknown environment values and MACKEREL_APIKEY_WITH_DECRYPT=false.
+		// the invalid values are left zero, and the defaults are used.
+		for _, err := range unwrapErrors(err) {
+			slog.Warn("invalid configuration, ignores it", "error", err.Error())
+		}
 	}
 	f := &forwarder.Forwarder{
-		APIURL: os.Getenv("MACKEREL_APIURL"),
-		Config: cfg,
+		Config:    cfg,
+		EnvConfig: env,
+	}
+	flush := setupTracing(context.Background())
+
+	// FORWARD_HANDLER selects the handler of the Lambda function.
+	switch handler := env.Handler; handler {
+	case "", "metrics":
+		// FORWARD_DEBUG_HANDLERS enables the requests for debugging, e.g. {"__dump_pending": true}.
+		debug := env.DebugHandlers
+		handler := func(ctx context.Context, data json.RawMessage) (any, error) {
+			// {"ping": true} verifies the deployment without forwarding metrics.
+			if forwarder.IsPingRequest(data) {
+				return f.Ping(ctx)
+			}
+			if debug && forwarder.IsDumpPendingRequest(data) {
+				return f.PendingMetrics(), nil
+			}
+			return f.ForwardMetrics(ctx, data)
+		}
+		lambda.StartWithOptions(withFlush(handler, flush), lambda.WithEnableSIGTERM(func() {
+			// the execution environment is being shut down.
+			// AWS Lambda gives 500ms for shutting down.
+			ctx, cancel := context.WithTimeout(context.Background(), 400*time.Millisecond)
+			defer cancel()
+			if err := f.Shutdown(ctx); err != nil {
+				slog.Error("failed to shut down", "error", err.Error())
+			}
+		}))
+	case "ping":
+		lambda.Start(withFlush(f.Ping, flush))
+	case "batch":
+		lambda.Start(withFlush(f.ForwardMetricsBatch, flush))
+	case "sqs":
+		lambda.Start(withFlush(f.ForwardMetricsSQS, flush))
+	case "collect":
+		lambda.Start(withFlush(f.CollectMetrics, flush))
+	case "publish":
+		lambda.Start(withFlush(f.PublishMetrics, flush))
+	case "kinesis":
+		lambda.Start(withFlush(f.ForwardKinesis, flush))
+	case "firehose":
+		lambda.Start(withFlush(f.ForwardFirehose, flush))
+	case "costs":
+		lambda.Start(withFlush(f.ForwardCosts, flush))
+	default:
+		slog.Error("unknown handler", "handler", handler)
+		os.Exit(1)
 	}
-	lambda.Start(f.ForwardMetrics)
 }
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	forwarder "github.com/shogo82148/mackerel-cloudwatch-forwarder"
+)
+
+// runPackage runs the "package" subcommand.
+// It packages the running executable as the zip file of AWS Lambda, uploads it to S3 optionally,
+// and prints the parameters for the deployment.
+func runPackage(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("package", flag.ContinueOnError)
+	output := flags.String("output", "dist.zip", "the path of the zip file")
+	s3URI := flags.String("s3", "", "the S3 URI to upload the zip file, e.g. s3://bucket/prefix/")
+	format := flags.String("format", "json", "the format of the deployment parameters: json or tfvars")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if runtime.GOOS != "linux" {
+		return fmt.Errorf("the package command must run on linux, cross-compile the command with GOOS=linux: %s", runtime.GOOS)
+	}
+	arch, err := lambdaArchitecture(runtime.GOARCH)
+	if err != nil {
+		return err
+	}
+
+	// validate the flags before writing the zip file.
+	if !isParameterFormat(*format) {
+		return errors.New("unknown format: " + *format)
+	}
+	var bucket, key string
+	if *s3URI != "" {
+		bucket, key, err = parseS3Prefix(*s3URI)
+		if err != nil {
+			return err
+		}
+		key += fmt.Sprintf("mackerel-cloudwatch-forwarder-%s-%s.zip", forwarder.Version(), arch)
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	bootstrap, err := os.ReadFile(exe)
+	if err != nil {
+		return err
+	}
+	data, err := zipBootstrap(bootstrap)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(*output, data, 0o644); err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256(data)
+	params := map[string]string{
+		"runtime":          "provided.al2023",
+		"handler":          "bootstrap",
+		"architecture":     arch,
+		"filename":         *output,
+		"source_code_hash": base64.StdEncoding.EncodeToString(sum[:]),
+	}
+
+	if *s3URI != "" {
+		cfg, err := config.LoadDefaultConfig(ctx)
+		if err != nil {
+			return err
+		}
+		svc := s3.NewFromConfig(cfg)
+		_, err = svc.PutObject(ctx, &s3.PutObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+			Body:   bytes.NewReader(data),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to upload the zip file: %w", err)
+		}
+		delete(params, "filename")
+		params["s3_bucket"] = bucket
+		params["s3_key"] = key
+	}
+
+	return printParameters(os.Stdout, *format, params)
+}
+
+// lambdaArchitecture returns the architecture name of AWS Lambda.
+func lambdaArchitecture(goarch string) (string, error) {
+	switch goarch {
+	case "amd64":
+		return "x86_64", nil
+	case "arm64":
+		return "arm64", nil
+	}
+	return "", fmt.Errorf("unsupported architecture for AWS Lambda: %s", goarch)
+}
+
+// zipBootstrap creates the zip file that contains the executable named "bootstrap".
+func zipBootstrap(bootstrap []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	header := &zip.FileHeader{
+		Name:   "bootstrap",
+		Method: zip.Deflate,
+	}
+	header.SetMode(0o755)
+	f, err := w.CreateHeader(header)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Write(bootstrap); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// parseS3Prefix parses the S3 URI, e.g. "s3://bucket/prefix/".
+func parseS3Prefix(uri string) (bucket, prefix string, err error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", "", err
+	}
+	if u.Scheme != "s3" || u.Host == "" {
+		return "", "", fmt.Errorf("invalid s3 uri: %q", uri)
+	}
+	prefix = strings.TrimPrefix(u.Path, "/")
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return u.Host, prefix, nil
+}
+
+// isParameterFormat reports whether printParameters supports the format.
+func isParameterFormat(format string) bool {
+	return format == "json" || format == "tfvars"
+}
+
+// printParameters prints the deployment parameters.
+func printParameters(w io.Writer, format string, params map[string]string) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(params)
+	case "tfvars":
+		keys := make([]string, 0, len(params))
+		for k := range params {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			v, err := json.Marshal(params[k])
+			if err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintf(w, "%s = %s\n", k, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return errors.New("unknown format: " + format)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"os"
+
+	forwarder "github.com/shogo82148/mackerel-cloudwatch-forwarder"
+)
+
+// runIAMPolicy runs the "iam-policy" subcommand.
+// It prints the minimal IAM policy that the forwarder requires for the query definition.
+func runIAMPolicy(args []string) error {
+	flags := flag.NewFlagSet("iam-policy", flag.ContinueOnError)
+	parameterName := flags.String("parameter-name", "", "the name of the SSM parameter that stores the API key of Mackerel")
+	kmsKeyARN := flags.String("kms-key-arn", "", "the ARN of the KMS key that encrypts the API key")
+	stateStore := flags.String("state-store", "", "the URI of the state store, e.g. s3://bucket/path/to/state.json")
+	stateKMSKey := flags.String("state-kms-key", "", "the ARN of the KMS key that encrypts the state")
+	idempotencyTable := flags.String("idempotency-table", "", "the name of the DynamoDB table for deduplicating the invocations")
+	syncHostMetadata := flags.Bool("sync-host-metadata", false, "sync the host metadata from the tags of the resources")
+	verifyMetrics := flags.Bool("verify-metrics", false, "verify that the metrics of the queries exist on CloudWatch")
+	partition := flags.String("partition", "", "the partition of AWS, e.g. aws, aws-us-gov, and aws-cn (default: the partition of the region)")
+	region := flags.String("region", "*", "the region of AWS")
+	accountID := flags.String("account-id", "*", "the id of the AWS account")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("usage: iam-policy [options] query-file")
+	}
+
+	data, err := forwarder.LoadQueryFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	policy, err := forwarder.RequiredPolicyForInput(data, &forwarder.PolicyOptions{
+		ParameterName:    *parameterName,
+		KMSKeyARN:        *kmsKeyARN,
+		StateStore:       *stateStore,
+		StateKMSKeyARN:   *stateKMSKey,
+		IdempotencyTable: *idempotencyTable,
+		SyncHostMetadata: *syncHostMetadata,
+		VerifyMetrics:    *verifyMetrics,
+	})
+	if err != nil {
+		return err
+	}
+	policy = forwarder.SubstitutePseudoParameters(policy, forwarder.PseudoParameters{
+		Partition: *partition,
+		Region:    *region,
+		AccountID: *accountID,
+	})
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(policy)
+}
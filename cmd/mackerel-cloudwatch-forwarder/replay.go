@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	forwarder "github.com/shogo82148/mackerel-cloudwatch-forwarder"
+)
+
+// runReplay runs the "replay" subcommand.
+// It forwards the metrics in the past time range again, e.g. for backfilling Mackerel after an outage.
+// The forwarder is configured by the same environment values as the Lambda function.
+func runReplay(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	from := flags.String("from", "", "the start of the time range in RFC 3339, e.g. 2024-01-02T03:00:00Z")
+	to := flags.String("to", "", "the end of the time range in RFC 3339")
+	file := flags.String("f", "", "the query file")
+	rate := flags.Float64("rate", 1, "the maximum number of the time windows forwarded per second")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *from == "" || *to == "" || *file == "" {
+		return errors.New("usage: replay --from time --to time -f query-file [options]")
+	}
+	start, err := time.Parse(time.RFC3339, *from)
+	if err != nil {
+		return err
+	}
+	end, err := time.Parse(time.RFC3339, *to)
+	if err != nil {
+		return err
+	}
+
+	data, err := forwarder.LoadQueryFile(*file)
+	if err != nil {
+		return err
+	}
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return err
+	}
+	f := &forwarder.Forwarder{
+		APIURL: os.Getenv("MACKEREL_APIURL"),
+		Config: cfg,
+	}
+	// the state of the scheduled invocations is not saved,
+	// because the replay doesn't load it.
+	return f.Replay(ctx, data, start, end, &forwarder.ReplayOptions{
+		Rate: *rate,
+	})
+}
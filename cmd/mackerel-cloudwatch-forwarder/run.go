@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	forwarder "github.com/shogo82148/mackerel-cloudwatch-forwarder"
+)
+
+// the exit codes of the "run" subcommand.
+const (
+	// exitFailure means the invocation failed, and the pending metrics are not persisted.
+	exitFailure = 1
+
+	// exitPartialFailure means some metrics failed to forward, and they are persisted in the state store for retrying.
+	exitPartialFailure = 2
+
+	// exitConfigError means the configuration is invalid, e.g. the environment values, the queries, or the API key.
+	// Running again doesn't fix it.
+	exitConfigError = 3
+
+	// exitUsage means the command line is invalid, e.g. an unknown subcommand or flag.
+	// It is the same as EX_USAGE of sysexits.h, and distinct from the outcomes of forwarding.
+	exitUsage = 64
+)
+
+// exitError is an error with the exit code of the process.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+func (e *exitError) Unwrap() error {
+	return e.err
+}
+
+// exitCode returns the exit code of the process for the error of the subcommand.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *exitError
+	if errors.As(err, &exitErr) {
+		return exitErr.code
+	}
+	return exitFailure
+}
+
+// runOnce runs the "run" subcommand.
+// It forwards the metrics once and exits, e.g. for Kubernetes CronJobs.
+// The exit code tells the outcome: 0 for success, 2 for partial failure with the pending metrics persisted,
+// 3 for configuration errors, 64 for invalid flags, and 1 for the other failures.
+// Set FORWARD_STATE_STORE to persist the metrics that failed to forward for the next run.
+func runOnce(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("run", flag.ContinueOnError)
+	file := flags.String("f", "", "the query file (default: FORWARD_QUERY_FILE)")
+	if err := flags.Parse(args); err != nil {
+		return &exitError{code: exitUsage, err: err}
+	}
+
+	env, err := forwarder.LoadConfigFromEnv()
+	if err != nil {
+		return &exitError{code: exitConfigError, err: err}
+	}
+	if *file == "" && env.QueryFile == "" {
+		return &exitError{code: exitConfigError, err: errors.New("usage: run -f query-file")}
+	}
+	if *file != "" {
+		// validate the queries in advance, to distinguish the mistakes of them from the failures of forwarding.
+		data, err := forwarder.LoadQueryFile(*file)
+		if err == nil {
+			_, err = forwarder.ParseQueries(data)
+		}
+		if err != nil {
+			return &exitError{code: exitConfigError, err: err}
+		}
+	}
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return &exitError{code: exitConfigError, err: err}
+	}
+
+	f := &forwarder.Forwarder{
+		Config:    cfg,
+		EnvConfig: env,
+		QueryFile: *file,
+	}
+	report, err := f.ForwardMetrics(ctx, nil)
+	shutdownErr := f.Shutdown(ctx)
+	var failed int
+	if report != nil {
+		failed = report.Failed
+	}
+	return runResult(err, failed, env.StateStore != "", shutdownErr)
+}
+
+// runResult maps the outcome of forwarding to the error with the exit code, or nil on success.
+// persisted reports whether the state store is configured, so that the failed metrics are retried on the next run.
+func runResult(err error, failed int, persisted bool, shutdownErr error) error {
+	if isConfigError(err) {
+		return &exitError{code: exitConfigError, err: err}
+	}
+	if err == nil && failed == 0 {
+		if shutdownErr != nil {
+			// the high-water marks are not saved, the next run may forward duplicated data points.
+			return &exitError{code: exitFailure, err: shutdownErr}
+		}
+		return nil
+	}
+
+	if err == nil {
+		err = fmt.Errorf("failed to forward %d data points", failed)
+	}
+	if !persisted || shutdownErr != nil {
+		return &exitError{code: exitFailure, err: errors.Join(err, shutdownErr)}
+	}
+	return &exitError{code: exitPartialFailure, err: err}
+}
+
+// isConfigError reports whether err is caused by the configuration.
+func isConfigError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var queryErr *forwarder.QueryError
+	var queryErrs forwarder.QueryErrors
+	return errors.As(err, &queryErr) ||
+		errors.As(err, &queryErrs) ||
+		errors.Is(err, forwarder.ErrNoAPIKey) ||
+		errors.Is(err, forwarder.ErrInvalidAPIKey) ||
+		errors.Is(err, forwarder.ErrAPIKeyPermissionDenied)
+}
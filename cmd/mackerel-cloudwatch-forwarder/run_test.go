@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	forwarder "github.com/shogo82148/mackerel-cloudwatch-forwarder"
+)
+
+func TestRunResult(t *testing.T) {
+	errNetwork := errors.New("connection refused")
+	tests := []struct {
+		name        string
+		err         error
+		failed      int
+		persisted   bool
+		shutdownErr error
+		want        int
+	}{
+		{
+			name: "success",
+			want: 0,
+		},
+		{
+			name:        "failed to save the state",
+			shutdownErr: errNetwork,
+			want:        exitFailure,
+		},
+		{
+			name:      "partial failure with the state store",
+			failed:    3,
+			persisted: true,
+			want:      exitPartialFailure,
+		},
+		{
+			name:   "partial failure without the state store",
+			failed: 3,
+			want:   exitFailure,
+		},
+		{
+			name:        "partial failure and failed to save the state",
+			failed:      3,
+			persisted:   true,
+			shutdownErr: errNetwork,
+			want:        exitFailure,
+		},
+		{
+			name:      "failure with the state store",
+			err:       errNetwork,
+			persisted: true,
+			want:      exitPartialFailure,
+		},
+		{
+			name: "failure without the state store",
+			err:  errNetwork,
+			want: exitFailure,
+		},
+		{
+			name:      "no api key",
+			err:       fmt.Errorf("failed to get the api key: %w", forwarder.ErrNoAPIKey),
+			persisted: true,
+			want:      exitConfigError,
+		},
+		{
+			name: "invalid api key",
+			err:  forwarder.ErrInvalidAPIKey,
+			want: exitConfigError,
+		},
+		{
+			name:      "invalid queries",
+			err:       forwarder.QueryErrors{&forwarder.QueryError{Index: 0, Err: errors.New("invalid namespace")}},
+			persisted: true,
+			want:      exitConfigError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := runResult(tt.err, tt.failed, tt.persisted, tt.shutdownErr)
+			if got := exitCode(err); got != tt.want {
+				t.Errorf("unexpected exit code: want %d, got %d (%v)", tt.want, got, err)
+			}
+			if tt.want != 0 && err == nil {
+				t.Error("want an error, got nil")
+			}
+		})
+	}
+}
+
+func TestRunOnce_Usage(t *testing.T) {
+	t.Setenv("FORWARD_QUERY_FILE", "")
+	tests := []struct {
+		name string
+		env  map[string]string
+		args []string
+		want int
+	}{
+		{
+			name: "unknown flag",
+			args: []string{"-unknown"},
+			want: exitUsage,
+		},
+		{
+			name: "invalid environment value",
+			env:  map[string]string{"MACKEREL_APIKEY_WITH_DECRYPT": "false"},
+			args: []string{"-f", "query.json"},
+			want: exitConfigError,
+		},
+		{
+			name: "unknown environment value",
+			env:  map[string]string{"FORWARD_UNKNOWN": "1"},
+			args: []string{"-f", "query.json"},
+			want: exitConfigError,
+		},
+		{
+			name: "no query file",
+			args: []string{},
+			want: exitConfigError,
+		},
+		{
+			name: "query file not found",
+			args: []string{"-f", filepath.Join(t.TempDir(), "query.json")},
+			want: exitConfigError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			err := runOnce(context.Background(), tt.args)
+			if got := exitCode(err); got != tt.want {
+				t.Errorf("unexpected exit code: want %d, got %d (%v)", tt.want, got, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"flag"
+	"os"
+
+	forwarder "github.com/shogo82148/mackerel-cloudwatch-forwarder"
+)
+
+// runSchema runs the "schema" subcommand.
+// It prints the JSON Schema of the query definition for the editors.
+func runSchema(args []string) error {
+	flags := flag.NewFlagSet("schema", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	_, err := os.Stdout.Write(forwarder.QuerySchema())
+	return err
+}
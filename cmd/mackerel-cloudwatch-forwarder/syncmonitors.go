@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	forwarder "github.com/shogo82148/mackerel-cloudwatch-forwarder"
+)
+
+// runSyncMonitors runs the "sync-monitors" subcommand.
+// It creates or updates the monitors on Mackerel of the queries with the "monitor" rules.
+// The API key is configured by the same environment values as the Lambda function.
+func runSyncMonitors(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("sync-monitors", flag.ContinueOnError)
+	source := flags.String("f", "", "the query definition file")
+	dryRun := flags.Bool("dry-run", false, "print the monitors without syncing them")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *source == "" {
+		return errors.New("usage: sync-monitors -f query-file [options]")
+	}
+
+	data, err := forwarder.LoadQueryFile(*source)
+	if err != nil {
+		return err
+	}
+	queries, err := forwarder.ParseQueries(data)
+	if err != nil {
+		return err
+	}
+	monitors, err := forwarder.GenerateMonitors(queries)
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(monitors)
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return err
+	}
+	f := &forwarder.Forwarder{
+		APIURL: os.Getenv("MACKEREL_APIURL"),
+		Config: cfg,
+	}
+	return f.SyncMonitors(ctx, monitors)
+}
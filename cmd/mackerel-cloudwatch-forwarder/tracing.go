@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"go.opentelemetry.io/contrib/propagators/aws/xray"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// setupTracing configures OpenTelemetry to export the spans of the forwarder by OTLP over HTTP,
+// if OTEL_EXPORTER_OTLP_ENDPOINT or OTEL_EXPORTER_OTLP_TRACES_ENDPOINT is set,
+// e.g. to the collector of AWS Distro for OpenTelemetry that sends them to AWS X-Ray.
+// The trace IDs are compatible with X-Ray, and the sampling decision of AWS Lambda is respected.
+// It returns the function that flushes the spans, which is called after each invocation.
+func setupTracing(ctx context.Context) func(context.Context) {
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return func(context.Context) {}
+	}
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		slog.Error("failed to configure the trace exporter, tracing is disabled", "error", err.Error())
+		return func(context.Context) {}
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithIDGenerator(xray.NewIDGenerator()),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(xray.Propagator{}, propagation.TraceContext{}))
+	return func(ctx context.Context) {
+		if err := tp.ForceFlush(ctx); err != nil {
+			slog.Error("failed to flush the spans", "error", err.Error())
+		}
+	}
+}
+
+// flushHandler flushes the spans after each invocation,
+// because AWS Lambda freezes the execution environment between the invocations.
+type flushHandler struct {
+	handler lambda.Handler
+	flush   func(context.Context)
+}
+
+func withFlush(handler any, flush func(context.Context)) lambda.Handler {
+	return flushHandler{handler: lambda.NewHandler(handler), flush: flush}
+}
+
+func (h flushHandler) Invoke(ctx context.Context, payload []byte) ([]byte, error) {
+	defer h.flush(context.WithoutCancel(ctx))
+	return h.handler.Invoke(ctx, payload)
+}
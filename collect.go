@@ -0,0 +1,223 @@
+package forwarder
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// MetricSink receives the data points collected by CollectMetrics.
+// The data points are posted to Mackerel by another handler, e.g. PublishMetrics for SQS and ForwardKinesis for Kinesis,
+// so that the heavy fleets can scale and retry fetching and publishing independently.
+type MetricSink interface {
+	// PutDatapoints sends the data points.
+	PutDatapoints(ctx context.Context, points []Datapoint) error
+}
+
+// the limits of Amazon SQS.
+const (
+	// sqsMaxMessageSize is the maximum size of a message, and the total size of a batch.
+	sqsMaxMessageSize = 256 * 1024
+
+	// sqsMaxBatchEntries is the maximum number of the messages in a batch.
+	sqsMaxBatchEntries = 10
+)
+
+// SQSMetricSink sends the data points to an Amazon SQS queue.
+// Each message body is a JSON array of Datapoint, which PublishMetrics consumes.
+type SQSMetricSink struct {
+	QueueURL string
+	Client   SQSAPI
+}
+
+var _ MetricSink = (*SQSMetricSink)(nil)
+
+// PutDatapoints implements MetricSink.
+func (s *SQSMetricSink) PutDatapoints(ctx context.Context, points []Datapoint) error {
+	bodies, err := encodeMessageBodies(points, sqsMaxMessageSize)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	var entries []types.SendMessageBatchRequestEntry
+	var size int
+	flush := func() {
+		if len(entries) == 0 {
+			return
+		}
+		if err := s.sendBatch(ctx, entries); err != nil {
+			errs = append(errs, err)
+		}
+		entries, size = nil, 0
+	}
+	for _, body := range bodies {
+		if len(entries) >= sqsMaxBatchEntries || size+len(body) > sqsMaxMessageSize {
+			flush()
+		}
+		entries = append(entries, types.SendMessageBatchRequestEntry{
+			Id:          aws.String(strconv.Itoa(len(entries))),
+			MessageBody: aws.String(body),
+		})
+		size += len(body)
+	}
+	flush()
+	return errors.Join(errs...)
+}
+
+func (s *SQSMetricSink) sendBatch(ctx context.Context, entries []types.SendMessageBatchRequestEntry) error {
+	resp, err := s.Client.SendMessageBatch(ctx, &sqs.SendMessageBatchInput{
+		QueueUrl: aws.String(s.QueueURL),
+		Entries:  entries,
+	})
+	if err != nil {
+		return fmt.Errorf("forwarder: failed to send the data points to %s: %w", s.QueueURL, err)
+	}
+	if len(resp.Failed) > 0 {
+		f := resp.Failed[0]
+		return fmt.Errorf("forwarder: failed to send %d of %d messages to %s: %s: %s",
+			len(resp.Failed), len(entries), s.QueueURL, aws.ToString(f.Code), aws.ToString(f.Message))
+	}
+	return nil
+}
+
+// encodeMessageBodies encodes the data points into JSON arrays whose sizes are at most maxSize bytes.
+func encodeMessageBodies(points []Datapoint, maxSize int) ([]string, error) {
+	var bodies []string
+	var buf bytes.Buffer
+	for _, p := range points {
+		data, err := json.Marshal(p)
+		if err != nil {
+			return nil, err
+		}
+		// the brackets or the comma are added around the data point.
+		if len(data)+2 > maxSize {
+			return nil, fmt.Errorf("forwarder: the data point %s is too large to send", p.Name)
+		}
+		if buf.Len() > 0 && buf.Len()+len(data)+2 > maxSize {
+			buf.WriteByte(']')
+			bodies = append(bodies, buf.String())
+			buf.Reset()
+		}
+		if buf.Len() == 0 {
+			buf.WriteByte('[')
+		} else {
+			buf.WriteByte(',')
+		}
+		buf.Write(data)
+	}
+	if buf.Len() > 0 {
+		buf.WriteByte(']')
+		bodies = append(bodies, buf.String())
+	}
+	return bodies, nil
+}
+
+// metricSink returns the sink for CollectMetrics.
+// If Sink is nil, the SQS queue of the FORWARD_COLLECT_QUEUE_URL environment value is used.
+// It returns nil if neither is configured.
+func (f *Forwarder) metricSink() MetricSink {
+	if f.Sink != nil {
+		return f.Sink
+	}
+	u := f.env().CollectQueueURL
+	if u == "" {
+		return nil
+	}
+	return &SQSMetricSink{
+		QueueURL: u,
+		Client:   f.sqs(),
+	}
+}
+
+// CollectMetrics is the fetch phase of ForwardMetrics.
+// It fetches the metrics of the queries, and sends the data points to the sink instead of posting them to Mackerel.
+// See MetricSink for the publish phase.
+//
+// The pending metrics are not kept, because the sink and its consumer take care of retrying.
+// In the dry run, the metrics are logged instead of sending.
+func (f *Forwarder) CollectMetrics(ctx context.Context, data json.RawMessage) error {
+	ctx, cancel := f.invocationContext(ctx)
+	defer cancel()
+	ctx = f.withInvocationOverrides(ctx, data)
+
+	sink := f.metricSink()
+	if sink == nil && !f.dryRun(ctx) {
+		return errors.New("forwarder: the metric sink is not configured")
+	}
+
+	at := windowTime(data, f.now())
+	_, query, err := f.loadQueries(ctx, data)
+	if err != nil {
+		return err
+	}
+
+	start, end := f.timeWindow(ctx, at)
+	fctx := &forwardContext{
+		forwarder: f,
+		start:     start,
+		end:       end,
+	}
+	fetchCtx, cancel := f.fetchContext(ctx)
+	err = fctx.getMetricsData(fetchCtx, query)
+	cancel()
+	// note: do not check error here.
+	// because we need to send the metrics that are fetched successfully.
+
+	fctx.normalizeMetrics(ctx)
+	if f.dryRun(ctx) {
+		fctx.logMetrics(ctx)
+		return err
+	}
+
+	points := fctx.datapoints()
+	if len(points) == 0 {
+		return err
+	}
+	if serr := sink.PutDatapoints(ctx, points); serr != nil {
+		err = errors.Join(err, serr)
+	}
+	if err != nil {
+		f.logger().ErrorContext(ctx, "failed to collect the metrics", "error", err.Error())
+	}
+	return err
+}
+
+// PublishMetrics is the publish phase of ForwardMetrics.
+// It posts the data points in the SQS messages that CollectMetrics sends to Mackerel.
+// Each message body contains the data points in JSON, see Datapoint for the format.
+//
+// The messages that have invalid data are skipped with warnings.
+// The messages that failed to post are reported as the batch item failures,
+// so SQS redelivers them. Enable ReportBatchItemFailures of the event source mapping to use it.
+func (f *Forwarder) PublishMetrics(ctx context.Context, event *events.SQSEvent) (*events.SQSEventResponse, error) {
+	ctx, cancel := f.invocationContext(ctx)
+	defer cancel()
+
+	records := make([]ingestRecord, 0, len(event.Records))
+	for _, msg := range event.Records {
+		records = append(records, ingestRecord{
+			ID:   msg.MessageId,
+			Data: []byte(msg.Body),
+		})
+	}
+	_, failed := f.ingest(ctx, records)
+
+	resp := &events.SQSEventResponse{
+		BatchItemFailures: []events.SQSBatchItemFailure{},
+	}
+	for _, id := range failed {
+		resp.BatchItemFailures = append(resp.BatchItemFailures, events.SQSBatchItemFailure{
+			ItemIdentifier: id,
+		})
+	}
+	return resp, nil
+}
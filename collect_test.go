@@ -0,0 +1,185 @@
+package forwarder
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/google/go-cmp/cmp"
+)
+
+// fakeSQS records the message bodies.
+type fakeSQS struct {
+	batches [][]string
+}
+
+func (s *fakeSQS) SendMessageBatch(ctx context.Context, params *sqs.SendMessageBatchInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageBatchOutput, error) {
+	var bodies []string
+	for _, e := range params.Entries {
+		bodies = append(bodies, aws.ToString(e.MessageBody))
+	}
+	s.batches = append(s.batches, bodies)
+	return &sqs.SendMessageBatchOutput{}, nil
+}
+
+func TestEncodeMessageBodies(t *testing.T) {
+	points := []Datapoint{
+		{Service: "foo", Name: "custom.a", Time: 1234567860, Value: 1},
+		{Service: "foo", Name: "custom.b", Time: 1234567860, Value: 2},
+		{Service: "foo", Name: "custom.c", Time: 1234567860, Value: 3},
+	}
+	bodies, err := encodeMessageBodies(points, 140)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bodies) != 2 {
+		t.Fatalf("want 2 bodies, got %d: %v", len(bodies), bodies)
+	}
+
+	var got []Datapoint
+	for _, body := range bodies {
+		if len(body) > 140 {
+			t.Errorf("the body is too large: %d bytes", len(body))
+		}
+		var p []Datapoint
+		if err := json.Unmarshal([]byte(body), &p); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, p...)
+	}
+	if diff := cmp.Diff(points, got); diff != "" {
+		t.Errorf("datapoints mismatch: (-want/+got):\n%s", diff)
+	}
+
+	if _, err := encodeMessageBodies(points, 10); err == nil {
+		t.Error("want an error, got nil")
+	}
+}
+
+func TestSQSMetricSink(t *testing.T) {
+	svc := &fakeSQS{}
+	sink := &SQSMetricSink{QueueURL: "https://sqs.ap-northeast-1.amazonaws.com/123456789012/metrics", Client: svc}
+
+	// a data point is about 70 bytes, so a message has about 3700 data points.
+	points := make([]Datapoint, 0, 50000)
+	for i := range cap(points) {
+		points = append(points, Datapoint{Service: "foo", Name: fmt.Sprintf("custom.m%d", i), Time: 1234567860, Value: 1})
+	}
+	if err := sink.PutDatapoints(context.Background(), points); err != nil {
+		t.Fatal(err)
+	}
+
+	var cnt int
+	for _, batch := range svc.batches {
+		if len(batch) > sqsMaxBatchEntries {
+			t.Errorf("too many entries: %d", len(batch))
+		}
+		var size int
+		for _, body := range batch {
+			size += len(body)
+			var p []Datapoint
+			if err := json.Unmarshal([]byte(body), &p); err != nil {
+				t.Fatal(err)
+			}
+			cnt += len(p)
+		}
+		if size > sqsMaxMessageSize {
+			t.Errorf("the batch is too large: %d bytes", size)
+		}
+	}
+	if cnt != len(points) {
+		t.Errorf("want %d data points, got %d", len(points), cnt)
+	}
+}
+
+func TestCollectMetrics(t *testing.T) {
+	svc := &fakeSQS{}
+	now := time.Unix(1234567890, 0)
+	f := &Forwarder{
+		svccloudwatch: &fakeCloudWatch{
+			values: map[string][]float64{
+				"m1": {1},
+				"m2": {2},
+			},
+		},
+		Sink: &SQSMetricSink{QueueURL: "https://sqs.ap-northeast-1.amazonaws.com/123456789012/metrics", Client: svc},
+		Now:  func() time.Time { return now },
+	}
+	data := []byte(`[
+		{"service":"foo","name":"ec2.cpu","metric":["AWS/EC2","CPUUtilization"],"stat":"Average"},
+		{"host":"host-abc","name":"rds.cpu","metric":["AWS/RDS","CPUUtilization"],"stat":"Average"}
+	]`)
+	if err := f.CollectMetrics(context.Background(), data); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(svc.batches) != 1 || len(svc.batches[0]) != 1 {
+		t.Fatalf("want a message, got %v", svc.batches)
+	}
+	got, err := parseDatapoints([]byte(svc.batches[0][0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	start, _ := f.timeWindow(context.Background(), now)
+	want := []Datapoint{
+		{HostID: "host-abc", Name: "rds.cpu", Time: start.Unix(), Value: 2},
+		{Service: "foo", Name: "ec2.cpu", Time: start.Unix(), Value: 1},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("datapoints mismatch: (-want/+got):\n%s", diff)
+	}
+}
+
+func TestCollectMetrics_NoSink(t *testing.T) {
+	t.Setenv("FORWARD_COLLECT_QUEUE_URL", "")
+	f := &Forwarder{}
+	if err := f.CollectMetrics(context.Background(), []byte(`[]`)); err == nil || !strings.Contains(err.Error(), "sink") {
+		t.Errorf("want the error of the missing sink, got %v", err)
+	}
+}
+
+func TestPublishMetrics(t *testing.T) {
+	var posted []string
+	client := newTestMackerelClient(t, http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		posted = append(posted, r.URL.Path)
+		if r.URL.Path == "/api/v0/services/bar/tsdb" {
+			rw.WriteHeader(http.StatusForbidden)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+	}))
+	f := &Forwarder{
+		svcmackerel: client,
+	}
+	now := time.Now().Truncate(time.Minute).Unix()
+	event := &events.SQSEvent{
+		Records: []events.SQSMessage{
+			{MessageId: "1", Body: fmt.Sprintf(`[{"service":"foo","name":"custom.a","time":%d,"value":1}]`, now)},
+			{MessageId: "2", Body: fmt.Sprintf(`[{"service":"bar","name":"custom.a","time":%d,"value":1}]`, now)},
+			{MessageId: "3", Body: `invalid`},
+		},
+	}
+
+	resp, err := f.PublishMetrics(context.Background(), event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &events.SQSEventResponse{
+		BatchItemFailures: []events.SQSBatchItemFailure{
+			{ItemIdentifier: "2"},
+		},
+	}
+	if diff := cmp.Diff(want, resp); diff != "" {
+		t.Errorf("response mismatch: (-want/+got):\n%s", diff)
+	}
+	if len(posted) == 0 {
+		t.Error("no metrics are posted")
+	}
+}
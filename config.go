@@ -0,0 +1,447 @@
+package forwarder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is the configuration of the forwarder from the environment values.
+// The zero values mean that the environment values are not set, and the defaults are used.
+// The fields of Forwarder take precedence over it.
+type Config struct {
+	// APIURL is MACKEREL_APIURL, the base URL of the Mackerel API.
+	APIURL string
+
+	// APIKey is MACKEREL_APIKEY.
+	APIKey string
+
+	// APIKeyParameter is MACKEREL_APIKEY_PARAMETER.
+	APIKeyParameter string
+
+	// APIKeyWithDecrypt is MACKEREL_APIKEY_WITH_DECRYPT.
+	APIKeyWithDecrypt bool
+
+	// APIKeySecret is MACKEREL_APIKEY_SECRET.
+	APIKeySecret string
+
+	// APIKeySecretKey is MACKEREL_APIKEY_SECRET_KEY.
+	APIKeySecretKey string
+
+	// MackerelProxy is FORWARD_MACKEREL_PROXY.
+	MackerelProxy string
+
+	// Handler is FORWARD_HANDLER, the handler of the Lambda function, e.g. "metrics" and "sqs".
+	Handler string
+
+	// DebugHandlers is FORWARD_DEBUG_HANDLERS.
+	DebugHandlers bool
+
+	// LogLevel is FORWARD_LOG_LEVEL, e.g. "debug" and "info".
+	LogLevel string
+
+	// AutoRegisterHosts is FORWARD_AUTO_REGISTER_HOSTS.
+	AutoRegisterHosts bool
+
+	// AutoCreateService is FORWARD_AUTO_CREATE_SERVICE.
+	AutoCreateService bool
+
+	// SyncHostMetadata is FORWARD_SYNC_HOST_METADATA.
+	SyncHostMetadata bool
+
+	// ValidateHosts is FORWARD_VALIDATE_HOSTS.
+	ValidateHosts bool
+
+	// HostListTTL is FORWARD_HOST_LIST_TTL.
+	HostListTTL time.Duration
+
+	// RetiredHostTTL is FORWARD_RETIRED_HOST_TTL.
+	RetiredHostTTL time.Duration
+
+	// DiscoveryCacheTTL is FORWARD_DISCOVERY_CACHE_TTL.
+	DiscoveryCacheTTL time.Duration
+
+	// PersistDiscoveryCache is FORWARD_PERSIST_DISCOVERY_CACHE.
+	PersistDiscoveryCache bool
+
+	// LookupLatest is FORWARD_LOOKUP_LATEST.
+	LookupLatest bool
+
+	// VerifyMetrics is FORWARD_VERIFY_METRICS.
+	VerifyMetrics bool
+
+	// DryRun is FORWARD_DRY_RUN.
+	DryRun bool
+
+	// Strict is FORWARD_STRICT.
+	Strict bool
+
+	// SanitizeMetricNames is FORWARD_SANITIZE_METRIC_NAMES.
+	SanitizeMetricNames bool
+
+	// SkipAPIKeyVerification is FORWARD_SKIP_API_KEY_VERIFICATION.
+	SkipAPIKeyVerification bool
+
+	// Lookback is FORWARD_LOOKBACK.
+	Lookback time.Duration
+
+	// MackerelRPS is FORWARD_MACKEREL_RPS.
+	MackerelRPS float64
+
+	// PublishConcurrency is FORWARD_PUBLISH_CONCURRENCY.
+	PublishConcurrency int
+
+	// RetryMargin is FORWARD_RETRY_MARGIN.
+	RetryMargin time.Duration
+
+	// MaxPendingMetrics is FORWARD_MAX_PENDING_METRICS.
+	MaxPendingMetrics int
+
+	// MaxMetricAge is FORWARD_MAX_METRIC_AGE.
+	MaxMetricAge time.Duration
+
+	// TimeoutMargin is FORWARD_TIMEOUT_MARGIN.
+	TimeoutMargin string
+
+	// FetchBudget is FORWARD_FETCH_BUDGET.
+	FetchBudget string
+
+	// QueryFile is FORWARD_QUERY_FILE.
+	QueryFile string
+
+	// StateStore is FORWARD_STATE_STORE.
+	StateStore string
+
+	// StateKMSKey is FORWARD_STATE_KMS_KEY.
+	StateKMSKey string
+
+	// StateKMSMigrate is FORWARD_STATE_KMS_MIGRATE.
+	StateKMSMigrate bool
+
+	// Interval is FORWARD_INTERVAL.
+	Interval time.Duration
+
+	// IdempotencyTable is FORWARD_IDEMPOTENCY_TABLE.
+	IdempotencyTable string
+
+	// CollectQueueURL is FORWARD_COLLECT_QUEUE_URL.
+	CollectQueueURL string
+
+	// PrometheusRemoteWriteURL is FORWARD_PROMETHEUS_REMOTE_WRITE_URL.
+	PrometheusRemoteWriteURL string
+
+	// PrometheusRemoteWriteSigV4 is FORWARD_PROMETHEUS_REMOTE_WRITE_SIGV4.
+	PrometheusRemoteWriteSigV4 bool
+
+	// OTLPEndpoint is FORWARD_OTLP_ENDPOINT.
+	OTLPEndpoint string
+
+	// OTLPHeaders is FORWARD_OTLP_HEADERS.
+	OTLPHeaders string
+
+	// Endpoints are FORWARD_ENDPOINT_<SERVICE>, keyed by the service names in lower case.
+	Endpoints map[string]string
+
+	// UseFIPSEndpoint is FORWARD_USE_FIPS_ENDPOINT.
+	UseFIPSEndpoint bool
+
+	// UseDualStackEndpoint is FORWARD_USE_DUALSTACK_ENDPOINT.
+	UseDualStackEndpoint bool
+}
+
+// EnvError is the error of an invalid environment value.
+type EnvError struct {
+	// Name is the name of the environment value, e.g. "FORWARD_LOOKBACK".
+	Name string
+
+	// Value is the invalid value.
+	Value string
+
+	// Err is the reason.
+	Err error
+
+	desc string // the description of the setting, e.g. "lookback"
+}
+
+func (e *EnvError) Error() string {
+	return fmt.Sprintf("forwarder: invalid %s %s=%q: %v", e.desc, e.Name, e.Value, e.Err)
+}
+
+func (e *EnvError) Unwrap() error {
+	return e.Err
+}
+
+// LoadConfigFromEnv reads the environment values of the forwarder into Config.
+// It reports all the problems at once:
+// the invalid values, the unknown FORWARD_* and MACKEREL_APIKEY_* environment values that are likely typos,
+// and the conflicting settings.
+// The config is returned even if there are problems, so that the caller can choose to go on with warnings.
+// The invalid values are left zero.
+func LoadConfigFromEnv() (*Config, error) {
+	return loadConfig(os.LookupEnv, os.Environ())
+}
+
+// loadConfig reads the environment values into Config.
+// The invalid values are left zero.
+func loadConfig(lookup func(string) (string, bool), environ []string) (*Config, error) {
+	l := &envLoader{lookup: lookup, known: map[string]bool{}}
+	cfg := &Config{
+		APIURL:                     l.string("MACKEREL_APIURL"),
+		APIKey:                     l.string("MACKEREL_APIKEY"),
+		APIKeyParameter:            l.string("MACKEREL_APIKEY_PARAMETER"),
+		APIKeyWithDecrypt:          l.bool("MACKEREL_APIKEY_WITH_DECRYPT"),
+		APIKeySecret:               l.string("MACKEREL_APIKEY_SECRET"),
+		APIKeySecretKey:            l.string("MACKEREL_APIKEY_SECRET_KEY"),
+		MackerelProxy:              l.proxy("FORWARD_MACKEREL_PROXY"),
+		Handler:                    l.string("FORWARD_HANDLER"),
+		DebugHandlers:              l.bool("FORWARD_DEBUG_HANDLERS"),
+		LogLevel:                   l.string("FORWARD_LOG_LEVEL"),
+		AutoRegisterHosts:          l.bool("FORWARD_AUTO_REGISTER_HOSTS"),
+		AutoCreateService:          l.bool("FORWARD_AUTO_CREATE_SERVICE"),
+		SyncHostMetadata:           l.bool("FORWARD_SYNC_HOST_METADATA"),
+		ValidateHosts:              l.bool("FORWARD_VALIDATE_HOSTS"),
+		HostListTTL:                l.duration("FORWARD_HOST_LIST_TTL", "host list ttl"),
+		RetiredHostTTL:             l.duration("FORWARD_RETIRED_HOST_TTL", "retired host ttl"),
+		DiscoveryCacheTTL:          l.duration("FORWARD_DISCOVERY_CACHE_TTL", "discovery cache ttl"),
+		PersistDiscoveryCache:      l.bool("FORWARD_PERSIST_DISCOVERY_CACHE"),
+		LookupLatest:               l.bool("FORWARD_LOOKUP_LATEST"),
+		VerifyMetrics:              l.bool("FORWARD_VERIFY_METRICS"),
+		DryRun:                     l.bool("FORWARD_DRY_RUN"),
+		Strict:                     l.bool("FORWARD_STRICT"),
+		SanitizeMetricNames:        l.bool("FORWARD_SANITIZE_METRIC_NAMES"),
+		SkipAPIKeyVerification:     l.bool("FORWARD_SKIP_API_KEY_VERIFICATION"),
+		Lookback:                   l.duration("FORWARD_LOOKBACK", "lookback"),
+		Interval:                   l.duration("FORWARD_INTERVAL", "interval"),
+		MackerelRPS:                l.float("FORWARD_MACKEREL_RPS", "rps"),
+		PublishConcurrency:         l.int("FORWARD_PUBLISH_CONCURRENCY", "publish concurrency"),
+		RetryMargin:                l.duration("FORWARD_RETRY_MARGIN", "retry margin"),
+		MaxPendingMetrics:          l.int("FORWARD_MAX_PENDING_METRICS", "max pending metrics"),
+		MaxMetricAge:               l.duration("FORWARD_MAX_METRIC_AGE", "max metric age"),
+		TimeoutMargin:              l.budget("FORWARD_TIMEOUT_MARGIN"),
+		FetchBudget:                l.budget("FORWARD_FETCH_BUDGET"),
+		QueryFile:                  l.string("FORWARD_QUERY_FILE"),
+		StateStore:                 l.string("FORWARD_STATE_STORE"),
+		StateKMSKey:                l.string("FORWARD_STATE_KMS_KEY"),
+		StateKMSMigrate:            l.bool("FORWARD_STATE_KMS_MIGRATE"),
+		IdempotencyTable:           l.string("FORWARD_IDEMPOTENCY_TABLE"),
+		CollectQueueURL:            l.string("FORWARD_COLLECT_QUEUE_URL"),
+		PrometheusRemoteWriteURL:   l.string("FORWARD_PROMETHEUS_REMOTE_WRITE_URL"),
+		PrometheusRemoteWriteSigV4: l.bool("FORWARD_PROMETHEUS_REMOTE_WRITE_SIGV4"),
+		OTLPEndpoint:               l.string("FORWARD_OTLP_ENDPOINT"),
+		OTLPHeaders:                l.string("FORWARD_OTLP_HEADERS"),
+		UseFIPSEndpoint:            l.bool("FORWARD_USE_FIPS_ENDPOINT"),
+		UseDualStackEndpoint:       l.bool("FORWARD_USE_DUALSTACK_ENDPOINT"),
+	}
+	for _, service := range endpointServices {
+		if u := l.string("FORWARD_ENDPOINT_" + strings.ToUpper(service)); u != "" {
+			if cfg.Endpoints == nil {
+				cfg.Endpoints = make(map[string]string)
+			}
+			cfg.Endpoints[service] = u
+		}
+	}
+
+	l.checkUnknown(environ)
+	l.checkConflicts(cfg)
+	return cfg, errors.Join(l.errs...)
+}
+
+// envLoader parses the environment values, and collects the errors.
+type envLoader struct {
+	lookup func(string) (string, bool)
+	known  map[string]bool
+	errs   []error
+}
+
+func (l *envLoader) string(name string) string {
+	l.known[name] = true
+	v, _ := l.lookup(name)
+	return v
+}
+
+// bool reports whether the environment value is set.
+// Any non-empty values enable the setting, so "false" and "0" are reported as errors to avoid the confusion.
+func (l *envLoader) bool(name string) bool {
+	v := l.string(name)
+	if b, err := strconv.ParseBool(v); err == nil && !b {
+		l.errs = append(l.errs, fmt.Errorf("forwarder: %s=%q enables the setting, unset it to disable", name, v))
+	}
+	return v != ""
+}
+
+func (l *envLoader) duration(name, desc string) time.Duration {
+	v := l.string(name)
+	if v == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(v)
+	if err == nil && d <= 0 {
+		err = errors.New("must be positive")
+	}
+	if err != nil {
+		l.errs = append(l.errs, &EnvError{Name: name, Value: v, Err: err, desc: desc})
+		return 0
+	}
+	return d
+}
+
+func (l *envLoader) int(name, desc string) int {
+	v := l.string(name)
+	if v == "" {
+		return 0
+	}
+	n, err := strconv.Atoi(v)
+	if err == nil && n <= 0 {
+		err = errors.New("must be positive")
+	}
+	if err != nil {
+		l.errs = append(l.errs, &EnvError{Name: name, Value: v, Err: err, desc: desc})
+		return 0
+	}
+	return n
+}
+
+func (l *envLoader) float(name, desc string) float64 {
+	v := l.string(name)
+	if v == "" {
+		return 0
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err == nil && f <= 0 {
+		err = errors.New("must be positive")
+	}
+	if err != nil {
+		l.errs = append(l.errs, &EnvError{Name: name, Value: v, Err: err, desc: desc})
+		return 0
+	}
+	return f
+}
+
+// budget parses the percentage or the duration for the time budgets.
+func (l *envLoader) budget(name string) string {
+	v := l.string(name)
+	if v == "" {
+		return ""
+	}
+	if _, err := parseDurationOrPercentage(v, time.Minute); err != nil {
+		l.errs = append(l.errs, &EnvError{Name: name, Value: v, Err: err, desc: "time budget"})
+		return ""
+	}
+	return v
+}
+
+func (l *envLoader) proxy(name string) string {
+	v := l.string(name)
+	if v == "" {
+		return ""
+	}
+	if _, err := parseProxy(v); err != nil {
+		l.errs = append(l.errs, &EnvError{Name: name, Value: v, Err: err, desc: "proxy"})
+		return ""
+	}
+	return v
+}
+
+// checkUnknown reports the environment values of the forwarder that are not known.
+func (l *envLoader) checkUnknown(environ []string) {
+	known := make([]string, 0, len(l.known))
+	for name := range l.known {
+		known = append(known, name)
+	}
+	slices.Sort(known)
+
+	for _, kv := range environ {
+		name, _, _ := strings.Cut(kv, "=")
+		if l.known[name] || !(strings.HasPrefix(name, "FORWARD_") || strings.HasPrefix(name, "MACKEREL_APIKEY_")) {
+			continue
+		}
+		msg := fmt.Sprintf("forwarder: unknown environment value %s", name)
+		if suggestion := closestName(name, known); suggestion != "" {
+			msg += fmt.Sprintf(", did you mean %s?", suggestion)
+		}
+		l.errs = append(l.errs, errors.New(msg))
+	}
+}
+
+// checkConflicts reports the settings that can't be used together.
+// The API keys are not reported, because they are resolved in the documented priority;
+// see (*Forwarder).keyProvider.
+func (l *envLoader) checkConflicts(cfg *Config) {
+	requires := func(cond bool, name, required string) {
+		if cond {
+			l.errs = append(l.errs, fmt.Errorf("forwarder: %s is set, but %s is not set", name, required))
+		}
+	}
+	requires(cfg.APIKeySecretKey != "" && cfg.APIKeySecret == "", "MACKEREL_APIKEY_SECRET_KEY", "MACKEREL_APIKEY_SECRET")
+	requires(cfg.StateKMSKey != "" && cfg.StateStore == "", "FORWARD_STATE_KMS_KEY", "FORWARD_STATE_STORE")
+	requires(cfg.StateKMSMigrate && cfg.StateKMSKey == "", "FORWARD_STATE_KMS_MIGRATE", "FORWARD_STATE_KMS_KEY")
+	requires(cfg.PrometheusRemoteWriteSigV4 && cfg.PrometheusRemoteWriteURL == "", "FORWARD_PROMETHEUS_REMOTE_WRITE_SIGV4", "FORWARD_PROMETHEUS_REMOTE_WRITE_URL")
+	requires(cfg.OTLPHeaders != "" && cfg.OTLPEndpoint == "", "FORWARD_OTLP_HEADERS", "FORWARD_OTLP_ENDPOINT")
+}
+
+// closestName returns the name that is the most similar to name, or an empty string if there is no similar one.
+func closestName(name string, candidates []string) string {
+	best, bestDist := "", 4 // the typos farther than it are not suggested
+	for _, c := range candidates {
+		if d := editDistance(name, c); d < bestDist {
+			best, bestDist = c, d
+		}
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+// env returns the configuration from the environment values.
+// If EnvConfig is nil, it is loaded on the first use,
+// and the problems are logged as warnings, because the invocations shouldn't fail on them.
+func (f *Forwarder) env() *Config {
+	if f.EnvConfig != nil {
+		return f.EnvConfig
+	}
+	f.envOnce.Do(func() {
+		cfg, err := loadConfig(os.LookupEnv, os.Environ())
+		f.envConfig = cfg
+		if err == nil {
+			return
+		}
+		ctx := context.Background()
+		for _, err := range err.(interface{ Unwrap() []error }).Unwrap() {
+			var envErr *EnvError
+			if errors.As(err, &envErr) {
+				f.logger().WarnContext(ctx, "invalid "+envErr.desc+", use the default",
+					"name", envErr.Name,
+					"input", envErr.Value,
+					"error", envErr.Err.Error(),
+				)
+				continue
+			}
+			f.logger().WarnContext(ctx, "invalid environment values", "error", err.Error())
+		}
+	})
+	return f.envConfig
+}
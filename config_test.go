@@ -0,0 +1,168 @@
+package forwarder
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testLookup(env map[string]string) (func(string) (string, bool), []string) {
+	environ := make([]string, 0, len(env))
+	for k, v := range env {
+		environ = append(environ, k+"="+v)
+	}
+	return func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}, environ
+}
+
+func TestLoadConfig(t *testing.T) {
+	lookup, environ := testLookup(map[string]string{
+		"MACKEREL_APIKEY_PARAMETER":   "/mackerel/api-key",
+		"FORWARD_LOOKBACK":            "5m",
+		"FORWARD_MACKEREL_RPS":        "2.5",
+		"FORWARD_PUBLISH_CONCURRENCY": "4",
+		"FORWARD_FETCH_BUDGET":        "80%",
+		"FORWARD_DRY_RUN":             "1",
+		"FORWARD_ENDPOINT_CLOUDWATCH": "http://localhost:4566",
+		"PATH":                        "/usr/bin",
+	})
+	cfg, err := loadConfig(lookup, environ)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.APIKeyParameter != "/mackerel/api-key" {
+		t.Errorf("unexpected api key parameter: %q", cfg.APIKeyParameter)
+	}
+	if cfg.Lookback != 5*time.Minute {
+		t.Errorf("unexpected lookback: %s", cfg.Lookback)
+	}
+	if cfg.MackerelRPS != 2.5 {
+		t.Errorf("unexpected rps: %f", cfg.MackerelRPS)
+	}
+	if cfg.PublishConcurrency != 4 {
+		t.Errorf("unexpected publish concurrency: %d", cfg.PublishConcurrency)
+	}
+	if cfg.FetchBudget != "80%" {
+		t.Errorf("unexpected fetch budget: %q", cfg.FetchBudget)
+	}
+	if !cfg.DryRun {
+		t.Error("dry run is not enabled")
+	}
+	if cfg.Endpoints["cloudwatch"] != "http://localhost:4566" {
+		t.Errorf("unexpected endpoints: %v", cfg.Endpoints)
+	}
+}
+
+func TestLoadConfig_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want string
+	}{
+		{
+			name: "invalid duration",
+			env:  map[string]string{"FORWARD_LOOKBACK": "5"},
+			want: `forwarder: invalid lookback FORWARD_LOOKBACK="5"`,
+		},
+		{
+			name: "negative number",
+			env:  map[string]string{"FORWARD_PUBLISH_CONCURRENCY": "-1"},
+			want: "must be positive",
+		},
+		{
+			name: "invalid budget",
+			env:  map[string]string{"FORWARD_TIMEOUT_MARGIN": "150%"},
+			want: "percentage out of range",
+		},
+		{
+			name: "disabled flag",
+			env:  map[string]string{"FORWARD_DRY_RUN": "false"},
+			want: "unset it to disable",
+		},
+		{
+			name: "typo",
+			env:  map[string]string{"FORWARD_LOOKBAK": "5m"},
+			want: "unknown environment value FORWARD_LOOKBAK, did you mean FORWARD_LOOKBACK?",
+		},
+		{
+			name: "unknown service",
+			env:  map[string]string{"FORWARD_ENDPOINT_EC2": "http://localhost:4566"},
+			want: "unknown environment value FORWARD_ENDPOINT_EC2",
+		},
+		{
+			name: "missing dependency",
+			env:  map[string]string{"FORWARD_STATE_KMS_KEY": "alias/forwarder"},
+			want: "FORWARD_STATE_KMS_KEY is set, but FORWARD_STATE_STORE is not set",
+		},
+		{
+			name: "migration without encryption",
+			env:  map[string]string{"FORWARD_STATE_STORE": "s3://bucket/state.json", "FORWARD_STATE_KMS_MIGRATE": "true"},
+			want: "FORWARD_STATE_KMS_MIGRATE is set, but FORWARD_STATE_KMS_KEY is not set",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := loadConfig(testLookup(tt.env))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("want the error %q, got %v", tt.want, err)
+			}
+			if cfg == nil {
+				t.Error("want the config even on errors, got nil")
+			}
+		})
+	}
+}
+
+func TestLoadConfigFromEnv(t *testing.T) {
+	t.Setenv("FORWARD_MAX_METRIC_AGE", "1h")
+	t.Setenv("FORWARD_RETIRED_HOST_TTL", "forever")
+	t.Setenv("MACKEREL_APIKEY_WITH_DECRYPT", "false")
+	cfg, err := LoadConfigFromEnv()
+	var envErr *EnvError
+	if !errors.As(err, &envErr) {
+		t.Fatalf("want EnvError, got %v", err)
+	}
+	if envErr.Name != "FORWARD_RETIRED_HOST_TTL" || envErr.Value != "forever" {
+		t.Errorf("unexpected error: %v", envErr)
+	}
+
+	// the config is returned with the problems, and the invalid values are left zero.
+	if cfg == nil {
+		t.Fatal("want the config, got nil")
+	}
+	if cfg.MaxMetricAge != time.Hour || cfg.RetiredHostTTL != 0 {
+		t.Errorf("unexpected config: max metric age %s, retired host ttl %s", cfg.MaxMetricAge, cfg.RetiredHostTTL)
+	}
+	// any non-empty values enable the setting, as the older versions do.
+	if !cfg.APIKeyWithDecrypt {
+		t.Error("want APIKeyWithDecrypt enabled")
+	}
+}
+
+func TestForwarder_EnvConfig(t *testing.T) {
+	t.Setenv("FORWARD_MAX_METRIC_AGE", "3h")
+
+	// EnvConfig takes precedence over the environment values.
+	f := &Forwarder{EnvConfig: &Config{MaxMetricAge: time.Hour}}
+	if got := f.maxMetricAge(context.Background()); got != time.Hour {
+		t.Errorf("want %s, got %s", time.Hour, got)
+	}
+
+	// the invalid values are warned once.
+	t.Setenv("FORWARD_PUBLISH_CONCURRENCY", "many")
+	var buf bytes.Buffer
+	f = &Forwarder{Logger: slog.New(slog.NewTextHandler(&buf, nil))}
+	if got := f.publishConcurrency(context.Background()); got != defaultPublishConcurrency {
+		t.Errorf("want %d, got %d", defaultPublishConcurrency, got)
+	}
+	f.publishConcurrency(context.Background())
+	if n := strings.Count(buf.String(), "invalid publish concurrency"); n != 1 {
+		t.Errorf("want a warning, got %d: %s", n, buf.String())
+	}
+}
@@ -0,0 +1,201 @@
+package forwarder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/costexplorer"
+	"github.com/aws/aws-sdk-go-v2/service/costexplorer/types"
+	phperjson "github.com/shogo82148/go-phper-json"
+)
+
+// CostQuery is a query for AWS Cost Explorer.
+type CostQuery struct {
+	// Service is the service name on Mackerel.
+	Service string `json:"service,omitempty"`
+
+	// Name is the metric name on Mackerel.
+	// If GroupBy is specified, the group key is appended to the name.
+	Name string `json:"name,omitempty"`
+
+	// GroupBy is the grouping of the cost.
+	// It is one of "SERVICE", "LINKED_ACCOUNT", and "TAG:<tag key>".
+	// If it is empty, the total cost is forwarded.
+	GroupBy string `json:"groupBy,omitempty"`
+
+	// Metric is the cost metric. The default is "UnblendedCost".
+	Metric string `json:"metric,omitempty"`
+}
+
+func (f *Forwarder) costexplorer() costexploreriface {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.svccostexplorer == nil {
+		// Cost Explorer API is available only in a region of each partition, e.g. us-east-1.
+		region := f.partition().costExplorerRegion
+		f.svccostexplorer = costexplorer.NewFromConfig(f.awsConfig(), func(o *costexplorer.Options) {
+			o.Region = region
+			f.resolveEndpoint("costexplorer", &o.BaseEndpoint, &o.EndpointOptions.UseFIPSEndpoint, &o.EndpointOptions.UseDualStackEndpoint)
+		})
+	}
+	return f.svccostexplorer
+}
+
+// ForwardCosts forwards the daily cost of AWS Cost Explorer to Mackerel.
+// It forwards the cost of the previous day (UTC), so it should be invoked once a day.
+// The cost is posted at the time of the invocation, because Mackerel rejects the data points older than the max metric age.
+// The queries that fail are skipped with warnings, and their errors are returned after posting the others.
+func (f *Forwarder) ForwardCosts(ctx context.Context, data json.RawMessage) error {
+	err := f.forwardCosts(ctx, data)
+	if err != nil {
+		f.logger().ErrorContext(ctx, "failed to forward the costs", "error", err.Error())
+	}
+	return err
+}
+
+func (f *Forwarder) forwardCosts(ctx context.Context, data json.RawMessage) error {
+	var query []*CostQuery
+	if err := phperjson.Unmarshal([]byte(data), &query); err != nil {
+		return fmt.Errorf("forwarder: failed to parse the input: %w", err)
+	}
+
+	client, err := f.mackerel(ctx)
+	if err != nil {
+		return fmt.Errorf("forwarder: failed to configure the mackerel client: %w", err)
+	}
+
+	now := f.now()
+	end := now.UTC().Truncate(24 * time.Hour)
+	start := end.Add(-24 * time.Hour)
+
+	var metrics serviceMetricsType
+	var fetchErrs []error
+	for i, q := range query {
+		ctx := withQueryIndex(ctx, i)
+		if q.Service == "" || q.Name == "" {
+			f.logger().WarnContext(ctx, "service name and metric name are required, skips")
+			continue
+		}
+		values, err := f.getCosts(ctx, q, start, end)
+		if err != nil {
+			f.logger().WarnContext(ctx, "failed to get the cost, skips", "error", err.Error())
+			fetchErrs = append(fetchErrs, &FetchError{Indexes: []int{i}, Err: err})
+			continue
+		}
+		for _, v := range values {
+			v.Time = now.Truncate(time.Minute).Unix()
+			metrics.Append(q.Service, v)
+		}
+	}
+
+	metrics = f.normalizeServiceMetrics(ctx, metrics)
+	f.dropStaleServiceMetrics(ctx, metrics, now)
+	for service, values := range metrics {
+		if err := client.PostServiceMetricValues(ctx, service, values); err != nil {
+			return errors.Join(append(fetchErrs, fmt.Errorf("forwarder: failed to post the cost of %s: %w", service, err))...)
+		}
+		f.logger().InfoContext(ctx, "succeed to post cost metrics",
+			"service", service,
+			"count", len(values),
+		)
+	}
+	return errors.Join(fetchErrs...)
+}
+
+// getCosts gets the cost between start and end.
+func (f *Forwarder) getCosts(ctx context.Context, q *CostQuery, start, end time.Time) ([]ServiceMetricValue, error) {
+	metric := q.Metric
+	if metric == "" {
+		metric = "UnblendedCost"
+	}
+	input := &costexplorer.GetCostAndUsageInput{
+		Granularity: types.GranularityDaily,
+		Metrics:     []string{metric},
+		TimePeriod: &types.DateInterval{
+			Start: aws.String(start.Format(time.DateOnly)),
+			End:   aws.String(end.Format(time.DateOnly)),
+		},
+	}
+	if q.GroupBy != "" {
+		group := types.GroupDefinition{
+			Type: types.GroupDefinitionTypeDimension,
+			Key:  aws.String(q.GroupBy),
+		}
+		if key, ok := strings.CutPrefix(q.GroupBy, "TAG:"); ok {
+			group.Type = types.GroupDefinitionTypeTag
+			group.Key = aws.String(key)
+		}
+		input.GroupBy = []types.GroupDefinition{group}
+	}
+
+	svc := f.costexplorer()
+	var ret []ServiceMetricValue
+	for {
+		resp, err := svc.GetCostAndUsage(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		for _, result := range resp.ResultsByTime {
+			t, err := time.Parse(time.DateOnly, aws.ToString(result.TimePeriod.Start))
+			if err != nil {
+				return nil, err
+			}
+			if q.GroupBy == "" {
+				v, err := parseCostAmount(result.Total[metric])
+				if err != nil {
+					return nil, err
+				}
+				ret = append(ret, ServiceMetricValue{
+					Name:  q.Name,
+					Time:  t.Unix(),
+					Value: v,
+				})
+				continue
+			}
+			for _, g := range result.Groups {
+				v, err := parseCostAmount(g.Metrics[metric])
+				if err != nil {
+					return nil, err
+				}
+				ret = append(ret, ServiceMetricValue{
+					Name:  q.Name + "." + sanitizeMetricNameElement(strings.Join(g.Keys, "_")),
+					Time:  t.Unix(),
+					Value: v,
+				})
+			}
+		}
+		if resp.NextPageToken == nil {
+			break
+		}
+		input.NextPageToken = resp.NextPageToken
+	}
+	return ret, nil
+}
+
+func parseCostAmount(v types.MetricValue) (float64, error) {
+	if v.Amount == nil {
+		return 0, nil
+	}
+	return strconv.ParseFloat(*v.Amount, 64)
+}
+
+// sanitizeMetricNameElement replaces the characters that are not allowed in Mackerel metric names with "_".
+func sanitizeMetricNameElement(s string) string {
+	return strings.Map(func(r rune) rune {
+		if isMetricNameChar(r) {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// isMetricNameChar reports whether r is allowed in an element of Mackerel metric names.
+func isMetricNameChar(r rune) bool {
+	return 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' || r == '-' || r == '_'
+}
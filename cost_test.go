@@ -0,0 +1,133 @@
+package forwarder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/costexplorer"
+	"github.com/aws/aws-sdk-go-v2/service/costexplorer/types"
+	"github.com/google/go-cmp/cmp"
+)
+
+type fakeCostExplorer struct {
+	t   *testing.T
+	err error
+}
+
+func (s *fakeCostExplorer) GetCostAndUsage(ctx context.Context, params *costexplorer.GetCostAndUsageInput, optFns ...func(*costexplorer.Options)) (*costexplorer.GetCostAndUsageOutput, error) {
+	if s.err != nil && len(params.GroupBy) > 0 {
+		return nil, s.err
+	}
+	result := types.ResultByTime{
+		TimePeriod: params.TimePeriod,
+		Total: map[string]types.MetricValue{
+			"UnblendedCost": {Amount: aws.String("12.5"), Unit: aws.String("USD")},
+		},
+	}
+	if len(params.GroupBy) > 0 {
+		if want, got := types.GroupDefinitionTypeTag, params.GroupBy[0].Type; want != got {
+			s.t.Errorf("unexpected group type: want %s, got %s", want, got)
+		}
+		result.Total = nil
+		result.Groups = []types.Group{
+			{
+				Keys: []string{"team$payments"},
+				Metrics: map[string]types.MetricValue{
+					"UnblendedCost": {Amount: aws.String("10"), Unit: aws.String("USD")},
+				},
+			},
+			{
+				Keys: []string{"team$"},
+				Metrics: map[string]types.MetricValue{
+					"UnblendedCost": {Amount: aws.String("2.5"), Unit: aws.String("USD")},
+				},
+			},
+		}
+	}
+	return &costexplorer.GetCostAndUsageOutput{
+		ResultsByTime: []types.ResultByTime{result},
+	}, nil
+}
+
+func TestGetCosts(t *testing.T) {
+	f := &Forwarder{
+		svccostexplorer: &fakeCostExplorer{t: t},
+	}
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(24 * time.Hour)
+
+	got, err := f.getCosts(context.Background(), &CostQuery{
+		Service: "billing",
+		Name:    "cost.total",
+	}, start, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ServiceMetricValue{
+		{Name: "cost.total", Time: start.Unix(), Value: 12.5},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("metrics mismatch: (-want/+got):\n%s", diff)
+	}
+
+	got, err = f.getCosts(context.Background(), &CostQuery{
+		Service: "billing",
+		Name:    "cost.team",
+		GroupBy: "TAG:team",
+	}, start, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []ServiceMetricValue{
+		{Name: "cost.team.team_payments", Time: start.Unix(), Value: 10},
+		{Name: "cost.team.team_", Time: start.Unix(), Value: 2.5},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("metrics mismatch: (-want/+got):\n%s", diff)
+	}
+}
+
+func TestForwardCosts(t *testing.T) {
+	var posted []ServiceMetricValue
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		var values []ServiceMetricValue
+		if err := json.NewDecoder(r.Body).Decode(&values); err != nil {
+			t.Error(err)
+		}
+		posted = append(posted, values...)
+		rw.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(ts.Close)
+
+	now := time.Date(2025, 1, 2, 9, 30, 15, 0, time.UTC)
+	f := &Forwarder{
+		APIKey:                 "dummy",
+		APIURL:                 ts.URL,
+		SkipAPIKeyVerification: true,
+		Now:                    func() time.Time { return now },
+		svccostexplorer:        &fakeCostExplorer{t: t, err: errors.New("access denied")},
+	}
+	data := json.RawMessage(`[
+		{"service": "billing", "name": "cost.team", "groupBy": "TAG:team"},
+		{"service": "billing", "name": "cost.total"}
+	]`)
+	err := f.forwardCosts(context.Background(), data)
+
+	// the failed query is skipped, and the others are posted.
+	var ferr *FetchError
+	if !errors.As(err, &ferr) || len(ferr.Indexes) != 1 || ferr.Indexes[0] != 0 {
+		t.Errorf("want FetchError of query[0], got %v", err)
+	}
+	want := []ServiceMetricValue{
+		{Name: "cost.total", Time: time.Date(2025, 1, 2, 9, 30, 0, 0, time.UTC).Unix(), Value: 12.5},
+	}
+	if diff := cmp.Diff(want, posted); diff != "" {
+		t.Errorf("metrics mismatch: (-want/+got):\n%s", diff)
+	}
+}
@@ -0,0 +1,147 @@
+package forwarder
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// the pricing of the CloudWatch API in US East (N. Virginia).
+// https://aws.amazon.com/cloudwatch/pricing/
+const (
+	// defaultMetricDataPrice is the price of GetMetricData in USD per 1,000 metrics requested.
+	defaultMetricDataPrice = 0.01
+
+	// defaultAPIRequestPrice is the price of GetMetricStatistics in USD per 1,000 requests.
+	defaultAPIRequestPrice = 0.01
+
+	// maxMetricDataPoints is the maximum number of the data points in a page of GetMetricData.
+	maxMetricDataPoints = 100800
+
+	// costEstimateMonth is the length of a month for estimating the cost.
+	costEstimateMonth = 30 * 24 * time.Hour
+)
+
+// CostEstimateOptions is the options of EstimateCost.
+type CostEstimateOptions struct {
+	// Schedule is the rate expression of the invocations, e.g. "rate(5 minutes)".
+	// The default is "rate(1 minute)".
+	Schedule string
+
+	// Lookback is the length of the time window, see Forwarder.Lookback.
+	Lookback time.Duration
+
+	// MetricDataPrice is the price of GetMetricData in USD per 1,000 metrics requested.
+	// The default is the price in US East (N. Virginia).
+	MetricDataPrice float64
+
+	// APIRequestPrice is the price of GetMetricStatistics in USD per 1,000 requests.
+	// The default is the price in US East (N. Virginia).
+	APIRequestPrice float64
+}
+
+// CostEstimate is the estimated usage and cost of the CloudWatch API.
+type CostEstimate struct {
+	// InvocationsPerMonth is the number of the invocations in a month of 30 days.
+	InvocationsPerMonth int64 `json:"invocationsPerMonth"`
+
+	// MetricsPerInvocation is the number of the metrics requested by GetMetricData in an invocation.
+	// The metric math expressions are not counted.
+	MetricsPerInvocation int64 `json:"metricsPerInvocation"`
+
+	// GetMetricDataCallsPerInvocation is the number of GetMetricData calls in an invocation, including the pages.
+	GetMetricDataCallsPerInvocation int64 `json:"getMetricDataCallsPerInvocation"`
+
+	// GetMetricStatisticsCallsPerInvocation is the number of GetMetricStatistics calls in an invocation.
+	GetMetricStatisticsCallsPerInvocation int64 `json:"getMetricStatisticsCallsPerInvocation"`
+
+	// MetricsPerMonth is the number of the metrics requested by GetMetricData in a month.
+	MetricsPerMonth int64 `json:"metricsPerMonth"`
+
+	// APICallsPerMonth is the number of the calls of GetMetricData and GetMetricStatistics in a month.
+	APICallsPerMonth int64 `json:"apiCallsPerMonth"`
+
+	// MonthlyCost is the estimated cost in USD per month.
+	MonthlyCost float64 `json:"monthlyCost"`
+}
+
+var rateExpression = regexp.MustCompile(`^rate\((\d+) (minutes?|hours?|days?)\)$`)
+
+// parseRateExpression parses the rate expression of EventBridge, e.g. "rate(5 minutes)".
+func parseRateExpression(expr string) (time.Duration, error) {
+	m := rateExpression.FindStringSubmatch(expr)
+	if m == nil {
+		return 0, fmt.Errorf("forwarder: unsupported schedule expression: %q", expr)
+	}
+	n, err := strconv.ParseInt(m[1], 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("forwarder: invalid rate: %q", expr)
+	}
+	var unit time.Duration
+	switch m[2] {
+	case "minute", "minutes":
+		unit = time.Minute
+	case "hour", "hours":
+		unit = time.Hour
+	case "day", "days":
+		unit = 24 * time.Hour
+	}
+	return time.Duration(n) * unit, nil
+}
+
+// EstimateCost estimates the monthly usage and cost of the CloudWatch API for the queries.
+// The queries must be valid, otherwise QueryErrors is returned.
+func EstimateCost(queries []*Query, opts *CostEstimateOptions) (*CostEstimate, error) {
+	if opts == nil {
+		opts = &CostEstimateOptions{}
+	}
+	schedule := opts.Schedule
+	if schedule == "" {
+		schedule = "rate(1 minute)"
+	}
+	interval, err := parseRateExpression(schedule)
+	if err != nil {
+		return nil, err
+	}
+	lookback := max(opts.Lookback.Truncate(time.Minute), time.Minute)
+	metricDataPrice := opts.MetricDataPrice
+	if metricDataPrice == 0 {
+		metricDataPrice = defaultMetricDataPrice
+	}
+	apiRequestPrice := opts.APIRequestPrice
+	if apiRequestPrice == 0 {
+		apiRequestPrice = defaultAPIRequestPrice
+	}
+
+	resolved, errs := prepareQueries(queries)
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	// the queries with the different delays are fetched in the different calls.
+	groups := make(map[time.Duration]int64)
+	var ret CostEstimate
+	for _, q := range resolved {
+		switch {
+		case q.usesGetMetricData():
+			groups[q.Delay]++
+			if q.Query.Expression == "" {
+				ret.MetricsPerInvocation++
+			}
+		case q.Query.Type == queryTypeServiceQuota, q.Query.API == apiStatistics:
+			ret.GetMetricStatisticsCallsPerInvocation++
+		}
+	}
+	points := int64(lookback / time.Minute)
+	for _, n := range groups {
+		ret.GetMetricDataCallsPerInvocation += max(1, (n*points+maxMetricDataPoints-1)/maxMetricDataPoints)
+	}
+
+	ret.InvocationsPerMonth = int64(costEstimateMonth / interval)
+	ret.MetricsPerMonth = ret.MetricsPerInvocation * ret.InvocationsPerMonth
+	ret.APICallsPerMonth = (ret.GetMetricDataCallsPerInvocation + ret.GetMetricStatisticsCallsPerInvocation) * ret.InvocationsPerMonth
+	ret.MonthlyCost = float64(ret.MetricsPerMonth)/1000*metricDataPrice +
+		float64(ret.GetMetricStatisticsCallsPerInvocation*ret.InvocationsPerMonth)/1000*apiRequestPrice
+	return &ret, nil
+}
@@ -0,0 +1,72 @@
+package forwarder
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestParseRateExpression(t *testing.T) {
+	tests := []struct {
+		input string
+		want  time.Duration
+	}{
+		{"rate(1 minute)", time.Minute},
+		{"rate(5 minutes)", 5 * time.Minute},
+		{"rate(1 hour)", time.Hour},
+		{"rate(2 days)", 48 * time.Hour},
+	}
+	for _, tt := range tests {
+		got, err := parseRateExpression(tt.input)
+		if err != nil {
+			t.Errorf("%q: %v", tt.input, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%q: want %s, got %s", tt.input, tt.want, got)
+		}
+	}
+
+	for _, input := range []string{"cron(0 * * * ? *)", "rate(0 minutes)", "rate(1 second)"} {
+		if _, err := parseRateExpression(input); err == nil {
+			t.Errorf("%q: want error, got nil", input)
+		}
+	}
+}
+
+func TestEstimateCost(t *testing.T) {
+	queries := []*Query{
+		{ID: "sent", Metric: []interface{}{"AWS/SQS", "NumberOfMessagesSent"}, Stat: "Sum", ReturnData: new(bool)},
+		{ID: "deleted", Metric: []interface{}{"AWS/SQS", "NumberOfMessagesDeleted"}, Stat: "Sum", ReturnData: new(bool)},
+		{Service: "foo", Name: "sqs.ratio", Expression: "deleted / sent * 100"},
+		{Service: "foo", Name: "billing", Metric: []interface{}{"AWS/Billing", "EstimatedCharges"}, Stat: "Maximum", Delay: "15m"},
+		{Service: "foo", Name: "ec2.cpu", Metric: []interface{}{"AWS/EC2", "CPUUtilization"}, Stat: "Average", API: "statistics"},
+	}
+	got, err := EstimateCost(queries, &CostEstimateOptions{
+		Schedule: "rate(5 minutes)",
+		Lookback: 5 * time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &CostEstimate{
+		InvocationsPerMonth:                   8640,
+		MetricsPerInvocation:                  3,
+		GetMetricDataCallsPerInvocation:       2,
+		GetMetricStatisticsCallsPerInvocation: 1,
+		MetricsPerMonth:                       25920,
+		APICallsPerMonth:                      25920,
+	}
+	if math.Abs(got.MonthlyCost-(0.2592+0.0864)) > 1e-9 {
+		t.Errorf("unexpected cost: %f", got.MonthlyCost)
+	}
+	got.MonthlyCost = 0
+	if *got != *want {
+		t.Errorf("unexpected estimate: want %#v, got %#v", want, got)
+	}
+
+	if _, err := EstimateCost([]*Query{{Service: "foo", Name: "bar"}}, nil); err == nil {
+		t.Error("want error for invalid queries, got nil")
+	}
+}
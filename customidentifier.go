@@ -0,0 +1,66 @@
+package forwarder
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// customIdentifierPrefix is the prefix of the hosts in the queries that are the custom identifiers of Mackerel,
+// e.g. "customIdentifier:arn:aws:rds:ap-northeast-1:123456789012:db:db".
+const customIdentifierPrefix = "customIdentifier:"
+
+// resolveCustomIdentifiers replaces the custom identifiers in the host fields of the queries with the host ids.
+// The results of the queries whose custom identifiers can't be resolved are not forwarded,
+// but they are still available in the expressions.
+func (f *Forwarder) resolveCustomIdentifiers(ctx context.Context, client *MackerelClient, query []*Query) []*Query {
+	var lastHost string
+	ret := make([]*Query, 0, len(query))
+	for i, q := range query {
+		host := q.Host
+		setDefault(&host, &lastHost)
+		customIdentifier, ok := strings.CutPrefix(host, customIdentifierPrefix)
+		if !ok {
+			ret = append(ret, q)
+			continue
+		}
+
+		qq := *q
+		id, err := f.lookupHostID(ctx, client, customIdentifier)
+		if err != nil {
+			f.logger().WarnContext(withQueryIndex(ctx, i), "failed to resolve the custom identifier, skips",
+				"customIdentifier", customIdentifier,
+				"error", err.Error(),
+			)
+			returnData := false
+			qq.ReturnData = &returnData
+		} else {
+			qq.Host = id
+		}
+		ret = append(ret, &qq)
+	}
+	return ret
+}
+
+// lookupHostID returns the host id of the custom identifier.
+// The host ids are cached across the invocations for each organization until the TTL of the host list expires.
+func (f *Forwarder) lookupHostID(ctx context.Context, client *MackerelClient, customIdentifier string) (string, error) {
+	now := f.now()
+	cache := f.mackerelCache(client)
+	cache.muHosts.Lock()
+	defer cache.muHosts.Unlock()
+	if id, ok := cache.hostID(customIdentifier, now); ok {
+		return id, nil
+	}
+
+	hosts, err := client.FindHostsByCustomIdentifier(ctx, customIdentifier)
+	if err != nil {
+		return "", err
+	}
+	if len(hosts) == 0 {
+		return "", fmt.Errorf("forwarder: host not found: %q", customIdentifier)
+	}
+
+	cache.setHostID(customIdentifier, hosts[0].ID, now.Add(f.hostListTTL(ctx)))
+	return hosts[0].ID, nil
+}
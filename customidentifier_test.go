@@ -0,0 +1,117 @@
+package forwarder
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestResolveCustomIdentifiers(t *testing.T) {
+	var requested int32
+	client := newTestMackerelClient(t, http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requested, 1)
+		switch r.URL.Query().Get("customIdentifier") {
+		case "arn:aws:rds:ap-northeast-1:123456789012:db:db":
+			rw.Write([]byte(`{"hosts":[{"id":"host-abc","name":"db"}]}`))
+		default:
+			rw.Write([]byte(`{"hosts":[]}`))
+		}
+	}))
+
+	f := &Forwarder{}
+	query := []*Query{
+		{
+			Host:   "customIdentifier:arn:aws:rds:ap-northeast-1:123456789012:db:db",
+			Name:   "rds.cpu",
+			Metric: []interface{}{"AWS/RDS", "CPUUtilization", "DBInstanceIdentifier", "db"},
+		},
+		{
+			Host:   ".",
+			Name:   "rds.connections",
+			Metric: []interface{}{".", "DatabaseConnections", ".", "."},
+		},
+		{
+			Host:   "host-def",
+			Name:   "rds.cpu",
+			Metric: []interface{}{"AWS/RDS", "CPUUtilization", "DBInstanceIdentifier", "db2"},
+		},
+		{
+			Host:   "customIdentifier:unknown",
+			Name:   "rds.cpu",
+			Metric: []interface{}{"AWS/RDS", "CPUUtilization", "DBInstanceIdentifier", "db3"},
+		},
+	}
+
+	for i := 0; i < 2; i++ {
+		got := f.resolveCustomIdentifiers(context.Background(), client, query)
+		if got[0].Host != "host-abc" {
+			t.Errorf("unexpected host id: want %q, got %q", "host-abc", got[0].Host)
+		}
+		if got[1].Host != "host-abc" {
+			t.Errorf("unexpected host id: want %q, got %q", "host-abc", got[1].Host)
+		}
+		if got[2] != query[2] {
+			t.Errorf("the query without custom identifier is modified: %#v", got[2])
+		}
+		if got[3].returnData() {
+			t.Error("the query with unknown custom identifier should not return data")
+		}
+	}
+	if query[0].Host != "customIdentifier:arn:aws:rds:ap-northeast-1:123456789012:db:db" {
+		t.Errorf("the original query is modified: %q", query[0].Host)
+	}
+
+	// the known host is cached, and the unknown one is looked up every time.
+	if want, got := int32(3), atomic.LoadInt32(&requested); want != got {
+		t.Errorf("unexpected request count: want %d, got %d", want, got)
+	}
+}
+
+func TestLookupHostID_Expiration(t *testing.T) {
+	var requested int32
+	client := newTestMackerelClient(t, http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requested, 1) == 1 {
+			rw.Write([]byte(`{"hosts":[{"id":"host-old","name":"db"}]}`))
+		} else {
+			rw.Write([]byte(`{"hosts":[{"id":"host-new","name":"db"}]}`))
+		}
+	}))
+
+	now := time.Unix(1234567890, 0)
+	f := &Forwarder{
+		HostListTTL: time.Minute,
+		Now:         func() time.Time { return now },
+	}
+	lookup := func() string {
+		t.Helper()
+		id, err := f.lookupHostID(context.Background(), client, "arn:aws:rds:ap-northeast-1:123456789012:db:db")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+
+	if got := lookup(); got != "host-old" {
+		t.Errorf("unexpected host id: want %q, got %q", "host-old", got)
+	}
+
+	// the host is retired, and registered again.
+	f.markRetiredHost(context.Background(), client, "host-old", now)
+	if got := lookup(); got != "host-new" {
+		t.Errorf("unexpected host id: want %q, got %q", "host-new", got)
+	}
+
+	// the cache expires with the ttl of the host list.
+	now = now.Add(30 * time.Second)
+	lookup()
+	if want, got := int32(2), atomic.LoadInt32(&requested); want != got {
+		t.Errorf("unexpected request count: want %d, got %d", want, got)
+	}
+	now = now.Add(time.Minute)
+	lookup()
+	if want, got := int32(3), atomic.LoadInt32(&requested); want != got {
+		t.Errorf("unexpected request count: want %d, got %d", want, got)
+	}
+}
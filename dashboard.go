@@ -0,0 +1,228 @@
+package forwarder
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// defaultDashboardPeriod is the default period of the widgets of CloudWatch dashboards in seconds.
+const defaultDashboardPeriod = 300
+
+// dashboardBody is the body of a CloudWatch dashboard.
+// See https://docs.aws.amazon.com/AmazonCloudWatch/latest/APIReference/CloudWatch-Dashboard-Body-Structure.html
+type dashboardBody struct {
+	Widgets []*dashboardWidget `json:"widgets"`
+}
+
+type dashboardWidget struct {
+	Type       string `json:"type"`
+	Properties struct {
+		Title   string              `json:"title"`
+		Stat    string              `json:"stat"`
+		Period  int                 `json:"period"`
+		Metrics [][]json.RawMessage `json:"metrics"`
+	} `json:"properties"`
+}
+
+// dashboardMetricOptions is the rendering properties at the end of a metric array of the widget.
+type dashboardMetricOptions struct {
+	Expression string `json:"expression"`
+	ID         string `json:"id"`
+	Label      string `json:"label"`
+	Stat       string `json:"stat"`
+	Period     int    `json:"period"`
+	Visible    *bool  `json:"visible"`
+}
+
+// ImportDashboard converts the body of a CloudWatch dashboard into the query document,
+// so that the graphs of the dashboard are migrated to Mackerel.
+// It also accepts the output of `aws cloudwatch get-dashboard`, which has the body as the string DashboardBody.
+//
+// Each metric widget becomes a group of the queries with the period of the widget,
+// and its metrics are posted as the service metrics "<the title of the widget>.<the label of the metric>",
+// so that Mackerel shows them in one graph. The other widgets, e.g. the texts and the alarms, are skipped.
+// The ids of the metrics are prefixed with the number of the widget, because they are unique only in the widget.
+// The regions of the widgets are ignored, and the metrics are fetched from the region of the forwarder.
+func ImportDashboard(data []byte, service string) (*QueryDocument, error) {
+	var wrapper struct {
+		DashboardBody *string `json:"DashboardBody"`
+	}
+	if err := json.Unmarshal(data, &wrapper); err == nil && wrapper.DashboardBody != nil {
+		data = []byte(*wrapper.DashboardBody)
+	}
+	var body dashboardBody
+	if err := json.Unmarshal(data, &body); err != nil {
+		return nil, fmt.Errorf("forwarder: failed to parse the dashboard: %w", err)
+	}
+
+	doc := &QueryDocument{Version: queryDocumentVersion}
+	names := make(map[string]bool)
+	for i, w := range body.Widgets {
+		if w.Type != "metric" || len(w.Properties.Metrics) == 0 {
+			continue
+		}
+		group, err := importDashboardWidget(w, i, service, names)
+		if err != nil {
+			return nil, fmt.Errorf("forwarder: invalid widgets[%d] of the dashboard: %w", i, err)
+		}
+		doc.Groups = append(doc.Groups, group)
+	}
+	if len(doc.Groups) == 0 {
+		return nil, errors.New("forwarder: the dashboard has no metric widgets")
+	}
+	return doc, nil
+}
+
+func importDashboardWidget(w *dashboardWidget, index int, service string, names map[string]bool) (*QueryDocumentGroup, error) {
+	period := w.Properties.Period
+	if period == 0 {
+		period = defaultDashboardPeriod
+	}
+	stat := w.Properties.Stat
+	if stat == "" {
+		stat = "Average"
+	}
+	prefix := dashboardMetricName(w.Properties.Title)
+	if prefix == "" {
+		prefix = fmt.Sprintf("widget%d", index+1)
+	}
+
+	group := &QueryDocumentGroup{Period: strconv.Itoa(period)}
+	ids := make(map[string]string)
+	var last []string
+	for j, row := range w.Properties.Metrics {
+		fields, opts, err := parseDashboardMetric(row, last)
+		if err != nil {
+			return nil, fmt.Errorf("metrics[%d]: %w", j, err)
+		}
+
+		q := &Query{}
+		if opts.ID != "" {
+			q.ID = fmt.Sprintf("w%d_%s", index+1, opts.ID)
+			ids[opts.ID] = q.ID
+		}
+		if opts.Period != 0 && opts.Period != period {
+			q.Period = strconv.Itoa(opts.Period)
+		}
+		if opts.Expression != "" {
+			q.Expression = opts.Expression
+		} else {
+			if len(fields) < 2 || len(fields)%2 != 0 {
+				return nil, fmt.Errorf("metrics[%d]: the namespace, the metric name and the pairs of the dimensions are required: %q", j, fields)
+			}
+			last = fields
+			q.Metric = make(MetricSpec, 0, len(fields))
+			for _, f := range fields {
+				q.Metric = append(q.Metric, f)
+			}
+			q.Stat = stat
+			if opts.Stat != "" {
+				q.Stat = opts.Stat
+			}
+		}
+		if opts.Visible != nil && !*opts.Visible {
+			q.ReturnData = opts.Visible
+		} else {
+			q.Service = service
+			q.Name = uniqueMetricName(prefix+"."+dashboardMetricLabel(fields, opts), names)
+		}
+		group.Queries = append(group.Queries, q)
+	}
+
+	// the expressions refer to the ids in the widget.
+	for _, q := range group.Queries {
+		if q.Expression == "" {
+			continue
+		}
+		q.Expression = queryIDPattern.ReplaceAllStringFunc(q.Expression, func(s string) string {
+			if id, ok := ids[s]; ok {
+				return id
+			}
+			return s
+		})
+	}
+	return group, nil
+}
+
+// parseDashboardMetric parses a metric array of the widget.
+// The shorthands "." and "..." are resolved with the last metric of the widget.
+func parseDashboardMetric(row []json.RawMessage, last []string) ([]string, *dashboardMetricOptions, error) {
+	opts := &dashboardMetricOptions{}
+	if n := len(row); n > 0 && bytes.HasPrefix(bytes.TrimSpace(row[n-1]), []byte("{")) {
+		if err := json.Unmarshal(row[n-1], opts); err != nil {
+			return nil, nil, err
+		}
+		row = row[:n-1]
+	}
+
+	fields := make([]string, 0, len(row))
+	for i, raw := range row {
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return nil, nil, fmt.Errorf("the element %s must be a string", raw)
+		}
+		switch {
+		case s == "..." && i == 0:
+			n := len(last) - (len(row) - 1)
+			if n < 0 {
+				return nil, nil, errors.New(`the shorthand "..." has no metric to repeat`)
+			}
+			fields = append(fields, last[:n]...)
+		case s == ".":
+			if len(fields) >= len(last) {
+				return nil, nil, errors.New(`the shorthand "." has no metric to inherit`)
+			}
+			fields = append(fields, last[len(fields)])
+		default:
+			fields = append(fields, s)
+		}
+	}
+	return fields, opts, nil
+}
+
+// dashboardMetricLabel returns the last part of the metric name on Mackerel.
+// It is the label of the metric if it is a plain text,
+// otherwise the values of the dimensions, or the metric name.
+func dashboardMetricLabel(fields []string, opts *dashboardMetricOptions) string {
+	if opts.Label != "" && !strings.Contains(opts.Label, "${") {
+		if name := dashboardMetricName(opts.Label); name != "" {
+			return name
+		}
+	}
+	if opts.ID != "" && opts.Expression != "" {
+		return opts.ID
+	}
+	if len(fields) > 2 {
+		values := make([]string, 0, len(fields)/2-1)
+		for i := 3; i < len(fields); i += 2 {
+			values = append(values, fields[i])
+		}
+		return dashboardMetricName(strings.Join(values, "_"))
+	}
+	if len(fields) > 1 {
+		return dashboardMetricName(fields[1])
+	}
+	return "expression"
+}
+
+// dashboardMetricName converts the text into a part of the metric name on Mackerel.
+// "." is replaced, because it separates the graphs on Mackerel.
+func dashboardMetricName(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	s = strings.ReplaceAll(s, ".", "_")
+	return sanitizeMetricName(s)
+}
+
+// uniqueMetricName returns the name with the suffix "_<n>" if it is already used.
+func uniqueMetricName(name string, used map[string]bool) string {
+	ret := name
+	for i := 2; used[ret]; i++ {
+		ret = fmt.Sprintf("%s_%d", name, i)
+	}
+	used[ret] = true
+	return ret
+}
@@ -0,0 +1,111 @@
+package forwarder
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestImportDashboard(t *testing.T) {
+	body := `{
+  "widgets": [
+    {"type": "text", "properties": {"markdown": "# My Service"}},
+    {
+      "type": "metric",
+      "properties": {
+        "title": "CPU Utilization",
+        "region": "ap-northeast-1",
+        "stat": "Maximum",
+        "metrics": [
+          ["AWS/EC2", "CPUUtilization", "InstanceId", "i-123"],
+          ["...", "i-456", {"stat": "Average", "period": 60}]
+        ]
+      }
+    },
+    {
+      "type": "metric",
+      "properties": {
+        "title": "Error Rate",
+        "period": 60,
+        "metrics": [
+          ["AWS/ApplicationELB", "HTTPCode_Target_5XX_Count", "LoadBalancer", "app/my-alb/123", {"id": "m1", "stat": "Sum", "visible": false}],
+          [".", "RequestCount", ".", ".", {"id": "m2", "stat": "Sum", "visible": false}],
+          [{"expression": "m1 / m2 * 100", "label": "5xx rate", "id": "e1"}]
+        ]
+      }
+    }
+  ]
+}`
+	wrapped, err := json.Marshal(map[string]string{"DashboardArn": "arn:aws:cloudwatch::123456789012:dashboard/foo", "DashboardBody": body})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hidden := false
+	want := &QueryDocument{
+		Version: queryDocumentVersion,
+		Groups: []*QueryDocumentGroup{
+			{
+				Period: "300",
+				Queries: []*Query{
+					{Service: "foo", Name: "cpu_utilization.i-123", Metric: MetricSpec{"AWS/EC2", "CPUUtilization", "InstanceId", "i-123"}, Stat: "Maximum"},
+					{Service: "foo", Name: "cpu_utilization.i-456", Metric: MetricSpec{"AWS/EC2", "CPUUtilization", "InstanceId", "i-456"}, Stat: "Average", Period: "60"},
+				},
+			},
+			{
+				Period: "60",
+				Queries: []*Query{
+					{ID: "w3_m1", Metric: MetricSpec{"AWS/ApplicationELB", "HTTPCode_Target_5XX_Count", "LoadBalancer", "app/my-alb/123"}, Stat: "Sum", ReturnData: &hidden},
+					{ID: "w3_m2", Metric: MetricSpec{"AWS/ApplicationELB", "RequestCount", "LoadBalancer", "app/my-alb/123"}, Stat: "Sum", ReturnData: &hidden},
+					{ID: "w3_e1", Service: "foo", Name: "error_rate.5xx_rate", Expression: "w3_m1 / w3_m2 * 100"},
+				},
+			},
+		},
+	}
+	for _, data := range []string{body, string(wrapped)} {
+		got, err := ImportDashboard([]byte(data), "foo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("query document mismatch: (-want/+got):\n%s", diff)
+		}
+	}
+
+	// the generated query file is valid.
+	got, err := ImportDashboard([]byte(body), "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queries, err := ParseQueries(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, errs := prepareQueries(queries); len(errs) > 0 {
+		t.Error(errs)
+	}
+}
+
+func TestImportDashboard_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"no metric widgets", `{"widgets": [{"type": "text", "properties": {"markdown": "foo"}}]}`},
+		{"inherit nothing", `{"widgets": [{"type": "metric", "properties": {"metrics": [[".", "CPUUtilization"]]}}]}`},
+		{"missing dimension value", `{"widgets": [{"type": "metric", "properties": {"metrics": [["AWS/EC2", "CPUUtilization", "InstanceId"]]}}]}`},
+		{"not json", `foo`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ImportDashboard([]byte(tt.body), "foo"); err == nil {
+				t.Error("want an error, got nil")
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package forwarder
+
+import (
+	"encoding/json"
+	"slices"
+)
+
+// PendingMetrics returns a copy of the pending metrics that are retried in the next invocation.
+// It is for inspecting what is stuck in retry during incidents.
+// The pending metrics in the state store that are not restored yet are not included.
+func (f *Forwarder) PendingMetrics() *State {
+	f.muPending.Lock()
+	defer f.muPending.Unlock()
+
+	state := &State{}
+	if len(f.pendingServiceMetrics) > 0 {
+		state.ServiceMetrics = make(map[string][]ServiceMetricValue, len(f.pendingServiceMetrics))
+		for service, metrics := range f.pendingServiceMetrics {
+			state.ServiceMetrics[service] = slices.Clone(metrics)
+		}
+	}
+	if len(f.pendingHostMetrics) > 0 {
+		state.HostMetrics = slices.Clone([]HostMetricValue(f.pendingHostMetrics))
+	}
+	return state
+}
+
+// IsDumpPendingRequest reports whether the input of ForwardMetrics is a request
+// for dumping the pending metrics, i.e. {"__dump_pending": true}.
+// The response of the request should be the result of PendingMetrics.
+func IsDumpPendingRequest(data json.RawMessage) bool {
+	return isControlRequest(data, "__dump_pending")
+}
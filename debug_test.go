@@ -0,0 +1,53 @@
+package forwarder
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPendingMetrics(t *testing.T) {
+	f := &Forwarder{
+		pendingServiceMetrics: serviceMetricsType{
+			"foo": {
+				{Name: "a", Time: 60, Value: 1},
+			},
+		},
+		pendingHostMetrics: hostMetricsType{
+			{HostID: "host-abc", Name: "b", Time: 60, Value: 2},
+		},
+	}
+	got := f.PendingMetrics()
+	want := &State{
+		ServiceMetrics: map[string][]ServiceMetricValue{
+			"foo": {
+				{Name: "a", Time: 60, Value: 1},
+			},
+		},
+		HostMetrics: []HostMetricValue{
+			{HostID: "host-abc", Name: "b", Time: 60, Value: 2},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("pending metrics mismatch: (-want/+got):\n%s", diff)
+	}
+
+	// the result is a copy.
+	got.ServiceMetrics["foo"][0].Value = 42
+	if f.pendingServiceMetrics["foo"][0].Value != 1 {
+		t.Error("the pending metrics are modified through the copy")
+	}
+}
+
+func TestIsDumpPendingRequest(t *testing.T) {
+	if !IsDumpPendingRequest(json.RawMessage(`{"__dump_pending": true}`)) {
+		t.Error("want true, got false")
+	}
+	if IsDumpPendingRequest(json.RawMessage(`{"ping": true}`)) {
+		t.Error("want false, got true")
+	}
+	if IsDumpPendingRequest(json.RawMessage(`[]`)) {
+		t.Error("want false, got true")
+	}
+}
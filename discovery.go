@@ -0,0 +1,192 @@
+package forwarder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/resourcegroupstaggingapi"
+	"github.com/aws/aws-sdk-go-v2/service/resourcegroupstaggingapi/types"
+)
+
+// DiscoveryQuery is the filter of the resources for the discovery by the tags.
+type DiscoveryQuery struct {
+	// ResourceType is the type of the resources, e.g. "sqs", "ec2:instance" and "rds:db".
+	// See ResourceTypeFilters of GetResources of the Resource Groups Tagging API.
+	ResourceType string `json:"resourceType"`
+
+	// Tags is the tags of the resources.
+	// The resources must have all the keys, and one of the values of each key.
+	// The empty values match any values.
+	Tags map[string][]string `json:"tags,omitempty"`
+}
+
+// resourcePlaceholder is the placeholder of the id of the discovered resource in the dimension values.
+const resourcePlaceholder = "#{resource}"
+
+// discoverResources expands the queries with Discover into the queries of the discovered resources.
+// "#{resource}" and "#{tag:<key>}" in the dimension values are replaced with the id and the tags of the resources,
+// and the ResourceARN of the queries are set to the ARNs, so that the templates of the service names can refer the tags.
+// If the discovery fails, the query is skipped with a warning.
+func (f *Forwarder) discoverResources(ctx context.Context, query []*Query) []*Query {
+	var lastMetric lastMetricType
+	ret := make([]*Query, 0, len(query))
+	for i, q := range query {
+		if q.Discover == nil {
+			if len(q.Metric) >= 2 {
+				// keep the last metric for the shorthands of the discovery queries.
+				resolveMetric(q.Metric, &lastMetric)
+			}
+			ret = append(ret, q)
+			continue
+		}
+		ctx := withQueryIndex(ctx, i)
+		if q.Discover.ResourceType == "" || len(q.Metric) < 2 {
+			f.logger().WarnContext(ctx, "the resource type and the metric are required for the discovery, skips")
+			continue
+		}
+		namespace, name, dimensions := resolveMetric(q.Metric, &lastMetric)
+
+		resources, err := f.cachedDiscoveredResources(ctx, q.Discover)
+		if err != nil {
+			f.logger().WarnContext(ctx, "failed to discover the resources, skips",
+				"resourceType", q.Discover.ResourceType,
+				"error", err.Error(),
+			)
+			continue
+		}
+		if len(resources) == 0 {
+			f.logger().InfoContext(ctx, "no resources are discovered",
+				"resourceType", q.Discover.ResourceType,
+			)
+			continue
+		}
+		f.logger().DebugContext(ctx, "the resources are discovered",
+			"resourceType", q.Discover.ResourceType,
+			"count", len(resources),
+		)
+
+		for j, res := range resources {
+			metric := []interface{}{namespace, name}
+			for _, d := range dimensions {
+				value, _ := renderTemplate(aws.ToString(d.Value), nil, res.Tags, identity)
+				value = strings.ReplaceAll(value, resourcePlaceholder, resourceID(res.ARN))
+				metric = append(metric, aws.ToString(d.Name), value)
+			}
+			qq := *q
+			qq.Discover = nil
+			qq.Metric = metric
+			qq.ResourceARN = res.ARN
+			if q.ID != "" {
+				qq.ID = fmt.Sprintf("%s_%d", q.ID, j)
+			}
+			ret = append(ret, &qq)
+		}
+	}
+	return ret
+}
+
+// validateDiscovery validates that the query is expanded by discoverResources.
+func (q *Query) validateDiscovery() error {
+	if q.Discover != nil {
+		return errors.New("the resources of discover are not discovered")
+	}
+	return nil
+}
+
+// resourceID returns the id of the resource in the ARN, which is usually the dimension value of the resource.
+// e.g. "i-1234567890abcdef0" of "arn:aws:ec2:ap-northeast-1:123456789012:instance/i-1234567890abcdef0",
+// and "app/my-alb/1234567890abcdef" of "arn:aws:elasticloadbalancing:ap-northeast-1:123456789012:loadbalancer/app/my-alb/1234567890abcdef".
+func resourceID(arn string) string {
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) < 6 {
+		return arn
+	}
+	resource := parts[5]
+	if i := strings.IndexAny(resource, "/:"); i >= 0 {
+		return resource[i+1:]
+	}
+	return resource
+}
+
+// cachedDiscoveredResources returns the resources that match the filter.
+// The resources are cached for the discovery cache ttl, so the new resources are picked up in the period.
+func (f *Forwarder) cachedDiscoveredResources(ctx context.Context, q *DiscoveryQuery) ([]DiscoveredResource, error) {
+	key, err := json.Marshal(q)
+	if err != nil {
+		return nil, err
+	}
+	now := f.now()
+
+	f.muDiscovery.Lock()
+	defer f.muDiscovery.Unlock()
+	f.loadDiscoveryCache(ctx)
+	if c, ok := f.discoveryCache.Resources[string(key)]; ok && f.fresh(c.CachedAt, now) {
+		return c.Resources, nil
+	}
+
+	resources, err := f.discover(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	if f.discoveryCache.Resources == nil {
+		f.discoveryCache.Resources = make(map[string]*DiscoveredResources)
+	}
+	f.discoveryCache.Resources[string(key)] = &DiscoveredResources{Resources: resources, CachedAt: now.Unix()}
+
+	// the tags are reused by the templates of the service names.
+	f.muResourceTags.Lock()
+	defer f.muResourceTags.Unlock()
+	if f.resourceTags == nil {
+		f.resourceTags = make(map[string]cachedResourceTags, len(resources))
+	}
+	for _, res := range resources {
+		f.resourceTags[res.ARN] = cachedResourceTags{tags: res.Tags, fetchedAt: now}
+	}
+	return resources, nil
+}
+
+func (f *Forwarder) discover(ctx context.Context, q *DiscoveryQuery) ([]DiscoveredResource, error) {
+	keys := make([]string, 0, len(q.Tags))
+	for k := range q.Tags {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	filters := make([]types.TagFilter, 0, len(keys))
+	for _, k := range keys {
+		filters = append(filters, types.TagFilter{
+			Key:    aws.String(k),
+			Values: q.Tags[k],
+		})
+	}
+
+	paginator := resourcegroupstaggingapi.NewGetResourcesPaginator(f.tagging(), &resourcegroupstaggingapi.GetResourcesInput{
+		ResourceTypeFilters: []string{q.ResourceType},
+		TagFilters:          filters,
+	})
+	var ret []DiscoveredResource
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("forwarder: failed to discover the resources: %w", err)
+		}
+		for _, res := range page.ResourceTagMappingList {
+			tags := make(map[string]string, len(res.Tags))
+			for _, tag := range res.Tags {
+				tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+			}
+			ret = append(ret, DiscoveredResource{
+				ARN:  aws.ToString(res.ResourceARN),
+				Tags: tags,
+			})
+		}
+	}
+	slices.SortFunc(ret, func(a, b DiscoveredResource) int {
+		return strings.Compare(a.ARN, b.ARN)
+	})
+	return ret, nil
+}
@@ -0,0 +1,157 @@
+package forwarder
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/resourcegroupstaggingapi"
+	"github.com/aws/aws-sdk-go-v2/service/resourcegroupstaggingapi/types"
+	"github.com/google/go-cmp/cmp"
+)
+
+// discoveryTagging finds the resources by the resource types and the tag filters.
+type discoveryTagging struct {
+	resources map[string]map[string]string // arn -> tags
+	calls     int
+}
+
+func (s *discoveryTagging) GetResources(ctx context.Context, params *resourcegroupstaggingapi.GetResourcesInput, optFns ...func(*resourcegroupstaggingapi.Options)) (*resourcegroupstaggingapi.GetResourcesOutput, error) {
+	s.calls++
+	var list []types.ResourceTagMapping
+	for arn, tags := range s.resources {
+		if !s.match(arn, tags, params) {
+			continue
+		}
+		var ts []types.Tag
+		for k, v := range tags {
+			ts = append(ts, types.Tag{Key: aws.String(k), Value: aws.String(v)})
+		}
+		list = append(list, types.ResourceTagMapping{ResourceARN: aws.String(arn), Tags: ts})
+	}
+	return &resourcegroupstaggingapi.GetResourcesOutput{ResourceTagMappingList: list}, nil
+}
+
+func (s *discoveryTagging) match(arn string, tags map[string]string, params *resourcegroupstaggingapi.GetResourcesInput) bool {
+	for _, typ := range params.ResourceTypeFilters {
+		service, resource, _ := strings.Cut(typ, ":")
+		parts := strings.SplitN(arn, ":", 6)
+		if parts[2] != service || resource != "" && !strings.HasPrefix(parts[5], resource) {
+			return false
+		}
+	}
+	for _, f := range params.TagFilters {
+		v, ok := tags[aws.ToString(f.Key)]
+		if !ok || len(f.Values) > 0 && !slices.Contains(f.Values, v) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestDiscoverResources(t *testing.T) {
+	svc := &discoveryTagging{
+		resources: map[string]map[string]string{
+			"arn:aws:sqs:ap-northeast-1:123456789012:orders":  {"team": "payments", "env": "production"},
+			"arn:aws:sqs:ap-northeast-1:123456789012:refunds": {"team": "payments", "env": "staging"},
+			"arn:aws:sqs:ap-northeast-1:123456789012:jobs":    {"team": "batch", "env": "production"},
+			"arn:aws:sns:ap-northeast-1:123456789012:topic":   {"team": "payments", "env": "production"},
+		},
+	}
+	now := time.Unix(1234567890, 0)
+	f := &Forwarder{svctagging: svc, Now: func() time.Time { return now }}
+	query := []*Query{
+		{
+			Service: "foo",
+			Name:    "rds.cpu",
+			Metric:  []interface{}{"AWS/RDS", "CPUUtilization", "DBInstanceIdentifier", "db"},
+			Stat:    "Average",
+		},
+		{
+			Service: "#{tag:team}",
+			Name:    "sqs.#{QueueName}.visible_messages",
+			Metric:  []interface{}{"AWS/SQS", "ApproximateNumberOfMessagesVisible", "QueueName", resourcePlaceholder},
+			Stat:    "Maximum",
+			Discover: &DiscoveryQuery{
+				ResourceType: "sqs",
+				Tags:         map[string][]string{"env": {"production"}},
+			},
+		},
+	}
+
+	got := f.discoverResources(context.Background(), query)
+	want := []*Query{
+		query[0],
+		{
+			Service:     "#{tag:team}",
+			Name:        "sqs.#{QueueName}.visible_messages",
+			Metric:      []interface{}{"AWS/SQS", "ApproximateNumberOfMessagesVisible", "QueueName", "jobs"},
+			Stat:        "Maximum",
+			ResourceARN: "arn:aws:sqs:ap-northeast-1:123456789012:jobs",
+		},
+		{
+			Service:     "#{tag:team}",
+			Name:        "sqs.#{QueueName}.visible_messages",
+			Metric:      []interface{}{"AWS/SQS", "ApproximateNumberOfMessagesVisible", "QueueName", "orders"},
+			Stat:        "Maximum",
+			ResourceARN: "arn:aws:sqs:ap-northeast-1:123456789012:orders",
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("queries mismatch: (-want/+got):\n%s", diff)
+	}
+
+	// the discovered queries are resolved with the tags.
+	resolved, errs := prepareQueries(got)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	fctx := &forwardContext{forwarder: f}
+	resolved = fctx.resolveServiceTemplates(context.Background(), resolved)
+	var labels []string
+	for _, q := range resolved {
+		labels = append(labels, q.Label.String())
+	}
+	wantLabels := []string{
+		"service=foo:rds.cpu",
+		"service=batch:sqs.jobs.visible_messages",
+		"service=payments:sqs.orders.visible_messages",
+	}
+	if diff := cmp.Diff(wantLabels, labels); diff != "" {
+		t.Errorf("labels mismatch: (-want/+got):\n%s", diff)
+	}
+
+	// the resources and their tags are cached.
+	f.discoverResources(context.Background(), query)
+	if svc.calls != 1 {
+		t.Errorf("want 1 call, got %d", svc.calls)
+	}
+
+	// the cache expires.
+	now = now.Add(defaultDiscoveryCacheTTL)
+	f.discoverResources(context.Background(), query)
+	if svc.calls != 2 {
+		t.Errorf("want 2 calls, got %d", svc.calls)
+	}
+}
+
+func TestResourceID(t *testing.T) {
+	tests := []struct {
+		arn  string
+		want string
+	}{
+		{"arn:aws:sqs:ap-northeast-1:123456789012:orders", "orders"},
+		{"arn:aws:ec2:ap-northeast-1:123456789012:instance/i-1234567890abcdef0", "i-1234567890abcdef0"},
+		{"arn:aws:rds:ap-northeast-1:123456789012:db:my-db", "my-db"},
+		{"arn:aws:elasticloadbalancing:ap-northeast-1:123456789012:loadbalancer/app/my-alb/1234567890abcdef", "app/my-alb/1234567890abcdef"},
+		{"invalid", "invalid"},
+	}
+	for _, tt := range tests {
+		if got := resourceID(tt.arn); got != tt.want {
+			t.Errorf("%s: want %q, got %q", tt.arn, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package forwarder
+
+import (
+	"context"
+	"time"
+)
+
+// defaultDiscoveryCacheTTL is the default of Forwarder.DiscoveryCacheTTL.
+const defaultDiscoveryCacheTTL = 10 * time.Minute
+
+// DiscoveryCache is the cache of the results of ListMetrics for the wildcard dimension values
+// and the resources discovered by the tags.
+type DiscoveryCache struct {
+	// Metrics are the dimensions of the metrics found by ListMetrics, keyed by the filters.
+	Metrics map[string]*DiscoveredMetrics `json:"metrics,omitempty"`
+
+	// Resources are the resources found by the tags, keyed by the filters.
+	Resources map[string]*DiscoveredResources `json:"resources,omitempty"`
+}
+
+// DiscoveredMetrics is the metrics found by ListMetrics.
+type DiscoveredMetrics struct {
+	// Dimensions are the dimensions of the metrics.
+	Dimensions []map[string]string `json:"dimensions"`
+
+	// CachedAt is the unix time when the metrics are found.
+	CachedAt int64 `json:"cachedAt"`
+}
+
+// DiscoveredResources is the resources found by the tags.
+type DiscoveredResources struct {
+	Resources []DiscoveredResource `json:"resources"`
+
+	// CachedAt is the unix time when the resources are found.
+	CachedAt int64 `json:"cachedAt"`
+}
+
+// DiscoveredResource is a resource found by the tags.
+type DiscoveredResource struct {
+	ARN  string            `json:"arn"`
+	Tags map[string]string `json:"tags,omitempty"`
+}
+
+// discoveryCacheTTL returns the duration for caching the discovery results.
+func (f *Forwarder) discoveryCacheTTL() time.Duration {
+	if f.DiscoveryCacheTTL > 0 {
+		return f.DiscoveryCacheTTL
+	}
+	if d := f.env().DiscoveryCacheTTL; d > 0 {
+		return d
+	}
+	return defaultDiscoveryCacheTTL
+}
+
+func (f *Forwarder) persistDiscoveryCache() bool {
+	if f.PersistDiscoveryCache {
+		return true
+	}
+	return f.env().PersistDiscoveryCache
+}
+
+// fresh reports whether the cache entry of the time is not expired.
+func (f *Forwarder) fresh(cachedAt int64, now time.Time) bool {
+	return now.Sub(time.Unix(cachedAt, 0)) < f.discoveryCacheTTL()
+}
+
+// loadDiscoveryCache loads the discovery cache from the state store at cold start,
+// if the cache is persisted. The caller must hold f.muDiscovery.
+func (f *Forwarder) loadDiscoveryCache(ctx context.Context) {
+	if f.discoveryCacheLoaded {
+		return
+	}
+	f.discoveryCacheLoaded = true
+	if !f.persistDiscoveryCache() {
+		return
+	}
+	store := f.stateStore(ctx)
+	if store == nil {
+		return
+	}
+	state, err := store.LoadState(ctx)
+	if err != nil {
+		f.logger().WarnContext(ctx, "failed to load the discovery cache", "error", err.Error())
+		return
+	}
+	if state.Discovery == nil {
+		return
+	}
+	f.discoveryCache = *state.Discovery
+	f.logger().InfoContext(ctx, "restore the discovery cache from the state store",
+		"metrics", len(f.discoveryCache.Metrics),
+		"resources", len(f.discoveryCache.Resources),
+	)
+}
+
+// discoveryCacheState returns the discovery cache to be saved in the state store.
+// It returns nil if the cache is not persisted.
+func (f *Forwarder) discoveryCacheState() *DiscoveryCache {
+	if !f.persistDiscoveryCache() {
+		return nil
+	}
+	f.muDiscovery.Lock()
+	defer f.muDiscovery.Unlock()
+
+	// drop the expired entries, so that the state doesn't grow.
+	now := f.now()
+	cache := &DiscoveryCache{}
+	for k, v := range f.discoveryCache.Metrics {
+		if f.fresh(v.CachedAt, now) {
+			if cache.Metrics == nil {
+				cache.Metrics = make(map[string]*DiscoveredMetrics)
+			}
+			cache.Metrics[k] = v
+		}
+	}
+	for k, v := range f.discoveryCache.Resources {
+		if f.fresh(v.CachedAt, now) {
+			if cache.Resources == nil {
+				cache.Resources = make(map[string]*DiscoveredResources)
+			}
+			cache.Resources[k] = v
+		}
+	}
+	if len(cache.Metrics) == 0 && len(cache.Resources) == 0 {
+		return nil
+	}
+	return cache
+}
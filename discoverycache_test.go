@@ -0,0 +1,120 @@
+package forwarder
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+	"github.com/google/go-cmp/cmp"
+)
+
+// countingListCloudWatch counts the calls of ListMetrics.
+type countingListCloudWatch struct {
+	listingCloudWatch
+	calls int
+}
+
+func (s *countingListCloudWatch) ListMetrics(ctx context.Context, params *cloudwatch.ListMetricsInput, optFns ...func(*cloudwatch.Options)) (*cloudwatch.ListMetricsOutput, error) {
+	s.calls++
+	return s.listingCloudWatch.ListMetrics(ctx, params, optFns...)
+}
+
+func TestListMetrics_Cache(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	store := &S3StateStore{
+		Bucket: "bucket",
+		Key:    "state.json",
+		svc:    &fakeS3{},
+	}
+	svc := &countingListCloudWatch{
+		listingCloudWatch: listingCloudWatch{
+			metrics: []types.Metric{
+				kinesisShardMetric("stream", "shardId-000000000000"),
+				kinesisShardMetric("stream", "shardId-000000000001"),
+			},
+		},
+	}
+	f := &Forwarder{
+		svccloudwatch:         svc,
+		StateStore:            store,
+		PersistDiscoveryCache: true,
+		Now:                   func() time.Time { return now },
+	}
+	query := []*Query{
+		{
+			Service:   "foo",
+			Name:      "kinesis.records",
+			Metric:    []interface{}{"AWS/Kinesis", "IncomingRecords", "StreamName", "stream", "ShardId", "*"},
+			Stat:      "Sum",
+			Aggregate: "sum",
+		},
+	}
+	resolved, errs := prepareQueries(query)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	fctx := &forwardContext{forwarder: f}
+	want, err := fctx.listMetrics(context.Background(), resolved[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the metrics are cached.
+	now = now.Add(time.Minute)
+	if _, err := fctx.listMetrics(context.Background(), resolved[0]); err != nil {
+		t.Fatal(err)
+	}
+	if svc.calls != 1 {
+		t.Errorf("want 1 call, got %d", svc.calls)
+	}
+
+	// the cache is restored in the new execution environment.
+	if err := f.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	g := &Forwarder{
+		svccloudwatch:         svc,
+		StateStore:            store,
+		PersistDiscoveryCache: true,
+		Now:                   func() time.Time { return now },
+	}
+	g.restoreState(context.Background())
+	got, err := (&forwardContext{forwarder: g}).listMetrics(context.Background(), resolved[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if svc.calls != 1 {
+		t.Errorf("want 1 call, got %d", svc.calls)
+	}
+	if diff := cmp.Diff(want, got, cmp.Comparer(func(a, b types.Metric) bool {
+		return aws.ToString(a.Namespace) == aws.ToString(b.Namespace) &&
+			aws.ToString(a.MetricName) == aws.ToString(b.MetricName) &&
+			dimensionsKey(a.Dimensions) == dimensionsKey(b.Dimensions)
+	})); diff != "" {
+		t.Errorf("metrics mismatch: (-want/+got):\n%s", diff)
+	}
+
+	// the cache expires.
+	now = now.Add(defaultDiscoveryCacheTTL)
+	if _, err := (&forwardContext{forwarder: g}).listMetrics(context.Background(), resolved[0]); err != nil {
+		t.Fatal(err)
+	}
+	if svc.calls != 2 {
+		t.Errorf("want 2 calls, got %d", svc.calls)
+	}
+}
+
+func TestDiscoveryCacheTTL(t *testing.T) {
+	if got := (&Forwarder{EnvConfig: &Config{}}).discoveryCacheTTL(); got != defaultDiscoveryCacheTTL {
+		t.Errorf("want %s, got %s", defaultDiscoveryCacheTTL, got)
+	}
+	if got := (&Forwarder{EnvConfig: &Config{DiscoveryCacheTTL: time.Hour}}).discoveryCacheTTL(); got != time.Hour {
+		t.Errorf("want %s, got %s", time.Hour, got)
+	}
+	if got := (&Forwarder{DiscoveryCacheTTL: time.Minute, EnvConfig: &Config{DiscoveryCacheTTL: time.Hour}}).discoveryCacheTTL(); got != time.Minute {
+		t.Errorf("want %s, got %s", time.Minute, got)
+	}
+}
@@ -0,0 +1,68 @@
+package forwarder
+
+import (
+	"context"
+	"time"
+)
+
+func (f *Forwarder) dryRun(ctx context.Context) bool {
+	if o := invocationOverridesFromContext(ctx).DryRun; o != nil {
+		return *o
+	}
+	if f.DryRun {
+		return true
+	}
+	return f.env().DryRun
+}
+
+// forwardMetricsDryRun fetches the metrics, and logs them instead of posting.
+// It doesn't touch Mackerel, so the auto registration of the hosts and the host metadata sync are skipped.
+func (f *Forwarder) forwardMetricsDryRun(ctx context.Context, query []*Query, at time.Time, report *InvocationReport) error {
+	start, end := f.timeWindow(ctx, at)
+	fctx := &forwardContext{
+		forwarder: f,
+		start:     start,
+		end:       end,
+		report:    report,
+	}
+
+	fetchCtx, cancel := f.fetchContext(ctx)
+	err := fctx.getMetricsData(fetchCtx, query)
+	cancel()
+
+	fctx.logMetrics(ctx)
+	return err
+}
+
+// logMetrics logs the metrics that would be posted.
+func (fctx *forwardContext) logMetrics(ctx context.Context) {
+	logger := fctx.forwarder.logger()
+	fctx.normalizeMetrics(ctx)
+
+	for service, metrics := range fctx.serviceMetrics {
+		for _, m := range metrics {
+			logger.InfoContext(ctx, "dry run: service metric",
+				"service", service,
+				"name", m.Name,
+				"time", m.Time,
+				"value", m.Value,
+			)
+		}
+	}
+	for _, m := range fctx.hostMetrics {
+		logger.InfoContext(ctx, "dry run: host metric",
+			"host_id", m.HostID,
+			"name", m.Name,
+			"time", m.Time,
+			"value", m.Value,
+		)
+	}
+	for _, r := range fctx.checkReports {
+		logger.InfoContext(ctx, "dry run: check monitoring report",
+			"host_id", r.Source.HostID,
+			"name", r.Name,
+			"status", string(r.Status),
+			"message", r.Message,
+		)
+	}
+}
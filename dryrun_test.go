@@ -0,0 +1,50 @@
+package forwarder
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+)
+
+func TestForwardMetrics_DryRun(t *testing.T) {
+	var buf bytes.Buffer
+	f := &Forwarder{
+		DryRun: true,
+		Logger: slog.New(slog.NewJSONHandler(&buf, nil)),
+		svccloudwatch: &fakeCloudWatch{
+			values: map[string][]float64{
+				"m1": {42},
+			},
+		},
+	}
+	data := json.RawMessage(`[{"service":"foo-bar","name":"ec2.cpu","metric":["AWS/EC2","CPUUtilization","InstanceId","i-1234567890"],"stat":"Average"}]`)
+
+	// the api key is not required, because nothing is posted to Mackerel.
+	if err := f.forwardMetrics(context.Background(), data, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var found bool
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var record map[string]any
+		if err := dec.Decode(&record); err != nil {
+			t.Fatal(err)
+		}
+		if record["msg"] != "dry run: service metric" {
+			continue
+		}
+		found = true
+		if record["service"] != "foo-bar" || record["name"] != "ec2.cpu" || record["value"] != float64(42) {
+			t.Errorf("unexpected record: %v", record)
+		}
+	}
+	if !found {
+		t.Errorf("the metric is not logged: %s", buf.String())
+	}
+	if f.svcmackerel != nil {
+		t.Error("the mackerel client is configured in dry run")
+	}
+}
@@ -0,0 +1,44 @@
+package forwarder
+
+import "context"
+
+// duplicateMetric is a pair of the queries that target the same Mackerel metric.
+type duplicateMetric struct {
+	Label Label
+
+	// Index is the index of the query that overwrites the metric.
+	Index int
+
+	// Overwritten is the index of the query whose metric is overwritten.
+	Overwritten int
+}
+
+// detectDuplicateMetrics detects the queries that target the same Mackerel metric.
+func detectDuplicateMetrics(queries []*metricQuery) []duplicateMetric {
+	var ret []duplicateMetric
+	seen := make(map[Label]int, len(queries))
+	for _, q := range queries {
+		if !q.Query.returnData() {
+			continue
+		}
+		if i, ok := seen[q.Label]; ok {
+			ret = append(ret, duplicateMetric{
+				Label:       q.Label,
+				Index:       q.Index,
+				Overwritten: i,
+			})
+		}
+		seen[q.Label] = q.Index
+	}
+	return ret
+}
+
+// warnDuplicateMetrics logs the queries that target the same Mackerel metric.
+func warnDuplicateMetrics(ctx context.Context, logger Logger, queries []*metricQuery) {
+	for _, d := range detectDuplicateMetrics(queries) {
+		logger.WarnContext(withQueryIndex(ctx, d.Index), "the query overwrites the metric of another query",
+			"label", d.Label.String(),
+			"overwritten", d.Overwritten,
+		)
+	}
+}
@@ -0,0 +1,31 @@
+package forwarder
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDetectDuplicateMetrics(t *testing.T) {
+	metric := []interface{}{"AWS/SQS", "NumberOfMessagesSent", "QueueName", "queue"}
+	query := []*Query{
+		{Service: "foo", Name: "sqs.sent", Metric: metric, Stat: "Sum"},
+		{Service: "foo", Name: "sqs.received", Metric: metric, Stat: "Sum"},
+		{Service: "bar", Name: "sqs.sent", Metric: metric, Stat: "Sum"},
+		{Service: "foo", Name: "sqs.sent", Metric: metric, Stat: "Average"},
+		{Service: "foo", Name: "sqs.sent", Metric: metric, Stat: "Maximum"},
+	}
+	resolved, errs := prepareQueries(query)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	got := detectDuplicateMetrics(resolved)
+	label := Label{Service: "foo", MetricName: "sqs.sent"}
+	want := []duplicateMetric{
+		{Label: label, Index: 3, Overwritten: 0},
+		{Label: label, Index: 4, Overwritten: 3},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("duplicates mismatch: (-want/+got):\n%s", diff)
+	}
+}
@@ -0,0 +1,50 @@
+package forwarder
+
+import (
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// endpointServices are the names of the AWS services whose endpoints can be configured.
+var endpointServices = []string{
+	"cloudwatch", "logs", "ssm", "kms", "secretsmanager", "tagging", "sts", "servicequotas", "costexplorer", "pi", "sqs",
+	"s3", "dynamodb",
+}
+
+// endpoint returns the custom endpoint URL of the AWS service.
+// It returns an empty string if the endpoint is not configured.
+func (f *Forwarder) endpoint(service string) string {
+	if u := f.Endpoints[service]; u != "" {
+		return u
+	}
+	return f.env().Endpoints[service]
+}
+
+func (f *Forwarder) useFIPSEndpoint() bool {
+	if f.UseFIPSEndpoint {
+		return true
+	}
+	return f.env().UseFIPSEndpoint
+}
+
+func (f *Forwarder) useDualStackEndpoint() bool {
+	if f.UseDualStackEndpoint {
+		return true
+	}
+	return f.env().UseDualStackEndpoint
+}
+
+// resolveEndpoint applies the endpoint settings of the service to the options of its client.
+// The FIPS and dual-stack settings are not applied to the custom endpoints,
+// because the AWS SDK rejects the combination.
+func (f *Forwarder) resolveEndpoint(service string, baseEndpoint **string, fips *aws.FIPSEndpointState, dualStack *aws.DualStackEndpointState) {
+	if u := f.endpoint(service); u != "" {
+		*baseEndpoint = aws.String(u)
+		return
+	}
+	if f.useFIPSEndpoint() {
+		*fips = aws.FIPSEndpointStateEnabled
+	}
+	if f.useDualStackEndpoint() {
+		*dualStack = aws.DualStackEndpointStateEnabled
+	}
+}
@@ -0,0 +1,102 @@
+package forwarder
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+)
+
+func TestForwarder_Endpoints(t *testing.T) {
+	var requested bool
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		requested = true
+		rw.Header().Set("Content-Type", "text/xml")
+		rw.Write([]byte(`<GetMetricDataResponse><GetMetricDataResult></GetMetricDataResult></GetMetricDataResponse>`))
+	}))
+	defer ts.Close()
+
+	f := &Forwarder{
+		Config: aws.Config{
+			Region:      "us-east-1",
+			Credentials: credentials.NewStaticCredentialsProvider("AKID", "SECRET", ""),
+		},
+		Endpoints: map[string]string{
+			"cloudwatch": ts.URL,
+		},
+		UseFIPSEndpoint: true,
+	}
+	now := time.Now()
+	_, err := f.cloudwatch().GetMetricData(context.Background(), &cloudwatch.GetMetricDataInput{
+		StartTime: aws.Time(now.Add(-time.Minute)),
+		EndTime:   aws.Time(now),
+		MetricDataQueries: []types.MetricDataQuery{
+			{Id: aws.String("m1"), Expression: aws.String("SEARCH('{AWS/EC2} CPUUtilization', 'Average', 60)")},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !requested {
+		t.Error("the custom endpoint is not used")
+	}
+
+	// the services without custom endpoints use the FIPS endpoints.
+	opts := f.ssm().(*ssm.Client).Options()
+	if opts.EndpointOptions.UseFIPSEndpoint != aws.FIPSEndpointStateEnabled {
+		t.Errorf("the FIPS endpoint is not enabled")
+	}
+	if opts.BaseEndpoint != nil {
+		t.Errorf("want no custom endpoint, got %q", *opts.BaseEndpoint)
+	}
+}
+
+func TestForwarder_EndpointFromEnv(t *testing.T) {
+	t.Setenv("FORWARD_ENDPOINT_SSM", "https://vpce-123.ssm.us-east-1.vpce.amazonaws.com")
+	f := &Forwarder{}
+	if got, want := f.endpoint("ssm"), "https://vpce-123.ssm.us-east-1.vpce.amazonaws.com"; got != want {
+		t.Errorf("want %q, got %q", want, got)
+	}
+	if got := f.endpoint("kms"); got != "" {
+		t.Errorf("want empty, got %q", got)
+	}
+}
+
+func TestForwarder_StoreEndpoints(t *testing.T) {
+	t.Setenv("FORWARD_STATE_STORE", "s3://bucket/state.json")
+	t.Setenv("FORWARD_IDEMPOTENCY_TABLE", "forwarder")
+	f := &Forwarder{
+		Config: aws.Config{
+			Region:      "us-east-1",
+			Credentials: credentials.NewStaticCredentialsProvider("AKID", "SECRET", ""),
+		},
+		Endpoints: map[string]string{
+			"s3":       "https://bucket.vpce-123.s3.us-east-1.vpce.amazonaws.com",
+			"dynamodb": "https://vpce-123.dynamodb.us-east-1.vpce.amazonaws.com",
+		},
+		UseFIPSEndpoint: true,
+	}
+
+	store := f.stateStore(context.Background()).(*S3StateStore)
+	if got := store.svc.(*s3.Client).Options().BaseEndpoint; got == nil || *got != "https://bucket.vpce-123.s3.us-east-1.vpce.amazonaws.com" {
+		t.Errorf("the custom endpoint of s3 is not used: %v", got)
+	}
+	if got := store.svckms.(*kms.Client).Options().EndpointOptions.UseFIPSEndpoint; got != aws.FIPSEndpointStateEnabled {
+		t.Errorf("the FIPS endpoint of kms is not enabled")
+	}
+
+	idempotency := f.idempotencyStore().(*DynamoDBIdempotencyStore)
+	if got := idempotency.svc.(*dynamodb.Client).Options().BaseEndpoint; got == nil || *got != "https://vpce-123.dynamodb.us-east-1.vpce.amazonaws.com" {
+		t.Errorf("the custom endpoint of dynamodb is not used: %v", got)
+	}
+}
@@ -0,0 +1,72 @@
+package forwarder
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FetchError is an error of fetching the metrics from AWS, e.g. throttling or missing permissions.
+// The configuration mistakes in the queries are reported as QueryError instead.
+type FetchError struct {
+	// Indexes is the indexes of the queries that failed to fetch.
+	Indexes []int
+
+	Err error
+}
+
+func (e *FetchError) Error() string {
+	indexes := make([]string, 0, len(e.Indexes))
+	for _, i := range e.Indexes {
+		indexes = append(indexes, fmt.Sprintf("query[%d]", i))
+	}
+	return fmt.Sprintf("forwarder: failed to fetch %s: %v", strings.Join(indexes, ", "), e.Err)
+}
+
+func (e *FetchError) Unwrap() error {
+	return e.Err
+}
+
+// the targets of PublishError.
+const (
+	publishServiceMetrics = "service metrics"
+	publishHostMetrics    = "host metrics"
+	publishCheckReports   = "check monitoring reports"
+)
+
+// PublishError is an error of posting the metrics to Mackerel or the other publishers.
+// The metrics that failed to post to Mackerel are retried in the next invocation.
+type PublishError struct {
+	// Service is the name of the service of the service metrics.
+	// It is empty for the host metrics and the check monitoring reports.
+	Service string
+
+	// Target is what failed to post, i.e. "service metrics", "host metrics", or "check monitoring reports".
+	Target string
+
+	// Publisher is the type of the publisher other than Mackerel, e.g. "*forwarder.PrometheusPublisher".
+	// It is empty for Mackerel.
+	Publisher string
+
+	Err error
+}
+
+func (e *PublishError) Error() string {
+	var b strings.Builder
+	b.WriteString("forwarder: failed to post ")
+	b.WriteString(e.Target)
+	if e.Service != "" {
+		b.WriteString(" of ")
+		b.WriteString(e.Service)
+	}
+	if e.Publisher != "" {
+		b.WriteString(" to ")
+		b.WriteString(e.Publisher)
+	}
+	b.WriteString(": ")
+	b.WriteString(e.Err.Error())
+	return b.String()
+}
+
+func (e *PublishError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,83 @@
+package forwarder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFetchError(t *testing.T) {
+	errThrottled := errors.New("throttled")
+	start := time.Unix(1234567860, 0)
+	fctx := &forwardContext{
+		forwarder: &Forwarder{
+			svccloudwatch: &failingStatisticsCloudWatch{
+				fail: map[string]error{"CPUUtilization": errThrottled},
+			},
+		},
+		start: start,
+		end:   start.Add(time.Minute),
+	}
+	query := []*Query{
+		{Service: "foo", Name: "disk", Metric: []interface{}{"AWS/EC2", "DiskReadOps"}, Stat: "Average", API: "statistics"},
+		{Service: "foo", Name: "cpu", Metric: []interface{}{"AWS/EC2", "CPUUtilization"}, Stat: "Average", API: "statistics"},
+	}
+	err := fctx.getMetricsData(context.Background(), query)
+
+	var ferr *FetchError
+	if !errors.As(err, &ferr) {
+		t.Fatalf("want FetchError, got %v", err)
+	}
+	if diff := cmp.Diff([]int{1}, ferr.Indexes); diff != "" {
+		t.Errorf("indexes mismatch: (-want/+got):\n%s", diff)
+	}
+	if !errors.Is(err, errThrottled) {
+		t.Errorf("want %v, got %v", errThrottled, err)
+	}
+	if !strings.HasPrefix(ferr.Error(), "forwarder: failed to fetch query[1]: ") {
+		t.Errorf("unexpected message: %q", ferr.Error())
+	}
+	var qerr *QueryError
+	if errors.As(err, &qerr) {
+		t.Errorf("want no QueryError, got %v", qerr)
+	}
+}
+
+func TestPublishError(t *testing.T) {
+	client := newTestMackerelClient(t, http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/services/bar/") {
+			rw.WriteHeader(http.StatusForbidden)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+	}))
+	f := &Forwarder{
+		svcmackerel: client,
+		svccloudwatch: &fakeCloudWatch{
+			values: map[string][]float64{"m1": {1}, "m2": {2}},
+		},
+	}
+	data := json.RawMessage(`[
+		{"service":"foo","name":"ec2.cpu","metric":["AWS/EC2","CPUUtilization","InstanceId","i-1"],"stat":"Average"},
+		{"service":"bar","name":"ec2.cpu","metric":["AWS/EC2","CPUUtilization","InstanceId","i-2"],"stat":"Average"}
+	]`)
+	_, err := f.ForwardMetrics(context.Background(), data)
+
+	var perr *PublishError
+	if !errors.As(err, &perr) {
+		t.Fatalf("want PublishError, got %v", err)
+	}
+	if perr.Service != "bar" || perr.Target != "service metrics" || perr.Publisher != "" {
+		t.Errorf("unexpected error: %#v", perr)
+	}
+	var merr Error
+	if !errors.As(err, &merr) || merr.StatusCode != http.StatusForbidden {
+		t.Errorf("want the error of mackerel, got %v", err)
+	}
+}
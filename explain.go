@@ -0,0 +1,99 @@
+package forwarder
+
+import (
+	"cmp"
+	"slices"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// ExplainedQuery is the effective query after resolving the shorthands, e.g. the "." inheritance of Metric.
+type ExplainedQuery struct {
+	// Index is the index of the query after expanding the presets.
+	Index int `json:"index"`
+
+	// ID is the id of the query in GetMetricData.
+	ID string `json:"id,omitempty"`
+
+	// Type is the type of the query.
+	Type string `json:"type,omitempty"`
+
+	// API is the CloudWatch API for fetching the metric.
+	API string `json:"api,omitempty"`
+
+	Namespace  string            `json:"namespace,omitempty"`
+	MetricName string            `json:"metricName,omitempty"`
+	Dimensions map[string]string `json:"dimensions,omitempty"`
+	Expression string            `json:"expression,omitempty"`
+	Stat       string            `json:"stat,omitempty"`
+	Period     string            `json:"period,omitempty"`
+	Delay      string            `json:"delay,omitempty"`
+
+	// Service, HostID and Name are the target of the metric on Mackerel.
+	Service string `json:"service,omitempty"`
+	HostID  string `json:"hostId,omitempty"`
+	Name    string `json:"name,omitempty"`
+
+	// ReturnData reports whether the result of the query is forwarded to Mackerel.
+	ReturnData bool `json:"returnData"`
+
+	// Default and Fill are the way to fill the missing data points.
+	Default *float64 `json:"default,omitempty"`
+	Fill    string   `json:"fill,omitempty"`
+
+	// Error is the reason why the query is invalid.
+	// The invalid queries are skipped by the forwarder.
+	Error string `json:"error,omitempty"`
+}
+
+// ExplainQueries returns the effective queries, so that the shorthands are audited.
+// The invalid queries are included with their errors.
+//
+// The wildcards, the templates of the tags, and the discovery are resolved on CloudWatch and the tags of the resources,
+// so they are shown as they are.
+func ExplainQueries(queries []*Query) []*ExplainedQuery {
+	resolved, errs := prepareQueries(queries)
+	ret := make([]*ExplainedQuery, 0, len(resolved)+len(errs))
+	for _, q := range resolved {
+		api := ""
+		if q.Query.Type == "" || q.Query.Type == queryTypeMetric {
+			api = cmp.Or(q.Query.API, apiData)
+		}
+		var dimensions map[string]string
+		if len(q.Dimensions) > 0 {
+			dimensions = make(map[string]string, len(q.Dimensions))
+			for _, d := range q.Dimensions {
+				dimensions[aws.ToString(d.Name)] = aws.ToString(d.Value)
+			}
+		}
+		ret = append(ret, &ExplainedQuery{
+			Index:      q.Index,
+			ID:         q.ID,
+			Type:       cmp.Or(q.Query.Type, queryTypeMetric),
+			API:        api,
+			Namespace:  q.Namespace,
+			MetricName: q.MetricName,
+			Dimensions: dimensions,
+			Expression: q.Query.Expression,
+			Stat:       q.Stat,
+			Period:     q.period().String(),
+			Delay:      q.Delay.String(),
+			Service:    q.Label.Service,
+			HostID:     q.Label.HostID,
+			Name:       q.Label.MetricName,
+			ReturnData: q.Query.returnData(),
+			Default:    q.Query.Default,
+			Fill:       q.Query.fill(),
+		})
+	}
+	for _, err := range errs {
+		ret = append(ret, &ExplainedQuery{
+			Index: err.Index,
+			Error: err.Err.Error(),
+		})
+	}
+	slices.SortStableFunc(ret, func(a, b *ExplainedQuery) int {
+		return cmp.Compare(a.Index, b.Index)
+	})
+	return ret
+}
@@ -0,0 +1,76 @@
+package forwarder
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestExplainQueries(t *testing.T) {
+	def := 0.0
+	queries, err := ParseQueries([]byte(`[
+		{"service":"foo","name":"sqs.sent","metric":["AWS/SQS","NumberOfMessagesSent","QueueName","queue"],"stat":"Sum","default":0},
+		{"service":"foo","name":"sqs.deleted","metric":[".","NumberOfMessagesDeleted",".","."],"stat":"Sum","period":"5m","delay":"2m"},
+		{"service":"foo","name":"sqs.invalid","metric":["."],"stat":"Sum"},
+		{"service":"foo","name":"sqs.ratio","expression":"m1 * 2"}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := ExplainQueries(queries)
+	want := []*ExplainedQuery{
+		{
+			Index:      0,
+			ID:         "m1",
+			Type:       "metric",
+			API:        "data",
+			Namespace:  "AWS/SQS",
+			MetricName: "NumberOfMessagesSent",
+			Dimensions: map[string]string{"QueueName": "queue"},
+			Stat:       "Sum",
+			Period:     "1m0s",
+			Delay:      "1m0s",
+			Service:    "foo",
+			Name:       "sqs.sent",
+			ReturnData: true,
+			Default:    &def,
+			Fill:       "default",
+		},
+		{
+			Index:      1,
+			ID:         "m2",
+			Type:       "metric",
+			API:        "data",
+			Namespace:  "AWS/SQS",
+			MetricName: "NumberOfMessagesDeleted",
+			Dimensions: map[string]string{"QueueName": "queue"},
+			Stat:       "Sum",
+			Period:     "5m0s",
+			Delay:      "2m0s",
+			Service:    "foo",
+			Name:       "sqs.deleted",
+			ReturnData: true,
+			Fill:       "none",
+		},
+		{
+			Index: 2,
+			Error: "at least, namespace and metric name are required: [.]",
+		},
+		{
+			Index:      3,
+			ID:         "m4",
+			Type:       "metric",
+			API:        "data",
+			Expression: "m1 * 2",
+			Period:     "1m0s",
+			Delay:      "1m0s",
+			Service:    "foo",
+			Name:       "sqs.ratio",
+			ReturnData: true,
+			Fill:       "none",
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("explained queries mismatch: (-want/+got):\n%s", diff)
+	}
+}
@@ -0,0 +1,130 @@
+package forwarder
+
+import (
+	"cmp"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// the formats of EncodeDatapoints.
+const (
+	// ExportFormatJSON is JSON Lines of Datapoint.
+	// The output can be ingested again by ForwardKinesis and ForwardFirehose.
+	ExportFormatJSON = "json"
+
+	// ExportFormatCSV is CSV with the header "host_id,service,name,time,value".
+	ExportFormatCSV = "csv"
+)
+
+// ExportOptions is the options of Export.
+type ExportOptions struct {
+	// Start and End are the time range for fetching the metrics.
+	// If they are zero, the time window of the current invocation is used.
+	Start time.Time
+	End   time.Time
+}
+
+// Export fetches the metrics of the queries, and returns the data points instead of posting them to Mackerel,
+// e.g. for audits and offline analysis.
+// Like the dry run, it doesn't touch Mackerel and the pending metrics.
+func (f *Forwarder) Export(ctx context.Context, data json.RawMessage, opts *ExportOptions) ([]Datapoint, error) {
+	if opts == nil {
+		opts = &ExportOptions{}
+	}
+	start, end := opts.Start, opts.End
+	if start.IsZero() || end.IsZero() {
+		start, end = f.timeWindow(ctx, f.now())
+	} else {
+		start, end = start.Truncate(time.Minute), end.Truncate(time.Minute)
+		if !start.Before(end) {
+			return nil, fmt.Errorf("forwarder: the export range is empty: from %s to %s", start.Format(time.RFC3339), end.Format(time.RFC3339))
+		}
+	}
+
+	expanded, err := f.expandPlaceholders(ctx, data)
+	if err != nil {
+		return nil, fmt.Errorf("forwarder: failed to expand placeholders: %w", err)
+	}
+	query, err := ParseQueries(expanded)
+	if err != nil {
+		return nil, fmt.Errorf("forwarder: failed to parse the input: %w", err)
+	}
+
+	fctx := &forwardContext{
+		forwarder: f,
+		start:     start,
+		end:       end,
+	}
+	fetchCtx, cancel := f.fetchContext(ctx)
+	err = fctx.getMetricsData(fetchCtx, query)
+	cancel()
+	fctx.normalizeMetrics(ctx)
+	return fctx.datapoints(), err
+}
+
+// datapoints returns the data points of the metrics in the context, sorted by the series and the time.
+func (fctx *forwardContext) datapoints() []Datapoint {
+	var points []Datapoint
+	for service, metrics := range fctx.serviceMetrics {
+		for _, m := range metrics {
+			points = append(points, Datapoint{
+				Service: service,
+				Name:    m.Name,
+				Time:    m.Time,
+				Value:   m.Value,
+			})
+		}
+	}
+	for _, m := range fctx.hostMetrics {
+		points = append(points, Datapoint{
+			HostID: m.HostID,
+			Name:   m.Name,
+			Time:   m.Time,
+			Value:  m.Value,
+		})
+	}
+	slices.SortFunc(points, func(a, b Datapoint) int {
+		return cmp.Or(
+			cmp.Compare(a.Service, b.Service),
+			cmp.Compare(a.HostID, b.HostID),
+			cmp.Compare(a.Name, b.Name),
+			cmp.Compare(a.Time, b.Time),
+		)
+	})
+	return points
+}
+
+// EncodeDatapoints writes the data points to w in the format, ExportFormatJSON or ExportFormatCSV.
+func EncodeDatapoints(w io.Writer, format string, points []Datapoint) error {
+	switch format {
+	case "", ExportFormatJSON:
+		enc := json.NewEncoder(w)
+		for _, p := range points {
+			if err := enc.Encode(p); err != nil {
+				return err
+			}
+		}
+		return nil
+	case ExportFormatCSV:
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"host_id", "service", "name", "time", "value"})
+		for _, p := range points {
+			cw.Write([]string{
+				p.HostID,
+				p.Service,
+				p.Name,
+				strconv.FormatInt(p.Time, 10),
+				strconv.FormatFloat(p.Value, 'g', -1, 64),
+			})
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+	return fmt.Errorf("forwarder: unknown export format: %q", format)
+}
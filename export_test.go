@@ -0,0 +1,96 @@
+package forwarder
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestExport(t *testing.T) {
+	f := &Forwarder{
+		svccloudwatch: &fakeCloudWatch{
+			values: map[string][]float64{
+				"m1": {1, 2},
+				"m2": {3},
+			},
+		},
+	}
+	data := []byte(`[
+		{"service":"foo","name":"ec2.cpu","metric":["AWS/EC2","CPUUtilization"],"stat":"Average"},
+		{"host":"host-abc","name":"rds.cpu","metric":["AWS/RDS","CPUUtilization"],"stat":"Average"}
+	]`)
+	start := time.Unix(1234567860, 0)
+	points, err := f.Export(context.Background(), data, &ExportOptions{
+		Start: start,
+		End:   start.Add(2 * time.Minute),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Datapoint{
+		{HostID: "host-abc", Name: "rds.cpu", Time: start.Unix(), Value: 3},
+		{Service: "foo", Name: "ec2.cpu", Time: start.Unix(), Value: 1},
+		{Service: "foo", Name: "ec2.cpu", Time: start.Add(time.Minute).Unix(), Value: 2},
+	}
+	if diff := cmp.Diff(want, points); diff != "" {
+		t.Errorf("datapoints mismatch: (-want/+got):\n%s", diff)
+	}
+}
+
+func TestExport_EmptyRange(t *testing.T) {
+	f := &Forwarder{}
+	start := time.Unix(1234567860, 0)
+	_, err := f.Export(context.Background(), []byte(`[]`), &ExportOptions{
+		Start: start,
+		End:   start,
+	})
+	if err == nil {
+		t.Error("want an error, got nil")
+	}
+}
+
+func TestEncodeDatapoints(t *testing.T) {
+	points := []Datapoint{
+		{HostID: "host-abc", Name: "rds.cpu", Time: 1234567860, Value: 3},
+		{Service: "foo", Name: "ec2.cpu", Time: 1234567860, Value: 1.5},
+	}
+
+	t.Run("json", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := EncodeDatapoints(&buf, ExportFormatJSON, points); err != nil {
+			t.Fatal(err)
+		}
+		// the output can be parsed as the input of the generic metric ingestion.
+		got, err := parseDatapoints(buf.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(points, got); diff != "" {
+			t.Errorf("datapoints mismatch: (-want/+got):\n%s", diff)
+		}
+	})
+
+	t.Run("csv", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := EncodeDatapoints(&buf, ExportFormatCSV, points); err != nil {
+			t.Fatal(err)
+		}
+		want := "host_id,service,name,time,value\n" +
+			"host-abc,,rds.cpu,1234567860,3\n" +
+			",foo,ec2.cpu,1234567860,1.5\n"
+		if got := buf.String(); got != want {
+			t.Errorf("want %q, got %q", want, got)
+		}
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := EncodeDatapoints(&buf, "parquet", points); err == nil {
+			t.Error("want an error, got nil")
+		}
+	})
+}
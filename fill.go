@@ -0,0 +1,102 @@
+package forwarder
+
+import (
+	"context"
+	"time"
+)
+
+// the fill options for the missing data points.
+const (
+	// fillNone doesn't fill the missing data points.
+	fillNone = "none"
+
+	// fillZero fills the missing data points with zero.
+	fillZero = "zero"
+
+	// fillLast fills the missing data points with the last value.
+	fillLast = "last"
+
+	// fillDefault fills the missing data points with the default value of the query.
+	fillDefault = "default"
+)
+
+// fill returns the fill option of the query.
+// The default is "default" if the default value is specified, otherwise "none".
+func (q *Query) fill() string {
+	if q.Fill != "" {
+		return q.Fill
+	}
+	if q.Default != nil {
+		return fillDefault
+	}
+	return fillNone
+}
+
+// lookback returns the length of the time window for fetching the metrics.
+// The lookback in the input of the invocation overrides the configuration.
+func (f *Forwarder) lookback(ctx context.Context) time.Duration {
+	d := invocationOverridesFromContext(ctx).lookback
+	if d == 0 {
+		d = f.Lookback
+	}
+	if d == 0 {
+		d = f.env().Lookback
+	}
+	d = d.Truncate(time.Minute)
+	if d < time.Minute {
+		d = time.Minute
+	}
+	return d
+}
+
+// fillMissingValues fills the missing data points of the query in the time window.
+func (fctx *forwardContext) fillMissingValues(q *metricQuery) {
+	fill := q.Query.fill()
+	if fill == fillNone {
+		return
+	}
+
+	l := q.Label.String()
+	points := fctx.points[l]
+	last, hasLast := fctx.forwarder.lastValues[l]
+	start, _ := fctx.window(q)
+	end := fctx.completeEnd(q)
+	for t := start; t.Before(end); t = t.Add(q.period()) {
+		if v, ok := points[t.Unix()]; ok {
+			last, hasLast = latestValue{Time: t, Value: v}, true
+			continue
+		}
+		if fctx.isForwarded(l, t) {
+			continue
+		}
+		switch fill {
+		case fillZero:
+			fctx.appendValue(q.Query, q.Label, t, 0)
+		case fillDefault:
+			fctx.appendValue(q.Query, q.Label, t, *q.Query.Default)
+		case fillLast:
+			if hasLast {
+				fctx.appendValue(q.Query, q.Label, t, last.Value)
+			} else if q.Query.Default != nil {
+				fctx.appendValue(q.Query, q.Label, t, *q.Query.Default)
+			}
+		}
+	}
+}
+
+// rememberLastValues remembers the latest values for filling in the next invocation.
+func (fctx *forwardContext) rememberLastValues(queries map[string]*metricQuery) {
+	for l, q := range queries {
+		if q.Query.fill() != fillLast {
+			continue
+		}
+		v, ok := fctx.latestComplete(l, q)
+		if !ok {
+			continue
+		}
+		if fctx.forwarder.lastValues == nil {
+			fctx.forwarder.lastValues = make(map[string]latestValue)
+		}
+		fctx.forwarder.lastValues[l] = v
+	}
+}
@@ -0,0 +1,105 @@
+package forwarder
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestGetMetricsData_Fill(t *testing.T) {
+	start := time.Unix(1234567860, 0)
+	def := 7.0
+	fctx := &forwardContext{
+		forwarder: &Forwarder{
+			svccloudwatch: &fakeCloudWatch{
+				values: map[string][]float64{
+					"m1": {5},
+					"m3": {1},
+				},
+			},
+			lastValues: map[string]latestValue{
+				"service=foo:last.previous": {Time: start.Add(-time.Minute), Value: 3},
+			},
+		},
+		start: start,
+		end:   start.Add(3 * time.Minute),
+	}
+	metric := []interface{}{"AWS/SQS", "NumberOfMessagesSent", "QueueName", "queue"}
+	query := []*Query{
+		{Service: "foo", Name: "last", Metric: metric, Stat: "Sum", Fill: "last"},
+		{Service: "foo", Name: "zero", Metric: metric, Stat: "Sum", Fill: "zero"},
+		{Service: "foo", Name: "default", Metric: metric, Stat: "Sum", Default: &def},
+		{Service: "foo", Name: "last.previous", Metric: metric, Stat: "Sum", Fill: "last"},
+		{Service: "foo", Name: "none", Metric: metric, Stat: "Sum"},
+	}
+	if err := fctx.getMetricsData(context.Background(), query); err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string][]float64{}
+	for _, v := range fctx.serviceMetrics["foo"] {
+		got[v.Name] = append(got[v.Name], v.Value)
+	}
+	want := map[string][]float64{
+		"last":          {5, 5, 5},
+		"zero":          {0, 0, 0},
+		"default":       {1, 7, 7},
+		"last.previous": {3, 3, 3},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("metrics mismatch: (-want/+got):\n%s", diff)
+	}
+
+	// the last values are remembered for the next invocation.
+	if v := fctx.forwarder.lastValues["service=foo:last"]; v.Value != 5 || !v.Time.Equal(start.Add(2*time.Minute)) {
+		t.Errorf("unexpected last value: %v", v)
+	}
+}
+
+func TestGetMetricsData_HighResolution(t *testing.T) {
+	start := time.Now().Truncate(time.Minute).Add(-10 * time.Minute)
+	zero := 0.0
+	marks := map[string]int64{}
+	fctx := &forwardContext{
+		forwarder: &Forwarder{
+			svccloudwatch: &fakeCloudWatch{
+				values: map[string][]float64{"m1": {1, 2, 3}},
+			},
+		},
+		start:          start,
+		end:            start.Add(time.Minute),
+		highWaterMarks: marks,
+	}
+	query := []*Query{
+		{Service: "foo", Name: "a", Metric: []interface{}{"AWS/EC2", "CPUUtilization"}, Stat: "Average", Period: "10s", Default: &zero},
+	}
+	if err := fctx.getMetricsData(context.Background(), query); err != nil {
+		t.Fatal(err)
+	}
+
+	// the data points in a minute are not collapsed, and the missing ones are filled every period.
+	want := serviceMetricsType{
+		"foo": {
+			{Name: "a", Time: start.Unix(), Value: 1},
+			{Name: "a", Time: start.Add(10 * time.Second).Unix(), Value: 2},
+			{Name: "a", Time: start.Add(20 * time.Second).Unix(), Value: 3},
+			{Name: "a", Time: start.Add(30 * time.Second).Unix(), Value: 0},
+			{Name: "a", Time: start.Add(40 * time.Second).Unix(), Value: 0},
+			{Name: "a", Time: start.Add(50 * time.Second).Unix(), Value: 0},
+		},
+	}
+	if diff := cmp.Diff(want, fctx.serviceMetrics); diff != "" {
+		t.Errorf("service metrics mismatch: (-want/+got):\n%s", diff)
+	}
+
+	// the next fetch starts from the next period of the high-water mark.
+	postAll(fctx)
+	if got, want := marks["service=foo:a"], start.Add(50*time.Second).Unix(); got != want {
+		t.Errorf("unexpected mark: want %d, got %d", want, got)
+	}
+	if got, want := fctx.fetchStart([]*metricQuery{{Query: query[0], Label: Label{Service: "foo", MetricName: "a"}, Period: 10 * time.Second}}, start), start.Add(time.Minute); !got.Equal(want) {
+		t.Errorf("unexpected fetch start: want %s, got %s", want, got)
+	}
+}
@@ -1,33 +1,52 @@
 package forwarder
 
 import (
+	"cmp"
 	"context"
-	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
 	"net/url"
-	"os"
+	"slices"
 	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
 	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/resourcegroupstaggingapi"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
 	"github.com/aws/aws-sdk-go-v2/service/ssm"
-	phperjson "github.com/shogo82148/go-phper-json"
-	"github.com/sirupsen/logrus"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"golang.org/x/time/rate"
 )
 
+// Version returns the version of the forwarder.
+func Version() string {
+	return version
+}
+
 // Forwarder forwards metrics of AWS CloudWatch to Mackerel
 type Forwarder struct {
 	Config aws.Config
 
 	APIURL string
 
+	// MackerelProxy is the URL of the HTTP(S) proxy for the Mackerel API, e.g. "http://proxy.example.com:3128".
+	// It doesn't affect the AWS endpoints. "direct" disables the proxy for the Mackerel API.
+	// If it is empty, the FORWARD_MACKEREL_PROXY environment value is used.
+	// If both are empty, the HTTP_PROXY, HTTPS_PROXY, and NO_PROXY environment values are used as usual.
+	MackerelProxy string
+
 	// APIKey is api key for the Mackerel.
 	// If it empty, the MACKEREL_APIKEY environment value is used.
 	// The priority is APIKey, APIKeyParameter, MACKEREL_APIKEY, and the MACKEREL_APIKEY_PARAMETER.
+	// If all of them are empty, the secret of AWS Secrets Manager named by MACKEREL_APIKEY_SECRET is used.
+	// Its MACKEREL_APIKEY_SECRET_KEY field is the API key if the secret is a JSON object.
 	APIKey string
 
 	// APIKeyParameter is a name of AWS Systems Manager Parameter Store for the Mackerel api key.
@@ -40,214 +59,837 @@ type Forwarder struct {
 	// If not, the MACKEREL_APIKEY_WITH_DECRYPT environment value is used.
 	APIKeyWithDecrypt bool
 
-	mu            sync.Mutex
-	svcmackerel   *MackerelClient
-	svcssm        ssmiface
-	svckms        kmsiface
-	svccloudwatch cloudwatchiface
+	// KeyProvider provides the API key of Mackerel.
+	// If it is set, APIKey, APIKeyParameter, APIKeyWithDecrypt, and the environment values for them are ignored.
+	KeyProvider KeyProvider
+
+	// AutoRegisterHosts enables registering Mackerel hosts for the AWS resources
+	// that are referenced by the queries without service name and host id.
+	// If not, the FORWARD_AUTO_REGISTER_HOSTS environment value is used.
+	AutoRegisterHosts bool
+
+	// SyncHostMetadata enables pushing the tags of the AWS resources as Mackerel host metadata.
+	// The resources are specified by the resourceArn field of the queries.
+	// If not, the FORWARD_SYNC_HOST_METADATA environment value is used.
+	SyncHostMetadata bool
+
+	// Publishers are the publishers to which the metrics are dual-written in addition to Mackerel.
+	// The failures of them are only warned, and the metrics are not retried.
+	// They receive only the metrics fetched in the invocation, and not the metrics retried on Mackerel.
+	// If the FORWARD_PROMETHEUS_REMOTE_WRITE_URL environment value is set,
+	// the Prometheus remote write publisher is also used.
+	// If the FORWARD_OTLP_ENDPOINT environment value is set, the OTLP/HTTP publisher is also used.
+	Publishers []Publisher
+
+	// TimeoutMargin is the safety margin for the deadline of AWS Lambda.
+	// It is a percentage of the remaining time (e.g. "10%") or a duration (e.g. "5s").
+	// If it empty, the FORWARD_TIMEOUT_MARGIN environment value is used. The default is "10%".
+	TimeoutMargin string
+
+	// FetchBudget is the time budget for fetching the metrics,
+	// so that publishing the metrics always gets the rest of the time.
+	// It is a percentage of the remaining time (e.g. "70%") or a duration (e.g. "30s").
+	// If it empty, the FORWARD_FETCH_BUDGET environment value is used. The default is "70%".
+	FetchBudget string
+
+	// StateStore is the store for the pending metrics.
+	// The pending metrics are saved by Shutdown, and restored on the first invocation.
+	// If it is nil, the FORWARD_STATE_STORE environment value (e.g. "s3://bucket/state.json") is used,
+	// and the state is encrypted by the KMS key of the FORWARD_STATE_KMS_KEY environment value if it is set.
+	// The existing state in plain text is loaded only if FORWARD_STATE_KMS_MIGRATE is also set.
+	StateStore StateStore
+
+	// QueryFile is the path of the query definition file.
+	// If it is specified, the queries are loaded from the file instead of the input event.
+	// The files with the ".jsonnet" extension are evaluated as Jsonnet,
+	// so that similar queries can be generated by loops and imports.
+	// If it empty, the FORWARD_QUERY_FILE environment value is used.
+	QueryFile string
+
+	// Strict makes the invocation fail when any query is invalid,
+	// or the query definition doesn't match the JSON Schema of QuerySchema.
+	// If not, the invalid queries are skipped with warnings,
+	// and the FORWARD_STRICT environment value is used.
+	Strict bool
+
+	// Lookback is the length of the time window for fetching the metrics.
+	// It is truncated to a minute, and the minimum is a minute.
+	// If it is zero, the FORWARD_LOOKBACK environment value is used. The default is a minute.
+	Lookback time.Duration
+
+	// Interval is the interval of forwarding the metrics within an invocation, for the high-resolution metrics.
+	// If it is less than a minute, ForwardMetrics forwards the metrics every interval until the next minute,
+	// and the time windows include the minutes in progress, so that the data points are posted as soon as they arrive.
+	// The invocations must be scheduled every minute, and the timeout of AWS Lambda must be longer than a minute.
+	// If it is zero, the FORWARD_INTERVAL environment value is used.
+	// If both are empty, the metrics are forwarded once per invocation.
+	Interval time.Duration
+
+	// SanitizeMetricNames enables replacing the invalid characters in the metric names with "_".
+	// If not, the metrics with invalid names are dropped with warnings,
+	// and the FORWARD_SANITIZE_METRIC_NAMES environment value is used.
+	SanitizeMetricNames bool
+
+	// MackerelRPS is the maximum number of the requests per second to the Mackerel API.
+	// If it is zero, the FORWARD_MACKEREL_RPS environment value is used.
+	// If both are empty, the requests are not limited.
+	MackerelRPS float64
+
+	// PublishConcurrency is the maximum number of the concurrent requests for publishing the metrics.
+	// If it is zero, the FORWARD_PUBLISH_CONCURRENCY environment value is used. The default is 8.
+	PublishConcurrency int
+
+	// DryRun makes the forwarder log the metrics instead of posting them to Mackerel.
+	// The metrics are fetched as usual, but nothing is written to Mackerel, the other publishers, and the state store.
+	// The metrics are logged at the info level.
+	// If it is false, the FORWARD_DRY_RUN environment value is used.
+	DryRun bool
+
+	// Endpoints are the custom endpoint URLs of the AWS services keyed by the service names,
+	// e.g. {"cloudwatch": "http://localhost:4566"} for LocalStack, or the DNS names of the VPC interface endpoints.
+	// The service names are "cloudwatch", "logs", "ssm", "kms", "secretsmanager", "tagging", "sts",
+	// "servicequotas", "costexplorer", "pi", "sqs", "s3" (the state store), and "dynamodb" (the idempotency store).
+	// If the service is not in it, the FORWARD_ENDPOINT_<SERVICE> environment value (e.g. FORWARD_ENDPOINT_CLOUDWATCH) is used.
+	// If both are empty, the endpoint is resolved by the AWS SDK as usual.
+	Endpoints map[string]string
+
+	// UseFIPSEndpoint makes the clients of the AWS services use the FIPS endpoints.
+	// It is not applied to the services that have custom endpoints.
+	// If not, the FORWARD_USE_FIPS_ENDPOINT environment value is used.
+	UseFIPSEndpoint bool
+
+	// UseDualStackEndpoint makes the clients of the AWS services use the dual-stack (IPv4 and IPv6) endpoints.
+	// It is not applied to the services that have custom endpoints.
+	// If not, the FORWARD_USE_DUALSTACK_ENDPOINT environment value is used.
+	UseDualStackEndpoint bool
+
+	// SkipAPIKeyVerification disables verifying the API key by the organization API of Mackerel
+	// when the Mackerel client is configured.
+	// If not, the FORWARD_SKIP_API_KEY_VERIFICATION environment value is used.
+	SkipAPIKeyVerification bool
+
+	// MaxMetricAge is the maximum age of the data points for posting.
+	// The older data points are dropped before posting, because Mackerel rejects the whole request that contains them.
+	// The queries whose delay plus period reaches it are invalid, because all of their data points are dropped.
+	// If it is zero, the FORWARD_MAX_METRIC_AGE environment value is used. The default is 6 hours.
+	MaxMetricAge time.Duration
+
+	// MaxPendingMetrics is the maximum number of the pending data points for retrying.
+	// When it is exceeded, the oldest data points are dropped,
+	// so that the memory usage is bounded during long outages of Mackerel.
+	// If it is zero, the FORWARD_MAX_PENDING_METRICS environment value is used. The default is 100000.
+	MaxPendingMetrics int
+
+	// IdempotencyStore records the forwarded time windows,
+	// so that the retries of an invocation skip the windows that are already forwarded.
+	// If it is nil, the FORWARD_IDEMPOTENCY_TABLE environment value (the name of a DynamoDB table) is used.
+	// If both are empty, the invocations are not deduplicated.
+	// The invocations are identified by the id or the time of the event in the input, or the request id of AWS Lambda,
+	// and the invocations that have none of them are not deduplicated.
+	IdempotencyStore IdempotencyStore
+
+	// AutoCreateServices enables creating the services on Mackerel before posting the service metrics,
+	// if the services don't exist. Mackerel rejects the service metrics of unknown services.
+	// If not, the FORWARD_AUTO_CREATE_SERVICE environment value is used.
+	AutoCreateServices bool
+
+	// ValidateHosts enables validating the host ids of the host metrics by the host list of Mackerel.
+	// The metrics of the retired or unknown hosts are dropped with warnings instead of posting.
+	// If not, the FORWARD_VALIDATE_HOSTS environment value is used.
+	ValidateHosts bool
+
+	// HostListTTL is the duration for caching the host list for ValidateHosts,
+	// and the host ids resolved from the custom identifiers.
+	// If it is zero, the FORWARD_HOST_LIST_TTL environment value is used. The default is 10 minutes.
+	HostListTTL time.Duration
+
+	// RetiredHostTTL is the duration for skipping the hosts that Mackerel reports as retired or not found.
+	// The queries and the metrics of the hosts are skipped during the period.
+	// If it is zero, the FORWARD_RETIRED_HOST_TTL environment value is used. The default is 1 hour.
+	RetiredHostTTL time.Duration
+
+	// DiscoveryCacheTTL is the duration for caching the results of ListMetrics for the wildcard dimension values
+	// and the resources discovered by the tags, so that they are not called every minute.
+	// If it is zero, the FORWARD_DISCOVERY_CACHE_TTL environment value is used. The default is 10 minutes.
+	DiscoveryCacheTTL time.Duration
+
+	// PersistDiscoveryCache enables saving the discovery cache in the state store,
+	// so that it survives the cold starts.
+	// If not, the FORWARD_PERSIST_DISCOVERY_CACHE environment value is used.
+	PersistDiscoveryCache bool
+
+	// VerifyMetrics enables verifying that the metrics of the queries exist on CloudWatch by ListMetrics.
+	// The queries of the nonexistent metrics are warned, because they always return empty data silently.
+	// With Strict, the invocation fails instead. Each metric is verified once in the execution environment.
+	// If not, the FORWARD_VERIFY_METRICS environment value is used.
+	VerifyMetrics bool
+
+	// LookupLatest enables looking up the latest values of the host metrics on Mackerel at cold start.
+	// The high-water marks are advanced to them, so that the backfills and the replays
+	// don't post the data points that are already present on Mackerel.
+	// If not, the FORWARD_LOOKUP_LATEST environment value is used.
+	LookupLatest bool
+
+	// Sink receives the data points collected by CollectMetrics.
+	// If it is nil, the SQS queue of the FORWARD_COLLECT_QUEUE_URL environment value is used.
+	Sink MetricSink
+
+	// ConfigureMackerelClient is called with the client of Mackerel when it is created,
+	// e.g. for setting its UserAgent, RequestMiddleware, and ResponseHooks.
+	ConfigureMackerelClient func(client *MackerelClient)
+
+	// Logger is the logger of the forwarder.
+	// *slog.Logger satisfies it. If it is nil, slog.Default() is used.
+	Logger Logger
+
+	// CloudWatch, SSM, KMS and SecretsManager are the clients of the AWS services.
+	// If they are nil, the clients are created from the default AWS config.
+	// Set them to use fakes in tests, see the forwardertest package.
+	CloudWatch     CloudWatchAPI
+	SSM            SSMAPI
+	KMS            KMSAPI
+	SecretsManager SecretsManagerAPI
+
+	// EnvConfig is the configuration from the environment values.
+	// If it is nil, it is loaded from the environment values on the first use,
+	// and the invalid values are ignored with warnings. See LoadConfigFromEnv for validating them.
+	EnvConfig *Config
+
+	// Now returns the current time, which decides the time window of the invocations.
+	// It allows the tests and the replay tools to control the time window.
+	// If it is nil, time.Now is used.
+	Now func() time.Time
+
+	envOnce   sync.Once
+	envConfig *Config
+
+	mu             sync.Mutex
+	svcmackerel    *MackerelClient
+	unverified     bool // the api key of svcmackerel is not verified yet
+	svcssm         ssmiface
+	svckms         kmsiface
+	svcsecrets     SecretsManagerAPI
+	svckeyprovider KeyProvider
+	svccloudwatch  cloudwatchiface
+	svcbilling     cloudwatchiface // the client of CloudWatch for the billing metrics
+	svctagging     taggingiface
+	svclogs        logsiface
+	svcpi          piiface
+	svcsts         stsiface
+	svcsqs         SQSAPI
+
+	svccostexplorer  costexploreriface
+	svcservicequotas servicequotasiface
+	svcpublishers    []Publisher
+	svcstate         StateStore
+	svcidempotency   IdempotencyStore
+
+	// svcmackerelByParameter is the clients for apiKeyParameter in the inputs, keyed by the parameter names.
+	svcmackerelByParameter map[string]*MackerelClient
 
 	muPending             sync.Mutex
 	pendingServiceMetrics serviceMetricsType
 	pendingHostMetrics    hostMetricsType
+	stateRestored         bool
+	lastValues            map[string]latestValue // label -> the last value for the fill option "last"
+	highWaterMarks        map[string]int64       // label -> the unix time of the latest forwarded data point
+	latestLookedUp        bool                   // the high-water marks are seeded by the latest values on Mackerel
+
+	muMackerelCaches sync.Mutex
+	mackerelCaches   map[string]*mackerelCache // api key -> the caches of the organization
+
+	muMetadata     sync.Mutex
+	metadataSynced map[string]time.Time // host id -> last synced time
+
+	muDiscovery          sync.Mutex
+	discoveryCache       DiscoveryCache
+	discoveryCacheLoaded bool
+
+	muVerifiedMetrics sync.Mutex
+	verifiedMetrics   map[string]bool // namespace:metric:dimensions -> whether the metric exists
+
+	muResourceTags sync.Mutex
+	resourceTags   map[string]cachedResourceTags // arn -> tags
+
+	muStats              sync.Mutex
+	stats                forwarderStats
+	credentialsCheckedAt time.Time // the time when the AWS credentials are checked by Health
+	credentialsARN       string
+	credentialsErr       error
+}
+
+func (f *Forwarder) now() time.Time {
+	if f.Now != nil {
+		return f.Now()
+	}
+	return time.Now()
+}
+
+func (f *Forwarder) strict() bool {
+	if f.Strict {
+		return true
+	}
+	return f.env().Strict
+}
+
+func (f *Forwarder) mackerelRPS(ctx context.Context) float64 {
+	if f.MackerelRPS > 0 {
+		return f.MackerelRPS
+	}
+	return f.env().MackerelRPS
+}
+
+// defaultPublishConcurrency is the default number of the concurrent requests for publishing the metrics.
+const defaultPublishConcurrency = 8
+
+func (f *Forwarder) publishConcurrency(ctx context.Context) int {
+	if f.PublishConcurrency > 0 {
+		return f.PublishConcurrency
+	}
+	if n := f.env().PublishConcurrency; n > 0 {
+		return n
+	}
+	return defaultPublishConcurrency
 }
 
 func (f *Forwarder) mackerel(ctx context.Context) (*MackerelClient, error) {
-	svcssm := f.ssm()
-	svckms := f.kms()
+	if name := invocationOverridesFromContext(ctx).APIKeyParameter; name != "" {
+		return f.mackerelWithParameter(ctx, name)
+	}
+
+	provider := f.keyProvider()
+	f.refreshRotatedKey(ctx, provider)
 	f.mu.Lock()
 	defer f.mu.Unlock()
-	if f.svcmackerel != nil {
+	if f.svcmackerel != nil && !f.unverified {
 		return f.svcmackerel, nil
 	}
-	key, err := f.apiKey(ctx, svcssm, svckms)
-	if err != nil {
-		return nil, err
-	}
-	f.svcmackerel = NewMackerelClient(key)
-	if f.APIURL != "" {
-		u, err := url.Parse(f.APIURL)
+
+	client := f.svcmackerel
+	if client == nil {
+		key, err := provider.APIKey(ctx)
+		if err != nil {
+			return nil, err
+		}
+		client, err = f.newMackerelClient(ctx, key)
 		if err != nil {
 			return nil, err
 		}
-		f.svcmackerel.BaseURL = u
 	}
-	return f.svcmackerel, nil
-}
 
-func (f *Forwarder) apiKey(ctx context.Context, svcssm ssmiface, svckms kmsiface) (string, error) {
-	decrypt := f.APIKeyWithDecrypt
-	if os.Getenv("MACKEREL_APIKEY_WITH_DECRYPT") != "" {
-		decrypt = true
+	// verify the api key on the first invocation, and after the key is resolved again.
+	f.unverified = false
+	if !f.skipAPIKeyVerification() {
+		if err := f.verifyAPIKey(ctx, client); err != nil {
+			if !errors.Is(err, ErrMackerelUnreachable) {
+				// discard the client, so that the api key is resolved again in the next invocation.
+				f.svcmackerel = nil
+				return nil, err
+			}
+			// Mackerel may be temporarily unavailable.
+			// the metrics are kept for retrying, and the key is verified again in the next invocation.
+			f.unverified = true
+		}
 	}
+	f.svcmackerel = client
+	return client, nil
+}
 
-	if key := f.APIKey; key != "" {
-		if !decrypt {
-			return key, nil
-		}
-		b, err := base64.StdEncoding.DecodeString(key)
-		if err != nil {
-			return "", err
-		}
-		resp, err := svckms.Decrypt(ctx, &kms.DecryptInput{
-			CiphertextBlob: b,
-		})
-		if err != nil {
-			return "", err
-		}
-		key = string(resp.Plaintext)
-		return key, nil
+// newMackerelClient returns a new client of Mackerel with the API key.
+func (f *Forwarder) newMackerelClient(ctx context.Context, key string) (*MackerelClient, error) {
+	client := NewMackerelClient(key)
+	client.Logger = f.Logger
+	if d := f.env().RetryMargin; d > 0 {
+		client.RetryMargin = d
 	}
-	if f.APIKeyParameter != "" {
-		resp, err := svcssm.GetParameter(ctx, &ssm.GetParameterInput{
-			Name:           aws.String(f.APIKeyParameter),
-			WithDecryption: aws.Bool(decrypt),
-		})
+	if rps := f.mackerelRPS(ctx); rps > 0 {
+		client.RateLimiter = rate.NewLimiter(rate.Limit(rps), max(1, int(rps)))
+	}
+	if apiURL := cmp.Or(f.APIURL, f.env().APIURL); apiURL != "" {
+		u, err := url.Parse(apiURL)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
-		return aws.ToString(resp.Parameter.Value), nil
+		client.BaseURL = u
 	}
-	if key := os.Getenv("MACKEREL_APIKEY"); key != "" {
-		if !decrypt {
-			return key, nil
-		}
-		b, err := base64.StdEncoding.DecodeString(key)
+	if proxy := f.mackerelProxy(); proxy != "" {
+		p, err := parseProxy(proxy)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
-		resp, err := svckms.Decrypt(ctx, &kms.DecryptInput{
-			CiphertextBlob: b,
-		})
-		if err != nil {
-			return "", err
+		if t, ok := client.HTTPClient.Transport.(*http.Transport); ok {
+			t.Proxy = p
 		}
-		key = string(resp.Plaintext)
-		return key, nil
 	}
-	if name := os.Getenv("MACKEREL_APIKEY_PARAMETER"); name != "" {
-		resp, err := svcssm.GetParameter(ctx, &ssm.GetParameterInput{
-			Name:           aws.String(name),
-			WithDecryption: aws.Bool(decrypt),
-		})
-		if err != nil {
-			return "", err
+	if f.ConfigureMackerelClient != nil {
+		f.ConfigureMackerelClient(client)
+	}
+	return client, nil
+}
+
+// mackerelWithParameter returns the client of Mackerel with the API key in the parameter of AWS Systems Manager Parameter Store.
+// It is for the apiKeyParameter in the input of the invocation, and the clients are cached by the parameter names.
+func (f *Forwarder) mackerelWithParameter(ctx context.Context, name string) (*MackerelClient, error) {
+	svcssm := f.ssm()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if client, ok := f.svcmackerelByParameter[name]; ok {
+		return client, nil
+	}
+
+	decrypt := f.APIKeyWithDecrypt || f.env().APIKeyWithDecrypt
+	provider := &SSMKeyProvider{Client: svcssm, Name: name, WithDecryption: decrypt}
+	key, err := provider.APIKey(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("forwarder: failed to get the api key from %s: %w", name, err)
+	}
+	client, err := f.newMackerelClient(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if !f.skipAPIKeyVerification() {
+		if err := f.verifyAPIKey(ctx, client); err != nil {
+			return nil, err
 		}
-		return aws.ToString(resp.Parameter.Value), nil
 	}
-	return "", errors.New("forwarder: api key for the mackerel is not found")
+	if f.svcmackerelByParameter == nil {
+		f.svcmackerelByParameter = make(map[string]*MackerelClient)
+	}
+	f.svcmackerelByParameter[name] = client
+	return client, nil
+}
+
+func (f *Forwarder) mackerelProxy() string {
+	if f.MackerelProxy != "" {
+		return f.MackerelProxy
+	}
+	return f.env().MackerelProxy
 }
 
 func (f *Forwarder) ssm() ssmiface {
+	if f.SSM != nil {
+		return f.SSM
+	}
 	f.mu.Lock()
 	defer f.mu.Unlock()
 	if f.svcssm == nil {
-		f.svcssm = ssm.NewFromConfig(f.Config)
+		f.svcssm = ssm.NewFromConfig(f.awsConfig(), func(o *ssm.Options) {
+			f.resolveEndpoint("ssm", &o.BaseEndpoint, &o.EndpointOptions.UseFIPSEndpoint, &o.EndpointOptions.UseDualStackEndpoint)
+		})
 	}
 	return f.svcssm
 }
 
 func (f *Forwarder) kms() kmsiface {
+	if f.KMS != nil {
+		return f.KMS
+	}
 	f.mu.Lock()
 	defer f.mu.Unlock()
 	if f.svckms == nil {
-		f.svckms = kms.NewFromConfig(f.Config)
+		f.svckms = kms.NewFromConfig(f.awsConfig(), func(o *kms.Options) {
+			f.resolveEndpoint("kms", &o.BaseEndpoint, &o.EndpointOptions.UseFIPSEndpoint, &o.EndpointOptions.UseDualStackEndpoint)
+		})
 	}
 	return f.svckms
 }
 
+func (f *Forwarder) secretsManager() SecretsManagerAPI {
+	if f.SecretsManager != nil {
+		return f.SecretsManager
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.svcsecrets == nil {
+		f.svcsecrets = secretsmanager.NewFromConfig(f.awsConfig(), func(o *secretsmanager.Options) {
+			f.resolveEndpoint("secretsmanager", &o.BaseEndpoint, &o.EndpointOptions.UseFIPSEndpoint, &o.EndpointOptions.UseDualStackEndpoint)
+		})
+	}
+	return f.svcsecrets
+}
+
+func (f *Forwarder) sqs() SQSAPI {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.svcsqs == nil {
+		f.svcsqs = sqs.NewFromConfig(f.awsConfig(), func(o *sqs.Options) {
+			f.resolveEndpoint("sqs", &o.BaseEndpoint, &o.EndpointOptions.UseFIPSEndpoint, &o.EndpointOptions.UseDualStackEndpoint)
+		})
+	}
+	return f.svcsqs
+}
+
 func (f *Forwarder) cloudwatch() cloudwatchiface {
+	if f.CloudWatch != nil {
+		return f.CloudWatch
+	}
 	f.mu.Lock()
 	defer f.mu.Unlock()
 	if f.svccloudwatch == nil {
-		f.svccloudwatch = cloudwatch.NewFromConfig(f.Config)
+		f.svccloudwatch = cloudwatch.NewFromConfig(f.awsConfig(), func(o *cloudwatch.Options) {
+			f.resolveEndpoint("cloudwatch", &o.BaseEndpoint, &o.EndpointOptions.UseFIPSEndpoint, &o.EndpointOptions.UseDualStackEndpoint)
+		})
 	}
 	return f.svccloudwatch
 }
 
+// billingNamespace is the namespace of the billing metrics of CloudWatch.
+const billingNamespace = "AWS/Billing"
+
+// cloudwatchFor returns the client of CloudWatch for the metrics in the namespace.
+// The billing metrics are published only in us-east-1 regardless of the regions of the resources,
+// so they are fetched by the client pinned to the region.
+func (f *Forwarder) cloudwatchFor(namespace string) cloudwatchiface {
+	region := f.partition().billingRegion
+	if namespace != billingNamespace || f.CloudWatch != nil || region == "" || f.Config.Region == "" || f.Config.Region == region {
+		return f.cloudwatch()
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.svcbilling == nil {
+		f.svcbilling = cloudwatch.NewFromConfig(f.awsConfig(), func(o *cloudwatch.Options) {
+			o.Region = region
+			f.resolveEndpoint("cloudwatch", &o.BaseEndpoint, &o.EndpointOptions.UseFIPSEndpoint, &o.EndpointOptions.UseDualStackEndpoint)
+		})
+	}
+	return f.svcbilling
+}
+
+func (f *Forwarder) logs() logsiface {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.svclogs == nil {
+		f.svclogs = cloudwatchlogs.NewFromConfig(f.awsConfig(), func(o *cloudwatchlogs.Options) {
+			f.resolveEndpoint("logs", &o.BaseEndpoint, &o.EndpointOptions.UseFIPSEndpoint, &o.EndpointOptions.UseDualStackEndpoint)
+		})
+	}
+	return f.svclogs
+}
+
+func (f *Forwarder) pi() piiface {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.svcpi == nil {
+		c := newPIClient(f.awsConfig())
+		c.endpoint = f.endpoint("pi")
+		f.svcpi = c
+	}
+	return f.svcpi
+}
+
+func (f *Forwarder) tagging() taggingiface {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.svctagging == nil {
+		f.svctagging = resourcegroupstaggingapi.NewFromConfig(f.awsConfig(), func(o *resourcegroupstaggingapi.Options) {
+			f.resolveEndpoint("tagging", &o.BaseEndpoint, &o.EndpointOptions.UseFIPSEndpoint, &o.EndpointOptions.UseDualStackEndpoint)
+		})
+	}
+	return f.svctagging
+}
+
+func (f *Forwarder) sts() stsiface {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.svcsts == nil {
+		f.svcsts = sts.NewFromConfig(f.awsConfig(), func(o *sts.Options) {
+			f.resolveEndpoint("sts", &o.BaseEndpoint, &o.EndpointOptions.UseFIPSEndpoint, &o.EndpointOptions.UseDualStackEndpoint)
+		})
+	}
+	return f.svcsts
+}
+
 type forwardContext struct {
 	forwarder      *Forwarder
 	mackerel       *MackerelClient
+	publishers     []Publisher
 	start          time.Time
 	end            time.Time
 	serviceMetrics serviceMetricsType
 	hostMetrics    hostMetricsType
+	checkReports   []CheckReport
+	latest         map[string]latestValue
+	points         map[string]map[int64]float64 // label -> unix time -> value
+	outOfRange     map[string]int               // label -> the number of the dropped values
+	report         *InvocationReport
+
+	// pendingServiceMetrics and pendingHostMetrics are the metrics that failed to post to Mackerel in the previous invocations.
+	// They are retried only on Mackerel, because the other publishers have received them already.
+	pendingServiceMetrics serviceMetricsType
+	pendingHostMetrics    hostMetricsType
+
+	// highWaterMarks is the unix time of the latest forwarded data points of the labels.
+	// It is nil in the dry run.
+	highWaterMarks map[string]int64
+
+	// markCandidates are the latest complete data points of the labels fetched in this invocation,
+	// and posted is the data points posted to Mackerel. The high-water marks advance only over the posted ones.
+	markCandidates map[string]markCandidate
+	posted         map[postedKey]bool
+
+	// inProgress is the length of the end of the time window that is still in progress, see Forwarder.Interval.
+	// The data points in it are forwarded, but they are fetched again and not filled.
+	inProgress time.Duration
+
+	// throttle backs off the requests to CloudWatch on the throttling.
+	throttle adaptiveThrottle
 
 	mu                   sync.Mutex
 	failedServiceMetrics serviceMetricsType
 	failedHostMetrics    hostMetricsType
 }
 
-// ForwardMetrics forwards metrics of AWS CloudWatch to Mackerel
-func (f *Forwarder) ForwardMetrics(ctx context.Context, data json.RawMessage) error {
-	// set timeout to avoid to be killed by AWS Lambda
+// ForwardMetrics forwards metrics of AWS CloudWatch to Mackerel.
+// It returns the report of the invocation.
+func (f *Forwarder) ForwardMetrics(ctx context.Context, data json.RawMessage) (*InvocationReport, error) {
+	startedAt := time.Now()
+	ctx, cancel := f.invocationContext(ctx)
+	defer cancel()
+
+	report := &InvocationReport{}
+	err := f.forwardMetrics(ctx, data, f.interval(), report)
+	report.DurationMillis = time.Since(startedAt).Milliseconds()
+	if err != nil {
+		f.logger().ErrorContext(ctx, "failed to forward the metrics", "error", err.Error())
+	}
+	f.recordStats(report, err)
+	return report, err
+}
+
+// invocationContext returns the context for an invocation.
+// It sets timeout to avoid to be killed by AWS Lambda.
+func (f *Forwarder) invocationContext(ctx context.Context) (context.Context, context.CancelFunc) {
 	timeout := 50 * time.Second
 	deadline, ok := ctx.Deadline()
 	if ok {
 		timeout = time.Until(deadline)
-		timeout -= timeout / 10
+		timeout -= f.timeoutMargin(ctx, timeout)
 	}
-	ctx, cancel := context.WithTimeout(ctx, timeout)
-	defer cancel()
+	return context.WithTimeout(ctx, timeout)
+}
 
-	err := f.forwardMetrics(ctx, data)
+// forwardMetrics forwards the metrics of the queries in data.
+// If interval is not zero, the metrics are forwarded every interval until the next minute, see Forwarder.Interval.
+func (f *Forwarder) forwardMetrics(ctx context.Context, data json.RawMessage, interval time.Duration, report *InvocationReport) (err error) {
+	ctx = f.withInvocationOverrides(ctx, data)
+	now := f.now()
+	at := windowTime(data, now)
+
+	event := data
+	data, query, err := f.loadQueries(ctx, data)
 	if err != nil {
-		logrus.Error(err)
+		return err
 	}
-	return err
-}
 
-func (f *Forwarder) forwardMetrics(ctx context.Context, data json.RawMessage) error {
-	var query []*Query
-	if err := phperjson.Unmarshal([]byte(data), &query); err != nil {
-		return fmt.Errorf("forwarder: failed to parse the input: %w", err)
+	if f.dryRun(ctx) {
+		return f.forwardMetricsDryRun(ctx, query, at, report)
 	}
 
-	now := time.Now()
+	if store := f.idempotencyStore(); store != nil {
+		start, end := f.timeWindow(ctx, at)
+		key, ok := idempotencyKey(ctx, event, data, start, end)
+		if !ok {
+			f.logger().WarnContext(ctx, "the invocation has neither the event id, the time, nor the request id, skips the idempotency check")
+		} else if ok, berr := store.Begin(ctx, key, idempotencyLease(ctx)); berr != nil {
+			f.logger().WarnContext(ctx, "failed to begin the idempotency record, forwards anyway",
+				"key", key,
+				"error", berr.Error(),
+			)
+		} else if !ok {
+			f.logger().InfoContext(ctx, "the time window is already forwarded, skips",
+				"key", key,
+			)
+			report.Skipped = true
+			return nil
+		} else {
+			defer func() {
+				f.endIdempotency(ctx, store, key, err)
+			}()
+		}
+	}
 
 	client, err := f.mackerel(ctx)
 	if err != nil {
 		return fmt.Errorf("forwarder: failed to configure the mackerel client: %w", err)
 	}
 
+	query = f.resolveCustomIdentifiers(ctx, client, query)
+	if f.autoRegisterHosts() {
+		query = f.registerHosts(ctx, client, query)
+	}
+	if f.syncHostMetadata() {
+		f.syncHostMetadataFromTags(ctx, client, query, now)
+	}
+
+	if interval > 0 {
+		return f.forwardEvery(ctx, client, query, at, interval, report)
+	}
+	return f.forwardWindow(ctx, client, query, at, 0, report)
+}
+
+// forwardWindow forwards the metrics in the time window of at, and the pending metrics.
+// The time window is extended by inProgress for the minutes that are still in progress.
+func (f *Forwarder) forwardWindow(ctx context.Context, client *MackerelClient, query []*Query, at time.Time, inProgress time.Duration, report *InvocationReport) error {
+	if isIsolatedInvocation(ctx) {
+		return f.forwardWindowIsolated(ctx, client, query, at, inProgress, make(map[string]int64), report)
+	}
+
+	now := f.now()
 	f.muPending.Lock()
 	defer f.muPending.Unlock()
+	f.restoreState(ctx)
 
 	// drop old metrics
 	if cnt := f.pendingHostMetrics.Drop(now.Add(-6 * time.Hour)); cnt > 0 {
-		logrus.WithFields(logrus.Fields{
-			"count": cnt,
-		}).Warn("drop host metrics because of timeout")
+		report.addDropped("", cnt)
+		f.logger().WarnContext(ctx, "drop host metrics because of timeout",
+			"count", cnt,
+		)
 	}
 
-	// truncate to a minute.
-	// https://docs.aws.amazon.com/AmazonCloudWatch/latest/APIReference/API_GetMetricData.html#API_GetMetricData_RequestParameters
-	// > For better performance, specify StartTime and EndTime values
-	// > that align with the value of the metric's Period and sync up with the beginning and end of an hour.
-	start := now.Truncate(time.Minute)
-
-	// https://docs.aws.amazon.com/AmazonCloudWatch/latest/monitoring/publishingMetrics.html#publishingDataPoints
-	// > When you create a metric, it can take up to 2 minutes before you can retrieve statistics
-	// > for the new metric using the get-metric-statistics command.
-	start = start.Add(-2 * time.Minute)
-	end := start.Add(time.Minute)
-
+	start, end := f.timeWindow(ctx, at)
+	end = end.Add(inProgress)
+	if f.highWaterMarks == nil {
+		f.highWaterMarks = make(map[string]int64)
+	}
+	if f.lookupLatest() {
+		f.seedHighWaterMarks(ctx, client, query)
+	}
 	fctx := &forwardContext{
 		forwarder:      f,
 		mackerel:       client,
+		publishers:     f.publishers(ctx),
 		start:          start,
 		end:            end,
-		serviceMetrics: f.pendingServiceMetrics,
-		hostMetrics:    f.pendingHostMetrics,
+		report:         report,
+		highWaterMarks: f.highWaterMarks,
+		inProgress:     inProgress,
+
+		pendingServiceMetrics: f.pendingServiceMetrics,
+		pendingHostMetrics:    f.pendingHostMetrics,
 	}
 
-	err = fctx.getMetricsData(ctx, query)
+	fetchCtx, cancel := f.fetchContext(ctx)
+	err := fctx.getMetricsData(fetchCtx, query)
+	cancel()
 	// note: do not check error here.
 	// because we need to publish pending metrics.
 
-	fctx.publishMetric(ctx)
+	perr := fctx.publishMetric(ctx)
 	f.pendingServiceMetrics = fctx.failedServiceMetrics
 	f.pendingHostMetrics = fctx.failedHostMetrics
-	return err
+	f.evictPendingMetrics(ctx, report)
+	return errors.Join(err, perr)
+}
+
+// forwardWindowIsolated is forwardWindow for the isolated invocations, e.g. the invocations with apiKeyParameter in the input,
+// the groups of ForwardMetricsBatch, and Replay.
+// The pending metrics and the high-water marks belong to the scheduled invocations of the default API key,
+// so they are neither published nor updated, and highWaterMarks of the caller are used instead.
+// The metrics that failed to post are reported as the error.
+func (f *Forwarder) forwardWindowIsolated(ctx context.Context, client *MackerelClient, query []*Query, at time.Time, inProgress time.Duration, highWaterMarks map[string]int64, report *InvocationReport) error {
+	// the last values of the fill option "last" are guarded by muPending.
+	f.muPending.Lock()
+	defer f.muPending.Unlock()
+
+	start, end := f.timeWindow(ctx, at)
+	end = end.Add(inProgress)
+	fctx := &forwardContext{
+		forwarder:      f,
+		mackerel:       client,
+		publishers:     f.publishers(ctx),
+		start:          start,
+		end:            end,
+		report:         report,
+		highWaterMarks: highWaterMarks,
+		inProgress:     inProgress,
+	}
+
+	fetchCtx, cancel := f.fetchContext(ctx)
+	err := fctx.getMetricsData(fetchCtx, query)
+	cancel()
+
+	perr := fctx.publishMetric(ctx)
+	return errors.Join(err, perr)
+}
+
+// loadQueries loads the queries of the input.
+// The input is replaced with the query file if it is configured.
+// It returns the input that the queries are loaded from, and the queries expanded by the discovery.
+func (f *Forwarder) loadQueries(ctx context.Context, data json.RawMessage) (json.RawMessage, []*Query, error) {
+	if path := f.queryFile(); path != "" {
+		var err error
+		data, err = LoadQueryFile(path)
+		if err != nil {
+			return nil, nil, err
+		}
+	} else if isScheduledEvent(data) {
+		return nil, nil, errors.New("forwarder: the query file is required for the scheduled events")
+	}
+
+	expanded, err := f.expandPlaceholders(ctx, data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("forwarder: failed to expand placeholders: %w", err)
+	}
+
+	if err := ValidateQuerySchema(expanded); err != nil {
+		if f.strict() {
+			return nil, nil, err
+		}
+		// the loosely typed values and the unknown keys have been accepted, so they are only warned.
+		f.logger().WarnContext(ctx, "the query definition doesn't match the schema", "error", err.Error())
+	}
+	query, err := ParseQueries(expanded)
+	if err != nil {
+		return nil, nil, fmt.Errorf("forwarder: failed to parse the input: %w", err)
+	}
+	query = f.discoverResources(ctx, query)
+	return data, query, nil
+}
+
+// defaultDelay is the delay of the end of the time window from the current time truncated to a minute.
+const defaultDelay = time.Minute
+
+// timeWindow returns the time window for fetching the metrics.
+// The windows of the queries are shifted by their delays, see (*forwardContext).window.
+func (f *Forwarder) timeWindow(ctx context.Context, now time.Time) (start, end time.Time) {
+	// truncate to a minute.
+	// https://docs.aws.amazon.com/AmazonCloudWatch/latest/APIReference/API_GetMetricData.html#API_GetMetricData_RequestParameters
+	// > For better performance, specify StartTime and EndTime values
+	// > that align with the value of the metric's Period and sync up with the beginning and end of an hour.
+	start = now.Truncate(time.Minute)
+
+	// https://docs.aws.amazon.com/AmazonCloudWatch/latest/monitoring/publishingMetrics.html#publishingDataPoints
+	// > When you create a metric, it can take up to 2 minutes before you can retrieve statistics
+	// > for the new metric using the get-metric-statistics command.
+	end = start.Add(-defaultDelay)
+	start = end.Add(-f.lookback(ctx))
+	return start, end
+}
+
+// window returns the time window of the query.
+// It is the time window of the invocation shifted by the delay of the query.
+func (fctx *forwardContext) window(q *metricQuery) (start, end time.Time) {
+	shift := q.Delay - defaultDelay
+	return fctx.start.Add(-shift), fctx.end.Add(-shift)
+}
+
+// alignWindow widens the time window to the last complete period of the queries,
+// if the longest period of them is longer than the window.
+// Otherwise, the data points of the long periods, e.g. the billing metrics, are fetched
+// only by the invocations whose windows happen to cover them.
+// The window is aligned to the period, so that the invocations in the same period fetch the same data point,
+// and the high-water marks skip it once it is forwarded.
+func alignWindow(queries []*metricQuery, start, end time.Time) (time.Time, time.Time) {
+	var period time.Duration
+	for _, q := range queries {
+		period = max(period, q.period())
+	}
+	if period <= end.Sub(start) {
+		return start, end
+	}
+	end = end.Truncate(period)
+	return end.Add(-period), end
 }
 
 type serviceMetricsType map[string][]ServiceMetricValue
@@ -331,80 +973,349 @@ func (m *hostMetricsType) Drop(t time.Time) int {
 
 // getMetricsData gets metrics data from CloudWatch Metrics.
 func (fctx *forwardContext) getMetricsData(ctx context.Context, query []*Query) error {
-	svc := fctx.forwarder.cloudwatch()
-	metricQuery, defaults, err := ToMetricDataQuery(query)
-	if err != nil {
-		return err
+	resolved, errs := prepareQueries(query)
+	resolved, aerrs := validateMetricAge(resolved, fctx.forwarder.maxMetricAge(ctx))
+	if len(aerrs) > 0 {
+		errs = append(errs, aerrs...)
+		slices.SortStableFunc(errs, func(a, b *QueryError) int {
+			return cmp.Compare(a.Index, b.Index)
+		})
+	}
+	if len(errs) > 0 && fctx.forwarder.strict() {
+		return errs
+	}
+	for _, err := range errs {
+		fctx.forwarder.logger().WarnContext(withQueryIndex(ctx, err.Index), "invalid query, skips",
+			"error", err.Err.Error(),
+		)
+	}
+	resolved = fctx.skipRetiredHosts(ctx, resolved)
+	resolved = fctx.expandAggregates(ctx, resolved)
+	resolved = fctx.resolveServiceTemplates(ctx, resolved)
+	if fctx.forwarder.verifyMetrics() {
+		verrs := fctx.verifyMetricsExist(ctx, resolved)
+		if len(verrs) > 0 && fctx.forwarder.strict() {
+			return verrs
+		}
+		for _, err := range verrs {
+			fctx.forwarder.logger().WarnContext(withQueryIndex(ctx, err.Index), "the metric is not found, the query returns no data",
+				"error", err.Err.Error(),
+			)
+		}
+	}
+	warnDuplicateMetrics(ctx, fctx.forwarder.logger(), resolved)
+	queries := make(map[string]*metricQuery, len(resolved))
+	var dataQueries, statsQueries, logsQueries, piQueries, quotaQueries []*metricQuery
+	for _, q := range resolved {
+		fctx.forwarder.logger().DebugContext(withQueryIndex(ctx, q.Index), "new metric data query",
+			"id", q.ID,
+			"label", q.Label.String(),
+			"stat", q.Stat,
+			"default", q.Query.Default,
+		)
+		if q.Query.returnData() {
+			queries[q.Label.String()] = q
+		}
+		switch {
+		case q.Query.Type == queryTypeLogs:
+			logsQueries = append(logsQueries, q)
+		case q.Query.Type == queryTypePerformanceInsights:
+			piQueries = append(piQueries, q)
+		case q.Query.Type == queryTypeServiceQuota:
+			quotaQueries = append(quotaQueries, q)
+		case q.Query.API == apiStatistics:
+			statsQueries = append(statsQueries, q)
+		default:
+			dataQueries = append(dataQueries, q)
+		}
+	}
+
+	fctx.latest = make(map[string]latestValue, len(resolved))
+	fctx.points = make(map[string]map[int64]float64, len(resolved))
+
+	// a failure of fetching doesn't stop fetching the other queries.
+	// the queries that failed are not filled, so that the missing values are not filled with the defaults.
+	var fetchErrs []error
+	fetchFailed := func(err error, qs ...*metricQuery) {
+		ferr := &FetchError{Err: err}
+		for _, q := range qs {
+			ferr.Indexes = append(ferr.Indexes, q.Index)
+			delete(queries, q.Label.String())
+		}
+		fetchErrs = append(fetchErrs, ferr)
+	}
+	for _, group := range groupByDelay(dataQueries) {
+		if err := fctx.getMetricDataResultsInWindow(ctx, group); err != nil {
+			fetchFailed(err, group...)
+		}
+	}
+	for _, q := range statsQueries {
+		if err := fctx.getMetricStatistics(withQueryIndex(ctx, q.Index), q); err != nil {
+			fetchFailed(err, q)
+		}
+	}
+	for _, q := range logsQueries {
+		if err := fctx.getLogEventCounts(withQueryIndex(ctx, q.Index), q); err != nil {
+			fetchFailed(err, q)
+		}
+	}
+	for _, q := range piQueries {
+		if err := fctx.getPerformanceInsightsMetrics(withQueryIndex(ctx, q.Index), q); err != nil {
+			fetchFailed(err, q)
+		}
+	}
+	for _, q := range quotaQueries {
+		if err := fctx.getServiceQuotaUtilization(withQueryIndex(ctx, q.Index), q); err != nil {
+			fetchFailed(err, q)
+		}
+	}
+
+	if n := fctx.throttle.count(); n > 0 {
+		fctx.forwarder.logger().WarnContext(ctx, "the requests to cloudwatch are throttled, backed off",
+			"count", n,
+		)
+	}
+
+	for _, q := range queries {
+		fctx.fillMissingValues(q)
+	}
+	fctx.rememberLastValues(queries)
+	fctx.collectHighWaterMarks(queries)
+
+	for l, cnt := range fctx.outOfRange {
+		fctx.forwarder.logger().WarnContext(ctx, "drop the values that are NaN, infinite, or out of the range",
+			"label", l,
+			"count", cnt,
+		)
+	}
+
+	for l, q := range queries {
+		if q.Query.Check == nil {
+			continue
+		}
+		if v, ok := fctx.latest[l]; ok {
+			fctx.appendCheckReport(withQueryIndex(ctx, q.Index), q, v)
+		}
+	}
+	return errors.Join(fetchErrs...)
+}
+
+// groupByDelay groups the queries by their delays.
+// The queries that have the same delay are fetched together by GetMetricData.
+// The billing metrics and the expressions that reference them are grouped separately,
+// because they are fetched from another region, see cloudwatchFor.
+func groupByDelay(queries []*metricQuery) [][]*metricQuery {
+	type groupKey struct {
+		delay   time.Duration
+		billing bool
+	}
+	ids := metricDataQueryIDs(queries)
+	groups := make(map[groupKey][]*metricQuery)
+	var keys []groupKey
+	for _, q := range queries {
+		key := groupKey{delay: q.Delay, billing: isBillingQuery(q, ids)}
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], q)
+	}
+	ret := make([][]*metricQuery, 0, len(keys))
+	for _, key := range keys {
+		ret = append(ret, groups[key])
+	}
+	return ret
+}
+
+// getMetricDataResultsInWindow gets metrics data of the queries in the same time window.
+func (fctx *forwardContext) getMetricDataResultsInWindow(ctx context.Context, queries []*metricQuery) error {
+	namespace := queries[0].Namespace
+	for _, q := range queries {
+		// the expressions have no namespaces.
+		if q.Namespace != "" {
+			namespace = q.Namespace
+			break
+		}
+	}
+	svc := fctx.forwarder.cloudwatchFor(namespace)
+	start, end := fctx.window(queries[0])
+	start, end = alignWindow(queries, start, end)
+	start = fctx.fetchStart(queries, start)
+	if !start.Before(end) {
+		// all the minutes in the window are already forwarded.
+		return nil
+	}
+	dataQuery := make([]types.MetricDataQuery, 0, len(queries))
+	ids := make(map[string]*metricQuery, len(queries))
+	for _, q := range queries {
+		dataQuery = append(dataQuery, q.toMetricDataQuery())
+		ids[q.ID] = q
 	}
 	paginator := cloudwatch.NewGetMetricDataPaginator(svc, &cloudwatch.GetMetricDataInput{
-		StartTime:         aws.Time(fctx.start),
-		EndTime:           aws.Time(fctx.end),
-		MetricDataQueries: metricQuery,
+		StartTime:         aws.Time(start),
+		EndTime:           aws.Time(end),
+		MetricDataQueries: dataQuery,
 	})
-	seen := make(map[string]struct{}, len(query))
-	for paginator.HasMorePages() {
-		page, err := paginator.NextPage(ctx)
-		if err != nil {
-			return err
-		}
+
+	// the results that can't be matched to the queries are skipped,
+	// so that they don't discard the other results.
+	var errs []error
+	handle := func(page *cloudwatch.GetMetricDataOutput) {
 		for _, result := range page.MetricDataResults {
-			rawLabel := aws.ToString(result.Label)
-			if len(result.Values) > 0 {
-				seen[rawLabel] = struct{}{}
-			}
-			label, err := ParseLabel(rawLabel)
-			if err != nil {
-				return err
+			q, ok := ids[aws.ToString(result.Id)]
+			if !ok {
+				errs = append(errs, fmt.Errorf("forwarder: unknown id in the result: %s", aws.ToString(result.Id)))
+				continue
 			}
 			for i := range result.Timestamps {
-				t := result.Timestamps[i]
-				v := result.Values[i]
-				if label.Service != "" {
-					fctx.serviceMetrics.Append(label.Service, ServiceMetricValue{
-						Name:  label.MetricName,
-						Time:  t.Unix(),
-						Value: v,
-					})
-				} else if label.HostID != "" {
-					fctx.hostMetrics.Append(HostMetricValue{
-						HostID: label.HostID,
-						Name:   label.MetricName,
-						Time:   t.Unix(),
-						Value:  v,
-					})
-				}
+				fctx.appendValue(q.Query, q.Label, result.Timestamps[i], result.Values[i])
 			}
 		}
 	}
 
-	for l, v := range defaults {
-		if _, ok := seen[l]; ok {
-			continue
-		}
-		label, err := ParseLabel(l)
-		if err != nil {
-			return err
-		}
-		if label.Service != "" {
-			fctx.serviceMetrics.Append(label.Service, ServiceMetricValue{
-				Name:  label.MetricName,
-				Time:  fctx.start.Unix(),
-				Value: v,
+	if fctx.throttle.throttled() {
+		// fetch the pages one by one, not to make concurrent requests while throttled.
+		for paginator.HasMorePages() {
+			page, err := withThrottle(ctx, &fctx.throttle, func() (*cloudwatch.GetMetricDataOutput, error) {
+				return paginator.NextPage(ctx)
 			})
-		} else if label.HostID != "" {
-			fctx.hostMetrics.Append(HostMetricValue{
-				HostID: label.HostID,
-				Name:   label.MetricName,
-				Time:   fctx.start.Unix(),
-				Value:  v,
+			if err != nil {
+				errs = append(errs, err)
+				break
+			}
+			handle(page)
+		}
+		return errors.Join(errs...)
+	}
+
+	// fetch the next page while processing the current one.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	pages, errc := fetchMetricDataPages(ctx, paginator, &fctx.throttle)
+	for page := range pages {
+		handle(page)
+	}
+	errs = append(errs, <-errc)
+	return errors.Join(errs...)
+}
+
+// fetchMetricDataPages fetches the pages of GetMetricData in a goroutine.
+// The pages are sent to the returned channel, and it is closed after all the pages are fetched.
+// Then the error of fetching is sent to the error channel, or nil if it succeeds.
+func fetchMetricDataPages(ctx context.Context, paginator *cloudwatch.GetMetricDataPaginator, throttle *adaptiveThrottle) (<-chan *cloudwatch.GetMetricDataOutput, <-chan error) {
+	pages := make(chan *cloudwatch.GetMetricDataOutput, 1)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(errc)
+		defer close(pages)
+		for paginator.HasMorePages() {
+			page, err := withThrottle(ctx, throttle, func() (*cloudwatch.GetMetricDataOutput, error) {
+				return paginator.NextPage(ctx)
 			})
+			if err != nil {
+				errc <- err
+				return
+			}
+			select {
+			case pages <- page:
+			case <-ctx.Done():
+				errc <- ctx.Err()
+				return
+			}
+		}
+	}()
+	return pages, errc
+}
+
+// appendValue appends the value to the metrics, and records the latest value of the label.
+// The value is filtered by the value range of the query.
+func (fctx *forwardContext) appendValue(q *Query, label Label, t time.Time, v float64) {
+	l := label.String()
+	t = q.alignTime(t)
+	v, ok := q.filterValue(v)
+	if !ok {
+		fctx.report.addFetched(label.Service, 1)
+		fctx.report.addDropped(label.Service, 1)
+		if fctx.outOfRange == nil {
+			fctx.outOfRange = make(map[string]int)
 		}
+		fctx.outOfRange[l]++
+		return
+	}
+	if latest, ok := fctx.latest[l]; !ok || t.After(latest.Time) {
+		fctx.latest[l] = latestValue{Time: t, Value: v}
+	}
+	if fctx.points[l] == nil {
+		fctx.points[l] = make(map[int64]float64)
+	}
+	fctx.points[l][t.Unix()] = v
+
+	fctx.report.addFetched(label.Service, 1)
+	if label.Service != "" {
+		fctx.serviceMetrics.Append(label.Service, ServiceMetricValue{
+			Name:  label.MetricName,
+			Time:  t.Unix(),
+			Value: v,
+		})
+	} else if label.HostID != "" {
+		fctx.hostMetrics.Append(HostMetricValue{
+			HostID: label.HostID,
+			Name:   label.MetricName,
+			Time:   t.Unix(),
+			Value:  v,
+		})
 	}
-	return nil
 }
 
-func (fctx *forwardContext) publishMetric(ctx context.Context) {
+type latestValue struct {
+	Time  time.Time
+	Value float64
+}
+
+// appendCheckReport evaluates the check rule of the query, and appends the report.
+// The rule and the host of the query are validated by resolveQueries.
+func (fctx *forwardContext) appendCheckReport(ctx context.Context, q *metricQuery, v latestValue) {
+	status, message := q.Query.Check.Evaluate(v.Value)
+	fctx.checkReports = append(fctx.checkReports, CheckReport{
+		Source: CheckSource{
+			Type:   "host",
+			HostID: q.Label.HostID,
+		},
+		Name:       q.Query.Check.name(q.Label),
+		Status:     status,
+		Message:    message,
+		OccurredAt: v.Time.Unix(),
+	})
+}
+
+// publishMetric posts the metrics and the check monitoring reports.
+// The metrics that failed to post are kept in failedServiceMetrics and failedHostMetrics for retrying,
+// and all the errors of posting are returned by errors.Join.
+func (fctx *forwardContext) publishMetric(ctx context.Context) error {
+	fctx.normalizeMetrics(ctx)
+
+	// the other publishers receive only the metrics fetched in this invocation.
+	othersServiceMetrics, othersHostMetrics := fctx.serviceMetrics, slices.Clone(fctx.hostMetrics)
+	fctx.mergePendingMetrics()
+
+	now := fctx.forwarder.now()
+	fctx.dropStaleMetrics(ctx, now)
+	fctx.dropRetiredHostMetrics(ctx, now)
+	if fctx.forwarder.validateHosts() {
+		fctx.dropUnknownHostMetrics(ctx, now)
+	}
+	if fctx.forwarder.autoCreateServices() {
+		fctx.ensureServices(ctx)
+	}
+
 	var wg sync.WaitGroup
+	var errs []error
+
+	// limit the number of the concurrent requests.
+	sem := make(chan struct{}, fctx.forwarder.publishConcurrency(ctx))
+	acquire := func() func() {
+		sem <- struct{}{}
+		return func() { <-sem }
+	}
 
 	// publush service metrics
 	for service, metrics := range fctx.serviceMetrics {
@@ -412,25 +1323,45 @@ func (fctx *forwardContext) publishMetric(ctx context.Context) {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			err := fctx.mackerel.PostServiceMetricValues(ctx, service, metrics)
-			if err != nil {
-				logrus.WithFields(logrus.Fields{
-					"error":   err.Error(),
-					"service": service,
-				}).Warn("failed to post service metrics, will retry in next minutes")
+			defer acquire()()
+			rejected, failed, err := postBisect(ctx, metrics, isBadRequest, func(ctx context.Context, values []ServiceMetricValue) error {
+				return fctx.mackerel.PostServiceMetricValues(ctx, service, values)
+			})
+			for _, v := range rejected {
+				fctx.forwarder.logger().WarnContext(ctx, "drop the service metric rejected by mackerel",
+					"service", service,
+					"name", v.Name,
+					"time", v.Time,
+					"value", v.Value,
+				)
+			}
+			posted := len(metrics) - len(rejected) - len(failed)
+
+			fctx.mu.Lock()
+			defer fctx.mu.Unlock()
+			fctx.addPosted(servicePostedKeys(service, metrics), servicePostedKeys(service, rejected), servicePostedKeys(service, failed))
+			fctx.report.addDropped(service, len(rejected))
+			fctx.report.addPosted(service, posted)
+			if len(failed) > 0 {
+				fctx.forwarder.logger().WarnContext(ctx, "failed to post service metrics, will retry in next minutes",
+					"error", err.Error(),
+					"service", service,
+				)
+
+				errs = append(errs, &PublishError{Service: service, Target: publishServiceMetrics, Err: err})
 
 				// save metrics to retry
-				fctx.mu.Lock()
-				defer fctx.mu.Unlock()
+				fctx.report.addFailed(service, len(failed))
 				if fctx.failedServiceMetrics == nil {
 					fctx.failedServiceMetrics = make(serviceMetricsType)
 				}
-				fctx.failedServiceMetrics[service] = append(fctx.failedServiceMetrics[service], metrics...)
-			} else {
-				logrus.WithFields(logrus.Fields{
-					"service": service,
-					"count":   len(metrics),
-				}).Info("succeed to post service metrics")
+				fctx.failedServiceMetrics[service] = append(fctx.failedServiceMetrics[service], failed...)
+			}
+			if posted > 0 {
+				fctx.forwarder.logger().InfoContext(ctx, "succeed to post service metrics",
+					"service", service,
+					"count", posted,
+				)
 			}
 		}()
 	}
@@ -440,23 +1371,137 @@ func (fctx *forwardContext) publishMetric(ctx context.Context) {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			err := fctx.mackerel.PostHostMetricValues(ctx, []HostMetricValue(fctx.hostMetrics))
-			if err != nil {
-				logrus.WithFields(logrus.Fields{
-					"error": err.Error(),
-				}).Warn("failed to post host metrics, will retry in next minutes")
+			defer acquire()()
+			rejected, failed, err := postBisect(ctx, []HostMetricValue(fctx.hostMetrics), isRejectedHostMetric, fctx.postHostMetricValues)
+			for _, v := range rejected {
+				fctx.forwarder.logger().WarnContext(ctx, "drop the host metric rejected by mackerel",
+					"host", v.HostID,
+					"name", v.Name,
+					"time", v.Time,
+					"value", v.Value,
+				)
+			}
+			posted := len(fctx.hostMetrics) - len(rejected) - len(failed)
+
+			fctx.mu.Lock()
+			defer fctx.mu.Unlock()
+			fctx.addPosted(hostPostedKeys(fctx.hostMetrics), hostPostedKeys(rejected), hostPostedKeys(failed))
+			fctx.report.addDropped("", len(rejected))
+			fctx.report.addPosted("", posted)
+			if len(failed) > 0 {
+				fctx.forwarder.logger().WarnContext(ctx, "failed to post host metrics, will retry in next minutes",
+					"error", err.Error(),
+				)
+
+				errs = append(errs, &PublishError{Target: publishHostMetrics, Err: err})
 
 				// save metrics to retry
+				fctx.report.addFailed("", len(failed))
+				fctx.failedHostMetrics = failed
+			}
+			if posted > 0 {
+				fctx.forwarder.logger().InfoContext(ctx, "succeed to post host metrics",
+					"count", posted,
+				)
+			}
+		}()
+	}
+
+	// publish metrics to the other publishers
+	for _, p := range fctx.publishers {
+		p := p
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer acquire()()
+			if err := fctx.publishOthers(ctx, p, othersServiceMetrics, othersHostMetrics); err != nil {
 				fctx.mu.Lock()
 				defer fctx.mu.Unlock()
-				fctx.failedHostMetrics = fctx.hostMetrics
+				errs = append(errs, err)
+			}
+		}()
+	}
+
+	// publish check monitoring reports
+	if len(fctx.checkReports) > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer acquire()()
+			err := fctx.mackerel.PostCheckReports(ctx, fctx.checkReports)
+			if err != nil {
+				fctx.forwarder.logger().WarnContext(ctx, "failed to post check monitoring reports",
+					"error", err.Error(),
+				)
+				fctx.mu.Lock()
+				defer fctx.mu.Unlock()
+				errs = append(errs, &PublishError{Target: publishCheckReports, Err: err})
 			} else {
-				logrus.WithFields(logrus.Fields{
-					"count": len(fctx.hostMetrics),
-				}).Info("succeed to post host metrics")
+				fctx.forwarder.logger().InfoContext(ctx, "succeed to post check monitoring reports",
+					"count", len(fctx.checkReports),
+				)
 			}
 		}()
 	}
 
 	wg.Wait()
+	fctx.advanceHighWaterMarks(now)
+	return errors.Join(errs...)
+}
+
+// normalizeMetrics normalizes the metric names, and records the dropped metrics.
+func (fctx *forwardContext) normalizeMetrics(ctx context.Context) {
+	serviceMetrics := fctx.forwarder.normalizeServiceMetrics(ctx, fctx.serviceMetrics)
+	for service, metrics := range fctx.serviceMetrics {
+		fctx.report.addDropped(service, len(metrics)-len(serviceMetrics[service]))
+	}
+	hostMetrics := fctx.forwarder.normalizeHostMetrics(ctx, fctx.hostMetrics)
+	fctx.report.addDropped("", len(fctx.hostMetrics)-len(hostMetrics))
+	fctx.serviceMetrics = serviceMetrics
+	fctx.hostMetrics = hostMetrics
+}
+
+// mergePendingMetrics merges the pending metrics into the metrics to post to Mackerel.
+// The pending metrics are older than the fetched ones, so they are put first.
+func (fctx *forwardContext) mergePendingMetrics() {
+	if len(fctx.pendingServiceMetrics) > 0 {
+		merged := make(serviceMetricsType, len(fctx.serviceMetrics)+len(fctx.pendingServiceMetrics))
+		for service, metrics := range fctx.pendingServiceMetrics {
+			merged[service] = slices.Clone(metrics)
+		}
+		for service, metrics := range fctx.serviceMetrics {
+			merged[service] = append(merged[service], metrics...)
+		}
+		fctx.serviceMetrics = merged
+	}
+	if len(fctx.pendingHostMetrics) > 0 {
+		fctx.hostMetrics = append(slices.Clone(fctx.pendingHostMetrics), fctx.hostMetrics...)
+	}
+	fctx.pendingServiceMetrics = nil
+	fctx.pendingHostMetrics = nil
+}
+
+// publishOthers publishes the metrics to the publisher other than Mackerel.
+func (fctx *forwardContext) publishOthers(ctx context.Context, p Publisher, serviceMetrics serviceMetricsType, hostMetrics hostMetricsType) error {
+	var errs []error
+	for service, metrics := range serviceMetrics {
+		if err := p.PostServiceMetricValues(ctx, service, metrics); err != nil {
+			fctx.forwarder.logger().WarnContext(ctx, "failed to publish service metrics",
+				"error", err.Error(),
+				"service", service,
+				"publisher", fmt.Sprintf("%T", p),
+			)
+			errs = append(errs, &PublishError{Service: service, Target: publishServiceMetrics, Publisher: fmt.Sprintf("%T", p), Err: err})
+		}
+	}
+	if len(hostMetrics) > 0 {
+		if err := p.PostHostMetricValues(ctx, []HostMetricValue(hostMetrics)); err != nil {
+			fctx.forwarder.logger().WarnContext(ctx, "failed to publish host metrics",
+				"error", err.Error(),
+				"publisher", fmt.Sprintf("%T", p),
+			)
+			errs = append(errs, &PublishError{Target: publishHostMetrics, Publisher: fmt.Sprintf("%T", p), Err: err})
+		}
+	}
+	return errors.Join(errs...)
 }
@@ -1 +1,696 @@
 package forwarder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+	"github.com/google/go-cmp/cmp"
+)
+
+// fakeCloudWatch returns the values for the metric data queries.
+type fakeCloudWatch struct {
+	// values is the data points of the queries, keyed by the id of the queries.
+	values map[string][]float64
+
+	// statistics is the data points of GetMetricStatistics, keyed by the metric name.
+	statistics map[string][]types.Datapoint
+}
+
+func (s *fakeCloudWatch) GetMetricStatistics(ctx context.Context, params *cloudwatch.GetMetricStatisticsInput, optFns ...func(*cloudwatch.Options)) (*cloudwatch.GetMetricStatisticsOutput, error) {
+	return &cloudwatch.GetMetricStatisticsOutput{
+		Label:      params.MetricName,
+		Datapoints: s.statistics[aws.ToString(params.MetricName)],
+	}, nil
+}
+
+func (s *fakeCloudWatch) GetMetricData(ctx context.Context, params *cloudwatch.GetMetricDataInput, optFns ...func(*cloudwatch.Options)) (*cloudwatch.GetMetricDataOutput, error) {
+	var results []types.MetricDataResult
+	for _, q := range params.MetricDataQueries {
+		values := s.values[aws.ToString(q.Id)]
+		step := time.Minute
+		if q.MetricStat != nil && aws.ToInt32(q.MetricStat.Period) > 0 {
+			step = time.Duration(aws.ToInt32(q.MetricStat.Period)) * time.Second
+		}
+		timestamps := make([]time.Time, 0, len(values))
+		for i := range values {
+			timestamps = append(timestamps, params.StartTime.Add(time.Duration(i)*step))
+		}
+		results = append(results, types.MetricDataResult{
+			Id:         q.Id,
+			Label:      q.Label,
+			Timestamps: timestamps,
+			Values:     values,
+			StatusCode: types.StatusCodeComplete,
+		})
+	}
+	return &cloudwatch.GetMetricDataOutput{
+		MetricDataResults: results,
+	}, nil
+}
+
+func TestGetMetricsData_Check(t *testing.T) {
+	warning := 80.0
+	start := time.Unix(1234567860, 0)
+	fctx := &forwardContext{
+		forwarder: &Forwarder{
+			svccloudwatch: &fakeCloudWatch{
+				values: map[string][]float64{
+					"m1": {85},
+					"m2": {10},
+				},
+			},
+		},
+		start: start,
+		end:   start.Add(time.Minute),
+	}
+	query := []*Query{
+		{
+			Host:   "host-abc",
+			Name:   "rds.cpu",
+			Metric: []interface{}{"AWS/RDS", "CPUUtilization", "DBInstanceIdentifier", "db"},
+			Stat:   "Average",
+			Check:  &CheckRule{Warning: &warning},
+		},
+		{
+			Service: "foo-bar",
+			Name:    "rds.cpu",
+			Metric:  []interface{}{"AWS/RDS", "CPUUtilization", "DBInstanceIdentifier", "db"},
+			Stat:    "Average",
+			Check:   &CheckRule{Warning: &warning},
+		},
+	}
+	if err := fctx.getMetricsData(context.Background(), query); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(fctx.checkReports) != 1 {
+		t.Fatalf("unexpected check reports: %v", fctx.checkReports)
+	}
+	report := fctx.checkReports[0]
+	if report.Status != CheckStatusWarning {
+		t.Errorf("unexpected status: want %s, got %s", CheckStatusWarning, report.Status)
+	}
+	if report.Source.HostID != "host-abc" {
+		t.Errorf("unexpected host id: want %s, got %s", "host-abc", report.Source.HostID)
+	}
+	if report.OccurredAt != start.Unix() {
+		t.Errorf("unexpected occurred time: want %d, got %d", start.Unix(), report.OccurredAt)
+	}
+}
+
+func TestGetMetricsData_Statistics(t *testing.T) {
+	start := time.Unix(1234567860, 0)
+	fctx := &forwardContext{
+		forwarder: &Forwarder{
+			svccloudwatch: &fakeCloudWatch{
+				values: map[string][]float64{
+					"m1": {1},
+				},
+				statistics: map[string][]types.Datapoint{
+					"NumberOfObjects": {
+						{
+							Timestamp: aws.Time(start),
+							Average:   aws.Float64(42),
+						},
+					},
+					"Latency": {
+						{
+							Timestamp:          aws.Time(start),
+							ExtendedStatistics: map[string]float64{"p99": 0.5},
+						},
+					},
+				},
+			},
+		},
+		start: start,
+		end:   start.Add(time.Minute),
+	}
+	query := []*Query{
+		{
+			Service: "foo-bar",
+			Name:    "sqs.sent",
+			Metric:  []interface{}{"AWS/SQS", "NumberOfMessagesSent"},
+			Stat:    "Sum",
+		},
+		{
+			Service: "foo-bar",
+			Name:    "s3.objects",
+			Metric:  []interface{}{"AWS/S3", "NumberOfObjects"},
+			Stat:    "Average",
+			API:     "statistics",
+		},
+		{
+			Service: "foo-bar",
+			Name:    "api.latency",
+			Metric:  []interface{}{"AWS/ApiGateway", "Latency"},
+			Stat:    "p99",
+			API:     "statistics",
+		},
+	}
+	if err := fctx.getMetricsData(context.Background(), query); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []ServiceMetricValue{
+		{Name: "sqs.sent", Time: start.Unix(), Value: 1},
+		{Name: "s3.objects", Time: start.Unix(), Value: 42},
+		{Name: "api.latency", Time: start.Unix(), Value: 0.5},
+	}
+	if diff := cmp.Diff(want, fctx.serviceMetrics["foo-bar"]); diff != "" {
+		t.Errorf("metrics mismatch: (-want/+got):\n%s", diff)
+	}
+}
+
+func TestGetMetricsData_Strict(t *testing.T) {
+	query := []*Query{
+		{Service: "foo", Name: "short", Metric: []interface{}{"AWS/EC2"}, Stat: "Average"},
+	}
+
+	fctx := &forwardContext{
+		forwarder: &Forwarder{
+			svccloudwatch: &fakeCloudWatch{},
+		},
+	}
+	if err := fctx.getMetricsData(context.Background(), query); err != nil {
+		t.Errorf("want no error, got %v", err)
+	}
+
+	fctx = &forwardContext{
+		forwarder: &Forwarder{
+			Strict:        true,
+			svccloudwatch: &fakeCloudWatch{},
+		},
+	}
+	var errs QueryErrors
+	if err := fctx.getMetricsData(context.Background(), query); !errors.As(err, &errs) {
+		t.Errorf("want QueryErrors, got %v", err)
+	}
+}
+
+func TestPublishMetric_Concurrency(t *testing.T) {
+	var mu sync.Mutex
+	var inflight, maxInflight int
+	client := newTestMackerelClient(t, http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inflight++
+		if inflight > maxInflight {
+			maxInflight = inflight
+		}
+		mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mu.Lock()
+		inflight--
+		mu.Unlock()
+		rw.WriteHeader(http.StatusOK)
+	}))
+
+	var metrics serviceMetricsType
+	for i := 0; i < 10; i++ {
+		metrics.Append(fmt.Sprintf("service%d", i), ServiceMetricValue{Name: "custom.foo", Time: 1234567860, Value: 1})
+	}
+	fctx := &forwardContext{
+		forwarder: &Forwarder{
+			PublishConcurrency: 2,
+		},
+		mackerel:       client,
+		serviceMetrics: metrics,
+	}
+	fctx.publishMetric(context.Background())
+
+	if maxInflight > 2 {
+		t.Errorf("too many concurrent requests: %d", maxInflight)
+	}
+	if len(fctx.failedServiceMetrics) != 0 {
+		t.Errorf("unexpected failed metrics: %v", fctx.failedServiceMetrics)
+	}
+}
+
+// recordingPublisher records the metrics that are published.
+type recordingPublisher struct {
+	mu             sync.Mutex
+	serviceMetrics serviceMetricsType
+	hostMetrics    hostMetricsType
+}
+
+func (p *recordingPublisher) PostServiceMetricValues(ctx context.Context, serviceName string, values []ServiceMetricValue) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, v := range values {
+		p.serviceMetrics.Append(serviceName, v)
+	}
+	return nil
+}
+
+func (p *recordingPublisher) PostHostMetricValues(ctx context.Context, values []HostMetricValue) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.hostMetrics = append(p.hostMetrics, values...)
+	return nil
+}
+
+func TestPublishMetric_Publishers(t *testing.T) {
+	var mu sync.Mutex
+	var posted []HostMetricValue
+	client := newTestMackerelClient(t, http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v0/tsdb" {
+			rw.WriteHeader(http.StatusOK)
+			return
+		}
+		var values []HostMetricValue
+		if err := json.NewDecoder(r.Body).Decode(&values); err != nil {
+			t.Error(err)
+		}
+		mu.Lock()
+		posted = append(posted, values...)
+		mu.Unlock()
+		rw.WriteHeader(http.StatusOK)
+	}))
+
+	now := time.Now().Truncate(time.Minute).Unix()
+	publisher := &recordingPublisher{}
+	fctx := &forwardContext{
+		forwarder:  &Forwarder{},
+		mackerel:   client,
+		publishers: []Publisher{publisher},
+		hostMetrics: hostMetricsType{
+			{HostID: "host-abc", Name: "custom.foo", Time: now, Value: 2},
+		},
+		serviceMetrics: serviceMetricsType{
+			"foo": {{Name: "custom.foo", Time: now, Value: 2}},
+		},
+		pendingHostMetrics: hostMetricsType{
+			{HostID: "host-abc", Name: "custom.foo", Time: now - 60, Value: 1},
+		},
+		pendingServiceMetrics: serviceMetricsType{
+			"foo": {{Name: "custom.foo", Time: now - 60, Value: 1}},
+			"bar": {{Name: "custom.foo", Time: now - 60, Value: 1}},
+		},
+		report: &InvocationReport{},
+	}
+	if err := fctx.publishMetric(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// the pending metrics are retried only on Mackerel.
+	wantPosted := []HostMetricValue{
+		{HostID: "host-abc", Name: "custom.foo", Time: now - 60, Value: 1},
+		{HostID: "host-abc", Name: "custom.foo", Time: now, Value: 2},
+	}
+	if diff := cmp.Diff(wantPosted, posted); diff != "" {
+		t.Errorf("posted host metrics mismatch: (-want/+got):\n%s", diff)
+	}
+	if want, got := 5, fctx.report.Posted; want != got {
+		t.Errorf("unexpected posted count: want %d, got %d", want, got)
+	}
+
+	// the other publishers receive only the fetched metrics.
+	wantService := serviceMetricsType{
+		"foo": {{Name: "custom.foo", Time: now, Value: 2}},
+	}
+	if diff := cmp.Diff(wantService, publisher.serviceMetrics); diff != "" {
+		t.Errorf("published service metrics mismatch: (-want/+got):\n%s", diff)
+	}
+	wantHost := hostMetricsType{
+		{HostID: "host-abc", Name: "custom.foo", Time: now, Value: 2},
+	}
+	if diff := cmp.Diff(wantHost, publisher.hostMetrics); diff != "" {
+		t.Errorf("published host metrics mismatch: (-want/+got):\n%s", diff)
+	}
+}
+
+func TestGetMetricsData_Delay(t *testing.T) {
+	var starts []time.Time
+	start := time.Unix(1234567860, 0)
+	fctx := &forwardContext{
+		forwarder: &Forwarder{
+			svccloudwatch: &recordingCloudWatch{
+				fakeCloudWatch: fakeCloudWatch{
+					values: map[string][]float64{
+						"m1": {1},
+						"m2": {2},
+					},
+				},
+				onGetMetricData: func(s time.Time) { starts = append(starts, s) },
+			},
+		},
+		start: start,
+		end:   start.Add(time.Minute),
+	}
+	query := []*Query{
+		{
+			Service: "foo",
+			Name:    "ec2.cpu",
+			Metric:  []interface{}{"AWS/EC2", "CPUUtilization", "InstanceId", "i-1"},
+			Stat:    "Average",
+		},
+		{
+			Service: "foo",
+			Name:    "billing",
+			Metric:  []interface{}{"AWS/Billing", "EstimatedCharges", "Currency", "USD"},
+			Stat:    "Maximum",
+			Delay:   "15m",
+		},
+	}
+	if err := fctx.getMetricsData(context.Background(), query); err != nil {
+		t.Fatal(err)
+	}
+
+	wantStarts := []time.Time{start, start.Add(-14 * time.Minute)}
+	if diff := cmp.Diff(wantStarts, starts); diff != "" {
+		t.Errorf("start times mismatch: (-want/+got):\n%s", diff)
+	}
+	want := serviceMetricsType{
+		"foo": {
+			{Name: "ec2.cpu", Time: start.Unix(), Value: 1},
+			{Name: "billing", Time: start.Add(-14 * time.Minute).Unix(), Value: 2},
+		},
+	}
+	if diff := cmp.Diff(want, fctx.serviceMetrics); diff != "" {
+		t.Errorf("metrics mismatch: (-want/+got):\n%s", diff)
+	}
+}
+
+func TestAlignWindow(t *testing.T) {
+	end := time.Date(2024, 1, 2, 3, 4, 0, 0, time.UTC)
+	start := end.Add(-time.Minute)
+	minutely := &metricQuery{}
+	hourly := &metricQuery{Period: time.Hour}
+
+	// the window is kept if it covers the periods.
+	gotStart, gotEnd := alignWindow([]*metricQuery{minutely}, start, end)
+	if !gotStart.Equal(start) || !gotEnd.Equal(end) {
+		t.Errorf("unexpected window: %s - %s", gotStart, gotEnd)
+	}
+
+	// the window is widened to the last complete period.
+	gotStart, gotEnd = alignWindow([]*metricQuery{minutely, hourly}, start, end)
+	wantStart, wantEnd := time.Date(2024, 1, 2, 2, 0, 0, 0, time.UTC), time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC)
+	if !gotStart.Equal(wantStart) || !gotEnd.Equal(wantEnd) {
+		t.Errorf("unexpected window: want %s - %s, got %s - %s", wantStart, wantEnd, gotStart, gotEnd)
+	}
+}
+
+func TestGetMetricsData_Billing(t *testing.T) {
+	var regional, billing int
+	start := time.Unix(1234567860, 0)
+	fctx := &forwardContext{
+		forwarder: &Forwarder{
+			Config: aws.Config{Region: "ap-northeast-1"},
+			svccloudwatch: &recordingCloudWatch{
+				fakeCloudWatch:  fakeCloudWatch{values: map[string][]float64{"m1": {1}}},
+				onGetMetricData: func(time.Time) { regional++ },
+			},
+			svcbilling: &recordingCloudWatch{
+				fakeCloudWatch:  fakeCloudWatch{values: map[string][]float64{"m2": {2}}},
+				onGetMetricData: func(time.Time) { billing++ },
+			},
+		},
+		start: start,
+		end:   start.Add(time.Minute),
+	}
+	query := []*Query{
+		{
+			Service: "foo",
+			Name:    "ec2.cpu",
+			Metric:  []interface{}{"AWS/EC2", "CPUUtilization", "InstanceId", "i-1"},
+			Stat:    "Average",
+		},
+		{
+			Service: "foo",
+			Name:    "billing",
+			Metric:  []interface{}{"AWS/Billing", "EstimatedCharges", "Currency", "USD"},
+			Stat:    "Maximum",
+		},
+	}
+	if err := fctx.getMetricsData(context.Background(), query); err != nil {
+		t.Fatal(err)
+	}
+
+	// the billing metrics are fetched from us-east-1, even if they have the same delay as the others.
+	if regional != 1 || billing != 1 {
+		t.Errorf("want 1 request for each region, got %d and %d", regional, billing)
+	}
+	want := serviceMetricsType{
+		"foo": {
+			{Name: "ec2.cpu", Time: start.Unix(), Value: 1},
+			{Name: "billing", Time: start.Unix(), Value: 2},
+		},
+	}
+	if diff := cmp.Diff(want, fctx.serviceMetrics); diff != "" {
+		t.Errorf("metrics mismatch: (-want/+got):\n%s", diff)
+	}
+}
+
+// pagedCloudWatch returns the values for the metric data queries in the pages.
+// The page i contains the value of the i-th minute of the window.
+type pagedCloudWatch struct {
+	fakeCloudWatch
+	pages int
+	err   error // the error of the last page
+}
+
+func (s *pagedCloudWatch) GetMetricData(ctx context.Context, params *cloudwatch.GetMetricDataInput, optFns ...func(*cloudwatch.Options)) (*cloudwatch.GetMetricDataOutput, error) {
+	var page int
+	if token := aws.ToString(params.NextToken); token != "" {
+		fmt.Sscanf(token, "%d", &page)
+	}
+	if page == s.pages-1 && s.err != nil {
+		return nil, s.err
+	}
+	var results []types.MetricDataResult
+	for _, q := range params.MetricDataQueries {
+		results = append(results, types.MetricDataResult{
+			Id:         q.Id,
+			Label:      q.Label,
+			Timestamps: []time.Time{params.StartTime.Add(time.Duration(page) * time.Minute)},
+			Values:     []float64{float64(page)},
+			StatusCode: types.StatusCodePartialData,
+		})
+	}
+	out := &cloudwatch.GetMetricDataOutput{
+		MetricDataResults: results,
+	}
+	if page+1 < s.pages {
+		out.NextToken = aws.String(fmt.Sprint(page + 1))
+	}
+	return out, nil
+}
+
+func TestGetMetricsData_Pagination(t *testing.T) {
+	start := time.Unix(1234567860, 0)
+	query := []*Query{
+		{Service: "foo", Name: "ec2.cpu", Metric: []interface{}{"AWS/EC2", "CPUUtilization"}, Stat: "Average"},
+	}
+
+	fctx := &forwardContext{
+		forwarder: &Forwarder{
+			svccloudwatch: &pagedCloudWatch{pages: 3},
+		},
+		start: start,
+		end:   start.Add(3 * time.Minute),
+	}
+	if err := fctx.getMetricsData(context.Background(), query); err != nil {
+		t.Fatal(err)
+	}
+	want := serviceMetricsType{
+		"foo": {
+			{Name: "ec2.cpu", Time: start.Unix(), Value: 0},
+			{Name: "ec2.cpu", Time: start.Add(time.Minute).Unix(), Value: 1},
+			{Name: "ec2.cpu", Time: start.Add(2 * time.Minute).Unix(), Value: 2},
+		},
+	}
+	if diff := cmp.Diff(want, fctx.serviceMetrics); diff != "" {
+		t.Errorf("metrics mismatch: (-want/+got):\n%s", diff)
+	}
+
+	// the error of fetching a page is returned.
+	fetchErr := errors.New("throttled")
+	fctx = &forwardContext{
+		forwarder: &Forwarder{
+			svccloudwatch: &pagedCloudWatch{pages: 3, err: fetchErr},
+		},
+		start: start,
+		end:   start.Add(3 * time.Minute),
+	}
+	if err := fctx.getMetricsData(context.Background(), query); !errors.Is(err, fetchErr) {
+		t.Errorf("want %v, got %v", fetchErr, err)
+	}
+}
+
+// unknownIDCloudWatch adds a result of an unknown id to the results.
+type unknownIDCloudWatch struct {
+	fakeCloudWatch
+}
+
+func (s *unknownIDCloudWatch) GetMetricData(ctx context.Context, params *cloudwatch.GetMetricDataInput, optFns ...func(*cloudwatch.Options)) (*cloudwatch.GetMetricDataOutput, error) {
+	out, err := s.fakeCloudWatch.GetMetricData(ctx, params, optFns...)
+	if err != nil {
+		return nil, err
+	}
+	out.MetricDataResults = append([]types.MetricDataResult{
+		{
+			Id:         aws.String("unknown"),
+			Timestamps: []time.Time{aws.ToTime(params.StartTime)},
+			Values:     []float64{42},
+			StatusCode: types.StatusCodeComplete,
+		},
+	}, out.MetricDataResults...)
+	return out, nil
+}
+
+func TestGetMetricsData_UnknownID(t *testing.T) {
+	start := time.Unix(1234567860, 0)
+	fctx := &forwardContext{
+		forwarder: &Forwarder{
+			svccloudwatch: &unknownIDCloudWatch{
+				fakeCloudWatch: fakeCloudWatch{
+					values: map[string][]float64{"m1": {1}},
+				},
+			},
+		},
+		start: start,
+		end:   start.Add(time.Minute),
+	}
+	query := []*Query{
+		{Service: "foo", Name: "ec2.cpu", Metric: []interface{}{"AWS/EC2", "CPUUtilization"}, Stat: "Average"},
+	}
+	if err := fctx.getMetricsData(context.Background(), query); err == nil {
+		t.Error("want error, got nil")
+	}
+
+	// the other results are kept.
+	want := serviceMetricsType{
+		"foo": {
+			{Name: "ec2.cpu", Time: start.Unix(), Value: 1},
+		},
+	}
+	if diff := cmp.Diff(want, fctx.serviceMetrics); diff != "" {
+		t.Errorf("metrics mismatch: (-want/+got):\n%s", diff)
+	}
+}
+
+// failingStatisticsCloudWatch fails GetMetricStatistics of the metric names.
+type failingStatisticsCloudWatch struct {
+	fakeCloudWatch
+	fail map[string]error
+}
+
+func (s *failingStatisticsCloudWatch) GetMetricStatistics(ctx context.Context, params *cloudwatch.GetMetricStatisticsInput, optFns ...func(*cloudwatch.Options)) (*cloudwatch.GetMetricStatisticsOutput, error) {
+	if err := s.fail[aws.ToString(params.MetricName)]; err != nil {
+		return nil, err
+	}
+	return s.fakeCloudWatch.GetMetricStatistics(ctx, params, optFns...)
+}
+
+func TestGetMetricsData_JoinErrors(t *testing.T) {
+	start := time.Unix(1234567860, 0)
+	errCPU := errors.New("cpu is throttled")
+	errNetwork := errors.New("network is throttled")
+	zero := 0.0
+	fctx := &forwardContext{
+		forwarder: &Forwarder{
+			svccloudwatch: &failingStatisticsCloudWatch{
+				fakeCloudWatch: fakeCloudWatch{
+					statistics: map[string][]types.Datapoint{
+						"DiskReadOps": {{Timestamp: aws.Time(start), Average: aws.Float64(1)}},
+					},
+				},
+				fail: map[string]error{
+					"CPUUtilization": errCPU,
+					"NetworkIn":      errNetwork,
+				},
+			},
+		},
+		start: start,
+		end:   start.Add(time.Minute),
+	}
+	query := []*Query{
+		{Service: "foo", Name: "cpu", Metric: []interface{}{"AWS/EC2", "CPUUtilization"}, Stat: "Average", API: "statistics", Default: &zero},
+		{Service: "foo", Name: "disk", Metric: []interface{}{"AWS/EC2", "DiskReadOps"}, Stat: "Average", API: "statistics"},
+		{Service: "foo", Name: "network", Metric: []interface{}{"AWS/EC2", "NetworkIn"}, Stat: "Average", API: "statistics"},
+	}
+	err := fctx.getMetricsData(context.Background(), query)
+	if !errors.Is(err, errCPU) || !errors.Is(err, errNetwork) {
+		t.Errorf("want all the errors, got %v", err)
+	}
+
+	// the other queries are fetched, and the failed ones are not filled.
+	want := serviceMetricsType{
+		"foo": {
+			{Name: "disk", Time: start.Unix(), Value: 1},
+		},
+	}
+	if diff := cmp.Diff(want, fctx.serviceMetrics); diff != "" {
+		t.Errorf("metrics mismatch: (-want/+got):\n%s", diff)
+	}
+}
+
+func TestForwardMetrics_Now(t *testing.T) {
+	var start time.Time
+	now := time.Date(2024, 1, 2, 3, 4, 30, 0, time.UTC)
+	f := &Forwarder{
+		DryRun: true,
+		Now:    func() time.Time { return now },
+		svccloudwatch: &recordingCloudWatch{
+			fakeCloudWatch: fakeCloudWatch{
+				values: map[string][]float64{"m1": {1}},
+			},
+			onGetMetricData: func(s time.Time) { start = s },
+		},
+	}
+	data := []byte(`[{"service":"foo","name":"ec2.cpu","metric":["AWS/EC2","CPUUtilization"],"stat":"Average"}]`)
+	if _, err := f.ForwardMetrics(context.Background(), data); err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2024, 1, 2, 3, 2, 0, 0, time.UTC); !start.Equal(want) {
+		t.Errorf("unexpected start time: want %s, got %s", want, start)
+	}
+}
+
+func TestGetMetricsData_AlignTimestamps(t *testing.T) {
+	start := time.Unix(1234567860, 0)
+	fctx := &forwardContext{
+		forwarder: &Forwarder{
+			svccloudwatch: &fakeCloudWatch{
+				values: map[string][]float64{
+					"m1": {1, 2},
+					"m2": {3, 4},
+				},
+			},
+		},
+		// the timestamps of the results land mid-minute.
+		start: start.Add(30 * time.Second),
+		end:   start.Add(150 * time.Second),
+	}
+	metric := []interface{}{"AWS/EC2", "CPUUtilization"}
+	query := []*Query{
+		{Service: "foo", Name: "asis", Metric: metric, Stat: "Average"},
+		{Service: "foo", Name: "floor", Metric: metric, Stat: "Average", AlignTimestamps: "floorToMinute"},
+	}
+	if err := fctx.getMetricsData(context.Background(), query); err != nil {
+		t.Fatal(err)
+	}
+
+	want := serviceMetricsType{
+		"foo": {
+			{Name: "asis", Time: start.Add(30 * time.Second).Unix(), Value: 1},
+			{Name: "asis", Time: start.Add(90 * time.Second).Unix(), Value: 2},
+			{Name: "floor", Time: start.Unix(), Value: 3},
+			{Name: "floor", Time: start.Add(time.Minute).Unix(), Value: 4},
+		},
+	}
+	if diff := cmp.Diff(want, fctx.serviceMetrics); diff != "" {
+		t.Errorf("service metrics mismatch: (-want/+got):\n%s", diff)
+	}
+}
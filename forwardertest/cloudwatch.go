@@ -0,0 +1,240 @@
+// Package forwardertest provides in-memory fakes of the services that the forwarder depends on,
+// so the query files can be tested end to end without AWS and Mackerel.
+//
+//	cw := forwardertest.NewCloudWatch()
+//	cw.Put("AWS/RDS", "CPUUtilization", map[string]string{"DBInstanceIdentifier": "db"}, time.Now(), 42)
+//	srv := forwardertest.NewMackerelServer()
+//	defer srv.Close()
+//	f := &forwarder.Forwarder{
+//		CloudWatch: cw,
+//		APIKey:     "dummy",
+//		APIURL:     srv.URL,
+//	}
+package forwardertest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+	forwarder "github.com/shogo82148/mackerel-cloudwatch-forwarder"
+)
+
+// CloudWatch is an in-memory fake of Amazon CloudWatch.
+// The data points are put by Put, and aggregated by GetMetricData and GetMetricStatistics.
+// The metric math expressions are not supported.
+type CloudWatch struct {
+	mu      sync.Mutex
+	metrics map[string][]datum
+}
+
+var _ forwarder.CloudWatchAPI = (*CloudWatch)(nil)
+
+type datum struct {
+	timestamp time.Time
+	value     float64
+}
+
+// NewCloudWatch returns a new empty CloudWatch.
+func NewCloudWatch() *CloudWatch {
+	return &CloudWatch{
+		metrics: make(map[string][]datum),
+	}
+}
+
+// Put puts a data point of the metric.
+func (c *CloudWatch) Put(namespace, name string, dimensions map[string]string, timestamp time.Time, value float64) {
+	dims := make([]types.Dimension, 0, len(dimensions))
+	for k, v := range dimensions {
+		dims = append(dims, types.Dimension{
+			Name:  aws.String(k),
+			Value: aws.String(v),
+		})
+	}
+	key := metricKey(namespace, name, dims)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.metrics[key] = append(c.metrics[key], datum{
+		timestamp: timestamp,
+		value:     value,
+	})
+}
+
+// metricKey returns the key of the metric.
+// The dimensions are sorted, because CloudWatch doesn't distinguish their order.
+func metricKey(namespace, name string, dimensions []types.Dimension) string {
+	dims := make([]string, 0, len(dimensions))
+	for _, d := range dimensions {
+		dims = append(dims, aws.ToString(d.Name)+"="+aws.ToString(d.Value))
+	}
+	sort.Strings(dims)
+	return namespace + "\x00" + name + "\x00" + strings.Join(dims, "\x00")
+}
+
+// aggregate aggregates the data points of the metric in [start, end) by the period.
+// It returns the timestamps of the periods in ascending order.
+func (c *CloudWatch) aggregate(key string, start, end time.Time, period time.Duration) ([]time.Time, [][]float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	buckets := make(map[time.Time][]float64)
+	for _, d := range c.metrics[key] {
+		if d.timestamp.Before(start) || !d.timestamp.Before(end) {
+			continue
+		}
+		t := start.Add(d.timestamp.Sub(start) / period * period)
+		buckets[t] = append(buckets[t], d.value)
+	}
+	timestamps := make([]time.Time, 0, len(buckets))
+	for t := range buckets {
+		timestamps = append(timestamps, t)
+	}
+	slices.SortFunc(timestamps, func(a, b time.Time) int { return a.Compare(b) })
+	values := make([][]float64, 0, len(timestamps))
+	for _, t := range timestamps {
+		values = append(values, buckets[t])
+	}
+	return timestamps, values
+}
+
+// GetMetricData implements forwarder.CloudWatchAPI.
+func (c *CloudWatch) GetMetricData(ctx context.Context, params *cloudwatch.GetMetricDataInput, optFns ...func(*cloudwatch.Options)) (*cloudwatch.GetMetricDataOutput, error) {
+	start, end := aws.ToTime(params.StartTime), aws.ToTime(params.EndTime)
+	results := make([]types.MetricDataResult, 0, len(params.MetricDataQueries))
+	for _, q := range params.MetricDataQueries {
+		if q.Expression != nil {
+			return nil, fmt.Errorf("forwardertest: the expression of %s is not supported", aws.ToString(q.Id))
+		}
+		stat := q.MetricStat
+		if stat == nil || stat.Metric == nil {
+			return nil, fmt.Errorf("forwardertest: the metric of %s is missing", aws.ToString(q.Id))
+		}
+		if q.ReturnData != nil && !*q.ReturnData {
+			continue
+		}
+
+		key := metricKey(aws.ToString(stat.Metric.Namespace), aws.ToString(stat.Metric.MetricName), stat.Metric.Dimensions)
+		timestamps, buckets := c.aggregate(key, start, end, period(stat.Period))
+		values := make([]float64, 0, len(buckets))
+		for _, b := range buckets {
+			v, err := statistic(aws.ToString(stat.Stat), b)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+		}
+		if params.ScanBy != types.ScanByTimestampAscending {
+			slices.Reverse(timestamps)
+			slices.Reverse(values)
+		}
+		results = append(results, types.MetricDataResult{
+			Id:         q.Id,
+			Label:      q.Label,
+			Timestamps: timestamps,
+			Values:     values,
+			StatusCode: types.StatusCodeComplete,
+		})
+	}
+	return &cloudwatch.GetMetricDataOutput{
+		MetricDataResults: results,
+	}, nil
+}
+
+// GetMetricStatistics implements forwarder.CloudWatchAPI.
+func (c *CloudWatch) GetMetricStatistics(ctx context.Context, params *cloudwatch.GetMetricStatisticsInput, optFns ...func(*cloudwatch.Options)) (*cloudwatch.GetMetricStatisticsOutput, error) {
+	key := metricKey(aws.ToString(params.Namespace), aws.ToString(params.MetricName), params.Dimensions)
+	timestamps, buckets := c.aggregate(key, aws.ToTime(params.StartTime), aws.ToTime(params.EndTime), period(params.Period))
+	datapoints := make([]types.Datapoint, 0, len(buckets))
+	for i, b := range buckets {
+		dp := types.Datapoint{
+			Timestamp: aws.Time(timestamps[i]),
+			Unit:      params.Unit,
+		}
+		for _, s := range params.Statistics {
+			v, err := statistic(string(s), b)
+			if err != nil {
+				return nil, err
+			}
+			switch s {
+			case types.StatisticAverage:
+				dp.Average = aws.Float64(v)
+			case types.StatisticSum:
+				dp.Sum = aws.Float64(v)
+			case types.StatisticMinimum:
+				dp.Minimum = aws.Float64(v)
+			case types.StatisticMaximum:
+				dp.Maximum = aws.Float64(v)
+			case types.StatisticSampleCount:
+				dp.SampleCount = aws.Float64(v)
+			}
+		}
+		if len(params.ExtendedStatistics) > 0 {
+			dp.ExtendedStatistics = make(map[string]float64, len(params.ExtendedStatistics))
+			for _, s := range params.ExtendedStatistics {
+				v, err := statistic(s, b)
+				if err != nil {
+					return nil, err
+				}
+				dp.ExtendedStatistics[s] = v
+			}
+		}
+		datapoints = append(datapoints, dp)
+	}
+	return &cloudwatch.GetMetricStatisticsOutput{
+		Label:      params.MetricName,
+		Datapoints: datapoints,
+	}, nil
+}
+
+func period(p *int32) time.Duration {
+	if p == nil || *p <= 0 {
+		return time.Minute
+	}
+	return time.Duration(*p) * time.Second
+}
+
+// statistic calculates the statistic of the values.
+// The standard statistics and the percentiles (e.g. "p99") are supported.
+func statistic(stat string, values []float64) (float64, error) {
+	switch stat {
+	case "Average":
+		var sum float64
+		for _, v := range values {
+			sum += v
+		}
+		return sum / float64(len(values)), nil
+	case "Sum":
+		var sum float64
+		for _, v := range values {
+			sum += v
+		}
+		return sum, nil
+	case "Minimum":
+		return slices.Min(values), nil
+	case "Maximum":
+		return slices.Max(values), nil
+	case "SampleCount":
+		return float64(len(values)), nil
+	}
+	if p, ok := strings.CutPrefix(stat, "p"); ok {
+		percentile, err := strconv.ParseFloat(p, 64)
+		if err == nil && percentile >= 0 && percentile <= 100 {
+			sorted := slices.Clone(values)
+			slices.Sort(sorted)
+			i := int(math.Ceil(percentile/100*float64(len(sorted)))) - 1
+			return sorted[max(i, 0)], nil
+		}
+	}
+	return 0, errors.New("forwardertest: unsupported statistic: " + stat)
+}
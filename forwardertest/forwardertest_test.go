@@ -0,0 +1,177 @@
+package forwardertest_test
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	cwtypes "github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	forwarder "github.com/shogo82148/mackerel-cloudwatch-forwarder"
+	"github.com/shogo82148/mackerel-cloudwatch-forwarder/forwardertest"
+)
+
+func TestForwardMetrics(t *testing.T) {
+	now := time.Now()
+	cw := forwardertest.NewCloudWatch()
+	for i := range 10 {
+		ts := now.Add(-time.Duration(i) * time.Minute)
+		cw.Put("AWS/RDS", "CPUUtilization", map[string]string{"DBInstanceIdentifier": "db"}, ts, 40)
+		cw.Put("AWS/RDS", "CPUUtilization", map[string]string{"DBInstanceIdentifier": "db"}, ts, 60)
+	}
+
+	srv := forwardertest.NewMackerelServer()
+	defer srv.Close()
+	srv.APIKey = "secret"
+
+	f := &forwarder.Forwarder{
+		APIURL:          srv.URL,
+		APIKeyParameter: "/mackerel/apikey",
+		CloudWatch:      cw,
+		SSM:             forwardertest.NewSSM(map[string]string{"/mackerel/apikey": "secret"}),
+		KMS:             forwardertest.KMS{},
+		Logger:          slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	data := []byte(`[{"service":"foo","name":"rds.cpu","metric":["AWS/RDS","CPUUtilization","DBInstanceIdentifier","db"],"stat":"Average"}]`)
+	if _, err := f.ForwardMetrics(context.Background(), data); err != nil {
+		t.Fatal(err)
+	}
+
+	values := srv.ServiceMetrics("foo")
+	if len(values) == 0 {
+		t.Fatal("no metrics are posted")
+	}
+	for _, v := range values {
+		if v.Name != "rds.cpu" || v.Value != 50 {
+			t.Errorf("unexpected value: %#v", v)
+		}
+	}
+}
+
+func TestForwardMetrics_BillingPreset(t *testing.T) {
+	now := time.Now()
+	cw := forwardertest.NewCloudWatch()
+	for i := range 48 * 12 {
+		// CloudWatch publishes the billing metrics every few hours, but the fake has the data every 5 minutes.
+		ts := now.Add(-time.Duration(i) * 5 * time.Minute)
+		cw.Put("AWS/Billing", "EstimatedCharges", map[string]string{"Currency": "USD"}, ts, float64(1000-i))
+	}
+
+	srv := forwardertest.NewMackerelServer()
+	defer srv.Close()
+	srv.APIKey = "secret"
+
+	f := &forwarder.Forwarder{
+		APIURL:          srv.URL,
+		APIKeyParameter: "/mackerel/apikey",
+		CloudWatch:      cw,
+		SSM:             forwardertest.NewSSM(map[string]string{"/mackerel/apikey": "secret"}),
+		KMS:             forwardertest.KMS{},
+		Logger:          slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	data := []byte(`[{"service":"foo","preset":"billing","dimensions":{"Currency":"USD"}}]`)
+	report, err := f.ForwardMetrics(context.Background(), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Stale != 0 {
+		t.Errorf("want no stale data points, got %d", report.Stale)
+	}
+
+	values := srv.ServiceMetrics("foo")
+	if len(values) != 1 {
+		t.Fatalf("want a data point, got %d", len(values))
+	}
+	if values[0].Name != "billing.estimated_charges" {
+		t.Errorf("unexpected name: %s", values[0].Name)
+	}
+	if age := now.Sub(time.Unix(values[0].Time, 0)); age > 6*time.Hour {
+		t.Errorf("the data point is too old: %s", age)
+	}
+}
+
+func TestMackerelServer_InvalidAPIKey(t *testing.T) {
+	srv := forwardertest.NewMackerelServer()
+	defer srv.Close()
+	srv.APIKey = "secret"
+
+	f := &forwarder.Forwarder{
+		APIURL:            srv.URL,
+		APIKey:            base64.StdEncoding.EncodeToString([]byte("wrong")),
+		APIKeyWithDecrypt: true,
+		CloudWatch:        forwardertest.NewCloudWatch(),
+		KMS:               forwardertest.KMS{},
+		Logger:            slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	data := []byte(`[{"service":"foo","name":"rds.cpu","metric":["AWS/RDS","CPUUtilization"],"stat":"Average"}]`)
+	_, err := f.ForwardMetrics(context.Background(), data)
+	if !errors.Is(err, forwarder.ErrInvalidAPIKey) {
+		t.Errorf("want ErrInvalidAPIKey, got %v", err)
+	}
+}
+
+func TestCloudWatch_GetMetricData(t *testing.T) {
+	start := time.Date(2024, 1, 2, 3, 4, 0, 0, time.UTC)
+	cw := forwardertest.NewCloudWatch()
+	dims := map[string]string{"LoadBalancer": "app/foo", "TargetGroup": "tg/bar"}
+	cw.Put("AWS/ApplicationELB", "RequestCount", dims, start, 1)
+	cw.Put("AWS/ApplicationELB", "RequestCount", dims, start.Add(30*time.Second), 2)
+	cw.Put("AWS/ApplicationELB", "RequestCount", dims, start.Add(time.Minute), 4)
+	cw.Put("AWS/ApplicationELB", "RequestCount", dims, start.Add(2*time.Minute), 8) // out of the range
+
+	resp, err := cw.GetMetricData(context.Background(), &cloudwatch.GetMetricDataInput{
+		StartTime: aws.Time(start),
+		EndTime:   aws.Time(start.Add(2 * time.Minute)),
+		MetricDataQueries: []cwtypes.MetricDataQuery{
+			{
+				Id: aws.String("m1"),
+				MetricStat: &cwtypes.MetricStat{
+					Metric: &cwtypes.Metric{
+						Namespace:  aws.String("AWS/ApplicationELB"),
+						MetricName: aws.String("RequestCount"),
+						// the order of the dimensions doesn't matter.
+						Dimensions: []cwtypes.Dimension{
+							{Name: aws.String("TargetGroup"), Value: aws.String("tg/bar")},
+							{Name: aws.String("LoadBalancer"), Value: aws.String("app/foo")},
+						},
+					},
+					Period: aws.Int32(60),
+					Stat:   aws.String("Sum"),
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.MetricDataResults) != 1 {
+		t.Fatalf("unexpected results: %v", resp.MetricDataResults)
+	}
+	r := resp.MetricDataResults[0]
+	// the results are in descending order of the timestamps by default.
+	want := []float64{4, 3}
+	if len(r.Values) != len(want) || r.Values[0] != want[0] || r.Values[1] != want[1] {
+		t.Errorf("unexpected values: want %v, got %v", want, r.Values)
+	}
+	if !r.Timestamps[1].Equal(start) {
+		t.Errorf("unexpected timestamp: want %s, got %s", start, r.Timestamps[1])
+	}
+}
+
+func TestSSM_NotFound(t *testing.T) {
+	s := forwardertest.NewSSM(nil)
+	_, err := s.GetParameter(context.Background(), &ssm.GetParameterInput{
+		Name: aws.String("/not/found"),
+	})
+	var nf *types.ParameterNotFound
+	if !errors.As(err, &nf) {
+		t.Errorf("want ParameterNotFound, got %v", err)
+	}
+}
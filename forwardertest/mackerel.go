@@ -0,0 +1,277 @@
+package forwardertest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+
+	forwarder "github.com/shogo82148/mackerel-cloudwatch-forwarder"
+)
+
+// MackerelServer is a fake server of the Mackerel API.
+// It records the posted metrics and check reports, and serves the hosts that are registered.
+// Set URL to Forwarder.APIURL to use it.
+type MackerelServer struct {
+	*httptest.Server
+
+	// APIKey is the API key that the server accepts.
+	// If it is empty, any API keys are accepted.
+	APIKey string
+
+	// OrgName is the name of the organization that the API key belongs to.
+	OrgName string
+
+	mu             sync.Mutex
+	serviceMetrics map[string][]forwarder.ServiceMetricValue
+	hostMetrics    []forwarder.HostMetricValue
+	checkReports   []forwarder.CheckReport
+	hosts          []forwarder.Host
+	services       []forwarder.Service
+	hostMetadata   map[string]map[string]json.RawMessage
+}
+
+// NewMackerelServer starts and returns a new MackerelServer.
+// The caller should call Close when finished, to shut it down.
+func NewMackerelServer() *MackerelServer {
+	s := &MackerelServer{
+		OrgName:        "forwardertest",
+		serviceMetrics: make(map[string][]forwarder.ServiceMetricValue),
+		hostMetadata:   make(map[string]map[string]json.RawMessage),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v0/org", s.handleOrg)
+	mux.HandleFunc("POST /api/v0/services/{service}/tsdb", s.handleServiceMetrics)
+	mux.HandleFunc("POST /api/v0/tsdb", s.handleHostMetrics)
+	mux.HandleFunc("GET /api/v0/tsdb/latest", s.handleLatestHostMetrics)
+	mux.HandleFunc("POST /api/v0/monitoring/checks/report", s.handleCheckReports)
+	mux.HandleFunc("GET /api/v0/services", s.handleFindServices)
+	mux.HandleFunc("POST /api/v0/services", s.handleCreateService)
+	mux.HandleFunc("GET /api/v0/hosts", s.handleFindHosts)
+	mux.HandleFunc("POST /api/v0/hosts", s.handleCreateHost)
+	mux.HandleFunc("PUT /api/v0/hosts/{host}/metadata/{namespace}", s.handleHostMetadata)
+	s.Server = httptest.NewServer(s.authenticate(mux))
+	return s
+}
+
+func (s *MackerelServer) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.APIKey != "" && r.Header.Get("X-Api-Key") != s.APIKey {
+			writeError(w, http.StatusUnauthorized, "Authentication failed. Please try with valid Api Key.")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *MackerelServer) handleOrg(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, map[string]string{"name": s.OrgName})
+}
+
+func (s *MackerelServer) handleServiceMetrics(w http.ResponseWriter, r *http.Request) {
+	var values []forwarder.ServiceMetricValue
+	if err := json.NewDecoder(r.Body).Decode(&values); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	service := r.PathValue("service")
+
+	s.mu.Lock()
+	s.serviceMetrics[service] = append(s.serviceMetrics[service], values...)
+	s.mu.Unlock()
+	writeJSON(w, map[string]bool{"success": true})
+}
+
+func (s *MackerelServer) handleHostMetrics(w http.ResponseWriter, r *http.Request) {
+	var values []forwarder.HostMetricValue
+	if err := json.NewDecoder(r.Body).Decode(&values); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	s.hostMetrics = append(s.hostMetrics, values...)
+	s.mu.Unlock()
+	writeJSON(w, map[string]bool{"success": true})
+}
+
+func (s *MackerelServer) handleLatestHostMetrics(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	latest := make(map[string]map[string]*forwarder.HostMetricValue)
+	for _, hostID := range q["hostId"] {
+		latest[hostID] = make(map[string]*forwarder.HostMetricValue)
+		for _, name := range q["name"] {
+			latest[hostID][name] = nil
+		}
+	}
+	for _, v := range s.hostMetrics {
+		metrics, ok := latest[v.HostID]
+		if !ok {
+			continue
+		}
+		if last, ok := metrics[v.Name]; ok && (last == nil || last.Time < v.Time) {
+			metrics[v.Name] = &v
+		}
+	}
+	writeJSON(w, map[string]any{"tsdbLatest": latest})
+}
+
+func (s *MackerelServer) handleCheckReports(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Reports []forwarder.CheckReport `json:"reports"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	s.checkReports = append(s.checkReports, payload.Reports...)
+	s.mu.Unlock()
+	writeJSON(w, map[string]bool{"success": true})
+}
+
+func (s *MackerelServer) handleFindServices(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	services := append([]forwarder.Service{}, s.services...)
+	s.mu.Unlock()
+	writeJSON(w, map[string][]forwarder.Service{"services": services})
+}
+
+func (s *MackerelServer) handleCreateService(w http.ResponseWriter, r *http.Request) {
+	var service forwarder.Service
+	if err := json.NewDecoder(r.Body).Decode(&service); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if s.HasService(service.Name) {
+		writeError(w, http.StatusBadRequest, "Duplicated service name.")
+		return
+	}
+	s.AddService(service)
+	writeJSON(w, service)
+}
+
+func (s *MackerelServer) handleFindHosts(w http.ResponseWriter, r *http.Request) {
+	customIdentifier := r.URL.Query().Get("customIdentifier")
+
+	s.mu.Lock()
+	hosts := make([]forwarder.Host, 0, len(s.hosts))
+	for _, h := range s.hosts {
+		if customIdentifier == "" || h.CustomIdentifier == customIdentifier {
+			hosts = append(hosts, h)
+		}
+	}
+	s.mu.Unlock()
+	writeJSON(w, map[string][]forwarder.Host{"hosts": hosts})
+}
+
+func (s *MackerelServer) handleCreateHost(w http.ResponseWriter, r *http.Request) {
+	var host forwarder.Host
+	if err := json.NewDecoder(r.Body).Decode(&host); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	id := s.AddHost(host)
+	writeJSON(w, map[string]string{"id": id})
+}
+
+func (s *MackerelServer) handleHostMetadata(w http.ResponseWriter, r *http.Request) {
+	var metadata json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&metadata); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	host, namespace := r.PathValue("host"), r.PathValue("namespace")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.hostMetadata[host] == nil {
+		s.hostMetadata[host] = make(map[string]json.RawMessage)
+	}
+	s.hostMetadata[host][namespace] = metadata
+	writeJSON(w, map[string]bool{"success": true})
+}
+
+// AddHost registers the host, and returns its host id.
+// If the id of the host is empty, a new id is assigned.
+func (s *MackerelServer) AddHost(host forwarder.Host) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if host.ID == "" {
+		host.ID = "host-" + strconv.Itoa(len(s.hosts)+1)
+	}
+	s.hosts = append(s.hosts, host)
+	return host.ID
+}
+
+// AddService registers the service.
+func (s *MackerelServer) AddService(service forwarder.Service) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.services = append(s.services, service)
+}
+
+// HasService reports whether the service is registered.
+func (s *MackerelServer) HasService(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, service := range s.services {
+		if service.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ServiceMetrics returns the service metrics posted to the service.
+func (s *MackerelServer) ServiceMetrics(service string) []forwarder.ServiceMetricValue {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]forwarder.ServiceMetricValue(nil), s.serviceMetrics[service]...)
+}
+
+// HostMetrics returns the host metrics posted to the host.
+func (s *MackerelServer) HostMetrics(hostID string) []forwarder.HostMetricValue {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ret []forwarder.HostMetricValue
+	for _, v := range s.hostMetrics {
+		if v.HostID == hostID {
+			ret = append(ret, v)
+		}
+	}
+	return ret
+}
+
+// CheckReports returns the posted check reports.
+func (s *MackerelServer) CheckReports() []forwarder.CheckReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]forwarder.CheckReport(nil), s.checkReports...)
+}
+
+// HostMetadata returns the metadata of the host in the namespace.
+// It returns nil if the metadata is not put.
+func (s *MackerelServer) HostMetadata(hostID, namespace string) json.RawMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.hostMetadata[hostID][namespace]
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{
+		"error": map[string]string{"message": message},
+	})
+}
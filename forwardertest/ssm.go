@@ -0,0 +1,75 @@
+package forwardertest
+
+import (
+	"context"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	forwarder "github.com/shogo82148/mackerel-cloudwatch-forwarder"
+)
+
+// SSM is an in-memory fake of the parameter store of AWS Systems Manager.
+// The parameters are stored in plain text, so WithDecryption has no effect.
+type SSM struct {
+	mu         sync.Mutex
+	parameters map[string]string
+}
+
+var _ forwarder.SSMAPI = (*SSM)(nil)
+
+// NewSSM returns a new SSM that has the parameters.
+func NewSSM(parameters map[string]string) *SSM {
+	s := &SSM{
+		parameters: make(map[string]string, len(parameters)),
+	}
+	for name, value := range parameters {
+		s.parameters[name] = value
+	}
+	return s
+}
+
+// Put puts the parameter.
+func (s *SSM) Put(name, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.parameters[name] = value
+}
+
+// GetParameter implements forwarder.SSMAPI.
+// It returns *types.ParameterNotFound if the parameter doesn't exist.
+func (s *SSM) GetParameter(ctx context.Context, params *ssm.GetParameterInput, optFns ...func(*ssm.Options)) (*ssm.GetParameterOutput, error) {
+	name := aws.ToString(params.Name)
+	s.mu.Lock()
+	value, ok := s.parameters[name]
+	s.mu.Unlock()
+	if !ok {
+		return nil, &types.ParameterNotFound{
+			Message: aws.String("parameter " + name + " is not found"),
+		}
+	}
+	return &ssm.GetParameterOutput{
+		Parameter: &types.Parameter{
+			Name:  aws.String(name),
+			Type:  types.ParameterTypeSecureString,
+			Value: aws.String(value),
+		},
+	}, nil
+}
+
+// KMS is a fake of AWS KMS that doesn't encrypt anything,
+// i.e. Decrypt returns the ciphertext as the plaintext.
+// Set the API key encoded in base64 to Forwarder.APIKey to test APIKeyWithDecrypt.
+type KMS struct{}
+
+var _ forwarder.KMSAPI = KMS{}
+
+// Decrypt implements forwarder.KMSAPI.
+func (KMS) Decrypt(ctx context.Context, params *kms.DecryptInput, optFns ...func(*kms.Options)) (*kms.DecryptOutput, error) {
+	return &kms.DecryptOutput{
+		KeyId:     params.KeyId,
+		Plaintext: append([]byte(nil), params.CiphertextBlob...),
+	}, nil
+}
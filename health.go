@@ -0,0 +1,134 @@
+package forwarder
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+const (
+	// healthCredentialsTTL is the duration for caching the result of the check of the AWS credentials,
+	// so that the frequent probes don't call AWS STS every time.
+	healthCredentialsTTL = time.Minute
+
+	// healthMaxStaleness is the maximum duration since the last successful invocation for being ready.
+	healthMaxStaleness = 5 * time.Minute
+)
+
+// HealthStatus is the status of the forwarder reported by the health endpoints.
+type HealthStatus struct {
+	// OK is true if all the checks succeed.
+	OK bool `json:"ok"`
+
+	// Checks is the results of the checks: "aws-credentials", "last-success", and "pending-metrics".
+	Checks []PingCheck `json:"checks"`
+
+	// LastSuccess is the time when the last successful invocation finished.
+	// It is nil if no invocations have succeeded yet.
+	LastSuccess *time.Time `json:"lastSuccess,omitempty"`
+
+	// PendingMetrics is the number of the pending data points for retrying.
+	PendingMetrics int `json:"pendingMetrics"`
+}
+
+// Health checks the status of the forwarder for the health checks of the long-running processes.
+func (f *Forwarder) Health(ctx context.Context) *HealthStatus {
+	f.muStats.Lock()
+	stats := f.stats
+	f.muStats.Unlock()
+
+	status := &HealthStatus{
+		OK:             true,
+		PendingMetrics: stats.pending,
+	}
+	if !stats.lastSuccess.IsZero() {
+		status.LastSuccess = &stats.lastSuccess
+	}
+	add := func(name, msg string, err error) {
+		c := PingCheck{Name: name, OK: err == nil, Message: msg}
+		if err != nil {
+			c.Message = err.Error()
+			status.OK = false
+		}
+		status.Checks = append(status.Checks, c)
+	}
+
+	arn, err := f.checkCredentials(ctx)
+	add("aws-credentials", arn, err)
+
+	switch {
+	case stats.invocations == 0:
+		add("last-success", "no invocations yet", nil)
+	case stats.lastSuccess.IsZero():
+		add("last-success", "", fmt.Errorf("forwarder: no invocations have succeeded in %d invocations", stats.invocations))
+	case time.Since(stats.lastSuccess) > healthMaxStaleness:
+		add("last-success", "", fmt.Errorf("forwarder: the last successful invocation is at %s", stats.lastSuccess.Format(time.RFC3339)))
+	default:
+		add("last-success", stats.lastSuccess.Format(time.RFC3339), nil)
+	}
+
+	if limit := f.maxPendingMetrics(ctx); stats.pending >= limit {
+		add("pending-metrics", "", fmt.Errorf("forwarder: the pending data points reach the limit %d", limit))
+	} else {
+		add("pending-metrics", strconv.Itoa(stats.pending), nil)
+	}
+	return status
+}
+
+// checkCredentials verifies the AWS credentials by AWS STS, and returns the caller's ARN.
+// The result is cached for healthCredentialsTTL.
+func (f *Forwarder) checkCredentials(ctx context.Context) (string, error) {
+	f.muStats.Lock()
+	if time.Since(f.credentialsCheckedAt) < healthCredentialsTTL {
+		arn, err := f.credentialsARN, f.credentialsErr
+		f.muStats.Unlock()
+		return arn, err
+	}
+	f.muStats.Unlock()
+
+	var arn string
+	resp, err := f.sts().GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err == nil {
+		arn = aws.ToString(resp.Arn)
+	}
+
+	f.muStats.Lock()
+	defer f.muStats.Unlock()
+	f.credentialsCheckedAt = time.Now()
+	f.credentialsARN, f.credentialsErr = arn, err
+	return arn, err
+}
+
+// LivenessHandler returns the handler for the liveness probe, e.g. "/healthz".
+// It always responds 200 OK while the process is serving, with HealthStatus as the body,
+// because restarting the process doesn't fix the failures of AWS or Mackerel.
+func (f *Forwarder) LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeHealthStatus(w, http.StatusOK, f.Health(r.Context()))
+	})
+}
+
+// ReadinessHandler returns the handler for the readiness probe, e.g. "/readyz".
+// It responds 503 Service Unavailable if any checks of HealthStatus fail.
+func (f *Forwarder) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := f.Health(r.Context())
+		code := http.StatusOK
+		if !status.OK {
+			code = http.StatusServiceUnavailable
+		}
+		writeHealthStatus(w, code, status)
+	})
+}
+
+func writeHealthStatus(w http.ResponseWriter, code int, status *HealthStatus) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(status)
+}
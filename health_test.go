@@ -0,0 +1,61 @@
+package forwarder
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestForwarder_ReadinessHandler(t *testing.T) {
+	svc := &fakeSTS{}
+	f := &Forwarder{svcsts: svc}
+
+	probe := func() (int, *HealthStatus) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		f.ReadinessHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+		var status HealthStatus
+		if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+			t.Fatal(err)
+		}
+		return rec.Code, &status
+	}
+
+	// no invocations yet.
+	if code, status := probe(); code != http.StatusOK || !status.OK {
+		t.Errorf("want ready, got %d: %+v", code, status)
+	}
+
+	// the invocations keep failing.
+	f.recordStats(&InvocationReport{}, errors.New("failed"))
+	if code, status := probe(); code != http.StatusServiceUnavailable || status.OK {
+		t.Errorf("want not ready, got %d: %+v", code, status)
+	}
+
+	// recovered.
+	f.recordStats(&InvocationReport{}, nil)
+	code, status := probe()
+	if code != http.StatusOK || status.LastSuccess == nil {
+		t.Errorf("want ready, got %d: %+v", code, status)
+	}
+
+	// the result of the credentials is cached.
+	svc.err = errors.New("expired token")
+	if code, _ := probe(); code != http.StatusOK {
+		t.Errorf("want the cached result, got %d", code)
+	}
+	f.credentialsCheckedAt = time.Time{}
+	if code, _ := probe(); code != http.StatusServiceUnavailable {
+		t.Errorf("want not ready, got %d", code)
+	}
+
+	// the liveness probe doesn't fail.
+	rec := httptest.NewRecorder()
+	f.LivenessHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("want %d, got %d", http.StatusOK, rec.Code)
+	}
+}
@@ -0,0 +1,222 @@
+package forwarder
+
+import (
+	"context"
+	"slices"
+	"time"
+)
+
+// highWaterMarkRetention is the retention of the high-water marks.
+// The marks older than the time windows are useless, so they are removed.
+const highWaterMarkRetention = 24 * time.Hour
+
+// fetchStart returns the start of the time window for fetching the queries by GetMetricData.
+// The periods up to the high-water marks of all the labels are already forwarded, so they are skipped.
+func (fctx *forwardContext) fetchStart(queries []*metricQuery, start time.Time) time.Time {
+	if fctx.highWaterMarks == nil {
+		return start
+	}
+	var ret time.Time
+	for _, q := range queries {
+		if !q.Query.returnData() {
+			continue
+		}
+		mark, ok := fctx.highWaterMarks[q.Label.String()]
+		if !ok {
+			return start
+		}
+		t := time.Unix(mark, 0).Add(q.period())
+		if ret.IsZero() || t.Before(ret) {
+			ret = t
+		}
+	}
+	if ret.IsZero() || ret.Before(start) {
+		return start
+	}
+	return ret
+}
+
+// isForwarded reports whether the minute of the label is already forwarded.
+func (fctx *forwardContext) isForwarded(label string, t time.Time) bool {
+	mark, ok := fctx.highWaterMarks[label]
+	return ok && t.Unix() <= mark
+}
+
+// postedKey identifies the data point that is posted to Mackerel.
+type postedKey struct {
+	service string
+	hostID  string
+	name    string
+	time    int64
+}
+
+// collectHighWaterMarks records the latest complete data points of the queries as the candidates of the high-water marks.
+// The marks are advanced by advanceHighWaterMarks after the data points are posted.
+func (fctx *forwardContext) collectHighWaterMarks(queries map[string]*metricQuery) {
+	if fctx.highWaterMarks == nil {
+		return
+	}
+	fctx.markCandidates = make(map[string]markCandidate, len(queries))
+	for l, q := range queries {
+		if v, ok := fctx.latestComplete(l, q); ok {
+			fctx.markCandidates[l] = markCandidate{label: q.Label, time: v.Time.Unix()}
+		}
+	}
+}
+
+// markCandidate is the candidate of the high-water mark of the label.
+type markCandidate struct {
+	label Label
+	time  int64
+}
+
+// key returns the key of the data point of the label at t, see appendValue.
+func (c markCandidate) key(t int64) postedKey {
+	name := c.label.MetricName
+	if validateMetricName(name) != nil {
+		// the name is sanitized on posting, see normalizeMetricName.
+		name = sanitizeMetricName(name)
+	}
+	if c.label.Service != "" {
+		return postedKey{service: c.label.Service, name: name, time: t}
+	}
+	return postedKey{hostID: c.label.HostID, name: name, time: t}
+}
+
+// servicePostedKeys returns the keys of the service metrics.
+func servicePostedKeys(service string, values []ServiceMetricValue) []postedKey {
+	keys := make([]postedKey, 0, len(values))
+	for _, v := range values {
+		keys = append(keys, postedKey{service: service, name: v.Name, time: v.Time})
+	}
+	return keys
+}
+
+// hostPostedKeys returns the keys of the host metrics.
+func hostPostedKeys(values []HostMetricValue) []postedKey {
+	keys := make([]postedKey, 0, len(values))
+	for _, v := range values {
+		keys = append(keys, postedKey{hostID: v.HostID, name: v.Name, time: v.Time})
+	}
+	return keys
+}
+
+// addPosted records the data points that are posted to Mackerel,
+// i.e. the values except the rejected and the failed ones.
+// The caller must hold fctx.mu.
+func (fctx *forwardContext) addPosted(values []postedKey, rejected, failed []postedKey) {
+	if fctx.highWaterMarks == nil {
+		return
+	}
+	unposted := make(map[postedKey]bool, len(rejected)+len(failed))
+	for _, k := range rejected {
+		unposted[k] = true
+	}
+	for _, k := range failed {
+		unposted[k] = true
+	}
+	if fctx.posted == nil {
+		fctx.posted = make(map[postedKey]bool)
+	}
+	for _, k := range values {
+		if !unposted[k] {
+			fctx.posted[k] = true
+		}
+	}
+}
+
+// advanceHighWaterMarks advances the high-water marks up to the candidates.
+// The mark of a label stops before its first data point that is not posted, e.g. failed, rejected, or dropped,
+// so that the data point is fetched and forwarded again in the next invocation.
+func (fctx *forwardContext) advanceHighWaterMarks(now time.Time) {
+	if fctx.highWaterMarks == nil {
+		return
+	}
+	for l, c := range fctx.markCandidates {
+		times := make([]int64, 0, len(fctx.points[l]))
+		for t := range fctx.points[l] {
+			if t <= c.time {
+				times = append(times, t)
+			}
+		}
+		slices.Sort(times)
+
+		mark, ok := fctx.highWaterMarks[l]
+		for _, t := range times {
+			if ok && t <= mark {
+				continue
+			}
+			if !fctx.posted[c.key(t)] {
+				break
+			}
+			mark, ok = t, true
+		}
+		if ok {
+			fctx.highWaterMarks[l] = mark
+		}
+	}
+
+	threshold := now.Add(-highWaterMarkRetention).Unix()
+	for l, mark := range fctx.highWaterMarks {
+		if mark < threshold {
+			delete(fctx.highWaterMarks, l)
+		}
+	}
+}
+
+func (f *Forwarder) lookupLatest() bool {
+	if f.LookupLatest {
+		return true
+	}
+	return f.env().LookupLatest
+}
+
+// seedHighWaterMarks advances the high-water marks of the host metrics to their latest values on Mackerel.
+// It runs once at cold start, because the marks are maintained by the invocations after that.
+// The caller must hold f.muPending.
+func (f *Forwarder) seedHighWaterMarks(ctx context.Context, client *MackerelClient, query []*Query) {
+	if f.latestLookedUp {
+		return
+	}
+
+	resolved, _ := prepareQueries(query)
+	labels := make(map[string]Label)
+	var hostIDs, names []string
+	for _, q := range resolved {
+		if !q.Query.returnData() || q.Label.HostID == "" {
+			continue
+		}
+		labels[q.Label.String()] = q.Label
+		hostIDs = append(hostIDs, q.Label.HostID)
+		names = append(names, q.Label.MetricName)
+	}
+	if len(labels) == 0 {
+		f.latestLookedUp = true
+		return
+	}
+
+	slices.Sort(hostIDs)
+	hostIDs = slices.Compact(hostIDs)
+	slices.Sort(names)
+	names = slices.Compact(names)
+	latest, err := client.GetLatestHostMetricValues(ctx, hostIDs, names)
+	if err != nil {
+		// try again in the next invocation.
+		f.logger().WarnContext(ctx, "failed to look up the latest values of the host metrics", "error", err.Error())
+		return
+	}
+	f.latestLookedUp = true
+
+	var cnt int
+	for l, label := range labels {
+		v, ok := latest[label.HostID][label.MetricName]
+		if !ok {
+			continue
+		}
+		if mark, ok := f.highWaterMarks[l]; !ok || v.Time > mark {
+			f.highWaterMarks[l] = v.Time
+			cnt++
+		}
+	}
+	f.logger().InfoContext(ctx, "seeded the high-water marks by the latest values on mackerel", "count", cnt)
+}
@@ -0,0 +1,174 @@
+package forwarder
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// postAll marks all the fetched metrics as posted, and advances the high-water marks.
+func postAll(fctx *forwardContext) {
+	for service, metrics := range fctx.serviceMetrics {
+		fctx.addPosted(servicePostedKeys(service, metrics), nil, nil)
+	}
+	fctx.addPosted(hostPostedKeys(fctx.hostMetrics), nil, nil)
+	fctx.advanceHighWaterMarks(time.Now())
+}
+
+func TestAdvanceHighWaterMarks(t *testing.T) {
+	now := time.Now().Truncate(time.Minute)
+	t1, t2, t3 := now.Add(-3*time.Minute).Unix(), now.Add(-2*time.Minute).Unix(), now.Add(-time.Minute).Unix()
+	newContext := func() *forwardContext {
+		return &forwardContext{
+			highWaterMarks: map[string]int64{},
+			points: map[string]map[int64]float64{
+				"service=foo:a": {t1: 1, t2: 2, t3: 3},
+				"host=host-1:b": {t1: 1, t2: 2, t3: 3},
+			},
+			markCandidates: map[string]markCandidate{
+				"service=foo:a": {label: Label{Service: "foo", MetricName: "a"}, time: t3},
+				"host=host-1:b": {label: Label{HostID: "host-1", MetricName: "b"}, time: t3},
+			},
+		}
+	}
+	service := []ServiceMetricValue{{Name: "a", Time: t1, Value: 1}, {Name: "a", Time: t2, Value: 2}, {Name: "a", Time: t3, Value: 3}}
+	host := []HostMetricValue{{HostID: "host-1", Name: "b", Time: t1, Value: 1}, {HostID: "host-1", Name: "b", Time: t2, Value: 2}, {HostID: "host-1", Name: "b", Time: t3, Value: 3}}
+
+	// all the data points are posted.
+	fctx := newContext()
+	fctx.addPosted(servicePostedKeys("foo", service), nil, nil)
+	fctx.addPosted(hostPostedKeys(host), nil, nil)
+	fctx.advanceHighWaterMarks(now)
+	want := map[string]int64{"service=foo:a": t3, "host=host-1:b": t3}
+	if diff := cmp.Diff(want, fctx.highWaterMarks); diff != "" {
+		t.Errorf("marks mismatch: (-want/+got):\n%s", diff)
+	}
+
+	// the marks stop before the data points that failed or are rejected,
+	// even if the later ones are posted.
+	fctx = newContext()
+	fctx.addPosted(servicePostedKeys("foo", service), nil, servicePostedKeys("foo", service[1:2]))
+	fctx.addPosted(hostPostedKeys(host), hostPostedKeys(host[:1]), nil)
+	fctx.advanceHighWaterMarks(now)
+	want = map[string]int64{"service=foo:a": t1}
+	if diff := cmp.Diff(want, fctx.highWaterMarks); diff != "" {
+		t.Errorf("marks mismatch: (-want/+got):\n%s", diff)
+	}
+
+	// the data points that are dropped before posting, e.g. the stale ones, don't advance the marks.
+	fctx = newContext()
+	fctx.addPosted(servicePostedKeys("foo", service[:2]), nil, nil)
+	fctx.advanceHighWaterMarks(now)
+	want = map[string]int64{"service=foo:a": t2}
+	if diff := cmp.Diff(want, fctx.highWaterMarks); diff != "" {
+		t.Errorf("marks mismatch: (-want/+got):\n%s", diff)
+	}
+}
+
+func TestGetMetricsData_HighWaterMarks(t *testing.T) {
+	start := time.Now().Truncate(time.Minute).Add(-10 * time.Minute)
+	var fetched []time.Time
+	zero := 0.0
+	query := []*Query{
+		{Service: "foo", Name: "a", Metric: []interface{}{"AWS/EC2", "CPUUtilization"}, Stat: "Average", Default: &zero},
+	}
+	newContext := func(marks map[string]int64) *forwardContext {
+		return &forwardContext{
+			forwarder: &Forwarder{
+				svccloudwatch: &recordingCloudWatch{
+					fakeCloudWatch: fakeCloudWatch{
+						values: map[string][]float64{"m1": {1}},
+					},
+					onGetMetricData: func(s time.Time) { fetched = append(fetched, s) },
+				},
+			},
+			start:          start,
+			end:            start.Add(5 * time.Minute),
+			highWaterMarks: marks,
+		}
+	}
+
+	// the minutes up to the mark are not fetched nor filled.
+	marks := map[string]int64{"service=foo:a": start.Add(2 * time.Minute).Unix()}
+	fctx := newContext(marks)
+	if err := fctx.getMetricsData(context.Background(), query); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]time.Time{start.Add(3 * time.Minute)}, fetched); diff != "" {
+		t.Errorf("fetched windows mismatch: (-want/+got):\n%s", diff)
+	}
+	want := serviceMetricsType{
+		"foo": {
+			{Name: "a", Time: start.Add(3 * time.Minute).Unix(), Value: 1},
+			{Name: "a", Time: start.Add(4 * time.Minute).Unix(), Value: 0},
+		},
+	}
+	if diff := cmp.Diff(want, fctx.serviceMetrics); diff != "" {
+		t.Errorf("service metrics mismatch: (-want/+got):\n%s", diff)
+	}
+	postAll(fctx)
+	if got, want := marks["service=foo:a"], start.Add(4*time.Minute).Unix(); got != want {
+		t.Errorf("unexpected mark: want %d, got %d", want, got)
+	}
+
+	// all the minutes are already forwarded.
+	fetched = nil
+	fctx = newContext(marks)
+	if err := fctx.getMetricsData(context.Background(), query); err != nil {
+		t.Fatal(err)
+	}
+	if len(fetched) != 0 {
+		t.Errorf("want no requests, got %v", fetched)
+	}
+	if len(fctx.serviceMetrics) != 0 {
+		t.Errorf("want no metrics, got %v", fctx.serviceMetrics)
+	}
+
+	// without marks, e.g. in the dry run, the whole window is fetched.
+	fetched = nil
+	fctx = newContext(nil)
+	if err := fctx.getMetricsData(context.Background(), query); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]time.Time{start}, fetched); diff != "" {
+		t.Errorf("fetched windows mismatch: (-want/+got):\n%s", diff)
+	}
+}
+
+func TestSeedHighWaterMarks(t *testing.T) {
+	var requests int
+	client := newTestMackerelClient(t, http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		requests++
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Write([]byte(`{"tsdbLatest":{"host-abc":{"rds.cpu":{"time":1234567860,"value":42},"rds.memory":{"time":1234567860,"value":1}}}}`))
+	}))
+	f := &Forwarder{
+		highWaterMarks: map[string]int64{
+			// the newer mark is kept.
+			"host=host-abc:rds.memory": 1234567920,
+		},
+	}
+	query := []*Query{
+		{Host: "host-abc", Name: "rds.cpu", Metric: []interface{}{"AWS/RDS", "CPUUtilization"}, Stat: "Average"},
+		{Host: "host-abc", Name: "rds.memory", Metric: []interface{}{"AWS/RDS", "FreeableMemory"}, Stat: "Average"},
+		{Service: "foo", Name: "rds.cpu", Metric: []interface{}{"AWS/RDS", "CPUUtilization"}, Stat: "Average"},
+	}
+	f.seedHighWaterMarks(context.Background(), client, query)
+
+	want := map[string]int64{
+		"host=host-abc:rds.cpu":    1234567860,
+		"host=host-abc:rds.memory": 1234567920,
+	}
+	if diff := cmp.Diff(want, f.highWaterMarks); diff != "" {
+		t.Errorf("high-water marks mismatch: (-want/+got):\n%s", diff)
+	}
+
+	// it looks up only at cold start.
+	f.seedHighWaterMarks(context.Background(), client, query)
+	if requests != 1 {
+		t.Errorf("want 1 request, got %d", requests)
+	}
+}
@@ -0,0 +1,113 @@
+package forwarder
+
+import (
+	"context"
+	"slices"
+	"time"
+)
+
+// defaultHostListTTL is the default of Forwarder.HostListTTL.
+const defaultHostListTTL = 10 * time.Minute
+
+func (f *Forwarder) validateHosts() bool {
+	if f.ValidateHosts {
+		return true
+	}
+	return f.env().ValidateHosts
+}
+
+// hostListTTL returns the duration for caching the host list.
+func (f *Forwarder) hostListTTL(ctx context.Context) time.Duration {
+	if f.HostListTTL > 0 {
+		return f.HostListTTL
+	}
+	if d := f.env().HostListTTL; d > 0 {
+		return d
+	}
+	return defaultHostListTTL
+}
+
+// knownHosts returns the set of the host ids that are not retired.
+// The host list of each organization is cached across the invocations until the TTL expires.
+func (f *Forwarder) knownHosts(ctx context.Context, client *MackerelClient, now time.Time) (map[string]bool, error) {
+	cache := f.mackerelCache(client)
+	cache.muHostList.Lock()
+	defer cache.muHostList.Unlock()
+	if cache.hostList != nil && now.Before(cache.hostListExpiresAt) {
+		return cache.hostList, nil
+	}
+
+	hosts, err := client.FindHosts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	list := make(map[string]bool, len(hosts))
+	for _, h := range hosts {
+		list[h.ID] = true
+	}
+	cache.hostList = list
+	cache.hostListExpiresAt = now.Add(f.hostListTTL(ctx))
+	return list, nil
+}
+
+// isKnownHost reports whether the host id is in the host list,
+// or it is resolved or registered by the forwarder after the host list is fetched.
+func (f *Forwarder) isKnownHost(client *MackerelClient, list map[string]bool, id string, now time.Time) bool {
+	if list[id] {
+		return true
+	}
+	cache := f.mackerelCache(client)
+	cache.muHosts.Lock()
+	defer cache.muHosts.Unlock()
+	for _, h := range cache.hostIDs {
+		if h.id == id && now.Before(h.expiresAt) {
+			return true
+		}
+	}
+	return false
+}
+
+// dropUnknownHostMetrics drops the host metrics of the retired or unknown hosts,
+// because Mackerel ignores or rejects them.
+// If the host list is not available, the metrics are posted as is.
+func (fctx *forwardContext) dropUnknownHostMetrics(ctx context.Context, now time.Time) {
+	if len(fctx.hostMetrics) == 0 {
+		return
+	}
+	f := fctx.forwarder
+	list, err := f.knownHosts(ctx, fctx.mackerel, now)
+	if err != nil {
+		f.logger().WarnContext(ctx, "failed to get the host list, skips validating the host ids", "error", err.Error())
+		return
+	}
+
+	known := make(map[string]bool)
+	unknown := make(map[string]int)
+	metrics := fctx.hostMetrics[:0]
+	for _, v := range fctx.hostMetrics {
+		ok, checked := known[v.HostID]
+		if !checked {
+			ok = f.isKnownHost(fctx.mackerel, list, v.HostID, now)
+			known[v.HostID] = ok
+		}
+		if ok {
+			metrics = append(metrics, v)
+		} else {
+			unknown[v.HostID]++
+		}
+	}
+	fctx.hostMetrics = metrics
+
+	ids := make([]string, 0, len(unknown))
+	for id := range unknown {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	for _, id := range ids {
+		fctx.report.addDropped("", unknown[id])
+		f.logger().WarnContext(ctx, "drop the host metrics of the unknown host",
+			"host_id", id,
+			"count", unknown[id],
+		)
+	}
+}
@@ -0,0 +1,115 @@
+package forwarder
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDropUnknownHostMetrics(t *testing.T) {
+	var requested int32
+	client := newTestMackerelClient(t, http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requested, 1)
+		rw.Write([]byte(`{"hosts":[{"id":"host-abc","name":"abc"}]}`))
+	}))
+
+	f := &Forwarder{
+		HostListTTL: time.Minute,
+	}
+	now := time.Unix(1234567890, 0)
+	f.mackerelCache(client).setHostID("arn:aws:rds:ap-northeast-1:123456789012:db:db", "host-registered", now.Add(time.Hour))
+	for i, at := range []time.Time{now, now.Add(30 * time.Second), now.Add(2 * time.Minute)} {
+		report := &InvocationReport{}
+		fctx := &forwardContext{
+			forwarder: f,
+			mackerel:  client,
+			hostMetrics: hostMetricsType{
+				{HostID: "host-abc", Name: "a", Time: 1234567860, Value: 1},
+				{HostID: "host-retired", Name: "a", Time: 1234567860, Value: 2},
+				{HostID: "host-registered", Name: "a", Time: 1234567860, Value: 3},
+				{HostID: "host-retired", Name: "b", Time: 1234567860, Value: 4},
+			},
+			report: report,
+		}
+		fctx.dropUnknownHostMetrics(context.Background(), at)
+
+		want := hostMetricsType{
+			{HostID: "host-abc", Name: "a", Time: 1234567860, Value: 1},
+			{HostID: "host-registered", Name: "a", Time: 1234567860, Value: 3},
+		}
+		if diff := cmp.Diff(want, fctx.hostMetrics); diff != "" {
+			t.Errorf("%d: host metrics mismatch: (-want/+got):\n%s", i, diff)
+		}
+		if report.Dropped != 2 {
+			t.Errorf("%d: unexpected dropped count: want %d, got %d", i, 2, report.Dropped)
+		}
+	}
+
+	// the host list is cached until the ttl expires.
+	if want, got := int32(2), atomic.LoadInt32(&requested); want != got {
+		t.Errorf("unexpected request count: want %d, got %d", want, got)
+	}
+}
+
+func TestDropUnknownHostMetrics_Organizations(t *testing.T) {
+	newClient := func(key, hostID string) *MackerelClient {
+		client := newTestMackerelClient(t, http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			rw.Write([]byte(`{"hosts":[{"id":"` + hostID + `","name":"abc"}]}`))
+		}))
+		client.APIKey = key
+		return client
+	}
+	clientFoo := newClient("api-key-foo", "host-foo")
+	clientBar := newClient("api-key-bar", "host-bar")
+
+	// the host lists are cached for each organization.
+	f := &Forwarder{HostListTTL: time.Minute}
+	now := time.Unix(1234567890, 0)
+	for _, client := range []*MackerelClient{clientFoo, clientBar, clientFoo} {
+		fctx := &forwardContext{
+			forwarder: f,
+			mackerel:  client,
+			hostMetrics: hostMetricsType{
+				{HostID: "host-foo", Name: "a", Time: 1234567860, Value: 1},
+				{HostID: "host-bar", Name: "a", Time: 1234567860, Value: 2},
+			},
+			report: &InvocationReport{},
+		}
+		fctx.dropUnknownHostMetrics(context.Background(), now)
+
+		want := hostMetricsType{
+			{HostID: "host-foo", Name: "a", Time: 1234567860, Value: 1},
+		}
+		if client == clientBar {
+			want = hostMetricsType{
+				{HostID: "host-bar", Name: "a", Time: 1234567860, Value: 2},
+			}
+		}
+		if diff := cmp.Diff(want, fctx.hostMetrics); diff != "" {
+			t.Errorf("%s: host metrics mismatch: (-want/+got):\n%s", client.APIKey, diff)
+		}
+	}
+}
+
+func TestDropUnknownHostMetrics_Unavailable(t *testing.T) {
+	client := newTestMackerelClient(t, http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusForbidden)
+	}))
+
+	fctx := &forwardContext{
+		forwarder: &Forwarder{},
+		mackerel:  client,
+		hostMetrics: hostMetricsType{
+			{HostID: "host-abc", Name: "a", Time: 1234567860, Value: 1},
+		},
+	}
+	fctx.dropUnknownHostMetrics(context.Background(), time.Unix(1234567890, 0))
+
+	if len(fctx.hostMetrics) != 1 {
+		t.Errorf("the metrics should be posted as is: %v", fctx.hostMetrics)
+	}
+}
@@ -0,0 +1,86 @@
+package forwarder
+
+import (
+	"context"
+	"time"
+)
+
+// hostMetadataNamespace is the namespace of the host metadata for AWS tags.
+const hostMetadataNamespace = "aws-tags"
+
+// hostMetadataSyncInterval is the interval for syncing the host metadata.
+const hostMetadataSyncInterval = time.Hour
+
+// maxResourceARNs is the maximum number of ARNs in a GetResources request.
+const maxResourceARNs = 100
+
+func (f *Forwarder) syncHostMetadata() bool {
+	if f.SyncHostMetadata {
+		return true
+	}
+	return f.env().SyncHostMetadata
+}
+
+// syncHostMetadataFromTags pushes the tags of the AWS resources as the host metadata.
+// The metadata of each host is updated at most once per hostMetadataSyncInterval.
+func (f *Forwarder) syncHostMetadataFromTags(ctx context.Context, client *MackerelClient, query []*Query, now time.Time) {
+	f.muMetadata.Lock()
+	defer f.muMetadata.Unlock()
+
+	// collect the resources to be synced.
+	var lastHost string
+	hosts := map[string][]string{} // arn -> host ids
+	var arns []string
+	for _, q := range query {
+		host := q.Host
+		setDefault(&host, &lastHost)
+		if host == "" || q.ResourceARN == "" {
+			continue
+		}
+		if t, ok := f.metadataSynced[host]; ok && now.Sub(t) < hostMetadataSyncInterval {
+			continue
+		}
+		if _, ok := hosts[q.ResourceARN]; !ok {
+			arns = append(arns, q.ResourceARN)
+		}
+		hosts[q.ResourceARN] = appendUnique(hosts[q.ResourceARN], host)
+	}
+	if len(arns) == 0 {
+		return
+	}
+
+	resources, err := f.getResourceTags(ctx, arns)
+	if err != nil {
+		f.logger().WarnContext(ctx, "failed to get the tags of the resources",
+			"error", err.Error(),
+		)
+	}
+	for _, arn := range arns {
+		tags, ok := resources[arn]
+		if !ok {
+			continue
+		}
+		for _, host := range hosts[arn] {
+			if err := client.PutHostMetadata(ctx, host, hostMetadataNamespace, tags); err != nil {
+				f.logger().WarnContext(ctx, "failed to put the host metadata",
+					"error", err.Error(),
+					"hostId", host,
+				)
+				continue
+			}
+			if f.metadataSynced == nil {
+				f.metadataSynced = make(map[string]time.Time)
+			}
+			f.metadataSynced[host] = now
+		}
+	}
+}
+
+func appendUnique(s []string, v string) []string {
+	for _, w := range s {
+		if w == v {
+			return s
+		}
+	}
+	return append(s, v)
+}
@@ -0,0 +1,100 @@
+package forwarder
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/resourcegroupstaggingapi"
+	"github.com/aws/aws-sdk-go-v2/service/resourcegroupstaggingapi/types"
+	"github.com/google/go-cmp/cmp"
+)
+
+type fakeTagging struct {
+	tags map[string]map[string]string
+}
+
+func (s *fakeTagging) GetResources(ctx context.Context, params *resourcegroupstaggingapi.GetResourcesInput, optFns ...func(*resourcegroupstaggingapi.Options)) (*resourcegroupstaggingapi.GetResourcesOutput, error) {
+	var list []types.ResourceTagMapping
+	for _, arn := range params.ResourceARNList {
+		tags, ok := s.tags[arn]
+		if !ok {
+			continue
+		}
+		var ts []types.Tag
+		for k, v := range tags {
+			ts = append(ts, types.Tag{Key: aws.String(k), Value: aws.String(v)})
+		}
+		list = append(list, types.ResourceTagMapping{
+			ResourceARN: aws.String(arn),
+			Tags:        ts,
+		})
+	}
+	return &resourcegroupstaggingapi.GetResourcesOutput{
+		ResourceTagMappingList: list,
+	}, nil
+}
+
+func TestSyncHostMetadataFromTags(t *testing.T) {
+	var count int32
+	client := newTestMackerelClient(t, http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&count, 1)
+		if r.Method != http.MethodPut {
+			t.Errorf("unexpected method: want %s, got %s", http.MethodPut, r.Method)
+		}
+		if want, got := "/api/v0/hosts/host-abc/metadata/aws-tags", r.URL.Path; want != got {
+			t.Errorf("unexpected path: want %q, got %q", want, got)
+		}
+		var body interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		want := map[string]interface{}{
+			"team": "payments",
+		}
+		if diff := cmp.Diff(want, body); diff != "" {
+			t.Errorf("body mismatch: (-want/+got):\n%s", diff)
+		}
+		rw.Write([]byte(`{"success":true}`))
+	}))
+
+	arn := "arn:aws:rds:ap-northeast-1:123456789012:db:db"
+	f := &Forwarder{
+		svctagging: &fakeTagging{
+			tags: map[string]map[string]string{
+				arn: {"team": "payments"},
+			},
+		},
+	}
+	query := []*Query{
+		{
+			Host:        "host-abc",
+			Name:        "rds.cpu",
+			Metric:      []interface{}{"AWS/RDS", "CPUUtilization", "DBInstanceIdentifier", "db"},
+			ResourceARN: arn,
+		},
+		{
+			Host:        ".",
+			Name:        "rds.connections",
+			Metric:      []interface{}{".", "DatabaseConnections", ".", "."},
+			ResourceARN: arn,
+		},
+	}
+
+	now := time.Unix(1234567890, 0)
+	f.syncHostMetadataFromTags(context.Background(), client, query, now)
+	f.syncHostMetadataFromTags(context.Background(), client, query, now.Add(time.Minute))
+	if want, got := int32(1), atomic.LoadInt32(&count); want != got {
+		t.Errorf("unexpected api call count: want %d, got %d", want, got)
+	}
+
+	f.syncHostMetadataFromTags(context.Background(), client, query, now.Add(hostMetadataSyncInterval))
+	if want, got := int32(2), atomic.LoadInt32(&count); want != got {
+		t.Errorf("unexpected api call count: want %d, got %d", want, got)
+	}
+}
@@ -0,0 +1,69 @@
+package forwarder
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"time"
+)
+
+// newHTTPClient returns a new HTTP client that is tuned for reusing the connections in warm AWS Lambda.
+func newHTTPClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ForceAttemptHTTP2 = true
+	transport.MaxIdleConns = 100
+	transport.MaxIdleConnsPerHost = 16
+	transport.IdleConnTimeout = 90 * time.Second
+	transport.TLSHandshakeTimeout = 10 * time.Second
+	transport.ResponseHeaderTimeout = 30 * time.Second
+	transport.ExpectContinueTimeout = time.Second
+	return &http.Client{
+		Transport: transport,
+	}
+}
+
+// proxyDirect is the value of the proxy settings that disables the proxy.
+const proxyDirect = "direct"
+
+// parseProxy parses the proxy setting, the URL of the proxy or "direct".
+// It returns nil for "direct", which means connecting directly.
+func parseProxy(s string) (func(*http.Request) (*url.URL, error), error) {
+	if s == proxyDirect {
+		return nil, nil
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("forwarder: failed to parse the proxy url: %w", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5") || u.Host == "" {
+		return nil, fmt.Errorf("forwarder: invalid proxy url: %q", u.Redacted())
+	}
+	return http.ProxyURL(u), nil
+}
+
+// withConnectionTrace adds the trace that logs the connection reuse in the debug level.
+func withConnectionTrace(ctx context.Context, logger Logger, method, url string) context.Context {
+	if !loggerEnabled(ctx, logger, slog.LevelDebug) {
+		return ctx
+	}
+	var start time.Time
+	trace := &httptrace.ClientTrace{
+		GetConn: func(hostPort string) {
+			start = time.Now()
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			logger.DebugContext(ctx, "got connection",
+				"method", method,
+				"url", url,
+				"reused", info.Reused,
+				"was_idle", info.WasIdle,
+				"idle_time", info.IdleTime.String(),
+				"duration", time.Since(start).String(),
+			)
+		},
+	}
+	return httptrace.WithClientTrace(ctx, trace)
+}
@@ -0,0 +1,92 @@
+package forwarder
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMackerelClient_ConnectionReuse(t *testing.T) {
+	client := newTestMackerelClient(t, http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	}))
+
+	var buf bytes.Buffer
+	client.Logger = slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+	}))
+
+	for i := 0; i < 2; i++ {
+		err := client.PostHostMetricValues(context.Background(), []HostMetricValue{
+			{HostID: "host-abc", Name: "custom.foo", Time: 1234567890, Value: 1},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var reused []bool
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var record struct {
+			Msg    string `json:"msg"`
+			Reused bool   `json:"reused"`
+		}
+		if err := dec.Decode(&record); err != nil {
+			t.Fatal(err)
+		}
+		if record.Msg == "got connection" {
+			reused = append(reused, record.Reused)
+		}
+	}
+	if len(reused) != 2 || reused[0] || !reused[1] {
+		t.Errorf("the connection is not reused: %v", reused)
+	}
+}
+
+func TestForwarder_MackerelProxy(t *testing.T) {
+	var requested []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		// the proxy receives the absolute url of the mackerel api.
+		requested = append(requested, r.URL.String())
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Write([]byte(`{"success":true}`))
+	}))
+	defer proxy.Close()
+
+	f := &Forwarder{
+		APIURL:                 "http://mackerel.invalid/",
+		APIKey:                 "dummy",
+		MackerelProxy:          proxy.URL,
+		SkipAPIKeyVerification: true,
+	}
+	client, err := f.mackerel(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.PostHostMetricValues(context.Background(), []HostMetricValue{
+		{HostID: "host-abc", Name: "custom.foo", Time: 1234567890, Value: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(requested) != 1 || requested[0] != "http://mackerel.invalid/api/v0/tsdb" {
+		t.Errorf("the request is not sent via the proxy: %v", requested)
+	}
+}
+
+func TestParseProxy(t *testing.T) {
+	if p, err := parseProxy("direct"); err != nil || p != nil {
+		t.Errorf("want no proxy, got %t, %v", p != nil, err)
+	}
+	if _, err := parseProxy("http://proxy.example.com:3128"); err != nil {
+		t.Error(err)
+	}
+	if _, err := parseProxy("proxy.example.com:3128"); err == nil {
+		t.Error("want an error, got nil")
+	}
+}
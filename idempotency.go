@@ -0,0 +1,227 @@
+package forwarder
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// IdempotencyStore records the time windows that are forwarded,
+// so that the retries of an invocation (e.g. the retries of the asynchronous invocation of AWS Lambda)
+// don't post the same window twice.
+type IdempotencyStore interface {
+	// Begin records the start of forwarding the window identified by the key.
+	// It reports false if the window is already forwarded, or another invocation is forwarding it.
+	// The record of an invocation in progress expires after the lease, so that it can be retried after a crash.
+	Begin(ctx context.Context, key string, lease time.Duration) (bool, error)
+
+	// Complete records that the window is successfully forwarded.
+	Complete(ctx context.Context, key string) error
+
+	// Abort removes the record of the window, so that it can be retried.
+	Abort(ctx context.Context, key string) error
+}
+
+// the statuses of the records in DynamoDBIdempotencyStore.
+const (
+	idempotencyInProgress = "IN_PROGRESS"
+	idempotencyCompleted  = "COMPLETED"
+)
+
+// defaultIdempotencyTTL is the default of DynamoDBIdempotencyStore.TTL.
+// It is the maximum age of the events of the asynchronous invocation of AWS Lambda.
+const defaultIdempotencyTTL = 6 * time.Hour
+
+// DynamoDBIdempotencyStore is an IdempotencyStore backed by a table of Amazon DynamoDB.
+// The table must have the partition key "id" of the string type.
+// Enable the time to live of the table on the "expiresAt" attribute to remove the old records.
+type DynamoDBIdempotencyStore struct {
+	Table string
+
+	// TTL is the duration for keeping the records of the completed windows.
+	// The default is 6 hours.
+	TTL time.Duration
+
+	svc dynamodbiface
+}
+
+var _ IdempotencyStore = (*DynamoDBIdempotencyStore)(nil)
+
+// NewDynamoDBIdempotencyStore creates a new DynamoDBIdempotencyStore.
+func NewDynamoDBIdempotencyStore(cfg aws.Config, table string) *DynamoDBIdempotencyStore {
+	return &DynamoDBIdempotencyStore{
+		Table: table,
+		svc:   dynamodb.NewFromConfig(cfg),
+	}
+}
+
+func (s *DynamoDBIdempotencyStore) ttl() time.Duration {
+	if s.TTL > 0 {
+		return s.TTL
+	}
+	return defaultIdempotencyTTL
+}
+
+// Begin implements IdempotencyStore.
+func (s *DynamoDBIdempotencyStore) Begin(ctx context.Context, key string, lease time.Duration) (bool, error) {
+	now := time.Now()
+	_, err := s.svc.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.Table),
+		Item: map[string]types.AttributeValue{
+			"id":        &types.AttributeValueMemberS{Value: key},
+			"status":    &types.AttributeValueMemberS{Value: idempotencyInProgress},
+			"expiresAt": &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Add(lease).Unix(), 10)},
+		},
+		// the record in progress expires after the lease, and the completed one expires after TTL.
+		ConditionExpression: aws.String("attribute_not_exists(#id) OR #expiresAt < :now"),
+		ExpressionAttributeNames: map[string]string{
+			"#id":        "id",
+			"#expiresAt": "expiresAt",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":now": &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Unix(), 10)},
+		},
+	})
+	if err != nil {
+		var cerr *types.ConditionalCheckFailedException
+		if errors.As(err, &cerr) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// Complete implements IdempotencyStore.
+func (s *DynamoDBIdempotencyStore) Complete(ctx context.Context, key string) error {
+	_, err := s.svc.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.Table),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: key},
+		},
+		UpdateExpression: aws.String("SET #status = :completed, #expiresAt = :expiresAt"),
+		ExpressionAttributeNames: map[string]string{
+			"#status":    "status",
+			"#expiresAt": "expiresAt",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":completed": &types.AttributeValueMemberS{Value: idempotencyCompleted},
+			":expiresAt": &types.AttributeValueMemberN{Value: strconv.FormatInt(time.Now().Add(s.ttl()).Unix(), 10)},
+		},
+	})
+	return err
+}
+
+// Abort implements IdempotencyStore.
+func (s *DynamoDBIdempotencyStore) Abort(ctx context.Context, key string) error {
+	_, err := s.svc.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(s.Table),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: key},
+		},
+		ConditionExpression: aws.String("#status = :inProgress"),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "status",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":inProgress": &types.AttributeValueMemberS{Value: idempotencyInProgress},
+		},
+	})
+	var cerr *types.ConditionalCheckFailedException
+	if errors.As(err, &cerr) {
+		// the window is already completed, or the record is taken over by another invocation.
+		return nil
+	}
+	return err
+}
+
+func (f *Forwarder) idempotencyStore() IdempotencyStore {
+	if f.IdempotencyStore != nil {
+		return f.IdempotencyStore
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.svcidempotency != nil {
+		return f.svcidempotency
+	}
+	table := f.env().IdempotencyTable
+	if table == "" {
+		return nil
+	}
+	f.svcidempotency = &DynamoDBIdempotencyStore{
+		Table: table,
+		svc: dynamodb.NewFromConfig(f.awsConfig(), func(o *dynamodb.Options) {
+			f.resolveEndpoint("dynamodb", &o.BaseEndpoint, &o.EndpointOptions.UseFIPSEndpoint, &o.EndpointOptions.UseDualStackEndpoint)
+		}),
+	}
+	return f.svcidempotency
+}
+
+// idempotencyKey returns the key of the invocation for the queries in data.
+// The retries of an invocation must have the same key, so it is identified by
+// the id of the event (e.g. the events of Amazon EventBridge), the request id of AWS Lambda,
+// or the time window of the time in the event, in this order.
+// It reports false if the invocation can't be identified,
+// because the time window of the current time shifts on the retries.
+func idempotencyKey(ctx context.Context, event, data []byte, start, end time.Time) (string, bool) {
+	sum := sha256.Sum256(data)
+	prefix := hex.EncodeToString(sum[:])
+	if id, ok := eventID(event); ok {
+		return prefix + ":event:" + id, true
+	}
+	if lc, ok := lambdacontext.FromContext(ctx); ok && lc.AwsRequestID != "" {
+		return prefix + ":request:" + lc.AwsRequestID, true
+	}
+	if _, ok := scheduledTime(event); ok {
+		return fmt.Sprintf("%s:%d:%d", prefix, start.Unix(), end.Unix()), true
+	}
+	return "", false
+}
+
+// eventID returns the "id" field of the event in the input.
+func eventID(data []byte) (string, bool) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || data[0] != '{' {
+		return "", false
+	}
+	var v struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil || v.ID == "" {
+		return "", false
+	}
+	return v.ID, true
+}
+
+// idempotencyLease returns the lease of the record for the invocation.
+func idempotencyLease(ctx context.Context) time.Duration {
+	if deadline, ok := ctx.Deadline(); ok {
+		return max(time.Until(deadline), time.Second)
+	}
+	return 15 * time.Minute
+}
+
+// endIdempotency records the result of forwarding the window.
+// If err is not nil, the record is removed so that the retry of the invocation forwards the window again.
+func (f *Forwarder) endIdempotency(ctx context.Context, store IdempotencyStore, key string, err error) {
+	if err != nil {
+		if err := store.Abort(ctx, key); err != nil {
+			f.logger().WarnContext(ctx, "failed to abort the idempotency record", "key", key, "error", err.Error())
+		}
+		return
+	}
+	if err := store.Complete(ctx, key); err != nil {
+		f.logger().WarnContext(ctx, "failed to complete the idempotency record", "key", key, "error", err.Error())
+	}
+}
@@ -0,0 +1,240 @@
+package forwarder
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/google/go-cmp/cmp"
+)
+
+// fakeDynamoDB emulates the conditional writes of DynamoDBIdempotencyStore.
+type fakeDynamoDB struct {
+	items map[string]map[string]types.AttributeValue
+}
+
+func (s *fakeDynamoDB) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	id := params.Item["id"].(*types.AttributeValueMemberS).Value
+	if item, ok := s.items[id]; ok {
+		now := params.ExpressionAttributeValues[":now"].(*types.AttributeValueMemberN).Value
+		expiresAt := item["expiresAt"].(*types.AttributeValueMemberN).Value
+		if len(expiresAt) > len(now) || (len(expiresAt) == len(now) && expiresAt >= now) {
+			return nil, &types.ConditionalCheckFailedException{}
+		}
+	}
+	if s.items == nil {
+		s.items = make(map[string]map[string]types.AttributeValue)
+	}
+	s.items[id] = params.Item
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (s *fakeDynamoDB) UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	id := params.Key["id"].(*types.AttributeValueMemberS).Value
+	item := s.items[id]
+	item["status"] = params.ExpressionAttributeValues[":completed"]
+	item["expiresAt"] = params.ExpressionAttributeValues[":expiresAt"]
+	return &dynamodb.UpdateItemOutput{}, nil
+}
+
+func (s *fakeDynamoDB) DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error) {
+	id := params.Key["id"].(*types.AttributeValueMemberS).Value
+	if item, ok := s.items[id]; !ok || item["status"].(*types.AttributeValueMemberS).Value != idempotencyInProgress {
+		return nil, &types.ConditionalCheckFailedException{}
+	}
+	delete(s.items, id)
+	return &dynamodb.DeleteItemOutput{}, nil
+}
+
+func TestDynamoDBIdempotencyStore(t *testing.T) {
+	ctx := context.Background()
+	store := &DynamoDBIdempotencyStore{
+		Table: "forwarder",
+		svc:   &fakeDynamoDB{},
+	}
+
+	ok, err := store.Begin(ctx, "key", time.Minute)
+	if err != nil || !ok {
+		t.Fatalf("want true, got %t, %v", ok, err)
+	}
+
+	// another invocation is forwarding the window.
+	ok, err = store.Begin(ctx, "key", time.Minute)
+	if err != nil || ok {
+		t.Fatalf("want false, got %t, %v", ok, err)
+	}
+
+	// the aborted window can be retried.
+	if err := store.Abort(ctx, "key"); err != nil {
+		t.Fatal(err)
+	}
+	ok, err = store.Begin(ctx, "key", time.Minute)
+	if err != nil || !ok {
+		t.Fatalf("want true, got %t, %v", ok, err)
+	}
+
+	// the completed window is skipped, and it can't be aborted.
+	if err := store.Complete(ctx, "key"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Abort(ctx, "key"); err != nil {
+		t.Fatal(err)
+	}
+	ok, err = store.Begin(ctx, "key", time.Minute)
+	if err != nil || ok {
+		t.Fatalf("want false, got %t, %v", ok, err)
+	}
+}
+
+type fakeIdempotencyStore struct {
+	forwarded bool
+	calls     []string
+}
+
+func (s *fakeIdempotencyStore) Begin(ctx context.Context, key string, lease time.Duration) (bool, error) {
+	s.calls = append(s.calls, "begin")
+	return !s.forwarded, nil
+}
+
+func (s *fakeIdempotencyStore) Complete(ctx context.Context, key string) error {
+	s.calls = append(s.calls, "complete")
+	s.forwarded = true
+	return nil
+}
+
+func (s *fakeIdempotencyStore) Abort(ctx context.Context, key string) error {
+	s.calls = append(s.calls, "abort")
+	return nil
+}
+
+func TestForwardMetrics_Idempotency(t *testing.T) {
+	var posted int
+	client := newTestMackerelClient(t, http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		posted++
+		rw.WriteHeader(http.StatusOK)
+	}))
+	store := &fakeIdempotencyStore{}
+	f := &Forwarder{
+		svcmackerel: client,
+		svccloudwatch: &fakeCloudWatch{
+			values: map[string][]float64{
+				"m1": {1},
+			},
+		},
+		IdempotencyStore: store,
+	}
+	input := []byte(`[{"service":"foo","name":"ec2.cpu","metric":["AWS/EC2","CPUUtilization","InstanceId","i-1"],"stat":"Average"}]`)
+
+	// the retries of the asynchronous invocation have the same request id.
+	ctx := lambdacontext.NewContext(context.Background(), &lambdacontext.LambdaContext{AwsRequestID: "request-1"})
+	report, err := f.ForwardMetrics(ctx, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Skipped || posted != 1 {
+		t.Errorf("the first invocation must be forwarded: skipped %t, posted %d", report.Skipped, posted)
+	}
+
+	// the retry is skipped.
+	report, err = f.ForwardMetrics(ctx, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Skipped || posted != 1 {
+		t.Errorf("the retry must be skipped: skipped %t, posted %d", report.Skipped, posted)
+	}
+
+	// the failed invocation is aborted, so that it can be retried.
+	store.forwarded = false
+	f.Strict = true
+	if _, err := f.ForwardMetrics(ctx, []byte(`[{"service":"foo","name":"ec2.cpu","metric":["AWS/EC2"]}]`)); err == nil {
+		t.Fatal("want error, got nil")
+	}
+
+	want := []string{"begin", "complete", "begin", "begin", "abort"}
+	if diff := cmp.Diff(want, store.calls); diff != "" {
+		t.Errorf("calls mismatch: (-want/+got):\n%s", diff)
+	}
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	data := []byte(`[{"service":"foo","name":"ec2.cpu","metric":["AWS/EC2","CPUUtilization"],"stat":"Average"}]`)
+	sum := sha256.Sum256(data)
+	prefix := hex.EncodeToString(sum[:])
+	start := time.Unix(1234567800, 0)
+	end := time.Unix(1234567860, 0)
+	withRequestID := lambdacontext.NewContext(context.Background(), &lambdacontext.LambdaContext{AwsRequestID: "request-1"})
+
+	tests := []struct {
+		name  string
+		ctx   context.Context
+		event string
+		want  string
+		ok    bool
+	}{
+		{
+			name:  "event id",
+			ctx:   withRequestID,
+			event: `{"id":"event-1","detail-type":"Scheduled Event","time":"2009-02-13T23:31:00Z"}`,
+			want:  prefix + ":event:event-1",
+			ok:    true,
+		},
+		{
+			name:  "request id",
+			ctx:   withRequestID,
+			event: string(data),
+			want:  prefix + ":request:request-1",
+			ok:    true,
+		},
+		{
+			name:  "time",
+			ctx:   context.Background(),
+			event: `{"time":"2009-02-13T23:31:00Z","queries":[]}`,
+			want:  prefix + ":1234567800:1234567860",
+			ok:    true,
+		},
+		{
+			name:  "unidentified",
+			ctx:   context.Background(),
+			event: string(data),
+			ok:    false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := idempotencyKey(tt.ctx, []byte(tt.event), data, start, end)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("idempotencyKey() = (%q, %t), want (%q, %t)", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestDynamoDBIdempotencyStore_Error(t *testing.T) {
+	store := &DynamoDBIdempotencyStore{
+		Table: "forwarder",
+		svc:   &errorDynamoDB{},
+	}
+	if _, err := store.Begin(context.Background(), "key", time.Minute); err == nil {
+		t.Error("want error, got nil")
+	}
+}
+
+type errorDynamoDB struct {
+	fakeDynamoDB
+}
+
+func (s *errorDynamoDB) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	if aws.ToString(params.TableName) == "" {
+		return nil, errors.New("table name is required")
+	}
+	return nil, errors.New("access denied")
+}
@@ -0,0 +1,140 @@
+package forwarder
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// importChunkSize is the number of the data points that Import posts at a time.
+const importChunkSize = 1000
+
+// DecodeDatapoints reads the data points in the format, ExportFormatJSON or ExportFormatCSV.
+// It accepts the output of EncodeDatapoints.
+func DecodeDatapoints(r io.Reader, format string) ([]Datapoint, error) {
+	switch format {
+	case "", ExportFormatJSON:
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		return parseDatapoints(data)
+	case ExportFormatCSV:
+		return decodeDatapointsCSV(r)
+	}
+	return nil, fmt.Errorf("forwarder: unknown import format: %q", format)
+}
+
+func decodeDatapointsCSV(r io.Reader) ([]Datapoint, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err != nil {
+		return nil, err
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[name] = i
+	}
+	for _, name := range []string{"name", "time", "value"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("forwarder: the column %q is missing", name)
+		}
+	}
+	field := func(record []string, name string) string {
+		if i, ok := columns[name]; ok {
+			return record[i]
+		}
+		return ""
+	}
+
+	var ret []Datapoint
+	for {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		t, err := strconv.ParseInt(field(record, "time"), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("forwarder: invalid time: %w", err)
+		}
+		v, err := strconv.ParseFloat(field(record, "value"), 64)
+		if err != nil {
+			return nil, fmt.Errorf("forwarder: invalid value: %w", err)
+		}
+		ret = append(ret, Datapoint{
+			HostID:  field(record, "host_id"),
+			Service: field(record, "service"),
+			Name:    field(record, "name"),
+			Time:    t,
+			Value:   v,
+		})
+	}
+	return ret, nil
+}
+
+// Import posts the data points to Mackerel, e.g. for migrations and manual backfills.
+// The data points are posted in chunks, with the same retries as ForwardMetrics.
+// The invalid data points are skipped with warnings.
+// Note that the data points older than the max metric age are dropped, see Forwarder.MaxMetricAge.
+func (f *Forwarder) Import(ctx context.Context, points []Datapoint) error {
+	var client *MackerelClient
+	if !f.dryRun(ctx) {
+		var err error
+		client, err = f.mackerel(ctx)
+		if err != nil {
+			return fmt.Errorf("forwarder: failed to configure the mackerel client: %w", err)
+		}
+	}
+
+	var errs []error
+	for len(points) > 0 {
+		n := min(len(points), importChunkSize)
+		chunk := points[:n]
+		points = points[n:]
+
+		fctx := &forwardContext{
+			forwarder: f,
+			mackerel:  client,
+		}
+		for _, p := range chunk {
+			if (p.HostID == "") == (p.Service == "") {
+				f.logger().WarnContext(ctx, "either service name or host id is required but not both, skips",
+					"service", p.Service,
+					"host", p.HostID,
+					"name", p.Name,
+				)
+				continue
+			}
+			if p.Service != "" {
+				fctx.serviceMetrics.Append(p.Service, ServiceMetricValue{
+					Name:  p.Name,
+					Time:  p.Time,
+					Value: p.Value,
+				})
+			} else {
+				fctx.hostMetrics.Append(HostMetricValue{
+					HostID: p.HostID,
+					Name:   p.Name,
+					Time:   p.Time,
+					Value:  p.Value,
+				})
+			}
+		}
+
+		if f.dryRun(ctx) {
+			fctx.logMetrics(ctx)
+			continue
+		}
+		fctx.publishers = f.publishers(ctx)
+		if err := fctx.publishMetric(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,116 @@
+package forwarder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDecodeDatapoints_CSV(t *testing.T) {
+	data := "host_id,service,name,time,value\n" +
+		"host-abc,,rds.cpu,1234567860,3\n" +
+		",foo,ec2.cpu,1234567860,1.5\n"
+	got, err := DecodeDatapoints(strings.NewReader(data), ExportFormatCSV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Datapoint{
+		{HostID: "host-abc", Name: "rds.cpu", Time: 1234567860, Value: 3},
+		{Service: "foo", Name: "ec2.cpu", Time: 1234567860, Value: 1.5},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("datapoints mismatch: (-want/+got):\n%s", diff)
+	}
+
+	if _, err := DecodeDatapoints(strings.NewReader("service,name,value\nfoo,ec2.cpu,1\n"), ExportFormatCSV); err == nil {
+		t.Error("want an error for the missing column, got nil")
+	}
+	if _, err := DecodeDatapoints(strings.NewReader(",foo,ec2.cpu,now,1\n"), "xml"); err == nil {
+		t.Error("want an error for the unknown format, got nil")
+	}
+}
+
+func TestImport(t *testing.T) {
+	var mu sync.Mutex
+	var requests int
+	var serviceMetrics []ServiceMetricValue
+	var hostMetrics []HostMetricValue
+	client := newTestMackerelClient(t, http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		data, _ := io.ReadAll(r.Body)
+		switch r.URL.Path {
+		case "/api/v0/services/foo/tsdb":
+			var values []ServiceMetricValue
+			if err := json.Unmarshal(data, &values); err != nil {
+				t.Error(err)
+			}
+			requests++
+			serviceMetrics = append(serviceMetrics, values...)
+		case "/api/v0/tsdb":
+			var values []HostMetricValue
+			if err := json.Unmarshal(data, &values); err != nil {
+				t.Error(err)
+			}
+			hostMetrics = append(hostMetrics, values...)
+		}
+		rw.WriteHeader(http.StatusOK)
+	}))
+	f := &Forwarder{
+		svcmackerel: client,
+	}
+
+	now := time.Now().Truncate(time.Minute).Unix()
+	var points []Datapoint
+	for i := range importChunkSize + 1 {
+		points = append(points, Datapoint{Service: "foo", Name: fmt.Sprintf("custom.%d", i), Time: now, Value: float64(i)})
+	}
+	points = append(points,
+		Datapoint{HostID: "host-abc", Name: "custom.b", Time: now, Value: 2},
+		Datapoint{Name: "invalid", Time: now, Value: 3},
+	)
+	if err := f.Import(context.Background(), points); err != nil {
+		t.Fatal(err)
+	}
+
+	if requests != 2 {
+		t.Errorf("the service metrics are not chunked: want 2 requests, got %d", requests)
+	}
+	if len(serviceMetrics) != importChunkSize+1 {
+		t.Errorf("want %d service metrics, got %d", importChunkSize+1, len(serviceMetrics))
+	}
+	wantHostMetrics := []HostMetricValue{
+		{HostID: "host-abc", Name: "custom.b", Time: now, Value: 2},
+	}
+	if diff := cmp.Diff(wantHostMetrics, hostMetrics); diff != "" {
+		t.Errorf("host metrics mismatch: (-want/+got):\n%s", diff)
+	}
+}
+
+func TestImport_Failure(t *testing.T) {
+	client := newTestMackerelClient(t, http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v0/services/foo/tsdb" {
+			rw.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	f := &Forwarder{
+		svcmackerel: client,
+	}
+	now := time.Now().Truncate(time.Minute).Unix()
+	err := f.Import(context.Background(), []Datapoint{
+		{Service: "foo", Name: "custom.a", Time: now, Value: 1},
+	})
+	var perr *PublishError
+	if !errors.As(err, &perr) || perr.Service != "foo" {
+		t.Errorf("want PublishError of foo, got %v", err)
+	}
+}
@@ -0,0 +1,231 @@
+package forwarder
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// Datapoint is a data point of the generic metric ingestion.
+// Either HostID or Service is required but not both.
+type Datapoint struct {
+	HostID  string  `json:"hostId,omitempty"`
+	Service string  `json:"service,omitempty"`
+	Name    string  `json:"name"`
+	Time    int64   `json:"time"`
+	Value   float64 `json:"value"`
+}
+
+// parseDatapoints parses the data of a record.
+// The data is a JSON object of Datapoint, a JSON array of them, or the concatenation of them (e.g. JSON Lines).
+func parseDatapoints(data []byte) ([]Datapoint, error) {
+	var ret []Datapoint
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, err
+		}
+		raw = bytes.TrimLeft(raw, " \t\r\n")
+		if len(raw) > 0 && raw[0] == '[' {
+			var points []Datapoint
+			if err := json.Unmarshal(raw, &points); err != nil {
+				return nil, err
+			}
+			ret = append(ret, points...)
+		} else {
+			var point Datapoint
+			if err := json.Unmarshal(raw, &point); err != nil {
+				return nil, err
+			}
+			ret = append(ret, point)
+		}
+	}
+	return ret, nil
+}
+
+// ingestRecord is a record of the generic metric ingestion.
+type ingestRecord struct {
+	ID   string
+	Data []byte
+}
+
+// ingest posts the data points in the records to Mackerel.
+// It returns the ids of the records that have invalid data, and the ids of the records that failed to post.
+func (f *Forwarder) ingest(ctx context.Context, records []ingestRecord) (invalid, failed []string) {
+	var serviceMetrics serviceMetricsType
+	var hostMetrics hostMetricsType
+	serviceRecords := make(map[string][]string)
+	var hostRecords []string
+	for _, r := range records {
+		points, err := parseDatapoints(r.Data)
+		if err != nil {
+			f.logger().WarnContext(ctx, "invalid record, skips",
+				"record", r.ID,
+				"error", err.Error(),
+			)
+			invalid = append(invalid, r.ID)
+			continue
+		}
+
+		var hasService, hasHost bool
+		seen := make(map[string]bool)
+		for _, p := range points {
+			if (p.HostID == "") == (p.Service == "") {
+				f.logger().WarnContext(ctx, "either service name or host id is required but not both, skips",
+					"record", r.ID,
+					"service", p.Service,
+					"host", p.HostID,
+				)
+				continue
+			}
+			if p.Service != "" {
+				serviceMetrics.Append(p.Service, ServiceMetricValue{
+					Name:  p.Name,
+					Time:  p.Time,
+					Value: p.Value,
+				})
+				if !seen[p.Service] {
+					seen[p.Service] = true
+					serviceRecords[p.Service] = append(serviceRecords[p.Service], r.ID)
+				}
+				hasService = true
+			} else {
+				hostMetrics.Append(HostMetricValue{
+					HostID: p.HostID,
+					Name:   p.Name,
+					Time:   p.Time,
+					Value:  p.Value,
+				})
+				hasHost = true
+			}
+		}
+		if hasHost {
+			hostRecords = append(hostRecords, r.ID)
+		}
+		if !hasService && !hasHost {
+			invalid = append(invalid, r.ID)
+		}
+	}
+
+	fctx := &forwardContext{
+		forwarder:      f,
+		serviceMetrics: serviceMetrics,
+		hostMetrics:    hostMetrics,
+	}
+	if f.dryRun(ctx) {
+		fctx.logMetrics(ctx)
+		return invalid, nil
+	}
+
+	client, err := f.mackerel(ctx)
+	if err != nil {
+		f.logger().ErrorContext(ctx, "failed to configure the mackerel client", "error", err.Error())
+		for _, r := range records {
+			failed = append(failed, r.ID)
+		}
+		return invalid, failed
+	}
+	fctx.mackerel = client
+	fctx.publishers = f.publishers(ctx)
+	fctx.publishMetric(ctx)
+
+	failedRecords := make(map[string]bool)
+	for service := range fctx.failedServiceMetrics {
+		for _, id := range serviceRecords[service] {
+			failedRecords[id] = true
+		}
+	}
+	if len(fctx.failedHostMetrics) > 0 {
+		for _, id := range hostRecords {
+			failedRecords[id] = true
+		}
+	}
+	for _, r := range records {
+		if failedRecords[r.ID] {
+			failed = append(failed, r.ID)
+		}
+	}
+	return invalid, failed
+}
+
+// ForwardKinesis posts the data points in the Kinesis records to Mackerel.
+// Each record contains the data points in JSON, see Datapoint for the format.
+//
+// The records that have invalid data are skipped with warnings.
+// The records that failed to post are reported as the batch item failures,
+// so Kinesis retries them. Enable ReportBatchItemFailures of the event source mapping to use it.
+func (f *Forwarder) ForwardKinesis(ctx context.Context, event *events.KinesisEvent) (*events.KinesisEventResponse, error) {
+	ctx, cancel := f.invocationContext(ctx)
+	defer cancel()
+
+	records := make([]ingestRecord, 0, len(event.Records))
+	for _, r := range event.Records {
+		records = append(records, ingestRecord{
+			ID:   r.Kinesis.SequenceNumber,
+			Data: r.Kinesis.Data,
+		})
+	}
+	_, failed := f.ingest(ctx, records)
+
+	resp := &events.KinesisEventResponse{
+		BatchItemFailures: []events.KinesisBatchItemFailure{},
+	}
+	for _, id := range failed {
+		resp.BatchItemFailures = append(resp.BatchItemFailures, events.KinesisBatchItemFailure{
+			ItemIdentifier: id,
+		})
+	}
+	return resp, nil
+}
+
+// ForwardFirehose is the data transformation of Amazon Data Firehose.
+// It posts the data points in the records to Mackerel, and passes the records through to the destination.
+// Each record contains the data points in JSON, see Datapoint for the format.
+//
+// The records that have invalid data or failed to post are marked as "ProcessingFailed",
+// so Firehose delivers them to the error output.
+func (f *Forwarder) ForwardFirehose(ctx context.Context, event *events.KinesisFirehoseEvent) (*events.KinesisFirehoseResponse, error) {
+	ctx, cancel := f.invocationContext(ctx)
+	defer cancel()
+
+	records := make([]ingestRecord, 0, len(event.Records))
+	for _, r := range event.Records {
+		records = append(records, ingestRecord{
+			ID:   r.RecordID,
+			Data: r.Data,
+		})
+	}
+	invalid, failed := f.ingest(ctx, records)
+
+	results := make(map[string]string, len(invalid)+len(failed))
+	for _, id := range invalid {
+		results[id] = events.KinesisFirehoseTransformedStateProcessingFailed
+	}
+	for _, id := range failed {
+		results[id] = events.KinesisFirehoseTransformedStateProcessingFailed
+	}
+
+	resp := &events.KinesisFirehoseResponse{
+		Records: make([]events.KinesisFirehoseResponseRecord, 0, len(event.Records)),
+	}
+	for _, r := range event.Records {
+		result, ok := results[r.RecordID]
+		if !ok {
+			result = events.KinesisFirehoseTransformedStateOk
+		}
+		resp.Records = append(resp.Records, events.KinesisFirehoseResponseRecord{
+			RecordID: r.RecordID,
+			Result:   result,
+			Data:     r.Data,
+		})
+	}
+	return resp, nil
+}
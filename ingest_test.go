@@ -0,0 +1,112 @@
+package forwarder
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseDatapoints(t *testing.T) {
+	data := []byte(`{"service":"foo","name":"custom.a","time":1234567860,"value":1}
+[{"hostId":"host-abc","name":"custom.b","time":1234567860,"value":2}]`)
+	got, err := parseDatapoints(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Datapoint{
+		{Service: "foo", Name: "custom.a", Time: 1234567860, Value: 1},
+		{HostID: "host-abc", Name: "custom.b", Time: 1234567860, Value: 2},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("datapoints mismatch: (-want/+got):\n%s", diff)
+	}
+
+	if _, err := parseDatapoints([]byte(`{"service":`)); err == nil {
+		t.Error("want error, got nil")
+	}
+}
+
+func TestForwardKinesis(t *testing.T) {
+	var hostMetrics []HostMetricValue
+	client := newTestMackerelClient(t, http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v0/services/bar/tsdb":
+			rw.WriteHeader(http.StatusForbidden)
+		case "/api/v0/tsdb":
+			data, _ := io.ReadAll(r.Body)
+			if err := json.Unmarshal(data, &hostMetrics); err != nil {
+				t.Error(err)
+			}
+			rw.WriteHeader(http.StatusOK)
+		default:
+			rw.WriteHeader(http.StatusOK)
+		}
+	}))
+	f := &Forwarder{
+		svcmackerel: client,
+	}
+	now := time.Now().Truncate(time.Minute).Unix()
+	event := &events.KinesisEvent{
+		Records: []events.KinesisEventRecord{
+			{Kinesis: events.KinesisRecord{SequenceNumber: "1", Data: []byte(fmt.Sprintf(`{"service":"foo","name":"custom.a","time":%d,"value":1}`, now))}},
+			{Kinesis: events.KinesisRecord{SequenceNumber: "2", Data: []byte(fmt.Sprintf(`{"service":"bar","name":"custom.a","time":%d,"value":1}`, now))}},
+			{Kinesis: events.KinesisRecord{SequenceNumber: "3", Data: []byte(fmt.Sprintf(`{"hostId":"host-abc","name":"custom.b","time":%d,"value":2}`, now))}},
+			{Kinesis: events.KinesisRecord{SequenceNumber: "4", Data: []byte(`invalid`)}},
+		},
+	}
+
+	resp, err := f.ForwardKinesis(context.Background(), event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &events.KinesisEventResponse{
+		BatchItemFailures: []events.KinesisBatchItemFailure{
+			{ItemIdentifier: "2"},
+		},
+	}
+	if diff := cmp.Diff(want, resp); diff != "" {
+		t.Errorf("response mismatch: (-want/+got):\n%s", diff)
+	}
+	wantHostMetrics := []HostMetricValue{
+		{HostID: "host-abc", Name: "custom.b", Time: now, Value: 2},
+	}
+	if diff := cmp.Diff(wantHostMetrics, hostMetrics); diff != "" {
+		t.Errorf("host metrics mismatch: (-want/+got):\n%s", diff)
+	}
+}
+
+func TestForwardFirehose(t *testing.T) {
+	client := newTestMackerelClient(t, http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	}))
+	f := &Forwarder{
+		svcmackerel: client,
+	}
+	event := &events.KinesisFirehoseEvent{
+		Records: []events.KinesisFirehoseEventRecord{
+			{RecordID: "1", Data: []byte(`{"service":"foo","name":"custom.a","time":1234567860,"value":1}`)},
+			{RecordID: "2", Data: []byte(`invalid`)},
+		},
+	}
+
+	resp, err := f.ForwardFirehose(context.Background(), event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &events.KinesisFirehoseResponse{
+		Records: []events.KinesisFirehoseResponseRecord{
+			{RecordID: "1", Result: events.KinesisFirehoseTransformedStateOk, Data: event.Records[0].Data},
+			{RecordID: "2", Result: events.KinesisFirehoseTransformedStateProcessingFailed, Data: event.Records[1].Data},
+		},
+	}
+	if diff := cmp.Diff(want, resp); diff != "" {
+		t.Errorf("response mismatch: (-want/+got):\n%s", diff)
+	}
+}
@@ -0,0 +1,139 @@
+package forwarder
+
+import (
+	"fmt"
+)
+
+// namingIntegration is the naming of the metrics compatible with the AWS integration of Mackerel.
+const namingIntegration = "integration"
+
+// integrationMetricNames is the metric names of the AWS integration of Mackerel.
+// namespace -> CloudWatch metric name -> Mackerel metric name.
+var integrationMetricNames = map[string]map[string]string{
+	"AWS/RDS": {
+		"CPUUtilization":            "rds.cpu.used",
+		"FreeableMemory":            "rds.memory.free",
+		"SwapUsage":                 "rds.swap_usage.used",
+		"FreeStorageSpace":          "rds.disk.free",
+		"DatabaseConnections":       "rds.database_connections.connections",
+		"ReadIOPS":                  "rds.iops.read",
+		"WriteIOPS":                 "rds.iops.write",
+		"ReadLatency":               "rds.latency.read",
+		"WriteLatency":              "rds.latency.write",
+		"ReadThroughput":            "rds.throughput.read",
+		"WriteThroughput":           "rds.throughput.write",
+		"NetworkReceiveThroughput":  "rds.network_throughput.receive",
+		"NetworkTransmitThroughput": "rds.network_throughput.transmit",
+		"DiskQueueDepth":            "rds.disk_queue_depth.depth",
+		"ReplicaLag":                "rds.replica_lag.lag",
+	},
+	"AWS/ApplicationELB": {
+		"RequestCount":               "alb.request.count",
+		"TargetResponseTime":         "alb.response_time.target",
+		"ActiveConnectionCount":      "alb.connection_count.active",
+		"NewConnectionCount":         "alb.connection_count.new",
+		"RejectedConnectionCount":    "alb.connection_count.rejected",
+		"HealthyHostCount":           "alb.host_count.healthy",
+		"UnHealthyHostCount":         "alb.host_count.unhealthy",
+		"HTTPCode_Target_2XX_Count":  "alb.httpcode_count.target_2xx",
+		"HTTPCode_Target_3XX_Count":  "alb.httpcode_count.target_3xx",
+		"HTTPCode_Target_4XX_Count":  "alb.httpcode_count.target_4xx",
+		"HTTPCode_Target_5XX_Count":  "alb.httpcode_count.target_5xx",
+		"HTTPCode_ELB_4XX_Count":     "alb.httpcode_count.elb_4xx",
+		"HTTPCode_ELB_5XX_Count":     "alb.httpcode_count.elb_5xx",
+		"ProcessedBytes":             "alb.bytes.processed",
+		"TargetConnectionErrorCount": "alb.target_connection_error_count.count",
+	},
+	"AWS/ELB": {
+		"RequestCount":            "elb.requests.count",
+		"Latency":                 "elb.latency.latency",
+		"HealthyHostCount":        "elb.host_count.healthy",
+		"UnHealthyHostCount":      "elb.host_count.unhealthy",
+		"HTTPCode_Backend_2XX":    "elb.http_backend_count.2xx",
+		"HTTPCode_Backend_3XX":    "elb.http_backend_count.3xx",
+		"HTTPCode_Backend_4XX":    "elb.http_backend_count.4xx",
+		"HTTPCode_Backend_5XX":    "elb.http_backend_count.5xx",
+		"HTTPCode_ELB_4XX":        "elb.http_count.4xx",
+		"HTTPCode_ELB_5XX":        "elb.http_count.5xx",
+		"SurgeQueueLength":        "elb.surge_queue_length.length",
+		"SpilloverCount":          "elb.spillover_count.count",
+		"BackendConnectionErrors": "elb.backend_connection_errors.count",
+	},
+	"AWS/SQS": {
+		"ApproximateNumberOfMessagesVisible":    "sqs.messages.visible",
+		"ApproximateNumberOfMessagesNotVisible": "sqs.messages.not_visible",
+		"ApproximateNumberOfMessagesDelayed":    "sqs.messages.delayed",
+		"ApproximateAgeOfOldestMessage":         "sqs.oldest_message_age.age",
+		"NumberOfMessagesSent":                  "sqs.message_count.sent",
+		"NumberOfMessagesReceived":              "sqs.message_count.received",
+		"NumberOfMessagesDeleted":               "sqs.message_count.deleted",
+		"NumberOfEmptyReceives":                 "sqs.message_count.empty_receives",
+		"SentMessageSize":                       "sqs.message_size.sent",
+	},
+	"AWS/Lambda": {
+		"Invocations":          "lambda.count.invocations",
+		"Errors":               "lambda.count.errors",
+		"Throttles":            "lambda.count.throttles",
+		"DeadLetterErrors":     "lambda.count.dead_letter_errors",
+		"Duration":             "lambda.duration.duration",
+		"ConcurrentExecutions": "lambda.concurrent_executions.count",
+		"IteratorAge":          "lambda.iterator_age.age",
+	},
+	"AWS/DynamoDB": {
+		"ConsumedReadCapacityUnits":     "dynamodb.capacity.consumed_read",
+		"ConsumedWriteCapacityUnits":    "dynamodb.capacity.consumed_write",
+		"ProvisionedReadCapacityUnits":  "dynamodb.capacity.provisioned_read",
+		"ProvisionedWriteCapacityUnits": "dynamodb.capacity.provisioned_write",
+		"ReadThrottleEvents":            "dynamodb.throttle_events.read",
+		"WriteThrottleEvents":           "dynamodb.throttle_events.write",
+		"ThrottledRequests":             "dynamodb.throttled_requests.count",
+		"SuccessfulRequestLatency":      "dynamodb.latency.successful",
+		"UserErrors":                    "dynamodb.errors.user",
+		"SystemErrors":                  "dynamodb.errors.system",
+	},
+	"AWS/ElastiCache": {
+		"CPUUtilization":       "elasticache.cpu.used",
+		"FreeableMemory":       "elasticache.memory.freeable",
+		"SwapUsage":            "elasticache.memory.swap_usage",
+		"CurrConnections":      "elasticache.connections.current",
+		"NewConnections":       "elasticache.connections.new",
+		"Evictions":            "elasticache.evictions.evictions",
+		"CacheHits":            "elasticache.cache.hits",
+		"CacheMisses":          "elasticache.cache.misses",
+		"NetworkBytesIn":       "elasticache.network.in",
+		"NetworkBytesOut":      "elasticache.network.out",
+		"ReplicationLag":       "elasticache.replication_lag.lag",
+		"CurrItems":            "elasticache.items.current",
+		"EngineCPUUtilization": "elasticache.cpu.engine",
+	},
+}
+
+// IntegrationMetricName returns the metric name of the AWS integration of Mackerel
+// for the CloudWatch metric, e.g. "rds.cpu.used" for CPUUtilization of AWS/RDS.
+// The forwarded metrics with the names are shown in the same graphs as the ones that the integration collects.
+func IntegrationMetricName(namespace, metricName string) (string, bool) {
+	name, ok := integrationMetricNames[namespace][metricName]
+	return name, ok
+}
+
+// metricName returns the metric name on Mackerel of the query.
+func (q *Query) metricName(namespace, metricName string) (string, error) {
+	switch q.Naming {
+	case "":
+		return q.Name, nil
+	case namingIntegration:
+		if q.Name != "" {
+			return "", fmt.Errorf("name can't be specified with naming %q", q.Naming)
+		}
+		if (q.Type != "" && q.Type != queryTypeMetric) || q.Expression != "" {
+			return "", fmt.Errorf("naming %q is available only for metric type queries without expressions", q.Naming)
+		}
+		name, ok := IntegrationMetricName(namespace, metricName)
+		if !ok {
+			return "", fmt.Errorf("no metric name of the integration for %s %s", namespace, metricName)
+		}
+		return name, nil
+	default:
+		return "", fmt.Errorf("unknown naming: %q", q.Naming)
+	}
+}
@@ -0,0 +1,50 @@
+package forwarder
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestIntegrationMetricName(t *testing.T) {
+	name, ok := IntegrationMetricName("AWS/RDS", "CPUUtilization")
+	if !ok || name != "rds.cpu.used" {
+		t.Errorf("unexpected name: %q, %t", name, ok)
+	}
+	if _, ok := IntegrationMetricName("AWS/RDS", "Unknown"); ok {
+		t.Error("unknown metric should not have the name")
+	}
+	for namespace, names := range integrationMetricNames {
+		for metric, name := range names {
+			if err := validateMetricName(name); err != nil {
+				t.Errorf("%s %s: %v", namespace, metric, err)
+			}
+		}
+	}
+}
+
+func TestPrepareQueries_NamingIntegration(t *testing.T) {
+	query := []*Query{
+		{Host: "host-abc", Naming: "integration", Metric: []interface{}{"AWS/RDS", "CPUUtilization", "DBInstanceIdentifier", "db"}, Stat: "Average"},
+		{Host: ".", Naming: "integration", Metric: []interface{}{".", "DatabaseConnections", ".", "."}, Stat: "Average"},
+		{Host: ".", Name: "custom.rds.cpu", Naming: "integration", Metric: []interface{}{"AWS/RDS", "CPUUtilization", "DBInstanceIdentifier", "db"}, Stat: "Maximum"},
+		{Host: ".", Naming: "integration", Metric: []interface{}{"AWS/RDS", "Unknown", "DBInstanceIdentifier", "db"}, Stat: "Average"},
+		{Host: ".", Naming: "unknown", Metric: []interface{}{"AWS/RDS", "CPUUtilization", "DBInstanceIdentifier", "db"}, Stat: "Minimum"},
+	}
+	got, errs := prepareQueries(query)
+
+	var names []string
+	for _, q := range got {
+		names = append(names, q.Label.MetricName)
+	}
+	if diff := cmp.Diff([]string{"rds.cpu.used", "rds.database_connections.connections"}, names); diff != "" {
+		t.Errorf("names mismatch: (-want/+got):\n%s", diff)
+	}
+	var indexes []int
+	for _, err := range errs {
+		indexes = append(indexes, err.Index)
+	}
+	if diff := cmp.Diff([]int{2, 3, 4}, indexes); diff != "" {
+		t.Errorf("indexes mismatch: (-want/+got):\n%s", diff)
+	}
+}
@@ -0,0 +1,75 @@
+package forwarder
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// minInterval is the minimum interval of forwarding within an invocation.
+const minInterval = time.Second
+
+// interval returns the interval of forwarding within an invocation.
+// It returns zero if the metrics are forwarded once per invocation.
+func (f *Forwarder) interval() time.Duration {
+	d := f.Interval
+	if d == 0 {
+		d = f.env().Interval
+	}
+	if d <= 0 || d >= time.Minute {
+		return 0
+	}
+	return max(d, minInterval)
+}
+
+// inProgressWindow is the length of the minutes that are still in progress at the time of the invocation.
+// They are the minute of the invocation and the one before it, which are delayed by defaultDelay in the time window.
+const inProgressWindow = defaultDelay + time.Minute
+
+// forwardEvery forwards the metrics every interval until the next minute,
+// when the next invocation takes over.
+// The time windows include the minutes in progress, so that the data points are posted as soon as they arrive.
+// The data points of the minutes in progress are posted again with the updated values in the following ticks.
+func (f *Forwarder) forwardEvery(ctx context.Context, client *MackerelClient, query []*Query, at time.Time, interval time.Duration, report *InvocationReport) error {
+	until := at.Truncate(time.Minute).Add(time.Minute)
+	var errs []error
+	for tick := at; ; {
+		if err := f.forwardWindow(ctx, client, query, tick, inProgressWindow, report); err != nil {
+			errs = append(errs, err)
+		}
+
+		tick = tick.Add(interval)
+		if !tick.Before(until) {
+			break
+		}
+		if err := sleepContext(ctx, tick.Sub(f.now())); err != nil {
+			// no time for the next tick.
+			break
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// completeEnd returns the end of the complete minutes in the time window of the query.
+func (fctx *forwardContext) completeEnd(q *metricQuery) time.Time {
+	_, end := fctx.window(q)
+	return end.Add(-fctx.inProgress)
+}
+
+// latestComplete returns the latest value of the label in the complete minutes.
+// The values of the minutes in progress are not final, so they don't advance the high-water marks.
+func (fctx *forwardContext) latestComplete(l string, q *metricQuery) (latestValue, bool) {
+	if fctx.inProgress == 0 {
+		v, ok := fctx.latest[l]
+		return v, ok
+	}
+	end := fctx.completeEnd(q).Unix()
+	var ret latestValue
+	var found bool
+	for t, v := range fctx.points[l] {
+		if t < end && (!found || t > ret.Time.Unix()) {
+			ret, found = latestValue{Time: time.Unix(t, 0), Value: v}, true
+		}
+	}
+	return ret, found
+}
@@ -0,0 +1,76 @@
+package forwarder
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestForwarder_Interval(t *testing.T) {
+	tests := []struct {
+		interval time.Duration
+		want     time.Duration
+	}{
+		{interval: 0, want: 0},
+		{interval: time.Minute, want: 0},
+		{interval: 10 * time.Second, want: 10 * time.Second},
+		{interval: time.Millisecond, want: minInterval},
+	}
+	for _, tt := range tests {
+		f := &Forwarder{Interval: tt.interval, EnvConfig: &Config{}}
+		if got := f.interval(); got != tt.want {
+			t.Errorf("interval %s: want %s, got %s", tt.interval, tt.want, got)
+		}
+	}
+}
+
+func TestForwardMetrics_Interval(t *testing.T) {
+	var mu sync.Mutex
+	var posts int
+	client := newTestMackerelClient(t, http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.URL.Path == "/api/v0/services/foo/tsdb" {
+			posts++
+		}
+		rw.WriteHeader(http.StatusOK)
+	}))
+
+	// the ticks are at 58s and 59s of the minute.
+	at := time.Now().Truncate(time.Minute).Add(-time.Minute).Add(58 * time.Second)
+	var fetched []time.Time
+	f := &Forwarder{
+		svcmackerel: client,
+		svccloudwatch: &recordingCloudWatch{
+			fakeCloudWatch: fakeCloudWatch{
+				values: map[string][]float64{"m1": {1, 2, 3}},
+			},
+			onGetMetricData: func(s time.Time) { fetched = append(fetched, s) },
+		},
+		Interval:  time.Second,
+		EnvConfig: &Config{},
+		Now:       func() time.Time { return at },
+	}
+	data := []byte(`[{"service":"foo","name":"a","metric":["AWS/EC2","CPUUtilization"],"stat":"Average"}]`)
+	if _, err := f.ForwardMetrics(context.Background(), data); err != nil {
+		t.Fatal(err)
+	}
+
+	// the complete minute is not fetched again, but the minutes in progress are.
+	start, end := f.timeWindow(context.Background(), at)
+	if diff := cmp.Diff([]time.Time{start, end}, fetched); diff != "" {
+		t.Errorf("fetched windows mismatch: (-want/+got):\n%s", diff)
+	}
+	if posts != 2 {
+		t.Errorf("want 2 posts, got %d", posts)
+	}
+
+	// the high-water mark doesn't cover the minutes in progress.
+	if got, want := f.highWaterMarks["service=foo:a"], start.Unix(); got != want {
+		t.Errorf("unexpected mark: want %d, got %d", want, got)
+	}
+}
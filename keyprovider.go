@@ -0,0 +1,315 @@
+package forwarder
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+)
+
+// ErrNoAPIKey means the KeyProvider has no API key.
+// KeyProviderChain tries the next provider on it.
+var ErrNoAPIKey = errors.New("forwarder: api key for the mackerel is not found")
+
+// KeyProvider provides the API key of Mackerel.
+// Implement it to fetch the key from the other secret stores, e.g. HashiCorp Vault.
+type KeyProvider interface {
+	// APIKey returns the API key.
+	// It returns ErrNoAPIKey if the provider isn't configured.
+	APIKey(ctx context.Context) (string, error)
+}
+
+// RotatableKeyProvider is a KeyProvider whose API key may be rotated.
+// The forwarder checks Rotated on each invocation, and resolves the API key again if it reports true.
+type RotatableKeyProvider interface {
+	KeyProvider
+
+	// Rotated reports whether the API key is changed since it was returned by APIKey last time.
+	Rotated(ctx context.Context) (bool, error)
+}
+
+// KeyProviderFunc is an adapter to use ordinary functions as KeyProvider.
+type KeyProviderFunc func(ctx context.Context) (string, error)
+
+// APIKey implements KeyProvider.
+func (f KeyProviderFunc) APIKey(ctx context.Context) (string, error) {
+	return f(ctx)
+}
+
+// KeyProviderChain tries the providers in order, and returns the first API key found.
+type KeyProviderChain []KeyProvider
+
+// APIKey implements KeyProvider.
+func (c KeyProviderChain) APIKey(ctx context.Context) (string, error) {
+	for _, p := range c {
+		key, err := p.APIKey(ctx)
+		if errors.Is(err, ErrNoAPIKey) {
+			continue
+		}
+		return key, err
+	}
+	return "", ErrNoAPIKey
+}
+
+// Rotated implements RotatableKeyProvider.
+// It reports true if any of the providers reports true.
+func (c KeyProviderChain) Rotated(ctx context.Context) (bool, error) {
+	for _, p := range c {
+		r, ok := p.(RotatableKeyProvider)
+		if !ok {
+			continue
+		}
+		rotated, err := r.Rotated(ctx)
+		if err != nil || rotated {
+			return rotated, err
+		}
+	}
+	return false, nil
+}
+
+// StaticKeyProvider provides the API key as is.
+type StaticKeyProvider string
+
+// APIKey implements KeyProvider.
+func (p StaticKeyProvider) APIKey(ctx context.Context) (string, error) {
+	if p == "" {
+		return "", ErrNoAPIKey
+	}
+	return string(p), nil
+}
+
+// EnvKeyProvider provides the API key from the environment value of the name.
+type EnvKeyProvider string
+
+// APIKey implements KeyProvider.
+func (p EnvKeyProvider) APIKey(ctx context.Context) (string, error) {
+	key := os.Getenv(string(p))
+	if key == "" {
+		return "", ErrNoAPIKey
+	}
+	return key, nil
+}
+
+// SSMKeyProvider provides the API key from AWS Systems Manager Parameter Store.
+type SSMKeyProvider struct {
+	Client         SSMAPI
+	Name           string
+	WithDecryption bool
+}
+
+// APIKey implements KeyProvider.
+func (p *SSMKeyProvider) APIKey(ctx context.Context) (string, error) {
+	if p.Name == "" {
+		return "", ErrNoAPIKey
+	}
+	resp, err := p.Client.GetParameter(ctx, &ssm.GetParameterInput{
+		Name:           aws.String(p.Name),
+		WithDecryption: aws.Bool(p.WithDecryption),
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(resp.Parameter.Value), nil
+}
+
+// KMSKeyProvider decrypts the API key from Source by AWS KMS.
+// The key from Source must be the ciphertext encoded in base64.
+type KMSKeyProvider struct {
+	Client KMSAPI
+	Source KeyProvider
+}
+
+// APIKey implements KeyProvider.
+func (p *KMSKeyProvider) APIKey(ctx context.Context) (string, error) {
+	key, err := p.Source.APIKey(ctx)
+	if err != nil {
+		return "", err
+	}
+	b, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return "", err
+	}
+	resp, err := p.Client.Decrypt(ctx, &kms.DecryptInput{
+		CiphertextBlob: b,
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(resp.Plaintext), nil
+}
+
+// versionStageCurrent is the staging label of the current version of the secrets.
+const versionStageCurrent = "AWSCURRENT"
+
+// SecretsManagerKeyProvider provides the API key from AWS Secrets Manager.
+//
+// It supports the rotation of the secret.
+// The rotation function stores the new API key as the AWSPENDING version in the same layout,
+// e.g. {"apikey": "..."} with Key "apikey", and moves the AWSCURRENT label to it after testing.
+// Rotated detects the move, so the forwarder switches to the new key in the next invocation.
+// Keep the old key valid on Mackerel until the invocations with it are finished.
+type SecretsManagerKeyProvider struct {
+	Client   SecretsManagerAPI
+	SecretID string
+
+	// Key is the key of the API key in the secret of a JSON object.
+	// If it is empty, the whole secret string is the API key.
+	Key string
+
+	mu        sync.Mutex
+	versionID string // the version of the secret that APIKey returned last time
+}
+
+// APIKey implements KeyProvider.
+func (p *SecretsManagerKeyProvider) APIKey(ctx context.Context) (string, error) {
+	if p.SecretID == "" {
+		return "", ErrNoAPIKey
+	}
+	resp, err := p.Client.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId:     aws.String(p.SecretID),
+		VersionStage: aws.String(versionStageCurrent),
+	})
+	if err != nil {
+		return "", err
+	}
+	p.mu.Lock()
+	p.versionID = aws.ToString(resp.VersionId)
+	p.mu.Unlock()
+
+	secret := aws.ToString(resp.SecretString)
+	if p.Key == "" {
+		return secret, nil
+	}
+	var values map[string]string
+	if err := json.Unmarshal([]byte(secret), &values); err != nil {
+		return "", fmt.Errorf("forwarder: the secret %s is not a JSON object: %w", p.SecretID, err)
+	}
+	key, ok := values[p.Key]
+	if !ok {
+		return "", fmt.Errorf("forwarder: the key %q is not found in the secret %s", p.Key, p.SecretID)
+	}
+	return key, nil
+}
+
+// Rotated implements RotatableKeyProvider.
+// It reports true if the AWSCURRENT label is moved to another version of the secret.
+func (p *SecretsManagerKeyProvider) Rotated(ctx context.Context) (bool, error) {
+	p.mu.Lock()
+	versionID := p.versionID
+	p.mu.Unlock()
+	if p.SecretID == "" || versionID == "" {
+		// the API key is not fetched yet.
+		return false, nil
+	}
+
+	resp, err := p.Client.DescribeSecret(ctx, &secretsmanager.DescribeSecretInput{
+		SecretId: aws.String(p.SecretID),
+	})
+	if err != nil {
+		return false, err
+	}
+	for id, stages := range resp.VersionIdsToStages {
+		for _, stage := range stages {
+			if stage == versionStageCurrent {
+				return id != versionID, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// keyProvider returns the provider of the API key.
+// If KeyProvider is nil, the chain of the fields and the environment values is used,
+// in the order of APIKey, APIKeyParameter, MACKEREL_APIKEY, MACKEREL_APIKEY_PARAMETER, and MACKEREL_APIKEY_SECRET.
+func (f *Forwarder) keyProvider() KeyProvider {
+	if f.KeyProvider != nil {
+		return f.KeyProvider
+	}
+	svcssm, svckms, svcsecrets := f.ssm(), f.kms(), f.secretsManager()
+
+	// the provider is reused, because the rotatable providers keep the versions of the keys.
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.svckeyprovider != nil {
+		return f.svckeyprovider
+	}
+
+	env := f.env()
+	f.warnAPIKeyConflicts(env)
+	decrypt := f.APIKeyWithDecrypt || env.APIKeyWithDecrypt
+	withKMS := func(p KeyProvider) KeyProvider {
+		if !decrypt {
+			return p
+		}
+		return &KMSKeyProvider{Client: svckms, Source: p}
+	}
+
+	f.svckeyprovider = KeyProviderChain{
+		withKMS(StaticKeyProvider(f.APIKey)),
+		&SSMKeyProvider{Client: svcssm, Name: f.APIKeyParameter, WithDecryption: decrypt},
+		withKMS(StaticKeyProvider(env.APIKey)),
+		&SSMKeyProvider{Client: svcssm, Name: env.APIKeyParameter, WithDecryption: decrypt},
+		&SecretsManagerKeyProvider{
+			Client:   svcsecrets,
+			SecretID: env.APIKeySecret,
+			Key:      env.APIKeySecretKey,
+		},
+	}
+	return f.svckeyprovider
+}
+
+// warnAPIKeyConflicts warns that more than one API key is set by the environment values.
+// It is not an error, because the key is resolved in the priority of MACKEREL_APIKEY,
+// MACKEREL_APIKEY_PARAMETER, and MACKEREL_APIKEY_SECRET, and the existing deployments rely on it.
+func (f *Forwarder) warnAPIKeyConflicts(env *Config) {
+	var names []string
+	for _, v := range []struct {
+		name  string
+		value string
+	}{
+		{"MACKEREL_APIKEY", env.APIKey},
+		{"MACKEREL_APIKEY_PARAMETER", env.APIKeyParameter},
+		{"MACKEREL_APIKEY_SECRET", env.APIKeySecret},
+	} {
+		if v.value != "" {
+			names = append(names, v.name)
+		}
+	}
+	if len(names) > 1 {
+		f.logger().WarnContext(context.Background(), "more than one api key is set, the first one is used",
+			"names", strings.Join(names, ", "),
+			"use", names[0],
+		)
+	}
+}
+
+// refreshRotatedKey discards the Mackerel client if the API key is rotated,
+// so that the new key is resolved in the invocation.
+func (f *Forwarder) refreshRotatedKey(ctx context.Context, provider KeyProvider) {
+	r, ok := provider.(RotatableKeyProvider)
+	if !ok {
+		return
+	}
+	rotated, err := r.Rotated(ctx)
+	if err != nil {
+		// keep using the current key.
+		f.logger().WarnContext(ctx, "failed to check the rotation of the api key", "error", err.Error())
+		return
+	}
+	if !rotated {
+		return
+	}
+	f.logger().InfoContext(ctx, "the api key of mackerel is rotated, resolves it again")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.svcmackerel = nil
+}
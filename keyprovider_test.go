@@ -0,0 +1,263 @@
+package forwarder
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+)
+
+// fakeKMS "decrypts" the ciphertext by reversing the bytes.
+type fakeKMS struct{}
+
+func (fakeKMS) Decrypt(ctx context.Context, params *kms.DecryptInput, optFns ...func(*kms.Options)) (*kms.DecryptOutput, error) {
+	b := []byte(string(params.CiphertextBlob))
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return &kms.DecryptOutput{Plaintext: b}, nil
+}
+
+type fakeSecretsManager struct {
+	secrets map[string]string
+
+	// versions is the current versions of the secrets.
+	versions map[string]string
+}
+
+func (s *fakeSecretsManager) GetSecretValue(ctx context.Context, params *secretsmanager.GetSecretValueInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error) {
+	id := aws.ToString(params.SecretId)
+	v, ok := s.secrets[id]
+	if !ok {
+		return nil, errors.New("secret not found")
+	}
+	return &secretsmanager.GetSecretValueOutput{
+		SecretString: aws.String(v),
+		VersionId:    aws.String(s.versions[id]),
+	}, nil
+}
+
+func (s *fakeSecretsManager) DescribeSecret(ctx context.Context, params *secretsmanager.DescribeSecretInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.DescribeSecretOutput, error) {
+	id := aws.ToString(params.SecretId)
+	if _, ok := s.secrets[id]; !ok {
+		return nil, errors.New("secret not found")
+	}
+	return &secretsmanager.DescribeSecretOutput{
+		VersionIdsToStages: map[string][]string{
+			"previous":     {"AWSPREVIOUS"},
+			s.versions[id]: {"AWSCURRENT"},
+		},
+	}, nil
+}
+
+func TestKeyProviderChain(t *testing.T) {
+	t.Setenv("FORWARDER_TEST_APIKEY", "")
+	errBroken := errors.New("broken")
+
+	tests := []struct {
+		name  string
+		chain KeyProviderChain
+		want  string
+		err   error
+	}{
+		{
+			name:  "first",
+			chain: KeyProviderChain{StaticKeyProvider("a"), StaticKeyProvider("b")},
+			want:  "a",
+		},
+		{
+			name:  "skip not configured",
+			chain: KeyProviderChain{StaticKeyProvider(""), EnvKeyProvider("FORWARDER_TEST_APIKEY"), StaticKeyProvider("b")},
+			want:  "b",
+		},
+		{
+			name: "stop on errors",
+			chain: KeyProviderChain{
+				KeyProviderFunc(func(ctx context.Context) (string, error) { return "", errBroken }),
+				StaticKeyProvider("b"),
+			},
+			err: errBroken,
+		},
+		{
+			name:  "not found",
+			chain: KeyProviderChain{StaticKeyProvider("")},
+			err:   ErrNoAPIKey,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.chain.APIKey(context.Background())
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("want error %v, got %v", tt.err, err)
+			}
+			if got != tt.want {
+				t.Errorf("want %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestKMSKeyProvider(t *testing.T) {
+	p := &KMSKeyProvider{
+		Client: fakeKMS{},
+		Source: StaticKeyProvider(base64.StdEncoding.EncodeToString([]byte("terces"))),
+	}
+	got, err := p.APIKey(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "secret" {
+		t.Errorf("want %q, got %q", "secret", got)
+	}
+
+	// the source that isn't configured is skipped by the chain.
+	p.Source = StaticKeyProvider("")
+	if _, err := p.APIKey(context.Background()); !errors.Is(err, ErrNoAPIKey) {
+		t.Errorf("want ErrNoAPIKey, got %v", err)
+	}
+}
+
+func TestSecretsManagerKeyProvider(t *testing.T) {
+	svc := &fakeSecretsManager{
+		secrets: map[string]string{
+			"plain": "api-key",
+			"json":  `{"apikey":"json-api-key"}`,
+		},
+	}
+
+	p := &SecretsManagerKeyProvider{Client: svc, SecretID: "plain"}
+	if got, err := p.APIKey(context.Background()); err != nil || got != "api-key" {
+		t.Errorf("want %q, got %q, %v", "api-key", got, err)
+	}
+
+	p = &SecretsManagerKeyProvider{Client: svc, SecretID: "json", Key: "apikey"}
+	if got, err := p.APIKey(context.Background()); err != nil || got != "json-api-key" {
+		t.Errorf("want %q, got %q, %v", "json-api-key", got, err)
+	}
+
+	p = &SecretsManagerKeyProvider{Client: svc, SecretID: "json", Key: "unknown"}
+	if _, err := p.APIKey(context.Background()); err == nil || !strings.Contains(err.Error(), "unknown") {
+		t.Errorf("want the error of the missing key, got %v", err)
+	}
+}
+
+func TestForwarder_KeyProvider(t *testing.T) {
+	t.Setenv("MACKEREL_APIKEY", "")
+	t.Setenv("MACKEREL_APIKEY_PARAMETER", "")
+	t.Setenv("MACKEREL_APIKEY_WITH_DECRYPT", "")
+
+	t.Run("custom", func(t *testing.T) {
+		f := &Forwarder{
+			APIKey:      "ignored",
+			KeyProvider: StaticKeyProvider("custom"),
+		}
+		got, err := f.keyProvider().APIKey(context.Background())
+		if err != nil || got != "custom" {
+			t.Errorf("want %q, got %q, %v", "custom", got, err)
+		}
+	})
+
+	t.Run("parameter with decryption", func(t *testing.T) {
+		f := &Forwarder{
+			APIKeyParameter:   "/mackerel/api-key",
+			APIKeyWithDecrypt: true,
+			SSM:               &fakeSSM{params: map[string]string{"/mackerel/api-key": "from-ssm"}},
+			KMS:               fakeKMS{},
+			SecretsManager:    &fakeSecretsManager{},
+		}
+		got, err := f.keyProvider().APIKey(context.Background())
+		if err != nil || got != "from-ssm" {
+			t.Errorf("want %q, got %q, %v", "from-ssm", got, err)
+		}
+	})
+
+	t.Run("secrets manager", func(t *testing.T) {
+		t.Setenv("MACKEREL_APIKEY_SECRET", "mackerel")
+		f := &Forwarder{
+			SSM:            &fakeSSM{},
+			SecretsManager: &fakeSecretsManager{secrets: map[string]string{"mackerel": "from-secrets-manager"}},
+		}
+		got, err := f.keyProvider().APIKey(context.Background())
+		if err != nil || got != "from-secrets-manager" {
+			t.Errorf("want %q, got %q, %v", "from-secrets-manager", got, err)
+		}
+	})
+
+	t.Run("multiple api keys", func(t *testing.T) {
+		// the key is resolved in the documented priority, and the others are warned.
+		t.Setenv("MACKEREL_APIKEY", "from-env")
+		t.Setenv("MACKEREL_APIKEY_PARAMETER", "/mackerel/api-key")
+		var buf bytes.Buffer
+		f := &Forwarder{
+			Logger:         slog.New(slog.NewTextHandler(&buf, nil)),
+			SSM:            &fakeSSM{params: map[string]string{"/mackerel/api-key": "from-ssm"}},
+			SecretsManager: &fakeSecretsManager{},
+		}
+		got, err := f.keyProvider().APIKey(context.Background())
+		if err != nil || got != "from-env" {
+			t.Errorf("want %q, got %q, %v", "from-env", got, err)
+		}
+		if !strings.Contains(buf.String(), "more than one api key is set") {
+			t.Errorf("want a warning, got %q", buf.String())
+		}
+		if _, err := loadConfig(testLookup(map[string]string{"MACKEREL_APIKEY": "from-env", "MACKEREL_APIKEY_PARAMETER": "/mackerel/api-key"})); err != nil {
+			t.Errorf("want no error, got %v", err)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		f := &Forwarder{
+			SSM:            &fakeSSM{},
+			SecretsManager: &fakeSecretsManager{},
+		}
+		if _, err := f.keyProvider().APIKey(context.Background()); !errors.Is(err, ErrNoAPIKey) {
+			t.Errorf("want ErrNoAPIKey, got %v", err)
+		}
+	})
+}
+
+func TestForwarder_RotateAPIKey(t *testing.T) {
+	svc := &fakeSecretsManager{
+		secrets:  map[string]string{"mackerel": `{"apikey":"old"}`},
+		versions: map[string]string{"mackerel": "v1"},
+	}
+	f := &Forwarder{
+		KeyProvider: &SecretsManagerKeyProvider{
+			Client:   svc,
+			SecretID: "mackerel",
+			Key:      "apikey",
+		},
+		SkipAPIKeyVerification: true,
+	}
+
+	client, err := f.mackerel(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.APIKey != "old" {
+		t.Errorf("want %q, got %q", "old", client.APIKey)
+	}
+
+	// the secret is not rotated, the client is reused.
+	if c, err := f.mackerel(context.Background()); err != nil || c != client {
+		t.Errorf("the client is not reused: %v", err)
+	}
+
+	// the secret is rotated.
+	svc.secrets["mackerel"] = `{"apikey":"new"}`
+	svc.versions["mackerel"] = "v2"
+	client, err = f.mackerel(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.APIKey != "new" {
+		t.Errorf("want %q, got %q", "new", client.APIKey)
+	}
+}
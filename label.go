@@ -2,6 +2,7 @@ package forwarder
 
 import (
 	"fmt"
+	"net/url"
 	"strings"
 )
 
@@ -12,7 +13,24 @@ type Label struct {
 	MetricName string
 }
 
+// labelEscaper escapes the characters that have special meanings in labels.
+var labelEscaper = strings.NewReplacer("%", "%25", ":", "%3A", "=", "%3D")
+
+// unescapeLabel unescapes the element of the label.
+// For backward compatibility, the element that is not escaped is returned as is.
+func unescapeLabel(s string) string {
+	if !strings.Contains(s, "%") {
+		return s
+	}
+	u, err := url.PathUnescape(s)
+	if err != nil {
+		return s
+	}
+	return u
+}
+
 // ParseLabel parses a label.
+// The service name, the host id, and the metric name in the label may be URL-escaped.
 func ParseLabel(s string) (Label, error) {
 	idx := strings.IndexByte(s, ':')
 	switch {
@@ -36,13 +54,13 @@ func ParseLabel(s string) (Label, error) {
 	switch t {
 	case "service":
 		return Label{
-			Service:    id,
-			MetricName: name,
+			Service:    unescapeLabel(id),
+			MetricName: unescapeLabel(name),
 		}, nil
 	case "host":
 		return Label{
-			HostID:     id,
-			MetricName: name,
+			HostID:     unescapeLabel(id),
+			MetricName: unescapeLabel(name),
 		}, nil
 	}
 	return Label{}, fmt.Errorf("invalid label format, unknown id name: %s", t)
@@ -52,12 +70,12 @@ func (l Label) String() string {
 	var buf strings.Builder
 	if l.Service != "" {
 		buf.WriteString("service=")
-		buf.WriteString(l.Service)
+		labelEscaper.WriteString(&buf, l.Service)
 	} else if l.HostID != "" {
 		buf.WriteString("host=")
-		buf.WriteString(l.HostID)
+		labelEscaper.WriteString(&buf, l.HostID)
 	}
 	buf.WriteString(":")
-	buf.WriteString(l.MetricName)
+	labelEscaper.WriteString(&buf, l.MetricName)
 	return buf.String()
 }
@@ -27,6 +27,23 @@ func TestParseLabel(t *testing.T) {
 			},
 			valid: true,
 		},
+		{
+			in: "service=a%3Ab%3Dc:foo%3Abar%25",
+			out: Label{
+				Service:    "a:b=c",
+				MetricName: "foo:bar%",
+			},
+			valid: true,
+		},
+		{
+			// backward compatibility
+			in: "service=prod:foo:bar%zz",
+			out: Label{
+				Service:    "prod",
+				MetricName: "foo:bar%zz",
+			},
+			valid: true,
+		},
 		{
 			in: "",
 		},
@@ -91,6 +108,13 @@ func TestLabel_String(t *testing.T) {
 			},
 			out: "host=abcdefg:boo.foo.uoo",
 		},
+		{
+			in: Label{
+				Service:    "a:b=c",
+				MetricName: "foo:bar%",
+			},
+			out: "service=a%3Ab%3Dc:foo%3Abar%25",
+		},
 	}
 
 	for _, tc := range testcases {
@@ -98,5 +122,13 @@ func TestLabel_String(t *testing.T) {
 		if got != tc.out {
 			t.Errorf("want %s, got %s", tc.out, got)
 		}
+		l, err := ParseLabel(got)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if l != tc.in {
+			t.Errorf("round trip: want %v, got %v", tc.in, l)
+		}
 	}
 }
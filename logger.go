@@ -0,0 +1,105 @@
+package forwarder
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+)
+
+// Logger is the interface for logging.
+// It is compatible with *slog.Logger, so the logs can be routed to the logger of the library consumers.
+type Logger interface {
+	DebugContext(ctx context.Context, msg string, args ...any)
+	InfoContext(ctx context.Context, msg string, args ...any)
+	WarnContext(ctx context.Context, msg string, args ...any)
+	ErrorContext(ctx context.Context, msg string, args ...any)
+}
+
+var _ Logger = (*slog.Logger)(nil)
+
+// logger returns the logger of the forwarder.
+func (f *Forwarder) logger() Logger {
+	if f.Logger != nil {
+		return f.Logger
+	}
+	return slog.Default()
+}
+
+// logger returns the logger of the client.
+func (c *MackerelClient) logger() Logger {
+	if c.Logger != nil {
+		return c.Logger
+	}
+	return slog.Default()
+}
+
+// loggerEnabled reports whether the logger emits the logs at the level.
+// It returns true if the logger doesn't tell it.
+func loggerEnabled(ctx context.Context, logger Logger, level slog.Level) bool {
+	if l, ok := logger.(interface {
+		Enabled(context.Context, slog.Level) bool
+	}); ok {
+		return l.Enabled(ctx, level)
+	}
+	return true
+}
+
+type queryIndexKey struct{}
+
+// withQueryIndex returns a copy of ctx that carries the index of the query in progress.
+func withQueryIndex(ctx context.Context, index int) context.Context {
+	return context.WithValue(ctx, queryIndexKey{}, index)
+}
+
+// queryIndexFromContext returns the index of the query in progress.
+func queryIndexFromContext(ctx context.Context) (int, bool) {
+	index, ok := ctx.Value(queryIndexKey{}).(int)
+	return index, ok
+}
+
+// LambdaHandler is a slog.Handler that adds the information of AWS Lambda to the records.
+// The records have the following attributes if they are available:
+//
+//   - aws_request_id: the request id of the invocation.
+//   - function_version: the version of the function.
+//   - query_index: the index of the query in progress.
+type LambdaHandler struct {
+	handler slog.Handler
+}
+
+var _ slog.Handler = (*LambdaHandler)(nil)
+
+// NewLambdaHandler returns a new LambdaHandler that passes the records to h.
+func NewLambdaHandler(h slog.Handler) *LambdaHandler {
+	return &LambdaHandler{handler: h}
+}
+
+// Enabled implements slog.Handler.
+func (h *LambdaHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.handler.Enabled(ctx, level)
+}
+
+// Handle implements slog.Handler.
+func (h *LambdaHandler) Handle(ctx context.Context, r slog.Record) error {
+	if lc, ok := lambdacontext.FromContext(ctx); ok && lc.AwsRequestID != "" {
+		r.AddAttrs(slog.String("aws_request_id", lc.AwsRequestID))
+	}
+	if lambdacontext.FunctionVersion != "" {
+		r.AddAttrs(slog.String("function_version", lambdacontext.FunctionVersion))
+	}
+	if index, ok := queryIndexFromContext(ctx); ok {
+		r.AddAttrs(slog.Int("query_index", index))
+	}
+	return h.handler.Handle(ctx, r)
+}
+
+// WithAttrs implements slog.Handler.
+func (h *LambdaHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &LambdaHandler{handler: h.handler.WithAttrs(attrs)}
+}
+
+// WithGroup implements slog.Handler.
+func (h *LambdaHandler) WithGroup(name string) slog.Handler {
+	return &LambdaHandler{handler: h.handler.WithGroup(name)}
+}
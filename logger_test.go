@@ -0,0 +1,66 @@
+package forwarder
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+)
+
+func TestForwarderLogger(t *testing.T) {
+	t.Setenv("FORWARD_LOOKBACK", "invalid")
+
+	var buf bytes.Buffer
+	f := &Forwarder{
+		Logger: slog.New(slog.NewJSONHandler(&buf, nil)),
+	}
+	f.lookback(context.Background())
+
+	var record map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatal(err)
+	}
+	if record["level"] != "WARN" {
+		t.Errorf("unexpected level: %v", record["level"])
+	}
+	if record["msg"] != "invalid lookback, use the default" {
+		t.Errorf("unexpected message: %v", record["msg"])
+	}
+	if record["input"] != "invalid" {
+		t.Errorf("unexpected input: %v", record["input"])
+	}
+}
+
+func TestLambdaHandler(t *testing.T) {
+	version := lambdacontext.FunctionVersion
+	lambdacontext.FunctionVersion = "42"
+	defer func() { lambdacontext.FunctionVersion = version }()
+
+	var buf bytes.Buffer
+	logger := slog.New(NewLambdaHandler(slog.NewJSONHandler(&buf, nil)))
+	ctx := lambdacontext.NewContext(context.Background(), &lambdacontext.LambdaContext{
+		AwsRequestID: "request-id",
+	})
+	ctx = withQueryIndex(ctx, 3)
+	logger.With("foo", "bar").InfoContext(ctx, "message")
+
+	var record map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatal(err)
+	}
+	if record["aws_request_id"] != "request-id" {
+		t.Errorf("unexpected aws_request_id: %v", record["aws_request_id"])
+	}
+	if record["function_version"] != "42" {
+		t.Errorf("unexpected function_version: %v", record["function_version"])
+	}
+	if record["query_index"] != float64(3) {
+		t.Errorf("unexpected query_index: %v", record["query_index"])
+	}
+	if record["foo"] != "bar" {
+		t.Errorf("unexpected foo: %v", record["foo"])
+	}
+}
@@ -0,0 +1,43 @@
+package forwarder
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+)
+
+// getLogEventCounts counts the log events that match the filter pattern per minute.
+func (fctx *forwardContext) getLogEventCounts(ctx context.Context, q *metricQuery) error {
+	svc := fctx.forwarder.logs()
+	start, end := fctx.window(q)
+	input := &cloudwatchlogs.FilterLogEventsInput{
+		LogGroupName: aws.String(q.Query.LogGroup),
+		StartTime:    aws.Int64(start.UnixMilli()),
+		// EndTime is inclusive, but the window is exclusive.
+		EndTime: aws.Int64(end.UnixMilli() - 1),
+	}
+	if q.Query.FilterPattern != "" {
+		input.FilterPattern = aws.String(q.Query.FilterPattern)
+	}
+
+	counts := map[int64]int{}
+	paginator := cloudwatchlogs.NewFilterLogEventsPaginator(svc, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("forwarder: failed to filter log events of %s: %w", q.Query.LogGroup, err)
+		}
+		for _, event := range page.Events {
+			t := time.UnixMilli(aws.ToInt64(event.Timestamp)).Truncate(time.Minute)
+			counts[t.Unix()]++
+		}
+	}
+
+	for t := start.Truncate(time.Minute); t.Before(end); t = t.Add(time.Minute) {
+		fctx.appendValue(q.Query, q.Label, t, float64(counts[t.Unix()]))
+	}
+	return nil
+}
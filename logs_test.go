@@ -0,0 +1,65 @@
+package forwarder
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+	"github.com/google/go-cmp/cmp"
+)
+
+type fakeLogs struct {
+	t      *testing.T
+	events []types.FilteredLogEvent
+}
+
+func (s *fakeLogs) FilterLogEvents(ctx context.Context, params *cloudwatchlogs.FilterLogEventsInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.FilterLogEventsOutput, error) {
+	if want, got := "/aws/lambda/foo", aws.ToString(params.LogGroupName); want != got {
+		s.t.Errorf("unexpected log group: want %q, got %q", want, got)
+	}
+	if want, got := "ERROR", aws.ToString(params.FilterPattern); want != got {
+		s.t.Errorf("unexpected filter pattern: want %q, got %q", want, got)
+	}
+	return &cloudwatchlogs.FilterLogEventsOutput{
+		Events: s.events,
+	}, nil
+}
+
+func TestGetMetricsData_Logs(t *testing.T) {
+	start := time.Unix(1234567860, 0)
+	fctx := &forwardContext{
+		forwarder: &Forwarder{
+			svclogs: &fakeLogs{
+				t: t,
+				events: []types.FilteredLogEvent{
+					{Timestamp: aws.Int64(start.UnixMilli() + 1000)},
+					{Timestamp: aws.Int64(start.UnixMilli() + 2000)},
+				},
+			},
+		},
+		start: start,
+		end:   start.Add(time.Minute),
+	}
+	query := []*Query{
+		{
+			Type:          "logs",
+			Service:       "foo-bar",
+			Name:          "logs.errors",
+			LogGroup:      "/aws/lambda/foo",
+			FilterPattern: "ERROR",
+		},
+	}
+	if err := fctx.getMetricsData(context.Background(), query); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []ServiceMetricValue{
+		{Name: "logs.errors", Time: start.Unix(), Value: 2},
+	}
+	if diff := cmp.Diff(want, fctx.serviceMetrics["foo-bar"]); diff != "" {
+		t.Errorf("metrics mismatch: (-want/+got):\n%s", diff)
+	}
+}
@@ -8,9 +8,11 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"strings"
 	"time"
 
 	"github.com/shogo82148/go-retry"
+	"golang.org/x/time/rate"
 )
 
 var defaultBaseURL *url.URL
@@ -45,13 +47,36 @@ type MackerelClient struct {
 	UserAgent   string
 	HTTPClient  *http.Client
 	RetryPolicy retry.Policy
+
+	// RetryMargin is the minimum remaining time before the deadline of the context to start retrying.
+	// The default is 3 seconds.
+	RetryMargin time.Duration
+
+	// RateLimiter limits the rate of the requests.
+	// It is shared among the goroutines that use the client.
+	// If it is nil, the requests are not limited.
+	RateLimiter *rate.Limiter
+
+	// Logger is the logger of the client.
+	// If it is nil, slog.Default() is used.
+	Logger Logger
+
+	// RequestMiddleware are called in order with every request before it is sent,
+	// e.g. for adding the headers for auth proxies and audits.
+	// They are called after the default headers are set, so they can override them.
+	RequestMiddleware []func(req *http.Request)
+
+	// ResponseHooks are called in order after every request is sent, with the response or the error,
+	// e.g. for custom instrumentation. They must not read or close the body of the response.
+	ResponseHooks []func(req *http.Request, resp *http.Response, err error)
 }
 
 // NewMackerelClient creates a new MackerelClient.
 func NewMackerelClient(apiKey string) *MackerelClient {
 	return &MackerelClient{
-		BaseURL: defaultBaseURL,
-		APIKey:  apiKey,
+		BaseURL:    defaultBaseURL,
+		APIKey:     apiKey,
+		HTTPClient: newHTTPClient(),
 		RetryPolicy: retry.Policy{
 			MinDelay: 100 * time.Millisecond,
 			MaxDelay: 30 * time.Second,
@@ -78,12 +103,15 @@ func (c *MackerelClient) urlfor(path string) string {
 	u := new(url.URL)
 	*u = *base
 
+	path, query, _ := strings.Cut(path, "?")
 	u.Path = path
+	u.RawQuery = query
 	return u.String()
 }
 
 func (c *MackerelClient) newRequest(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
 	u := c.urlfor(path)
+	ctx = withConnectionTrace(ctx, c.logger(), method, u)
 	req, err := http.NewRequestWithContext(ctx, method, u, body)
 	if err != nil {
 		return nil, err
@@ -96,26 +124,50 @@ func (c *MackerelClient) newRequest(ctx context.Context, method, path string, bo
 		agent := fmt.Sprintf("mackerel-cloudwatch-forwarder/%s", version)
 		req.Header.Set("User-Agent", agent)
 	}
+	for _, m := range c.RequestMiddleware {
+		m(req)
+	}
 
 	return req, nil
 }
 
 func (c *MackerelClient) postJSON(ctx context.Context, path string, payload interface{}) error {
+	return c.doJSON(ctx, http.MethodPost, path, payload, nil)
+}
+
+// doJSON sends the payload encoded in JSON, and decodes the response into result.
+// If payload is nil, the request has no body. If result is nil, the response body is discarded.
+func (c *MackerelClient) doJSON(ctx context.Context, method, path string, payload, result interface{}) error {
+	if c.RateLimiter != nil {
+		if err := c.RateLimiter.Wait(ctx); err != nil {
+			return err
+		}
+	}
+
 	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
-	data, err := json.Marshal(payload)
-	if err != nil {
-		return retry.MarkPermanent(err)
+	var body io.Reader
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return retry.MarkPermanent(err)
+		}
+		body = bytes.NewReader(data)
 	}
 
-	req, err := c.newRequest(ctx, http.MethodPost, path, bytes.NewReader(data))
+	req, err := c.newRequest(ctx, method, path, body)
 	if err != nil {
 		return err
 	}
-	req.Header.Add("Content-Type", "application/json")
+	if payload != nil {
+		req.Header.Add("Content-Type", "application/json")
+	}
 
-	resp, err := c.httpClient().Do(req)
+	resp, err := tracedDo(c.httpClient(), req, "mackerel")
+	for _, h := range c.ResponseHooks {
+		h(req, resp, err)
+	}
 	if err != nil {
 		return err
 	}
@@ -125,6 +177,12 @@ func (c *MackerelClient) postJSON(ctx context.Context, path string, payload inte
 		return handleError(resp)
 	}
 
+	if result != nil {
+		dec := json.NewDecoder(resp.Body)
+		if err := dec.Decode(result); err != nil {
+			return err
+		}
+	}
 	io.Copy(io.Discard, resp.Body)
 
 	return nil
@@ -134,6 +192,9 @@ func (c *MackerelClient) postJSON(ctx context.Context, path string, payload inte
 type Error struct {
 	StatusCode int
 	Message    string
+
+	// RetryAfter is the duration of the Retry-After header.
+	RetryAfter time.Duration
 }
 
 func (e Error) Error() string {
@@ -152,6 +213,7 @@ func handleError(resp *http.Response) error {
 	return Error{
 		StatusCode: resp.StatusCode,
 		Message:    string(b),
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
 	}
 }
 
@@ -161,7 +223,7 @@ func (c *MackerelClient) PostServiceMetricValues(ctx context.Context, serviceNam
 		return nil
 	}
 
-	return c.RetryPolicy.Do(ctx, func() error {
+	return c.retry(ctx, func() error {
 		return c.postJSON(ctx, fmt.Sprintf("api/v0/services/%s/tsdb", serviceName), values)
 	})
 }
@@ -172,7 +234,7 @@ func (c *MackerelClient) PostHostMetricValues(ctx context.Context, values []Host
 		return nil
 	}
 
-	return c.RetryPolicy.Do(ctx, func() error {
+	return c.retry(ctx, func() error {
 		return c.postJSON(ctx, "api/v0/tsdb", values)
 	})
 }
@@ -0,0 +1,62 @@
+package forwarder
+
+import (
+	"context"
+)
+
+// maxCheckReports is the maximum number of check reports in a request.
+const maxCheckReports = 100
+
+// CheckStatus is a status of check monitoring.
+type CheckStatus string
+
+const (
+	// CheckStatusOK means the check is ok.
+	CheckStatusOK CheckStatus = "OK"
+
+	// CheckStatusWarning means the check is warning.
+	CheckStatusWarning CheckStatus = "WARNING"
+
+	// CheckStatusCritical means the check is critical.
+	CheckStatusCritical CheckStatus = "CRITICAL"
+
+	// CheckStatusUnknown means the status of the check is unknown.
+	CheckStatusUnknown CheckStatus = "UNKNOWN"
+)
+
+// CheckSource is a source of check monitoring report.
+type CheckSource struct {
+	Type   string `json:"type"`
+	HostID string `json:"hostId"`
+}
+
+// CheckReport is a report of check monitoring.
+type CheckReport struct {
+	Source     CheckSource `json:"source"`
+	Name       string      `json:"name"`
+	Status     CheckStatus `json:"status"`
+	Message    string      `json:"message"`
+	OccurredAt int64       `json:"occurredAt"`
+}
+
+// PostCheckReports posts check monitoring reports.
+func (c *MackerelClient) PostCheckReports(ctx context.Context, reports []CheckReport) error {
+	for len(reports) > 0 {
+		n := min(len(reports), maxCheckReports)
+		chunk := reports[:n]
+		reports = reports[n:]
+
+		payload := struct {
+			Reports []CheckReport `json:"reports"`
+		}{
+			Reports: chunk,
+		}
+		err := c.retry(ctx, func() error {
+			return c.postJSON(ctx, "api/v0/monitoring/checks/report", payload)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package forwarder
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPostCheckReports(t *testing.T) {
+	ch := make(chan interface{}, 1)
+	client := newTestMackerelClient(t, http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("unexpected method: want %s, got %s", http.MethodPost, r.Method)
+		}
+		if want, got := "/api/v0/monitoring/checks/report", r.URL.Path; want != got {
+			t.Errorf("unexpected path: want %q, got %q", want, got)
+		}
+		var body interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ch <- body
+		rw.Write([]byte(`{"success":true}`))
+	}))
+
+	err := client.PostCheckReports(context.Background(), []CheckReport{
+		{
+			Source: CheckSource{
+				Type:   "host",
+				HostID: "host-abc",
+			},
+			Name:       "rds.cpu",
+			Status:     CheckStatusWarning,
+			Message:    "85 > 80",
+			OccurredAt: 1234567890,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got interface{}
+	select {
+	case got = <-ch:
+	default:
+		t.Fatal("api is not called")
+	}
+	want := map[string]interface{}{
+		"reports": []interface{}{
+			map[string]interface{}{
+				"source": map[string]interface{}{
+					"type":   "host",
+					"hostId": "host-abc",
+				},
+				"name":       "rds.cpu",
+				"status":     "WARNING",
+				"message":    "85 > 80",
+				"occurredAt": 1234567890.0,
+			},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("body mismatch: (-want/+got):\n%s", diff)
+	}
+}
@@ -0,0 +1,80 @@
+package forwarder
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// Dashboard is a custom dashboard on Mackerel.
+type Dashboard struct {
+	ID      string            `json:"id,omitempty"`
+	Title   string            `json:"title"`
+	Memo    string            `json:"memo"`
+	URLPath string            `json:"urlPath"`
+	Widgets []DashboardWidget `json:"widgets,omitempty"`
+}
+
+// DashboardWidget is a widget of the dashboard, e.g. "graph" and "markdown".
+type DashboardWidget struct {
+	Type     string          `json:"type"`
+	Title    string          `json:"title"`
+	Markdown string          `json:"markdown,omitempty"`
+	Graph    *DashboardGraph `json:"graph,omitempty"`
+	Layout   DashboardLayout `json:"layout"`
+}
+
+// DashboardGraph is the graph of the "graph" widget, e.g. "host" and "service".
+type DashboardGraph struct {
+	Type        string `json:"type"`
+	HostID      string `json:"hostId,omitempty"`
+	ServiceName string `json:"serviceName,omitempty"`
+	Name        string `json:"name"`
+}
+
+// DashboardLayout is the position and the size of the widget in the grid of 24 columns.
+type DashboardLayout struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// FindDashboards returns the dashboards of the organization.
+// The widgets are not included.
+func (c *MackerelClient) FindDashboards(ctx context.Context) ([]Dashboard, error) {
+	var resp struct {
+		Dashboards []Dashboard `json:"dashboards"`
+	}
+	err := c.retry(ctx, func() error {
+		return c.doJSON(ctx, http.MethodGet, "api/v0/dashboards", nil, &resp)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Dashboards, nil
+}
+
+// CreateDashboard creates a new dashboard, and returns the created one.
+func (c *MackerelClient) CreateDashboard(ctx context.Context, dashboard *Dashboard) (*Dashboard, error) {
+	var resp Dashboard
+	err := c.retry(ctx, func() error {
+		return c.doJSON(ctx, http.MethodPost, "api/v0/dashboards", dashboard, &resp)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// UpdateDashboard replaces the dashboard of the id, and returns the updated one.
+func (c *MackerelClient) UpdateDashboard(ctx context.Context, id string, dashboard *Dashboard) (*Dashboard, error) {
+	var resp Dashboard
+	err := c.retry(ctx, func() error {
+		return c.doJSON(ctx, http.MethodPut, "api/v0/dashboards/"+url.PathEscape(id), dashboard, &resp)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
@@ -0,0 +1,84 @@
+package forwarder
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFindDashboards(t *testing.T) {
+	client := newTestMackerelClient(t, http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			t.Errorf("unexpected method: want %s, got %s", http.MethodGet, r.Method)
+		}
+		if want, got := "/api/v0/dashboards", r.URL.Path; want != got {
+			t.Errorf("unexpected path: want %q, got %q", want, got)
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Write([]byte(`{"dashboards":[{"id":"abc","title":"foo","memo":"","urlPath":"foo","createdAt":1234567890,"updatedAt":1234567890}]}`))
+	}))
+
+	got, err := client.FindDashboards(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Dashboard{{ID: "abc", Title: "foo", URLPath: "foo"}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("dashboards mismatch: (-want/+got):\n%s", diff)
+	}
+}
+
+func TestUpdateDashboard(t *testing.T) {
+	client := newTestMackerelClient(t, http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			t.Errorf("unexpected method: want %s, got %s", http.MethodPut, r.Method)
+		}
+		if want, got := "/api/v0/dashboards/abc", r.URL.Path; want != got {
+			t.Errorf("unexpected path: want %q, got %q", want, got)
+		}
+		var payload map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Fatal(err)
+		}
+		want := map[string]any{
+			"title":   "foo",
+			"memo":    "",
+			"urlPath": "foo",
+			"widgets": []any{
+				map[string]any{
+					"type":   "graph",
+					"title":  "sqs",
+					"graph":  map[string]any{"type": "service", "serviceName": "bar", "name": "sqs"},
+					"layout": map[string]any{"x": float64(0), "y": float64(0), "width": float64(8), "height": float64(6)},
+				},
+			},
+		}
+		if diff := cmp.Diff(want, payload); diff != "" {
+			t.Errorf("payload mismatch: (-want/+got):\n%s", diff)
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Write([]byte(`{"id":"abc","title":"foo","memo":"","urlPath":"foo"}`))
+	}))
+
+	got, err := client.UpdateDashboard(context.Background(), "abc", &Dashboard{
+		Title:   "foo",
+		URLPath: "foo",
+		Widgets: []DashboardWidget{
+			{
+				Type:   "graph",
+				Title:  "sqs",
+				Graph:  &DashboardGraph{Type: "service", ServiceName: "bar", Name: "sqs"},
+				Layout: DashboardLayout{Width: 8, Height: 6},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != "abc" {
+		t.Errorf("unexpected id: want %q, got %q", "abc", got.ID)
+	}
+}
@@ -0,0 +1,79 @@
+package forwarder
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// Host is a host on Mackerel.
+type Host struct {
+	ID               string                 `json:"id,omitempty"`
+	Name             string                 `json:"name"`
+	CustomIdentifier string                 `json:"customIdentifier,omitempty"`
+	Meta             map[string]interface{} `json:"meta"`
+}
+
+// FindHostsByCustomIdentifier finds the hosts that have the custom identifier, and are not retired.
+func (c *MackerelClient) FindHostsByCustomIdentifier(ctx context.Context, customIdentifier string) ([]Host, error) {
+	var resp struct {
+		Hosts []Host `json:"hosts"`
+	}
+	path := "api/v0/hosts?" + url.Values{"customIdentifier": {customIdentifier}, "status": hostStatuses}.Encode()
+	err := c.retry(ctx, func() error {
+		return c.doJSON(ctx, http.MethodGet, path, nil, &resp)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Hosts, nil
+}
+
+// hostStatuses are the statuses of the hosts that are not retired.
+// Mackerel returns only the working and standby hosts without the status filter.
+var hostStatuses = []string{"working", "standby", "maintenance", "poweroff"}
+
+// FindHosts returns the hosts that are not retired.
+func (c *MackerelClient) FindHosts(ctx context.Context) ([]Host, error) {
+	var resp struct {
+		Hosts []Host `json:"hosts"`
+	}
+	path := "api/v0/hosts?" + url.Values{"status": hostStatuses}.Encode()
+	err := c.retry(ctx, func() error {
+		return c.doJSON(ctx, http.MethodGet, path, nil, &resp)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Hosts, nil
+}
+
+// CreateHost registers a new host, and returns its host id.
+func (c *MackerelClient) CreateHost(ctx context.Context, host *Host) (string, error) {
+	payload := *host
+	if payload.Meta == nil {
+		payload.Meta = map[string]interface{}{}
+	}
+	var resp struct {
+		ID string `json:"id"`
+	}
+	err := c.retry(ctx, func() error {
+		return c.doJSON(ctx, http.MethodPost, "api/v0/hosts", &payload, &resp)
+	})
+	if err != nil {
+		return "", err
+	}
+	if resp.ID == "" {
+		return "", fmt.Errorf("forwarder: the host id is empty")
+	}
+	return resp.ID, nil
+}
+
+// PutHostMetadata puts the metadata of the host.
+func (c *MackerelClient) PutHostMetadata(ctx context.Context, hostID, namespace string, metadata interface{}) error {
+	path := fmt.Sprintf("api/v0/hosts/%s/metadata/%s", url.PathEscape(hostID), url.PathEscape(namespace))
+	return c.retry(ctx, func() error {
+		return c.doJSON(ctx, http.MethodPut, path, metadata, nil)
+	})
+}
@@ -0,0 +1,116 @@
+package forwarder
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func newTestMackerelClient(t *testing.T, handler http.Handler) *MackerelClient {
+	t.Helper()
+	ts := httptest.NewServer(handler)
+	t.Cleanup(ts.Close)
+	client := NewMackerelClient("api-token")
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.BaseURL = u
+	return client
+}
+
+func TestFindHostsByCustomIdentifier(t *testing.T) {
+	client := newTestMackerelClient(t, http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			t.Errorf("unexpected method: want %s, got %s", http.MethodGet, r.Method)
+		}
+		if want, got := "/api/v0/hosts", r.URL.Path; want != got {
+			t.Errorf("unexpected path: want %q, got %q", want, got)
+		}
+		if want, got := "cloudwatch:AWS/RDS:DBInstanceIdentifier=db", r.URL.Query().Get("customIdentifier"); want != got {
+			t.Errorf("unexpected custom identifier: want %q, got %q", want, got)
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Write([]byte(`{"hosts":[{"id":"host-abc","name":"db","customIdentifier":"cloudwatch:AWS/RDS:DBInstanceIdentifier=db"}]}`))
+	}))
+
+	got, err := client.FindHostsByCustomIdentifier(context.Background(), "cloudwatch:AWS/RDS:DBInstanceIdentifier=db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Host{
+		{
+			ID:               "host-abc",
+			Name:             "db",
+			CustomIdentifier: "cloudwatch:AWS/RDS:DBInstanceIdentifier=db",
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("hosts mismatch: (-want/+got):\n%s", diff)
+	}
+}
+
+func TestFindHosts(t *testing.T) {
+	client := newTestMackerelClient(t, http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if want, got := "/api/v0/hosts", r.URL.Path; want != got {
+			t.Errorf("unexpected path: want %q, got %q", want, got)
+		}
+		// the hosts in maintenance and poweroff are not returned without the status filter.
+		want := []string{"working", "standby", "maintenance", "poweroff"}
+		if diff := cmp.Diff(want, r.URL.Query()["status"]); diff != "" {
+			t.Errorf("status mismatch: (-want/+got):\n%s", diff)
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Write([]byte(`{"hosts":[{"id":"host-abc","name":"db"}]}`))
+	}))
+
+	got, err := client.FindHosts(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Host{{ID: "host-abc", Name: "db"}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("hosts mismatch: (-want/+got):\n%s", diff)
+	}
+}
+
+func TestCreateHost(t *testing.T) {
+	client := newTestMackerelClient(t, http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("unexpected method: want %s, got %s", http.MethodPost, r.Method)
+		}
+		if want, got := "/api/v0/hosts", r.URL.Path; want != got {
+			t.Errorf("unexpected path: want %q, got %q", want, got)
+		}
+		var body interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		want := map[string]interface{}{
+			"name":             "db",
+			"customIdentifier": "cloudwatch:AWS/RDS:DBInstanceIdentifier=db",
+			"meta":             map[string]interface{}{},
+		}
+		if diff := cmp.Diff(want, body); diff != "" {
+			t.Errorf("body mismatch: (-want/+got):\n%s", diff)
+		}
+		rw.Write([]byte(`{"id":"host-abc"}`))
+	}))
+
+	id, err := client.CreateHost(context.Background(), &Host{
+		Name:             "db",
+		CustomIdentifier: "cloudwatch:AWS/RDS:DBInstanceIdentifier=db",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "host-abc" {
+		t.Errorf("unexpected host id: want %q, got %q", "host-abc", id)
+	}
+}
@@ -0,0 +1,57 @@
+package forwarder
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// maxLatestHosts is the maximum number of the hosts in a request of GetLatestHostMetricValues,
+// so that the URL doesn't get too long.
+const maxLatestHosts = 100
+
+// GetLatestHostMetricValues returns the latest values of the host metrics.
+// The result is keyed by the host id and the metric name.
+// The metrics that have no values are omitted.
+func (c *MackerelClient) GetLatestHostMetricValues(ctx context.Context, hostIDs, names []string) (map[string]map[string]HostMetricValue, error) {
+	ret := make(map[string]map[string]HostMetricValue)
+	if len(names) == 0 {
+		return ret, nil
+	}
+	for len(hostIDs) > 0 {
+		n := min(len(hostIDs), maxLatestHosts)
+		chunk := hostIDs[:n]
+		hostIDs = hostIDs[n:]
+
+		var resp struct {
+			TSDBLatest map[string]map[string]*struct {
+				Time  int64   `json:"time"`
+				Value float64 `json:"value"`
+			} `json:"tsdbLatest"`
+		}
+		path := "api/v0/tsdb/latest?" + url.Values{"hostId": chunk, "name": names}.Encode()
+		err := c.retry(ctx, func() error {
+			return c.doJSON(ctx, http.MethodGet, path, nil, &resp)
+		})
+		if err != nil {
+			return nil, err
+		}
+		for hostID, metrics := range resp.TSDBLatest {
+			for name, v := range metrics {
+				if v == nil {
+					continue
+				}
+				if ret[hostID] == nil {
+					ret[hostID] = make(map[string]HostMetricValue)
+				}
+				ret[hostID][name] = HostMetricValue{
+					HostID: hostID,
+					Name:   name,
+					Time:   v.Time,
+					Value:  v.Value,
+				}
+			}
+		}
+	}
+	return ret, nil
+}
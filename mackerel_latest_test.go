@@ -0,0 +1,42 @@
+package forwarder
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestGetLatestHostMetricValues(t *testing.T) {
+	client := newTestMackerelClient(t, http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			t.Errorf("unexpected method: want %s, got %s", http.MethodGet, r.Method)
+		}
+		if want, got := "/api/v0/tsdb/latest", r.URL.Path; want != got {
+			t.Errorf("unexpected path: want %q, got %q", want, got)
+		}
+		q := r.URL.Query()
+		if diff := cmp.Diff([]string{"host-abc", "host-def"}, q["hostId"]); diff != "" {
+			t.Errorf("host ids mismatch: (-want/+got):\n%s", diff)
+		}
+		if diff := cmp.Diff([]string{"rds.cpu"}, q["name"]); diff != "" {
+			t.Errorf("names mismatch: (-want/+got):\n%s", diff)
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Write([]byte(`{"tsdbLatest":{"host-abc":{"rds.cpu":{"time":1234567860,"value":42}},"host-def":{"rds.cpu":null}}}`))
+	}))
+
+	got, err := client.GetLatestHostMetricValues(context.Background(), []string{"host-abc", "host-def"}, []string{"rds.cpu"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]map[string]HostMetricValue{
+		"host-abc": {
+			"rds.cpu": {HostID: "host-abc", Name: "rds.cpu", Time: 1234567860, Value: 42},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("values mismatch: (-want/+got):\n%s", diff)
+	}
+}
@@ -0,0 +1,92 @@
+package forwarder
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+)
+
+// Monitor is a host metric monitor or a service metric monitor on Mackerel.
+// The other types of the monitors are decoded partially.
+type Monitor struct {
+	ID       string   `json:"id,omitempty"`
+	Type     string   `json:"type"`
+	Name     string   `json:"name"`
+	Memo     string   `json:"memo"`
+	Service  string   `json:"service,omitempty"`
+	Metric   string   `json:"metric"`
+	Operator string   `json:"operator"`
+	Warning  *float64 `json:"warning"`
+	Critical *float64 `json:"critical"`
+	Duration int      `json:"duration"`
+	Scopes   []string `json:"scopes,omitempty"`
+}
+
+const (
+	// monitorTypeHost is the type of the host metric monitors.
+	monitorTypeHost = "host"
+
+	// monitorTypeService is the type of the service metric monitors.
+	monitorTypeService = "service"
+)
+
+// FindMonitors returns the monitors of the organization.
+func (c *MackerelClient) FindMonitors(ctx context.Context) ([]Monitor, error) {
+	list, err := c.findMonitorsJSON(ctx)
+	if err != nil {
+		return nil, err
+	}
+	monitors := make([]Monitor, len(list))
+	for i, raw := range list {
+		if err := json.Unmarshal(raw, &monitors[i]); err != nil {
+			return nil, err
+		}
+	}
+	return monitors, nil
+}
+
+// findMonitorsJSON returns the raw JSON of the monitors of the organization,
+// which has the fields that Monitor doesn't have, e.g. notificationInterval and isMute.
+func (c *MackerelClient) findMonitorsJSON(ctx context.Context) ([]json.RawMessage, error) {
+	var resp struct {
+		Monitors []json.RawMessage `json:"monitors"`
+	}
+	err := c.retry(ctx, func() error {
+		return c.doJSON(ctx, http.MethodGet, "api/v0/monitors", nil, &resp)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Monitors, nil
+}
+
+// CreateMonitor creates a new monitor, and returns the created one.
+func (c *MackerelClient) CreateMonitor(ctx context.Context, monitor *Monitor) (*Monitor, error) {
+	var resp Monitor
+	err := c.retry(ctx, func() error {
+		return c.doJSON(ctx, http.MethodPost, "api/v0/monitors", monitor, &resp)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// UpdateMonitor replaces the monitor of the id, and returns the updated one.
+// The fields that Monitor doesn't have are reset to the defaults.
+func (c *MackerelClient) UpdateMonitor(ctx context.Context, id string, monitor *Monitor) (*Monitor, error) {
+	return c.updateMonitorJSON(ctx, id, monitor)
+}
+
+// updateMonitorJSON replaces the monitor of the id with the JSON of monitor, and returns the updated one.
+func (c *MackerelClient) updateMonitorJSON(ctx context.Context, id string, monitor any) (*Monitor, error) {
+	var resp Monitor
+	err := c.retry(ctx, func() error {
+		return c.doJSON(ctx, http.MethodPut, "api/v0/monitors/"+url.PathEscape(id), monitor, &resp)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
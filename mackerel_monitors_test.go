@@ -0,0 +1,38 @@
+package forwarder
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFindMonitors(t *testing.T) {
+	client := newTestMackerelClient(t, http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			t.Errorf("unexpected method: want %s, got %s", http.MethodGet, r.Method)
+		}
+		if want, got := "/api/v0/monitors", r.URL.Path; want != got {
+			t.Errorf("unexpected path: want %q, got %q", want, got)
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Write([]byte(`{"monitors":[
+			{"id":"abc","type":"service","name":"queue","memo":"","service":"foo","metric":"sqs.visible","operator":">","warning":null,"critical":100,"duration":3,"maxCheckAttempts":1},
+			{"id":"def","type":"connectivity","name":"connectivity","memo":""}
+		]}`))
+	}))
+
+	got, err := client.FindMonitors(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	critical := 100.0
+	want := []Monitor{
+		{ID: "abc", Type: "service", Name: "queue", Service: "foo", Metric: "sqs.visible", Operator: ">", Critical: &critical, Duration: 3},
+		{ID: "def", Type: "connectivity", Name: "connectivity"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("monitors mismatch: (-want/+got):\n%s", diff)
+	}
+}
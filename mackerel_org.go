@@ -0,0 +1,35 @@
+package forwarder
+
+import (
+	"context"
+	"net/http"
+)
+
+// Org is the organization of Mackerel.
+type Org struct {
+	Name string `json:"name"`
+}
+
+// GetOrg returns the organization that the API key belongs to.
+// It is useful for verifying the API key.
+func (c *MackerelClient) GetOrg(ctx context.Context) (*Org, error) {
+	var org *Org
+	err := c.retry(ctx, func() error {
+		var err error
+		org, err = c.getOrg(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return org, nil
+}
+
+// getOrg is the same as GetOrg, but it doesn't retry.
+func (c *MackerelClient) getOrg(ctx context.Context) (*Org, error) {
+	var resp Org
+	if err := c.doJSON(ctx, http.MethodGet, "api/v0/org", nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
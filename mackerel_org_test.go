@@ -0,0 +1,31 @@
+package forwarder
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestGetOrg(t *testing.T) {
+	client := newTestMackerelClient(t, http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			t.Errorf("unexpected method: want %s, got %s", http.MethodGet, r.Method)
+		}
+		if want, got := "/api/v0/org", r.URL.Path; want != got {
+			t.Errorf("unexpected path: want %q, got %q", want, got)
+		}
+		if want, got := "api-token", r.Header.Get("X-Api-Key"); want != got {
+			t.Errorf("unexpected api key: want %q, got %q", want, got)
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Write([]byte(`{"name":"example-org"}`))
+	}))
+
+	org, err := client.GetOrg(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := "example-org", org.Name; want != got {
+		t.Errorf("unexpected org name: want %q, got %q", want, got)
+	}
+}
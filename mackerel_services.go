@@ -0,0 +1,41 @@
+package forwarder
+
+import (
+	"context"
+	"net/http"
+)
+
+// Service is a service on Mackerel.
+type Service struct {
+	Name  string   `json:"name"`
+	Memo  string   `json:"memo"`
+	Roles []string `json:"roles,omitempty"`
+}
+
+// FindServices returns the services of the organization.
+func (c *MackerelClient) FindServices(ctx context.Context) ([]Service, error) {
+	var resp struct {
+		Services []Service `json:"services"`
+	}
+	err := c.retry(ctx, func() error {
+		return c.doJSON(ctx, http.MethodGet, "api/v0/services", nil, &resp)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Services, nil
+}
+
+// CreateService creates a new service.
+func (c *MackerelClient) CreateService(ctx context.Context, service *Service) error {
+	payload := struct {
+		Name string `json:"name"`
+		Memo string `json:"memo"`
+	}{
+		Name: service.Name,
+		Memo: service.Memo,
+	}
+	return c.retry(ctx, func() error {
+		return c.doJSON(ctx, http.MethodPost, "api/v0/services", &payload, nil)
+	})
+}
@@ -0,0 +1,57 @@
+package forwarder
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFindServices(t *testing.T) {
+	client := newTestMackerelClient(t, http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			t.Errorf("unexpected method: want %s, got %s", http.MethodGet, r.Method)
+		}
+		if want, got := "/api/v0/services", r.URL.Path; want != got {
+			t.Errorf("unexpected path: want %q, got %q", want, got)
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Write([]byte(`{"services":[{"name":"foo","memo":"","roles":["db"]}]}`))
+	}))
+
+	got, err := client.FindServices(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Service{{Name: "foo", Roles: []string{"db"}}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("services mismatch: (-want/+got):\n%s", diff)
+	}
+}
+
+func TestCreateService(t *testing.T) {
+	client := newTestMackerelClient(t, http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("unexpected method: want %s, got %s", http.MethodPost, r.Method)
+		}
+		if want, got := "/api/v0/services", r.URL.Path; want != got {
+			t.Errorf("unexpected path: want %q, got %q", want, got)
+		}
+		var payload map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Fatal(err)
+		}
+		want := map[string]any{"name": "foo", "memo": "memo"}
+		if diff := cmp.Diff(want, payload); diff != "" {
+			t.Errorf("payload mismatch: (-want/+got):\n%s", diff)
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Write([]byte(`{"name":"foo","memo":"memo","roles":[]}`))
+	}))
+
+	if err := client.CreateService(context.Background(), &Service{Name: "foo", Memo: "memo"}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -13,6 +13,7 @@ import (
 	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"golang.org/x/time/rate"
 )
 
 func TestPostServiceMetricValues(t *testing.T) {
@@ -251,3 +252,64 @@ func TestPostServiceMetricValues_Error(t *testing.T) {
 		t.Errorf("unexpected api call count: want %d, got %d", want, got)
 	}
 }
+
+func TestMackerelClient_RateLimiter(t *testing.T) {
+	var count atomic.Int32
+	client := newTestMackerelClient(t, http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		count.Add(1)
+		rw.WriteHeader(http.StatusOK)
+	}))
+	client.RateLimiter = rate.NewLimiter(rate.Every(100*time.Millisecond), 1)
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		err := client.PostHostMetricValues(context.Background(), []HostMetricValue{
+			{HostID: "host-abc", Name: "custom.foo", Time: 1234567890, Value: 1},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("the requests are not limited: %s", elapsed)
+	}
+	if count.Load() != 3 {
+		t.Errorf("unexpected count of the requests: %d", count.Load())
+	}
+}
+
+func TestMackerelClient_Hooks(t *testing.T) {
+	client := newTestMackerelClient(t, http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if want, got := "audit-id", r.Header.Get("X-Audit-Id"); want != got {
+			t.Errorf("unexpected audit header: want %q, got %q", want, got)
+		}
+		if want, got := "custom-agent", r.Header.Get("User-Agent"); want != got {
+			t.Errorf("unexpected user agent: want %q, got %q", want, got)
+		}
+		rw.WriteHeader(http.StatusOK)
+	}))
+	client.RequestMiddleware = []func(*http.Request){
+		func(req *http.Request) { req.Header.Set("X-Audit-Id", "audit-id") },
+		func(req *http.Request) { req.Header.Set("User-Agent", "custom-agent") },
+	}
+	var statuses []int
+	client.ResponseHooks = []func(*http.Request, *http.Response, error){
+		func(req *http.Request, resp *http.Response, err error) {
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			statuses = append(statuses, resp.StatusCode)
+		},
+	}
+
+	err := client.PostServiceMetricValues(context.Background(), "awesome-service", []ServiceMetricValue{
+		{Name: "foo", Time: 1234567860, Value: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]int{http.StatusOK}, statuses); diff != "" {
+		t.Errorf("statuses mismatch: (-want/+got):\n%s", diff)
+	}
+}
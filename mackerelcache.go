@@ -0,0 +1,80 @@
+package forwarder
+
+import (
+	"sync"
+	"time"
+)
+
+// mackerelCache is the caches of the hosts and the services of an organization on Mackerel.
+// The invocations with apiKeyParameter in the input post to other organizations,
+// so the caches are kept for each API key, and never shared between the organizations.
+type mackerelCache struct {
+	muHosts sync.Mutex
+	hostIDs map[string]cachedHostID // custom identifier -> host id
+
+	muHostList        sync.Mutex
+	hostList          map[string]bool // host id -> true
+	hostListExpiresAt time.Time
+
+	muRetiredHosts sync.Mutex
+	retiredHosts   map[string]time.Time // host id -> the time until which the host is skipped
+
+	muServices sync.Mutex
+	services   map[string]bool // the names of the services that exist on Mackerel
+}
+
+// cachedHostID is the host id resolved from a custom identifier.
+// It expires with the same TTL as the host list, so that the host retired and registered again is resolved again.
+type cachedHostID struct {
+	id        string
+	expiresAt time.Time
+}
+
+// hostID returns the cached host id of the custom identifier.
+// It must be called with muHosts held.
+func (c *mackerelCache) hostID(customIdentifier string, now time.Time) (string, bool) {
+	h, ok := c.hostIDs[customIdentifier]
+	if !ok {
+		return "", false
+	}
+	if !now.Before(h.expiresAt) {
+		delete(c.hostIDs, customIdentifier)
+		return "", false
+	}
+	return h.id, true
+}
+
+// setHostID caches the host id of the custom identifier until expiresAt.
+// It must be called with muHosts held.
+func (c *mackerelCache) setHostID(customIdentifier, id string, expiresAt time.Time) {
+	if c.hostIDs == nil {
+		c.hostIDs = make(map[string]cachedHostID)
+	}
+	c.hostIDs[customIdentifier] = cachedHostID{id: id, expiresAt: expiresAt}
+}
+
+// forgetHost removes the host id from the cache, e.g. when the host is retired.
+func (c *mackerelCache) forgetHost(id string) {
+	c.muHosts.Lock()
+	defer c.muHosts.Unlock()
+	for customIdentifier, h := range c.hostIDs {
+		if h.id == id {
+			delete(c.hostIDs, customIdentifier)
+		}
+	}
+}
+
+// mackerelCache returns the caches of the organization of the client.
+func (f *Forwarder) mackerelCache(client *MackerelClient) *mackerelCache {
+	f.muMackerelCaches.Lock()
+	defer f.muMackerelCaches.Unlock()
+	if c, ok := f.mackerelCaches[client.APIKey]; ok {
+		return c
+	}
+	if f.mackerelCaches == nil {
+		f.mackerelCaches = make(map[string]*mackerelCache)
+	}
+	c := &mackerelCache{}
+	f.mackerelCaches[client.APIKey] = c
+	return c
+}
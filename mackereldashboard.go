@@ -0,0 +1,135 @@
+package forwarder
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+const (
+	// dashboardColumns is the number of the columns of the dashboards on Mackerel.
+	dashboardColumns = 24
+
+	dashboardGraphWidth   = 8
+	dashboardGraphHeight  = 6
+	dashboardHeaderHeight = 2
+)
+
+// dashboardTarget is the service or the host of the graphs.
+type dashboardTarget struct {
+	service string
+	host    string
+}
+
+// GenerateDashboard returns the custom dashboard of Mackerel with the graphs of the forwarded metrics.
+// The graphs are grouped by the services and the hosts in the order of the queries,
+// and each group has a header of the markdown widget.
+//
+// The queries that don't return data and the invalid queries are skipped.
+// The services and the hosts with the templates, e.g. "#{tag:team}", are also skipped,
+// because they are resolved on forwarding.
+func GenerateDashboard(queries []*Query, title, urlPath string) *Dashboard {
+	resolved, _ := prepareQueries(queries)
+
+	var targets []dashboardTarget
+	graphs := make(map[dashboardTarget][]string)
+	seen := make(map[dashboardTarget]map[string]bool)
+	for _, q := range resolved {
+		if !q.Query.returnData() || hasNameTemplate(q.Label.Service) || hasNameTemplate(q.Label.HostID) {
+			continue
+		}
+		target := dashboardTarget{service: q.Label.Service, host: q.Label.HostID}
+		if _, ok := seen[target]; !ok {
+			targets = append(targets, target)
+			seen[target] = make(map[string]bool)
+		}
+		name := dashboardGraphName(q)
+		if seen[target][name] {
+			continue
+		}
+		seen[target][name] = true
+		graphs[target] = append(graphs[target], name)
+	}
+
+	dashboard := &Dashboard{
+		Title:   title,
+		Memo:    "generated by mackerel-cloudwatch-forwarder",
+		URLPath: urlPath,
+	}
+	y := 0
+	for _, target := range targets {
+		header := "service: " + target.service
+		graph := DashboardGraph{Type: "service", ServiceName: target.service}
+		if target.host != "" {
+			header = "host: " + target.host
+			graph = DashboardGraph{Type: "host", HostID: target.host}
+		}
+		dashboard.Widgets = append(dashboard.Widgets, DashboardWidget{
+			Type:     "markdown",
+			Title:    header,
+			Markdown: "## " + header,
+			Layout:   DashboardLayout{X: 0, Y: y, Width: dashboardColumns, Height: dashboardHeaderHeight},
+		})
+		y += dashboardHeaderHeight
+
+		for i, name := range graphs[target] {
+			g := graph
+			g.Name = name
+			x := (i * dashboardGraphWidth) % dashboardColumns
+			if i > 0 && x == 0 {
+				y += dashboardGraphHeight
+			}
+			dashboard.Widgets = append(dashboard.Widgets, DashboardWidget{
+				Type:   "graph",
+				Title:  name,
+				Graph:  &g,
+				Layout: DashboardLayout{X: x, Y: y, Width: dashboardGraphWidth, Height: dashboardGraphHeight},
+			})
+		}
+		y += dashboardGraphHeight
+	}
+	return dashboard
+}
+
+// dashboardGraphName returns the name of the graph on Mackerel that the metric of the query belongs to.
+// Mackerel groups the metrics into the graphs by the names without the last parts, e.g. "sqs.messages" for "sqs.messages.visible".
+// The placeholders of the templates are replaced with the wildcards.
+func dashboardGraphName(q *metricQuery) string {
+	name := namePlaceholder.ReplaceAllString(q.Label.MetricName, "*")
+	if q.Query.TopN > 0 {
+		// the metrics are forwarded as "<name>.<the values of the wildcard dimensions>".
+		return name
+	}
+	if i := strings.LastIndexByte(name, '.'); i > 0 {
+		return name[:i]
+	}
+	return name
+}
+
+// SyncDashboard creates the dashboard on Mackerel, or replaces the existing one that has the same URLPath.
+// It returns the dashboard on Mackerel.
+func (f *Forwarder) SyncDashboard(ctx context.Context, dashboard *Dashboard) (*Dashboard, error) {
+	client, err := f.mackerel(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("forwarder: failed to configure the mackerel client: %w", err)
+	}
+	list, err := client.FindDashboards(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("forwarder: failed to get the dashboard list: %w", err)
+	}
+	for _, d := range list {
+		if d.URLPath != dashboard.URLPath {
+			continue
+		}
+		ret, err := client.UpdateDashboard(ctx, d.ID, dashboard)
+		if err != nil {
+			return nil, fmt.Errorf("forwarder: failed to update the dashboard %s: %w", d.ID, err)
+		}
+		return ret, nil
+	}
+	ret, err := client.CreateDashboard(ctx, dashboard)
+	if err != nil {
+		return nil, fmt.Errorf("forwarder: failed to create the dashboard: %w", err)
+	}
+	return ret, nil
+}
@@ -0,0 +1,99 @@
+package forwarder
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestGenerateDashboard(t *testing.T) {
+	queries, err := ParseQueries([]byte(`[
+		{"service": "foo", "name": "sqs.messages.visible", "metric": ["AWS/SQS", "ApproximateNumberOfMessagesVisible", "QueueName", "q1"], "stat": "Maximum"},
+		{"service": "foo", "name": "sqs.messages.delayed", "metric": [".", "ApproximateNumberOfMessagesDelayed", ".", "."], "stat": "Maximum"},
+		{"service": "foo", "name": "sqs.age", "metric": [".", "ApproximateAgeOfOldestMessage", ".", "."], "stat": "Maximum"},
+		{"service": "foo", "name": "sqs.queues.#{QueueName}.sent", "metric": [".", "NumberOfMessagesSent", "QueueName", "*"], "stat": "Sum"},
+		{"service": "foo", "name": "lambda.errors", "metric": ["AWS/Lambda", "Errors", "FunctionName", "*"], "stat": "Sum", "topN": 3},
+		{"host": "host-abc", "name": "custom.rds.cpu", "metric": ["AWS/RDS", "CPUUtilization", "DBInstanceIdentifier", "db1"], "stat": "Average"},
+		{"id": "hidden", "metric": ["AWS/RDS", "FreeableMemory", "DBInstanceIdentifier", "db1"], "stat": "Average", "returnData": false},
+		{"service": "#{tag:team}", "name": "sqs.sent", "metric": ["AWS/SQS", "NumberOfMessagesSent", "QueueName", "q1"], "stat": "Sum", "resourceArn": "arn:aws:sqs:ap-northeast-1:123456789012:q1"}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := GenerateDashboard(queries, "CloudWatch", "cloudwatch")
+	want := &Dashboard{
+		Title:   "CloudWatch",
+		Memo:    "generated by mackerel-cloudwatch-forwarder",
+		URLPath: "cloudwatch",
+		Widgets: []DashboardWidget{
+			{Type: "markdown", Title: "service: foo", Markdown: "## service: foo", Layout: DashboardLayout{X: 0, Y: 0, Width: 24, Height: 2}},
+			{Type: "graph", Title: "sqs.messages", Graph: &DashboardGraph{Type: "service", ServiceName: "foo", Name: "sqs.messages"}, Layout: DashboardLayout{X: 0, Y: 2, Width: 8, Height: 6}},
+			{Type: "graph", Title: "sqs", Graph: &DashboardGraph{Type: "service", ServiceName: "foo", Name: "sqs"}, Layout: DashboardLayout{X: 8, Y: 2, Width: 8, Height: 6}},
+			{Type: "graph", Title: "sqs.queues.*", Graph: &DashboardGraph{Type: "service", ServiceName: "foo", Name: "sqs.queues.*"}, Layout: DashboardLayout{X: 16, Y: 2, Width: 8, Height: 6}},
+			{Type: "graph", Title: "lambda.errors", Graph: &DashboardGraph{Type: "service", ServiceName: "foo", Name: "lambda.errors"}, Layout: DashboardLayout{X: 0, Y: 8, Width: 8, Height: 6}},
+			{Type: "markdown", Title: "host: host-abc", Markdown: "## host: host-abc", Layout: DashboardLayout{X: 0, Y: 14, Width: 24, Height: 2}},
+			{Type: "graph", Title: "custom.rds", Graph: &DashboardGraph{Type: "host", HostID: "host-abc", Name: "custom.rds"}, Layout: DashboardLayout{X: 0, Y: 16, Width: 8, Height: 6}},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("dashboard mismatch: (-want/+got):\n%s", diff)
+	}
+}
+
+func TestSyncDashboard(t *testing.T) {
+	var mu sync.Mutex
+	var requests []string
+	existing := `[]`
+	client := newTestMackerelClient(t, http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		rw.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodGet {
+			rw.Write([]byte(`{"dashboards":` + existing + `}`))
+			return
+		}
+		var d Dashboard
+		if err := json.NewDecoder(r.Body).Decode(&d); err != nil {
+			t.Fatal(err)
+		}
+		d.ID = "abc"
+		json.NewEncoder(rw).Encode(d)
+	}))
+	f := &Forwarder{
+		svcmackerel: client,
+	}
+	dashboard := &Dashboard{Title: "CloudWatch", URLPath: "cloudwatch"}
+
+	// create a new dashboard.
+	got, err := f.SyncDashboard(context.Background(), dashboard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != "abc" {
+		t.Errorf("unexpected id: want %q, got %q", "abc", got.ID)
+	}
+
+	// the dashboard of the same url path is replaced.
+	mu.Lock()
+	existing = `[{"id":"abc","title":"old","memo":"","urlPath":"cloudwatch"},{"id":"def","title":"other","memo":"","urlPath":"other"}]`
+	mu.Unlock()
+	if _, err := f.SyncDashboard(context.Background(), dashboard); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"GET /api/v0/dashboards",
+		"POST /api/v0/dashboards",
+		"GET /api/v0/dashboards",
+		"PUT /api/v0/dashboards/abc",
+	}
+	if diff := cmp.Diff(want, requests); diff != "" {
+		t.Errorf("requests mismatch: (-want/+got):\n%s", diff)
+	}
+}
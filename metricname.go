@@ -0,0 +1,101 @@
+package forwarder
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// maxMetricNameLengthOnMackerel is the maximum length of the metric names on Mackerel.
+const maxMetricNameLengthOnMackerel = 255
+
+// validateMetricName validates the metric name against the rule of Mackerel.
+// The metric name consists of letters, numbers, ".", "-" and "_".
+func validateMetricName(name string) error {
+	if name == "" {
+		return fmt.Errorf("forwarder: metric name is empty")
+	}
+	if len(name) > maxMetricNameLengthOnMackerel {
+		return fmt.Errorf("forwarder: metric name is too long: %q", name)
+	}
+	for _, r := range name {
+		if !isMetricNameChar(r) && r != '.' {
+			return fmt.Errorf("forwarder: invalid character %q in the metric name: %q", r, name)
+		}
+	}
+	return nil
+}
+
+// sanitizeMetricName replaces the invalid characters in the metric name with "_",
+// and truncates it to the maximum length.
+func sanitizeMetricName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if isMetricNameChar(r) || r == '.' {
+			return r
+		}
+		return '_'
+	}, name)
+	if len(name) > maxMetricNameLengthOnMackerel {
+		name = name[:maxMetricNameLengthOnMackerel]
+	}
+	return name
+}
+
+// sanitizeMetricNames reports whether the invalid metric names are sanitized.
+func (f *Forwarder) sanitizeMetricNames() bool {
+	if f.SanitizeMetricNames {
+		return true
+	}
+	return f.env().SanitizeMetricNames
+}
+
+// normalizeMetricName validates the metric name.
+// If the name is invalid, it is sanitized when the sanitization is enabled, otherwise it is rejected.
+func (f *Forwarder) normalizeMetricName(ctx context.Context, name string) (string, bool) {
+	err := validateMetricName(name)
+	if err == nil {
+		return name, true
+	}
+	if f.sanitizeMetricNames() && name != "" {
+		sanitized := sanitizeMetricName(name)
+		f.logger().WarnContext(ctx, "invalid metric name, sanitized",
+			"name", name,
+			"sanitized", sanitized,
+		)
+		return sanitized, true
+	}
+	f.logger().WarnContext(ctx, "invalid metric name, skips",
+		"error", err.Error(),
+	)
+	return "", false
+}
+
+// normalizeServiceMetrics normalizes the metric names of the service metrics.
+func (f *Forwarder) normalizeServiceMetrics(ctx context.Context, m serviceMetricsType) serviceMetricsType {
+	var ret serviceMetricsType
+	for service, metrics := range m {
+		for _, v := range metrics {
+			name, ok := f.normalizeMetricName(ctx, v.Name)
+			if !ok {
+				continue
+			}
+			v.Name = name
+			ret.Append(service, v)
+		}
+	}
+	return ret
+}
+
+// normalizeHostMetrics normalizes the metric names of the host metrics.
+func (f *Forwarder) normalizeHostMetrics(ctx context.Context, m hostMetricsType) hostMetricsType {
+	ret := make(hostMetricsType, 0, len(m))
+	for _, v := range m {
+		name, ok := f.normalizeMetricName(ctx, v.Name)
+		if !ok {
+			continue
+		}
+		v.Name = name
+		ret.Append(v)
+	}
+	return ret
+}
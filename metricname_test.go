@@ -0,0 +1,56 @@
+package forwarder
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestValidateMetricName(t *testing.T) {
+	valid := []string{"custom.foo-bar.baz_qux", "a"}
+	for _, name := range valid {
+		if err := validateMetricName(name); err != nil {
+			t.Errorf("%q should be valid: %v", name, err)
+		}
+	}
+	invalid := []string{"", "custom.foo bar", "custom.foo:bar", strings.Repeat("a", 256)}
+	for _, name := range invalid {
+		if err := validateMetricName(name); err == nil {
+			t.Errorf("%q should be invalid", name)
+		}
+	}
+}
+
+func TestNormalizeServiceMetrics(t *testing.T) {
+	metrics := serviceMetricsType{
+		"foo": {
+			{Name: "custom.ok", Time: 1234567860, Value: 1},
+			{Name: "custom.invalid name", Time: 1234567860, Value: 2},
+		},
+	}
+
+	f := &Forwarder{}
+	got := f.normalizeServiceMetrics(context.Background(), metrics)
+	want := serviceMetricsType{
+		"foo": {
+			{Name: "custom.ok", Time: 1234567860, Value: 1},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("metrics mismatch: (-want/+got):\n%s", diff)
+	}
+
+	f = &Forwarder{SanitizeMetricNames: true}
+	got = f.normalizeServiceMetrics(context.Background(), metrics)
+	want = serviceMetricsType{
+		"foo": {
+			{Name: "custom.ok", Time: 1234567860, Value: 1},
+			{Name: "custom.invalid_name", Time: 1234567860, Value: 2},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("metrics mismatch: (-want/+got):\n%s", diff)
+	}
+}
@@ -0,0 +1,224 @@
+package forwarder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// managedMonitorMemo is the memo of the monitors synced by the forwarder.
+const managedMonitorMemo = "managed by mackerel-cloudwatch-forwarder"
+
+// MonitorRule is the definition of the monitor of the metric on Mackerel, which is synced by SyncMonitors.
+// It is the host metric monitor for the host metrics, and the service metric monitor for the service metrics.
+type MonitorRule struct {
+	// Name is the name of the monitor, which identifies the monitor to update.
+	// If it is empty, the metric name is used.
+	Name string `json:"name,omitempty"`
+
+	// Warning is the threshold for the warning alerts.
+	Warning *float64 `json:"warning,omitempty"`
+
+	// Critical is the threshold for the critical alerts.
+	Critical *float64 `json:"critical,omitempty"`
+
+	// Operator is the comparison operator for the thresholds.
+	// It is one of ">" and "<". The default is ">".
+	Operator string `json:"operator,omitempty"`
+
+	// Duration is the number of the data points averaged for the evaluation. The default is 1.
+	Duration int `json:"duration,omitempty"`
+
+	// Scopes are the scopes of the host metric monitor, e.g. "service" and "service:role".
+	// Mackerel doesn't scope the monitors by the hosts, so the monitor applies to all the hosts without them.
+	Scopes []string `json:"scopes,omitempty"`
+}
+
+// GenerateMonitors returns the monitors on Mackerel of the queries with the monitor rules.
+// It returns QueryErrors if some rules are invalid.
+func GenerateMonitors(queries []*Query) ([]*Monitor, error) {
+	resolved, errs := prepareQueries(queries)
+
+	var ret []*Monitor
+	names := make(map[string]int)
+	for _, q := range resolved {
+		rule := q.Query.Monitor
+		if rule == nil {
+			continue
+		}
+		m, err := rule.monitor(q)
+		if err == nil {
+			if j, ok := names[m.Name]; ok {
+				err = fmt.Errorf("duplicated monitor name %q, conflicts with query[%d]", m.Name, j)
+			}
+		}
+		if err != nil {
+			errs = append(errs, &QueryError{Index: q.Index, Err: err})
+			continue
+		}
+		names[m.Name] = q.Index
+		ret = append(ret, m)
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return ret, nil
+}
+
+func (r *MonitorRule) monitor(q *metricQuery) (*Monitor, error) {
+	if !q.Query.returnData() {
+		return nil, errors.New("the monitor requires the metric forwarded to Mackerel")
+	}
+	label := q.Label
+	if hasNameTemplate(label.MetricName) || hasNameTemplate(label.Service) || q.Query.TopN > 0 {
+		return nil, errors.New("the monitor is not available for the metrics with the templates")
+	}
+	if r.Warning == nil && r.Critical == nil {
+		return nil, errors.New("the monitor requires the warning or critical threshold")
+	}
+	op := r.Operator
+	switch op {
+	case "":
+		op = ">"
+	case ">", "<":
+	default:
+		return nil, fmt.Errorf("unknown operator of the monitor: %q", r.Operator)
+	}
+	if r.Duration < 0 {
+		return nil, fmt.Errorf("the duration of the monitor must be positive: %d", r.Duration)
+	}
+
+	m := &Monitor{
+		Type:     monitorTypeHost,
+		Name:     r.Name,
+		Memo:     managedMonitorMemo,
+		Metric:   label.MetricName,
+		Operator: op,
+		Warning:  r.Warning,
+		Critical: r.Critical,
+		Duration: max(r.Duration, 1),
+		Scopes:   r.Scopes,
+	}
+	if m.Name == "" {
+		m.Name = label.MetricName
+	}
+	if label.Service != "" {
+		if len(r.Scopes) > 0 {
+			return nil, errors.New("the scopes are available only for the host metrics")
+		}
+		m.Type = monitorTypeService
+		m.Service = label.Service
+	}
+	return m, nil
+}
+
+// SyncMonitors creates the monitors on Mackerel, or updates the existing ones that have the same names.
+// The monitors that are not changed are left as they are, so it is safe to run it repeatedly.
+// The monitors on Mackerel that are not in monitors are not deleted.
+//
+// Only the monitors that have the memo of the forwarder are updated,
+// and the other monitors that have the same names are reported as the errors.
+// The settings that the forwarder doesn't manage, e.g. notificationInterval and isMute, are kept on updating.
+func (f *Forwarder) SyncMonitors(ctx context.Context, monitors []*Monitor) error {
+	client, err := f.mackerel(ctx)
+	if err != nil {
+		return fmt.Errorf("forwarder: failed to configure the mackerel client: %w", err)
+	}
+	list, err := client.findMonitorsJSON(ctx)
+	if err != nil {
+		return fmt.Errorf("forwarder: failed to get the monitor list: %w", err)
+	}
+	existing := make(map[string]*Monitor, len(list))
+	raws := make(map[string]json.RawMessage, len(list))
+	for _, raw := range list {
+		var m Monitor
+		if err := json.Unmarshal(raw, &m); err != nil {
+			return fmt.Errorf("forwarder: failed to parse the monitor list: %w", err)
+		}
+		existing[m.Name] = &m
+		raws[m.Name] = raw
+	}
+
+	var errs []error
+	for _, m := range monitors {
+		old, ok := existing[m.Name]
+		if !ok {
+			created, err := client.CreateMonitor(ctx, m)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("forwarder: failed to create the monitor %q: %w", m.Name, err))
+				continue
+			}
+			f.logger().InfoContext(ctx, "the monitor is created", "name", m.Name, "id", created.ID)
+			continue
+		}
+		if old.Memo != managedMonitorMemo {
+			errs = append(errs, fmt.Errorf("forwarder: the monitor %q (%s) is not managed by the forwarder, rename either of them", m.Name, old.ID))
+			continue
+		}
+		if old.Type != m.Type {
+			errs = append(errs, fmt.Errorf("forwarder: the monitor %q is the %s monitor, but want the %s metric monitor", m.Name, old.Type, m.Type))
+			continue
+		}
+		if sameMonitor(old, m) {
+			f.logger().DebugContext(ctx, "the monitor is up to date", "name", m.Name, "id", old.ID)
+			continue
+		}
+		payload, err := mergeMonitor(raws[m.Name], m)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("forwarder: failed to update the monitor %q: %w", m.Name, err))
+			continue
+		}
+		if _, err := client.updateMonitorJSON(ctx, old.ID, payload); err != nil {
+			errs = append(errs, fmt.Errorf("forwarder: failed to update the monitor %q: %w", m.Name, err))
+			continue
+		}
+		f.logger().InfoContext(ctx, "the monitor is updated", "name", m.Name, "id", old.ID)
+	}
+	return errors.Join(errs...)
+}
+
+// mergeMonitor returns the JSON of the monitor on Mackerel whose fields of Monitor are replaced with m.
+// The other fields, e.g. notificationInterval, isMute, excludeScopes and maxCheckAttempts, are kept as they are.
+func mergeMonitor(raw json.RawMessage, m *Monitor) (json.RawMessage, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	var managed map[string]json.RawMessage
+	if err := json.Unmarshal(data, &managed); err != nil {
+		return nil, err
+	}
+
+	// the scopes are omitted for all the hosts.
+	delete(fields, "scopes")
+	maps.Copy(fields, managed)
+	delete(fields, "id")
+	return json.Marshal(fields)
+}
+
+// sameMonitor reports whether the settings of the monitors are the same, regardless of their ids.
+func sameMonitor(a, b *Monitor) bool {
+	sameThreshold := func(x, y *float64) bool {
+		if x == nil || y == nil {
+			return x == y
+		}
+		return *x == *y
+	}
+	return a.Type == b.Type &&
+		a.Name == b.Name &&
+		a.Memo == b.Memo &&
+		a.Service == b.Service &&
+		a.Metric == b.Metric &&
+		a.Operator == b.Operator &&
+		sameThreshold(a.Warning, b.Warning) &&
+		sameThreshold(a.Critical, b.Critical) &&
+		a.Duration == b.Duration &&
+		slices.Equal(a.Scopes, b.Scopes)
+}
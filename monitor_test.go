@@ -0,0 +1,164 @@
+package forwarder
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestGenerateMonitors(t *testing.T) {
+	queries, err := ParseQueries([]byte(`[
+		{"service": "foo", "name": "sqs.visible", "metric": ["AWS/SQS", "ApproximateNumberOfMessagesVisible", "QueueName", "q1"], "stat": "Maximum",
+		 "monitor": {"name": "queue backlog", "warning": 100, "critical": 1000, "duration": 5}},
+		{"host": "host-abc", "name": "custom.rds.cpu", "metric": ["AWS/RDS", "CPUUtilization", "DBInstanceIdentifier", "db1"], "stat": "Average",
+		 "monitor": {"critical": 90, "scopes": ["foo:db"]}},
+		{"service": "foo", "name": "sqs.sent", "metric": ["AWS/SQS", "NumberOfMessagesSent", "QueueName", "q1"], "stat": "Sum"}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := GenerateMonitors(queries)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	warning, critical, cpu := 100.0, 1000.0, 90.0
+	want := []*Monitor{
+		{
+			Type:     "service",
+			Name:     "queue backlog",
+			Memo:     managedMonitorMemo,
+			Service:  "foo",
+			Metric:   "sqs.visible",
+			Operator: ">",
+			Warning:  &warning,
+			Critical: &critical,
+			Duration: 5,
+		},
+		{
+			Type:     "host",
+			Name:     "custom.rds.cpu",
+			Memo:     managedMonitorMemo,
+			Metric:   "custom.rds.cpu",
+			Operator: ">",
+			Critical: &cpu,
+			Duration: 1,
+			Scopes:   []string{"foo:db"},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("monitors mismatch: (-want/+got):\n%s", diff)
+	}
+}
+
+func TestGenerateMonitors_Invalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+	}{
+		{"no threshold", `{"service": "foo", "name": "sqs.visible", "metric": ["AWS/SQS", "ApproximateNumberOfMessagesVisible"], "stat": "Sum", "monitor": {}}`},
+		{"template", `{"service": "foo", "name": "sqs.#{QueueName}.visible", "metric": ["AWS/SQS", "ApproximateNumberOfMessagesVisible", "QueueName", "*"], "stat": "Sum", "monitor": {"critical": 1}}`},
+		{"scopes of service metric", `{"service": "foo", "name": "sqs.visible", "metric": ["AWS/SQS", "ApproximateNumberOfMessagesVisible"], "stat": "Sum", "monitor": {"critical": 1, "scopes": ["foo"]}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			queries, err := ParseQueries([]byte("[" + tt.query + "]"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := GenerateMonitors(queries); err == nil {
+				t.Error("want an error, got nil")
+			}
+		})
+	}
+
+	// the names of the monitors must be unique.
+	queries, err := ParseQueries([]byte(`[
+		{"service": "foo", "name": "sqs.visible", "metric": ["AWS/SQS", "ApproximateNumberOfMessagesVisible"], "stat": "Sum", "monitor": {"name": "sqs", "critical": 1}},
+		{"service": "foo", "name": "sqs.sent", "metric": ["AWS/SQS", "NumberOfMessagesSent"], "stat": "Sum", "monitor": {"name": "sqs", "critical": 1}}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GenerateMonitors(queries); err == nil {
+		t.Error("want an error for the duplicated names, got nil")
+	}
+}
+
+func TestSyncMonitors(t *testing.T) {
+	critical := 100.0
+	unchanged := &Monitor{Type: "service", Name: "unchanged", Memo: managedMonitorMemo, Service: "foo", Metric: "a", Operator: ">", Critical: &critical, Duration: 1}
+	changed := &Monitor{Type: "service", Name: "changed", Memo: managedMonitorMemo, Service: "foo", Metric: "b", Operator: ">", Critical: &critical, Duration: 5}
+	created := &Monitor{Type: "host", Name: "created", Memo: managedMonitorMemo, Metric: "custom.c", Operator: "<", Critical: &critical, Duration: 1}
+
+	var mu sync.Mutex
+	var requests []string
+	var updated map[string]any
+	client := newTestMackerelClient(t, http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		rw.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodGet {
+			rw.Write([]byte(`{"monitors":[
+				{"id":"m1","type":"service","name":"unchanged","memo":"managed by mackerel-cloudwatch-forwarder","service":"foo","metric":"a","operator":">","warning":null,"critical":100,"duration":1},
+				{"id":"m2","type":"service","name":"changed","memo":"managed by mackerel-cloudwatch-forwarder","service":"foo","metric":"b","operator":">","warning":null,"critical":100,"duration":1,"scopes":["foo:web"],"notificationInterval":60,"isMute":true,"maxCheckAttempts":3},
+				{"id":"m3","type":"connectivity","name":"conflict","memo":""},
+				{"id":"m4","type":"service","name":"unmanaged","memo":"created by hand","service":"foo","metric":"e","operator":">","warning":null,"critical":100,"duration":1}
+			]}`))
+			return
+		}
+		var m map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+			t.Fatal(err)
+		}
+		if r.Method == http.MethodPut {
+			updated = maps.Clone(m)
+		}
+		m["id"] = "new"
+		json.NewEncoder(rw).Encode(m)
+	}))
+	f := &Forwarder{
+		svcmackerel: client,
+	}
+
+	conflict := &Monitor{Type: "service", Name: "conflict", Memo: managedMonitorMemo, Service: "foo", Metric: "d", Operator: ">", Critical: &critical, Duration: 1}
+	unmanaged := &Monitor{Type: "service", Name: "unmanaged", Memo: managedMonitorMemo, Service: "foo", Metric: "e", Operator: ">", Critical: &critical, Duration: 5}
+	err := f.SyncMonitors(context.Background(), []*Monitor{unchanged, changed, created, conflict, unmanaged})
+	if err == nil {
+		t.Error("want an error for the conflicting monitors, got nil")
+	}
+
+	want := []string{
+		"GET /api/v0/monitors",
+		"PUT /api/v0/monitors/m2",
+		"POST /api/v0/monitors",
+	}
+	if diff := cmp.Diff(want, requests); diff != "" {
+		t.Errorf("requests mismatch: (-want/+got):\n%s", diff)
+	}
+
+	// the settings that the forwarder doesn't manage are kept.
+	wantUpdated := map[string]any{
+		"type":                 "service",
+		"name":                 "changed",
+		"memo":                 managedMonitorMemo,
+		"service":              "foo",
+		"metric":               "b",
+		"operator":             ">",
+		"warning":              nil,
+		"critical":             100.0,
+		"duration":             5.0,
+		"notificationInterval": 60.0,
+		"isMute":               true,
+		"maxCheckAttempts":     3.0,
+	}
+	if diff := cmp.Diff(wantUpdated, updated); diff != "" {
+		t.Errorf("updated monitor mismatch: (-want/+got):\n%s", diff)
+	}
+}
@@ -0,0 +1,185 @@
+package forwarder
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+)
+
+// namePlaceholder is the placeholder in the templates of the names, e.g. "sqs.#{QueueName}.visible_messages".
+// It is replaced with the dimension value, or the tag value of the resource if it starts with tagPlaceholderPrefix.
+var namePlaceholder = regexp.MustCompile(`#\{([^{}]*)\}`)
+
+// tagPlaceholderPrefix is the prefix of the placeholders of the resource tags, e.g. "#{tag:team}".
+const tagPlaceholderPrefix = "tag:"
+
+// hasNameTemplate reports whether the name has placeholders.
+func hasNameTemplate(name string) bool {
+	return namePlaceholder.MatchString(name)
+}
+
+// hasTagPlaceholder reports whether the name has placeholders of the resource tags.
+func hasTagPlaceholder(name string) bool {
+	for _, m := range namePlaceholder.FindAllStringSubmatch(name, -1) {
+		if strings.HasPrefix(m[1], tagPlaceholderPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// escapeNameValue sanitizes the value for the names on Mackerel.
+// "." in the value is replaced with "_", so that the value doesn't split the name into extra segments.
+func escapeNameValue(s string) string {
+	return sanitizeMetricName(strings.ReplaceAll(s, ".", "_"))
+}
+
+// renderTemplate replaces the placeholders with the dimension values and the tag values escaped by escape.
+func renderTemplate(tmpl string, dimensions []types.Dimension, tags map[string]string, escape func(string) string) (string, error) {
+	var err error
+	ret := namePlaceholder.ReplaceAllStringFunc(tmpl, func(s string) string {
+		key := namePlaceholder.FindStringSubmatch(s)[1]
+		if tag, ok := strings.CutPrefix(key, tagPlaceholderPrefix); ok {
+			if v, ok := tags[tag]; ok {
+				return escape(v)
+			}
+			if err == nil {
+				err = fmt.Errorf("the tag %q is not found", tag)
+			}
+			return s
+		}
+		for _, d := range dimensions {
+			if aws.ToString(d.Name) == key {
+				return escape(aws.ToString(d.Value))
+			}
+		}
+		if err == nil {
+			err = fmt.Errorf("unknown dimension in the template: %q", key)
+		}
+		return s
+	})
+	return ret, err
+}
+
+// renderNameTemplate replaces the placeholders in the metric name with the dimension values.
+func renderNameTemplate(name string, dimensions []types.Dimension) (string, error) {
+	return renderTemplate(name, dimensions, nil, escapeNameValue)
+}
+
+// validateTemplate validates that the placeholders refer the dimensions of the query.
+func validateTemplate(tmpl string, dimensions []types.Dimension, allowTags bool) error {
+	for _, m := range namePlaceholder.FindAllStringSubmatch(tmpl, -1) {
+		if strings.HasPrefix(m[1], tagPlaceholderPrefix) {
+			if !allowTags {
+				return fmt.Errorf("the tags are not available in the template: %q", tmpl)
+			}
+			continue
+		}
+		found := false
+		for _, d := range dimensions {
+			if aws.ToString(d.Name) == m[1] {
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("unknown dimension in the template: %q", m[1])
+		}
+	}
+	return nil
+}
+
+// resolveNameTemplate renders the template of the metric name with the dimensions of the query.
+// If the metrics of the wildcard dimension values are forwarded individually,
+// the template is kept as is, and rendered for each metric in the expansion.
+func (q *Query) resolveNameTemplate(name string, dimensions []types.Dimension) (string, error) {
+	return q.resolveTemplate(name, dimensions, false)
+}
+
+// resolveServiceTemplate renders the template of the service name with the dimensions of the query.
+// The templates with the tags are kept as is, and rendered by resolveServiceTemplates.
+func (q *Query) resolveServiceTemplate(service string, dimensions []types.Dimension) (string, error) {
+	if hasTagPlaceholder(service) && q.ResourceARN == "" {
+		return "", fmt.Errorf("the tags in the service name require resourceArn: %q", service)
+	}
+	if err := validateTemplate(q.ResourceARN, dimensions, false); err != nil {
+		return "", fmt.Errorf("invalid resourceArn: %w", err)
+	}
+	return q.resolveTemplate(service, dimensions, true)
+}
+
+func (q *Query) resolveTemplate(tmpl string, dimensions []types.Dimension, allowTags bool) (string, error) {
+	if !hasNameTemplate(tmpl) {
+		return tmpl, nil
+	}
+	if err := validateTemplate(tmpl, dimensions, allowTags); err != nil {
+		return "", err
+	}
+	if hasWildcard(dimensions) && (q.Aggregate == "" || q.TopN > 0) {
+		return tmpl, nil
+	}
+	for _, m := range namePlaceholder.FindAllStringSubmatch(tmpl, -1) {
+		for _, d := range dimensions {
+			if aws.ToString(d.Name) == m[1] && aws.ToString(d.Value) == dimensionWildcard {
+				return "", fmt.Errorf("the template refers the wildcard dimension %s, but it is aggregated into one metric", m[1])
+			}
+		}
+	}
+	if hasTagPlaceholder(tmpl) {
+		return tmpl, nil
+	}
+	return renderNameTemplate(tmpl, dimensions)
+}
+
+// resolveServiceTemplates renders the templates of the service names that are left by resolveQueries,
+// i.e. the ones of the metrics expanded from the wildcard dimension values and the ones with the tags.
+// The tags are fetched from the resources of resourceArn.
+// The queries whose service names can't be rendered are skipped with warnings.
+func (fctx *forwardContext) resolveServiceTemplates(ctx context.Context, queries []*metricQuery) []*metricQuery {
+	var arns []string
+	for _, q := range queries {
+		if q.Query.returnData() && hasTagPlaceholder(q.Label.Service) {
+			arn, _ := renderTemplate(q.Query.ResourceARN, q.Dimensions, nil, identity)
+			arns = appendUnique(arns, arn)
+		}
+	}
+	var tags map[string]map[string]string
+	if len(arns) > 0 {
+		var err error
+		tags, err = fctx.forwarder.cachedResourceTags(ctx, arns, fctx.forwarder.now())
+		if err != nil {
+			fctx.forwarder.logger().WarnContext(ctx, "failed to get the tags of the resources",
+				"error", err.Error(),
+			)
+		}
+	}
+
+	ret := make([]*metricQuery, 0, len(queries))
+	for _, q := range queries {
+		if !q.Query.returnData() || !hasNameTemplate(q.Label.Service) {
+			ret = append(ret, q)
+			continue
+		}
+		arn, _ := renderTemplate(q.Query.ResourceARN, q.Dimensions, nil, identity)
+		service, err := renderTemplate(q.Label.Service, q.Dimensions, tags[arn], escapeNameValue)
+		if err != nil {
+			fctx.forwarder.logger().WarnContext(withQueryIndex(ctx, q.Index), "failed to render the service name, skips",
+				"service", q.Label.Service,
+				"resourceArn", arn,
+				"error", err.Error(),
+			)
+			continue
+		}
+		mq := *q
+		mq.Label.Service = service
+		ret = append(ret, &mq)
+	}
+	return ret
+}
+
+func identity(s string) string {
+	return s
+}
@@ -0,0 +1,223 @@
+package forwarder
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRenderNameTemplate(t *testing.T) {
+	dimensions := []types.Dimension{
+		{Name: aws.String("QueueName"), Value: aws.String("my.queue:fifo")},
+		{Name: aws.String("Region"), Value: aws.String("ap-northeast-1")},
+	}
+	got, err := renderNameTemplate("sqs.#{QueueName}.#{Region}.visible_messages", dimensions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "sqs.my_queue_fifo.ap-northeast-1.visible_messages"; got != want {
+		t.Errorf("want %q, got %q", want, got)
+	}
+
+	if _, err := renderNameTemplate("sqs.#{Unknown}", dimensions); err == nil {
+		t.Error("want an error, got nil")
+	}
+}
+
+func TestResolveNameTemplate(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   *Query
+		want    string
+		wantErr bool
+	}{
+		{
+			name:  "static",
+			query: &Query{Service: "foo", Name: "sqs.#{QueueName}.visible", Metric: []interface{}{"AWS/SQS", "ApproximateNumberOfMessagesVisible", "QueueName", "queue"}, Stat: "Sum"},
+			want:  "sqs.queue.visible",
+		},
+		{
+			name:  "wildcard",
+			query: &Query{Service: "foo", Name: "sqs.#{QueueName}.visible", Metric: []interface{}{"AWS/SQS", "ApproximateNumberOfMessagesVisible", "QueueName", "*"}, Stat: "Sum"},
+			want:  "sqs.#{QueueName}.visible",
+		},
+		{
+			name:    "unknown dimension",
+			query:   &Query{Service: "foo", Name: "sqs.#{Queue}.visible", Metric: []interface{}{"AWS/SQS", "ApproximateNumberOfMessagesVisible", "QueueName", "queue"}, Stat: "Sum"},
+			wantErr: true,
+		},
+		{
+			name:    "aggregated wildcard",
+			query:   &Query{Service: "foo", Name: "sqs.#{QueueName}.visible", Metric: []interface{}{"AWS/SQS", "ApproximateNumberOfMessagesVisible", "QueueName", "*"}, Stat: "Sum", Aggregate: "sum"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolved, errs := prepareQueries([]*Query{tt.query})
+			if tt.wantErr {
+				if len(errs) == 0 {
+					t.Error("want an error, got nil")
+				}
+				return
+			}
+			if len(errs) > 0 {
+				t.Fatal(errs)
+			}
+			if got := resolved[0].Label.MetricName; got != tt.want {
+				t.Errorf("want %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestGetMetricsData_NameTemplate(t *testing.T) {
+	start := time.Unix(1234567860, 0)
+	queue := func(name string) types.Metric {
+		return types.Metric{
+			Namespace:  aws.String("AWS/SQS"),
+			MetricName: aws.String("ApproximateNumberOfMessagesVisible"),
+			Dimensions: []types.Dimension{{Name: aws.String("QueueName"), Value: aws.String(name)}},
+		}
+	}
+	svc := &listingCloudWatch{
+		fakeCloudWatch: fakeCloudWatch{
+			values: map[string][]float64{
+				"m1_0": {1},
+				"m1_1": {2},
+			},
+		},
+		metrics: []types.Metric{queue("orders"), queue("jobs")},
+	}
+	fctx := &forwardContext{
+		forwarder: &Forwarder{svccloudwatch: svc},
+		start:     start,
+		end:       start.Add(time.Minute),
+	}
+	query := []*Query{
+		{
+			Service: "foo",
+			Name:    "sqs.#{QueueName}.visible_messages",
+			Metric:  []interface{}{"AWS/SQS", "ApproximateNumberOfMessagesVisible", "QueueName", "*"},
+			Stat:    "Maximum",
+		},
+	}
+	if err := fctx.getMetricsData(context.Background(), query); err != nil {
+		t.Fatal(err)
+	}
+
+	want := serviceMetricsType{
+		"foo": {
+			{Name: "sqs.jobs.visible_messages", Time: start.Unix(), Value: 1},
+			{Name: "sqs.orders.visible_messages", Time: start.Unix(), Value: 2},
+		},
+	}
+	if diff := cmp.Diff(want, fctx.serviceMetrics); diff != "" {
+		t.Errorf("service metrics mismatch: (-want/+got):\n%s", diff)
+	}
+}
+
+func TestGetMetricsData_ServiceTemplate(t *testing.T) {
+	start := time.Unix(1234567860, 0)
+	queue := func(name string) types.Metric {
+		return types.Metric{
+			Namespace:  aws.String("AWS/SQS"),
+			MetricName: aws.String("ApproximateNumberOfMessagesVisible"),
+			Dimensions: []types.Dimension{{Name: aws.String("QueueName"), Value: aws.String(name)}},
+		}
+	}
+	svc := &listingCloudWatch{
+		fakeCloudWatch: fakeCloudWatch{
+			values: map[string][]float64{
+				"m1_0": {1},
+				"m1_1": {2},
+				"m1_2": {3},
+			},
+		},
+		metrics: []types.Metric{queue("orders"), queue("jobs"), queue("untagged")},
+	}
+	tagging := &fakeTagging{
+		tags: map[string]map[string]string{
+			"arn:aws:sqs:ap-northeast-1:123456789012:orders":   {"team": "payments"},
+			"arn:aws:sqs:ap-northeast-1:123456789012:jobs":     {"team": "batch"},
+			"arn:aws:sqs:ap-northeast-1:123456789012:untagged": {},
+		},
+	}
+	fctx := &forwardContext{
+		forwarder: &Forwarder{svccloudwatch: svc, svctagging: tagging},
+		start:     start,
+		end:       start.Add(time.Minute),
+	}
+	query := []*Query{
+		{
+			Service:     "#{tag:team}",
+			Name:        "sqs.#{QueueName}.visible_messages",
+			Metric:      []interface{}{"AWS/SQS", "ApproximateNumberOfMessagesVisible", "QueueName", "*"},
+			Stat:        "Maximum",
+			ResourceARN: "arn:aws:sqs:ap-northeast-1:123456789012:#{QueueName}",
+		},
+	}
+	if err := fctx.getMetricsData(context.Background(), query); err != nil {
+		t.Fatal(err)
+	}
+
+	// the metrics of the queue without the tag are skipped.
+	want := serviceMetricsType{
+		"batch":    {{Name: "sqs.jobs.visible_messages", Time: start.Unix(), Value: 1}},
+		"payments": {{Name: "sqs.orders.visible_messages", Time: start.Unix(), Value: 2}},
+	}
+	if diff := cmp.Diff(want, fctx.serviceMetrics); diff != "" {
+		t.Errorf("service metrics mismatch: (-want/+got):\n%s", diff)
+	}
+}
+
+func TestResolveServiceTemplate(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   *Query
+		want    string
+		wantErr bool
+	}{
+		{
+			name:  "dimension",
+			query: &Query{Service: "svc-#{QueueName}", Name: "sqs.visible", Metric: []interface{}{"AWS/SQS", "ApproximateNumberOfMessagesVisible", "QueueName", "queue"}, Stat: "Sum"},
+			want:  "svc-queue",
+		},
+		{
+			name:  "tag",
+			query: &Query{Service: "#{tag:team}", Name: "sqs.visible", Metric: []interface{}{"AWS/SQS", "ApproximateNumberOfMessagesVisible", "QueueName", "queue"}, Stat: "Sum", ResourceARN: "arn:aws:sqs:ap-northeast-1:123456789012:queue"},
+			want:  "#{tag:team}",
+		},
+		{
+			name:    "tag without resource arn",
+			query:   &Query{Service: "#{tag:team}", Name: "sqs.visible", Metric: []interface{}{"AWS/SQS", "ApproximateNumberOfMessagesVisible", "QueueName", "queue"}, Stat: "Sum"},
+			wantErr: true,
+		},
+		{
+			name:    "unknown dimension in resource arn",
+			query:   &Query{Service: "#{tag:team}", Name: "sqs.visible", Metric: []interface{}{"AWS/SQS", "ApproximateNumberOfMessagesVisible", "QueueName", "queue"}, Stat: "Sum", ResourceARN: "arn:aws:sqs:ap-northeast-1:123456789012:#{Queue}"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolved, errs := prepareQueries([]*Query{tt.query})
+			if tt.wantErr {
+				if len(errs) == 0 {
+					t.Error("want an error, got nil")
+				}
+				return
+			}
+			if len(errs) > 0 {
+				t.Fatal(errs)
+			}
+			if got := resolved[0].Label.Service; got != tt.want {
+				t.Errorf("want %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
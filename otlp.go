@@ -0,0 +1,219 @@
+package forwarder
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OTLPPublisher publishes the metrics to an OpenTelemetry collector using OTLP/HTTP with the JSON encoding.
+// The metrics are exported as gauges. The service name of Mackerel is attached as
+// the "mackerel.service.name" resource attribute, and the host id is attached as the "host.id" resource attribute.
+type OTLPPublisher struct {
+	// Endpoint is the URL for the metrics, e.g. "http://localhost:4318/v1/metrics".
+	Endpoint string
+
+	// Headers are the additional HTTP headers, e.g. for authentication.
+	Headers map[string]string
+
+	// HTTPClient is the client for OTLP/HTTP.
+	// If it is nil, http.DefaultClient is used.
+	HTTPClient *http.Client
+}
+
+var _ Publisher = (*OTLPPublisher)(nil)
+
+// newOTLPPublisherFromEnv creates a new OTLPPublisher from the FORWARD_OTLP_ENDPOINT and FORWARD_OTLP_HEADERS.
+// FORWARD_OTLP_ENDPOINT is the base URL of the collector, and "/v1/metrics" is appended if the path is empty.
+// FORWARD_OTLP_HEADERS is a comma-separated list of "key=value" pairs.
+func newOTLPPublisherFromEnv(endpoint, headers string) (*OTLPPublisher, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("forwarder: failed to parse the otlp endpoint: %w", err)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/v1/metrics"
+	}
+	p := &OTLPPublisher{
+		Endpoint: u.String(),
+	}
+	for _, kv := range strings.Split(headers, ",") {
+		if strings.TrimSpace(kv) == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			return nil, fmt.Errorf("forwarder: invalid otlp header: %q", kv)
+		}
+		v, err := url.QueryUnescape(strings.TrimSpace(v))
+		if err != nil {
+			return nil, fmt.Errorf("forwarder: invalid otlp header: %q: %w", kv, err)
+		}
+		if p.Headers == nil {
+			p.Headers = make(map[string]string)
+		}
+		p.Headers[strings.TrimSpace(k)] = v
+	}
+	return p, nil
+}
+
+// PostServiceMetricValues implements Publisher.
+func (p *OTLPPublisher) PostServiceMetricValues(ctx context.Context, serviceName string, values []ServiceMetricValue) error {
+	points := make([]otlpPoint, 0, len(values))
+	for _, v := range values {
+		points = append(points, otlpPoint{Name: v.Name, Time: v.Time, Value: v.Value})
+	}
+	return p.export(ctx, []otlpResourceMetrics{
+		newOTLPResourceMetrics("mackerel.service.name", serviceName, points),
+	})
+}
+
+// PostHostMetricValues implements Publisher.
+func (p *OTLPPublisher) PostHostMetricValues(ctx context.Context, values []HostMetricValue) error {
+	hosts := make(map[string][]otlpPoint)
+	for _, v := range values {
+		hosts[v.HostID] = append(hosts[v.HostID], otlpPoint{Name: v.Name, Time: v.Time, Value: v.Value})
+	}
+	ids := make([]string, 0, len(hosts))
+	for id := range hosts {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	resources := make([]otlpResourceMetrics, 0, len(ids))
+	for _, id := range ids {
+		resources = append(resources, newOTLPResourceMetrics("host.id", id, hosts[id]))
+	}
+	return p.export(ctx, resources)
+}
+
+func (p *OTLPPublisher) export(ctx context.Context, resources []otlpResourceMetrics) error {
+	if len(resources) == 0 {
+		return nil
+	}
+	body, err := json.Marshal(otlpExportMetricsServiceRequest{
+		ResourceMetrics: resources,
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range p.Headers {
+		req.Header.Set(k, v)
+	}
+
+	client := p.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("forwarder: otlp export error: status: %d, %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+type otlpPoint struct {
+	Name  string
+	Time  int64
+	Value float64
+}
+
+// newOTLPResourceMetrics groups the points by the metric name.
+func newOTLPResourceMetrics(key, value string, points []otlpPoint) otlpResourceMetrics {
+	var metrics []otlpMetric
+	index := make(map[string]int)
+	for _, pt := range points {
+		i, ok := index[pt.Name]
+		if !ok {
+			i = len(metrics)
+			index[pt.Name] = i
+			metrics = append(metrics, otlpMetric{Name: pt.Name})
+		}
+		metrics[i].Gauge.DataPoints = append(metrics[i].Gauge.DataPoints, otlpNumberDataPoint{
+			TimeUnixNano: strconv.FormatInt(pt.Time*int64(time.Second), 10),
+			AsDouble:     pt.Value,
+		})
+	}
+	return otlpResourceMetrics{
+		Resource: otlpResource{
+			Attributes: []otlpKeyValue{
+				{Key: key, Value: otlpAnyValue{StringValue: value}},
+			},
+		},
+		ScopeMetrics: []otlpScopeMetrics{
+			{
+				Scope:   otlpScope{Name: "github.com/shogo82148/mackerel-cloudwatch-forwarder", Version: version},
+				Metrics: metrics,
+			},
+		},
+	}
+}
+
+// the JSON representation of opentelemetry.proto.collector.metrics.v1.ExportMetricsServiceRequest.
+
+type otlpExportMetricsServiceRequest struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource     otlpResource       `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpScopeMetrics struct {
+	Scope   otlpScope    `json:"scope"`
+	Metrics []otlpMetric `json:"metrics"`
+}
+
+type otlpScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type otlpMetric struct {
+	Name  string    `json:"name"`
+	Gauge otlpGauge `json:"gauge"`
+}
+
+type otlpGauge struct {
+	DataPoints []otlpNumberDataPoint `json:"dataPoints"`
+}
+
+type otlpNumberDataPoint struct {
+	TimeUnixNano string  `json:"timeUnixNano"`
+	AsDouble     float64 `json:"asDouble"`
+}
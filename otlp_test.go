@@ -0,0 +1,72 @@
+package forwarder
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestOTLPPublisher(t *testing.T) {
+	var got otlpExportMetricsServiceRequest
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/metrics" {
+			t.Errorf("unexpected path: %q", r.URL.Path)
+		}
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			t.Errorf("unexpected Authorization: %q", r.Header.Get("Authorization"))
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Write([]byte(`{}`))
+	}))
+	defer ts.Close()
+
+	p, err := newOTLPPublisherFromEnv(ts.URL, "Authorization=Bearer%20secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.PostServiceMetricValues(context.Background(), "service", []ServiceMetricValue{
+		{Name: "custom.foo", Time: 1234567860, Value: 1},
+		{Name: "custom.foo", Time: 1234567920, Value: 2},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := otlpExportMetricsServiceRequest{
+		ResourceMetrics: []otlpResourceMetrics{
+			{
+				Resource: otlpResource{
+					Attributes: []otlpKeyValue{
+						{Key: "mackerel.service.name", Value: otlpAnyValue{StringValue: "service"}},
+					},
+				},
+				ScopeMetrics: []otlpScopeMetrics{
+					{
+						Scope: otlpScope{Name: "github.com/shogo82148/mackerel-cloudwatch-forwarder", Version: version},
+						Metrics: []otlpMetric{
+							{
+								Name: "custom.foo",
+								Gauge: otlpGauge{
+									DataPoints: []otlpNumberDataPoint{
+										{TimeUnixNano: "1234567860000000000", AsDouble: 1},
+										{TimeUnixNano: "1234567920000000000", AsDouble: 2},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("request mismatch: (-want/+got):\n%s", diff)
+	}
+}
@@ -0,0 +1,75 @@
+package forwarder
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// invocationOverrides is the settings overridden by the input of the invocation.
+// See the fields of QueryDocument for the format.
+type invocationOverrides struct {
+	Lookback        string `json:"lookback"`
+	DryRun          *bool  `json:"dryRun"`
+	APIKeyParameter string `json:"apiKeyParameter"`
+
+	lookback time.Duration
+}
+
+type invocationOverridesKey struct{}
+
+// withInvocationOverrides returns a copy of ctx that carries the overrides in the input of the invocation.
+// The invalid overrides are ignored with warnings.
+func (f *Forwarder) withInvocationOverrides(ctx context.Context, data []byte) context.Context {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || data[0] != '{' {
+		return ctx
+	}
+	var o invocationOverrides
+	if err := json.Unmarshal(data, &o); err != nil {
+		return ctx
+	}
+	if o.Lookback != "" {
+		d, err := time.ParseDuration(o.Lookback)
+		if err == nil && d <= 0 {
+			err = errors.New("must be positive")
+		}
+		if err != nil {
+			f.logger().WarnContext(ctx, "invalid lookback in the input, use the default",
+				"input", o.Lookback,
+				"error", err.Error(),
+			)
+		} else {
+			o.lookback = d
+		}
+	}
+	return context.WithValue(ctx, invocationOverridesKey{}, &o)
+}
+
+// invocationOverridesFromContext returns the overrides of the invocation in progress.
+// It returns the empty overrides if the input has no overrides.
+func invocationOverridesFromContext(ctx context.Context) *invocationOverrides {
+	if o, ok := ctx.Value(invocationOverridesKey{}).(*invocationOverrides); ok {
+		return o
+	}
+	return &invocationOverrides{}
+}
+
+type isolatedInvocationKey struct{}
+
+// withIsolatedInvocation returns a copy of ctx that marks the invocation as isolated.
+// The isolated invocations neither publish nor keep the pending metrics, see forwardWindowIsolated.
+func withIsolatedInvocation(ctx context.Context) context.Context {
+	return context.WithValue(ctx, isolatedInvocationKey{}, true)
+}
+
+// isIsolatedInvocation reports whether the invocation in progress is isolated.
+// The invocations with apiKeyParameter in the input are also isolated.
+func isIsolatedInvocation(ctx context.Context) bool {
+	if isolated, _ := ctx.Value(isolatedInvocationKey{}).(bool); isolated {
+		return true
+	}
+	return invocationOverridesFromContext(ctx).APIKeyParameter != ""
+}
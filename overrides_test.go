@@ -0,0 +1,101 @@
+package forwarder
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestForwardMetrics_OverrideDryRun(t *testing.T) {
+	f := &Forwarder{
+		svccloudwatch: &fakeCloudWatch{
+			values: map[string][]float64{
+				"m1": {42},
+			},
+		},
+	}
+	data := json.RawMessage(`{"queries":[{"service":"foo","name":"ec2.cpu","metric":["AWS/EC2","CPUUtilization","InstanceId","i-1"],"stat":"Average"}],"dryRun":true}`)
+
+	// the api key is not required, because nothing is posted to Mackerel.
+	if err := f.forwardMetrics(context.Background(), data, 0, &InvocationReport{}); err != nil {
+		t.Fatal(err)
+	}
+	if f.svcmackerel != nil {
+		t.Error("the mackerel client is configured in dry run")
+	}
+}
+
+func TestForwardMetrics_OverrideLookback(t *testing.T) {
+	var window time.Duration
+	f := &Forwarder{
+		DryRun:   true,
+		Lookback: time.Minute,
+		svccloudwatch: &recordingCloudWatch{
+			fakeCloudWatch: fakeCloudWatch{
+				values: map[string][]float64{
+					"m1": {42},
+				},
+			},
+			onGetMetricData: func(start time.Time) {
+				window = time.Since(start)
+			},
+		},
+	}
+	data := json.RawMessage(`{"queries":[{"service":"foo","name":"ec2.cpu","metric":["AWS/EC2","CPUUtilization","InstanceId","i-1"],"stat":"Average"}],"lookback":"10m"}`)
+	if err := f.forwardMetrics(context.Background(), data, 0, &InvocationReport{}); err != nil {
+		t.Fatal(err)
+	}
+	// the window is 10 minutes, and it ends a minute before.
+	if window < 11*time.Minute || window > 12*time.Minute {
+		t.Errorf("unexpected window: %s", window)
+	}
+
+	// the invalid lookback is ignored.
+	data = json.RawMessage(`{"queries":[{"service":"foo","name":"ec2.cpu","metric":["AWS/EC2","CPUUtilization","InstanceId","i-1"],"stat":"Average"}],"lookback":"-10m"}`)
+	if err := f.forwardMetrics(context.Background(), data, 0, &InvocationReport{}); err != nil {
+		t.Fatal(err)
+	}
+	if window < 2*time.Minute || window > 3*time.Minute {
+		t.Errorf("unexpected window: %s", window)
+	}
+}
+
+func TestForwardMetrics_OverrideAPIKeyParameter(t *testing.T) {
+	keys := map[string]int{}
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		keys[r.Header.Get("X-Api-Key")]++
+		rw.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(ts.Close)
+
+	f := &Forwarder{
+		APIKey:                 "default-key",
+		APIURL:                 ts.URL,
+		SkipAPIKeyVerification: true,
+		SSM:                    &fakeSSM{params: map[string]string{"/other/api-key": "other-key"}},
+		svccloudwatch: &fakeCloudWatch{
+			values: map[string][]float64{
+				"m1": {42},
+			},
+		},
+	}
+	data := json.RawMessage(`{"queries":[{"service":"foo","name":"ec2.cpu","metric":["AWS/EC2","CPUUtilization","InstanceId","i-1"],"stat":"Average"}],"apiKeyParameter":"/other/api-key"}`)
+	if err := f.forwardMetrics(context.Background(), data, 0, &InvocationReport{}); err != nil {
+		t.Fatal(err)
+	}
+	if keys["other-key"] != 1 || keys["default-key"] != 0 {
+		t.Errorf("unexpected api keys: %v", keys)
+	}
+	if f.svcmackerel != nil {
+		t.Error("the default mackerel client is configured")
+	}
+
+	// the unknown parameter is an error.
+	data = json.RawMessage(`{"queries":[],"apiKeyParameter":"/unknown"}`)
+	if err := f.forwardMetrics(context.Background(), data, 0, &InvocationReport{}); err == nil {
+		t.Error("want error, got nil")
+	}
+}
@@ -0,0 +1,57 @@
+package forwarder
+
+import "strings"
+
+// Partition is a group of AWS regions, e.g. the standard regions, AWS GovCloud (US), and the China regions.
+type Partition struct {
+	// ID is the partition id used in ARNs, e.g. "aws".
+	ID string
+
+	// DNSSuffix is the DNS suffix of the endpoints, e.g. "amazonaws.com".
+	DNSSuffix string
+
+	// costExplorerRegion is the region of the endpoint of AWS Cost Explorer.
+	costExplorerRegion string
+
+	// billingRegion is the region where CloudWatch publishes the billing metrics.
+	// It is empty if the partition has no billing metrics.
+	billingRegion string
+}
+
+var (
+	// PartitionAWS is the partition of the standard regions.
+	PartitionAWS = Partition{ID: "aws", DNSSuffix: "amazonaws.com", costExplorerRegion: "us-east-1", billingRegion: "us-east-1"}
+
+	// PartitionAWSUSGov is the partition of AWS GovCloud (US).
+	PartitionAWSUSGov = Partition{ID: "aws-us-gov", DNSSuffix: "amazonaws.com", costExplorerRegion: "us-gov-west-1"}
+
+	// PartitionAWSCN is the partition of the China regions.
+	PartitionAWSCN = Partition{ID: "aws-cn", DNSSuffix: "amazonaws.com.cn", costExplorerRegion: "cn-northwest-1"}
+)
+
+// PartitionForRegion returns the partition that the region belongs to, e.g. PartitionAWSCN for "cn-north-1".
+// It returns PartitionAWS for the unknown regions.
+func PartitionForRegion(region string) Partition {
+	switch {
+	case strings.HasPrefix(region, "us-gov-"):
+		return PartitionAWSUSGov
+	case strings.HasPrefix(region, "cn-"):
+		return PartitionAWSCN
+	}
+	return PartitionAWS
+}
+
+// PartitionByID returns the partition of the id, e.g. "aws-us-gov".
+func PartitionByID(id string) (Partition, bool) {
+	for _, p := range []Partition{PartitionAWS, PartitionAWSUSGov, PartitionAWSCN} {
+		if p.ID == id {
+			return p, true
+		}
+	}
+	return Partition{}, false
+}
+
+// partition returns the partition of the region in the AWS config.
+func (f *Forwarder) partition() Partition {
+	return PartitionForRegion(f.Config.Region)
+}
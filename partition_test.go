@@ -0,0 +1,121 @@
+package forwarder
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/costexplorer"
+	cetypes "github.com/aws/aws-sdk-go-v2/service/costexplorer/types"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+)
+
+func TestPartitionForRegion(t *testing.T) {
+	tests := []struct {
+		region string
+		want   Partition
+	}{
+		{region: "ap-northeast-1", want: PartitionAWS},
+		{region: "us-east-1", want: PartitionAWS},
+		{region: "us-gov-west-1", want: PartitionAWSUSGov},
+		{region: "cn-north-1", want: PartitionAWSCN},
+		{region: "", want: PartitionAWS},
+	}
+	for _, tt := range tests {
+		if got := PartitionForRegion(tt.region); got != tt.want {
+			t.Errorf("%q: want %q, got %q", tt.region, tt.want.ID, got.ID)
+		}
+	}
+}
+
+// hostRecorder records the hosts of the requests, and fails them.
+type hostRecorder struct {
+	hosts []string
+}
+
+func (r *hostRecorder) Do(req *http.Request) (*http.Response, error) {
+	r.hosts = append(r.hosts, req.URL.Host)
+	return nil, errors.New("not connected")
+}
+
+func TestForwarder_Partition(t *testing.T) {
+	tests := []struct {
+		region       string
+		ssm          string
+		kms          string
+		costexplorer string
+	}{
+		{
+			region:       "us-gov-west-1",
+			ssm:          "ssm.us-gov-west-1.amazonaws.com",
+			kms:          "kms.us-gov-west-1.amazonaws.com",
+			costexplorer: "ce.us-gov-west-1.amazonaws.com",
+		},
+		{
+			region:       "cn-north-1",
+			ssm:          "ssm.cn-north-1.amazonaws.com.cn",
+			kms:          "kms.cn-north-1.amazonaws.com.cn",
+			costexplorer: "ce.cn-northwest-1.amazonaws.com.cn",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.region, func(t *testing.T) {
+			rec := &hostRecorder{}
+			f := &Forwarder{
+				Config: aws.Config{
+					Region:           tt.region,
+					Credentials:      credentials.NewStaticCredentialsProvider("AKID", "SECRET", ""),
+					HTTPClient:       rec,
+					RetryMaxAttempts: 1,
+				},
+			}
+			ctx := context.Background()
+			f.ssm().GetParameter(ctx, &ssm.GetParameterInput{Name: aws.String("foo")})
+			f.kms().Decrypt(ctx, &kms.DecryptInput{CiphertextBlob: []byte("foo")})
+			f.costexplorer().GetCostAndUsage(ctx, &costexplorer.GetCostAndUsageInput{
+				Granularity: cetypes.GranularityDaily,
+				Metrics:     []string{"UnblendedCost"},
+				TimePeriod:  &cetypes.DateInterval{Start: aws.String("2024-01-01"), End: aws.String("2024-01-02")},
+			})
+
+			want := []string{tt.ssm, tt.kms, tt.costexplorer}
+			if len(rec.hosts) != len(want) {
+				t.Fatalf("want %v, got %v", want, rec.hosts)
+			}
+			for i := range want {
+				if rec.hosts[i] != want[i] {
+					t.Errorf("want %q, got %q", want[i], rec.hosts[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSubstitutePseudoParameters_Partition(t *testing.T) {
+	doc, err := RequiredPolicyForInput([]byte(`[
+		{"service": "foo", "name": "a", "metric": ["AWS/EC2", "CPUUtilization"], "stat": "Average"}
+	]`), &PolicyOptions{ParameterName: "/api-keys/mackerel"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := SubstitutePseudoParameters(doc, PseudoParameters{Region: "cn-north-1"})
+	var ssmARN, viaService string
+	for _, s := range got.Statement {
+		switch s.Action[0] {
+		case "ssm:GetParameter":
+			ssmARN = s.Resource[0]
+		case "kms:Decrypt":
+			viaService, _ = s.Condition["StringLike"]["kms:ViaService"].(string)
+		}
+	}
+	if want := "arn:aws-cn:ssm:cn-north-1:*:parameter/api-keys/mackerel"; ssmARN != want {
+		t.Errorf("want %q, got %q", want, ssmARN)
+	}
+	if want := "ssm.cn-north-1.amazonaws.com.cn"; viaService != want {
+		t.Errorf("want %q, got %q", want, viaService)
+	}
+}
@@ -0,0 +1,104 @@
+package forwarder
+
+import (
+	"cmp"
+	"context"
+	"slices"
+)
+
+// defaultMaxPendingMetrics is the default of Forwarder.MaxPendingMetrics.
+const defaultMaxPendingMetrics = 100000
+
+// maxPendingMetrics returns the maximum number of the pending data points.
+func (f *Forwarder) maxPendingMetrics(ctx context.Context) int {
+	if f.MaxPendingMetrics > 0 {
+		return f.MaxPendingMetrics
+	}
+	if n := f.env().MaxPendingMetrics; n > 0 {
+		return n
+	}
+	return defaultMaxPendingMetrics
+}
+
+// evictPendingMetrics drops the oldest pending data points that exceed the maximum number.
+// The caller must hold f.muPending.
+func (f *Forwarder) evictPendingMetrics(ctx context.Context, report *InvocationReport) {
+	limit := f.maxPendingMetrics(ctx)
+	total := len(f.pendingHostMetrics)
+	for _, metrics := range f.pendingServiceMetrics {
+		total += len(metrics)
+	}
+	if total <= limit {
+		return
+	}
+
+	// find the threshold of the time to evict.
+	times := make([]int64, 0, total)
+	for _, metrics := range f.pendingServiceMetrics {
+		for _, v := range metrics {
+			times = append(times, v.Time)
+		}
+	}
+	for _, v := range f.pendingHostMetrics {
+		times = append(times, v.Time)
+	}
+	slices.Sort(times)
+	evict := total - limit
+	threshold := times[evict-1]
+	// the number of the data points at the threshold time that are evicted.
+	atThreshold := evict - countLess(times, threshold)
+
+	// evict the service metrics in the order of the service names, so that the result is deterministic.
+	services := make([]string, 0, len(f.pendingServiceMetrics))
+	for service := range f.pendingServiceMetrics {
+		services = append(services, service)
+	}
+	slices.Sort(services)
+	for _, service := range services {
+		metrics := f.pendingServiceMetrics[service]
+		kept := metrics[:0]
+		var cnt int
+		for _, v := range metrics {
+			if v.Time < threshold || (v.Time == threshold && atThreshold > 0) {
+				if v.Time == threshold {
+					atThreshold--
+				}
+				cnt++
+				continue
+			}
+			kept = append(kept, v)
+		}
+		if len(kept) > 0 {
+			f.pendingServiceMetrics[service] = kept
+		} else {
+			delete(f.pendingServiceMetrics, service)
+		}
+		report.addEvicted(service, cnt)
+	}
+
+	kept := f.pendingHostMetrics[:0]
+	var cnt int
+	for _, v := range f.pendingHostMetrics {
+		if v.Time < threshold || (v.Time == threshold && atThreshold > 0) {
+			if v.Time == threshold {
+				atThreshold--
+			}
+			cnt++
+			continue
+		}
+		kept = append(kept, v)
+	}
+	f.pendingHostMetrics = kept
+	report.addEvicted("", cnt)
+
+	f.logger().WarnContext(ctx, "too many pending metrics, drop the oldest",
+		"count", evict,
+		"limit", limit,
+	)
+}
+
+// countLess returns the number of the elements less than v in the sorted slice.
+func countLess(sorted []int64, v int64) int {
+	i, _ := slices.BinarySearchFunc(sorted, v, cmp.Compare[int64])
+	return i
+}
@@ -0,0 +1,85 @@
+package forwarder
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestEvictPendingMetrics(t *testing.T) {
+	f := &Forwarder{
+		MaxPendingMetrics: 3,
+		pendingServiceMetrics: serviceMetricsType{
+			"foo": {
+				{Name: "a", Time: 60, Value: 1},
+				{Name: "a", Time: 180, Value: 3},
+			},
+			"bar": {
+				{Name: "a", Time: 120, Value: 2},
+			},
+		},
+		pendingHostMetrics: hostMetricsType{
+			{HostID: "host-abc", Name: "b", Time: 60, Value: 4},
+			{HostID: "host-abc", Name: "b", Time: 240, Value: 5},
+		},
+	}
+	report := &InvocationReport{}
+	f.evictPendingMetrics(context.Background(), report)
+
+	wantService := serviceMetricsType{
+		"foo": {
+			{Name: "a", Time: 180, Value: 3},
+		},
+		"bar": {
+			{Name: "a", Time: 120, Value: 2},
+		},
+	}
+	if diff := cmp.Diff(wantService, f.pendingServiceMetrics); diff != "" {
+		t.Errorf("service metrics mismatch: (-want/+got):\n%s", diff)
+	}
+	wantHost := hostMetricsType{
+		{HostID: "host-abc", Name: "b", Time: 240, Value: 5},
+	}
+	if diff := cmp.Diff(wantHost, f.pendingHostMetrics); diff != "" {
+		t.Errorf("host metrics mismatch: (-want/+got):\n%s", diff)
+	}
+	if report.Evicted != 2 || report.Dropped != 2 {
+		t.Errorf("unexpected report: evicted %d, dropped %d", report.Evicted, report.Dropped)
+	}
+}
+
+func TestEvictPendingMetrics_SameTime(t *testing.T) {
+	f := &Forwarder{
+		MaxPendingMetrics: 2,
+		pendingServiceMetrics: serviceMetricsType{
+			"foo": {
+				{Name: "a", Time: 60, Value: 1},
+				{Name: "b", Time: 60, Value: 2},
+				{Name: "c", Time: 60, Value: 3},
+			},
+		},
+	}
+	report := &InvocationReport{}
+	f.evictPendingMetrics(context.Background(), report)
+
+	if got := len(f.pendingServiceMetrics["foo"]); got != 2 {
+		t.Errorf("unexpected number of the pending metrics: want 2, got %d", got)
+	}
+	if report.Evicted != 1 {
+		t.Errorf("unexpected evicted: want 1, got %d", report.Evicted)
+	}
+}
+
+func TestEvictPendingMetrics_UnderLimit(t *testing.T) {
+	f := &Forwarder{
+		pendingHostMetrics: hostMetricsType{
+			{HostID: "host-abc", Name: "b", Time: 60, Value: 4},
+		},
+	}
+	report := &InvocationReport{}
+	f.evictPendingMetrics(context.Background(), report)
+	if len(f.pendingHostMetrics) != 1 || report.Dropped != 0 {
+		t.Errorf("unexpected eviction: %v", f.pendingHostMetrics)
+	}
+}
@@ -0,0 +1,290 @@
+package forwarder
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/smithy-go"
+)
+
+// queryTypePerformanceInsights is the query type for RDS Performance Insights.
+const queryTypePerformanceInsights = "performanceInsights"
+
+// PerformanceInsightsQuery is a query for Amazon RDS Performance Insights.
+type PerformanceInsightsQuery struct {
+	// ServiceType is the type of the service. The default is "RDS".
+	ServiceType string `json:"serviceType,omitempty"`
+
+	// Identifier is the resource id of the DB instance, e.g. "db-ABCDEFGHIJKLMNOPQRSTUVWXY0".
+	Identifier string `json:"identifier"`
+
+	// Metric is the name of the metric, e.g. "db.load.avg".
+	Metric string `json:"metric"`
+
+	// GroupBy is the dimension group for the top N, e.g. "db.wait_event".
+	// If it is specified, the metric of each group is forwarded
+	// with the name that the group is appended.
+	GroupBy string `json:"groupBy,omitempty"`
+
+	// Limit is the maximum number of the groups.
+	Limit int32 `json:"limit,omitempty"`
+}
+
+type piMetricQuery struct {
+	Metric  string          `json:"Metric"`
+	GroupBy *piDimensionGrp `json:"GroupBy,omitempty"`
+}
+
+type piDimensionGrp struct {
+	Group string `json:"Group"`
+	Limit int32  `json:"Limit,omitempty"`
+}
+
+type piGetResourceMetricsInput struct {
+	ServiceType     string          `json:"ServiceType"`
+	Identifier      string          `json:"Identifier"`
+	MetricQueries   []piMetricQuery `json:"MetricQueries"`
+	StartTime       float64         `json:"StartTime"`
+	EndTime         float64         `json:"EndTime"`
+	PeriodInSeconds int32           `json:"PeriodInSeconds"`
+}
+
+type piGetResourceMetricsOutput struct {
+	MetricList []piMetricKeyDataPoints `json:"MetricList"`
+}
+
+type piMetricKeyDataPoints struct {
+	Key struct {
+		Metric     string            `json:"Metric"`
+		Dimensions map[string]string `json:"Dimensions"`
+	} `json:"Key"`
+	DataPoints []struct {
+		Timestamp float64  `json:"Timestamp"`
+		Value     *float64 `json:"Value"`
+	} `json:"DataPoints"`
+}
+
+// piClient is a minimal client for the GetResourceMetrics API of Performance Insights.
+// It talks the AWS JSON 1.1 protocol and signs the requests with Signature Version 4.
+// The errors implement smithy.APIError, and they are retried by the retryer of the config,
+// in the same way as the clients of AWS SDK.
+type piClient struct {
+	config aws.Config
+	signer *v4.Signer
+
+	// endpoint overrides the endpoint of the API.
+	endpoint string
+}
+
+func newPIClient(cfg aws.Config) *piClient {
+	return &piClient{
+		config: cfg,
+		signer: v4.NewSigner(),
+	}
+}
+
+// piError is the error response of the Performance Insights API.
+type piError struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *piError) Error() string {
+	return fmt.Sprintf("forwarder: performance insights api error: status: %d, %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// ErrorCode implements smithy.APIError.
+func (e *piError) ErrorCode() string { return e.Code }
+
+// ErrorMessage implements smithy.APIError.
+func (e *piError) ErrorMessage() string { return e.Message }
+
+// ErrorFault implements smithy.APIError.
+func (e *piError) ErrorFault() smithy.ErrorFault {
+	if e.StatusCode >= 500 {
+		return smithy.FaultServer
+	}
+	return smithy.FaultClient
+}
+
+// HTTPStatusCode returns the status code, that the retryer of AWS SDK uses.
+func (e *piError) HTTPStatusCode() int { return e.StatusCode }
+
+// newPIError parses the error response of AWS JSON 1.1 protocol, e.g. {"__type": "ThrottlingException", "message": "Rate exceeded"}.
+func newPIError(resp *http.Response, data []byte) *piError {
+	var body struct {
+		Type         string `json:"__type"`
+		Message      string `json:"message"`
+		MessageUpper string `json:"Message"`
+	}
+	_ = json.Unmarshal(data, &body)
+	code := cmp.Or(resp.Header.Get("X-Amzn-ErrorType"), body.Type)
+	// the code may be "aws.protocols#ThrottlingException" or "ThrottlingException:http://internal.amazon.com/".
+	if i := strings.LastIndexByte(code, '#'); i >= 0 {
+		code = code[i+1:]
+	}
+	if i := strings.IndexByte(code, ':'); i >= 0 {
+		code = code[:i]
+	}
+	message := cmp.Or(body.Message, body.MessageUpper)
+	if code == "" && message == "" {
+		message = string(data)
+	}
+	return &piError{StatusCode: resp.StatusCode, Code: code, Message: message}
+}
+
+func (c *piClient) retryer() aws.Retryer {
+	if c.config.Retryer != nil {
+		return c.config.Retryer()
+	}
+	return retry.NewStandard()
+}
+
+func (c *piClient) GetResourceMetrics(ctx context.Context, params *piGetResourceMetricsInput) (*piGetResourceMetricsOutput, error) {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	retryer := c.retryer()
+	for attempt := 1; ; attempt++ {
+		out, err := c.getResourceMetrics(ctx, body)
+		if err == nil || attempt >= retryer.MaxAttempts() || !retryer.IsErrorRetryable(err) {
+			return out, err
+		}
+		delay, derr := retryer.RetryDelay(attempt, err)
+		if derr != nil {
+			return nil, err
+		}
+		if err := sleepContext(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// getResourceMetrics sends a request of GetResourceMetrics without retrying.
+func (c *piClient) getResourceMetrics(ctx context.Context, body []byte) (*piGetResourceMetricsOutput, error) {
+	region := c.config.Region
+	endpoint := c.endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://pi.%s.%s/", region, PartitionForRegion(region).DNSSuffix)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "PerformanceInsightsv20180227.GetResourceMetrics")
+
+	if c.config.Credentials == nil {
+		return nil, fmt.Errorf("forwarder: aws credentials are not configured")
+	}
+	creds, err := c.config.Credentials.Retrieve(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(body)
+	if err := c.signer.SignHTTP(ctx, creds, req, hex.EncodeToString(sum[:]), "pi", region, time.Now()); err != nil {
+		return nil, err
+	}
+
+	var client aws.HTTPClient = http.DefaultClient
+	if c.config.HTTPClient != nil {
+		client = c.config.HTTPClient
+	}
+	resp, err := tracedDo(client, req, "PI")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, newPIError(resp, data)
+	}
+	var out piGetResourceMetricsOutput
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// getPerformanceInsightsMetrics gets the metrics from Performance Insights.
+func (fctx *forwardContext) getPerformanceInsightsMetrics(ctx context.Context, q *metricQuery) error {
+	pq := q.Query.PerformanceInsights
+	serviceType := pq.ServiceType
+	if serviceType == "" {
+		serviceType = "RDS"
+	}
+	mq := piMetricQuery{
+		Metric: pq.Metric,
+	}
+	if pq.GroupBy != "" {
+		mq.GroupBy = &piDimensionGrp{
+			Group: pq.GroupBy,
+			Limit: pq.Limit,
+		}
+	}
+
+	svc := fctx.forwarder.pi()
+	start, end := fctx.window(q)
+	resp, err := svc.GetResourceMetrics(ctx, &piGetResourceMetricsInput{
+		ServiceType:     serviceType,
+		Identifier:      pq.Identifier,
+		MetricQueries:   []piMetricQuery{mq},
+		StartTime:       float64(start.Unix()),
+		EndTime:         float64(end.Unix()),
+		PeriodInSeconds: 60,
+	})
+	if err != nil {
+		return fmt.Errorf("forwarder: failed to get the metrics of %s: %w", pq.Identifier, err)
+	}
+
+	for _, m := range resp.MetricList {
+		label := q.Label
+		if len(m.Key.Dimensions) > 0 {
+			label.MetricName += "." + sanitizeMetricNameElement(piDimensionName(pq.GroupBy, m.Key.Dimensions))
+		}
+		for _, dp := range m.DataPoints {
+			if dp.Value == nil {
+				continue
+			}
+			fctx.appendValue(q.Query, label, time.Unix(int64(dp.Timestamp), 0), *dp.Value)
+		}
+	}
+	return nil
+}
+
+// piDimensionName returns the name for the dimensions.
+// It prefers the "<group>.name" dimension, e.g. "db.wait_event.name".
+func piDimensionName(group string, dimensions map[string]string) string {
+	if name, ok := dimensions[group+".name"]; ok {
+		return name
+	}
+	keys := make([]string, 0, len(dimensions))
+	for k := range dimensions {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	values := make([]string, 0, len(keys))
+	for _, k := range keys {
+		values = append(values, dimensions[k])
+	}
+	return strings.Join(values, "_")
+}
@@ -0,0 +1,165 @@
+package forwarder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/smithy-go"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPIClient_GetResourceMetrics(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if want, got := "PerformanceInsightsv20180227.GetResourceMetrics", r.Header.Get("X-Amz-Target"); want != got {
+			t.Errorf("unexpected target: want %q, got %q", want, got)
+		}
+		if auth := r.Header.Get("Authorization"); !strings.Contains(auth, "/ap-northeast-1/pi/aws4_request") {
+			t.Errorf("unexpected authorization: %q", auth)
+		}
+		var in piGetResourceMetricsInput
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if in.Identifier != "db-ABC" {
+			t.Errorf("unexpected identifier: want %q, got %q", "db-ABC", in.Identifier)
+		}
+		rw.Write([]byte(`{"MetricList":[{"Key":{"Metric":"db.load.avg"},"DataPoints":[{"Timestamp":1234567860,"Value":1.5}]}]}`))
+	}))
+	defer ts.Close()
+
+	client := newPIClient(aws.Config{
+		Region: "ap-northeast-1",
+		Credentials: aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "AKID", SecretAccessKey: "SECRET"}, nil
+		}),
+	})
+	client.endpoint = ts.URL
+
+	out, err := client.GetResourceMetrics(context.Background(), &piGetResourceMetricsInput{
+		ServiceType: "RDS",
+		Identifier:  "db-ABC",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.MetricList) != 1 || len(out.MetricList[0].DataPoints) != 1 {
+		t.Fatalf("unexpected output: %#v", out)
+	}
+	if v := out.MetricList[0].DataPoints[0].Value; v == nil || *v != 1.5 {
+		t.Errorf("unexpected value: %v", v)
+	}
+}
+
+func TestPIClient_Retry(t *testing.T) {
+	var calls int
+	var invalid bool
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		calls++
+		switch {
+		case invalid:
+			rw.WriteHeader(http.StatusBadRequest)
+			rw.Write([]byte(`{"__type":"InvalidArgumentException","message":"invalid identifier"}`))
+		case calls == 1:
+			rw.WriteHeader(http.StatusBadRequest)
+			rw.Write([]byte(`{"__type":"com.amazonaws.pi#ThrottlingException","message":"Rate exceeded"}`))
+		default:
+			rw.Write([]byte(`{"MetricList":[]}`))
+		}
+	}))
+	defer ts.Close()
+
+	client := newPIClient(aws.Config{
+		Region: "ap-northeast-1",
+		Credentials: aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "AKID", SecretAccessKey: "SECRET"}, nil
+		}),
+		Retryer: func() aws.Retryer {
+			return retry.NewStandard(func(o *retry.StandardOptions) {
+				o.Backoff = retry.BackoffDelayerFunc(func(int, error) (time.Duration, error) { return 0, nil })
+			})
+		},
+	})
+	client.endpoint = ts.URL
+
+	// the throttling is retried.
+	if _, err := client.GetResourceMetrics(context.Background(), &piGetResourceMetricsInput{Identifier: "db-ABC"}); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 {
+		t.Errorf("want 2 calls, got %d", calls)
+	}
+
+	// the client errors are not retried.
+	calls = 0
+	invalid = true
+	_, err := client.GetResourceMetrics(context.Background(), &piGetResourceMetricsInput{Identifier: "db-ABC"})
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) || apiErr.ErrorCode() != "InvalidArgumentException" {
+		t.Errorf("want InvalidArgumentException, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("want 1 call, got %d", calls)
+	}
+}
+
+type fakePI struct {
+	out *piGetResourceMetricsOutput
+}
+
+func (s *fakePI) GetResourceMetrics(ctx context.Context, params *piGetResourceMetricsInput) (*piGetResourceMetricsOutput, error) {
+	return s.out, nil
+}
+
+func TestGetMetricsData_PerformanceInsights(t *testing.T) {
+	start := time.Unix(1234567860, 0)
+	var out piGetResourceMetricsOutput
+	err := json.Unmarshal([]byte(`{"MetricList":[
+		{"Key":{"Metric":"db.load.avg"},"DataPoints":[{"Timestamp":1234567860,"Value":1.5}]},
+		{"Key":{"Metric":"db.load.avg","Dimensions":{"db.wait_event.name":"CPU","db.wait_event.type":"CPU"}},"DataPoints":[{"Timestamp":1234567860,"Value":1.0}]},
+		{"Key":{"Metric":"db.load.avg","Dimensions":{"db.wait_event.name":"IO:DataFileRead","db.wait_event.type":"IO"}},"DataPoints":[{"Timestamp":1234567860,"Value":0.5}]}
+	]}`), &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fctx := &forwardContext{
+		forwarder: &Forwarder{
+			svcpi: &fakePI{out: &out},
+		},
+		start: start,
+		end:   start.Add(time.Minute),
+	}
+	query := []*Query{
+		{
+			Type: "performanceInsights",
+			Host: "host-abc",
+			Name: "rds.db_load",
+			PerformanceInsights: &PerformanceInsightsQuery{
+				Identifier: "db-ABC",
+				Metric:     "db.load.avg",
+				GroupBy:    "db.wait_event",
+				Limit:      5,
+			},
+		},
+	}
+	if err := fctx.getMetricsData(context.Background(), query); err != nil {
+		t.Fatal(err)
+	}
+
+	want := hostMetricsType{
+		{HostID: "host-abc", Name: "rds.db_load", Time: start.Unix(), Value: 1.5},
+		{HostID: "host-abc", Name: "rds.db_load.CPU", Time: start.Unix(), Value: 1.0},
+		{HostID: "host-abc", Name: "rds.db_load.IO_DataFileRead", Time: start.Unix(), Value: 0.5},
+	}
+	if diff := cmp.Diff(want, fctx.hostMetrics); diff != "" {
+		t.Errorf("metrics mismatch: (-want/+got):\n%s", diff)
+	}
+}
@@ -0,0 +1,121 @@
+package forwarder
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// PingResult is the result of Ping.
+type PingResult struct {
+	// OK is true if all the checks succeed.
+	OK bool `json:"ok"`
+
+	// Version is the version of the forwarder.
+	Version string `json:"version"`
+
+	// Checks is the results of the checks.
+	Checks []PingCheck `json:"checks"`
+}
+
+// PingCheck is the result of a check of Ping.
+type PingCheck struct {
+	// Name is the name of the check, e.g. "aws-credentials", "api-key", and "mackerel".
+	Name string `json:"name"`
+
+	// OK is true if the check succeeds.
+	OK bool `json:"ok"`
+
+	// Message is the detail of the result, e.g. the caller's ARN, the name of the organization, or the error.
+	Message string `json:"message,omitempty"`
+}
+
+// errPingSkipped means the check is skipped because the checks that it depends on failed.
+var errPingSkipped = errors.New("skipped")
+
+func (r *PingResult) check(name string, f func() (string, error)) error {
+	msg, err := f()
+	c := PingCheck{
+		Name:    name,
+		OK:      err == nil,
+		Message: msg,
+	}
+	if err != nil {
+		c.Message = err.Error()
+		r.OK = false
+	}
+	r.Checks = append(r.Checks, c)
+	return err
+}
+
+// Ping verifies the configuration of the forwarder without forwarding any metrics.
+// It checks the AWS credentials, the access to the API key via SSM and KMS,
+// and the validity of the API key by the organization API of Mackerel.
+// The failures of the checks are reported in the result, not as the error,
+// so that the deployments can be verified by invoking the function right after rollout.
+func (f *Forwarder) Ping(ctx context.Context) (*PingResult, error) {
+	ctx, cancel := f.invocationContext(ctx)
+	defer cancel()
+
+	result := &PingResult{
+		OK:      true,
+		Version: version,
+	}
+	result.check("aws-credentials", func() (string, error) {
+		resp, err := f.sts().GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+		if err != nil {
+			return "", err
+		}
+		return aws.ToString(resp.Arn), nil
+	})
+	keyErr := result.check("api-key", func() (string, error) {
+		_, err := f.keyProvider().APIKey(ctx)
+		return "", err
+	})
+	result.check("mackerel", func() (string, error) {
+		if keyErr != nil {
+			return "", errPingSkipped
+		}
+		client, err := f.mackerel(ctx)
+		if err != nil {
+			return "", err
+		}
+		org, err := client.GetOrg(ctx)
+		if err != nil {
+			return "", classifyAPIKeyError(err)
+		}
+		return org.Name, nil
+	})
+
+	for _, c := range result.Checks {
+		if c.OK {
+			f.logger().InfoContext(ctx, "ping succeeded", "check", c.Name, "message", c.Message)
+		} else {
+			f.logger().ErrorContext(ctx, "ping failed", "check", c.Name, "error", c.Message)
+		}
+	}
+	return result, nil
+}
+
+// IsPingRequest reports whether the input of ForwardMetrics is a ping request, i.e. {"ping": true}.
+// It allows the function for forwarding metrics to be verified by Ping.
+func IsPingRequest(data json.RawMessage) bool {
+	return isControlRequest(data, "ping")
+}
+
+// isControlRequest reports whether the input is a JSON object whose field of the key is true.
+func isControlRequest(data json.RawMessage, key string) bool {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || data[0] != '{' {
+		return false
+	}
+	var req map[string]json.RawMessage
+	if err := json.Unmarshal(data, &req); err != nil {
+		return false
+	}
+	return bytes.Equal(req[key], []byte("true"))
+}
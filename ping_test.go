@@ -0,0 +1,108 @@
+package forwarder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/google/go-cmp/cmp"
+)
+
+type fakeSTS struct {
+	err error
+}
+
+func (s *fakeSTS) GetCallerIdentity(ctx context.Context, params *sts.GetCallerIdentityInput, optFns ...func(*sts.Options)) (*sts.GetCallerIdentityOutput, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &sts.GetCallerIdentityOutput{
+		Account: aws.String("123456789012"),
+		Arn:     aws.String("arn:aws:sts::123456789012:assumed-role/forwarder/function"),
+	}, nil
+}
+
+func TestPing(t *testing.T) {
+	client := newTestMackerelClient(t, http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if want, got := "/api/v0/org", r.URL.Path; want != got {
+			t.Errorf("unexpected path: want %q, got %q", want, got)
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Write([]byte(`{"name":"example-org"}`))
+	}))
+	f := &Forwarder{
+		APIKeyParameter: "/mackerel/api-key",
+		svcsts:          &fakeSTS{},
+		svcssm: &fakeSSM{
+			params: map[string]string{
+				"/mackerel/api-key": "api-token",
+			},
+		},
+		svcmackerel: client,
+	}
+
+	got, err := f.Ping(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &PingResult{
+		OK:      true,
+		Version: version,
+		Checks: []PingCheck{
+			{Name: "aws-credentials", OK: true, Message: "arn:aws:sts::123456789012:assumed-role/forwarder/function"},
+			{Name: "api-key", OK: true},
+			{Name: "mackerel", OK: true, Message: "example-org"},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("result mismatch: (-want/+got):\n%s", diff)
+	}
+}
+
+func TestPing_Failure(t *testing.T) {
+	f := &Forwarder{
+		APIKeyParameter: "/mackerel/api-key",
+		svcsts:          &fakeSTS{err: errors.New("expired token")},
+		svcssm:          &fakeSSM{},
+	}
+
+	got, err := f.Ping(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &PingResult{
+		OK:      false,
+		Version: version,
+		Checks: []PingCheck{
+			{Name: "aws-credentials", OK: false, Message: "expired token"},
+			{Name: "api-key", OK: false, Message: "parameter not found"},
+			{Name: "mackerel", OK: false, Message: "skipped"},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("result mismatch: (-want/+got):\n%s", diff)
+	}
+}
+
+func TestIsPingRequest(t *testing.T) {
+	tests := []struct {
+		input string
+		want  bool
+	}{
+		{`{"ping": true}`, true},
+		{` {"ping":true} `, true},
+		{`{"ping": false}`, false},
+		{`{"version": 1, "queries": []}`, false},
+		{`[{"service":"foo","name":"ec2.cpu","metric":["AWS/EC2","CPUUtilization"]}]`, false},
+		{``, false},
+	}
+	for _, tt := range tests {
+		if got := IsPingRequest(json.RawMessage(tt.input)); got != tt.want {
+			t.Errorf("IsPingRequest(%q) = %t, want %t", tt.input, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package forwarder
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+)
+
+// placeholderPattern matches placeholders like ${env:STAGE} or ${ssm:/path/to/value}.
+var placeholderPattern = regexp.MustCompile(`\$\{(env|ssm):([^}]+)\}`)
+
+// expandPlaceholders expands the placeholders in the input.
+// The expanded values are escaped for JSON strings,
+// because the placeholders are expected to be in the string literals of the query.
+func (f *Forwarder) expandPlaceholders(ctx context.Context, data []byte) ([]byte, error) {
+	if !placeholderPattern.Match(data) {
+		return data, nil
+	}
+
+	var svcssm ssmiface
+	params := map[string]string{}
+	var lastErr error
+	ret := placeholderPattern.ReplaceAllFunc(data, func(match []byte) []byte {
+		if lastErr != nil {
+			return match
+		}
+		m := placeholderPattern.FindSubmatch(match)
+		kind, name := string(m[1]), string(m[2])
+
+		var value string
+		switch kind {
+		case "env":
+			v, ok := os.LookupEnv(name)
+			if !ok {
+				lastErr = fmt.Errorf("forwarder: environment value %q is not found", name)
+				return match
+			}
+			value = v
+		case "ssm":
+			if v, ok := params[name]; ok {
+				value = v
+				break
+			}
+			if svcssm == nil {
+				svcssm = f.ssm()
+			}
+			resp, err := svcssm.GetParameter(ctx, &ssm.GetParameterInput{
+				Name:           aws.String(name),
+				WithDecryption: aws.Bool(true),
+			})
+			if err != nil {
+				lastErr = fmt.Errorf("forwarder: failed to get the parameter %q: %w", name, err)
+				return match
+			}
+			value = aws.ToString(resp.Parameter.Value)
+			params[name] = value
+		}
+		return escapeJSONString(value)
+	})
+	if lastErr != nil {
+		return nil, lastErr
+	}
+	return ret, nil
+}
+
+// escapeJSONString escapes s for embedding into a JSON string literal.
+func escapeJSONString(s string) []byte {
+	b, err := json.Marshal(s)
+	if err != nil {
+		// it never happens because s is a string.
+		panic(err)
+	}
+	// trim the double quotes.
+	return b[1 : len(b)-1]
+}
@@ -0,0 +1,68 @@
+package forwarder
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+type fakeSSM struct {
+	params map[string]string
+	calls  int
+}
+
+func (s *fakeSSM) GetParameter(ctx context.Context, params *ssm.GetParameterInput, optFns ...func(*ssm.Options)) (*ssm.GetParameterOutput, error) {
+	s.calls++
+	v, ok := s.params[aws.ToString(params.Name)]
+	if !ok {
+		return nil, errors.New("parameter not found")
+	}
+	return &ssm.GetParameterOutput{
+		Parameter: &types.Parameter{
+			Name:  params.Name,
+			Value: aws.String(v),
+		},
+	}, nil
+}
+
+func TestExpandPlaceholders(t *testing.T) {
+	t.Setenv("FORWARDER_TEST_STAGE", "prod")
+	svcssm := &fakeSSM{
+		params: map[string]string{
+			"/service/name": `my"service`,
+		},
+	}
+	f := &Forwarder{
+		svcssm: svcssm,
+	}
+
+	in := `[{"service":"${ssm:/service/name}","name":"${env:FORWARDER_TEST_STAGE}.${ssm:/service/name}"}]`
+	got, err := f.expandPlaceholders(context.Background(), []byte(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `[{"service":"my\"service","name":"prod.my\"service"}]`
+	if string(got) != want {
+		t.Errorf("want %s, got %s", want, got)
+	}
+	if svcssm.calls != 1 {
+		t.Errorf("unexpected GetParameter call count: want 1, got %d", svcssm.calls)
+	}
+}
+
+func TestExpandPlaceholders_NotFound(t *testing.T) {
+	f := &Forwarder{
+		svcssm: &fakeSSM{},
+	}
+
+	if _, err := f.expandPlaceholders(context.Background(), []byte(`["${env:FORWARDER_TEST_UNKNOWN}"]`)); err == nil {
+		t.Error("want error, got nil")
+	}
+	if _, err := f.expandPlaceholders(context.Background(), []byte(`["${ssm:/unknown}"]`)); err == nil {
+		t.Error("want error, got nil")
+	}
+}
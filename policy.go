@@ -0,0 +1,274 @@
+package forwarder
+
+import (
+	"net/url"
+	"strings"
+)
+
+// PolicyDocument is an IAM policy document.
+type PolicyDocument struct {
+	Version   string            `json:"Version"`
+	Statement []PolicyStatement `json:"Statement"`
+}
+
+// PolicyStatement is a statement of PolicyDocument.
+// The resources may contain the pseudo parameters of CloudFormation,
+// e.g. "arn:${AWS::Partition}:ssm:${AWS::Region}:${AWS::AccountId}:parameter/foo",
+// and the conditions may contain ${AWS::URLSuffix}.
+// So the statements can be embedded in CloudFormation templates with Fn::Sub.
+// Use SubstitutePseudoParameters to resolve them.
+type PolicyStatement struct {
+	Effect    string                    `json:"Effect"`
+	Action    []string                  `json:"Action"`
+	Resource  []string                  `json:"Resource"`
+	Condition map[string]map[string]any `json:"Condition,omitempty"`
+}
+
+// PolicyOptions is the options of RequiredPolicy.
+type PolicyOptions struct {
+	// ParameterName is the name of the SSM parameter that stores the API key of Mackerel.
+	ParameterName string
+
+	// KMSKeyARN is the ARN of the KMS key that encrypts the API key.
+	// If it is empty, the keys used via SSM are allowed.
+	KMSKeyARN string
+
+	// StateStore is the URI of the state store, e.g. "s3://bucket/path/to/state.json".
+	StateStore string
+
+	// StateKMSKeyARN is the ARN of the KMS key that encrypts the state.
+	StateKMSKeyARN string
+
+	// IdempotencyTable is the name of the DynamoDB table for IdempotencyStore.
+	IdempotencyTable string
+
+	// SyncHostMetadata indicates whether the host metadata is synced from the tags of the resources.
+	SyncHostMetadata bool
+
+	// VerifyMetrics indicates whether the metrics of the queries are verified by ListMetrics.
+	VerifyMetrics bool
+}
+
+// RequiredPolicy returns the IAM policy that the forwarder requires for the queries.
+func RequiredPolicy(queries []*Query, opts *PolicyOptions) *PolicyDocument {
+	return requiredPolicy(queries, nil, opts)
+}
+
+// RequiredPolicyForInput returns the IAM policy that the forwarder requires for the input of ForwardMetrics.
+// In addition to RequiredPolicy, it allows the SSM parameters that the placeholders reference.
+func RequiredPolicyForInput(data []byte, opts *PolicyOptions) (*PolicyDocument, error) {
+	var parameters []string
+	for _, m := range placeholderPattern.FindAllSubmatch(data, -1) {
+		if string(m[1]) == "ssm" {
+			parameters = append(parameters, string(m[2]))
+		}
+	}
+	queries, err := ParseQueries(data)
+	if err != nil {
+		return nil, err
+	}
+	return requiredPolicy(queries, parameters, opts), nil
+}
+
+func requiredPolicy(queries []*Query, parameters []string, opts *PolicyOptions) *PolicyDocument {
+	if opts == nil {
+		opts = &PolicyOptions{}
+	}
+
+	var b policyBuilder
+	for _, q := range queries {
+		switch q.Type {
+		case "", queryTypeMetric:
+			if q.API == apiStatistics {
+				b.allow("cloudwatch:GetMetricStatistics", "*")
+			} else {
+				b.allow("cloudwatch:GetMetricData", "*")
+			}
+			if q.hasWildcard() || opts.VerifyMetrics {
+				b.allow("cloudwatch:ListMetrics", "*")
+			}
+		case queryTypeLogs:
+			b.allow("logs:FilterLogEvents", "arn:${AWS::Partition}:logs:${AWS::Region}:${AWS::AccountId}:log-group:"+q.LogGroup+":*")
+		case queryTypePerformanceInsights:
+			if pq := q.PerformanceInsights; pq != nil {
+				serviceType := pq.ServiceType
+				if serviceType == "" {
+					serviceType = "RDS"
+				}
+				b.allow("pi:GetResourceMetrics", "arn:${AWS::Partition}:pi:${AWS::Region}:${AWS::AccountId}:metrics/"+strings.ToLower(serviceType)+"/"+pq.Identifier)
+			}
+		case queryTypeServiceQuota:
+			if sq := q.ServiceQuota; sq != nil {
+				b.allow("servicequotas:GetServiceQuota", "arn:${AWS::Partition}:servicequotas:${AWS::Region}:${AWS::AccountId}:"+sq.ServiceCode+"/"+sq.QuotaCode)
+				b.allow("cloudwatch:GetMetricStatistics", "*")
+			}
+		}
+		if q.Discover != nil || q.ResourceARN != "" && (opts.SyncHostMetadata || hasTagPlaceholder(q.Service)) {
+			b.allow("tag:GetResources", "*")
+		}
+	}
+
+	if opts.ParameterName != "" {
+		parameters = append([]string{opts.ParameterName}, parameters...)
+	}
+	for _, name := range parameters {
+		b.allow("ssm:GetParameter", ssmParameterARN(name))
+	}
+	if opts.KMSKeyARN != "" {
+		b.allow("kms:Decrypt", opts.KMSKeyARN)
+	} else if len(parameters) > 0 {
+		b.statements = append(b.statements, PolicyStatement{
+			Effect:   "Allow",
+			Action:   []string{"kms:Decrypt"},
+			Resource: []string{"arn:${AWS::Partition}:kms:${AWS::Region}:${AWS::AccountId}:key/*"},
+			Condition: map[string]map[string]any{
+				"StringLike": {
+					"kms:ViaService": "ssm.${AWS::Region}.${AWS::URLSuffix}",
+				},
+			},
+		})
+	}
+
+	if u, err := url.Parse(opts.StateStore); err == nil && u.Scheme == "s3" && u.Host != "" {
+		resource := "arn:${AWS::Partition}:s3:::" + u.Host + "/" + strings.TrimPrefix(u.Path, "/")
+		b.allow("s3:GetObject", resource)
+		b.allow("s3:PutObject", resource)
+		if opts.StateKMSKeyARN != "" {
+			b.allow("kms:GenerateDataKey", opts.StateKMSKeyARN)
+			b.allow("kms:Decrypt", opts.StateKMSKeyARN)
+		}
+	}
+
+	if opts.IdempotencyTable != "" {
+		resource := "arn:${AWS::Partition}:dynamodb:${AWS::Region}:${AWS::AccountId}:table/" + opts.IdempotencyTable
+		b.allow("dynamodb:PutItem", resource)
+		b.allow("dynamodb:UpdateItem", resource)
+		b.allow("dynamodb:DeleteItem", resource)
+	}
+
+	return &PolicyDocument{
+		Version:   "2012-10-17",
+		Statement: b.build(),
+	}
+}
+
+// policyBuilder builds the statements.
+// The actions for the same resources are merged into a statement.
+type policyBuilder struct {
+	statements []PolicyStatement
+}
+
+func (b *policyBuilder) allow(action, resource string) {
+	for i := range b.statements {
+		s := &b.statements[i]
+		if s.Condition != nil || len(s.Resource) != 1 || s.Resource[0] != resource {
+			continue
+		}
+		for _, a := range s.Action {
+			if a == action {
+				return
+			}
+		}
+		s.Action = append(s.Action, action)
+		return
+	}
+	b.statements = append(b.statements, PolicyStatement{
+		Effect:   "Allow",
+		Action:   []string{action},
+		Resource: []string{resource},
+	})
+}
+
+// build returns the statements.
+// The statements with the same actions are merged.
+func (b *policyBuilder) build() []PolicyStatement {
+	ret := make([]PolicyStatement, 0, len(b.statements))
+	index := make(map[string]int, len(b.statements))
+	for _, s := range b.statements {
+		if s.Condition != nil {
+			ret = append(ret, s)
+			continue
+		}
+		key := strings.Join(s.Action, ",")
+		if i, ok := index[key]; ok {
+			ret[i].Resource = append(ret[i].Resource, s.Resource...)
+			continue
+		}
+		index[key] = len(ret)
+		ret = append(ret, s)
+	}
+	return ret
+}
+
+func ssmParameterARN(name string) string {
+	return "arn:${AWS::Partition}:ssm:${AWS::Region}:${AWS::AccountId}:parameter/" + strings.TrimPrefix(name, "/")
+}
+
+// PseudoParameters is the values of the pseudo parameters of CloudFormation.
+type PseudoParameters struct {
+	// Partition is the partition, e.g. "aws", "aws-us-gov", and "aws-cn".
+	// The default is the partition of Region, or "aws" if Region is empty.
+	Partition string
+
+	// Region is the region, e.g. "ap-northeast-1". The default is "*".
+	Region string
+
+	// AccountID is the id of the account, e.g. "123456789012". The default is "*".
+	AccountID string
+}
+
+// SubstitutePseudoParameters returns a copy of the policy whose pseudo parameters are substituted.
+func SubstitutePseudoParameters(doc *PolicyDocument, params PseudoParameters) *PolicyDocument {
+	partition := PartitionForRegion(params.Region)
+	if p, ok := PartitionByID(params.Partition); ok {
+		partition = p
+	} else if params.Partition != "" {
+		partition = Partition{ID: params.Partition, DNSSuffix: partition.DNSSuffix}
+	}
+	region := params.Region
+	if region == "" {
+		region = "*"
+	}
+	account := params.AccountID
+	if account == "" {
+		account = "*"
+	}
+	r := strings.NewReplacer(
+		"${AWS::Partition}", partition.ID,
+		"${AWS::URLSuffix}", partition.DNSSuffix,
+		"${AWS::Region}", region,
+		"${AWS::AccountId}", account,
+	)
+
+	ret := &PolicyDocument{
+		Version:   doc.Version,
+		Statement: make([]PolicyStatement, 0, len(doc.Statement)),
+	}
+	for _, s := range doc.Statement {
+		resources := make([]string, 0, len(s.Resource))
+		for _, res := range s.Resource {
+			resources = append(resources, r.Replace(res))
+		}
+		var condition map[string]map[string]any
+		if s.Condition != nil {
+			condition = make(map[string]map[string]any, len(s.Condition))
+			for op, values := range s.Condition {
+				m := make(map[string]any, len(values))
+				for k, v := range values {
+					if str, ok := v.(string); ok {
+						v = r.Replace(str)
+					}
+					m[k] = v
+				}
+				condition[op] = m
+			}
+		}
+		ret.Statement = append(ret.Statement, PolicyStatement{
+			Effect:    s.Effect,
+			Action:    append([]string(nil), s.Action...),
+			Resource:  resources,
+			Condition: condition,
+		})
+	}
+	return ret
+}
@@ -0,0 +1,106 @@
+package forwarder
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRequiredPolicy(t *testing.T) {
+	queries := []*Query{
+		{Service: "foo", Name: "a", Metric: []interface{}{"AWS/EC2", "CPUUtilization"}, Stat: "Average"},
+		{Service: "foo", Name: "b", Metric: []interface{}{"AWS/EC2", "CPUUtilization"}, Stat: "Average", API: apiStatistics},
+	}
+	got := RequiredPolicy(queries, &PolicyOptions{
+		ParameterName: "/api-keys/mackerel",
+		KMSKeyARN:     "arn:aws:kms:ap-northeast-1:123456789012:key/1234abcd-12ab-34cd-56ef-1234567890ab",
+	})
+	want := &PolicyDocument{
+		Version: "2012-10-17",
+		Statement: []PolicyStatement{
+			{
+				Effect:   "Allow",
+				Action:   []string{"cloudwatch:GetMetricData", "cloudwatch:GetMetricStatistics"},
+				Resource: []string{"*"},
+			},
+			{
+				Effect:   "Allow",
+				Action:   []string{"ssm:GetParameter"},
+				Resource: []string{"arn:${AWS::Partition}:ssm:${AWS::Region}:${AWS::AccountId}:parameter/api-keys/mackerel"},
+			},
+			{
+				Effect:   "Allow",
+				Action:   []string{"kms:Decrypt"},
+				Resource: []string{"arn:aws:kms:ap-northeast-1:123456789012:key/1234abcd-12ab-34cd-56ef-1234567890ab"},
+			},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("policy mismatch: (-want/+got):\n%s", diff)
+	}
+}
+
+func TestRequiredPolicyForInput(t *testing.T) {
+	data := []byte(`[
+		{"service": "${ssm:/stage/service}", "name": "a", "metric": ["AWS/EC2", "CPUUtilization"], "stat": "Average"},
+		{"type": "logs", "service": "foo", "name": "errors", "logGroup": "/aws/lambda/foo", "filterPattern": "ERROR"},
+		{"type": "serviceQuota", "service": "foo", "name": "quota", "serviceQuota": {"serviceCode": "lambda", "quotaCode": "L-B99A9384"}}
+	]`)
+	doc, err := RequiredPolicyForInput(data, &PolicyOptions{
+		ParameterName: "/api-keys/mackerel",
+		StateStore:    "s3://bucket/state.json",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := SubstitutePseudoParameters(doc, PseudoParameters{
+		Region:    "ap-northeast-1",
+		AccountID: "123456789012",
+	})
+	want := &PolicyDocument{
+		Version: "2012-10-17",
+		Statement: []PolicyStatement{
+			{
+				Effect:   "Allow",
+				Action:   []string{"cloudwatch:GetMetricData", "cloudwatch:GetMetricStatistics"},
+				Resource: []string{"*"},
+			},
+			{
+				Effect:   "Allow",
+				Action:   []string{"logs:FilterLogEvents"},
+				Resource: []string{"arn:aws:logs:ap-northeast-1:123456789012:log-group:/aws/lambda/foo:*"},
+			},
+			{
+				Effect:   "Allow",
+				Action:   []string{"servicequotas:GetServiceQuota"},
+				Resource: []string{"arn:aws:servicequotas:ap-northeast-1:123456789012:lambda/L-B99A9384"},
+			},
+			{
+				Effect: "Allow",
+				Action: []string{"ssm:GetParameter"},
+				Resource: []string{
+					"arn:aws:ssm:ap-northeast-1:123456789012:parameter/api-keys/mackerel",
+					"arn:aws:ssm:ap-northeast-1:123456789012:parameter/stage/service",
+				},
+			},
+			{
+				Effect:   "Allow",
+				Action:   []string{"kms:Decrypt"},
+				Resource: []string{"arn:aws:kms:ap-northeast-1:123456789012:key/*"},
+				Condition: map[string]map[string]any{
+					"StringLike": {
+						"kms:ViaService": "ssm.ap-northeast-1.amazonaws.com",
+					},
+				},
+			},
+			{
+				Effect:   "Allow",
+				Action:   []string{"s3:GetObject", "s3:PutObject"},
+				Resource: []string{"arn:aws:s3:::bucket/state.json"},
+			},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("policy mismatch: (-want/+got):\n%s", diff)
+	}
+}
@@ -0,0 +1,256 @@
+package forwarder
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// queryPreset is the standard metric set of an AWS service.
+type queryPreset struct {
+	Namespace string
+
+	// Dimensions is the required dimensions.
+	Dimensions []string
+
+	Metrics []presetMetric
+
+	// Names is the metric names on Mackerel of the metrics that the AWS integration of Mackerel doesn't collect.
+	Names map[string]string
+
+	// Delay and Period are the defaults of the expanded queries.
+	Delay  string
+	Period string
+
+	// ServiceMetric means the metrics are forwarded only as the service metrics,
+	// because they don't belong to any resources, e.g. the billing metrics of the account.
+	ServiceMetric bool
+
+	// CheckMetric is the metric that the check rule of the query applies to.
+	// If it is empty, the check rule applies to all the metrics.
+	CheckMetric string
+
+	// CheckOperator is the default operator of the check rule, e.g. "<" for the success rates.
+	CheckOperator string
+}
+
+// presetMetric is a metric of queryPreset.
+type presetMetric struct {
+	Name string
+	Stat string
+}
+
+// queryPresets is the built-in presets.
+// The metrics are named by the naming "integration", so all of them must be in integrationMetricNames or Names.
+var queryPresets = map[string]*queryPreset{
+	"alb": {
+		Namespace:  "AWS/ApplicationELB",
+		Dimensions: []string{"LoadBalancer"},
+		Metrics: []presetMetric{
+			{"RequestCount", "Sum"},
+			{"TargetResponseTime", "Average"},
+			{"ActiveConnectionCount", "Sum"},
+			{"NewConnectionCount", "Sum"},
+			{"HTTPCode_Target_2XX_Count", "Sum"},
+			{"HTTPCode_Target_3XX_Count", "Sum"},
+			{"HTTPCode_Target_4XX_Count", "Sum"},
+			{"HTTPCode_Target_5XX_Count", "Sum"},
+			{"HTTPCode_ELB_4XX_Count", "Sum"},
+			{"HTTPCode_ELB_5XX_Count", "Sum"},
+			{"ProcessedBytes", "Sum"},
+		},
+	},
+	"sqs": {
+		Namespace:  "AWS/SQS",
+		Dimensions: []string{"QueueName"},
+		Metrics: []presetMetric{
+			{"ApproximateNumberOfMessagesVisible", "Maximum"},
+			{"ApproximateNumberOfMessagesNotVisible", "Maximum"},
+			{"ApproximateNumberOfMessagesDelayed", "Maximum"},
+			{"ApproximateAgeOfOldestMessage", "Maximum"},
+			{"NumberOfMessagesSent", "Sum"},
+			{"NumberOfMessagesReceived", "Sum"},
+			{"NumberOfMessagesDeleted", "Sum"},
+		},
+	},
+	"lambda": {
+		Namespace:  "AWS/Lambda",
+		Dimensions: []string{"FunctionName"},
+		Metrics: []presetMetric{
+			{"Invocations", "Sum"},
+			{"Errors", "Sum"},
+			{"Throttles", "Sum"},
+			{"Duration", "Average"},
+			{"ConcurrentExecutions", "Maximum"},
+		},
+	},
+	"rds": {
+		Namespace:  "AWS/RDS",
+		Dimensions: []string{"DBInstanceIdentifier"},
+		Metrics: []presetMetric{
+			{"CPUUtilization", "Average"},
+			{"FreeableMemory", "Minimum"},
+			{"FreeStorageSpace", "Minimum"},
+			{"DatabaseConnections", "Maximum"},
+			{"ReadIOPS", "Average"},
+			{"WriteIOPS", "Average"},
+			{"ReadLatency", "Average"},
+			{"WriteLatency", "Average"},
+		},
+	},
+	"elasticache": {
+		Namespace:  "AWS/ElastiCache",
+		Dimensions: []string{"CacheClusterId"},
+		Metrics: []presetMetric{
+			{"CPUUtilization", "Average"},
+			{"FreeableMemory", "Minimum"},
+			{"CurrConnections", "Maximum"},
+			{"Evictions", "Sum"},
+			{"CacheHits", "Sum"},
+			{"CacheMisses", "Sum"},
+		},
+	},
+	"dynamodb": {
+		Namespace:  "AWS/DynamoDB",
+		Dimensions: []string{"TableName"},
+		Metrics: []presetMetric{
+			{"ConsumedReadCapacityUnits", "Sum"},
+			{"ConsumedWriteCapacityUnits", "Sum"},
+			{"ReadThrottleEvents", "Sum"},
+			{"WriteThrottleEvents", "Sum"},
+		},
+	},
+	"billing": {
+		Namespace:  billingNamespace,
+		Dimensions: []string{"Currency"},
+		Metrics: []presetMetric{
+			{"EstimatedCharges", "Maximum"},
+		},
+		Names: map[string]string{
+			"EstimatedCharges": "billing.estimated_charges",
+		},
+		// CloudWatch publishes the billing metrics several times a day.
+		// The hourly data points are fetched 3 hours later, so that they are still in the default max metric age (6 hours).
+		Delay:         "3h",
+		Period:        "1h",
+		ServiceMetric: true,
+	},
+	"synthetics": {
+		Namespace:  "CloudWatchSynthetics",
+		Dimensions: []string{"CanaryName"},
+		Metrics: []presetMetric{
+			{"SuccessPercent", "Average"},
+			{"Duration", "Average"},
+		},
+		Names: map[string]string{
+			"SuccessPercent": "synthetics.success_percent",
+			"Duration":       "synthetics.duration",
+		},
+		CheckMetric:   "SuccessPercent",
+		CheckOperator: "<",
+	},
+	"rum": {
+		Namespace:  "AWS/RUM",
+		Dimensions: []string{"application_name"},
+		Metrics: []presetMetric{
+			{"SessionCount", "Sum"},
+			{"JsErrorCount", "Sum"},
+			{"HttpErrorCount", "Sum"},
+			{"PerformanceNavigationDuration", "Average"},
+		},
+		Names: map[string]string{
+			"SessionCount":                  "rum.sessions",
+			"JsErrorCount":                  "rum.errors.js",
+			"HttpErrorCount":                "rum.errors.http",
+			"PerformanceNavigationDuration": "rum.navigation_duration",
+		},
+	},
+}
+
+// expandPresets expands the queries with presets into the queries of the standard metric set.
+// The other fields of the query, e.g. Service, Host and Delay, are inherited by the expanded queries.
+func expandPresets(query []*Query) ([]*Query, error) {
+	var ret []*Query
+	var errs QueryErrors
+	for i, q := range query {
+		if q.Preset == "" {
+			if len(q.Dimensions) > 0 {
+				errs = append(errs, &QueryError{
+					Index: i,
+					Err:   errors.New("dimensions are available only with presets"),
+				})
+				continue
+			}
+			ret = append(ret, q)
+			continue
+		}
+		expanded, err := q.expandPreset()
+		if err != nil {
+			errs = append(errs, &QueryError{Index: i, Err: err})
+			continue
+		}
+		ret = append(ret, expanded...)
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return ret, nil
+}
+
+func (q *Query) expandPreset() ([]*Query, error) {
+	preset, ok := queryPresets[q.Preset]
+	if !ok {
+		return nil, fmt.Errorf("unknown preset: %q", q.Preset)
+	}
+	if q.Name != "" || q.ID != "" || q.Stat != "" || len(q.Metric) > 0 || q.Expression != "" {
+		return nil, fmt.Errorf("name, id, stat, metric and expression can't be specified with preset %q", q.Preset)
+	}
+	if q.Type != "" && q.Type != queryTypeMetric {
+		return nil, fmt.Errorf("preset is available only for metric type queries, but the type is %q", q.Type)
+	}
+	if preset.ServiceMetric && q.Host != "" {
+		return nil, fmt.Errorf("preset %q is available only for service metrics, but the host is specified", q.Preset)
+	}
+	for _, d := range preset.Dimensions {
+		if q.Dimensions[d] == "" {
+			return nil, fmt.Errorf("dimension %q is required for preset %q", d, q.Preset)
+		}
+	}
+
+	keys := make([]string, 0, len(q.Dimensions))
+	for k := range q.Dimensions {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	ret := make([]*Query, 0, len(preset.Metrics))
+	for _, m := range preset.Metrics {
+		metric := []interface{}{preset.Namespace, m.Name}
+		for _, k := range keys {
+			metric = append(metric, k, q.Dimensions[k])
+		}
+		qq := *q
+		qq.Preset = ""
+		qq.Dimensions = nil
+		qq.Naming = namingIntegration
+		qq.Metric = metric
+		qq.Stat = m.Stat
+		qq.Delay = cmp.Or(q.Delay, preset.Delay)
+		qq.Period = cmp.Or(q.Period, preset.Period)
+		if name, ok := preset.Names[m.Name]; ok {
+			qq.Naming = ""
+			qq.Name = name
+		}
+		if q.Check != nil && preset.CheckMetric != "" {
+			qq.Check = nil
+			if m.Name == preset.CheckMetric {
+				check := *q.Check
+				check.Operator = cmp.Or(check.Operator, preset.CheckOperator)
+				qq.Check = &check
+			}
+		}
+		ret = append(ret, &qq)
+	}
+	return ret, nil
+}
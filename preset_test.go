@@ -0,0 +1,138 @@
+package forwarder
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestQueryPresets(t *testing.T) {
+	for name, preset := range queryPresets {
+		for _, m := range preset.Metrics {
+			if _, ok := preset.Names[m.Name]; ok {
+				continue
+			}
+			if _, ok := IntegrationMetricName(preset.Namespace, m.Name); !ok {
+				t.Errorf("%s: no metric name of the integration for %s %s", name, preset.Namespace, m.Name)
+			}
+		}
+	}
+}
+
+func TestParseQueries_Preset(t *testing.T) {
+	input := `[
+		{"host": "host-abc", "preset": "lambda", "dimensions": {"FunctionName": "my-function", "Resource": "my-function:prod"}},
+		{"service": "foo", "name": "custom.sqs.sent", "metric": ["AWS/SQS", "NumberOfMessagesSent", "QueueName", "my-queue"], "stat": "Sum"}
+	]`
+	query, err := ParseQueries([]byte(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolved, errs := prepareQueries(query)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+
+	type result struct {
+		Label  string
+		Metric []interface{}
+		Stat   string
+	}
+	var got []result
+	for _, q := range resolved {
+		got = append(got, result{
+			Label:  q.Label.String(),
+			Metric: q.Query.Metric,
+			Stat:   q.Stat,
+		})
+	}
+	dims := []interface{}{"FunctionName", "my-function", "Resource", "my-function:prod"}
+	want := []result{
+		{"host=host-abc:lambda.count.invocations", append([]interface{}{"AWS/Lambda", "Invocations"}, dims...), "Sum"},
+		{"host=host-abc:lambda.count.errors", append([]interface{}{"AWS/Lambda", "Errors"}, dims...), "Sum"},
+		{"host=host-abc:lambda.count.throttles", append([]interface{}{"AWS/Lambda", "Throttles"}, dims...), "Sum"},
+		{"host=host-abc:lambda.duration.duration", append([]interface{}{"AWS/Lambda", "Duration"}, dims...), "Average"},
+		{"host=host-abc:lambda.concurrent_executions.count", append([]interface{}{"AWS/Lambda", "ConcurrentExecutions"}, dims...), "Maximum"},
+		{"service=foo:custom.sqs.sent", []interface{}{"AWS/SQS", "NumberOfMessagesSent", "QueueName", "my-queue"}, "Sum"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("queries mismatch: (-want/+got):\n%s", diff)
+	}
+}
+
+func TestParseQueries_InvalidPreset(t *testing.T) {
+	inputs := []string{
+		`[{"service": "foo", "preset": "unknown", "dimensions": {"QueueName": "my-queue"}}]`,
+		`[{"service": "foo", "preset": "sqs"}]`,
+		`[{"service": "foo", "preset": "sqs", "name": "foo", "dimensions": {"QueueName": "my-queue"}}]`,
+		`[{"service": "foo", "name": "foo", "metric": ["AWS/SQS", "NumberOfMessagesSent"], "dimensions": {"QueueName": "my-queue"}}]`,
+	}
+	for _, input := range inputs {
+		if _, err := ParseQueries([]byte(input)); err == nil {
+			t.Errorf("%s: want error, got nil", input)
+		}
+	}
+}
+
+func TestParseQueries_BillingPreset(t *testing.T) {
+	query, err := ParseQueries([]byte(`[{"service": "foo", "preset": "billing", "dimensions": {"Currency": "USD"}}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolved, errs := prepareQueries(query)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if len(resolved) != 1 {
+		t.Fatalf("want 1 query, got %d", len(resolved))
+	}
+	q := resolved[0]
+	if got, want := q.Label.String(), "service=foo:billing.estimated_charges"; got != want {
+		t.Errorf("want %q, got %q", want, got)
+	}
+	if q.Namespace != "AWS/Billing" || q.MetricName != "EstimatedCharges" || q.Stat != "Maximum" {
+		t.Errorf("unexpected metric: %s %s %s", q.Namespace, q.MetricName, q.Stat)
+	}
+	if q.Delay != 3*time.Hour || q.period() != time.Hour {
+		t.Errorf("want delay 3h and period 1h, got delay %s, period %s", q.Delay, q.period())
+	}
+
+	// the billing metrics are not of hosts.
+	if _, err := ParseQueries([]byte(`[{"host": "host-abc", "preset": "billing", "dimensions": {"Currency": "USD"}}]`)); err == nil {
+		t.Error("want error, got nil")
+	}
+}
+
+func TestParseQueries_SyntheticsPreset(t *testing.T) {
+	query, err := ParseQueries([]byte(`[{"host": "host-abc", "preset": "synthetics", "dimensions": {"CanaryName": "my-canary"}, "check": {"name": "canary", "critical": 90}}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolved, errs := prepareQueries(query)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+
+	type result struct {
+		Label string
+		Check *CheckRule
+	}
+	var got []result
+	for _, q := range resolved {
+		got = append(got, result{Label: q.Label.String(), Check: q.Query.Check})
+	}
+	critical := 90.0
+	want := []result{
+		{"host=host-abc:synthetics.success_percent", &CheckRule{Name: "canary", Critical: &critical, Operator: "<"}},
+		{"host=host-abc:synthetics.duration", nil},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("queries mismatch: (-want/+got):\n%s", diff)
+	}
+
+	// the success rate drops below the threshold.
+	if status, _ := got[0].Check.Evaluate(85); status != CheckStatusCritical {
+		t.Errorf("want %s, got %s", CheckStatusCritical, status)
+	}
+}
@@ -0,0 +1,181 @@
+package forwarder
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/golang/snappy"
+)
+
+// PrometheusPublisher publishes the metrics using the Prometheus remote write protocol.
+// The metric names are converted into Prometheus style, e.g. "custom.foo.bar" to "custom_foo_bar",
+// and the service name and the host id are attached as the "service" and "host_id" labels.
+type PrometheusPublisher struct {
+	// URL is the endpoint of the remote write.
+	URL string
+
+	// HTTPClient is the client for the remote write.
+	// If it is nil, http.DefaultClient is used.
+	HTTPClient *http.Client
+
+	// AWSConfig is the config for signing the requests with AWS Signature Version 4.
+	// It is required for Amazon Managed Service for Prometheus.
+	// If it is nil, the requests are not signed.
+	AWSConfig *aws.Config
+}
+
+var _ Publisher = (*PrometheusPublisher)(nil)
+
+// PostServiceMetricValues implements Publisher.
+func (p *PrometheusPublisher) PostServiceMetricValues(ctx context.Context, serviceName string, values []ServiceMetricValue) error {
+	series := make([]promTimeSeries, 0, len(values))
+	for _, v := range values {
+		series = append(series, promTimeSeries{
+			Labels: []promLabel{
+				{Name: "__name__", Value: prometheusMetricName(v.Name)},
+				{Name: "service", Value: serviceName},
+			},
+			Value:     v.Value,
+			Timestamp: v.Time * 1000,
+		})
+	}
+	return p.write(ctx, series)
+}
+
+// PostHostMetricValues implements Publisher.
+func (p *PrometheusPublisher) PostHostMetricValues(ctx context.Context, values []HostMetricValue) error {
+	series := make([]promTimeSeries, 0, len(values))
+	for _, v := range values {
+		series = append(series, promTimeSeries{
+			Labels: []promLabel{
+				{Name: "__name__", Value: prometheusMetricName(v.Name)},
+				{Name: "host_id", Value: v.HostID},
+			},
+			Value:     v.Value,
+			Timestamp: v.Time * 1000,
+		})
+	}
+	return p.write(ctx, series)
+}
+
+func (p *PrometheusPublisher) write(ctx context.Context, series []promTimeSeries) error {
+	if len(series) == 0 {
+		return nil
+	}
+
+	// the samples of each series must be in order of the timestamp.
+	sort.SliceStable(series, func(i, j int) bool {
+		return series[i].Timestamp < series[j].Timestamp
+	})
+	body := snappy.Encode(nil, encodeWriteRequest(series))
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+	if cfg := p.AWSConfig; cfg != nil {
+		creds, err := cfg.Credentials.Retrieve(ctx)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(body)
+		if err := v4.NewSigner().SignHTTP(ctx, creds, req, hex.EncodeToString(sum[:]), "aps", cfg.Region, time.Now()); err != nil {
+			return err
+		}
+	}
+
+	client := p.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("forwarder: remote write error: status: %d, %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// prometheusMetricName converts the metric name of Mackerel into the one of Prometheus.
+func prometheusMetricName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' || r == '_' || r == ':' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+type promLabel struct {
+	Name  string
+	Value string
+}
+
+// promTimeSeries is a time series that has only one sample.
+type promTimeSeries struct {
+	Labels    []promLabel
+	Value     float64
+	Timestamp int64 // in milliseconds
+}
+
+// encodeWriteRequest encodes the time series into the protocol buffers of prometheus.WriteRequest.
+//
+//	message WriteRequest { repeated TimeSeries timeseries = 1; }
+//	message TimeSeries { repeated Label labels = 1; repeated Sample samples = 2; }
+//	message Label { string name = 1; string value = 2; }
+//	message Sample { double value = 1; int64 timestamp = 2; }
+func encodeWriteRequest(series []promTimeSeries) []byte {
+	var buf, ts, msg []byte
+	for _, s := range series {
+		ts = ts[:0]
+		for _, l := range s.Labels {
+			msg = msg[:0]
+			msg = appendProtoBytes(msg, 1, []byte(l.Name))
+			msg = appendProtoBytes(msg, 2, []byte(l.Value))
+			ts = appendProtoBytes(ts, 1, msg)
+		}
+
+		msg = msg[:0]
+		msg = protoAppendVarint(msg, 1<<3|1) // field 1, fixed64
+		msg = binary.LittleEndian.AppendUint64(msg, math.Float64bits(s.Value))
+		msg = protoAppendVarint(msg, 2<<3|0) // field 2, varint
+		msg = protoAppendVarint(msg, uint64(s.Timestamp))
+		ts = appendProtoBytes(ts, 2, msg)
+
+		buf = appendProtoBytes(buf, 1, ts)
+	}
+	return buf
+}
+
+// appendProtoBytes appends the length-delimited field.
+func appendProtoBytes(b []byte, field int, v []byte) []byte {
+	b = protoAppendVarint(b, uint64(field)<<3|2)
+	b = protoAppendVarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+func protoAppendVarint(b []byte, v uint64) []byte {
+	return binary.AppendUvarint(b, v)
+}
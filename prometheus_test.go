@@ -0,0 +1,136 @@
+package forwarder
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang/snappy"
+	"github.com/google/go-cmp/cmp"
+)
+
+// decodeWriteRequest decodes prometheus.WriteRequest for testing.
+func decodeWriteRequest(t *testing.T, b []byte) []promTimeSeries {
+	t.Helper()
+	var ret []promTimeSeries
+	for _, ts := range decodeProtoFields(t, b)[1] {
+		fields := decodeProtoFields(t, ts)
+		var s promTimeSeries
+		for _, l := range fields[1] {
+			lf := decodeProtoFields(t, l)
+			s.Labels = append(s.Labels, promLabel{Name: string(lf[1][0]), Value: string(lf[2][0])})
+		}
+		sample := fields[2][0]
+		if sample[0] != 1<<3|1 {
+			t.Fatalf("unexpected tag: %x", sample[0])
+		}
+		s.Value = math.Float64frombits(binary.LittleEndian.Uint64(sample[1:9]))
+		if sample[9] != 2<<3|0 {
+			t.Fatalf("unexpected tag: %x", sample[9])
+		}
+		v, _ := binary.Uvarint(sample[10:])
+		s.Timestamp = int64(v)
+		ret = append(ret, s)
+	}
+	return ret
+}
+
+// decodeProtoFields decodes the length-delimited fields.
+func decodeProtoFields(t *testing.T, b []byte) map[int][][]byte {
+	t.Helper()
+	ret := map[int][][]byte{}
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		b = b[n:]
+		if tag&7 != 2 {
+			t.Fatalf("unexpected wire type: %d", tag&7)
+		}
+		l, n := binary.Uvarint(b)
+		b = b[n:]
+		ret[int(tag>>3)] = append(ret[int(tag>>3)], b[:l])
+		b = b[l:]
+	}
+	return ret
+}
+
+func TestPrometheusPublisher(t *testing.T) {
+	var got []promTimeSeries
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "snappy" {
+			t.Errorf("unexpected Content-Encoding: %q", r.Header.Get("Content-Encoding"))
+		}
+		if r.Header.Get("Content-Type") != "application/x-protobuf" {
+			t.Errorf("unexpected Content-Type: %q", r.Header.Get("Content-Type"))
+		}
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := snappy.Decode(nil, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, decodeWriteRequest(t, decoded)...)
+		rw.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	p := &PrometheusPublisher{
+		URL: ts.URL,
+	}
+	err := p.PostServiceMetricValues(context.Background(), "service", []ServiceMetricValue{
+		{Name: "custom.foo-bar", Time: 1234567920, Value: 2},
+		{Name: "custom.foo-bar", Time: 1234567860, Value: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.PostHostMetricValues(context.Background(), []HostMetricValue{
+		{HostID: "host-abc", Name: "custom.baz", Time: 1234567860, Value: 3.5},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []promTimeSeries{
+		{
+			Labels:    []promLabel{{Name: "__name__", Value: "custom_foo_bar"}, {Name: "service", Value: "service"}},
+			Value:     1,
+			Timestamp: 1234567860000,
+		},
+		{
+			Labels:    []promLabel{{Name: "__name__", Value: "custom_foo_bar"}, {Name: "service", Value: "service"}},
+			Value:     2,
+			Timestamp: 1234567920000,
+		},
+		{
+			Labels:    []promLabel{{Name: "__name__", Value: "custom_baz"}, {Name: "host_id", Value: "host-abc"}},
+			Value:     3.5,
+			Timestamp: 1234567860000,
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("time series mismatch: (-want/+got):\n%s", diff)
+	}
+}
+
+func TestPrometheusPublisher_Error(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		http.Error(rw, "out of order sample", http.StatusBadRequest)
+	}))
+	defer ts.Close()
+
+	p := &PrometheusPublisher{
+		URL: ts.URL,
+	}
+	err := p.PostHostMetricValues(context.Background(), []HostMetricValue{
+		{HostID: "host-abc", Name: "custom.baz", Time: 1234567860, Value: 3.5},
+	})
+	if err == nil {
+		t.Error("want error, got nil")
+	}
+}
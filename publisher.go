@@ -0,0 +1,50 @@
+package forwarder
+
+import (
+	"context"
+)
+
+// Publisher publishes the metrics to a time series database.
+// MackerelClient is an implementation of Publisher.
+type Publisher interface {
+	// PostServiceMetricValues posts the service metrics.
+	PostServiceMetricValues(ctx context.Context, serviceName string, values []ServiceMetricValue) error
+
+	// PostHostMetricValues posts the host metrics.
+	PostHostMetricValues(ctx context.Context, values []HostMetricValue) error
+}
+
+var _ Publisher = (*MackerelClient)(nil)
+
+// publishers returns the publishers to which the metrics are dual-written in addition to Mackerel.
+func (f *Forwarder) publishers(ctx context.Context) []Publisher {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.svcpublishers != nil {
+		return f.svcpublishers
+	}
+
+	env := f.env()
+	publishers := make([]Publisher, 0, len(f.Publishers)+1)
+	publishers = append(publishers, f.Publishers...)
+	if u := env.PrometheusRemoteWriteURL; u != "" {
+		p := &PrometheusPublisher{
+			URL: u,
+		}
+		if env.PrometheusRemoteWriteSigV4 {
+			cfg := f.Config
+			p.AWSConfig = &cfg
+		}
+		publishers = append(publishers, p)
+	}
+	if endpoint := env.OTLPEndpoint; endpoint != "" {
+		p, err := newOTLPPublisherFromEnv(endpoint, env.OTLPHeaders)
+		if err != nil {
+			f.logger().WarnContext(ctx, "failed to configure the otlp publisher, skips", "error", err.Error())
+		} else {
+			publishers = append(publishers, p)
+		}
+	}
+	f.svcpublishers = publishers
+	return publishers
+}
@@ -1,102 +1,701 @@
 package forwarder
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
-	"github.com/sirupsen/logrus"
+	phperjson "github.com/shogo82148/go-phper-json"
 )
 
 // Query is a query for AWS CloudWatch.
 type Query struct {
-	Service string        `json:"service,omitempty"`
-	Host    string        `json:"host,omitempty"`
-	Name    string        `json:"name,omitempty"`
-	Metric  []interface{} `json:"metric,omitempty"`
-	Stat    string        `json:"stat,omitempty"`
-	Default *float64      `json:"default,omitempty"`
+	// Type is the type of the query.
+	// It is one of "metric" (CloudWatch Metrics), "logs" (CloudWatch Logs),
+	// "performanceInsights" (RDS Performance Insights), and "serviceQuota" (AWS Service Quotas).
+	// The default is "metric".
+	Type string `json:"type,omitempty"`
+
+	Service string `json:"service,omitempty"`
+	Host    string `json:"host,omitempty"`
+	Name    string `json:"name,omitempty"`
+
+	// Metric is the metric of CloudWatch.
+	// It is an object, e.g. {"namespace": "AWS/EC2", "name": "CPUUtilization", "dimensions": {"InstanceId": "i-..."}},
+	// or the positional array of the namespace, the metric name and the pairs of the dimension names and values,
+	// e.g. ["AWS/EC2", "CPUUtilization", "InstanceId", "i-..."].
+	// The elements "." of the positional array inherit the same position of the previous query.
+	// The positional array is kept for compatibility, but the object is recommended,
+	// because the inheritance is error-prone when the dimensions are reordered.
+	Metric MetricSpec `json:"metric,omitempty"`
+
+	Stat    string   `json:"stat,omitempty"`
+	Default *float64 `json:"default,omitempty"`
+
+	// Naming is the way to name the metric on Mackerel instead of Name.
+	// "integration" names it the same as the AWS integration of Mackerel, e.g. "rds.cpu.used" for CPUUtilization of AWS/RDS,
+	// so that the forwarded metrics are shown in the same graphs as the ones that the integration collects.
+	Naming string `json:"naming,omitempty"`
+
+	// Preset is the name of the built-in standard metric set of an AWS service,
+	// e.g. "alb", "sqs", "lambda", "rds", "elasticache", "dynamodb", "billing", "synthetics" and "rum".
+	// The query is expanded into the queries of the metrics with Dimensions, and they are named by the naming "integration".
+	// "billing" forwards EstimatedCharges of AWS/Billing as the service metric "billing.estimated_charges",
+	// e.g. {"service": "foo", "preset": "billing", "dimensions": {"Currency": "USD"}}.
+	// The metric is fetched from us-east-1 hourly with the delay "3h" by default, so that it is posted within the max metric age.
+	// "synthetics" forwards SuccessPercent and Duration of the CloudWatch Synthetics canary,
+	// and Check applies only to SuccessPercent with the operator "<" by default,
+	// e.g. {"host": "...", "preset": "synthetics", "dimensions": {"CanaryName": "my-canary"}, "check": {"critical": 90}}.
+	// "rum" forwards the sessions, the errors and the navigation duration of the CloudWatch RUM application.
+	Preset string `json:"preset,omitempty"`
+
+	// Dimensions is the dimensions of the metrics of Preset, e.g. {"LoadBalancer": "app/my-alb/1234567890abcdef"}.
+	Dimensions map[string]string `json:"dimensions,omitempty"`
+
+	// Aggregate is the way to aggregate the metrics across the dimensions.
+	// It is one of "sum", "avg", "max" and "min".
+	// The dimension values of "*" in Metric match any values, and the metrics found by ListMetrics
+	// are aggregated into one metric, e.g. the sum of IncomingRecords of all the shards of a Kinesis stream.
+	// The metrics that have no data points in the last 3 hours are not aggregated.
+	//
+	// Without Aggregate and TopN, the metrics are forwarded individually.
+	// Then Name must be the template with the placeholders of the dimension values,
+	// e.g. "sqs.#{QueueName}.visible_messages", so that each metric gets a distinct name.
+	Aggregate string `json:"aggregate,omitempty"`
+
+	// TopN is the number of the metrics forwarded individually from the metrics matched by the wildcard dimension values.
+	// The metrics are ranked by the statistic By in the time window, and the top N metrics are forwarded
+	// as "<name>.<the values of the wildcard dimensions>".
+	// The rest are aggregated by Aggregate (the default is "sum") into "<name>.other".
+	TopN int `json:"topN,omitempty"`
+
+	// By is the statistic for ranking the metrics of TopN, e.g. "Average", "Maximum" and "Sum".
+	// The default is "Average".
+	By string `json:"by,omitempty"`
+
+	// Discover finds the resources by the tags, and expands the query into the queries of them.
+	// "#{resource}" in the dimension values of Metric is replaced with the id of each resource, e.g. the queue name of SQS,
+	// and "#{tag:<key>}" is replaced with the tag value. ResourceARN is set to the ARN of each resource,
+	// so that Service and Name can be the templates of them, e.g. "#{tag:team}".
+	// The discovered resources are cached for 10 minutes by default, see Forwarder.DiscoveryCacheTTL.
+	Discover *DiscoveryQuery `json:"discover,omitempty"`
+
+	// Fill is the way to fill the missing data points in the time window.
+	// It is one of "none", "zero", "last" (the last value, or the default value if there is no value),
+	// and "default" (the default value).
+	// The default is "default" if Default is specified, otherwise "none".
+	Fill string `json:"fill,omitempty"`
+
+	// Min and Max are the range of the values.
+	// The values out of the range are dropped, or clamped to the range if OutOfRange is "clamp".
+	// NaN and infinite values are always dropped regardless of them, because Mackerel doesn't accept them.
+	Min *float64 `json:"min,omitempty"`
+	Max *float64 `json:"max,omitempty"`
+
+	// OutOfRange is the action for the values out of the range of Min and Max.
+	// It is one of "drop" and "clamp". The default is "drop".
+	OutOfRange string `json:"outOfRange,omitempty"`
+
+	// Delay is the delay of the end of the time window from the current time truncated to a minute, e.g. "15m".
+	// Set it for the metrics that are published with delays, e.g. AWS/Billing and AWS/S3.
+	// The queries referenced by an expression must have the same delay as the expression,
+	// and an expression can't reference both the billing metrics and the other metrics, because they are fetched separately.
+	// The default is "1m".
+	Delay string `json:"delay,omitempty"`
+
+	// Period is the period of the data points, e.g. "10s" for the high-resolution metrics of CloudWatch.
+	// It is one of "1s", "5s", "10s", "30s", or a multiple of a minute. The default is "1m".
+	// It also accepts the number of seconds, e.g. 300, the same as the period of CloudWatch.
+	// Each data point is posted with its own timestamp, so the data points of the sub-minute periods are not collapsed,
+	// and the missing data points are filled every period.
+	// Note that CloudWatch keeps the sub-minute data points only for 3 hours.
+	Period string `json:"period,omitempty"`
+
+	// AlignTimestamps is the way to align the timestamps of the data points.
+	// It is one of "asIs" and "floorToMinute".
+	// "floorToMinute" truncates the timestamps that land mid-minute (e.g. from the expressions) to the minute boundaries,
+	// consistent with the 1-minute resolution of Mackerel. It is not available with the sub-minute periods.
+	// The default is "asIs".
+	AlignTimestamps string `json:"alignTimestamps,omitempty"`
+
+	// ResourceARN is the ARN of the AWS resource that the host represents.
+	// It is used for syncing the tags of the resource as the host metadata.
+	//
+	// Service may be the template with the placeholders of the dimension values and the tags of the resource,
+	// e.g. "#{tag:team}" with "arn:aws:sqs:ap-northeast-1:123456789012:#{QueueName}",
+	// so that the metrics are routed to the services by the tags. The tags are cached for an hour.
+	ResourceARN string `json:"resourceArn,omitempty"`
+
+	// API is the CloudWatch API for fetching the metric.
+	// It is one of "data" (GetMetricData) and "statistics" (GetMetricStatistics).
+	// The default is "data".
+	API string `json:"api,omitempty"`
+
+	// Check is the rule for check monitoring of the metric.
+	// It is available only for host metrics, and requires Warning or Critical.
+	Check *CheckRule `json:"check,omitempty"`
+
+	// Monitor is the rule for the monitor of the metric on Mackerel.
+	// It is not evaluated by the forwarder, but synced to Mackerel by SyncMonitors, e.g. the "sync-monitors" subcommand.
+	Monitor *MonitorRule `json:"monitor,omitempty"`
+
+	// LogGroup is the name of the log group for the "logs" type query.
+	LogGroup string `json:"logGroup,omitempty"`
+
+	// FilterPattern is the filter pattern of the log events for the "logs" type query.
+	// The number of the matched log events per minute is forwarded.
+	FilterPattern string `json:"filterPattern,omitempty"`
+
+	// PerformanceInsights is the query for the "performanceInsights" type query.
+	PerformanceInsights *PerformanceInsightsQuery `json:"performanceInsights,omitempty"`
+
+	// ServiceQuota is the query for the "serviceQuota" type query.
+	ServiceQuota *ServiceQuotaQuery `json:"serviceQuota,omitempty"`
+
+	// ID is the id of the query in GetMetricData.
+	// It must start with a lowercase letter, and contain only letters, numbers and underscores.
+	// It can be referenced by the expressions of other queries.
+	// The default is "m<index + 1>".
+	ID string `json:"id,omitempty"`
+
+	// Label is the human-readable label of the query in GetMetricData.
+	Label string `json:"label,omitempty"`
+
+	// Expression is the metric math expression, e.g. "m1 / m2 * 100".
+	// If it is specified, Metric is ignored.
+	Expression string `json:"expression,omitempty"`
+
+	// ReturnData indicates whether the result of the query is forwarded to Mackerel.
+	// Set it to false for queries that are used only in expressions.
+	// The default is true.
+	ReturnData *bool `json:"returnData,omitempty"`
+}
+
+// MetricSpec is the metric of Query in the positional array form.
+// It also accepts the object form of MetricObject in JSON, and converts it into the positional array.
+type MetricSpec []interface{}
+
+// MetricObject is the object form of MetricSpec.
+type MetricObject struct {
+	Namespace  string            `json:"namespace"`
+	Name       string            `json:"name"`
+	Dimensions map[string]string `json:"dimensions,omitempty"`
 }
 
+// UnmarshalJSON implements json.Unmarshaler.
+func (m *MetricSpec) UnmarshalJSON(data []byte) error {
+	trimmed := bytes.TrimLeft(data, " \t\r\n")
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		return phperjson.Unmarshal(data, (*[]interface{})(m))
+	}
+
+	var obj MetricObject
+	if err := phperjson.Unmarshal(data, &obj); err != nil {
+		return err
+	}
+	spec, err := obj.spec()
+	if err != nil {
+		return err
+	}
+	*m = spec
+	return nil
+}
+
+// spec converts the object into the positional array.
+// The dimensions are sorted by the names, so that the result is stable.
+func (obj *MetricObject) spec() (MetricSpec, error) {
+	if obj.Namespace == "" || obj.Name == "" {
+		return nil, errors.New("forwarder: namespace and name of the metric are required")
+	}
+	names := make([]string, 0, len(obj.Dimensions))
+	for name := range obj.Dimensions {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	ret := make(MetricSpec, 0, 2+2*len(names))
+	ret = append(ret, obj.Namespace, obj.Name)
+	for _, name := range names {
+		ret = append(ret, name, obj.Dimensions[name])
+	}
+	for _, v := range ret {
+		if v == "." {
+			// "." means the inheritance in the positional array.
+			return nil, errors.New(`forwarder: the shorthand "." is not available in the object form of the metric`)
+		}
+	}
+	return ret, nil
+}
+
+// QueryDocument is the structured query format.
+//
+//	{"version": 2, "queries": [...], "lookback": "5m", "dryRun": true}
+//
+// Lookback, DryRun and APIKeyParameter override the configuration of the forwarder for the invocation,
+// so that the rules of EventBridge can tweak the behavior without separate deployments of AWS Lambda.
+type QueryDocument struct {
+	// Version is the version of the format. It may be omitted.
+	Version int      `json:"version,omitempty"`
+	Queries []*Query `json:"queries,omitempty"`
+
+	// Groups is the groups of the queries that share the time window.
+	// They are forwarded with Queries in one invocation.
+	Groups []*QueryDocumentGroup `json:"groups,omitempty"`
+
+	// Time is the scheduled time of the invocation, e.g. <aws.scheduler.scheduled-time> of EventBridge Scheduler.
+	// If it is specified, the time window is computed from it instead of the current time.
+	Time *time.Time `json:"time,omitempty"`
+
+	// Lookback overrides Forwarder.Lookback, e.g. "5m".
+	Lookback string `json:"lookback,omitempty"`
+
+	// DryRun overrides Forwarder.DryRun.
+	DryRun *bool `json:"dryRun,omitempty"`
+
+	// APIKeyParameter overrides the API key of Mackerel with the one in the parameter of AWS Systems Manager Parameter Store.
+	// The metrics that failed to post are not kept for retrying,
+	// because the pending metrics belong to the default API key.
+	APIKeyParameter string `json:"apiKeyParameter,omitempty"`
+}
+
+// QueryDocumentGroup is a group of the queries in QueryDocument that share the time window, e.g.
+//
+//	{"delay": "15m", "period": 300, "queries": [...]}
+//
+// It allows the metrics published with delays, e.g. AWS/Billing and AWS/S3,
+// to be forwarded with the other metrics by one schedule.
+type QueryDocumentGroup struct {
+	// Delay is the delay of the queries that don't have their own delays. See Query.Delay.
+	Delay string `json:"delay,omitempty"`
+
+	// Period is the period of the metric type queries that don't have their own periods. See Query.Period.
+	Period string `json:"period,omitempty"`
+
+	Queries []*Query `json:"queries"`
+}
+
+// queries returns the queries of the group with the delay and the period of the group.
+func (g *QueryDocumentGroup) queries() []*Query {
+	for _, q := range g.Queries {
+		if q.Delay == "" {
+			q.Delay = g.Delay
+		}
+		if q.Period == "" && (q.Type == "" || q.Type == queryTypeMetric) {
+			q.Period = g.Period
+		}
+	}
+	return g.Queries
+}
+
+// queryDocumentVersion is the latest version of QueryDocument.
+const queryDocumentVersion = 2
+
+// ParseQueries parses the queries.
+// It accepts both the legacy array format and QueryDocument.
+// The queries with presets are expanded.
+func ParseQueries(data []byte) ([]*Query, error) {
+	trimmed := bytes.TrimLeft(data, " \t\r\n")
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		var query []*Query
+		if err := phperjson.Unmarshal(data, &query); err != nil {
+			return nil, err
+		}
+		return expandPresets(query)
+	}
+
+	var doc QueryDocument
+	if err := phperjson.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if doc.Version != 0 && doc.Version != queryDocumentVersion {
+		return nil, fmt.Errorf("forwarder: unsupported query version: %d", doc.Version)
+	}
+	query := doc.Queries
+	for _, g := range doc.Groups {
+		query = append(query, g.queries()...)
+	}
+	return expandPresets(query)
+}
+
+const (
+	// queryTypeMetric is the query type for CloudWatch Metrics.
+	queryTypeMetric = "metric"
+
+	// queryTypeLogs is the query type for CloudWatch Logs.
+	queryTypeLogs = "logs"
+)
+
 // ToMetricDataQuery converts the query to (cloudwatch/types).MetricDataQuery.
+// It validates the queries against the limits of CloudWatch, and returns QueryErrors if some queries are invalid.
+// Only the queries fetched by GetMetricData are converted;
+// the queries of CloudWatch Logs and of the GetMetricStatistics API are skipped.
 func ToMetricDataQuery(query []*Query) ([]types.MetricDataQuery, map[string]float64, error) {
-	// Namespace + MetricName + Maximum 10 Dimensions
-	var lastMetric [22]string
-	var lastHost, lastService, lastStat string
+	resolved, errs := prepareQueries(query)
+	if len(errs) > 0 {
+		return nil, nil, errs
+	}
+	ret := make([]types.MetricDataQuery, 0, len(resolved))
+	defaults := make(map[string]float64, len(resolved))
+	for _, q := range resolved {
+		if !q.usesGetMetricData() {
+			continue
+		}
+		ret = append(ret, q.toMetricDataQuery())
+		if q.Query.Default != nil {
+			defaults[q.Label.String()] = *q.Query.Default
+		}
+	}
+	return ret, defaults, nil
+}
 
-	ret := make([]types.MetricDataQuery, 0, len(query))
-	defaults := make(map[string]float64, len(query))
+// metricQuery is a query whose shorthands are resolved.
+type metricQuery struct {
+	// Query is the original query.
+	Query *Query
 
-	for i, q := range query {
-		host := q.Host
-		setDefault(&host, &lastHost)
-		service := q.Service
-		setDefault(&service, &lastService)
-		stat := q.Stat
-		setDefault(&stat, &lastStat)
-
-		if (host == "") == (service == "") {
-			logrus.WithFields(logrus.Fields{
-				"index":   i,
-				"host":    host,
-				"service": service,
-			}).Warn("either service name or host id is required but not both, skips")
+	Index      int
+	ID         string
+	Label      Label
+	Namespace  string
+	MetricName string
+	Dimensions []types.Dimension
+	Stat       string
+	Delay      time.Duration
+	Period     time.Duration
+}
+
+// delay parses the delay of the query.
+func (q *Query) delay() (time.Duration, error) {
+	if q.Delay == "" {
+		return defaultDelay, nil
+	}
+	d, err := time.ParseDuration(q.Delay)
+	if err != nil {
+		return 0, fmt.Errorf("invalid delay: %w", err)
+	}
+	if d < 0 || d%time.Minute != 0 {
+		return 0, fmt.Errorf("delay must be a non-negative multiple of a minute: %q", q.Delay)
+	}
+	return d, nil
+}
+
+// highResolutionPeriods are the periods of the high-resolution metrics that CloudWatch accepts.
+var highResolutionPeriods = []time.Duration{time.Second, 5 * time.Second, 10 * time.Second, 30 * time.Second}
+
+// period parses the period of the query.
+func (q *Query) period() (time.Duration, error) {
+	if q.Period == "" {
+		return time.Minute, nil
+	}
+	switch q.Type {
+	case "", queryTypeMetric:
+	default:
+		return 0, fmt.Errorf("period is available only for metric type queries, but the type is %q", q.Type)
+	}
+	var d time.Duration
+	if sec, err := strconv.Atoi(q.Period); err == nil {
+		// the number of seconds, the same as the period of CloudWatch.
+		d = time.Duration(sec) * time.Second
+	} else if d, err = time.ParseDuration(q.Period); err != nil {
+		return 0, fmt.Errorf("invalid period: %w", err)
+	}
+	if (d <= 0 || d%time.Minute != 0) && !slices.Contains(highResolutionPeriods, d) {
+		return 0, fmt.Errorf("period must be 1s, 5s, 10s, 30s, or a multiple of a minute: %q", q.Period)
+	}
+	return d, nil
+}
+
+const (
+	// alignAsIs keeps the timestamps as they are.
+	alignAsIs = "asIs"
+
+	// alignFloorToMinute truncates the timestamps to the minute boundaries.
+	alignFloorToMinute = "floorToMinute"
+)
+
+// validateAlignTimestamps validates AlignTimestamps against the period of the query.
+func (q *Query) validateAlignTimestamps(period time.Duration) error {
+	switch q.AlignTimestamps {
+	case "", alignAsIs:
+		return nil
+	case alignFloorToMinute:
+		if period < time.Minute {
+			return fmt.Errorf("alignTimestamps %q collapses the data points of the period %s", q.AlignTimestamps, period)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown alignTimestamps: %q", q.AlignTimestamps)
+}
+
+// alignTime aligns the timestamp of a data point by AlignTimestamps.
+func (q *Query) alignTime(t time.Time) time.Time {
+	if q.AlignTimestamps == alignFloorToMinute {
+		return t.Truncate(time.Minute)
+	}
+	return t
+}
+
+// period returns the period of the data points of the query.
+func (q *metricQuery) period() time.Duration {
+	if q.Period <= 0 {
+		return time.Minute
+	}
+	return q.Period
+}
+
+// returnData reports whether the result of the query is forwarded to Mackerel.
+func (q *Query) returnData() bool {
+	return q.ReturnData == nil || *q.ReturnData
+}
+
+// validQueryID is the pattern of the ids of GetMetricData.
+var validQueryID = regexp.MustCompile(`^[a-z][a-zA-Z0-9_]*$`)
+
+// resolveQueries resolves the shorthands of the queries.
+// The invalid queries are skipped, and their errors are returned.
+func resolveQueries(query []*Query) ([]*metricQuery, QueryErrors) {
+	ret := make([]*metricQuery, 0, len(query))
+	var errs QueryErrors
+	ids := make(map[string]int, len(query))
+	for i, sh := range resolveShorthands(query) {
+		q := query[i]
+		host, service, stat := sh.host, sh.service, sh.stat
+
+		if q.returnData() && (host == "") == (service == "") {
+			errs = append(errs, &QueryError{
+				Index: i,
+				Err:   fmt.Errorf("either service name or host id is required but not both: service %q, host %q", service, host),
+			})
+			continue
+		}
+
+		id := q.ID
+		if id == "" {
+			id = fmt.Sprintf("m%d", i+1)
+		} else if !validQueryID.MatchString(id) {
+			errs = append(errs, &QueryError{
+				Index: i,
+				Err:   fmt.Errorf("invalid id: %q", id),
+			})
 			continue
 		}
-		if len(q.Metric) < 2 {
-			logrus.WithFields(logrus.Fields{
-				"index":  i,
-				"metric": q.Metric,
-			}).Warn("at least, namespace and metric name are required, skips")
+		if j, ok := ids[id]; ok {
+			errs = append(errs, &QueryError{
+				Index: i,
+				Err:   fmt.Errorf("duplicated id %q, conflicts with query[%d]", id, j),
+			})
+			continue
 		}
-		namespace := interfaceToString(q.Metric[0])
-		setDefault(&namespace, &lastMetric[0])
-		name := interfaceToString(q.Metric[1])
-		setDefault(&name, &lastMetric[1])
 
-		var dimensions []types.Dimension
-		for j := 2; j+1 < len(q.Metric); j += 2 {
-			name := interfaceToString(q.Metric[j])
-			setDefault(&name, &lastMetric[j])
-			value := interfaceToString(q.Metric[j+1])
-			setDefault(&value, &lastMetric[j+1])
-			dimensions = append(dimensions, types.Dimension{
-				Name:  aws.String(name),
-				Value: aws.String(value),
+		if !q.returnData() && q.Type != "" && q.Type != queryTypeMetric {
+			errs = append(errs, &QueryError{
+				Index: i,
+				Err:   fmt.Errorf("returnData is available only for metric type queries, but the type is %q", q.Type),
 			})
+			continue
 		}
 
-		label := Label{
-			Service:    service,
-			HostID:     host,
-			MetricName: q.Name,
+		var namespace, name string
+		var dimensions []types.Dimension
+		var err error
+		switch q.Type {
+		case "", queryTypeMetric:
+			if q.Expression != "" {
+				if q.API == apiStatistics {
+					err = errors.New("expressions are not available with the statistics api")
+				}
+				break
+			}
+			if len(q.Metric) < 2 {
+				err = fmt.Errorf("at least, namespace and metric name are required: %v", q.Metric)
+				break
+			}
+			namespace, name, dimensions = sh.namespace, sh.name, sh.dimensions
+			if q.API != "" && q.API != apiData && q.API != apiStatistics {
+				err = fmt.Errorf("unknown api: %q", q.API)
+			}
+		case queryTypeLogs:
+			if q.LogGroup == "" {
+				err = errors.New("log group is required")
+			}
+		case queryTypePerformanceInsights:
+			if pq := q.PerformanceInsights; pq == nil || pq.Identifier == "" || pq.Metric == "" {
+				err = errors.New("identifier and metric of performance insights are required")
+			}
+		case queryTypeServiceQuota:
+			if sq := q.ServiceQuota; sq == nil || sq.ServiceCode == "" || sq.QuotaCode == "" {
+				err = errors.New("service code and quota code are required")
+			}
+		default:
+			err = fmt.Errorf("unknown query type: %q", q.Type)
 		}
-		metric := &types.Metric{
-			Namespace:  aws.String(namespace),
-			MetricName: aws.String(name),
-			Dimensions: dimensions,
+		switch q.Fill {
+		case "", fillNone, fillZero, fillLast:
+		case fillDefault:
+			if q.Default == nil {
+				err = errors.Join(err, errors.New("fill \"default\" requires the default value"))
+			}
+		default:
+			err = errors.Join(err, fmt.Errorf("unknown fill: %q", q.Fill))
+		}
+		if q.Check != nil {
+			err = errors.Join(err, q.Check.validate())
+			if service != "" {
+				err = errors.Join(err, errors.New("check monitoring is available only for host metrics"))
+			}
 		}
-		ret = append(ret, types.MetricDataQuery{
-			Id:    aws.String(fmt.Sprintf("m%d", i+1)),
-			Label: aws.String(label.String()),
-			MetricStat: &types.MetricStat{
-				Metric: metric,
-				Period: aws.Int32(60),
-				Stat:   aws.String(stat),
+		err = errors.Join(err, q.validateRange())
+		err = errors.Join(err, q.validateAggregate(dimensions))
+		err = errors.Join(err, q.validateDiscovery())
+		metricName, nerr := q.metricName(namespace, name)
+		err = errors.Join(err, nerr)
+		metricName, terr := q.resolveNameTemplate(metricName, dimensions)
+		err = errors.Join(err, terr)
+		service, serr := q.resolveServiceTemplate(service, dimensions)
+		err = errors.Join(err, serr)
+		delay, derr := q.delay()
+		err = errors.Join(err, derr)
+		period, perr := q.period()
+		err = errors.Join(err, perr)
+		if perr == nil {
+			err = errors.Join(err, q.validateAlignTimestamps(period))
+		}
+		if err != nil {
+			errs = append(errs, &QueryError{Index: i, Err: err})
+			continue
+		}
+
+		ids[id] = i
+		mq := &metricQuery{
+			Query: q,
+			Index: i,
+			ID:    id,
+			Label: Label{
+				Service:    service,
+				HostID:     host,
+				MetricName: metricName,
 			},
-		})
-		if q.Default != nil {
-			defaults[label.String()] = *q.Default
+			Namespace:  namespace,
+			MetricName: name,
+			Dimensions: dimensions,
+			Stat:       stat,
+			Delay:      delay,
+			Period:     period,
+		}
+		ret = append(ret, mq)
+	}
+	return ret, errs
+}
+
+// shorthand is the fields of a query whose shorthands "." are resolved with the previous queries.
+type shorthand struct {
+	host, service, stat string
+
+	// namespace, name and dimensions are empty unless the query fetches a metric of CloudWatch.
+	namespace, name string
+	dimensions      []types.Dimension
+}
+
+// resolveShorthands resolves the shorthands "." of the queries with the previous queries.
+// The metrics are resolved only for the metric type queries without expressions.
+func resolveShorthands(query []*Query) []shorthand {
+	var lastMetric lastMetricType
+	var lastHost, lastService, lastStat string
+
+	ret := make([]shorthand, len(query))
+	for i, q := range query {
+		sh := &ret[i]
+		sh.host = q.Host
+		setDefault(&sh.host, &lastHost)
+		sh.service = q.Service
+		setDefault(&sh.service, &lastService)
+		sh.stat = q.Stat
+		setDefault(&sh.stat, &lastStat)
+		if (q.Type == "" || q.Type == queryTypeMetric) && q.Expression == "" && len(q.Metric) >= 2 {
+			sh.namespace, sh.name, sh.dimensions = resolveMetric(q.Metric, &lastMetric)
 		}
+	}
+	return ret
+}
+
+// prepareQueries resolves and validates the queries.
+// The invalid queries are skipped, and their errors are returned.
+func prepareQueries(query []*Query) ([]*metricQuery, QueryErrors) {
+	resolved, errs := resolveQueries(query)
+	valid, verrs := validateMetricQueries(resolved)
+	errs = append(errs, verrs...)
+	sort.SliceStable(errs, func(i, j int) bool {
+		return errs[i].Index < errs[j].Index
+	})
+	return valid, errs
+}
 
-		logrus.WithFields(logrus.Fields{
-			"id":      fmt.Sprintf("m%d", i+1),
-			"label":   label.String(),
-			"stat":    stat,
-			"default": q.Default,
-		}).Debug("new metric data query")
+func (q *metricQuery) toMetricDataQuery() types.MetricDataQuery {
+	label := q.Query.Label
+	if label == "" {
+		if q.Query.returnData() {
+			label = q.Label.String()
+		} else {
+			label = q.ID
+		}
 	}
-	return ret, defaults, nil
+	if q.Query.Expression != "" {
+		return types.MetricDataQuery{
+			Id:         aws.String(q.ID),
+			Label:      aws.String(label),
+			Expression: aws.String(q.Query.Expression),
+			Period:     aws.Int32(int32(q.period() / time.Second)),
+			ReturnData: q.Query.ReturnData,
+		}
+	}
+	return types.MetricDataQuery{
+		Id:         aws.String(q.ID),
+		Label:      aws.String(label),
+		ReturnData: q.Query.ReturnData,
+		MetricStat: &types.MetricStat{
+			Metric: &types.Metric{
+				Namespace:  aws.String(q.Namespace),
+				MetricName: aws.String(q.MetricName),
+				Dimensions: q.Dimensions,
+			},
+			Period: aws.Int32(int32(q.period() / time.Second)),
+			Stat:   aws.String(q.Stat),
+		},
+	}
+}
+
+// lastMetricType holds the elements of the last metric for the shorthand ".".
+// Namespace + MetricName + Maximum 30 Dimensions (the limit of GetMetricStatistics)
+type lastMetricType [62]string
+
+// resolveMetric resolves the shorthand "." of the metric with the last metric.
+func resolveMetric(metric []interface{}, lastMetric *lastMetricType) (namespace, name string, dimensions []types.Dimension) {
+	namespace = interfaceToString(metric[0])
+	setDefault(&namespace, &lastMetric[0])
+	name = interfaceToString(metric[1])
+	setDefault(&name, &lastMetric[1])
+
+	for j := 2; j+1 < len(metric); j += 2 {
+		name := interfaceToString(metric[j])
+		value := interfaceToString(metric[j+1])
+		if j+1 < len(lastMetric) {
+			setDefault(&name, &lastMetric[j])
+			setDefault(&value, &lastMetric[j+1])
+		}
+		// the dimensions over the limit are reported by the validation.
+		dimensions = append(dimensions, types.Dimension{
+			Name:  aws.String(name),
+			Value: aws.String(value),
+		})
+	}
+	return
 }
 
 func interfaceToString(in interface{}) string {
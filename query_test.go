@@ -2,6 +2,7 @@ package forwarder
 
 import (
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
@@ -137,3 +138,205 @@ func TestToMetricDataQuery(t *testing.T) {
 		}
 	}
 }
+
+func TestToMetricDataQuery_OtherTypes(t *testing.T) {
+	zero := 0.0
+	query := []*Query{
+		{Service: "foo", Name: "logs.errors", Type: "logs", LogGroup: "/aws/lambda/foo", FilterPattern: "ERROR", Default: &zero},
+		{Service: "foo", Name: "ec2.cpu", Metric: []interface{}{"AWS/EC2", "CPUUtilization"}, Stat: "Average", API: "statistics", Default: &zero},
+		{Service: "foo", Name: "sqs.sent", Metric: []interface{}{"AWS/SQS", "NumberOfMessagesSent"}, Stat: "Sum", Default: &zero},
+	}
+	got, defaults, err := ToMetricDataQuery(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || aws.ToString(got[0].Label) != "service=foo:sqs.sent" {
+		t.Errorf("want only the query for GetMetricData, got %#v", got)
+	}
+	if diff := cmp.Diff(map[string]float64{"service=foo:sqs.sent": 0}, defaults); diff != "" {
+		t.Errorf("defaults mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestParseQueries(t *testing.T) {
+	legacy := `[{"service":"foo","name":"bar","metric":["AWS/SQS","NumberOfMessagesSent"],"stat":"Sum"}]`
+	got, err := ParseQueries([]byte(legacy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Service != "foo" {
+		t.Errorf("unexpected queries: %#v", got)
+	}
+
+	doc := ` {
+		"version": 2,
+		"queries": [
+			{"id": "sent", "metric": ["AWS/SQS","NumberOfMessagesSent"], "stat": "Sum", "returnData": false},
+			{"id": "deleted", "metric": ["AWS/SQS","NumberOfMessagesDeleted"], "stat": "Sum", "returnData": false},
+			{"id": "ratio", "service": "foo", "name": "sqs.ratio", "expression": "deleted / sent * 100", "label": "delete ratio"}
+		]
+	}`
+	got, err = ParseQueries([]byte(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	queries, _, err := ToMetricDataQuery(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []types.MetricDataQuery{
+		{
+			Id:    aws.String("sent"),
+			Label: aws.String("sent"),
+			MetricStat: &types.MetricStat{
+				Metric: &types.Metric{
+					Namespace:  aws.String("AWS/SQS"),
+					MetricName: aws.String("NumberOfMessagesSent"),
+				},
+				Period: aws.Int32(60),
+				Stat:   aws.String("Sum"),
+			},
+			ReturnData: aws.Bool(false),
+		},
+		{
+			Id:    aws.String("deleted"),
+			Label: aws.String("deleted"),
+			MetricStat: &types.MetricStat{
+				Metric: &types.Metric{
+					Namespace:  aws.String("AWS/SQS"),
+					MetricName: aws.String("NumberOfMessagesDeleted"),
+				},
+				Period: aws.Int32(60),
+				Stat:   aws.String("Sum"),
+			},
+			ReturnData: aws.Bool(false),
+		},
+		{
+			Id:         aws.String("ratio"),
+			Label:      aws.String("delete ratio"),
+			Expression: aws.String("deleted / sent * 100"),
+			Period:     aws.Int32(60),
+		},
+	}
+	opt := cmpopts.IgnoreUnexported(types.MetricDataQuery{}, types.MetricStat{}, types.Metric{}, types.Dimension{})
+	if diff := cmp.Diff(want, queries, opt); diff != "" {
+		t.Errorf("unexpected metric data (-want +got):\n%s", diff)
+	}
+
+	if _, err := ParseQueries([]byte(`{"version": 3, "queries": []}`)); err == nil {
+		t.Error("want error, got nil")
+	}
+}
+
+func TestParseQueries_Groups(t *testing.T) {
+	got, err := ParseQueries([]byte(`{
+		"queries": [
+			{"service":"foo","name":"sqs.sent","metric":["AWS/SQS","NumberOfMessagesSent","QueueName","queue"],"stat":"Sum"}
+		],
+		"groups": [
+			{"delay": "2m", "queries": [
+				{"service":"foo","name":"sqs.deleted","metric":[".","NumberOfMessagesDeleted",".","."],"stat":"Sum"}
+			]},
+			{"delay": "15m", "period": 300, "queries": [
+				{"service":"foo","name":"s3.size","metric":["AWS/S3","BucketSizeBytes","BucketName","bucket"],"stat":"Average"},
+				{"service":"foo","name":"s3.objects","metric":[".","NumberOfObjects",".","."],"stat":"Average","delay":"1h","period":"1h"},
+				{"type":"logs","service":"foo","name":"logs.errors","logGroup":"/aws/lambda/foo","filterPattern":"ERROR"}
+			]}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type window struct {
+		Name   string
+		Delay  string
+		Period string
+	}
+	var windows []window
+	for _, q := range got {
+		windows = append(windows, window{Name: q.Name, Delay: q.Delay, Period: q.Period})
+	}
+	want := []window{
+		{Name: "sqs.sent"},
+		{Name: "sqs.deleted", Delay: "2m"},
+		{Name: "s3.size", Delay: "15m", Period: "300"},
+		{Name: "s3.objects", Delay: "1h", Period: "1h"},
+		{Name: "logs.errors", Delay: "15m"},
+	}
+	if diff := cmp.Diff(want, windows); diff != "" {
+		t.Errorf("unexpected windows (-want +got):\n%s", diff)
+	}
+
+	// the period in seconds.
+	if d, err := got[2].period(); err != nil || d != 5*time.Minute {
+		t.Errorf("want 5m, got %s, %v", d, err)
+	}
+}
+
+func TestParseQueries_MetricObject(t *testing.T) {
+	got, err := ParseQueries([]byte(`[
+		{"service":"foo","name":"a","metric":{"namespace":"AWS/ApplicationELB","name":"RequestCount","dimensions":{"TargetGroup":"tg","LoadBalancer":"lb"}},"stat":"Sum"},
+		{"service":"foo","name":"b","metric":[".","HTTPCode_Target_5XX_Count",".","."],"stat":"Sum"}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []MetricSpec{
+		{"AWS/ApplicationELB", "RequestCount", "LoadBalancer", "lb", "TargetGroup", "tg"},
+		{".", "HTTPCode_Target_5XX_Count", ".", "."},
+	}
+	for i, q := range got {
+		if diff := cmp.Diff(want[i], q.Metric); diff != "" {
+			t.Errorf("metric %d mismatch: (-want/+got):\n%s", i, diff)
+		}
+	}
+
+	// the positional array after the object inherits the sorted dimensions of it.
+	resolved, errs := prepareQueries(got)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if got := dimensionsKey(resolved[1].Dimensions); got != "LoadBalancer=lb" {
+		t.Errorf("want LoadBalancer=lb, got %s", got)
+	}
+
+	invalid := []string{
+		`[{"service":"foo","name":"a","metric":{"name":"RequestCount"},"stat":"Sum"}]`,
+		`[{"service":"foo","name":"a","metric":{"namespace":".","name":"RequestCount"},"stat":"Sum"}]`,
+	}
+	for _, data := range invalid {
+		if _, err := ParseQueries([]byte(data)); err == nil {
+			t.Errorf("%s: want an error, got nil", data)
+		}
+	}
+}
+
+func TestPrepareQueries_Invalid(t *testing.T) {
+	query := []*Query{
+		{Service: "foo", Name: "short", Metric: []interface{}{"AWS/EC2"}, Stat: "Average"},
+		{Service: "foo", Host: "bar", Name: "both", Metric: []interface{}{"AWS/EC2", "CPUUtilization"}, Stat: "Average"},
+		{Service: "foo", Name: "ok", Metric: []interface{}{"AWS/EC2", "CPUUtilization"}, Stat: "Average"},
+		{Service: "foo", Name: "type", Type: "unknown"},
+		{Service: "foo", Name: "delay", Metric: []interface{}{"AWS/EC2", "CPUUtilization"}, Stat: "Average", Delay: "90s"},
+		{Service: "foo", Name: "period", Metric: []interface{}{"AWS/EC2", "CPUUtilization"}, Stat: "Average", Period: "20s"},
+		{Service: "foo", Name: "period.logs", Type: "logs", LogGroup: "/aws/lambda/foo", Period: "10s"},
+		{Service: "foo", Name: "period.ok", Metric: []interface{}{"AWS/EC2", "CPUUtilization"}, Stat: "Average", Period: "10s"},
+		{Service: "foo", Name: "align", Metric: []interface{}{"AWS/EC2", "CPUUtilization"}, Stat: "Average", AlignTimestamps: "round"},
+		{Service: "foo", Name: "align.period", Metric: []interface{}{"AWS/EC2", "CPUUtilization"}, Stat: "Average", Period: "10s", AlignTimestamps: "floorToMinute"},
+	}
+	got, errs := prepareQueries(query)
+	if len(got) != 2 || got[0].Index != 2 || got[1].Index != 7 {
+		t.Errorf("unexpected valid queries: %v", got)
+	}
+	if got[1].Period != 10*time.Second {
+		t.Errorf("want period 10s, got %s", got[1].Period)
+	}
+	var indexes []int
+	for _, err := range errs {
+		indexes = append(indexes, err.Index)
+	}
+	if diff := cmp.Diff([]int{0, 1, 3, 4, 5, 6, 8, 9}, indexes); diff != "" {
+		t.Errorf("indexes mismatch: (-want/+got):\n%s", diff)
+	}
+}
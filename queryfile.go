@@ -0,0 +1,40 @@
+package forwarder
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/google/go-jsonnet"
+)
+
+// queryFile returns the path of the query definition file.
+func (f *Forwarder) queryFile() string {
+	if f.QueryFile != "" {
+		return f.QueryFile
+	}
+	return f.env().QueryFile
+}
+
+// LoadQueryFile loads the query definition file.
+// The files with the ".jsonnet" extension are evaluated as Jsonnet,
+// and the other files are read as JSON.
+func LoadQueryFile(path string) ([]byte, error) {
+	if filepath.Ext(path) != ".jsonnet" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("forwarder: failed to read the query file: %w", err)
+		}
+		return data, nil
+	}
+
+	vm := jsonnet.MakeVM()
+	vm.Importer(&jsonnet.FileImporter{
+		JPaths: []string{filepath.Dir(path)},
+	})
+	out, err := vm.EvaluateFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("forwarder: failed to evaluate the query file: %w", err)
+	}
+	return []byte(out), nil
+}
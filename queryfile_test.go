@@ -0,0 +1,35 @@
+package forwarder
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLoadQueryFile_Jsonnet(t *testing.T) {
+	data, err := LoadQueryFile("testdata/queries.jsonnet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ParseQueries(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*Query{
+		{
+			Service: "sqs",
+			Name:    "sqs.sent.foo",
+			Metric:  []interface{}{"AWS/SQS", "NumberOfMessagesSent", "QueueName", "foo"},
+			Stat:    "Sum",
+		},
+		{
+			Service: "sqs",
+			Name:    "sqs.sent.bar",
+			Metric:  []interface{}{"AWS/SQS", "NumberOfMessagesSent", "QueueName", "bar"},
+			Stat:    "Sum",
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("queries mismatch: (-want/+got):\n%s", diff)
+	}
+}
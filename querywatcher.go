@@ -0,0 +1,152 @@
+package forwarder
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+)
+
+// QueryWatcher holds the query definition, and reloads it when the source is changed.
+// It is for the long-running processes, which can't pick up the changes by restarting.
+//
+// The source is one of:
+//   - the path of the query definition file, e.g. "queries.jsonnet". See LoadQueryFile.
+//   - the object on Amazon S3, e.g. "s3://bucket/queries.json".
+//   - the parameter of AWS Systems Manager Parameter Store, e.g. "ssm:/forwarder/queries".
+//
+// The new definition replaces the current one atomically only if it is valid,
+// so a broken edit doesn't stop forwarding.
+type QueryWatcher struct {
+	// Source is the location of the query definition.
+	Source string
+
+	// Logger is the logger. If it is nil, slog.Default() is used.
+	Logger Logger
+
+	svcs3  s3iface
+	svcssm ssmiface
+
+	mu      sync.Mutex // serializes the reloading
+	current atomic.Pointer[json.RawMessage]
+}
+
+// NewQueryWatcher returns a new QueryWatcher of the source.
+// Call Reload to load the first definition.
+func NewQueryWatcher(cfg aws.Config, source string) *QueryWatcher {
+	return &QueryWatcher{
+		Source: source,
+		svcs3:  s3.NewFromConfig(cfg),
+		svcssm: ssm.NewFromConfig(cfg),
+	}
+}
+
+// Query returns the current query definition.
+// It returns nil if the definition is not loaded yet.
+func (w *QueryWatcher) Query() json.RawMessage {
+	if p := w.current.Load(); p != nil {
+		return *p
+	}
+	return nil
+}
+
+// Reload loads the query definition from the source, and reports whether it is changed.
+// If the new definition is invalid, it returns an error and the current one is kept.
+func (w *QueryWatcher) Reload(ctx context.Context) (bool, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	data, err := w.load(ctx)
+	if err != nil {
+		return false, err
+	}
+	if bytes.Equal(data, w.Query()) {
+		return false, nil
+	}
+	if _, err := ParseQueries(data); err != nil {
+		return false, fmt.Errorf("forwarder: invalid query definition in %s: %w", w.Source, err)
+	}
+	msg := json.RawMessage(data)
+	w.current.Store(&msg)
+	return true, nil
+}
+
+// Watch reloads the query definition at the interval until ctx is canceled.
+// The failures are logged, and the current definition is kept.
+func (w *QueryWatcher) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.ReloadAndLog(ctx)
+		}
+	}
+}
+
+// ReloadAndLog is the same as Reload, but it logs the result instead of returning it.
+// It is convenient for the handlers of SIGHUP.
+func (w *QueryWatcher) ReloadAndLog(ctx context.Context) {
+	changed, err := w.Reload(ctx)
+	if err != nil {
+		w.logger().WarnContext(ctx, "failed to reload the query definition, keep the current one",
+			"source", w.Source,
+			"error", err.Error(),
+		)
+		return
+	}
+	if changed {
+		w.logger().InfoContext(ctx, "the query definition is reloaded", "source", w.Source)
+	}
+}
+
+func (w *QueryWatcher) load(ctx context.Context) ([]byte, error) {
+	if name, ok := strings.CutPrefix(w.Source, "ssm:"); ok {
+		resp, err := w.svcssm.GetParameter(ctx, &ssm.GetParameterInput{
+			Name:           aws.String(name),
+			WithDecryption: aws.Bool(true),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("forwarder: failed to get the query definition from %s: %w", w.Source, err)
+		}
+		return []byte(aws.ToString(resp.Parameter.Value)), nil
+	}
+
+	if strings.HasPrefix(w.Source, "s3://") {
+		u, err := url.Parse(w.Source)
+		if err != nil {
+			return nil, fmt.Errorf("forwarder: failed to parse the query source: %w", err)
+		}
+		resp, err := w.svcs3.GetObject(ctx, &s3.GetObjectInput{
+			Bucket: aws.String(u.Host),
+			Key:    aws.String(strings.TrimPrefix(u.Path, "/")),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("forwarder: failed to get the query definition from %s: %w", w.Source, err)
+		}
+		defer resp.Body.Close()
+		return io.ReadAll(resp.Body)
+	}
+
+	return LoadQueryFile(w.Source)
+}
+
+func (w *QueryWatcher) logger() Logger {
+	if w.Logger != nil {
+		return w.Logger
+	}
+	return slog.Default()
+}
@@ -0,0 +1,83 @@
+package forwarder
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestQueryWatcher_File(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queries.json")
+	write := func(data string) {
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx := context.Background()
+	w := &QueryWatcher{Source: path}
+
+	write(`[{"service": "foo", "name": "a", "metric": ["AWS/EC2", "CPUUtilization"], "stat": "Average"}]`)
+	if changed, err := w.Reload(ctx); err != nil || !changed {
+		t.Fatalf("want changed, got %t, %v", changed, err)
+	}
+	first := string(w.Query())
+
+	// not changed.
+	if changed, err := w.Reload(ctx); err != nil || changed {
+		t.Errorf("want not changed, got %t, %v", changed, err)
+	}
+
+	// the broken definition is not loaded.
+	write(`[{"service": "foo",`)
+	if _, err := w.Reload(ctx); err == nil {
+		t.Error("want an error, got nil")
+	}
+	if got := string(w.Query()); got != first {
+		t.Errorf("the current definition is replaced: %s", got)
+	}
+
+	// a metric is added.
+	second := `[
+		{"service": "foo", "name": "a", "metric": ["AWS/EC2", "CPUUtilization"], "stat": "Average"},
+		{"service": "foo", "name": "b", "metric": ["AWS/EC2", "NetworkIn"], "stat": "Sum"}
+	]`
+	write(second)
+	if changed, err := w.Reload(ctx); err != nil || !changed {
+		t.Fatalf("want changed, got %t, %v", changed, err)
+	}
+	if got := string(w.Query()); got != second {
+		t.Errorf("want %s, got %s", second, got)
+	}
+}
+
+func TestQueryWatcher_S3(t *testing.T) {
+	svc := &fakeS3{objects: map[string][]byte{
+		"bucket/queries.json": []byte(`[]`),
+	}}
+	w := &QueryWatcher{Source: "s3://bucket/queries.json", svcs3: svc}
+	if changed, err := w.Reload(context.Background()); err != nil || !changed {
+		t.Fatalf("want changed, got %t, %v", changed, err)
+	}
+	if got := string(w.Query()); got != `[]` {
+		t.Errorf("want %s, got %s", `[]`, got)
+	}
+}
+
+func TestQueryWatcher_SSM(t *testing.T) {
+	svc := &fakeSSM{params: map[string]string{
+		"/forwarder/queries": `{"version": 2, "queries": []}`,
+	}}
+	w := &QueryWatcher{Source: "ssm:/forwarder/queries", svcssm: svc}
+	if changed, err := w.Reload(context.Background()); err != nil || !changed {
+		t.Fatalf("want changed, got %t, %v", changed, err)
+	}
+
+	svc.params["/forwarder/queries"] = `{"version": 1, "queries": []}`
+	if _, err := w.Reload(context.Background()); err == nil {
+		t.Error("want an error for the unsupported version, got nil")
+	}
+	if got := string(w.Query()); got != `{"version": 2, "queries": []}` {
+		t.Errorf("the current definition is replaced: %s", got)
+	}
+}
@@ -0,0 +1,111 @@
+package forwarder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// defaultReplayRate is the default of ReplayOptions.Rate.
+const defaultReplayRate = 1
+
+// ReplayOptions is the options of Replay.
+type ReplayOptions struct {
+	// Rate is the maximum number of the time windows forwarded per second.
+	// The default is 1.
+	Rate float64
+}
+
+// Replay forwards the metrics in the past time range [from, to) again,
+// e.g. for backfilling Mackerel after an extended outage of the forwarder.
+// The range is split into the time windows of the lookback, and they are forwarded in order
+// as if ForwardMetrics were invoked at the time of each window.
+// The failures of the windows don't stop the replay, and they are returned joined.
+//
+// The replay is isolated from the scheduled invocations:
+// the pending metrics, the high-water marks and the state store are neither used nor updated,
+// because the marks of the scheduled invocations are ahead of the gap, and would drop all the replayed data points.
+// The replay has its own high-water marks instead, so that the windows don't post the same data points twice.
+// The metrics that failed to post are not retried, and they are reported as the errors.
+//
+// Replay overrides Now while it runs, so it must not be called concurrently with the other invocations.
+func (f *Forwarder) Replay(ctx context.Context, data json.RawMessage, from, to time.Time, opts *ReplayOptions) error {
+	if opts == nil {
+		opts = &ReplayOptions{}
+	}
+	r := opts.Rate
+	if r <= 0 {
+		r = defaultReplayRate
+	}
+
+	from = from.Truncate(time.Minute)
+	if !from.Before(to) {
+		return fmt.Errorf("forwarder: the replay range is empty: from %s to %s", from.Format(time.RFC3339), to.Format(time.RFC3339))
+	}
+	if to.After(f.now()) {
+		return fmt.Errorf("forwarder: the end of the replay range is in the future: %s", to.Format(time.RFC3339))
+	}
+
+	ctx = f.withInvocationOverrides(ctx, data)
+	_, query, err := f.loadQueries(ctx, data)
+	if err != nil {
+		return err
+	}
+	dryRun := f.dryRun(ctx)
+	var client *MackerelClient
+	if !dryRun {
+		client, err = f.mackerel(ctx)
+		if err != nil {
+			return fmt.Errorf("forwarder: failed to configure the mackerel client: %w", err)
+		}
+		query = f.resolveCustomIdentifiers(ctx, client, query)
+		if f.autoRegisterHosts() {
+			query = f.registerHosts(ctx, client, query)
+		}
+	}
+
+	var now time.Time
+	saved := f.Now
+	f.Now = func() time.Time { return now }
+	defer func() { f.Now = saved }()
+
+	limiter := rate.NewLimiter(rate.Limit(r), 1)
+	lookback := f.lookback(ctx)
+	highWaterMarks := make(map[string]int64)
+	var errs []error
+	for start := from; start.Before(to); start = start.Add(lookback) {
+		if err := limiter.Wait(ctx); err != nil {
+			errs = append(errs, err)
+			break
+		}
+
+		// the time window of the invocation at now is [now - delay - lookback, now - delay).
+		now = start.Add(lookback + defaultDelay)
+		report := &InvocationReport{}
+		if err := f.replayWindow(ctx, client, query, now, highWaterMarks, report); err != nil {
+			errs = append(errs, fmt.Errorf("forwarder: failed to replay the time window from %s: %w", start.Format(time.RFC3339), err))
+			continue
+		}
+		f.logger().InfoContext(ctx, "replayed the time window",
+			"start", start.Format(time.RFC3339),
+			"end", start.Add(lookback).Format(time.RFC3339),
+			"posted", report.Posted,
+		)
+	}
+	return errors.Join(errs...)
+}
+
+// replayWindow forwards the metrics in the time window of at for Replay.
+// client is nil in the dry run.
+func (f *Forwarder) replayWindow(ctx context.Context, client *MackerelClient, query []*Query, at time.Time, highWaterMarks map[string]int64, report *InvocationReport) error {
+	ctx, cancel := f.invocationContext(ctx)
+	defer cancel()
+	if client == nil {
+		return f.forwardMetricsDryRun(ctx, query, at, report)
+	}
+	return f.forwardWindowIsolated(ctx, client, query, at, 0, highWaterMarks, report)
+}
@@ -0,0 +1,107 @@
+package forwarder
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestReplay(t *testing.T) {
+	var starts []time.Time
+	f := &Forwarder{
+		DryRun: true,
+		svccloudwatch: &recordingCloudWatch{
+			fakeCloudWatch: fakeCloudWatch{
+				values: map[string][]float64{"m1": {1}},
+			},
+			onGetMetricData: func(s time.Time) { starts = append(starts, s) },
+		},
+	}
+	data := []byte(`[{"service":"foo","name":"ec2.cpu","metric":["AWS/EC2","CPUUtilization"],"stat":"Average"}]`)
+	from := time.Date(2024, 1, 2, 3, 0, 30, 0, time.UTC)
+	to := time.Date(2024, 1, 2, 3, 3, 0, 0, time.UTC)
+	if err := f.Replay(context.Background(), data, from, to, &ReplayOptions{Rate: 1000}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []time.Time{
+		time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC),
+		time.Date(2024, 1, 2, 3, 1, 0, 0, time.UTC),
+		time.Date(2024, 1, 2, 3, 2, 0, 0, time.UTC),
+	}
+	if diff := cmp.Diff(want, starts); diff != "" {
+		t.Errorf("windows mismatch: (-want/+got):\n%s", diff)
+	}
+	if f.Now != nil {
+		t.Error("Now is not restored")
+	}
+}
+
+func TestReplay_InvalidRange(t *testing.T) {
+	f := &Forwarder{DryRun: true}
+	data := []byte(`[]`)
+	now := time.Now()
+
+	if err := f.Replay(context.Background(), data, now.Add(-time.Hour), now.Add(-2*time.Hour), nil); err == nil {
+		t.Error("want an error for the empty range, got nil")
+	}
+	if err := f.Replay(context.Background(), data, now.Add(-time.Hour), now.Add(time.Hour), nil); err == nil {
+		t.Error("want an error for the future range, got nil")
+	}
+}
+
+func TestReplay_Isolated(t *testing.T) {
+	var mu sync.Mutex
+	var posted []ServiceMetricValue
+	client := newTestMackerelClient(t, http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		var values []ServiceMetricValue
+		if err := json.NewDecoder(r.Body).Decode(&values); err != nil {
+			t.Error(err)
+		}
+		mu.Lock()
+		posted = append(posted, values...)
+		mu.Unlock()
+		rw.WriteHeader(http.StatusOK)
+	}))
+
+	// the scheduled invocations have forwarded the data points after the gap.
+	label := Label{Service: "foo", MetricName: "ec2.cpu"}.String()
+	marks := map[string]int64{label: time.Date(2024, 1, 2, 4, 0, 0, 0, time.UTC).Unix()}
+	pending := serviceMetricsType{"foo": {{Name: "ec2.cpu", Time: 1704164400, Value: 42}}}
+	f := &Forwarder{
+		svcmackerel: client,
+		svccloudwatch: &fakeCloudWatch{
+			values: map[string][]float64{"m1": {1}},
+		},
+		highWaterMarks:        marks,
+		pendingServiceMetrics: pending,
+	}
+	data := []byte(`[{"service":"foo","name":"ec2.cpu","metric":["AWS/EC2","CPUUtilization"],"stat":"Average"}]`)
+	from := time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC)
+	to := time.Date(2024, 1, 2, 3, 2, 0, 0, time.UTC)
+	if err := f.Replay(context.Background(), data, from, to, &ReplayOptions{Rate: 1000}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the data points in the gap are posted, and the pending metrics are not.
+	want := []ServiceMetricValue{
+		{Name: "ec2.cpu", Time: from.Unix(), Value: 1},
+		{Name: "ec2.cpu", Time: from.Add(time.Minute).Unix(), Value: 1},
+	}
+	if diff := cmp.Diff(want, posted); diff != "" {
+		t.Errorf("posted metrics mismatch: (-want/+got):\n%s", diff)
+	}
+
+	// the state of the scheduled invocations is kept.
+	if diff := cmp.Diff(map[string]int64{label: time.Date(2024, 1, 2, 4, 0, 0, 0, time.UTC).Unix()}, f.highWaterMarks); diff != "" {
+		t.Errorf("high-water marks mismatch: (-want/+got):\n%s", diff)
+	}
+	if diff := cmp.Diff(pending, f.pendingServiceMetrics); diff != "" {
+		t.Errorf("pending metrics mismatch: (-want/+got):\n%s", diff)
+	}
+}
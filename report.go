@@ -0,0 +1,138 @@
+package forwarder
+
+// InvocationReport is the result of an invocation of ForwardMetrics.
+// It is returned as the response of AWS Lambda, so the callers can assert on the outcome.
+type InvocationReport struct {
+	// Fetched is the number of the data points fetched from AWS.
+	Fetched int `json:"fetched"`
+
+	// Posted is the number of the data points posted to Mackerel.
+	Posted int `json:"posted"`
+
+	// Failed is the number of the data points that failed to post.
+	// They are retried in the next invocation.
+	Failed int `json:"failed"`
+
+	// Dropped is the number of the data points that are dropped without posting,
+	// e.g. the pending data points that are too old, and the data points whose metric names are invalid.
+	Dropped int `json:"dropped"`
+
+	// Stale is the number of the data points that are older than the max age of the forwarder.
+	// They are included in Dropped.
+	Stale int `json:"stale"`
+
+	// Evicted is the number of the pending data points that are dropped
+	// because the number of the pending data points exceeds the limit of the forwarder.
+	// They are included in Dropped.
+	Evicted int `json:"evicted"`
+
+	// Skipped is true if the time window is skipped because it is already forwarded.
+	Skipped bool `json:"skipped,omitempty"`
+
+	// Services is the breakdown of the service metrics by the service names.
+	Services map[string]*ServiceReport `json:"services,omitempty"`
+
+	// DurationMillis is the duration of the invocation in milliseconds.
+	DurationMillis int64 `json:"durationMs"`
+}
+
+// ServiceReport is the breakdown of InvocationReport for a service.
+type ServiceReport struct {
+	Fetched int `json:"fetched"`
+	Posted  int `json:"posted"`
+	Failed  int `json:"failed"`
+	Dropped int `json:"dropped"`
+	Stale   int `json:"stale"`
+	Evicted int `json:"evicted"`
+}
+
+// service returns the report of the service.
+// It returns nil if r is nil or the service is empty.
+func (r *InvocationReport) service(name string) *ServiceReport {
+	if r == nil || name == "" {
+		return nil
+	}
+	if r.Services == nil {
+		r.Services = make(map[string]*ServiceReport)
+	}
+	s, ok := r.Services[name]
+	if !ok {
+		s = &ServiceReport{}
+		r.Services[name] = s
+	}
+	return s
+}
+
+// addFetched records the fetched data points.
+// service is empty for the host metrics.
+func (r *InvocationReport) addFetched(service string, n int) {
+	if r == nil {
+		return
+	}
+	r.Fetched += n
+	if s := r.service(service); s != nil {
+		s.Fetched += n
+	}
+}
+
+// addPosted records the posted data points.
+// service is empty for the host metrics.
+func (r *InvocationReport) addPosted(service string, n int) {
+	if r == nil {
+		return
+	}
+	r.Posted += n
+	if s := r.service(service); s != nil {
+		s.Posted += n
+	}
+}
+
+// addFailed records the data points that failed to post.
+// service is empty for the host metrics.
+func (r *InvocationReport) addFailed(service string, n int) {
+	if r == nil {
+		return
+	}
+	r.Failed += n
+	if s := r.service(service); s != nil {
+		s.Failed += n
+	}
+}
+
+// addDropped records the dropped data points.
+// service is empty for the host metrics.
+func (r *InvocationReport) addDropped(service string, n int) {
+	if r == nil || n == 0 {
+		return
+	}
+	r.Dropped += n
+	if s := r.service(service); s != nil {
+		s.Dropped += n
+	}
+}
+
+// addStale records the data points that are dropped because they are too old.
+// service is empty for the host metrics.
+func (r *InvocationReport) addStale(service string, n int) {
+	if r == nil || n == 0 {
+		return
+	}
+	r.addDropped(service, n)
+	r.Stale += n
+	if s := r.service(service); s != nil {
+		s.Stale += n
+	}
+}
+
+// addEvicted records the pending data points that are dropped because of the limit.
+// service is empty for the host metrics.
+func (r *InvocationReport) addEvicted(service string, n int) {
+	if r == nil || n == 0 {
+		return
+	}
+	r.addDropped(service, n)
+	r.Evicted += n
+	if s := r.service(service); s != nil {
+		s.Evicted += n
+	}
+}
@@ -0,0 +1,56 @@
+package forwarder
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestForwardMetrics_Report(t *testing.T) {
+	client := newTestMackerelClient(t, http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/services/bar/") {
+			rw.WriteHeader(http.StatusForbidden)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+	}))
+	f := &Forwarder{
+		svcmackerel: client,
+		svccloudwatch: &fakeCloudWatch{
+			values: map[string][]float64{
+				"m1": {1, 2},
+				"m2": {3},
+				"m3": {4},
+			},
+		},
+	}
+	data := json.RawMessage(`[
+		{"service":"foo","name":"ec2.cpu","metric":["AWS/EC2","CPUUtilization","InstanceId","i-1"],"stat":"Average"},
+		{"service":"bar","name":"ec2.cpu","metric":["AWS/EC2","CPUUtilization","InstanceId","i-2"],"stat":"Average"},
+		{"host":"host-abc","name":"invalid name","metric":["AWS/EC2","CPUUtilization","InstanceId","i-3"],"stat":"Average"}
+	]`)
+
+	// the failure of posting is reported, and the report is still returned.
+	report, err := f.ForwardMetrics(context.Background(), data)
+	if err == nil || !strings.Contains(err.Error(), "service metrics of bar") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	want := &InvocationReport{
+		Fetched: 4,
+		Posted:  2,
+		Failed:  1,
+		Dropped: 1,
+		Services: map[string]*ServiceReport{
+			"foo": {Fetched: 2, Posted: 2},
+			"bar": {Fetched: 1, Failed: 1},
+		},
+	}
+	if diff := cmp.Diff(want, report, cmpopts.IgnoreFields(InvocationReport{}, "DurationMillis")); diff != "" {
+		t.Errorf("report mismatch: (-want/+got):\n%s", diff)
+	}
+}
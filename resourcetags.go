@@ -0,0 +1,83 @@
+package forwarder
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/resourcegroupstaggingapi"
+)
+
+// resourceTagsTTL is the time to live of the cached tags of the resources.
+const resourceTagsTTL = time.Hour
+
+type cachedResourceTags struct {
+	tags      map[string]string
+	fetchedAt time.Time
+}
+
+// getResourceTags returns the tags of the resources, keyed by the ARNs.
+// The resources that are not found are not included.
+// On errors, the tags fetched before the errors are returned with them.
+func (f *Forwarder) getResourceTags(ctx context.Context, arns []string) (map[string]map[string]string, error) {
+	svc := f.tagging()
+	ret := make(map[string]map[string]string, len(arns))
+	for len(arns) > 0 {
+		n := min(len(arns), maxResourceARNs)
+		chunk := arns[:n]
+		arns = arns[n:]
+
+		paginator := resourcegroupstaggingapi.NewGetResourcesPaginator(svc, &resourcegroupstaggingapi.GetResourcesInput{
+			ResourceARNList: chunk,
+		})
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(ctx)
+			if err != nil {
+				return ret, err
+			}
+			for _, res := range page.ResourceTagMappingList {
+				tags := make(map[string]string, len(res.Tags))
+				for _, tag := range res.Tags {
+					tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+				}
+				ret[aws.ToString(res.ResourceARN)] = tags
+			}
+		}
+	}
+	return ret, nil
+}
+
+// cachedResourceTags is the same as getResourceTags, but the tags are cached for resourceTagsTTL.
+// The resources that are not found are cached as the resources without tags.
+func (f *Forwarder) cachedResourceTags(ctx context.Context, arns []string, now time.Time) (map[string]map[string]string, error) {
+	f.muResourceTags.Lock()
+	defer f.muResourceTags.Unlock()
+
+	ret := make(map[string]map[string]string, len(arns))
+	var missing []string
+	for _, arn := range arns {
+		if c, ok := f.resourceTags[arn]; ok && now.Sub(c.fetchedAt) < resourceTagsTTL {
+			ret[arn] = c.tags
+			continue
+		}
+		missing = appendUnique(missing, arn)
+	}
+	if len(missing) == 0 {
+		return ret, nil
+	}
+
+	fetched, err := f.getResourceTags(ctx, missing)
+	for arn, tags := range fetched {
+		ret[arn] = tags
+	}
+	if err != nil {
+		return ret, err
+	}
+	if f.resourceTags == nil {
+		f.resourceTags = make(map[string]cachedResourceTags, len(missing))
+	}
+	for _, arn := range missing {
+		f.resourceTags[arn] = cachedResourceTags{tags: fetched[arn], fetchedAt: now}
+	}
+	return ret, nil
+}
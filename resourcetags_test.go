@@ -0,0 +1,61 @@
+package forwarder
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/resourcegroupstaggingapi"
+	"github.com/google/go-cmp/cmp"
+)
+
+// countingTagging counts the resources requested by GetResources.
+type countingTagging struct {
+	fakeTagging
+	requested int
+}
+
+func (s *countingTagging) GetResources(ctx context.Context, params *resourcegroupstaggingapi.GetResourcesInput, optFns ...func(*resourcegroupstaggingapi.Options)) (*resourcegroupstaggingapi.GetResourcesOutput, error) {
+	s.requested += len(params.ResourceARNList)
+	return s.fakeTagging.GetResources(ctx, params, optFns...)
+}
+
+func TestCachedResourceTags(t *testing.T) {
+	svc := &countingTagging{
+		fakeTagging: fakeTagging{
+			tags: map[string]map[string]string{
+				"arn:a": {"team": "payments"},
+			},
+		},
+	}
+	f := &Forwarder{svctagging: svc}
+	now := time.Unix(1234567890, 0)
+	arns := []string{"arn:a", "arn:missing"}
+
+	got, err := f.cachedResourceTags(context.Background(), arns, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]map[string]string{
+		"arn:a": {"team": "payments"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("tags mismatch: (-want/+got):\n%s", diff)
+	}
+
+	// the tags are cached, including the missing resources.
+	if _, err := f.cachedResourceTags(context.Background(), arns, now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if svc.requested != 2 {
+		t.Errorf("want 2 resources requested, got %d", svc.requested)
+	}
+
+	// the cache expires.
+	if _, err := f.cachedResourceTags(context.Background(), arns, now.Add(resourceTagsTTL)); err != nil {
+		t.Fatal(err)
+	}
+	if svc.requested != 4 {
+		t.Errorf("want 4 resources requested, got %d", svc.requested)
+	}
+}
@@ -0,0 +1,205 @@
+package forwarder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// defaultRetiredHostTTL is the default of Forwarder.RetiredHostTTL.
+const defaultRetiredHostTTL = time.Hour
+
+// errRetiredHost means the host is skipped because Mackerel reported it as retired recently.
+var errRetiredHost = errors.New("forwarder: the host is retired")
+
+// retiredHostTTL returns the duration for skipping the retired hosts.
+func (f *Forwarder) retiredHostTTL(ctx context.Context) time.Duration {
+	if f.RetiredHostTTL > 0 {
+		return f.RetiredHostTTL
+	}
+	if d := f.env().RetiredHostTTL; d > 0 {
+		return d
+	}
+	return defaultRetiredHostTTL
+}
+
+// isRetiredHostError reports whether Mackerel rejects the host metric because the host is retired or not found.
+// Only the error messages of Mackerel match, e.g. {"error":{"message":"Host Not Found"}},
+// because the other 404s, e.g. from a wrong MACKEREL_APIURL or a proxy, are not the problems of the hosts.
+func isRetiredHostError(err error) bool {
+	if errors.Is(err, errRetiredHost) {
+		return true
+	}
+	var merr Error
+	if !errors.As(err, &merr) {
+		return false
+	}
+	if merr.StatusCode != http.StatusNotFound && merr.StatusCode != http.StatusBadRequest {
+		return false
+	}
+	msg, ok := mackerelErrorMessage(merr.Message)
+	if !ok {
+		return false
+	}
+	msg = strings.ToLower(msg)
+	return strings.Contains(msg, "retired") || (strings.Contains(msg, "host") && strings.Contains(msg, "not found"))
+}
+
+// mackerelErrorMessage returns the message in the error response of Mackerel,
+// e.g. {"error":{"message":"..."}} and {"error":"..."}.
+// It reports false if the body is not the error response of Mackerel.
+func mackerelErrorMessage(body string) (string, bool) {
+	var resp struct {
+		Error json.RawMessage `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(body), &resp); err != nil || len(resp.Error) == 0 {
+		return "", false
+	}
+	var msg string
+	if err := json.Unmarshal(resp.Error, &msg); err == nil {
+		return msg, true
+	}
+	var obj struct {
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(resp.Error, &obj); err == nil && obj.Message != "" {
+		return obj.Message, true
+	}
+	return "", false
+}
+
+// isRejectedHostMetric reports whether Mackerel rejects the host metrics.
+func isRejectedHostMetric(err error) bool {
+	return isBadRequest(err) || isRetiredHostError(err)
+}
+
+// markRetiredHost adds the host to the denylist,
+// and forgets the custom identifiers resolved to it, so that they are resolved again.
+func (f *Forwarder) markRetiredHost(ctx context.Context, client *MackerelClient, id string, now time.Time) {
+	ttl := f.retiredHostTTL(ctx)
+	cache := f.mackerelCache(client)
+	cache.forgetHost(id)
+	cache.muRetiredHosts.Lock()
+	defer cache.muRetiredHosts.Unlock()
+	if until, ok := cache.retiredHosts[id]; ok && now.Before(until) {
+		return
+	}
+	if cache.retiredHosts == nil {
+		cache.retiredHosts = make(map[string]time.Time)
+	}
+	cache.retiredHosts[id] = now.Add(ttl)
+	f.logger().WarnContext(ctx, "the host is retired or not found, skips it for a while",
+		"host_id", id,
+		"ttl", ttl.String(),
+	)
+}
+
+// isRetiredHost reports whether the host is in the denylist.
+func (f *Forwarder) isRetiredHost(client *MackerelClient, id string, now time.Time) bool {
+	cache := f.mackerelCache(client)
+	cache.muRetiredHosts.Lock()
+	defer cache.muRetiredHosts.Unlock()
+	until, ok := cache.retiredHosts[id]
+	if !ok {
+		return false
+	}
+	if !now.Before(until) {
+		delete(cache.retiredHosts, id)
+		return false
+	}
+	return true
+}
+
+// postHostMetricValues posts the host metrics.
+// The metrics of the hosts in the denylist are rejected without posting,
+// and the hosts that Mackerel reports as retired are added to the denylist.
+func (fctx *forwardContext) postHostMetricValues(ctx context.Context, values []HostMetricValue) error {
+	f := fctx.forwarder
+	now := f.now()
+	if len(values) == 1 && f.isRetiredHost(fctx.mackerel, values[0].HostID, now) {
+		return errRetiredHost
+	}
+	err := fctx.mackerel.PostHostMetricValues(ctx, values)
+	if len(values) == 1 && isRetiredHostError(err) {
+		f.markRetiredHost(ctx, fctx.mackerel, values[0].HostID, now)
+	}
+	return err
+}
+
+// dropRetiredHostMetrics drops the host metrics of the hosts in the denylist.
+func (fctx *forwardContext) dropRetiredHostMetrics(ctx context.Context, now time.Time) {
+	f := fctx.forwarder
+	retired := make(map[string]int)
+	metrics := fctx.hostMetrics[:0]
+	for _, v := range fctx.hostMetrics {
+		if f.isRetiredHost(fctx.mackerel, v.HostID, now) {
+			retired[v.HostID]++
+		} else {
+			metrics = append(metrics, v)
+		}
+	}
+	fctx.hostMetrics = metrics
+
+	ids := make([]string, 0, len(retired))
+	for id := range retired {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	for _, id := range ids {
+		fctx.report.addDropped("", retired[id])
+		f.logger().WarnContext(ctx, "drop the host metrics of the retired host",
+			"host_id", id,
+			"count", retired[id],
+		)
+	}
+}
+
+// skipRetiredHosts removes the queries for the hosts in the denylist, so that they are not fetched.
+// The queries that are referenced by the expressions of the other queries are kept.
+func (fctx *forwardContext) skipRetiredHosts(ctx context.Context, query []*metricQuery) []*metricQuery {
+	if fctx.mackerel == nil {
+		// the metrics are not posted to Mackerel, e.g. CollectMetrics.
+		return query
+	}
+	f := fctx.forwarder
+	now := f.now()
+	skip := make([]bool, len(query))
+	var skipped bool
+	for i, q := range query {
+		if q.Label.HostID != "" && q.Query.returnData() && f.isRetiredHost(fctx.mackerel, q.Label.HostID, now) {
+			skip[i] = true
+			skipped = true
+		}
+	}
+	if !skipped {
+		return query
+	}
+
+	ret := make([]*metricQuery, 0, len(query))
+	for i, q := range query {
+		if skip[i] && !isReferenced(q.ID, query, skip) {
+			f.logger().DebugContext(withQueryIndex(ctx, q.Index), "skip the query for the retired host",
+				"host_id", q.Label.HostID,
+			)
+			continue
+		}
+		ret = append(ret, q)
+	}
+	return ret
+}
+
+// isReferenced reports whether the id is referenced by the expressions of the queries that are not skipped.
+func isReferenced(id string, query []*metricQuery, skip []bool) bool {
+	pattern := regexp.MustCompile(`\b` + regexp.QuoteMeta(id) + `\b`)
+	for i, q := range query {
+		if !skip[i] && q.Query.Expression != "" && pattern.MatchString(q.Query.Expression) {
+			return true
+		}
+	}
+	return false
+}
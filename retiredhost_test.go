@@ -0,0 +1,177 @@
+package forwarder
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPublishMetric_RetiredHost(t *testing.T) {
+	var retiredRequests int32
+	client := newTestMackerelClient(t, http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		var values []HostMetricValue
+		if err := json.NewDecoder(r.Body).Decode(&values); err != nil {
+			t.Error(err)
+		}
+		for _, v := range values {
+			if v.HostID == "host-retired" {
+				atomic.AddInt32(&retiredRequests, 1)
+				rw.Header().Set("Content-Type", "application/json")
+				rw.WriteHeader(http.StatusNotFound)
+				rw.Write([]byte(`{"error":{"message":"Host Not Found"}}`))
+				return
+			}
+		}
+		rw.WriteHeader(http.StatusOK)
+	}))
+
+	f := &Forwarder{}
+	now := time.Now().Truncate(time.Minute).Unix()
+	publish := func() *InvocationReport {
+		report := &InvocationReport{}
+		fctx := &forwardContext{
+			forwarder: f,
+			mackerel:  client,
+			hostMetrics: hostMetricsType{
+				{HostID: "host-abc", Name: "a", Time: now, Value: 1},
+				{HostID: "host-retired", Name: "a", Time: now, Value: 2},
+			},
+			report: report,
+		}
+		fctx.publishMetric(context.Background())
+		if len(fctx.failedHostMetrics) != 0 {
+			t.Errorf("the metrics of the retired host must not be retried: %v", fctx.failedHostMetrics)
+		}
+		return report
+	}
+
+	report := publish()
+	if report.Posted != 1 || report.Dropped != 1 {
+		t.Errorf("unexpected report: posted %d, dropped %d", report.Posted, report.Dropped)
+	}
+	if !f.isRetiredHost(client, "host-retired", time.Now()) {
+		t.Error("the host should be marked as retired")
+	}
+	if f.isRetiredHost(client, "host-abc", time.Now()) {
+		t.Error("the host should not be marked as retired")
+	}
+	if f.isRetiredHost(client, "host-retired", time.Now().Add(2*time.Hour)) {
+		t.Error("the mark should expire")
+	}
+
+	// the metrics of the retired host are dropped without posting.
+	f.markRetiredHost(context.Background(), client, "host-retired", time.Now())
+	atomic.StoreInt32(&retiredRequests, 0)
+	report = publish()
+	if report.Posted != 1 || report.Dropped != 1 {
+		t.Errorf("unexpected report: posted %d, dropped %d", report.Posted, report.Dropped)
+	}
+	if got := atomic.LoadInt32(&retiredRequests); got != 0 {
+		t.Errorf("the metrics of the retired host are posted: %d requests", got)
+	}
+}
+
+func TestSkipRetiredHosts(t *testing.T) {
+	client := NewMackerelClient("api-token")
+	f := &Forwarder{}
+	f.markRetiredHost(context.Background(), client, "host-retired", time.Now())
+	fctx := &forwardContext{
+		forwarder: f,
+		mackerel:  client,
+	}
+	query := []*Query{
+		{
+			Host:   "host-retired",
+			Name:   "rds.cpu",
+			Metric: []interface{}{"AWS/RDS", "CPUUtilization", "DBInstanceIdentifier", "db"},
+			Stat:   "Average",
+		},
+		{
+			ID:     "connections",
+			Host:   "host-retired",
+			Name:   "rds.connections",
+			Metric: []interface{}{"AWS/RDS", "DatabaseConnections", "DBInstanceIdentifier", "db"},
+			Stat:   "Average",
+		},
+		{
+			Service:    "foo",
+			Name:       "rds.connections",
+			Expression: "connections * 2",
+		},
+		{
+			Host:   "host-abc",
+			Name:   "rds.cpu",
+			Metric: []interface{}{"AWS/RDS", "CPUUtilization", "DBInstanceIdentifier", "db2"},
+			Stat:   "Average",
+		},
+	}
+	resolved, errs := prepareQueries(query)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+
+	got := fctx.skipRetiredHosts(context.Background(), resolved)
+	var ids []string
+	for _, q := range got {
+		ids = append(ids, q.ID)
+	}
+	if diff := cmp.Diff([]string{"connections", "m3", "m4"}, ids); diff != "" {
+		t.Errorf("queries mismatch: (-want/+got):\n%s", diff)
+	}
+}
+
+func TestIsRetiredHostError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{errRetiredHost, true},
+		{Error{StatusCode: http.StatusNotFound, Message: `{"error":{"message":"Host Not Found"}}`}, true},
+		{Error{StatusCode: http.StatusBadRequest, Message: `{"error":{"message":"the host is retired"}}`}, true},
+		{Error{StatusCode: http.StatusBadRequest, Message: `{"error":"host retired"}`}, true},
+
+		// the 404s that are not from Mackerel, e.g. a wrong MACKEREL_APIURL or a proxy.
+		{Error{StatusCode: http.StatusNotFound, Message: "404 page not found"}, false},
+		{Error{StatusCode: http.StatusNotFound, Message: `{"error":{"message":"Not Found"}}`}, false},
+		{Error{StatusCode: http.StatusBadRequest, Message: `{"error":{"message":"invalid metric value"}}`}, false},
+		{Error{StatusCode: http.StatusInternalServerError, Message: `{"error":{"message":"Host Not Found"}}`}, false},
+	}
+	for _, tt := range tests {
+		if got := isRetiredHostError(tt.err); got != tt.want {
+			t.Errorf("isRetiredHostError(%v) = %t, want %t", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestPublishMetric_NotFoundFromProxy(t *testing.T) {
+	client := newTestMackerelClient(t, http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusNotFound)
+		rw.Write([]byte("404 page not found"))
+	}))
+
+	f := &Forwarder{}
+	now := time.Now().Truncate(time.Minute).Unix()
+	fctx := &forwardContext{
+		forwarder: f,
+		mackerel:  client,
+		hostMetrics: hostMetricsType{
+			{HostID: "host-abc", Name: "a", Time: now, Value: 1},
+			{HostID: "host-def", Name: "a", Time: now, Value: 2},
+		},
+		report: &InvocationReport{},
+	}
+	fctx.publishMetric(context.Background())
+
+	// the metrics are kept for retrying, and the hosts are not denylisted.
+	if len(fctx.failedHostMetrics) != 2 {
+		t.Errorf("want 2 failed metrics, got %v", fctx.failedHostMetrics)
+	}
+	if f.isRetiredHost(client, "host-abc", time.Now()) || f.isRetiredHost(client, "host-def", time.Now()) {
+		t.Error("the hosts should not be marked as retired")
+	}
+}
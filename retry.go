@@ -0,0 +1,103 @@
+package forwarder
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/shogo82148/go-retry"
+)
+
+// defaultRetryMargin is the default of MackerelClient.RetryMargin.
+const defaultRetryMargin = 3 * time.Second
+
+// retry executes f with the retry policy of the client, see (retry.Policy).Do.
+// If f fails with the Retry-After header, it waits for the rest of the duration that the backoff of the policy doesn't cover,
+// so the next attempt is after the longer of them, and the jitter of the policy is kept.
+// And it doesn't start retrying when the remaining time before the deadline is less than RetryMargin,
+// so that the caller can handle the error before the deadline.
+func (c *MackerelClient) retry(ctx context.Context, f func() error) error {
+	p := c.RetryPolicy
+	delay := p.MinDelay
+	maxDelay := max(p.MaxDelay, p.MinDelay)
+
+	var count int
+	return p.Do(ctx, func() error {
+		count++
+		err := f()
+		if err == nil {
+			return nil
+		}
+		var temp interface{ Temporary() bool }
+		if errors.As(err, &temp) && !temp.Temporary() {
+			return err
+		}
+		if p.MaxCount > 0 && count >= p.MaxCount {
+			return err
+		}
+
+		// the policy sleeps for the delay with the jitter before the next attempt, see (*retry.Retrier).Continue.
+		backoff := max(delay, 0)
+		delay = min(delay*2, maxDelay)
+		var extra time.Duration
+		var merr Error
+		if errors.As(err, &merr) {
+			extra = max(merr.RetryAfter-backoff, 0)
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < backoff+max(p.Jitter, 0)+extra+c.retryMargin() {
+			// there is no time to retry.
+			return retry.MarkPermanent(err)
+		}
+		if extra > 0 {
+			if serr := sleepContext(ctx, extra); serr != nil {
+				return retry.MarkPermanent(err)
+			}
+		}
+		return err
+	})
+}
+
+func (c *MackerelClient) retryMargin() time.Duration {
+	if c.RetryMargin > 0 {
+		return c.RetryMargin
+	}
+	return defaultRetryMargin
+}
+
+// sleepContext sleeps for d. It returns an error immediately if the context is done before d elapses.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < d {
+		// skip sleeping, because the context will be done during the sleep.
+		return context.DeadlineExceeded
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// parseRetryAfter parses the Retry-After header.
+// It accepts both delay-seconds and HTTP-date.
+func parseRetryAfter(h string, now time.Time) time.Duration {
+	if h == "" {
+		return 0
+	}
+	if sec, err := strconv.Atoi(h); err == nil {
+		if sec < 0 {
+			return 0
+		}
+		return time.Duration(sec) * time.Second
+	}
+	if t, err := http.ParseTime(h); err == nil {
+		if d := t.Sub(now); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
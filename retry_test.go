@@ -0,0 +1,127 @@
+package forwarder
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/shogo82148/go-retry"
+)
+
+func TestMackerelClient_RetryAfter(t *testing.T) {
+	var count atomic.Int32
+	client := newTestMackerelClient(t, http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if count.Add(1) == 1 {
+			rw.Header().Set("Retry-After", "1")
+			http.Error(rw, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+	}))
+	client.RetryPolicy = retry.Policy{
+		MinDelay: 10 * time.Millisecond,
+		MaxDelay: 10 * time.Millisecond,
+		Jitter:   10 * time.Millisecond,
+		MaxCount: 3,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	start := time.Now()
+	err := client.PostHostMetricValues(ctx, []HostMetricValue{
+		{HostID: "host-abc", Name: "custom.foo", Time: 1234567890, Value: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second || elapsed > 5*time.Second {
+		t.Errorf("unexpected elapsed time: %s", elapsed)
+	}
+	if count.Load() != 2 {
+		t.Errorf("unexpected count of the requests: %d", count.Load())
+	}
+}
+
+func TestMackerelClient_Retry(t *testing.T) {
+	client := NewMackerelClient("api-token")
+	client.RetryPolicy = retry.Policy{
+		MinDelay: 10 * time.Millisecond,
+		MaxDelay: 10 * time.Millisecond,
+		Jitter:   -5 * time.Millisecond, // shortens the delay
+		MaxCount: 3,
+	}
+
+	// the temporary errors are retried up to MaxCount.
+	var count int
+	err := client.retry(context.Background(), func() error {
+		count++
+		return errors.New("temporary")
+	})
+	if err == nil || count != 3 {
+		t.Errorf("want 3 attempts and an error, got %d, %v", count, err)
+	}
+
+	// the permanent errors are not retried, and they are unwrapped.
+	count = 0
+	want := errors.New("permanent")
+	err = client.retry(context.Background(), func() error {
+		count++
+		return retry.MarkPermanent(want)
+	})
+	if err != want || count != 1 {
+		t.Errorf("want 1 attempt and %v, got %d, %v", want, count, err)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		in   string
+		want time.Duration
+	}{
+		{"", 0},
+		{"120", 2 * time.Minute},
+		{"-1", 0},
+		{"Wed, 01 Jan 2025 00:00:30 GMT", 30 * time.Second},
+		{"Tue, 31 Dec 2024 23:59:00 GMT", 0},
+		{"foo", 0},
+	}
+	for _, tc := range cases {
+		if got := parseRetryAfter(tc.in, now); got != tc.want {
+			t.Errorf("%q: want %s, got %s", tc.in, tc.want, got)
+		}
+	}
+}
+
+func TestMackerelClient_RetryMargin(t *testing.T) {
+	var count atomic.Int32
+	client := newTestMackerelClient(t, http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		count.Add(1)
+		http.Error(rw, "internal server error", http.StatusInternalServerError)
+	}))
+	client.RetryPolicy = retry.Policy{
+		MinDelay: 10 * time.Millisecond,
+		MaxDelay: 10 * time.Millisecond,
+		MaxCount: 10,
+	}
+	client.RetryMargin = time.Minute
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	start := time.Now()
+	err := client.PostHostMetricValues(ctx, []HostMetricValue{
+		{HostID: "host-abc", Name: "custom.foo", Time: 1234567890, Value: 1},
+	})
+	if err == nil {
+		t.Fatal("want error, got nil")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("unexpected elapsed time: %s", elapsed)
+	}
+	if count.Load() != 1 {
+		t.Errorf("want no retry, but the count of the requests is %d", count.Load())
+	}
+}
@@ -0,0 +1,54 @@
+package forwarder
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+)
+
+// invocationTime is the time in the input of the invocation.
+// It is the "time" field of the events of Amazon EventBridge,
+// or the "time" field of QueryDocument (e.g. <aws.scheduler.scheduled-time> of EventBridge Scheduler).
+type invocationTime struct {
+	DetailType string    `json:"detail-type"`
+	Time       time.Time `json:"time"`
+}
+
+// scheduledTime returns the scheduled time of the invocation from the input.
+// It reports false if the input has no time.
+func scheduledTime(data []byte) (time.Time, bool) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || data[0] != '{' {
+		return time.Time{}, false
+	}
+	var v invocationTime
+	if err := json.Unmarshal(data, &v); err != nil || v.Time.IsZero() {
+		return time.Time{}, false
+	}
+	return v.Time, true
+}
+
+// isScheduledEvent reports whether the input is an event of the scheduled rules of Amazon EventBridge.
+// The event has no queries, so the queries are loaded from the query file.
+func isScheduledEvent(data []byte) bool {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || data[0] != '{' {
+		return false
+	}
+	var v invocationTime
+	if err := json.Unmarshal(data, &v); err != nil {
+		return false
+	}
+	return v.DetailType == "Scheduled Event"
+}
+
+// windowTime returns the time for computing the time window of the invocation.
+// It prefers the scheduled time in the input over the current time,
+// so that the delayed starts and the retries of the invocation don't shift the window.
+func windowTime(data []byte, now time.Time) time.Time {
+	t, ok := scheduledTime(data)
+	if !ok || t.After(now) {
+		return now
+	}
+	return t
+}
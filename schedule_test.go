@@ -0,0 +1,102 @@
+package forwarder
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+)
+
+func TestWindowTime(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 30, 0, time.UTC)
+	tests := []struct {
+		name  string
+		input string
+		want  time.Time
+	}{
+		{
+			name:  "scheduled event",
+			input: `{"version":"0","id":"abc","detail-type":"Scheduled Event","source":"aws.events","time":"2024-01-02T03:02:00Z","detail":{}}`,
+			want:  time.Date(2024, 1, 2, 3, 2, 0, 0, time.UTC),
+		},
+		{
+			name:  "query document",
+			input: `{"version":2,"time":"2024-01-02T03:03:00Z","queries":[]}`,
+			want:  time.Date(2024, 1, 2, 3, 3, 0, 0, time.UTC),
+		},
+		{
+			name:  "future",
+			input: `{"version":2,"time":"2024-01-02T03:10:00Z","queries":[]}`,
+			want:  now,
+		},
+		{
+			name:  "legacy array",
+			input: `[{"service":"foo","name":"ec2.cpu","metric":["AWS/EC2","CPUUtilization"]}]`,
+			want:  now,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := windowTime([]byte(tt.input), now); !got.Equal(tt.want) {
+				t.Errorf("want %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestParseQueries_Time(t *testing.T) {
+	queries, err := ParseQueries([]byte(`{"version":2,"time":"2024-01-02T03:03:00Z","queries":[{"service":"foo","name":"ec2.cpu","metric":["AWS/EC2","CPUUtilization"]}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queries) != 1 {
+		t.Errorf("unexpected queries: %v", queries)
+	}
+}
+
+func TestForwardMetrics_ScheduledEvent(t *testing.T) {
+	var start time.Time
+	cw := &recordingCloudWatch{
+		fakeCloudWatch: fakeCloudWatch{
+			values: map[string][]float64{"m1": {1}},
+		},
+		onGetMetricData: func(s time.Time) { start = s },
+	}
+	f := &Forwarder{
+		DryRun:        true,
+		svccloudwatch: cw,
+	}
+	event := []byte(`{"version":"0","detail-type":"Scheduled Event","source":"aws.events","time":"2024-01-02T03:04:00Z","detail":{}}`)
+
+	// the scheduled event has no queries.
+	if _, err := f.ForwardMetrics(context.Background(), event); err == nil {
+		t.Fatal("want error, got nil")
+	}
+
+	path := filepath.Join(t.TempDir(), "query.json")
+	if err := os.WriteFile(path, []byte(`[{"service":"foo","name":"ec2.cpu","metric":["AWS/EC2","CPUUtilization","InstanceId","i-1"],"stat":"Average"}]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f.QueryFile = path
+	if _, err := f.ForwardMetrics(context.Background(), event); err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2024, 1, 2, 3, 2, 0, 0, time.UTC); !start.Equal(want) {
+		t.Errorf("unexpected start time: want %s, got %s", want, start)
+	}
+}
+
+// recordingCloudWatch records the start time of GetMetricData.
+type recordingCloudWatch struct {
+	fakeCloudWatch
+	onGetMetricData func(start time.Time)
+}
+
+func (s *recordingCloudWatch) GetMetricData(ctx context.Context, params *cloudwatch.GetMetricDataInput, optFns ...func(*cloudwatch.Options)) (*cloudwatch.GetMetricDataOutput, error) {
+	s.onGetMetricData(aws.ToTime(params.StartTime))
+	return s.fakeCloudWatch.GetMetricData(ctx, params, optFns...)
+}
@@ -0,0 +1,135 @@
+package forwarder
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+//go:embed query.schema.json
+var querySchemaJSON []byte
+
+// QuerySchema returns the JSON Schema of the query definition.
+// Save it and refer it from the "$schema" of the query document,
+// so that the editors provide the completion and the validation of the query files.
+func QuerySchema() []byte {
+	return bytes.Clone(querySchemaJSON)
+}
+
+// SchemaError is a violation of the JSON Schema of the query definition.
+type SchemaError struct {
+	// Path is the JSON Pointer to the invalid value, e.g. "/queries/0/stat".
+	Path string
+
+	Message string
+}
+
+func (e *SchemaError) Error() string {
+	path := e.Path
+	if path == "" {
+		path = "/"
+	}
+	return path + ": " + e.Message
+}
+
+// SchemaErrors is the aggregated violations of the JSON Schema.
+type SchemaErrors []*SchemaError
+
+func (e SchemaErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return "forwarder: the query definition doesn't match the schema: " + strings.Join(msgs, "; ")
+}
+
+// ValidateQuerySchema validates the query definition against the JSON Schema of QuerySchema.
+// It returns SchemaErrors with the paths of the invalid values.
+//
+// The schema is strict for the editors, e.g. it rejects the unknown keys and the numbers in strings,
+// which ParseQueries accepts. The forwarder reports the violations as warnings unless Forwarder.Strict is set.
+func ValidateQuerySchema(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return fmt.Errorf("forwarder: failed to parse the query definition: %w", err)
+	}
+
+	schema, err := compiledQuerySchema()
+	if err != nil {
+		return err
+	}
+	err = schema.Validate(v)
+	var verr *jsonschema.ValidationError
+	if errors.As(err, &verr) {
+		return schemaErrors(verr)
+	}
+	return err
+}
+
+// querySchemaURL is the id of query.schema.json in the compiler.
+const querySchemaURL = "query.schema.json"
+
+var compiledQuerySchema = sync.OnceValues(func() (*jsonschema.Schema, error) {
+	c := jsonschema.NewCompiler()
+	c.Draft = jsonschema.Draft2020
+	if err := c.AddResource(querySchemaURL, bytes.NewReader(querySchemaJSON)); err != nil {
+		return nil, fmt.Errorf("forwarder: failed to parse the query schema: %w", err)
+	}
+	schema, err := c.Compile(querySchemaURL)
+	if err != nil {
+		return nil, fmt.Errorf("forwarder: failed to compile the query schema: %w", err)
+	}
+	return schema, nil
+})
+
+// schemaErrors flattens the tree of the validation errors into the leaves.
+// In anyOf, the branches that reject the type of the value are omitted if the other branches accept it,
+// e.g. the errors of the query document are reported without "expected array" of the legacy format,
+// because they are more precise than "no schema matches".
+func schemaErrors(verr *jsonschema.ValidationError) SchemaErrors {
+	var errs SchemaErrors
+	var walk func(e *jsonschema.ValidationError)
+	walk = func(e *jsonschema.ValidationError) {
+		if len(e.Causes) == 0 {
+			errs = append(errs, &SchemaError{Path: e.InstanceLocation, Message: e.Message})
+			return
+		}
+		causes := e.Causes
+		if strings.HasSuffix(e.KeywordLocation, "/anyOf") {
+			var typed []*jsonschema.ValidationError
+			for _, c := range causes {
+				if !isSchemaTypeError(c, e.InstanceLocation) {
+					typed = append(typed, c)
+				}
+			}
+			if len(typed) > 0 {
+				causes = typed
+			}
+		}
+		for _, c := range causes {
+			walk(c)
+		}
+	}
+	walk(verr)
+	slices.SortStableFunc(errs, func(a, b *SchemaError) int {
+		return strings.Compare(a.Path, b.Path) // for the stable order of the errors
+	})
+	return errs
+}
+
+// isSchemaTypeError reports whether the branch of anyOf rejects the type of the value at the location.
+func isSchemaTypeError(e *jsonschema.ValidationError, location string) bool {
+	for len(e.Causes) == 1 && e.InstanceLocation == location {
+		e = e.Causes[0]
+	}
+	return len(e.Causes) == 0 && e.InstanceLocation == location && strings.HasSuffix(e.KeywordLocation, "/type")
+}
@@ -0,0 +1,152 @@
+package forwarder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestValidateQuerySchema(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  SchemaErrors
+	}{
+		{
+			name:  "legacy array",
+			input: `[{"service":"foo","name":"ec2.cpu","metric":["AWS/EC2","CPUUtilization","InstanceId","i-1",{}],"stat":"Average","default":0}]`,
+		},
+		{
+			name:  "document",
+			input: `{"$schema":"./query.schema.json","version":2,"queries":[{"host":"host-abc","name":"cpu","metric":{"namespace":"AWS/EC2","name":"CPUUtilization"},"check":{"warning":80}}]}`,
+		},
+		{
+			name:  "unknown property",
+			input: `[{"service":"foo","name":"ec2.cpu","metric":["AWS/EC2","CPUUtilization"]},{"hostId":"host-abc"}]`,
+			want: SchemaErrors{
+				{Path: "/1", Message: "additionalProperties 'hostId' not allowed"},
+			},
+		},
+		{
+			name:  "invalid types",
+			input: `{"version":2,"queries":[{"default":"0","returnData":"false","topN":1.5}]}`,
+			want: SchemaErrors{
+				{Path: "/queries/0/default", Message: "expected number, but got string"},
+				{Path: "/queries/0/returnData", Message: "expected boolean, but got string"},
+				{Path: "/queries/0/topN", Message: "expected integer, but got number"},
+			},
+		},
+		{
+			name:  "invalid enum",
+			input: `[{"fill":"previous"}]`,
+			want: SchemaErrors{
+				{Path: "/0/fill", Message: `value must be one of "none", "zero", "last", "default"`},
+			},
+		},
+		{
+			name:  "invalid metric object",
+			input: `{"version":2,"queries":[{"metric":{"namespace":"AWS/EC2","dimensions":{"InstanceId":1}}}]}`,
+			want: SchemaErrors{
+				{Path: "/queries/0/metric", Message: "missing properties: 'name'"},
+				{Path: "/queries/0/metric/dimensions/InstanceId", Message: "expected string, but got number"},
+			},
+		},
+		{
+			name:  "invalid metric",
+			input: `[{"metric":"AWS/EC2"}]`,
+			want: SchemaErrors{
+				{Path: "/0/metric", Message: "expected object, but got string"},
+				{Path: "/0/metric", Message: "expected array, but got string"},
+			},
+		},
+		{
+			name:  "missing queries",
+			input: `{"version":2}`,
+			want: SchemaErrors{
+				{Path: "", Message: "missing properties: 'queries'"},
+				{Path: "", Message: "missing properties: 'groups'"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateQuerySchema([]byte(tt.input))
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("want nil, got %v", err)
+				}
+				return
+			}
+			var got SchemaErrors
+			if !errors.As(err, &got) {
+				t.Fatalf("want SchemaErrors, got %v", err)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("errors mismatch: (-want/+got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// TestParseQueries_Loose checks that the input violating the schema is parsed as before,
+// e.g. the numbers in strings, the keys in different cases, and the unknown keys.
+func TestParseQueries_Loose(t *testing.T) {
+	input := []byte(`[{"Service":"foo","name":"ec2.cpu","metric":["AWS/EC2","CPUUtilization"],"stat":"Average","default":"0","comment":"memo"}]`)
+	if err := ValidateQuerySchema(input); err == nil {
+		t.Fatal("want schema errors, got nil")
+	}
+	queries, err := ParseQueries(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ToMetricDataQuery(queries); err != nil {
+		t.Fatal(err)
+	}
+	if queries[0].Service != "foo" || queries[0].Default == nil || *queries[0].Default != 0 {
+		t.Errorf("unexpected query: %#v", queries[0])
+	}
+}
+
+func TestLoadQueries_Schema(t *testing.T) {
+	input := []byte(`[{"service":"foo","name":"ec2.cpu","metric":["AWS/EC2","CPUUtilization"],"sate":"Average"}]`)
+
+	// the violations are only warned by default.
+	f := &Forwarder{EnvConfig: &Config{}}
+	if _, _, err := f.loadQueries(context.Background(), input); err != nil {
+		t.Fatal(err)
+	}
+
+	f = &Forwarder{EnvConfig: &Config{}, Strict: true}
+	_, _, err := f.loadQueries(context.Background(), input)
+	var errs SchemaErrors
+	if !errors.As(err, &errs) {
+		t.Errorf("want SchemaErrors, got %v", err)
+	}
+}
+
+// TestQuerySchema_Presets checks that the schema is in sync with the built-in presets.
+func TestQuerySchema_Presets(t *testing.T) {
+	var schema struct {
+		Defs map[string]struct {
+			Properties map[string]struct {
+				Enum []string `json:"enum"`
+			} `json:"properties"`
+		} `json:"$defs"`
+	}
+	if err := json.Unmarshal(QuerySchema(), &schema); err != nil {
+		t.Fatal(err)
+	}
+	got := schema.Defs["query"].Properties["preset"].Enum
+	want := make([]string, 0, len(queryPresets))
+	for name := range queryPresets {
+		want = append(want, name)
+	}
+	slices.Sort(want)
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("presets mismatch: (-want/+got):\n%s", diff)
+	}
+}
@@ -0,0 +1,83 @@
+package forwarder
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// selfMetricsPrefix is the prefix of the metric names of the forwarder itself.
+const selfMetricsPrefix = "mackerel_cloudwatch_forwarder_"
+
+// forwarderStats is the cumulative results of the invocations of ForwardMetrics.
+type forwarderStats struct {
+	invocations      int64
+	invocationErrors int64
+	fetched          int64
+	posted           int64
+	failed           int64
+	dropped          int64
+	pending          int       // the number of the pending data points after the last invocation
+	lastSuccess      time.Time // the time when the last successful invocation finished
+}
+
+// recordStats records the result of the invocation.
+func (f *Forwarder) recordStats(report *InvocationReport, err error) {
+	f.muPending.Lock()
+	pending := len(f.pendingHostMetrics)
+	for _, metrics := range f.pendingServiceMetrics {
+		pending += len(metrics)
+	}
+	f.muPending.Unlock()
+
+	f.muStats.Lock()
+	defer f.muStats.Unlock()
+	s := &f.stats
+	s.invocations++
+	s.fetched += int64(report.Fetched)
+	s.posted += int64(report.Posted)
+	s.failed += int64(report.Failed)
+	s.dropped += int64(report.Dropped)
+	s.pending = pending
+	if err != nil {
+		s.invocationErrors++
+	} else {
+		s.lastSuccess = time.Now()
+	}
+}
+
+// MetricsHandler returns the handler that exposes the metrics of the forwarder itself
+// in the Prometheus text format, e.g. for serving "/metrics" in the daemon mode.
+// The metrics can be scraped by Prometheus, or by mackerel-agent with mackerel-plugin-prometheus-exporter.
+func (f *Forwarder) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.muStats.Lock()
+		s := f.stats
+		f.muStats.Unlock()
+
+		var lastSuccess float64
+		if !s.lastSuccess.IsZero() {
+			lastSuccess = float64(s.lastSuccess.UnixNano()) / 1e9
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		for _, m := range []struct {
+			name  string
+			typ   string
+			help  string
+			value float64
+		}{
+			{"invocations_total", "counter", "The number of the invocations.", float64(s.invocations)},
+			{"invocation_errors_total", "counter", "The number of the failed invocations.", float64(s.invocationErrors)},
+			{"fetched_total", "counter", "The number of the data points fetched from AWS.", float64(s.fetched)},
+			{"posted_total", "counter", "The number of the data points posted to Mackerel.", float64(s.posted)},
+			{"failed_total", "counter", "The number of the data points that failed to post.", float64(s.failed)},
+			{"dropped_total", "counter", "The number of the data points dropped without posting.", float64(s.dropped)},
+			{"pending_metrics", "gauge", "The number of the pending data points for retrying.", float64(s.pending)},
+			{"last_success_timestamp_seconds", "gauge", "The unix time of the last successful invocation.", lastSuccess},
+		} {
+			name := selfMetricsPrefix + m.name
+			fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %g\n", name, m.help, name, m.typ, name, m.value)
+		}
+	})
+}
@@ -0,0 +1,38 @@
+package forwarder
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestForwarder_MetricsHandler(t *testing.T) {
+	f := &Forwarder{
+		pendingHostMetrics: hostMetricsType{
+			{HostID: "host-abc", Name: "custom.foo", Time: 1234567860, Value: 1},
+		},
+	}
+	f.recordStats(&InvocationReport{Fetched: 3, Posted: 2, Failed: 1}, nil)
+	f.recordStats(&InvocationReport{Fetched: 1, Dropped: 1}, errors.New("failed"))
+
+	rec := httptest.NewRecorder()
+	f.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		"# TYPE mackerel_cloudwatch_forwarder_invocations_total counter\nmackerel_cloudwatch_forwarder_invocations_total 2\n",
+		"mackerel_cloudwatch_forwarder_invocation_errors_total 1\n",
+		"mackerel_cloudwatch_forwarder_fetched_total 4\n",
+		"mackerel_cloudwatch_forwarder_posted_total 2\n",
+		"mackerel_cloudwatch_forwarder_failed_total 1\n",
+		"mackerel_cloudwatch_forwarder_dropped_total 1\n",
+		"# TYPE mackerel_cloudwatch_forwarder_pending_metrics gauge\nmackerel_cloudwatch_forwarder_pending_metrics 1\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("want %q in the metrics:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "mackerel_cloudwatch_forwarder_last_success_timestamp_seconds 0\n") {
+		t.Errorf("the last success is not recorded:\n%s", body)
+	}
+}
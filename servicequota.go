@@ -0,0 +1,98 @@
+package forwarder
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+	"github.com/aws/aws-sdk-go-v2/service/servicequotas"
+)
+
+// queryTypeServiceQuota is the query type for AWS Service Quotas.
+const queryTypeServiceQuota = "serviceQuota"
+
+// ServiceQuotaQuery is a query for AWS Service Quotas.
+// The usage of the quota is forwarded as the percentage of the quota value.
+type ServiceQuotaQuery struct {
+	// ServiceCode is the service identifier, e.g. "lambda".
+	ServiceCode string `json:"serviceCode"`
+
+	// QuotaCode is the quota identifier, e.g. "L-B99A9384".
+	QuotaCode string `json:"quotaCode"`
+}
+
+func (f *Forwarder) servicequotas() servicequotasiface {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.svcservicequotas == nil {
+		f.svcservicequotas = servicequotas.NewFromConfig(f.awsConfig(), func(o *servicequotas.Options) {
+			f.resolveEndpoint("servicequotas", &o.BaseEndpoint, &o.EndpointOptions.UseFIPSEndpoint, &o.EndpointOptions.UseDualStackEndpoint)
+		})
+	}
+	return f.svcservicequotas
+}
+
+// getServiceQuotaUtilization gets the utilization of the service quota.
+func (fctx *forwardContext) getServiceQuotaUtilization(ctx context.Context, q *metricQuery) error {
+	sq := q.Query.ServiceQuota
+	resp, err := fctx.forwarder.servicequotas().GetServiceQuota(ctx, &servicequotas.GetServiceQuotaInput{
+		ServiceCode: aws.String(sq.ServiceCode),
+		QuotaCode:   aws.String(sq.QuotaCode),
+	})
+	if err != nil {
+		return fmt.Errorf("forwarder: failed to get the service quota %s/%s: %w", sq.ServiceCode, sq.QuotaCode, err)
+	}
+	quota := resp.Quota
+	if quota == nil || quota.Value == nil || *quota.Value == 0 {
+		return fmt.Errorf("forwarder: the value of the service quota %s/%s is not available", sq.ServiceCode, sq.QuotaCode)
+	}
+	usage := quota.UsageMetric
+	if usage == nil || usage.MetricNamespace == nil || usage.MetricName == nil {
+		return fmt.Errorf("forwarder: the service quota %s/%s has no usage metric", sq.ServiceCode, sq.QuotaCode)
+	}
+
+	stat := types.StatisticMaximum
+	if s := aws.ToString(usage.MetricStatisticRecommendation); s != "" {
+		stat = types.Statistic(s)
+	}
+	keys := make([]string, 0, len(usage.MetricDimensions))
+	for k := range usage.MetricDimensions {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	dimensions := make([]types.Dimension, 0, len(keys))
+	for _, k := range keys {
+		dimensions = append(dimensions, types.Dimension{
+			Name:  aws.String(k),
+			Value: aws.String(usage.MetricDimensions[k]),
+		})
+	}
+
+	start, end := fctx.window(q)
+	input := &cloudwatch.GetMetricStatisticsInput{
+		Namespace:  usage.MetricNamespace,
+		MetricName: usage.MetricName,
+		Dimensions: dimensions,
+		StartTime:  aws.Time(start),
+		EndTime:    aws.Time(end),
+		Period:     aws.Int32(60),
+		Statistics: []types.Statistic{stat},
+	}
+	stats, err := withThrottle(ctx, &fctx.throttle, func() (*cloudwatch.GetMetricStatisticsOutput, error) {
+		return fctx.forwarder.cloudwatch().GetMetricStatistics(ctx, input)
+	})
+	if err != nil {
+		return fmt.Errorf("forwarder: failed to get the usage of the service quota %s/%s: %w", sq.ServiceCode, sq.QuotaCode, err)
+	}
+	for _, dp := range stats.Datapoints {
+		v := standardStatisticValue(dp, stat)
+		if v == nil || dp.Timestamp == nil {
+			continue
+		}
+		fctx.appendValue(q.Query, q.Label, *dp.Timestamp, *v / *quota.Value * 100)
+	}
+	return nil
+}
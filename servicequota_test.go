@@ -0,0 +1,77 @@
+package forwarder
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+	"github.com/aws/aws-sdk-go-v2/service/servicequotas"
+	sqtypes "github.com/aws/aws-sdk-go-v2/service/servicequotas/types"
+	"github.com/google/go-cmp/cmp"
+)
+
+type fakeServiceQuotas struct{}
+
+func (s *fakeServiceQuotas) GetServiceQuota(ctx context.Context, params *servicequotas.GetServiceQuotaInput, optFns ...func(*servicequotas.Options)) (*servicequotas.GetServiceQuotaOutput, error) {
+	return &servicequotas.GetServiceQuotaOutput{
+		Quota: &sqtypes.ServiceQuota{
+			ServiceCode: params.ServiceCode,
+			QuotaCode:   params.QuotaCode,
+			Value:       aws.Float64(1000),
+			UsageMetric: &sqtypes.MetricInfo{
+				MetricNamespace: aws.String("AWS/Usage"),
+				MetricName:      aws.String("ConcurrentExecutions"),
+				MetricDimensions: map[string]string{
+					"Type":     "Resource",
+					"Resource": "ConcurrentExecutions",
+					"Service":  "Lambda",
+					"Class":    "None",
+				},
+				MetricStatisticRecommendation: aws.String("Maximum"),
+			},
+		},
+	}, nil
+}
+
+func TestGetMetricsData_ServiceQuota(t *testing.T) {
+	start := time.Unix(1234567860, 0)
+	fctx := &forwardContext{
+		forwarder: &Forwarder{
+			svcservicequotas: &fakeServiceQuotas{},
+			svccloudwatch: &fakeCloudWatch{
+				statistics: map[string][]types.Datapoint{
+					"ConcurrentExecutions": {
+						{Timestamp: aws.Time(start), Maximum: aws.Float64(250)},
+					},
+				},
+			},
+		},
+		start: start,
+		end:   start.Add(time.Minute),
+	}
+	query := []*Query{
+		{
+			Type:    "serviceQuota",
+			Service: "aws",
+			Name:    "quota.lambda.concurrent_executions",
+			ServiceQuota: &ServiceQuotaQuery{
+				ServiceCode: "lambda",
+				QuotaCode:   "L-B99A9384",
+			},
+		},
+	}
+	if err := fctx.getMetricsData(context.Background(), query); err != nil {
+		t.Fatal(err)
+	}
+
+	want := serviceMetricsType{
+		"aws": {
+			{Name: "quota.lambda.concurrent_executions", Time: start.Unix(), Value: 25},
+		},
+	}
+	if diff := cmp.Diff(want, fctx.serviceMetrics); diff != "" {
+		t.Errorf("metrics mismatch: (-want/+got):\n%s", diff)
+	}
+}
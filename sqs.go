@@ -0,0 +1,37 @@
+package forwarder
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// ForwardMetricsSQS forwards metrics of the queries in the SQS messages.
+// Each message body is the queries in the same format as the input of ForwardMetrics.
+//
+// The messages that failed to forward are reported as the batch item failures,
+// so SQS redelivers them instead of buffering the failed metrics in memory.
+// Enable ReportBatchItemFailures of the event source mapping to use it.
+func (f *Forwarder) ForwardMetricsSQS(ctx context.Context, event *events.SQSEvent) (*events.SQSEventResponse, error) {
+	ctx, cancel := f.invocationContext(ctx)
+	defer cancel()
+
+	groups := make([]QueryGroup, 0, len(event.Records))
+	for _, msg := range event.Records {
+		groups = append(groups, QueryGroup{
+			ID:      msg.MessageId,
+			Queries: json.RawMessage(msg.Body),
+		})
+	}
+
+	resp := &events.SQSEventResponse{
+		BatchItemFailures: []events.SQSBatchItemFailure{},
+	}
+	for _, failure := range f.forwardGroups(ctx, groups) {
+		resp.BatchItemFailures = append(resp.BatchItemFailures, events.SQSBatchItemFailure{
+			ItemIdentifier: failure.ItemIdentifier,
+		})
+	}
+	return resp, nil
+}
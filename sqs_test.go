@@ -0,0 +1,65 @@
+package forwarder
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestForwardMetricsSQS(t *testing.T) {
+	var posted []string
+	client := newTestMackerelClient(t, http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		posted = append(posted, r.URL.Path)
+		if strings.Contains(r.URL.Path, "/services/bar/") {
+			rw.WriteHeader(http.StatusForbidden)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+	}))
+	f := &Forwarder{
+		svcmackerel: client,
+		svccloudwatch: &fakeCloudWatch{
+			values: map[string][]float64{
+				"m1": {1},
+			},
+		},
+	}
+	event := &events.SQSEvent{
+		Records: []events.SQSMessage{
+			{
+				MessageId: "message-bar",
+				Body:      `[{"service":"bar","name":"ec2.cpu","metric":["AWS/EC2","CPUUtilization","InstanceId","i-2"],"stat":"Average"}]`,
+			},
+			{
+				MessageId: "message-foo",
+				Body:      `[{"service":"foo","name":"ec2.cpu","metric":["AWS/EC2","CPUUtilization","InstanceId","i-1"],"stat":"Average"}]`,
+			},
+		},
+	}
+
+	resp, err := f.ForwardMetricsSQS(context.Background(), event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &events.SQSEventResponse{
+		BatchItemFailures: []events.SQSBatchItemFailure{
+			{ItemIdentifier: "message-bar"},
+		},
+	}
+	if diff := cmp.Diff(want, resp); diff != "" {
+		t.Errorf("response mismatch: (-want/+got):\n%s", diff)
+	}
+
+	// the failed metrics are not retried in the next message, SQS redelivers the message instead.
+	wantPosted := []string{
+		"/api/v0/services/bar/tsdb",
+		"/api/v0/services/foo/tsdb",
+	}
+	if diff := cmp.Diff(wantPosted, posted); diff != "" {
+		t.Errorf("posted mismatch: (-want/+got):\n%s", diff)
+	}
+}
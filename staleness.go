@@ -0,0 +1,88 @@
+package forwarder
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// defaultMaxMetricAge is the default of Forwarder.MaxMetricAge.
+// It is the same as the retention of the pending metrics.
+const defaultMaxMetricAge = 6 * time.Hour
+
+// maxMetricAge returns the maximum age of the data points for posting.
+func (f *Forwarder) maxMetricAge(ctx context.Context) time.Duration {
+	if f.MaxMetricAge > 0 {
+		return f.MaxMetricAge
+	}
+	if d := f.env().MaxMetricAge; d > 0 {
+		return d
+	}
+	return defaultMaxMetricAge
+}
+
+// dropStaleMetrics drops the data points that are older than the max age,
+// because Mackerel rejects the whole request if it contains such data points.
+func (fctx *forwardContext) dropStaleMetrics(ctx context.Context, now time.Time) {
+	maxAge := fctx.forwarder.maxMetricAge(ctx)
+	threshold := now.Add(-maxAge)
+
+	for service, cnt := range fctx.forwarder.dropStaleServiceMetrics(ctx, fctx.serviceMetrics, now) {
+		fctx.report.addStale(service, cnt)
+	}
+
+	if cnt := fctx.hostMetrics.Drop(threshold); cnt > 0 {
+		fctx.report.addStale("", cnt)
+		fctx.forwarder.logger().WarnContext(ctx, "drop stale host metrics",
+			"count", cnt,
+			"max_age", maxAge.String(),
+		)
+	}
+}
+
+// dropStaleServiceMetrics drops the service metrics that are older than the max age with warnings.
+// It returns the number of the dropped data points of each service.
+func (f *Forwarder) dropStaleServiceMetrics(ctx context.Context, metrics serviceMetricsType, now time.Time) map[string]int {
+	maxAge := f.maxMetricAge(ctx)
+	threshold := now.Add(-maxAge)
+
+	dropped := make(map[string]int)
+	for service, values := range metrics {
+		m := serviceMetricsType{service: values}
+		cnt := m.Drop(threshold)
+		if cnt == 0 {
+			continue
+		}
+		if len(m[service]) > 0 {
+			metrics[service] = m[service]
+		} else {
+			delete(metrics, service)
+		}
+		dropped[service] = cnt
+		f.logger().WarnContext(ctx, "drop stale service metrics",
+			"service", service,
+			"count", cnt,
+			"max_age", maxAge.String(),
+		)
+	}
+	return dropped
+}
+
+// validateMetricAge reports the queries whose data points are always older than the max age,
+// i.e. the delay plus the period reaches it, because dropStaleMetrics drops all of their data points.
+// The queries that don't return data are not posted, so they are not reported.
+func validateMetricAge(queries []*metricQuery, maxAge time.Duration) ([]*metricQuery, QueryErrors) {
+	var errs QueryErrors
+	valid := make([]*metricQuery, 0, len(queries))
+	for _, q := range queries {
+		if q.Query.returnData() && q.Delay+q.period() >= maxAge {
+			errs = append(errs, &QueryError{
+				Index: q.Index,
+				Err:   fmt.Errorf("the delay %s plus the period %s reaches the max metric age %s, the data points are always dropped", q.Delay, q.period(), maxAge),
+			})
+			continue
+		}
+		valid = append(valid, q)
+	}
+	return valid, errs
+}
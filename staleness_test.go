@@ -0,0 +1,111 @@
+package forwarder
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDropStaleMetrics(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	old := now.Add(-2 * time.Hour).Unix()
+	recent := now.Add(-time.Minute).Unix()
+	report := &InvocationReport{}
+	fctx := &forwardContext{
+		forwarder: &Forwarder{
+			MaxMetricAge: time.Hour,
+		},
+		serviceMetrics: serviceMetricsType{
+			"foo": {
+				{Name: "a", Time: old, Value: 1},
+				{Name: "a", Time: recent, Value: 2},
+			},
+			"bar": {
+				{Name: "a", Time: old, Value: 3},
+			},
+		},
+		hostMetrics: hostMetricsType{
+			{HostID: "host-abc", Name: "b", Time: old, Value: 4},
+			{HostID: "host-abc", Name: "b", Time: recent, Value: 5},
+		},
+		report: report,
+	}
+	fctx.dropStaleMetrics(context.Background(), now)
+
+	wantService := serviceMetricsType{
+		"foo": {
+			{Name: "a", Time: recent, Value: 2},
+		},
+	}
+	if diff := cmp.Diff(wantService, fctx.serviceMetrics); diff != "" {
+		t.Errorf("service metrics mismatch: (-want/+got):\n%s", diff)
+	}
+	wantHost := hostMetricsType{
+		{HostID: "host-abc", Name: "b", Time: recent, Value: 5},
+	}
+	if diff := cmp.Diff(wantHost, fctx.hostMetrics); diff != "" {
+		t.Errorf("host metrics mismatch: (-want/+got):\n%s", diff)
+	}
+
+	wantReport := &InvocationReport{
+		Dropped: 3,
+		Stale:   3,
+		Services: map[string]*ServiceReport{
+			"foo": {Dropped: 1, Stale: 1},
+			"bar": {Dropped: 1, Stale: 1},
+		},
+	}
+	if diff := cmp.Diff(wantReport, report); diff != "" {
+		t.Errorf("report mismatch: (-want/+got):\n%s", diff)
+	}
+}
+
+func TestMaxMetricAge(t *testing.T) {
+	// the environment values are loaded on the first use, so use new forwarders.
+	t.Setenv("FORWARD_MAX_METRIC_AGE", "3h")
+	f := &Forwarder{}
+	if got, want := f.maxMetricAge(context.Background()), 3*time.Hour; got != want {
+		t.Errorf("unexpected max age: want %s, got %s", want, got)
+	}
+	t.Setenv("FORWARD_MAX_METRIC_AGE", "-1h")
+	f = &Forwarder{}
+	if got, want := f.maxMetricAge(context.Background()), defaultMaxMetricAge; got != want {
+		t.Errorf("unexpected max age: want %s, got %s", want, got)
+	}
+}
+
+func TestGetMetricsData_MaxMetricAge(t *testing.T) {
+	hidden := false
+	query := []*Query{
+		{Service: "foo", Name: "s3.size", Metric: []interface{}{"AWS/S3", "BucketSizeBytes", "BucketName", "bucket", "StorageType", "StandardStorage"}, Stat: "Average", Delay: "24h", Period: "86400"},
+		{Service: "foo", Name: "ec2.cpu", Metric: []interface{}{"AWS/EC2", "CPUUtilization", "InstanceId", "i-1"}, Stat: "Average", Delay: "5h", Period: "1h"},
+		{Service: "foo", Name: "ec2.network", Metric: []interface{}{"AWS/EC2", "NetworkIn", "InstanceId", "i-1"}, Stat: "Sum", Delay: "4h", Period: "1h"},
+		{ID: "input", Metric: []interface{}{"AWS/EC2", "NetworkOut", "InstanceId", "i-1"}, Stat: "Sum", Delay: "24h", ReturnData: &hidden},
+	}
+	resolved, errs := prepareQueries(query)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	valid, errs := validateMetricAge(resolved, defaultMaxMetricAge)
+	if len(valid) != 2 || valid[0].Index != 2 || valid[1].Index != 3 {
+		t.Errorf("unexpected valid queries: %v", valid)
+	}
+	if len(errs) != 2 || errs[0].Index != 0 || errs[1].Index != 1 {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+
+	// the invalid queries fail the invocation in the strict mode.
+	fctx := &forwardContext{
+		forwarder: &Forwarder{
+			Strict:        true,
+			svccloudwatch: &fakeCloudWatch{},
+		},
+	}
+	var qerrs QueryErrors
+	if err := fctx.getMetricsData(context.Background(), query); !errors.As(err, &qerrs) || len(qerrs) != 2 {
+		t.Errorf("want QueryErrors, got %v", err)
+	}
+}
@@ -0,0 +1,381 @@
+package forwarder
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// State is the state of the forwarder that is kept across the execution environments of AWS Lambda.
+type State struct {
+	// ServiceMetrics are the service metrics that are pending to retry.
+	ServiceMetrics map[string][]ServiceMetricValue `json:"serviceMetrics,omitempty"`
+
+	// HostMetrics are the host metrics that are pending to retry.
+	HostMetrics []HostMetricValue `json:"hostMetrics,omitempty"`
+
+	// HighWaterMarks are the unix time of the latest forwarded data points of the labels.
+	// The minutes up to them are not fetched again in the overlapping time windows.
+	HighWaterMarks map[string]int64 `json:"highWaterMarks,omitempty"`
+
+	// Discovery is the cache of the discovery results.
+	// It is saved only if Forwarder.PersistDiscoveryCache is enabled.
+	Discovery *DiscoveryCache `json:"discovery,omitempty"`
+}
+
+// StateStore stores the state of the forwarder.
+type StateStore interface {
+	// LoadState loads the state. It returns an empty state if the state is not found.
+	LoadState(ctx context.Context) (*State, error)
+
+	// SaveState saves the state.
+	SaveState(ctx context.Context, state *State) error
+}
+
+// StateUpdater is the StateStore that updates the state atomically.
+// The execution environments of AWS Lambda share the state, so the updates must not overwrite the others.
+type StateUpdater interface {
+	StateStore
+
+	// UpdateState loads the state, applies update to it, and saves it if update reports that it is changed.
+	// update may be called more than once on the conflicts, so it must not have side effects outside the state.
+	UpdateState(ctx context.Context, update func(state *State) bool) error
+}
+
+// updateState updates the state in the store.
+// If the store is not StateUpdater, the state is loaded and saved without the protection of the conflicts.
+func updateState(ctx context.Context, store StateStore, update func(state *State) bool) error {
+	if u, ok := store.(StateUpdater); ok {
+		return u.UpdateState(ctx, update)
+	}
+	state, err := store.LoadState(ctx)
+	if err != nil {
+		return err
+	}
+	if !update(state) {
+		return nil
+	}
+	return store.SaveState(ctx, state)
+}
+
+// S3StateStore stores the state as a JSON object on Amazon S3.
+// It updates the object with the conditional writes, see UpdateState.
+type S3StateStore struct {
+	Bucket string
+	Key    string
+
+	// KMSKeyID is the id of the KMS key for encrypting the state on the client side.
+	// The state is encrypted by a data key of the KMS key, see encryptState.
+	// If it is empty, the state is stored in plain text.
+	// If it is set, the state in plain text is rejected unless AllowPlaintext is true.
+	KMSKeyID string
+
+	// AllowPlaintext allows loading the state in plain text even if KMSKeyID is set,
+	// so that the encryption can be enabled on the existing state.
+	// The state is encrypted on the next save, and then it should be disabled.
+	AllowPlaintext bool
+
+	svc    s3iface
+	svckms kmsdatakeyiface
+}
+
+var _ StateUpdater = (*S3StateStore)(nil)
+
+// maxStateUpdateAttempts is the maximum number of the attempts of UpdateState on the conflicts.
+const maxStateUpdateAttempts = 5
+
+// NewS3StateStore creates a new S3StateStore from the URI, e.g. "s3://bucket/path/to/state.json".
+func NewS3StateStore(cfg aws.Config, uri string) (*S3StateStore, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("forwarder: failed to parse the state store uri: %w", err)
+	}
+	if u.Scheme != "s3" || u.Host == "" || strings.TrimPrefix(u.Path, "/") == "" {
+		return nil, fmt.Errorf("forwarder: invalid state store uri: %q", uri)
+	}
+	return &S3StateStore{
+		Bucket: u.Host,
+		Key:    strings.TrimPrefix(u.Path, "/"),
+		svc:    s3.NewFromConfig(cfg),
+		svckms: kms.NewFromConfig(cfg),
+	}, nil
+}
+
+// newS3StateStore creates a new S3StateStore whose clients use the endpoint settings of the forwarder.
+func (f *Forwarder) newS3StateStore(uri string) (*S3StateStore, error) {
+	cfg := f.awsConfig()
+	store, err := NewS3StateStore(cfg, uri)
+	if err != nil {
+		return nil, err
+	}
+	store.svc = s3.NewFromConfig(cfg, func(o *s3.Options) {
+		f.resolveEndpoint("s3", &o.BaseEndpoint, &o.EndpointOptions.UseFIPSEndpoint, &o.EndpointOptions.UseDualStackEndpoint)
+	})
+	store.svckms = kms.NewFromConfig(cfg, func(o *kms.Options) {
+		f.resolveEndpoint("kms", &o.BaseEndpoint, &o.EndpointOptions.UseFIPSEndpoint, &o.EndpointOptions.UseDualStackEndpoint)
+	})
+	return store, nil
+}
+
+// LoadState implements StateStore.
+func (s *S3StateStore) LoadState(ctx context.Context) (*State, error) {
+	state, _, err := s.loadState(ctx)
+	return state, err
+}
+
+// loadState loads the state and the ETag of the object.
+// The ETag is empty if the object is not found.
+func (s *S3StateStore) loadState(ctx context.Context) (*State, string, error) {
+	resp, err := s.svc.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(s.Key),
+	})
+	if err != nil {
+		var notFound *types.NoSuchKey
+		if errors.As(err, &notFound) {
+			return &State{}, "", nil
+		}
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	data, err = decryptState(ctx, s.svckms, s.encryptionContext(), data, s.KMSKeyID == "" || s.AllowPlaintext)
+	if err != nil {
+		return nil, "", err
+	}
+	var state State
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, "", err
+	}
+	return &state, aws.ToString(resp.ETag), nil
+}
+
+// SaveState implements StateStore.
+// It overwrites the state unconditionally, use UpdateState to keep the updates of others.
+func (s *S3StateStore) SaveState(ctx context.Context, state *State) error {
+	return s.saveState(ctx, state, nil)
+}
+
+// UpdateState implements StateUpdater.
+// The object is written only if it is not changed since it is loaded, and the update is retried on the conflicts.
+func (s *S3StateStore) UpdateState(ctx context.Context, update func(state *State) bool) error {
+	for i := 0; ; i++ {
+		state, etag, err := s.loadState(ctx)
+		if err != nil {
+			return err
+		}
+		if !update(state) {
+			return nil
+		}
+		err = s.saveState(ctx, state, func(input *s3.PutObjectInput) {
+			if etag == "" {
+				input.IfNoneMatch = aws.String("*")
+			} else {
+				input.IfMatch = aws.String(etag)
+			}
+		})
+		if isStateConflict(err) && i+1 < maxStateUpdateAttempts {
+			continue
+		}
+		return err
+	}
+}
+
+// isStateConflict reports whether the conditional write failed because the object is changed by others.
+func isStateConflict(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.ErrorCode() {
+	case "PreconditionFailed", "ConditionalRequestConflict":
+		return true
+	}
+	return false
+}
+
+func (s *S3StateStore) saveState(ctx context.Context, state *State, condition func(input *s3.PutObjectInput)) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if s.KMSKeyID != "" {
+		data, err = encryptState(ctx, s.svckms, s.KMSKeyID, s.encryptionContext(), data)
+		if err != nil {
+			return fmt.Errorf("forwarder: failed to encrypt the state: %w", err)
+		}
+	}
+	input := &s3.PutObjectInput{
+		Bucket:      aws.String(s.Bucket),
+		Key:         aws.String(s.Key),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/json"),
+	}
+	if condition != nil {
+		condition(input)
+	}
+	_, err = s.svc.PutObject(ctx, input)
+	return err
+}
+
+// encryptionContext returns the encryption context of the state,
+// so that the encrypted state can't be moved to another object.
+func (s *S3StateStore) encryptionContext() map[string]string {
+	return map[string]string{
+		"forwarder:state": "s3://" + s.Bucket + "/" + s.Key,
+	}
+}
+
+func (f *Forwarder) stateStore(ctx context.Context) StateStore {
+	if f.StateStore != nil {
+		return f.StateStore
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.svcstate != nil {
+		return f.svcstate
+	}
+	uri := f.env().StateStore
+	if uri == "" {
+		return nil
+	}
+	store, err := f.newS3StateStore(uri)
+	if err != nil {
+		f.logger().WarnContext(ctx, "failed to configure the state store, skips", "error", err.Error())
+		return nil
+	}
+	store.KMSKeyID = f.env().StateKMSKey
+	store.AllowPlaintext = f.env().StateKMSMigrate
+	if store.KMSKeyID != "" && store.AllowPlaintext {
+		f.logger().WarnContext(ctx, "the state in plain text is loaded, unset FORWARD_STATE_KMS_MIGRATE after the state is encrypted")
+	}
+	f.svcstate = store
+	return store
+}
+
+// restoreState restores the pending metrics from the state store.
+// It must be called with muPending held.
+func (f *Forwarder) restoreState(ctx context.Context) {
+	if f.stateRestored {
+		return
+	}
+	f.stateRestored = true
+
+	store := f.stateStore(ctx)
+	if store == nil {
+		return
+	}
+	// take the pending metrics, so that other execution environments don't restore the same metrics.
+	// the high-water marks and the discovery cache are kept, because they can be shared.
+	var state State
+	err := updateState(ctx, store, func(s *State) bool {
+		state = State{
+			ServiceMetrics: s.ServiceMetrics,
+			HostMetrics:    s.HostMetrics,
+			HighWaterMarks: s.HighWaterMarks,
+		}
+		if len(s.ServiceMetrics) == 0 && len(s.HostMetrics) == 0 {
+			return false
+		}
+		s.ServiceMetrics = nil
+		s.HostMetrics = nil
+		return true
+	})
+	if err != nil {
+		f.logger().WarnContext(ctx, "failed to restore the state", "error", err.Error())
+		return
+	}
+	var cnt int
+	for service, metrics := range state.ServiceMetrics {
+		for _, v := range metrics {
+			f.pendingServiceMetrics.Append(service, v)
+			cnt++
+		}
+	}
+	for _, v := range state.HostMetrics {
+		f.pendingHostMetrics.Append(v)
+		cnt++
+	}
+	for l, mark := range state.HighWaterMarks {
+		if f.highWaterMarks == nil {
+			f.highWaterMarks = make(map[string]int64)
+		}
+		if mark > f.highWaterMarks[l] {
+			f.highWaterMarks[l] = mark
+		}
+	}
+	if cnt == 0 && len(state.HighWaterMarks) == 0 {
+		return
+	}
+	f.logger().InfoContext(ctx, "restore pending metrics from the state store",
+		"count", cnt,
+		"high_water_marks", len(state.HighWaterMarks),
+	)
+}
+
+// Shutdown saves the pending metrics to the state store.
+// They are merged with the state saved by other execution environments.
+// It should be called when the execution environment of AWS Lambda is being shut down.
+func (f *Forwarder) Shutdown(ctx context.Context) error {
+	store := f.stateStore(ctx)
+	if store == nil {
+		return nil
+	}
+
+	discovery := f.discoveryCacheState()
+
+	f.muPending.Lock()
+	defer f.muPending.Unlock()
+	if len(f.pendingServiceMetrics) == 0 && len(f.pendingHostMetrics) == 0 && len(f.highWaterMarks) == 0 && discovery == nil {
+		return nil
+	}
+	// merge into the state, because other execution environments may have saved their own.
+	err := updateState(ctx, store, func(state *State) bool {
+		for service, metrics := range f.pendingServiceMetrics {
+			if state.ServiceMetrics == nil {
+				state.ServiceMetrics = make(map[string][]ServiceMetricValue)
+			}
+			state.ServiceMetrics[service] = append(state.ServiceMetrics[service], metrics...)
+		}
+		state.HostMetrics = append(state.HostMetrics, f.pendingHostMetrics...)
+		for l, mark := range f.highWaterMarks {
+			if state.HighWaterMarks == nil {
+				state.HighWaterMarks = make(map[string]int64)
+			}
+			if mark > state.HighWaterMarks[l] {
+				state.HighWaterMarks[l] = mark
+			}
+		}
+		if discovery != nil {
+			state.Discovery = discovery
+		}
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("forwarder: failed to save the state: %w", err)
+	}
+	f.logger().InfoContext(ctx, "save pending metrics to the state store",
+		"service_metrics", len(f.pendingServiceMetrics),
+		"host_metrics", len(f.pendingHostMetrics),
+		"high_water_marks", len(f.highWaterMarks),
+	)
+
+	// the pending metrics belong to the state store now, they must not be saved twice.
+	f.pendingServiceMetrics = nil
+	f.pendingHostMetrics = nil
+	return nil
+}
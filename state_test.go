@@ -0,0 +1,175 @@
+package forwarder
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/google/go-cmp/cmp"
+)
+
+type fakeS3 struct {
+	objects map[string][]byte
+
+	// beforePut is called before the conditions of PutObject are checked, e.g. for simulating the conflicts.
+	beforePut func()
+}
+
+func (s *fakeS3) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	data, ok := s.objects[aws.ToString(params.Bucket)+"/"+aws.ToString(params.Key)]
+	if !ok {
+		return nil, &types.NoSuchKey{}
+	}
+	return &s3.GetObjectOutput{
+		Body: io.NopCloser(bytes.NewReader(data)),
+		ETag: aws.String(fakeETag(data)),
+	}, nil
+}
+
+func (s *fakeS3) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	data, err := io.ReadAll(params.Body)
+	if err != nil {
+		return nil, err
+	}
+	if s.beforePut != nil {
+		s.beforePut()
+	}
+	key := aws.ToString(params.Bucket) + "/" + aws.ToString(params.Key)
+	current, ok := s.objects[key]
+	if (params.IfNoneMatch != nil && ok) || (params.IfMatch != nil && (!ok || aws.ToString(params.IfMatch) != fakeETag(current))) {
+		return nil, &smithy.GenericAPIError{Code: "PreconditionFailed", Message: "At least one of the pre-conditions you specified did not hold"}
+	}
+	if s.objects == nil {
+		s.objects = make(map[string][]byte)
+	}
+	s.objects[key] = data
+	return &s3.PutObjectOutput{}, nil
+}
+
+func fakeETag(data []byte) string {
+	return fmt.Sprintf(`"%x"`, md5.Sum(data))
+}
+
+func TestNewS3StateStore(t *testing.T) {
+	store, err := NewS3StateStore(aws.Config{}, "s3://bucket/path/to/state.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store.Bucket != "bucket" || store.Key != "path/to/state.json" {
+		t.Errorf("unexpected bucket and key: %q, %q", store.Bucket, store.Key)
+	}
+
+	if _, err := NewS3StateStore(aws.Config{}, "https://example.com/state.json"); err == nil {
+		t.Error("want error, got nil")
+	}
+}
+
+func TestShutdownAndRestore(t *testing.T) {
+	store := &S3StateStore{
+		Bucket: "bucket",
+		Key:    "state.json",
+		svc:    &fakeS3{},
+	}
+
+	// the state is not found.
+	f := &Forwarder{StateStore: store}
+	f.restoreState(context.Background())
+	if len(f.pendingServiceMetrics) != 0 || len(f.pendingHostMetrics) != 0 {
+		t.Fatal("want no pending metrics")
+	}
+
+	// save the pending metrics.
+	f.pendingServiceMetrics.Append("service", ServiceMetricValue{Name: "foo", Time: 1234567860, Value: 1})
+	f.pendingHostMetrics.Append(HostMetricValue{HostID: "host-abc", Name: "bar", Time: 1234567860, Value: 2})
+	f.highWaterMarks = map[string]int64{"service=service:foo": 1234567860}
+	wantService, wantHost := f.pendingServiceMetrics, f.pendingHostMetrics
+	if err := f.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.pendingServiceMetrics) != 0 || len(f.pendingHostMetrics) != 0 {
+		t.Error("the saved metrics should be handed over to the state store")
+	}
+
+	// restore them in the new execution environment.
+	g := &Forwarder{StateStore: store}
+	g.restoreState(context.Background())
+	if diff := cmp.Diff(wantService, g.pendingServiceMetrics); diff != "" {
+		t.Errorf("service metrics mismatch: (-want/+got):\n%s", diff)
+	}
+	if diff := cmp.Diff(wantHost, g.pendingHostMetrics); diff != "" {
+		t.Errorf("host metrics mismatch: (-want/+got):\n%s", diff)
+	}
+	if diff := cmp.Diff(f.highWaterMarks, g.highWaterMarks); diff != "" {
+		t.Errorf("high-water marks mismatch: (-want/+got):\n%s", diff)
+	}
+
+	// the pending metrics are taken after restoring, and the high-water marks are kept for others.
+	state, err := store.LoadState(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(&State{HighWaterMarks: f.highWaterMarks}, state); diff != "" {
+		t.Errorf("state mismatch: (-want/+got):\n%s", diff)
+	}
+}
+
+func TestShutdown_Merge(t *testing.T) {
+	svc := &fakeS3{}
+	store := &S3StateStore{
+		Bucket: "bucket",
+		Key:    "state.json",
+		svc:    svc,
+	}
+
+	// two execution environments are shut down.
+	f := &Forwarder{StateStore: store}
+	f.pendingServiceMetrics.Append("service", ServiceMetricValue{Name: "foo", Time: 1234567860, Value: 1})
+	f.highWaterMarks = map[string]int64{"service=service:foo": 1234567860, "service=service:bar": 1234567920}
+	if err := f.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	g := &Forwarder{StateStore: store}
+	g.pendingHostMetrics.Append(HostMetricValue{HostID: "host-abc", Name: "bar", Time: 1234567860, Value: 2})
+	g.highWaterMarks = map[string]int64{"service=service:foo": 1234567920, "service=service:bar": 1234567860}
+
+	// another execution environment writes the state during the update, and it is retried.
+	svc.beforePut = func() {
+		svc.beforePut = nil
+		h := &Forwarder{StateStore: store}
+		h.pendingServiceMetrics.Append("service", ServiceMetricValue{Name: "baz", Time: 1234567860, Value: 3})
+		if err := h.Shutdown(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := g.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := store.LoadState(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &State{
+		ServiceMetrics: map[string][]ServiceMetricValue{
+			"service": {
+				{Name: "foo", Time: 1234567860, Value: 1},
+				{Name: "baz", Time: 1234567860, Value: 3},
+			},
+		},
+		HostMetrics: []HostMetricValue{
+			{HostID: "host-abc", Name: "bar", Time: 1234567860, Value: 2},
+		},
+		HighWaterMarks: map[string]int64{"service=service:foo": 1234567920, "service=service:bar": 1234567920},
+	}
+	if diff := cmp.Diff(want, state); diff != "" {
+		t.Errorf("state mismatch: (-want/+got):\n%s", diff)
+	}
+}
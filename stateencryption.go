@@ -0,0 +1,88 @@
+package forwarder
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/kms/types"
+)
+
+// encryptedState is the layout of the encrypted state.
+// The state is encrypted by AES-256-GCM with a data key,
+// and the data key is encrypted by KMS (a.k.a. envelope encryption),
+// because KMS can't encrypt the data larger than 4 KB directly.
+type encryptedState struct {
+	// DataKey is the data key encrypted by KMS.
+	DataKey []byte `json:"encryptedDataKey"`
+
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// encryptState encrypts the state by a new data key of the KMS key.
+func encryptState(ctx context.Context, svc kmsdatakeyiface, keyID string, encryptionContext map[string]string, data []byte) ([]byte, error) {
+	resp, err := svc.GenerateDataKey(ctx, &kms.GenerateDataKeyInput{
+		KeyId:             aws.String(keyID),
+		KeySpec:           types.DataKeySpecAes256,
+		EncryptionContext: encryptionContext,
+	})
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newStateCipher(resp.Plaintext)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return json.Marshal(&encryptedState{
+		DataKey:    resp.CiphertextBlob,
+		Nonce:      nonce,
+		Ciphertext: aead.Seal(nil, nonce, data, nil),
+	})
+}
+
+// decryptState decrypts the state encrypted by encryptState.
+// The state in plain text is returned as is if allowPlaintext is true, otherwise it is rejected,
+// so that the state written by someone who can't use the KMS key is never loaded.
+func decryptState(ctx context.Context, svc kmsiface, encryptionContext map[string]string, data []byte, allowPlaintext bool) ([]byte, error) {
+	var enc encryptedState
+	if err := json.Unmarshal(data, &enc); err != nil || len(enc.Ciphertext) == 0 {
+		// it is not encrypted.
+		if !allowPlaintext {
+			return nil, errors.New("forwarder: the state is not encrypted, set FORWARD_STATE_KMS_MIGRATE to load it once")
+		}
+		return data, nil
+	}
+	if svc == nil {
+		return nil, errors.New("forwarder: the state is encrypted, but the kms client is not configured")
+	}
+	resp, err := svc.Decrypt(ctx, &kms.DecryptInput{
+		CiphertextBlob:    enc.DataKey,
+		EncryptionContext: encryptionContext,
+	})
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newStateCipher(resp.Plaintext)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Open(nil, enc.Nonce, enc.Ciphertext, nil)
+}
+
+func newStateCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,154 @@
+package forwarder
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/google/go-cmp/cmp"
+)
+
+// fakeDataKeyKMS wraps the data keys by prefixing the encryption context.
+type fakeDataKeyKMS struct{}
+
+func (fakeDataKeyKMS) GenerateDataKey(ctx context.Context, params *kms.GenerateDataKeyInput, optFns ...func(*kms.Options)) (*kms.GenerateDataKeyOutput, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return &kms.GenerateDataKeyOutput{
+		KeyId:          params.KeyId,
+		Plaintext:      key,
+		CiphertextBlob: append([]byte(params.EncryptionContext["forwarder:state"]+"\x00"), key...),
+	}, nil
+}
+
+func (fakeDataKeyKMS) Decrypt(ctx context.Context, params *kms.DecryptInput, optFns ...func(*kms.Options)) (*kms.DecryptOutput, error) {
+	prefix := []byte(params.EncryptionContext["forwarder:state"] + "\x00")
+	if !bytes.HasPrefix(params.CiphertextBlob, prefix) {
+		return nil, errors.New("invalid ciphertext")
+	}
+	return &kms.DecryptOutput{
+		Plaintext: params.CiphertextBlob[len(prefix):],
+	}, nil
+}
+
+func TestEncryptState(t *testing.T) {
+	ctx := context.Background()
+	ec := map[string]string{"forwarder:state": "s3://bucket/state.json"}
+	data := []byte(`{"serviceMetrics":{"foo":[{"name":"internal.service","time":1234567860,"value":1}]}}`)
+
+	encrypted, err := encryptState(ctx, fakeDataKeyKMS{}, "alias/forwarder", ec, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(encrypted, []byte("internal.service")) {
+		t.Errorf("the state is not encrypted: %s", encrypted)
+	}
+
+	got, err := decryptState(ctx, fakeDataKeyKMS{}, ec, encrypted, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, got) {
+		t.Errorf("want %s, got %s", data, got)
+	}
+
+	// the encrypted state can't be moved to another object.
+	if _, err := decryptState(ctx, fakeDataKeyKMS{}, map[string]string{"forwarder:state": "s3://bucket/other.json"}, encrypted, false); err == nil {
+		t.Error("want an error, got nil")
+	}
+
+	// the state in plain text is returned as is only if it is allowed.
+	got, err = decryptState(ctx, nil, ec, data, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, got) {
+		t.Errorf("want %s, got %s", data, got)
+	}
+	if _, err := decryptState(ctx, nil, ec, data, false); err == nil {
+		t.Error("want an error, got nil")
+	}
+}
+
+func TestS3StateStore_Encryption(t *testing.T) {
+	ctx := context.Background()
+	svc := &fakeS3{}
+	store := &S3StateStore{
+		Bucket:   "bucket",
+		Key:      "state.json",
+		KMSKeyID: "alias/forwarder",
+		svc:      svc,
+		svckms:   fakeDataKeyKMS{},
+	}
+	state := &State{
+		ServiceMetrics: map[string][]ServiceMetricValue{
+			"foo": {{Name: "internal.service", Time: 1234567860, Value: 1}},
+		},
+	}
+	if err := store.SaveState(ctx, state); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(svc.objects["bucket/state.json"], []byte("internal.service")) {
+		t.Errorf("the state is not encrypted: %s", svc.objects["bucket/state.json"])
+	}
+
+	got, err := store.LoadState(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(state, got); diff != "" {
+		t.Errorf("state mismatch: (-want/+got):\n%s", diff)
+	}
+}
+
+func TestS3StateStore_EncryptionMigration(t *testing.T) {
+	ctx := context.Background()
+	svc := &fakeS3{}
+	plain := &S3StateStore{
+		Bucket: "bucket",
+		Key:    "state.json",
+		svc:    svc,
+	}
+	state := &State{
+		ServiceMetrics: map[string][]ServiceMetricValue{
+			"foo": {{Name: "internal.service", Time: 1234567860, Value: 1}},
+		},
+	}
+	if err := plain.SaveState(ctx, state); err != nil {
+		t.Fatal(err)
+	}
+
+	// the state in plain text is rejected once the encryption is enabled.
+	store := &S3StateStore{
+		Bucket:   "bucket",
+		Key:      "state.json",
+		KMSKeyID: "alias/forwarder",
+		svc:      svc,
+		svckms:   fakeDataKeyKMS{},
+	}
+	if _, err := store.LoadState(ctx); err == nil {
+		t.Fatal("want an error, got nil")
+	}
+
+	// it is loaded with AllowPlaintext, and encrypted on the next save.
+	store.AllowPlaintext = true
+	got, err := store.LoadState(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(state, got); diff != "" {
+		t.Errorf("state mismatch: (-want/+got):\n%s", diff)
+	}
+	if err := store.SaveState(ctx, got); err != nil {
+		t.Fatal(err)
+	}
+	store.AllowPlaintext = false
+	if _, err := store.LoadState(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,85 @@
+package forwarder
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+)
+
+const (
+	// apiData means the query uses the GetMetricData API.
+	apiData = "data"
+
+	// apiStatistics means the query uses the GetMetricStatistics API.
+	apiStatistics = "statistics"
+)
+
+// getMetricStatistics gets metrics data using the GetMetricStatistics API.
+func (fctx *forwardContext) getMetricStatistics(ctx context.Context, q *metricQuery) error {
+	svc := fctx.forwarder.cloudwatchFor(q.Namespace)
+	start, end := fctx.window(q)
+	start, end = alignWindow([]*metricQuery{q}, start, end)
+	input := &cloudwatch.GetMetricStatisticsInput{
+		Namespace:  aws.String(q.Namespace),
+		MetricName: aws.String(q.MetricName),
+		Dimensions: q.Dimensions,
+		StartTime:  aws.Time(start),
+		EndTime:    aws.Time(end),
+		Period:     aws.Int32(int32(q.period() / time.Second)),
+	}
+	standard := isStandardStatistic(q.Stat)
+	if standard {
+		input.Statistics = []types.Statistic{types.Statistic(q.Stat)}
+	} else {
+		input.ExtendedStatistics = []string{q.Stat}
+	}
+
+	resp, err := withThrottle(ctx, &fctx.throttle, func() (*cloudwatch.GetMetricStatisticsOutput, error) {
+		return svc.GetMetricStatistics(ctx, input)
+	})
+	if err != nil {
+		return fmt.Errorf("forwarder: failed to get the statistics of %s: %w", q.Label.String(), err)
+	}
+	for _, dp := range resp.Datapoints {
+		var v *float64
+		if standard {
+			v = standardStatisticValue(dp, types.Statistic(q.Stat))
+		} else if ev, ok := dp.ExtendedStatistics[q.Stat]; ok {
+			v = aws.Float64(ev)
+		}
+		if v == nil || dp.Timestamp == nil {
+			continue
+		}
+		fctx.appendValue(q.Query, q.Label, *dp.Timestamp, *v)
+	}
+	return nil
+}
+
+func isStandardStatistic(stat string) bool {
+	for _, s := range types.Statistic("").Values() {
+		if string(s) == stat {
+			return true
+		}
+	}
+	return false
+}
+
+func standardStatisticValue(dp types.Datapoint, stat types.Statistic) *float64 {
+	switch stat {
+	case types.StatisticSampleCount:
+		return dp.SampleCount
+	case types.StatisticAverage:
+		return dp.Average
+	case types.StatisticSum:
+		return dp.Sum
+	case types.StatisticMinimum:
+		return dp.Minimum
+	case types.StatisticMaximum:
+		return dp.Maximum
+	}
+	return nil
+}
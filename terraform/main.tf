@@ -0,0 +1,84 @@
+data "aws_partition" "current" {}
+data "aws_region" "current" {}
+data "aws_caller_identity" "current" {}
+
+resource "aws_iam_role" "forwarder" {
+  name = var.function_name
+
+  assume_role_policy = jsonencode({
+    Version = "2012-10-17"
+    Statement = [
+      {
+        Effect    = "Allow"
+        Principal = { Service = "lambda.amazonaws.com" }
+        Action    = "sts:AssumeRole"
+      },
+    ]
+  })
+}
+
+resource "aws_iam_role_policy_attachment" "basic" {
+  role       = aws_iam_role.forwarder.name
+  policy_arn = "arn:${data.aws_partition.current.partition}:iam::aws:policy/service-role/AWSLambdaBasicExecutionRole"
+}
+
+resource "aws_iam_role_policy_attachment" "cloudwatch" {
+  role       = aws_iam_role.forwarder.name
+  policy_arn = "arn:${data.aws_partition.current.partition}:iam::aws:policy/CloudWatchReadOnlyAccess"
+}
+
+resource "aws_iam_role_policy" "ssm" {
+  name = "ssm-parameter"
+  role = aws_iam_role.forwarder.id
+
+  policy = jsonencode({
+    Version = "2012-10-17"
+    Statement = [
+      {
+        Effect   = "Allow"
+        Action   = ["ssm:GetParameter", "ssm:GetParameters"]
+        Resource = "arn:${data.aws_partition.current.partition}:ssm:${data.aws_region.current.name}:${data.aws_caller_identity.current.account_id}:parameter/${trimprefix(var.parameter_name, "/")}"
+      },
+    ]
+  })
+}
+
+resource "aws_lambda_function" "forwarder" {
+  function_name    = var.function_name
+  role             = aws_iam_role.forwarder.arn
+  runtime          = var.runtime
+  handler          = var.handler
+  architectures    = [var.architecture]
+  filename         = var.filename
+  s3_bucket        = var.s3_bucket
+  s3_key           = var.s3_key
+  source_code_hash = var.source_code_hash
+  timeout          = 60
+
+  environment {
+    variables = merge({
+      MACKEREL_APIKEY_PARAMETER    = var.parameter_name
+      MACKEREL_APIKEY_WITH_DECRYPT = "1"
+      MACKEREL_APIURL              = var.base_url
+      FORWARD_LOG_LEVEL            = var.log_level
+    }, var.environment)
+  }
+}
+
+resource "aws_cloudwatch_event_rule" "schedule" {
+  name                = var.function_name
+  schedule_expression = var.schedule_expression
+}
+
+resource "aws_cloudwatch_event_target" "forwarder" {
+  rule  = aws_cloudwatch_event_rule.schedule.name
+  arn   = aws_lambda_function.forwarder.arn
+  input = var.forward_settings
+}
+
+resource "aws_lambda_permission" "schedule" {
+  action        = "lambda:InvokeFunction"
+  function_name = aws_lambda_function.forwarder.function_name
+  principal     = "events.amazonaws.com"
+  source_arn    = aws_cloudwatch_event_rule.schedule.arn
+}
@@ -0,0 +1,9 @@
+output "function_arn" {
+  value       = aws_lambda_function.forwarder.arn
+  description = "ARN of the Lambda function"
+}
+
+output "role_arn" {
+  value       = aws_iam_role.forwarder.arn
+  description = "ARN of the IAM role of the Lambda function"
+}
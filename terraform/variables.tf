@@ -0,0 +1,82 @@
+variable "function_name" {
+  type        = string
+  default     = "mackerel-cloudwatch-forwarder"
+  description = "name of the Lambda function"
+}
+
+variable "filename" {
+  type        = string
+  default     = null
+  description = "path of the zip file that the package command builds. either filename or s3_bucket and s3_key is required"
+}
+
+variable "s3_bucket" {
+  type        = string
+  default     = null
+  description = "S3 bucket of the zip file that the package command uploads"
+}
+
+variable "s3_key" {
+  type        = string
+  default     = null
+  description = "S3 key of the zip file that the package command uploads"
+}
+
+variable "source_code_hash" {
+  type        = string
+  default     = null
+  description = "base64-encoded SHA256 hash of the zip file that the package command prints"
+}
+
+variable "runtime" {
+  type        = string
+  default     = "provided.al2023"
+  description = "runtime of the Lambda function"
+}
+
+variable "handler" {
+  type        = string
+  default     = "bootstrap"
+  description = "handler of the Lambda function"
+}
+
+variable "architecture" {
+  type        = string
+  default     = "arm64"
+  description = "architecture of the Lambda function (x86_64, arm64)"
+}
+
+variable "parameter_name" {
+  type        = string
+  description = "name of SSM Parameter Store parameter for the Mackerel API Key"
+}
+
+variable "forward_settings" {
+  type        = string
+  default     = "[]"
+  description = "metrics settings for forwarding"
+}
+
+variable "log_level" {
+  type        = string
+  default     = "warning"
+  description = "log level (error, warn, info, debug)"
+}
+
+variable "base_url" {
+  type        = string
+  default     = "https://api.mackerelio.com/"
+  description = "base url for the Mackerel API"
+}
+
+variable "schedule_expression" {
+  type        = string
+  default     = "rate(1 minute)"
+  description = "schedule of forwarding"
+}
+
+variable "environment" {
+  type        = map(string)
+  default     = {}
+  description = "additional environment variables, e.g. FORWARD_STRICT"
+}
@@ -0,0 +1,8 @@
+{
+  sent(queue):: {
+    service: 'sqs',
+    name: 'sqs.sent.' + queue,
+    metric: ['AWS/SQS', 'NumberOfMessagesSent', 'QueueName', queue],
+    stat: 'Sum',
+  },
+}
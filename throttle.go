@@ -0,0 +1,126 @@
+package forwarder
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/aws/smithy-go"
+)
+
+// throttleErrorCodes are the error codes of AWS APIs for the throttling.
+var throttleErrorCodes = map[string]bool{
+	"Throttling":                             true,
+	"ThrottlingException":                    true,
+	"ThrottledException":                     true,
+	"RequestThrottled":                       true,
+	"RequestThrottledException":              true,
+	"RequestLimitExceeded":                   true,
+	"TooManyRequestsException":               true,
+	"ProvisionedThroughputExceededException": true,
+}
+
+// isThrottleError reports whether the error is the throttling of AWS APIs.
+func isThrottleError(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && throttleErrorCodes[apiErr.ErrorCode()]
+}
+
+const (
+	// throttleMinDelay is the initial delay of the backoff on the throttling.
+	throttleMinDelay = 200 * time.Millisecond
+
+	// throttleMaxDelay is the maximum delay of the backoff on the throttling.
+	throttleMaxDelay = 10 * time.Second
+
+	// throttleMaxAttempts is the maximum number of the attempts of a request on the throttling.
+	// The SDK retries the request before it, so the total attempts are more than it.
+	throttleMaxAttempts = 5
+)
+
+// adaptiveThrottle backs off the requests to CloudWatch on the throttling,
+// instead of failing the whole fetch.
+// Once throttled, the following requests in the invocation are paced by an interval that adapts to the throttling,
+// and the pages of GetMetricData are not prefetched.
+// The zero value is ready to use.
+type adaptiveThrottle struct {
+	mu        sync.Mutex
+	interval  time.Duration // the minimum interval between the requests
+	next      time.Time     // the time when the next request can be sent
+	throttles int           // the number of the throttling errors
+}
+
+// withThrottle calls f with the throttle t.
+// f is retried with the exponential backoff and the jitter while it fails with the throttling errors.
+func withThrottle[T any](ctx context.Context, t *adaptiveThrottle, f func() (T, error)) (T, error) {
+	delay := throttleMinDelay
+	for attempt := 1; ; attempt++ {
+		if err := t.wait(ctx); err != nil {
+			var zero T
+			return zero, err
+		}
+		ret, err := f()
+		if err == nil || !isThrottleError(err) {
+			t.succeed()
+			return ret, err
+		}
+		t.throttle()
+		if attempt >= throttleMaxAttempts {
+			return ret, err
+		}
+
+		// the full jitter.
+		wait := rand.N(delay) + 1
+		delay = min(delay*2, throttleMaxDelay)
+		if sleepContext(ctx, wait) != nil {
+			// there is no time to retry.
+			return ret, err
+		}
+	}
+}
+
+// wait waits for the interval since the last request.
+func (t *adaptiveThrottle) wait(ctx context.Context) error {
+	t.mu.Lock()
+	now := time.Now()
+	wait := t.next.Sub(now)
+	t.next = now.Add(max(wait, 0) + t.interval)
+	t.mu.Unlock()
+	if wait <= 0 {
+		return nil
+	}
+	return sleepContext(ctx, wait)
+}
+
+// throttle increases the interval multiplicatively.
+func (t *adaptiveThrottle) throttle() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.throttles++
+	t.interval = min(max(t.interval*2, throttleMinDelay), throttleMaxDelay)
+}
+
+// succeed decreases the interval, but it doesn't go back to zero once throttled.
+func (t *adaptiveThrottle) succeed() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.interval > 0 {
+		t.interval = max(t.interval*9/10, throttleMinDelay/4)
+	}
+}
+
+// throttled reports whether the requests are throttled in the invocation.
+func (t *adaptiveThrottle) throttled() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.throttles > 0
+}
+
+// count returns the number of the throttling errors.
+func (t *adaptiveThrottle) count() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.throttles
+}
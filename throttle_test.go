@@ -0,0 +1,93 @@
+package forwarder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/aws/smithy-go"
+)
+
+func TestIsThrottleError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{err: &smithy.GenericAPIError{Code: "Throttling"}, want: true},
+		{err: fmt.Errorf("wrapped: %w", &smithy.GenericAPIError{Code: "RequestLimitExceeded"}), want: true},
+		{err: &smithy.GenericAPIError{Code: "InvalidParameterValue"}, want: false},
+		{err: errors.New("Throttling"), want: false},
+		{err: nil, want: false},
+	}
+	for _, tt := range tests {
+		if got := isThrottleError(tt.err); got != tt.want {
+			t.Errorf("isThrottleError(%v): want %t, got %t", tt.err, tt.want, got)
+		}
+	}
+}
+
+func TestWithThrottle(t *testing.T) {
+	ctx := context.Background()
+	throttling := &smithy.GenericAPIError{Code: "Throttling", Message: "Rate exceeded"}
+
+	t.Run("recovered", func(t *testing.T) {
+		var throttle adaptiveThrottle
+		calls := 0
+		got, err := withThrottle(ctx, &throttle, func() (int, error) {
+			calls++
+			if calls <= 2 {
+				return 0, throttling
+			}
+			return 42, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != 42 {
+			t.Errorf("want 42, got %d", got)
+		}
+		if calls != 3 {
+			t.Errorf("want 3 calls, got %d", calls)
+		}
+		if !throttle.throttled() || throttle.count() != 2 {
+			t.Errorf("want 2 throttles, got %d", throttle.count())
+		}
+	})
+
+	t.Run("other errors are not retried", func(t *testing.T) {
+		var throttle adaptiveThrottle
+		errBroken := errors.New("broken")
+		calls := 0
+		_, err := withThrottle(ctx, &throttle, func() (int, error) {
+			calls++
+			return 0, errBroken
+		})
+		if !errors.Is(err, errBroken) {
+			t.Errorf("want %v, got %v", errBroken, err)
+		}
+		if calls != 1 {
+			t.Errorf("want 1 call, got %d", calls)
+		}
+		if throttle.throttled() {
+			t.Error("want not throttled, got throttled")
+		}
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		var throttle adaptiveThrottle
+		ctx, cancel := context.WithCancel(ctx)
+		calls := 0
+		_, err := withThrottle(ctx, &throttle, func() (int, error) {
+			calls++
+			cancel()
+			return 0, throttling
+		})
+		if !isThrottleError(err) {
+			t.Errorf("want the throttling error, got %v", err)
+		}
+		if calls != 1 {
+			t.Errorf("want 1 call, got %d", calls)
+		}
+	})
+}
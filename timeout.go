@@ -0,0 +1,87 @@
+package forwarder
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultTimeoutMargin is the default safety margin for the deadline of AWS Lambda.
+	defaultTimeoutMargin = "10%"
+
+	// defaultFetchBudget is the default budget for fetching the metrics.
+	// The rest of the time is reserved for publishing.
+	defaultFetchBudget = "70%"
+)
+
+// timeoutMargin returns the safety margin for the timeout.
+func (f *Forwarder) timeoutMargin(ctx context.Context, timeout time.Duration) time.Duration {
+	s := f.TimeoutMargin
+	if s == "" {
+		s = f.env().TimeoutMargin
+	}
+	return parseDurationBudget(ctx, f.logger(), s, defaultTimeoutMargin, timeout)
+}
+
+// fetchBudget returns the time budget for fetching the metrics.
+func (f *Forwarder) fetchBudget(ctx context.Context, timeout time.Duration) time.Duration {
+	s := f.FetchBudget
+	if s == "" {
+		s = f.env().FetchBudget
+	}
+	return parseDurationBudget(ctx, f.logger(), s, defaultFetchBudget, timeout)
+}
+
+// fetchContext returns the context for fetching the metrics.
+// It leaves the time for publishing the metrics.
+func (f *Forwarder) fetchContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, f.fetchBudget(ctx, time.Until(deadline)))
+}
+
+// parseDurationBudget parses s as a percentage of total (e.g. "10%") or a duration (e.g. "5s").
+// If s is empty or invalid, def is used.
+func parseDurationBudget(ctx context.Context, logger Logger, s, def string, total time.Duration) time.Duration {
+	if s != "" {
+		d, err := parseDurationOrPercentage(s, total)
+		if err == nil {
+			return d
+		}
+		logger.WarnContext(ctx, "invalid time budget, use the default",
+			"input", s,
+			"error", err.Error(),
+		)
+	}
+	d, err := parseDurationOrPercentage(def, total)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+func parseDurationOrPercentage(s string, total time.Duration) (time.Duration, error) {
+	if p, ok := strings.CutSuffix(s, "%"); ok {
+		v, err := strconv.ParseFloat(p, 64)
+		if err != nil {
+			return 0, err
+		}
+		if v < 0 || v > 100 {
+			return 0, fmt.Errorf("forwarder: percentage out of range: %s", s)
+		}
+		return time.Duration(float64(total) * v / 100), nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("forwarder: negative duration: %s", s)
+	}
+	return min(d, total), nil
+}
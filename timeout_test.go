@@ -0,0 +1,29 @@
+package forwarder
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestParseDurationBudget(t *testing.T) {
+	cases := []struct {
+		in   string
+		want time.Duration
+	}{
+		{"", 6 * time.Second},
+		{"25%", 15 * time.Second},
+		{"5s", 5 * time.Second},
+		{"2m", time.Minute},
+		{"150%", 6 * time.Second},
+		{"-1s", 6 * time.Second},
+		{"foo", 6 * time.Second},
+	}
+	for _, tc := range cases {
+		got := parseDurationBudget(context.Background(), slog.Default(), tc.in, "10%", time.Minute)
+		if got != tc.want {
+			t.Errorf("%q: want %s, got %s", tc.in, tc.want, got)
+		}
+	}
+}
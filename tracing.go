@@ -0,0 +1,133 @@
+package forwarder
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"go.opentelemetry.io/contrib/propagators/aws/xray"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The calls to AWS and Mackerel are traced by OpenTelemetry.
+// The spans are created by the global TracerProvider, so they are no-op until the application configures it,
+// e.g. the main package exports them to AWS X-Ray through the collector of AWS Distro for OpenTelemetry.
+// The trace context is propagated to the downstream requests by the global TextMapPropagator.
+
+// tracerName is the name of the tracer of the forwarder.
+const tracerName = "github.com/shogo82148/mackerel-cloudwatch-forwarder"
+
+// lambdaTraceIDKey is the key of the trace header that aws-lambda-go stores in the context.
+const lambdaTraceIDKey = "x-amzn-trace-id"
+
+// withLambdaTraceContext returns the context that has the trace context of the invocation of AWS Lambda.
+// AWS Lambda passes the trace header of X-Ray, and aws-lambda-go stores it in the context,
+// so the spans become the children of the invocation.
+func withLambdaTraceContext(ctx context.Context) context.Context {
+	if trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx
+	}
+	h, ok := ctx.Value(lambdaTraceIDKey).(string)
+	if !ok || h == "" {
+		return ctx
+	}
+	return xray.Propagator{}.Extract(ctx, propagation.HeaderCarrier(http.Header{"X-Amzn-Trace-Id": {h}}))
+}
+
+// startSpan starts a new client span.
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(withLambdaTraceContext(ctx), name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...),
+	)
+}
+
+// endSpan ends the span with the HTTP status code of the response, or zero if there is no response.
+func endSpan(span trace.Span, status int, err error) {
+	if status != 0 {
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+	}
+	switch {
+	case err != nil:
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	case status >= 400:
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+	span.End()
+}
+
+// tracedDo sends the HTTP request in a new span, and propagates the trace context to the request.
+func tracedDo(client aws.HTTPClient, req *http.Request, name string) (*http.Response, error) {
+	ctx, span := startSpan(req.Context(), name,
+		attribute.String("peer.service", name),
+		attribute.String("http.request.method", req.Method),
+		attribute.String("url.full", req.URL.String()),
+	)
+	if !span.IsRecording() {
+		span.End()
+		return client.Do(req)
+	}
+
+	req = req.WithContext(ctx)
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	resp, err := client.Do(req)
+	var status int
+	if resp != nil {
+		status = resp.StatusCode
+	}
+	endSpan(span, status, err)
+	return resp, err
+}
+
+// addTracingMiddleware adds the middlewares that trace the calls of AWS SDK.
+func addTracingMiddleware(stack *middleware.Stack) error {
+	err := stack.Initialize.Add(middleware.InitializeMiddlewareFunc("OTelTracing", func(
+		ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler,
+	) (middleware.InitializeOutput, middleware.Metadata, error) {
+		service, operation := awsmiddleware.GetServiceID(ctx), awsmiddleware.GetOperationName(ctx)
+		ctx, span := startSpan(ctx, service+"."+operation,
+			attribute.String("rpc.system", "aws-api"),
+			attribute.String("rpc.service", service),
+			attribute.String("rpc.method", operation),
+			attribute.String("cloud.region", awsmiddleware.GetRegion(ctx)),
+		)
+		out, metadata, err := next.HandleInitialize(ctx, in)
+		if id, ok := awsmiddleware.GetRequestIDMetadata(metadata); ok {
+			span.SetAttributes(attribute.String("aws.request_id", id))
+		}
+		var status int
+		if resp, ok := awsmiddleware.GetRawResponse(metadata).(*smithyhttp.Response); ok {
+			status = resp.StatusCode
+		}
+		endSpan(span, status, err)
+		return out, metadata, err
+	}), middleware.After)
+	if err != nil {
+		return err
+	}
+
+	// propagate the trace context to AWS.
+	return stack.Build.Add(middleware.BuildMiddlewareFunc("OTelPropagation", func(
+		ctx context.Context, in middleware.BuildInput, next middleware.BuildHandler,
+	) (middleware.BuildOutput, middleware.Metadata, error) {
+		if req, ok := in.Request.(*smithyhttp.Request); ok {
+			otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+		}
+		return next.HandleBuild(ctx, in)
+	}), middleware.After)
+}
+
+// awsConfig returns the config of AWS SDK with tracing.
+func (f *Forwarder) awsConfig() aws.Config {
+	cfg := f.Config.Copy()
+	cfg.APIOptions = append(cfg.APIOptions, addTracingMiddleware)
+	return cfg
+}
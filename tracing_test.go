@@ -0,0 +1,122 @@
+package forwarder
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/contrib/propagators/aws/xray"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// recordSpans installs the global TracerProvider that records the spans in the test.
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	tp, propagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(xray.Propagator{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(tp)
+		otel.SetTextMapPropagator(propagator)
+	})
+	return recorder
+}
+
+func TestWithLambdaTraceContext(t *testing.T) {
+	ctx := context.WithValue(context.Background(), lambdaTraceIDKey, "Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=1")
+	sc := trace.SpanContextFromContext(withLambdaTraceContext(ctx))
+	if got, want := sc.TraceID().String(), "5759e988bd862e3fe1be46a994272793"; got != want {
+		t.Errorf("unexpected trace id: want %s, got %s", want, got)
+	}
+	if got, want := sc.SpanID().String(), "53995c3f42cd8ad8"; got != want {
+		t.Errorf("unexpected span id: want %s, got %s", want, got)
+	}
+	if !sc.IsSampled() || !sc.IsRemote() {
+		t.Errorf("want the sampled remote span context, got %v", sc)
+	}
+
+	// out of AWS Lambda, e.g. the subcommands of the CLI.
+	if sc := trace.SpanContextFromContext(withLambdaTraceContext(context.Background())); sc.IsValid() {
+		t.Errorf("want no span context, got %v", sc)
+	}
+}
+
+func TestTracedDo(t *testing.T) {
+	recorder := recordSpans(t)
+
+	var header string
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		header = r.Header.Get("X-Amzn-Trace-Id")
+		rw.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer ts.Close()
+
+	ctx := context.WithValue(context.Background(), lambdaTraceIDKey, "Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=1")
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := tracedDo(ts.Client(), req, "mackerel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("want a span, got %d", len(spans))
+	}
+	span := spans[0]
+	if span.Name() != "mackerel" || span.SpanKind() != trace.SpanKindClient {
+		t.Errorf("unexpected span: %s %s", span.Name(), span.SpanKind())
+	}
+	if got, want := span.Parent().SpanID().String(), "53995c3f42cd8ad8"; got != want {
+		t.Errorf("unexpected parent: want %s, got %s", want, got)
+	}
+	if span.Status().Code != codes.Error {
+		t.Errorf("want the error status, got %v", span.Status())
+	}
+	var status attribute.Value
+	for _, kv := range span.Attributes() {
+		if kv.Key == "http.response.status_code" {
+			status = kv.Value
+		}
+	}
+	if status.AsInt64() != http.StatusTooManyRequests {
+		t.Errorf("unexpected status code: %v", status)
+	}
+
+	// the trace context is propagated to the request.
+	want := "Root=1-5759e988-bd862e3fe1be46a994272793;Parent=" + span.SpanContext().SpanID().String() + ";Sampled=1"
+	if header != want {
+		t.Errorf("unexpected trace header: want %q, got %q", want, header)
+	}
+}
+
+func TestTracedDo_NotSampled(t *testing.T) {
+	recorder := recordSpans(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	ctx := context.WithValue(context.Background(), lambdaTraceIDKey, "Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=0")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := tracedDo(ts.Client(), req, "mackerel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if spans := recorder.Ended(); len(spans) != 0 {
+		t.Errorf("want no spans, got %d", len(spans))
+	}
+}
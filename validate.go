@@ -0,0 +1,213 @@
+package forwarder
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// the limits of CloudWatch.
+// https://docs.aws.amazon.com/AmazonCloudWatch/latest/APIReference/API_GetMetricData.html
+// https://docs.aws.amazon.com/AmazonCloudWatch/latest/APIReference/API_Metric.html
+// https://docs.aws.amazon.com/AmazonCloudWatch/latest/APIReference/API_Dimension.html
+const (
+	maxMetricDataQueries = 500
+	maxDimensions        = 30
+	maxNamespaceLength   = 255
+	maxMetricNameLength  = 255
+	maxDimensionName     = 255
+	maxDimensionValue    = 1024
+	maxQueryIDLength     = 255
+	maxLabelLength       = 1024
+)
+
+var (
+	validNamespace = regexp.MustCompile(`^[0-9A-Za-z.\-_/#:]+$`)
+	validStat      = regexp.MustCompile(`^(?:SampleCount|Average|Sum|Minimum|Maximum|IQM|(?:p|tm|wm|tc|ts)(?:100|\d{1,2}(?:\.\d+)?)|(?:TM|WM|TC|TS|PR)\((?:\d+(?:\.\d+)?%?)?:(?:\d+(?:\.\d+)?%?)?\))$`)
+)
+
+// queryIDPattern matches the identifiers in the metric math expressions.
+var queryIDPattern = regexp.MustCompile(`[A-Za-z_][A-Za-z0-9_]*`)
+
+// QueryError is an error of the query, i.e. a configuration mistake.
+// The errors of fetching and posting the metrics are reported as FetchError and PublishError.
+type QueryError struct {
+	// Index is the index of the query.
+	Index int
+
+	Err error
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("query[%d]: %s", e.Index, e.Err.Error())
+}
+
+func (e *QueryError) Unwrap() error {
+	return e.Err
+}
+
+// QueryErrors is the aggregated errors of the queries.
+type QueryErrors []*QueryError
+
+func (e QueryErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return "forwarder: invalid queries: " + strings.Join(msgs, "; ")
+}
+
+// validateMetricQueries validates the queries against the limits of CloudWatch.
+// It returns the valid queries and the errors of the invalid queries.
+func validateMetricQueries(queries []*metricQuery) ([]*metricQuery, QueryErrors) {
+	var errs QueryErrors
+	valid := make([]*metricQuery, 0, len(queries))
+	var cnt int
+	for _, q := range queries {
+		if err := q.validate(); err != nil {
+			errs = append(errs, &QueryError{Index: q.Index, Err: err})
+			continue
+		}
+		if q.usesGetMetricData() {
+			cnt++
+			if cnt > maxMetricDataQueries {
+				errs = append(errs, &QueryError{
+					Index: q.Index,
+					Err:   fmt.Errorf("the number of queries for GetMetricData exceeds %d", maxMetricDataQueries),
+				})
+				continue
+			}
+		}
+		valid = append(valid, q)
+	}
+
+	valid, eerrs := validateExpressions(valid)
+	errs = append(errs, eerrs...)
+	return valid, errs
+}
+
+// validateExpressions validates that the queries referenced by the expressions are fetched together with them,
+// because GetMetricData evaluates the expressions only with the queries in the same request.
+// The queries are fetched in the groups of the delays and the billing metrics, see groupByDelay.
+func validateExpressions(queries []*metricQuery) ([]*metricQuery, QueryErrors) {
+	ids := metricDataQueryIDs(queries)
+	var errs QueryErrors
+	valid := make([]*metricQuery, 0, len(queries))
+	for _, q := range queries {
+		if q.usesGetMetricData() && q.Query.Expression != "" {
+			if err := validateExpression(q, ids); err != nil {
+				errs = append(errs, &QueryError{Index: q.Index, Err: err})
+				continue
+			}
+		}
+		valid = append(valid, q)
+	}
+	return valid, errs
+}
+
+func validateExpression(q *metricQuery, ids map[string]*metricQuery) error {
+	var billing, other string
+	for _, ref := range expressionRefs(q, ids) {
+		if ref.Delay != q.Delay {
+			return fmt.Errorf("the query %q referenced by the expression has the delay %s, but the expression has %s", ref.ID, ref.Delay, q.Delay)
+		}
+		if isBillingQuery(ref, ids) {
+			billing = ref.ID
+		} else {
+			other = ref.ID
+		}
+	}
+	if billing != "" && other != "" {
+		return fmt.Errorf("the expression references both the billing metric %q and the other metric %q, but they are fetched from different regions", billing, other)
+	}
+	return nil
+}
+
+// metricDataQueryIDs returns the queries fetched by GetMetricData keyed by their ids.
+func metricDataQueryIDs(queries []*metricQuery) map[string]*metricQuery {
+	ids := make(map[string]*metricQuery, len(queries))
+	for _, q := range queries {
+		if q.usesGetMetricData() {
+			ids[q.ID] = q
+		}
+	}
+	return ids
+}
+
+// expressionRefs returns the queries referenced by the expression of q.
+func expressionRefs(q *metricQuery, ids map[string]*metricQuery) []*metricQuery {
+	var refs []*metricQuery
+	for _, id := range queryIDPattern.FindAllString(q.Query.Expression, -1) {
+		if ref, ok := ids[id]; ok && ref != q {
+			refs = append(refs, ref)
+		}
+	}
+	return refs
+}
+
+// isBillingQuery reports whether the query is fetched with the billing metrics, see cloudwatchFor.
+// The expressions are fetched with the queries that they reference.
+func isBillingQuery(q *metricQuery, ids map[string]*metricQuery) bool {
+	return isBillingQueryVisited(q, ids, make(map[*metricQuery]bool))
+}
+
+func isBillingQueryVisited(q *metricQuery, ids map[string]*metricQuery, visited map[*metricQuery]bool) bool {
+	if q.Query.Expression == "" {
+		return q.Namespace == billingNamespace
+	}
+	if visited[q] {
+		return false
+	}
+	visited[q] = true
+	for _, ref := range expressionRefs(q, ids) {
+		if isBillingQueryVisited(ref, ids, visited) {
+			return true
+		}
+	}
+	return false
+}
+
+// usesGetMetricData reports whether the query is fetched by GetMetricData.
+func (q *metricQuery) usesGetMetricData() bool {
+	return (q.Query.Type == "" || q.Query.Type == queryTypeMetric) && q.Query.API != apiStatistics
+}
+
+// validate validates the query against the limits of CloudWatch.
+func (q *metricQuery) validate() error {
+	if q.Query.Type != "" && q.Query.Type != queryTypeMetric {
+		return nil
+	}
+	if len(q.ID) > maxQueryIDLength {
+		return fmt.Errorf("id is too long: %q", q.ID)
+	}
+	if utf8.RuneCountInString(q.Query.Label) > maxLabelLength || utf8.RuneCountInString(q.Label.String()) > maxLabelLength {
+		return fmt.Errorf("label is too long, the maximum is %d characters", maxLabelLength)
+	}
+	if q.Query.Expression != "" {
+		return nil
+	}
+
+	if q.Namespace == "" || len(q.Namespace) > maxNamespaceLength || !validNamespace.MatchString(q.Namespace) || q.Namespace[0] == ':' {
+		return fmt.Errorf("invalid namespace: %q", q.Namespace)
+	}
+	if q.MetricName == "" || utf8.RuneCountInString(q.MetricName) > maxMetricNameLength {
+		return fmt.Errorf("invalid metric name: %q", q.MetricName)
+	}
+	if len(q.Dimensions) > maxDimensions {
+		return fmt.Errorf("too many dimensions: %d, the maximum is %d", len(q.Dimensions), maxDimensions)
+	}
+	for _, d := range q.Dimensions {
+		name, value := *d.Name, *d.Value
+		if name == "" || utf8.RuneCountInString(name) > maxDimensionName {
+			return fmt.Errorf("invalid dimension name: %q", name)
+		}
+		if value == "" || utf8.RuneCountInString(value) > maxDimensionValue {
+			return fmt.Errorf("invalid dimension value of %s: %q", name, value)
+		}
+	}
+	if !validStat.MatchString(q.Stat) {
+		return fmt.Errorf("invalid stat: %q", q.Stat)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package forwarder
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestValidStat(t *testing.T) {
+	valid := []string{"Sum", "Average", "p99", "p99.9", "p100", "tm90", "IQM", "TM(10%:90%)", "PR(:300)", "TC(0.005:0.030)"}
+	for _, s := range valid {
+		if !validStat.MatchString(s) {
+			t.Errorf("%q should be valid", s)
+		}
+	}
+	invalid := []string{"", "sum", "p999", "TM(10%)", "Avg"}
+	for _, s := range invalid {
+		if validStat.MatchString(s) {
+			t.Errorf("%q should be invalid", s)
+		}
+	}
+}
+
+func TestToMetricDataQuery_Validation(t *testing.T) {
+	metric := []interface{}{"AWS/EC2", "CPUUtilization"}
+	for i := 0; i < maxDimensions+1; i++ {
+		metric = append(metric, fmt.Sprintf("Name%d", i), "value")
+	}
+	query := []*Query{
+		{Service: "foo", Name: "ok", Metric: []interface{}{"AWS/EC2", "CPUUtilization"}, Stat: "Average"},
+		{Service: "foo", Name: "stat", Metric: []interface{}{"AWS/EC2", "CPUUtilization"}, Stat: "average"},
+		{Service: "foo", Name: "namespace", Metric: []interface{}{"AWS EC2", "CPUUtilization"}, Stat: "Average"},
+		{Service: "foo", Name: "dimensions", Metric: metric, Stat: "Average"},
+		{Service: "foo", Name: strings.Repeat("a", maxLabelLength), Metric: []interface{}{"AWS/EC2", "CPUUtilization"}, Stat: "Average"},
+	}
+	_, _, err := ToMetricDataQuery(query)
+	var errs QueryErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("want QueryErrors, got %v", err)
+	}
+	var indexes []int
+	for _, e := range errs {
+		indexes = append(indexes, e.Index)
+	}
+	if fmt.Sprint(indexes) != "[1 2 3 4]" {
+		t.Errorf("unexpected indexes: %v, %v", indexes, err)
+	}
+}
+
+func TestPrepareQueries_Expressions(t *testing.T) {
+	hidden := false
+	query := []*Query{
+		{ID: "charges", Metric: []interface{}{"AWS/Billing", "EstimatedCharges", "Currency", "USD"}, Stat: "Maximum", Delay: "6h", ReturnData: &hidden},
+		{ID: "cpu", Metric: []interface{}{"AWS/EC2", "CPUUtilization"}, Stat: "Average", Delay: "6h", ReturnData: &hidden},
+		{Service: "foo", Name: "billing.with_tax", Expression: "charges * 1.1", Delay: "6h"},
+		{Service: "foo", Name: "billing.delay", Expression: "charges * 2"},
+		{Service: "foo", Name: "billing.mixed", Expression: "charges + cpu", Delay: "6h"},
+	}
+	valid, errs := prepareQueries(query)
+	var indexes []int
+	for _, e := range errs {
+		indexes = append(indexes, e.Index)
+	}
+	if fmt.Sprint(indexes) != "[3 4]" {
+		t.Errorf("unexpected indexes: %v, %v", indexes, errs)
+	}
+
+	// the expression is fetched with the billing metric that it references.
+	groups := groupByDelay(valid)
+	var ids [][]string
+	for _, group := range groups {
+		var g []string
+		for _, q := range group {
+			g = append(g, q.ID)
+		}
+		ids = append(ids, g)
+	}
+	if fmt.Sprint(ids) != "[[charges m3] [cpu]]" {
+		t.Errorf("unexpected groups: %v", ids)
+	}
+}
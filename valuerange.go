@@ -0,0 +1,56 @@
+package forwarder
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// the actions for the values out of the range.
+const (
+	// outOfRangeDrop drops the values out of the range.
+	outOfRangeDrop = "drop"
+
+	// outOfRangeClamp clamps the values to the range.
+	outOfRangeClamp = "clamp"
+)
+
+// validateRange validates the value range of the query.
+func (q *Query) validateRange() error {
+	var err error
+	switch q.OutOfRange {
+	case "", outOfRangeDrop, outOfRangeClamp:
+	default:
+		err = fmt.Errorf("unknown outOfRange: %q", q.OutOfRange)
+	}
+	if q.Min != nil && q.Max != nil && *q.Min > *q.Max {
+		err = errors.Join(err, fmt.Errorf("min %g is greater than max %g", *q.Min, *q.Max))
+	}
+	return err
+}
+
+// filterValue applies the value range of the query to the value.
+// It reports false if the value should be dropped.
+// NaN and infinite values are always dropped, because they can't be encoded in JSON.
+func (q *Query) filterValue(v float64) (float64, bool) {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return v, false
+	}
+	if q == nil {
+		return v, true
+	}
+	clamp := q.OutOfRange == outOfRangeClamp
+	if q.Min != nil && v < *q.Min {
+		if !clamp {
+			return v, false
+		}
+		v = *q.Min
+	}
+	if q.Max != nil && v > *q.Max {
+		if !clamp {
+			return v, false
+		}
+		v = *q.Max
+	}
+	return v, true
+}
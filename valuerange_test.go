@@ -0,0 +1,97 @@
+package forwarder
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFilterValue(t *testing.T) {
+	lower, upper := 0.0, 100.0
+	tests := []struct {
+		name   string
+		query  *Query
+		input  float64
+		want   float64
+		wantOK bool
+	}{
+		{"nil query", nil, 42, 42, true},
+		{"NaN", &Query{}, math.NaN(), 0, false},
+		{"+Inf", &Query{}, math.Inf(1), 0, false},
+		{"-Inf", &Query{OutOfRange: outOfRangeClamp, Min: &lower}, math.Inf(-1), 0, false},
+		{"in range", &Query{Min: &lower, Max: &upper}, 42, 42, true},
+		{"drop below min", &Query{Min: &lower}, -1, 0, false},
+		{"drop above max", &Query{Max: &upper}, 101, 0, false},
+		{"clamp below min", &Query{Min: &lower, OutOfRange: outOfRangeClamp}, -1, 0, true},
+		{"clamp above max", &Query{Max: &upper, OutOfRange: outOfRangeClamp}, 101, 100, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.query.filterValue(tt.input)
+			if ok != tt.wantOK {
+				t.Fatalf("unexpected ok: want %t, got %t", tt.wantOK, ok)
+			}
+			if ok && got != tt.want {
+				t.Errorf("unexpected value: want %g, got %g", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestValidateRange(t *testing.T) {
+	lower, upper := 100.0, 0.0
+	if err := (&Query{Min: &lower, Max: &upper}).validateRange(); err == nil {
+		t.Error("want error for min > max, got nil")
+	}
+	if err := (&Query{OutOfRange: "ignore"}).validateRange(); err == nil {
+		t.Error("want error for unknown outOfRange, got nil")
+	}
+	if err := (&Query{Min: &upper, Max: &lower, OutOfRange: outOfRangeClamp}).validateRange(); err != nil {
+		t.Errorf("want nil, got %v", err)
+	}
+}
+
+func TestGetMetricsData_ValueRange(t *testing.T) {
+	upper := 100.0
+	start := time.Unix(1234567860, 0)
+	report := &InvocationReport{}
+	fctx := &forwardContext{
+		forwarder: &Forwarder{
+			svccloudwatch: &fakeCloudWatch{
+				values: map[string][]float64{
+					"m1": {1, math.NaN(), 150},
+				},
+			},
+		},
+		start:  start,
+		end:    start.Add(3 * time.Minute),
+		report: report,
+	}
+	query := []*Query{
+		{
+			Service: "foo",
+			Name:    "ec2.cpu",
+			Metric:  []interface{}{"AWS/EC2", "CPUUtilization", "InstanceId", "i-1"},
+			Stat:    "Average",
+			Max:     &upper,
+		},
+	}
+	if err := fctx.getMetricsData(context.Background(), query); err != nil {
+		t.Fatal(err)
+	}
+
+	want := serviceMetricsType{
+		"foo": {
+			{Name: "ec2.cpu", Time: start.Unix(), Value: 1},
+		},
+	}
+	if diff := cmp.Diff(want, fctx.serviceMetrics); diff != "" {
+		t.Errorf("metrics mismatch: (-want/+got):\n%s", diff)
+	}
+	if report.Fetched != 3 || report.Dropped != 2 {
+		t.Errorf("unexpected report: fetched %d, dropped %d", report.Fetched, report.Dropped)
+	}
+}
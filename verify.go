@@ -0,0 +1,95 @@
+package forwarder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+var (
+	// ErrInvalidAPIKey means Mackerel rejects the API key, e.g. it is wrong or revoked.
+	ErrInvalidAPIKey = errors.New("forwarder: the api key of mackerel is invalid")
+
+	// ErrAPIKeyPermissionDenied means the API key is valid, but it is not allowed to access the API,
+	// e.g. the organization restricts the source IP addresses.
+	ErrAPIKeyPermissionDenied = errors.New("forwarder: the api key of mackerel is not permitted")
+
+	// ErrMackerelUnreachable means the forwarder can't connect to Mackerel,
+	// or Mackerel doesn't respond as expected, e.g. it is under maintenance or APIURL is wrong.
+	ErrMackerelUnreachable = errors.New("forwarder: mackerel is unreachable")
+)
+
+// APIKeyError is an error of verifying the API key of Mackerel.
+// Kind is one of ErrInvalidAPIKey, ErrAPIKeyPermissionDenied, and ErrMackerelUnreachable,
+// so errors.Is(err, ErrInvalidAPIKey) reports whether the API key is invalid.
+type APIKeyError struct {
+	Kind error
+	Err  error
+}
+
+func (e *APIKeyError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Kind, e.Err)
+}
+
+func (e *APIKeyError) Unwrap() []error {
+	return []error{e.Kind, e.Err}
+}
+
+// reason returns the short name of the kind for the logs.
+func (e *APIKeyError) reason() string {
+	switch e.Kind {
+	case ErrInvalidAPIKey:
+		return "invalid_api_key"
+	case ErrAPIKeyPermissionDenied:
+		return "permission_denied"
+	case ErrMackerelUnreachable:
+		return "unreachable"
+	}
+	return "unknown"
+}
+
+// classifyAPIKeyError classifies the error of the organization API.
+func classifyAPIKeyError(err error) *APIKeyError {
+	kind := ErrMackerelUnreachable
+	var merr Error
+	if errors.As(err, &merr) {
+		switch merr.StatusCode {
+		case http.StatusUnauthorized:
+			kind = ErrInvalidAPIKey
+		case http.StatusForbidden:
+			kind = ErrAPIKeyPermissionDenied
+		}
+	}
+	return &APIKeyError{
+		Kind: kind,
+		Err:  err,
+	}
+}
+
+func (f *Forwarder) skipAPIKeyVerification() bool {
+	if f.SkipAPIKeyVerification {
+		return true
+	}
+	return f.env().SkipAPIKeyVerification
+}
+
+// verifyAPIKey verifies the API key of the client by the organization API.
+func (f *Forwarder) verifyAPIKey(ctx context.Context, client *MackerelClient) error {
+	org, err := client.getOrg(ctx)
+	if err != nil {
+		kerr := classifyAPIKeyError(err)
+		args := []any{
+			"reason", kerr.reason(),
+			"error", err.Error(),
+		}
+		var merr Error
+		if errors.As(err, &merr) {
+			args = append(args, "status", merr.StatusCode)
+		}
+		f.logger().ErrorContext(ctx, "failed to verify the api key of mackerel", args...)
+		return kerr
+	}
+	f.logger().InfoContext(ctx, "the api key of mackerel is verified", "org", org.Name)
+	return nil
+}
@@ -0,0 +1,76 @@
+package forwarder
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClassifyAPIKeyError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want error
+	}{
+		{Error{StatusCode: http.StatusUnauthorized}, ErrInvalidAPIKey},
+		{Error{StatusCode: http.StatusForbidden}, ErrAPIKeyPermissionDenied},
+		{Error{StatusCode: http.StatusServiceUnavailable}, ErrMackerelUnreachable},
+		{Error{StatusCode: http.StatusNotFound}, ErrMackerelUnreachable},
+		{errors.New("dial tcp: connection refused"), ErrMackerelUnreachable},
+	}
+	for _, tt := range tests {
+		err := classifyAPIKeyError(tt.err)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("classifyAPIKeyError(%v) = %v, want %v", tt.err, err, tt.want)
+		}
+		if !errors.Is(err, tt.err) {
+			t.Errorf("classifyAPIKeyError(%v) doesn't wrap the original error", tt.err)
+		}
+	}
+}
+
+func TestMackerel_VerifyAPIKey(t *testing.T) {
+	var status int
+	var calls int
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		calls++
+		if want, got := "/api/v0/org", r.URL.Path; want != got {
+			t.Errorf("unexpected path: want %q, got %q", want, got)
+		}
+		rw.WriteHeader(status)
+		rw.Write([]byte(`{"name":"example-org"}`))
+	}))
+	defer ts.Close()
+
+	f := &Forwarder{
+		APIURL: ts.URL,
+		APIKey: "api-token",
+	}
+
+	// the api key is rejected.
+	status = http.StatusUnauthorized
+	if _, err := f.mackerel(context.Background()); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("want ErrInvalidAPIKey, got %v", err)
+	}
+
+	// mackerel is unavailable, the client is used without verification.
+	status = http.StatusServiceUnavailable
+	if _, err := f.mackerel(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// the api key is verified again.
+	status = http.StatusOK
+	if _, err := f.mackerel(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// the verified client is cached.
+	if _, err := f.mackerel(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 3 {
+		t.Errorf("unexpected number of the verification: want 3, got %d", calls)
+	}
+}
@@ -0,0 +1,99 @@
+package forwarder
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+)
+
+func (f *Forwarder) verifyMetrics() bool {
+	if f.VerifyMetrics {
+		return true
+	}
+	return f.env().VerifyMetrics
+}
+
+// verifyMetricsExist verifies that the metrics of the queries exist on CloudWatch by ListMetrics,
+// because the queries of the nonexistent metrics always return empty data silently,
+// e.g. by the typos of the dimension names.
+// Each metric is verified once in the execution environment.
+// It returns the errors of the queries whose metrics don't exist.
+func (fctx *forwardContext) verifyMetricsExist(ctx context.Context, queries []*metricQuery) QueryErrors {
+	f := fctx.forwarder
+	var errs QueryErrors
+	for _, q := range queries {
+		if q.Namespace == "" || q.Query.Expression != "" {
+			continue
+		}
+		switch q.Query.Type {
+		case "", queryTypeMetric:
+		default:
+			continue
+		}
+		svc, ok := f.cloudwatchFor(q.Namespace).(cloudwatchlistiface)
+		if !ok {
+			continue
+		}
+
+		key := q.Namespace + ":" + q.MetricName + ":" + dimensionsKey(q.Dimensions)
+		f.muVerifiedMetrics.Lock()
+		exists, verified := f.verifiedMetrics[key]
+		f.muVerifiedMetrics.Unlock()
+		if !verified {
+			var err error
+			exists, err = fctx.metricExists(ctx, svc, q)
+			if err != nil {
+				// try again in the next invocation.
+				f.logger().WarnContext(withQueryIndex(ctx, q.Index), "failed to verify the metric, skips the verification",
+					"label", q.Label.String(),
+					"error", err.Error(),
+				)
+				continue
+			}
+			f.muVerifiedMetrics.Lock()
+			if f.verifiedMetrics == nil {
+				f.verifiedMetrics = make(map[string]bool)
+			}
+			f.verifiedMetrics[key] = exists
+			f.muVerifiedMetrics.Unlock()
+		}
+		if !exists {
+			errs = append(errs, &QueryError{
+				Index: q.Index,
+				Err:   fmt.Errorf("the metric %s/%s with the dimensions {%s} is not found on CloudWatch, the query always returns empty data", q.Namespace, q.MetricName, dimensionsKey(q.Dimensions)),
+			})
+		}
+	}
+	return errs
+}
+
+// metricExists reports whether the metric of the query exists on CloudWatch.
+// The metrics with extra dimensions are different metrics, so they don't count.
+func (fctx *forwardContext) metricExists(ctx context.Context, svc cloudwatchlistiface, q *metricQuery) (bool, error) {
+	filters := make([]types.DimensionFilter, 0, len(q.Dimensions))
+	for _, d := range q.Dimensions {
+		filters = append(filters, types.DimensionFilter{Name: d.Name, Value: d.Value})
+	}
+	paginator := cloudwatch.NewListMetricsPaginator(svc, &cloudwatch.ListMetricsInput{
+		Namespace:  aws.String(q.Namespace),
+		MetricName: aws.String(q.MetricName),
+		Dimensions: filters,
+	})
+	for paginator.HasMorePages() {
+		page, err := withThrottle(ctx, &fctx.throttle, func() (*cloudwatch.ListMetricsOutput, error) {
+			return paginator.NextPage(ctx)
+		})
+		if err != nil {
+			return false, fmt.Errorf("forwarder: failed to list the metrics: %w", err)
+		}
+		for _, m := range page.Metrics {
+			if len(m.Dimensions) == len(q.Dimensions) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
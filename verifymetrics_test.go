@@ -0,0 +1,76 @@
+package forwarder
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestGetMetricsData_VerifyMetrics(t *testing.T) {
+	start := time.Unix(1234567860, 0)
+	svc := &countingListCloudWatch{
+		listingCloudWatch: listingCloudWatch{
+			fakeCloudWatch: fakeCloudWatch{
+				values: map[string][]float64{"m1": {1}},
+			},
+			metrics: []types.Metric{
+				kinesisShardMetric("stream", ""),
+				kinesisShardMetric("typo", "shardId-000000000000"),
+			},
+		},
+	}
+	f := &Forwarder{
+		svccloudwatch: svc,
+		VerifyMetrics: true,
+		EnvConfig:     &Config{},
+	}
+	query := []*Query{
+		{Service: "foo", Name: "found", Metric: []interface{}{"AWS/Kinesis", "IncomingRecords", "StreamName", "stream"}, Stat: "Sum"},
+		{Service: "foo", Name: "not.found", Metric: []interface{}{"AWS/Kinesis", "IncomingRecords", "StreamName", "unknown"}, Stat: "Sum"},
+		// the metric with extra dimensions is a different metric.
+		{Service: "foo", Name: "extra", Metric: []interface{}{"AWS/Kinesis", "IncomingRecords", "StreamName", "typo"}, Stat: "Sum"},
+		{Service: "foo", Name: "expression", Expression: "m1 * 2"},
+	}
+	newContext := func() *forwardContext {
+		return &forwardContext{
+			forwarder: f,
+			start:     start,
+			end:       start.Add(time.Minute),
+		}
+	}
+
+	// the queries are warned, but they are fetched anyway.
+	fctx := newContext()
+	if err := fctx.getMetricsData(context.Background(), query); err != nil {
+		t.Fatal(err)
+	}
+	if len(fctx.serviceMetrics["foo"]) == 0 {
+		t.Error("want the metrics, got none")
+	}
+	if svc.calls != 3 {
+		t.Errorf("want 3 calls, got %d", svc.calls)
+	}
+
+	// the metrics are verified once, and the strict mode fails.
+	f.Strict = true
+	fctx = newContext()
+	err := fctx.getMetricsData(context.Background(), query)
+	var errs QueryErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("want QueryErrors, got %v", err)
+	}
+	var indexes []int
+	for _, err := range errs {
+		indexes = append(indexes, err.Index)
+	}
+	if diff := cmp.Diff([]int{1, 2}, indexes); diff != "" {
+		t.Errorf("indexes mismatch: (-want/+got):\n%s", diff)
+	}
+	if svc.calls != 3 {
+		t.Errorf("want 3 calls, got %d", svc.calls)
+	}
+}